@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: GPL-2.0
+//
+// fastpath.c is the XDP fast path the daemons attach to the interfaces of
+// router containers of VirtualRouters with spec.fastPath. It translates the
+// 1:1 NAT rules the daemon puts in vr_fp_nat and forwards the packets it
+// translated straight to the next hop. Every other packet is passed on to
+// the kernel, whose packet filter translates it as before.
+//
+//   clang -O2 -g -target bpf -c fastpath.c -o fastpath.o
+
+#include <linux/bpf.h>
+#include <linux/if_ether.h>
+#include <linux/in.h>
+#include <linux/ip.h>
+#include <linux/tcp.h>
+#include <linux/udp.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+#define AF_INET 2
+
+// which address of a packet a translation matches
+#define FP_SOURCE 0
+#define FP_DESTINATION 1
+
+struct fp_key {
+	__u8 address_of;
+	__u8 pad[3];
+	__be32 address;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, 4096);
+	__type(key, struct fp_key);
+	__type(value, __be32);
+} vr_fp_nat SEC(".maps");
+
+// packets forwarded on the fast path
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, 1);
+	__type(key, __u32);
+	__type(value, __u64);
+} vr_fp_stats SEC(".maps");
+
+static __always_inline void csum_replace4(__sum16 *sum, __be32 from, __be32 to)
+{
+	__u32 csum = ~((__u32)*sum) & 0xffff;
+
+	csum += (~from & 0xffff) + (~from >> 16);
+	csum += (to & 0xffff) + (to >> 16);
+	csum = (csum & 0xffff) + (csum >> 16);
+	csum = (csum & 0xffff) + (csum >> 16);
+	*sum = ~csum;
+}
+
+static __always_inline void ip_decrease_ttl(struct iphdr *iph)
+{
+	__u32 check = (__u32)iph->check;
+
+	check += (__u32)bpf_htons(0x0100);
+	iph->check = (__sum16)(check + (check >= 0xFFFF));
+	iph->ttl--;
+}
+
+static __always_inline __be32 translate(__u8 address_of, __be32 address)
+{
+	struct fp_key key = {};
+	__be32 *translated;
+
+	key.address_of = address_of;
+	key.address = address;
+	translated = bpf_map_lookup_elem(&vr_fp_nat, &key);
+
+	return translated ? *translated : 0;
+}
+
+SEC("xdp")
+int vr_fastpath(struct xdp_md *ctx)
+{
+	void *data_end = (void *)(long)ctx->data_end;
+	void *data = (void *)(long)ctx->data;
+	struct bpf_fib_lookup fib = {};
+	struct ethhdr *eth = data;
+	__sum16 *l4_check = NULL;
+	struct iphdr *iph;
+	__be32 saddr, daddr;
+	__u32 zero = 0;
+	__u64 *packets;
+	void *l4;
+
+	if ((void *)(eth + 1) > data_end || eth->h_proto != bpf_htons(ETH_P_IP))
+		return XDP_PASS;
+	iph = (void *)(eth + 1);
+	if ((void *)(iph + 1) > data_end || iph->ihl < 5 || iph->ttl <= 1)
+		return XDP_PASS;
+	// fragments are reassembled by the kernel, which has their ports
+	if (iph->frag_off & bpf_htons(0x3FFF))
+		return XDP_PASS;
+
+	saddr = translate(FP_SOURCE, iph->saddr);
+	daddr = translate(FP_DESTINATION, iph->daddr);
+	if (!saddr && !daddr)
+		return XDP_PASS;
+	if (!saddr)
+		saddr = iph->saddr;
+	if (!daddr)
+		daddr = iph->daddr;
+
+	l4 = (void *)iph + iph->ihl * 4;
+	if (iph->protocol == IPPROTO_TCP) {
+		struct tcphdr *tcph = l4;
+
+		if ((void *)(tcph + 1) > data_end)
+			return XDP_PASS;
+		l4_check = &tcph->check;
+	} else if (iph->protocol == IPPROTO_UDP) {
+		struct udphdr *udph = l4;
+
+		if ((void *)(udph + 1) > data_end)
+			return XDP_PASS;
+		// no checksum to update
+		if (udph->check)
+			l4_check = &udph->check;
+	}
+
+	fib.family = AF_INET;
+	fib.tos = iph->tos;
+	fib.l4_protocol = iph->protocol;
+	fib.tot_len = bpf_ntohs(iph->tot_len);
+	fib.ipv4_src = saddr;
+	fib.ipv4_dst = daddr;
+	fib.ifindex = ctx->ingress_ifindex;
+	// the kernel forwards what isn't routed out of the router by a neighbor
+	// it knows
+	if (bpf_fib_lookup(ctx, &fib, sizeof(fib), 0) != BPF_FIB_LKUP_RET_SUCCESS)
+		return XDP_PASS;
+
+	if (l4_check) {
+		csum_replace4(l4_check, iph->saddr, saddr);
+		csum_replace4(l4_check, iph->daddr, daddr);
+	}
+	csum_replace4(&iph->check, iph->saddr, saddr);
+	csum_replace4(&iph->check, iph->daddr, daddr);
+	iph->saddr = saddr;
+	iph->daddr = daddr;
+	ip_decrease_ttl(iph);
+	__builtin_memcpy(eth->h_dest, fib.dmac, ETH_ALEN);
+	__builtin_memcpy(eth->h_source, fib.smac, ETH_ALEN);
+
+	packets = bpf_map_lookup_elem(&vr_fp_stats, &zero);
+	if (packets)
+		*packets += 1;
+	return bpf_redirect(fib.ifindex, 0);
+}
+
+char _license[] SEC("license") = "GPL";
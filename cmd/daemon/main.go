@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"flag"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -17,6 +23,8 @@ import (
 	daemon "github.com/tmax-cloud/virtualrouter-controller/internal/daemon"
 	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
 	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
 	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
 	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
 	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/signals"
@@ -24,8 +32,31 @@ import (
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL    string
+	kubeconfig   string
+	otlpEndpoint string
+
+	dryRun              bool
+	packetFilterBackend string
+
+	firewallCounterInterval    time.Duration
+	dnsHealthInterval          time.Duration
+	snatPoolMetricsInterval    time.Duration
+	wireGuardHandshakeInterval time.Duration
+	dataPlaneCheckInterval     time.Duration
+	reconcileInterval          time.Duration
+	trafficMetricsInterval     time.Duration
+	flowExportInterval         time.Duration
+	metricsBindAddress         string
+	debugBindAddress           string
+	auditRecords               int
+	captureDir                 string
+	xdpProgram                 string
+
+	requiredKernelModules     string
+	requiredSysctls           string
+	nodeQualificationInterval time.Duration
+	allowedNodeSysctls        string
 )
 
 func main() {
@@ -52,10 +83,21 @@ func main() {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
+	shutdownTracing, err := tracing.Setup(otlpEndpoint, "virtualrouter-daemon")
+	if err != nil {
+		klog.Fatalf("Error setting up tracing: %s", err.Error())
+	}
+	defer shutdownTracing()
+
 	exampleClient, err := clientset.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("Error building example clientset: %s", err.Error())
 	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building dynamic client: %s", err.Error())
+	}
 	// labelSelector := v1.LabelSelector{MatchLabels: map[string]string{"app": virtualroutermanager.VIRTUALROUTER_LABEL}}
 	labelSelector := labels.Set(map[string]string{"app": virtualroutermanager.VIRTUALROUTER_LABEL}).AsSelector()
 	// kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
@@ -77,6 +119,15 @@ func main() {
 		klog.Error("Empty annotation in Node resource. Please check whether externalInterface and internalInterface annotation on the Node")
 	}
 
+	var backend internalNetlink.Feature
+	switch packetFilterBackend {
+	case "auto":
+	case string(internalNetlink.FeatureNftables), string(internalNetlink.FeatureIptables):
+		backend = internalNetlink.Feature(packetFilterBackend)
+	default:
+		klog.Fatalf("Error unknown packet filter backend %q, expected nftables, iptables or auto", packetFilterBackend)
+	}
+
 	d := daemon.NewDaemon(&internalCrio.CrioConfig{
 		RuntimeEndpoint:      "unix:///var/run/crio/crio.sock",
 		RuntimeEndpointIsSet: true,
@@ -94,16 +145,79 @@ func main() {
 		NewExternalInterfaceName:    "extif",
 		InternalBridgeName:          "intbr",
 		ExternalBridgeName:          "extbr",
-	})
+	}, backend)
+	var allowed []string
+	for _, sysctl := range strings.Split(allowedNodeSysctls, ",") {
+		if sysctl = strings.TrimSpace(sysctl); sysctl != "" {
+			allowed = append(allowed, sysctl)
+		}
+	}
+	d.SetAllowedNodeSysctls(allowed)
 
-	err = d.Start(stopSignalCh, stopCh)
-	if err != nil {
+	if dryRun {
+		// the host bridges are left as they are
+		go func() {
+			<-stopSignalCh
+			close(stopCh)
+		}()
+	} else if err = d.Start(stopSignalCh, stopCh); err != nil {
 		klog.Errorf("Error running network daemon: %s", err.Error())
 	}
 
-	controller := daemon.NewController(kubeClient, exampleClient, d,
+	if features := d.Features(); features != nil {
+		if err := daemon.LabelNodeFeatures(kubeClient, *nodeName, features); err != nil {
+			klog.Errorf("Error labeling node features: %s", err.Error())
+		}
+	}
+
+	sysctls, err := daemon.ParseSysctls(requiredSysctls)
+	if err != nil {
+		klog.Fatalf("Error parsing --required-sysctls: %s", err.Error())
+	}
+	requirements := daemon.NodeRequirements{
+		Sysctls:    sysctls,
+		Interfaces: []string{internalInterfaceName, externalInterfaceName},
+	}
+	for _, module := range strings.Split(requiredKernelModules, ",") {
+		if module = strings.TrimSpace(module); module != "" {
+			requirements.KernelModules = append(requirements.KernelModules, module)
+		}
+	}
+	qualifyNode := func() {
+		if err := daemon.LabelNodeQualification(kubeClient, *nodeName, daemon.QualifyNode(requirements, d.Features())); err != nil {
+			klog.Errorf("Error labeling node qualification: %s", err.Error())
+		}
+	}
+	if nodeQualificationInterval > 0 {
+		go wait.Until(qualifyNode, nodeQualificationInterval, stopCh)
+	} else {
+		qualifyNode()
+	}
+
+	if metricsBindAddress != "" {
+		daemon.RegisterMetrics(prometheus.DefaultRegisterer)
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+			http.Handle("/healthz/dataplane", d.DataPlaneHealthHandler())
+			if err := http.ListenAndServe(metricsBindAddress, nil); err != nil {
+				klog.Fatalf("Error serving metrics: %s", err.Error())
+			}
+		}()
+	}
+
+	controller := daemon.NewController(kubeClient, exampleClient, dynamicClient, d,
 		kubeInformerFactory.Core().V1().Pods(),
-		exampleInformerFactory.Tmax().V1().VirtualRouters())
+		exampleInformerFactory.Tmax().V1().VirtualRouters(),
+		dryRun, firewallCounterInterval, dnsHealthInterval, snatPoolMetricsInterval, wireGuardHandshakeInterval, dataPlaneCheckInterval,
+		reconcileInterval, trafficMetricsInterval, flowExportInterval, auditRecords, captureDir, xdpProgram)
+
+	if debugBindAddress != "" {
+		go func() {
+			if err := http.ListenAndServe(debugBindAddress, controller.DebugHandler()); err != nil {
+				klog.Fatalf("Error serving debug endpoints: %s", err.Error())
+			}
+		}()
+	}
 
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
@@ -117,6 +231,27 @@ func main() {
 }
 
 func init() {
+	features.AddFlag(flag.CommandLine)
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP gRPC endpoint, such as otel-collector:4317, data plane apply spans are exported to. Tracing is on only when given.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only log and record in events the netlink operations every VirtualRouter would need, without performing them.")
+	flag.StringVar(&packetFilterBackend, "packet-filter-backend", "auto", "Packet filter the rules of the routers are programmed with: nftables, iptables, or auto to prefer nftables when the node supports it.")
+	flag.DurationVar(&firewallCounterInterval, "firewall-counter-interval", time.Minute, "How often the packet and byte counters of the firewall rules of the router pods are exported to the controller. 0 disables it.")
+	flag.DurationVar(&dnsHealthInterval, "dns-health-interval", 30*time.Second, "How often the DNS forwarders of the router pods are probed, reporting their health to the controller. 0 disables it.")
+	flag.DurationVar(&snatPoolMetricsInterval, "snat-pool-metrics-interval", 30*time.Second, "How often the SNAT pool metrics of the router pods are updated from their connection tracking tables. 0 disables it.")
+	flag.DurationVar(&dataPlaneCheckInterval, "data-plane-check-interval", 30*time.Second, "How often the data plane of the router pods is checked, reporting its health to the controller and at /healthz/dataplane. 0 disables it.")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", time.Minute, "How often the bridges and veths of the node and the interfaces of the router pods are compared with those set up, and converged. 0 disables it.")
+	flag.DurationVar(&trafficMetricsInterval, "traffic-metrics-interval", 15*time.Second, "How often the packet rates and tracked connections of the router pods, which VirtualRouters with spec.autoscaling scale on, are updated. 0 disables it.")
+	flag.DurationVar(&flowExportInterval, "flow-export-interval", time.Minute, "How often the traffic of the connections of the router pods with spec.flowExport is exported to their flow collectors. 0 disables it.")
+	flag.DurationVar(&wireGuardHandshakeInterval, "wireguard-handshake-interval", 30*time.Second, "How often the latest WireGuard handshakes of the router pods are reported to the controller. 0 disables it.")
+	flag.IntVar(&auditRecords, "audit-configmap-records", 0, "How many of the latest data plane changes of every router are kept in its <VirtualRouter>-virtualrouter-audit ConfigMap, besides the log. 0 keeps them in the log only.")
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8090", "Address on the host network the Prometheus metrics are served on at /metrics, none if empty.")
+	flag.StringVar(&captureDir, "capture-dir", "", "Directory the packet captures asked with the network.tmaxanc.com/capture annotation of VirtualRouters are stored in, such as a mounted PersistentVolumeClaim. Captures are not taken if empty.")
+	flag.StringVar(&xdpProgram, "xdp-program", "", "XDP object of the fast path, built from build/daemon/xdp/fastpath.c, that routers with spec.fastPath have their 1:1 NAT rules translated by. bpftool is needed as well. Routers fall back to the packet filter if empty.")
+	flag.StringVar(&requiredKernelModules, "required-kernel-modules", "br_netfilter,ip_vs,wireguard", "Comma separated kernel modules the node must have loaded to be labeled network.tmaxanc.com/router-ready=true.")
+	flag.StringVar(&requiredSysctls, "required-sysctls", "net.ipv4.ip_forward=1", "Comma separated name=value sysctls the node must have to be labeled network.tmaxanc.com/router-ready=true.")
+	flag.DurationVar(&nodeQualificationInterval, "node-qualification-interval", 5*time.Minute, "How often the node is checked again for the kernel modules, sysctls and uplink interfaces routers need, relabeling it. 0 checks it once on start.")
+	flag.StringVar(&allowedNodeSysctls, "allowed-node-sysctls", "", "Comma separated sysctls, or prefixes ending with *, routers may set on the node by spec.nodeSysctls. Routers setting others are held back as the node doesn't support them. None are allowed if empty.")
+	flag.StringVar(&debugBindAddress, "debug-bind-address", "", "Address on the host network the live rulesets and packet captures of the router pods are served on at /debug/ruleset and /debug/pcap, for kubectl vrouter, and failures injected into them at /debug/chaos with the ChaosInjection feature gate. None if empty. Callers authenticate with a bearer token, in the Authorization or X-Virtualrouter-Token header, allowed to get virtualrouters/ruleset or virtualrouters/pcap, or to create, delete or list virtualrouters/chaos.")
 }
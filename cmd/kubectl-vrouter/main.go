@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/vrouterctl"
+)
+
+const usage = `kubectl vrouter operates VirtualRouters.
+
+Usage:
+  kubectl vrouter status <virtualrouter>     Show the phase, revisions, VIP holder, pods and conditions
+  kubectl vrouter rules <virtualrouter>      Dump the live ruleset of a router pod
+  kubectl vrouter failover <virtualrouter>   Replace the active router pod with a standby one
+  kubectl vrouter pcap <virtualrouter>       Capture the packets of a router pod in pcap format
+
+rules and pcap reach the daemon of the node of the router pod through the API
+server, which needs the daemon run with --debug-bind-address. The daemon
+authenticates them with the bearer token of the kubeconfig, or of --token.
+
+Run 'kubectl vrouter <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	fs := flag.NewFlagSet("kubectl vrouter "+command, flag.ExitOnError)
+	kubeconfig := fs.String("kubeconfig", "", "Path to a kubeconfig, the one of kubectl by default.")
+	kubeContext := fs.String("context", "", "The kubeconfig context to use.")
+	namespace := fs.String("n", "", "Namespace of the VirtualRouter, the one of the context by default.")
+	daemonNamespace := fs.String("daemon-namespace", vrouterctl.DEFAULT_DAEMON_NAMESPACE, "Namespace of the daemon pods.")
+	daemonSelector := fs.String("daemon-selector", vrouterctl.DEFAULT_DAEMON_SELECTOR, "Label selector of the daemon pods.")
+	daemonPort := fs.String("daemon-port", vrouterctl.DEFAULT_DAEMON_PORT, "Port of the debug endpoints of the daemon, set with its --debug-bind-address.")
+	token := fs.String("token", "", "Bearer token the daemon authenticates rules and pcap with, the one of the kubeconfig by default.")
+	var podName, iface, filter, output string
+	var duration time.Duration
+	var force bool
+	switch command {
+	case "status":
+	case "rules":
+		fs.StringVar(&podName, "pod", "", "Router pod to dump, the active one by default.")
+	case "failover":
+		fs.BoolVar(&force, "force", false, "Replace the active router pod even without a ready standby one, cutting the traffic.")
+	case "pcap":
+		fs.StringVar(&podName, "pod", "", "Router pod to capture on, the active one by default.")
+		fs.StringVar(&iface, "i", "any", "Interface of the router pod to capture on: ethint, ethext or any.")
+		fs.StringVar(&filter, "f", "", "BPF filter of the packets, in tcpdump syntax.")
+		fs.DurationVar(&duration, "d", 10*time.Second, "How long to capture, up to 5m.")
+		fs.StringVar(&output, "w", "", "File the capture is written to, standard output if - .")
+	default:
+		return fmt.Errorf("unknown command %q, see 'kubectl vrouter --help'", command)
+	}
+
+	// flags may follow the name of the VirtualRouter, as with kubectl
+	var positional []string
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		positional = append(positional, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected the name of a VirtualRouter, see 'kubectl vrouter %s -h'", command)
+	}
+	name := positional[0]
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: *kubeContext})
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	if *namespace == "" {
+		if *namespace, _, err = clientConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+	if *token == "" {
+		*token = cfg.BearerToken
+	}
+	if *token == "" && cfg.BearerTokenFile != "" {
+		content, err := ioutil.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		*token = strings.TrimSpace(string(content))
+	}
+	if *token == "" && (command == "rules" || command == "pcap") {
+		return fmt.Errorf("%s authenticates to the daemon with a bearer token, none in the kubeconfig, give one with --token", command)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	virtualRouterClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	plugin := &vrouterctl.Plugin{
+		KubeClient:          kubeClient,
+		VirtualRouterClient: virtualRouterClient,
+		Out:                 os.Stdout,
+		DaemonNamespace:     *daemonNamespace,
+		DaemonSelector:      *daemonSelector,
+		DaemonPort:          *daemonPort,
+		Token:               *token,
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "status":
+		return plugin.Status(ctx, *namespace, name)
+	case "rules":
+		return plugin.Rules(ctx, *namespace, name, podName)
+	case "failover":
+		return plugin.Failover(ctx, *namespace, name, force)
+	}
+
+	var out io.Writer = os.Stdout
+	if output == "" {
+		return fmt.Errorf("the capture goes to a file given with -w, - for standard output")
+	}
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return plugin.Pcap(ctx, *namespace, name, vrouterctl.CaptureOptions{Pod: podName, Interface: iface, Filter: filter, Duration: duration}, out)
+}
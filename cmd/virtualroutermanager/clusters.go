@@ -0,0 +1,133 @@
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/multicluster"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
+	c1 "github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// clusterInformers are the informer factories the controller of a cluster
+// is built from.
+type clusterInformers struct {
+	kube       kubeinformers.SharedInformerFactory
+	routerPods kubeinformers.SharedInformerFactory
+	example    informers.SharedInformerFactory
+}
+
+func newClusterInformers(kubeClient kubernetes.Interface, exampleClient clientset.Interface, watchNamespace string) clusterInformers {
+	i := clusterInformers{
+		kube: kubeinformers.NewSharedInformerFactory(kubeClient, resyncPeriod),
+		// only router pods are needed, so the pod cache is scoped by their label
+		routerPods: kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeinformers.WithTweakListOptions(func(opt *metav1.ListOptions) {
+			opt.LabelSelector = labels.Set(map[string]string{"app": c1.VIRTUALROUTER_LABEL}).String()
+		})),
+		example: informers.NewFilteredSharedInformerFactory(exampleClient, resyncPeriod, watchNamespace, nil),
+	}
+	// the Deployments, StatefulSets and pods of routers are cached alone,
+	// and stripped
+	i.kube.InformerFor(&appsv1.Deployment{}, c1.NewRouterDeploymentInformer)
+	i.kube.InformerFor(&appsv1.StatefulSet{}, c1.NewRouterStatefulSetInformer)
+	i.routerPods.InformerFor(&corev1.Pod{}, c1.NewRouterPodInformer)
+	return i
+}
+
+// Start runs all the informers requested so far.
+func (i clusterInformers) Start(stopCh <-chan struct{}) {
+	i.kube.Start(stopCh)
+	i.routerPods.Start(stopCh)
+	i.example.Start(stopCh)
+}
+
+func newClusterController(kubeClient kubernetes.Interface, exampleClient clientset.Interface, dynamicClient dynamic.Interface, i clusterInformers, options c1.Options) *c1.Controller {
+	return c1.NewController(kubeClient, exampleClient, dynamicClient,
+		i.kube.Apps().V1().Deployments(),
+		i.kube.Apps().V1().StatefulSets(),
+		i.kube.Policy().V1beta1().PodDisruptionBudgets(),
+		i.kube.Autoscaling().V2beta2().HorizontalPodAutoscalers(),
+		i.routerPods.Core().V1().Pods(),
+		i.kube.Core().V1().Nodes(),
+		i.kube.Core().V1().Services(),
+		i.kube.Discovery().V1beta1().EndpointSlices(),
+		i.example.Tmax().V1().VirtualRouters(),
+		i.example.Tmax().V1().VirtualRouterProfiles(),
+		i.example.Tmax().V1().NetworkFreezes(),
+		options)
+}
+
+// clientConfigs returns the configurations of the clients creating the
+// objects of routers and of those writing status, each rate limited to
+// --kube-api-qps and --kube-api-burst of its own so status updates aren't
+// held back behind rollouts, and that of the client recording Events. The
+// writes of the first two are dry runs with --dry-run, Events are recorded
+// all the same.
+func clientConfigs(cfg *rest.Config) (*rest.Config, *rest.Config, *rest.Config) {
+	objectsCfg := c1.ClientConfig(cfg, c1.CLIENT_COMPONENT_OBJECTS, float32(kubeAPIQPS), kubeAPIBurst)
+	statusCfg := c1.ClientConfig(cfg, c1.CLIENT_COMPONENT_STATUS, float32(kubeAPIQPS), kubeAPIBurst)
+	eventsCfg := objectsCfg
+	if dryRun {
+		objectsCfg = c1.DryRunConfig(objectsCfg, nil)
+		statusCfg = c1.DryRunConfig(statusCfg, nil)
+	}
+	return objectsCfg, statusCfg, eventsCfg
+}
+
+// setStatusClients sets the clients writing status and recording Events in
+// the options.
+func setStatusClients(options *c1.Options, statusCfg *rest.Config, eventsCfg *rest.Config) error {
+	var err error
+	if options.StatusKubeClient, err = kubernetes.NewForConfig(statusCfg); err != nil {
+		return err
+	}
+	if options.EventClient, err = kubernetes.NewForConfig(eventsCfg); err != nil {
+		return err
+	}
+	options.StatusClient, err = clientset.NewForConfig(statusCfg)
+	return err
+}
+
+// newRemoteController builds the controller of a remote cluster with the
+// options of the local one.
+func newRemoteController(cluster multicluster.Cluster, watchNamespace string, options c1.Options) (*c1.Controller, clusterInformers, error) {
+	// dry runs of single VirtualRouters are made with their own clients
+	options.DryRunClients = c1.NewDryRunClients(c1.ClientConfig(cluster.Config, c1.CLIENT_COMPONENT_OBJECTS, float32(kubeAPIQPS), kubeAPIBurst))
+	cfg, statusCfg, eventsCfg := clientConfigs(cluster.Config)
+	if err := setStatusClients(&options, statusCfg, eventsCfg); err != nil {
+		return nil, clusterInformers{}, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, clusterInformers{}, err
+	}
+	exampleClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, clusterInformers{}, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, clusterInformers{}, err
+	}
+	if options.Monitoring {
+		installed, err := c1.PrometheusOperatorInstalled(kubeClient.Discovery())
+		if err != nil {
+			return nil, clusterInformers{}, err
+		}
+		if !installed {
+			klog.Warningf("The Prometheus Operator CRDs are not installed in cluster %s, no dashboards and alerts are generated there", cluster.Name)
+			options.Monitoring = false
+		}
+	}
+
+	i := newClusterInformers(kubeClient, exampleClient, watchNamespace)
+	return newClusterController(kubeClient, exampleClient, dynamicClient, i, options), i, nil
+}
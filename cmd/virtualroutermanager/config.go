@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/config"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/config/v1alpha1"
+	c1 "github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// applyConfig sets the flags from the configuration file, except those given
+// on the command line, which take precedence.
+func applyConfig(cfg *v1alpha1.ControllerConfiguration) {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	setDuration := func(name string, value *metav1.Duration, target *time.Duration) {
+		if value != nil && !given[name] {
+			*target = value.Duration
+		}
+	}
+	setString := func(name string, value *string, target *string) {
+		if value != nil && !given[name] {
+			*target = *value
+		}
+	}
+	setList := func(name string, value []string, target *string) {
+		if value != nil && !given[name] {
+			*target = strings.Join(value, ",")
+		}
+	}
+
+	// workers, tenantNetworkWorkers and resyncPeriod have no flag, and are
+	// defaulted when left out
+	workers = int(*cfg.Workers)
+	tenantNetworkWorkers = int(*cfg.TenantNetworkWorkers)
+	resyncPeriod = cfg.ResyncPeriod.Duration
+	if cfg.KubeAPIQPS != nil && !given["kube-api-qps"] {
+		kubeAPIQPS = float64(*cfg.KubeAPIQPS)
+	}
+	if cfg.KubeAPIBurst != nil && !given["kube-api-burst"] {
+		kubeAPIBurst = int(*cfg.KubeAPIBurst)
+	}
+	if cfg.EnsureParallelism != nil && !given["ensure-parallelism"] {
+		ensureParallelism = int(*cfg.EnsureParallelism)
+	}
+	setList("watch-namespaces", cfg.WatchNamespaces, &watchNamespaces)
+	setString("controller-class", cfg.ControllerClass, &controllerClass)
+	setList("management-cidrs", cfg.ManagementCIDRs, &managementCIDRs)
+	setList("default-image-pull-secrets", cfg.DefaultImagePullSecrets, &pullSecrets)
+	setDuration("rule-expiry-warning", cfg.RuleExpiryWarning, &ruleExpiryWarning)
+	setDuration("node-failure-grace-period", cfg.NodeFailureGracePeriod, &nodeFailureGracePeriod)
+	setDuration("drain-timeout", cfg.DrainTimeout, &drainTimeout)
+	setDuration("status-batch-interval", cfg.StatusBatchInterval, &statusBatchInterval)
+	setList("allowed-registries", cfg.AllowedRegistries, &allowedRegistries)
+	setList("allowed-unsafe-sysctls", cfg.AllowedUnsafeSysctls, &allowedUnsafeSysctls)
+	// sidecar profiles have no flag
+	sidecarProfiles = cfg.SidecarProfiles
+	macPools = cfg.MACPools
+	setString("image-verification-key", cfg.ImageVerificationKey, &imageVerificationKey)
+	setString("metrics-bind-address", cfg.MetricsBindAddress, &metricsBindAddress)
+	setString("webhook-bind-address", cfg.Webhook.BindAddress, &webhookBindAddress)
+	setString("webhook-cert-dir", cfg.Webhook.CertDir, &webhookCertDir)
+	configFeatureGates = fmt.Sprint(cfg.FeatureGates)
+}
+
+// reloadableOptions returns the options the controller takes again on
+// SIGHUP, from the flags.
+func reloadableOptions() c1.Options {
+	options := c1.Options{RuleExpiryWarning: ruleExpiryWarning, NodeFailureGracePeriod: nodeFailureGracePeriod, SidecarProfiles: sidecarProfiles, MACPools: macPools}
+	for _, secretName := range strings.Split(pullSecrets, ",") {
+		if secretName = strings.TrimSpace(secretName); secretName != "" {
+			options.DefaultImagePullSecrets = append(options.DefaultImagePullSecrets, secretName)
+		}
+	}
+	for _, registry := range strings.Split(allowedRegistries, ",") {
+		if registry = strings.TrimSpace(registry); registry != "" {
+			options.AllowedRegistries = append(options.AllowedRegistries, registry)
+		}
+	}
+	for _, sysctl := range strings.Split(allowedUnsafeSysctls, ",") {
+		if sysctl = strings.TrimSpace(sysctl); sysctl != "" {
+			options.AllowedUnsafeSysctls = append(options.AllowedUnsafeSysctls, sysctl)
+		}
+	}
+	for _, cidr := range strings.Split(managementCIDRs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			klog.Fatalf("Invalid management CIDR %q: %s", cidr, err.Error())
+		}
+		options.ManagementCIDRs = append(options.ManagementCIDRs, cidr)
+	}
+	return options
+}
+
+// configFeatureGates are the feature gates of the configuration file last
+// read, only set on start.
+var configFeatureGates string
+
+// startupSettings are the settings only taken when the controller starts.
+type startupSettings struct {
+	workers              int
+	tenantNetworkWorkers int
+	resyncPeriod         time.Duration
+	kubeAPIQPS           float64
+	kubeAPIBurst         int
+	watchNamespaces      string
+	controllerClass      string
+	drainTimeout         time.Duration
+	statusBatchInterval  time.Duration
+	ensureParallelism    int
+	imageVerificationKey string
+	metricsBindAddress   string
+	webhookBindAddress   string
+	webhookCertDir       string
+	featureGates         string
+}
+
+func currentStartupSettings() startupSettings {
+	return startupSettings{
+		workers:              workers,
+		tenantNetworkWorkers: tenantNetworkWorkers,
+		resyncPeriod:         resyncPeriod,
+		kubeAPIQPS:           kubeAPIQPS,
+		kubeAPIBurst:         kubeAPIBurst,
+		watchNamespaces:      watchNamespaces,
+		controllerClass:      controllerClass,
+		drainTimeout:         drainTimeout,
+		statusBatchInterval:  statusBatchInterval,
+		ensureParallelism:    ensureParallelism,
+		imageVerificationKey: imageVerificationKey,
+		metricsBindAddress:   metricsBindAddress,
+		webhookBindAddress:   webhookBindAddress,
+		webhookCertDir:       webhookCertDir,
+		featureGates:         configFeatureGates,
+	}
+}
+
+// reload reads the configuration file again, and hands the settings that can
+// change while running to the controllers and the VirtualRouter webhook. The
+// webhook certificate is read again too, with or without a configuration
+// file.
+func reload(controllers []*c1.Controller, quotaValidator *c1.QuotaValidator, certificate *certificateReloader) {
+	if certificate != nil {
+		if err := certificate.load(); err != nil {
+			klog.Errorf("Error reloading the webhook certificate, keeping the current one: %s", err.Error())
+		}
+	}
+	if configFile == "" {
+		return
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		klog.Errorf("Error reloading the configuration, keeping the current one: %s", err.Error())
+		return
+	}
+	started := currentStartupSettings()
+	applyConfig(cfg)
+	if currentStartupSettings() != started {
+		klog.Warning("Workers, resync period, API rate limits, namespaces, controller class, drain timeout, status batch interval, ensure parallelism, image verification key, metrics and webhook addresses and feature gates only change on restart")
+	}
+	options := reloadableOptions()
+	for _, controller := range controllers {
+		controller.Reload(options)
+	}
+	if quotaValidator != nil {
+		quotaValidator.Reload(options)
+	}
+	klog.Infof("Reloaded the configuration from %s", configFile)
+}
+
+// certificateReloader serves the webhook with the certificate last read from
+// the tls.crt and tls.key of a directory, so a renewed certificate is taken
+// on SIGHUP without a restart.
+type certificateReloader struct {
+	certFile, keyFile string
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(dir string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: filepath.Join(dir, "tls.crt"), keyFile: filepath.Join(dir, "tls.key")}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) load() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.certificate = &certificate
+	return nil
+}
+
+// GetCertificate is the tls.Config.GetCertificate of the webhook server.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.certificate, nil
+}
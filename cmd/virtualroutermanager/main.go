@@ -17,11 +17,21 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
+	// the time zones of maintenance windows, the image having no tzdata
+	_ "time/tzdata"
 
-	kubeinformers "k8s.io/client-go/informers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
@@ -29,23 +39,112 @@ import (
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
+	"github.com/tmax-cloud/virtualrouter-controller/internal/config"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/exporter"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/multicluster"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tenantnetwork"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/config/v1alpha1"
+	networkcontrollerv1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
 	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
-	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
 	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/signals"
 	c1 "github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	configFile string
+
+	workers              = int(v1alpha1.DEFAULT_WORKERS)
+	tenantNetworkWorkers = int(v1alpha1.DEFAULT_TENANT_NETWORK_WORKERS)
+	resyncPeriod         = v1alpha1.DEFAULT_RESYNC_PERIOD
+
+	masterURL       string
+	kubeconfig      string
+	kubeAPIQPS      float64
+	kubeAPIBurst    int
+	managementCIDRs string
+	pullSecrets     string
+	watchNamespaces string
+
+	externalIPApprovalURL     string
+	externalIPApprovalTimeout time.Duration
+
+	allowedRegistries        string
+	allowedUnsafeSysctls     string
+	routerInitImage          string
+	imageVerificationKey     string
+	imageVerificationTimeout time.Duration
+
+	// sidecarProfiles are only set by the configuration file
+	sidecarProfiles []networkcontrollerv1.RouterSidecar
+	// macPools are only set by the configuration file
+	macPools []networkcontrollerv1.MACPool
+
+	ipamProvider string
+	ipamURL      string
+
+	tenantFlavors string
+
+	ruleExpiryWarning time.Duration
+
+	nodeFailureGracePeriod time.Duration
+
+	namespaceTemplate string
+	routerClusterRole bool
+
+	serviceLoadBalancers bool
+
+	exportInterval       time.Duration
+	exportGitURL         string
+	exportGitBranch      string
+	exportDir            string
+	exportObjectStoreURL string
+
+	otlpEndpoint string
+
+	dryRun bool
+
+	drainTimeout time.Duration
+
+	statusBatchInterval time.Duration
+
+	ensureParallelism int
+
+	metricsBindAddress string
+
+	webhookBindAddress string
+	webhookCertDir     string
+
+	controllerClass string
+
+	monitoring       bool
+	monitoringLabels string
+
+	clusterName           string
+	remoteClusterSecrets  string
+	clusterStatusInterval time.Duration
 )
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	if configFile != "" {
+		cfg, err := config.Load(configFile)
+		if err != nil {
+			klog.Fatalf("Error loading the configuration: %s", err.Error())
+		}
+		applyConfig(cfg)
+		if err := features.SetFromConfig(cfg.FeatureGates); err != nil {
+			klog.Fatalf("Error setting the feature gates: %s", err.Error())
+		}
+	}
+
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
+	reloadCh := signals.SetupReloadHandler()
 
 	cfg, err := rest.InClusterConfig()
 
@@ -57,35 +156,281 @@ func main() {
 	// 	klog.Fatalf("Error building kubeconfig: %s", err.Error())
 	// }
 
+	// dry runs of single VirtualRouters are made with their own clients
+	dryRunClients := c1.NewDryRunClients(c1.ClientConfig(cfg, c1.CLIENT_COMPONENT_OBJECTS, float32(kubeAPIQPS), kubeAPIBurst))
+	cfg, statusCfg, eventsCfg := clientConfigs(cfg)
+
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
+	shutdownTracing, err := tracing.Setup(otlpEndpoint, "virtualrouter-controller")
+	if err != nil {
+		klog.Fatalf("Error setting up tracing: %s", err.Error())
+	}
+	defer shutdownTracing()
+
 	exampleClient, err := clientset.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("Error building example clientset: %s", err.Error())
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	// exampleInformerFactory := informers.NewSharedInformerFactory(exampleClient, time.Second*30)
-	exampleInformerFactory := informers.NewFilteredSharedInformerFactory(exampleClient, time.Second*30, namespace, nil)
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building dynamic client: %s", err.Error())
+	}
 
-	controller := c1.NewController(kubeClient, exampleClient,
-		kubeInformerFactory.Apps().V1().Deployments(),
-		exampleInformerFactory.Tmax().V1().VirtualRouters())
+	options := reloadableOptions()
+	options.ControllerNamespace = namespace
+	options.NamespaceTemplate = namespaceTemplate
+	options.RouterClusterRole = routerClusterRole
+	options.ServiceLoadBalancers = serviceLoadBalancers
+	options.DryRun = dryRun
+	options.DryRunClients = dryRunClients
+	options.ControllerClass = controllerClass
+	options.DrainTimeout = drainTimeout
+	options.StatusBatchInterval = statusBatchInterval
+	options.RouterInitImage = routerInitImage
+	options.EnsureParallelism = ensureParallelism
+	if err := setStatusClients(&options, statusCfg, eventsCfg); err != nil {
+		klog.Fatalf("Error building status clients: %s", err.Error())
+	}
+	if err := c1.ValidateNamespaceTemplate(namespaceTemplate); err != nil {
+		klog.Fatalf("Invalid namespace template: %s", err.Error())
+	}
+	if monitoring {
+		installed, err := c1.PrometheusOperatorInstalled(kubeClient.Discovery())
+		switch {
+		case err != nil:
+			klog.Fatalf("Error looking up the Prometheus Operator CRDs: %s", err.Error())
+		case !installed:
+			klog.Warning("The Prometheus Operator CRDs are not installed, no dashboards and alerts are generated")
+		default:
+			options.Monitoring = true
+		}
+		if options.MonitoringLabels, err = labels.ConvertSelectorToLabelsMap(monitoringLabels); err != nil {
+			klog.Fatalf("Invalid monitoring labels %q: %s", monitoringLabels, err.Error())
+		}
+	}
+	if externalIPApprovalURL != "" {
+		options.ExternalIPApprover = c1.NewWebhookExternalIPApprover(externalIPApprovalURL, externalIPApprovalTimeout)
+	}
+	if imageVerificationKey != "" {
+		options.ImageVerifier = c1.NewCosignImageVerifier(imageVerificationKey, imageVerificationTimeout)
+	}
+	if ipamProvider != "" {
+		options.IPAM, err = ipam.NewProvider(ipamProvider, ipamURL, os.Getenv("IPAM_TOKEN"), os.Getenv("IPAM_USERNAME"), os.Getenv("IPAM_PASSWORD"), 30*time.Second)
+		if err != nil {
+			klog.Fatalf("Error building IPAM provider: %s", err.Error())
+		}
+	}
+
+	// VirtualRouters of the controller namespace are watched unless other
+	// namespaces are given. A single namespace is watched on its own, several
+	// are filtered out of a cluster wide watch.
+	watchNamespace := namespace
+	for _, ns := range strings.Split(watchNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			options.WatchNamespaces = append(options.WatchNamespaces, ns)
+		}
+	}
+	switch {
+	case len(options.WatchNamespaces) == 1 && options.WatchNamespaces[0] == "*":
+		watchNamespace = metav1.NamespaceAll
+		options.WatchNamespaces = nil
+	case len(options.WatchNamespaces) == 1:
+		watchNamespace = options.WatchNamespaces[0]
+	case len(options.WatchNamespaces) > 1:
+		watchNamespace = metav1.NamespaceAll
+	}
+	localInformers := newClusterInformers(kubeClient, exampleClient, watchNamespace)
+	exampleInformerFactory := localInformers.example
+
+	controller := newClusterController(kubeClient, exampleClient, dynamicClient, localInformers, options)
+	controllers := []*c1.Controller{controller}
+
+	// VirtualRouters of the remote clusters are reconciled by controllers of
+	// their own, with the same options
+	var remoteSecrets []string
+	for _, secretName := range strings.Split(remoteClusterSecrets, ",") {
+		if secretName = strings.TrimSpace(secretName); secretName != "" {
+			remoteSecrets = append(remoteSecrets, secretName)
+		}
+	}
+	remoteClusters, err := multicluster.LoadClusters(kubeClient, namespace, remoteSecrets)
+	if err != nil {
+		klog.Fatalf("Error loading remote clusters: %s", err.Error())
+	}
+	var aggregator *multicluster.Aggregator
+	if len(remoteClusters) > 0 {
+		aggregator = multicluster.NewAggregator(kubeClient, namespace, options.WatchNamespaces, options.ControllerClass)
+		aggregator.AddCluster(clusterName, exampleInformerFactory.Tmax().V1().VirtualRouters())
+	}
+	var remoteInformers []clusterInformers
+	for _, cluster := range remoteClusters {
+		if cluster.Name == clusterName {
+			klog.Fatalf("Remote cluster %s is named as the local cluster", cluster.Name)
+		}
+		remoteController, i, err := newRemoteController(cluster, watchNamespace, options)
+		if err != nil {
+			klog.Fatalf("Error building the controller of cluster %s: %s", cluster.Name, err.Error())
+		}
+		aggregator.AddCluster(cluster.Name, i.example.Tmax().V1().VirtualRouters())
+		controllers = append(controllers, remoteController)
+		remoteInformers = append(remoteInformers, i)
+	}
+
+	tnController := tenantnetwork.NewController(kubeClient, exampleClient, dynamicClient,
+		exampleInformerFactory.Tmax().V1().TenantNetworks(),
+		exampleInformerFactory.Tmax().V1().VirtualRouters(),
+		tenantnetwork.Options{ControllerNamespace: namespace, FlavorConfigMap: tenantFlavors, IPAM: options.IPAM, EventClient: options.EventClient})
+
+	if metricsBindAddress != "" {
+		c1.RegisterMetrics(prometheus.DefaultRegisterer)
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(metricsBindAddress, nil); err != nil {
+				klog.Fatalf("Error serving metrics: %s", err.Error())
+			}
+		}()
+	}
+
+	var webhookCertificate *certificateReloader
+	var quotaValidator *c1.QuotaValidator
+	if webhookBindAddress != "" {
+		validator := c1.NewRuleValidator(dynamicClient, exampleInformerFactory.Tmax().V1().VirtualRouters(), exampleInformerFactory.Tmax().V1().VirtualRouterQuotas())
+		quotaValidator = c1.NewQuotaValidator(exampleInformerFactory.Tmax().V1().VirtualRouters(), exampleInformerFactory.Tmax().V1().VirtualRouterQuotas(), exampleInformerFactory.Tmax().V1().VirtualRouterProfiles())
+		quotaValidator.Reload(options)
+		webhookCertificate, err = newCertificateReloader(webhookCertDir)
+		if err != nil {
+			klog.Fatalf("Error loading the webhook certificate: %s", err.Error())
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle(c1.RULE_VALIDATION_PATH, validator)
+			mux.Handle(c1.VIRTUALROUTER_VALIDATION_PATH, quotaValidator)
+			server := &http.Server{
+				Addr:      webhookBindAddress,
+				Handler:   mux,
+				TLSConfig: &tls.Config{GetCertificate: webhookCertificate.GetCertificate},
+			}
+			if err := server.ListenAndServeTLS("", ""); err != nil {
+				klog.Fatalf("Error serving the validation webhooks: %s", err.Error())
+			}
+		}()
+	}
+
+	go func() {
+		for range reloadCh {
+			reload(controllers, quotaValidator, webhookCertificate)
+		}
+	}()
+
+	if sink := exportSink(); sink != nil && exportInterval > 0 {
+		e := exporter.NewExporter(exampleInformerFactory.Tmax().V1().VirtualRouters(), dynamicClient, sink, options.WatchNamespaces, options.ControllerClass)
+		go e.Run(exportInterval, stopCh)
+	}
 
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-	kubeInformerFactory.Start(stopCh)
-	exampleInformerFactory.Start(stopCh)
+	localInformers.Start(stopCh)
+	for _, i := range remoteInformers {
+		i.Start(stopCh)
+	}
 
-	if err = controller.Run(2, stopCh); err != nil {
+	if aggregator != nil {
+		go aggregator.Run(clusterStatusInterval, stopCh)
+	}
+
+	go func() {
+		if err := tnController.Run(tenantNetworkWorkers, stopCh); err != nil {
+			klog.Fatalf("Error running TenantNetwork controller: %s", err.Error())
+		}
+	}()
+
+	// the remote controllers are drained along with the local one
+	var remoteControllers sync.WaitGroup
+	for i, remoteController := range controllers[1:] {
+		remoteControllers.Add(1)
+		go func(cluster multicluster.Cluster, remoteController *c1.Controller) {
+			defer remoteControllers.Done()
+			if err := remoteController.Run(workers, stopCh); err != nil {
+				klog.Fatalf("Error running the controller of cluster %s: %s", cluster.Name, err.Error())
+			}
+		}(remoteClusters[i], remoteController)
+	}
+
+	if err = controller.Run(workers, stopCh); err != nil {
 		klog.Fatalf("Error running controller: %s", err.Error())
 	}
+	remoteControllers.Wait()
+}
+
+// exportSink returns the configured configuration export destination, or nil
+// when exporting is off.
+func exportSink() exporter.Sink {
+	switch {
+	case exportGitURL != "":
+		return &exporter.GitSink{
+			URL:         exportGitURL,
+			Branch:      exportGitBranch,
+			Dir:         exportDir,
+			AuthorName:  "virtualrouter-controller",
+			AuthorEmail: "virtualrouter-controller@localhost",
+		}
+	case exportObjectStoreURL != "":
+		return &exporter.ObjectStoreSink{
+			URL:    exportObjectStoreURL,
+			Token:  os.Getenv("EXPORT_OBJECT_STORE_TOKEN"),
+			Client: &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+	return nil
 }
 
 func init() {
+	features.AddFlag(flag.CommandLine)
+	flag.StringVar(&configFile, "config", "", "ControllerConfiguration file the settings are read from, flags given on the command line taking precedence. Management CIDRs, default image pull secrets, rule expiry warning, node failure grace period, allowed registries and the webhook certificate are read again on SIGHUP.")
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", float64(c1.DEFAULT_KUBE_API_QPS), "Queries per second to the API server of the clients creating the objects of routers, and apart of those writing status.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", c1.DEFAULT_KUBE_API_BURST, "Burst of queries to the API server over --kube-api-qps, of the clients creating the objects of routers and apart of those writing status.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&managementCIDRs, "management-cidrs", "", "Comma separated networks of the control plane, probes, metrics scrapers and DNS, kept reachable through every router regardless of tenant firewall rules.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "Comma separated namespaces whose VirtualRouters are handled, * for all. Defaults to the controller namespace.")
+	flag.StringVar(&pullSecrets, "default-image-pull-secrets", "", "Comma separated Secrets in the controller namespace used to pull every router image.")
+	flag.StringVar(&externalIPApprovalURL, "external-ip-approval-url", "", "Webhook that must approve external IPs, such as a bridge to the IPAM of record, before they are assigned to routers.")
+	flag.DurationVar(&externalIPApprovalTimeout, "external-ip-approval-timeout", 10*time.Second, "Timeout of a call to the external IP approval webhook.")
+	flag.StringVar(&allowedRegistries, "allowed-registries", "", "Comma separated registries, or repository paths of registries such as registry.example.com/tmax, router images must be of. Routers of other images are held back with a Degraded condition. Every registry is allowed if empty.")
+	flag.StringVar(&allowedUnsafeSysctls, "allowed-unsafe-sysctls", "", "Comma separated unsafe sysctls, or prefixes ending with *, the kubelets allow by their --allowed-unsafe-sysctls. Router pods are given those of them they set in their security context, and only the others by a privileged init container.")
+	flag.StringVar(&routerInitImage, "router-init-image", c1.DEFAULT_ROUTER_INIT_IMAGE, "Image of the privileged init container setting the sysctls of router pods the kubelets don't. It needs sysctl, and is the controller's rather than the router image of the spec.")
+	flag.StringVar(&imageVerificationKey, "image-verification-key", "", "Cosign public key router images must be signed with, verified with the cosign binary before routers are rolled onto them. Images aren't verified if empty.")
+	flag.DurationVar(&imageVerificationTimeout, "image-verification-timeout", 30*time.Second, "Timeout of the verification of a router image.")
+	flag.StringVar(&ipamProvider, "ipam-provider", "", "IPAM of record external IPs are allocated from when a router gives spec.externalIPPool: netbox or infoblox. Credentials are taken from IPAM_TOKEN (NetBox) or IPAM_USERNAME and IPAM_PASSWORD (Infoblox).")
+	flag.StringVar(&ipamURL, "ipam-url", "", "Base URL of NetBox, or the WAPI URL of Infoblox such as https://infoblox/wapi/v2.10.")
+	flag.DurationVar(&ruleExpiryWarning, "rule-expiry-warning", c1.DEFAULT_RULE_EXPIRY_WARNING, "How long ahead of the expiry of a temporary NAT, firewall or load balancer rule a warning event is emitted.")
+	flag.DurationVar(&nodeFailureGracePeriod, "node-failure-grace-period", c1.DEFAULT_NODE_FAILURE_GRACE_PERIOD, "How long router pods are left on a node not ready before they are force deleted so a standby takes over. Negative leaves them to the eviction of the node controller.")
+	flag.StringVar(&namespaceTemplate, "namespace-template", c1.DEFAULT_NAMESPACE_TEMPLATE, "Name of the namespace of a new VirtualRouter with the dedicated placement, from {namespace} and {name} of the VirtualRouter. Routers keep the namespace they got first.")
+	flag.BoolVar(&routerClusterRole, "router-cluster-role", false, "Bind router pods to the aggregated virtualrouter-router-cluster-role ClusterRole, letting them read nodes and custom resource definitions, and what ClusterRoles labelled network.tmaxanc.com/aggregate-to-virtualrouter-router=true grant.")
+	flag.BoolVar(&serviceLoadBalancers, "service-load-balancers", false, "Publish Services of type LoadBalancer annotated with network.tmaxanc.com/virtualrouter: <VirtualRouter name> on the external IP of that router, in the namespace of the Service, and write it to their status.")
+	flag.StringVar(&tenantFlavors, "tenant-flavors", "virtualrouter-flavors", "ConfigMap in the controller namespace holding the flavors TenantNetworks are provisioned from.")
+	flag.DurationVar(&exportInterval, "export-interval", time.Hour, "Interval of the configuration export. Exporting is on only when a destination is given.")
+	flag.StringVar(&exportGitURL, "export-git-url", "", "Git repository the configuration of every router is committed to.")
+	flag.StringVar(&exportGitBranch, "export-git-branch", "main", "Branch of the export Git repository.")
+	flag.StringVar(&exportDir, "export-dir", "/tmp/virtualrouter-export", "Working copy of the export Git repository.")
+	flag.StringVar(&exportObjectStoreURL, "export-object-store-url", "", "Base URL the configuration of every router is uploaded under, bearer token taken from EXPORT_OBJECT_STORE_TOKEN.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP gRPC endpoint, such as otel-collector:4317, reconcile spans are exported to. Tracing is on only when given.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Plan the changes to every VirtualRouter without applying them: writes are sent as server-side dry runs and logged, and the IPAM and the approval webhook aren't called.")
+	flag.StringVar(&controllerClass, "controller-class", "", "The spec.controllerClass of the VirtualRouters handled. The default, empty, handles those without one.")
+	flag.BoolVar(&monitoring, "monitoring", false, "Generate a Grafana dashboard ConfigMap and a PrometheusRule for every VirtualRouter in its router namespace, when the Prometheus Operator CRDs are installed.")
+	flag.StringVar(&monitoringLabels, "monitoring-labels", "", "Comma separated key=value labels added to the dashboard ConfigMaps and PrometheusRules, for Grafana and Prometheus to select them by.")
+	flag.StringVar(&webhookBindAddress, "webhook-bind-address", "", "Address the validating webhooks are served on over HTTPS, of NATRules, FireWallRules and LoadBalancerRules at /validate-rules and of the VirtualRouterQuotas on VirtualRouters at /validate-virtualrouters, none if empty.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "/etc/virtualrouter/webhook", "Directory holding the tls.crt and tls.key the webhook is served with.")
+	flag.DurationVar(&drainTimeout, "drain-timeout", c1.DEFAULT_DRAIN_TIMEOUT, "How long the syncs running on shutdown are waited for before their API calls are cancelled. The VirtualRouters not synced are then persisted, and synced first on the next start.")
+	flag.DurationVar(&statusBatchInterval, "status-batch-interval", c1.DEFAULT_STATUS_BATCH_INTERVAL, "How long the status of VirtualRouters is held before it is written along with the others, a VirtualRouter synced several times in between having its status written once. Addresses allocated to a router are written at once. 0, the default, writes it at the end of every sync.")
+	flag.IntVar(&ensureParallelism, "ensure-parallelism", c1.DEFAULT_ENSURE_PARALLELISM, "How many of the objects of a router not depending on each other, such as its ServiceAccount, Role and RoleBinding, are created at once within a sync. 1 creates them one after another.")
+	flag.StringVar(&remoteClusterSecrets, "remote-cluster-secrets", "", "Comma separated Secrets in the controller namespace holding the kubeconfig, under the kubeconfig key, of remote clusters whose VirtualRouters are reconciled too. The clusters are named after their Secret.")
+	flag.StringVar(&clusterName, "cluster-name", "local", "Name of the cluster the controller runs in, in the aggregated status of the remote clusters.")
+	flag.DurationVar(&clusterStatusInterval, "cluster-status-interval", time.Minute, "Interval the status of every cluster is aggregated at in the virtualrouter-clusters ConfigMap of the controller namespace, when remote clusters are given.")
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8080", "Address the Prometheus metrics are served on at /metrics, none if empty.")
 }
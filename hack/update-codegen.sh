@@ -32,5 +32,12 @@ bash "${CODEGEN_PKG}"/generate-groups.sh "deepcopy,client,informer,lister" \
   --output-base ~/workspace \
   --go-header-file "${SCRIPT_ROOT}"/hack/boilerplate.go.txt
 
+# the configuration file API is never served, so it only needs deepcopy
+bash "${CODEGEN_PKG}"/generate-groups.sh "deepcopy" \
+  github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis \
+  config:v1alpha1 \
+  --output-base ~/workspace \
+  --go-header-file "${SCRIPT_ROOT}"/hack/boilerplate.go.txt
+
 # To use your own boilerplate text append:
 #   --go-header-file "${SCRIPT_ROOT}"/hack/custom-boilerplate.go.txt
@@ -0,0 +1,43 @@
+#!/usr/bin/env bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+CONTROLLER_GEN_VERSION=v0.16.5
+CONTROLLER_GEN=${CONTROLLER_GEN:-"go run sigs.k8s.io/controller-tools/cmd/controller-gen@${CONTROLLER_GEN_VERSION}"}
+
+# generate the CRD manifests from the +kubebuilder markers in the API types.
+# controller-gen names the files <group>_<plural>.yaml, so they are renamed to
+# the names the install guide refers to.
+OUTPUT_DIR=$(mktemp -d)
+trap 'rm -rf "${OUTPUT_DIR}"' EXIT
+
+cd "${SCRIPT_ROOT}"
+${CONTROLLER_GEN} crd paths=./internal/utils/pkg/apis/... output:crd:dir="${OUTPUT_DIR}"
+
+sed "s/controller-gen.kubebuilder.io\/version: .*/controller-gen.kubebuilder.io\/version: ${CONTROLLER_GEN_VERSION}/" \
+  "${OUTPUT_DIR}"/tmax.hypercloud.com_virtualrouters.yaml > deploy/integrated/virtualrouter-crd.yaml
+sed "s/controller-gen.kubebuilder.io\/version: .*/controller-gen.kubebuilder.io\/version: ${CONTROLLER_GEN_VERSION}/" \
+  "${OUTPUT_DIR}"/tmax.hypercloud.com_tenantnetworks.yaml > deploy/integrated/tenantnetwork-crd.yaml
+sed "s/controller-gen.kubebuilder.io\/version: .*/controller-gen.kubebuilder.io\/version: ${CONTROLLER_GEN_VERSION}/" \
+  "${OUTPUT_DIR}"/tmax.hypercloud.com_virtualrouterquotas.yaml > deploy/integrated/virtualrouterquota-crd.yaml
+sed "s/controller-gen.kubebuilder.io\/version: .*/controller-gen.kubebuilder.io\/version: ${CONTROLLER_GEN_VERSION}/" \
+  "${OUTPUT_DIR}"/tmax.hypercloud.com_virtualrouterprofiles.yaml > deploy/integrated/virtualrouterprofile-crd.yaml
+sed "s/controller-gen.kubebuilder.io\/version: .*/controller-gen.kubebuilder.io\/version: ${CONTROLLER_GEN_VERSION}/" \
+  "${OUTPUT_DIR}"/tmax.hypercloud.com_networkfreezes.yaml > deploy/integrated/networkfreeze-crd.yaml
@@ -0,0 +1,113 @@
+// Package config loads the configuration file of the controller, given with
+// --config, a ControllerConfiguration of
+// virtualrouter.config.tmax.hypercloud.com/v1alpha1.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/macaddress"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/config/v1alpha1"
+)
+
+var scheme = runtime.NewScheme()
+
+var codecs = serializer.NewCodecFactory(scheme)
+
+func init() {
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
+// Load reads, defaults and validates the configuration file at path. Fields
+// it doesn't know are rejected, so typos aren't silently ignored.
+func Load(path string) (*v1alpha1.ControllerConfiguration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := codecs.DecoderToVersion(json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{Yaml: true, Strict: true}), v1alpha1.SchemeGroupVersion)
+	obj, gvk, err := decoder.Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	cfg, ok := obj.(*v1alpha1.ControllerConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("%s holds a %s, not a ControllerConfiguration", path, gvk)
+	}
+	scheme.Default(cfg)
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate returns the first invalid setting of the configuration.
+func Validate(cfg *v1alpha1.ControllerConfiguration) error {
+	if cfg.Workers != nil && *cfg.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", *cfg.Workers)
+	}
+	if cfg.TenantNetworkWorkers != nil && *cfg.TenantNetworkWorkers < 1 {
+		return fmt.Errorf("tenantNetworkWorkers must be at least 1, got %d", *cfg.TenantNetworkWorkers)
+	}
+	if cfg.KubeAPIQPS != nil && *cfg.KubeAPIQPS < 1 {
+		return fmt.Errorf("kubeAPIQPS must be at least 1, got %d", *cfg.KubeAPIQPS)
+	}
+	if cfg.KubeAPIBurst != nil && *cfg.KubeAPIBurst < 1 {
+		return fmt.Errorf("kubeAPIBurst must be at least 1, got %d", *cfg.KubeAPIBurst)
+	}
+	if cfg.ResyncPeriod != nil && cfg.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("resyncPeriod must not be negative, got %s", cfg.ResyncPeriod.Duration)
+	}
+	if cfg.RuleExpiryWarning != nil && cfg.RuleExpiryWarning.Duration < 0 {
+		return fmt.Errorf("ruleExpiryWarning must not be negative, got %s", cfg.RuleExpiryWarning.Duration)
+	}
+	if cfg.DrainTimeout != nil && cfg.DrainTimeout.Duration < 0 {
+		return fmt.Errorf("drainTimeout must not be negative, got %s", cfg.DrainTimeout.Duration)
+	}
+	if cfg.StatusBatchInterval != nil && cfg.StatusBatchInterval.Duration < 0 {
+		return fmt.Errorf("statusBatchInterval must not be negative, got %s", cfg.StatusBatchInterval.Duration)
+	}
+	if cfg.EnsureParallelism != nil && *cfg.EnsureParallelism < 1 {
+		return fmt.Errorf("ensureParallelism must be at least 1, got %d", *cfg.EnsureParallelism)
+	}
+	profiles := map[string]bool{}
+	for _, profile := range cfg.SidecarProfiles {
+		if profile.Name == "" {
+			return fmt.Errorf("sidecarProfiles must be named")
+		}
+		if profiles[profile.Name] {
+			return fmt.Errorf("sidecarProfiles: %s is given twice", profile.Name)
+		}
+		profiles[profile.Name] = true
+	}
+	pools := map[string]bool{}
+	for _, pool := range cfg.MACPools {
+		if pool.Name == "" {
+			return fmt.Errorf("macPools must be named")
+		}
+		if pools[pool.Name] {
+			return fmt.Errorf("macPools: %s is given twice", pool.Name)
+		}
+		pools[pool.Name] = true
+		if _, err := macaddress.ParsePrefix(pool.Prefix); err != nil {
+			return fmt.Errorf("macPools: %s: %v", pool.Name, err)
+		}
+	}
+	for _, cidr := range cfg.ManagementCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid managementCIDRs %q: %v", cidr, err)
+		}
+	}
+	if err := features.DefaultFeatureGate.DeepCopy().SetFromMap(cfg.FeatureGates); err != nil {
+		return fmt.Errorf("invalid featureGates: %v", err)
+	}
+	return nil
+}
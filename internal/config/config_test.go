@@ -0,0 +1,92 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `apiVersion: virtualrouter.config.tmax.hypercloud.com/v1alpha1
+kind: ControllerConfiguration
+workers: 4
+watchNamespaces:
+- tenant-a
+- tenant-b
+managementCIDRs:
+- 10.0.0.0/24
+ruleExpiryWarning: 5m
+webhook:
+  bindAddress: ":9443"
+featureGates:
+  VPN: false
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cfg.Workers != 4 {
+		t.Errorf("workers = %d, want 4", *cfg.Workers)
+	}
+	if *cfg.TenantNetworkWorkers != 1 {
+		t.Errorf("tenantNetworkWorkers = %d, want the default 1", *cfg.TenantNetworkWorkers)
+	}
+	if cfg.ResyncPeriod.Duration != 30*time.Second {
+		t.Errorf("resyncPeriod = %s, want the default 30s", cfg.ResyncPeriod.Duration)
+	}
+	if len(cfg.WatchNamespaces) != 2 || cfg.RuleExpiryWarning.Duration != 5*time.Minute {
+		t.Errorf("unexpected configuration %+v", cfg)
+	}
+	if *cfg.Webhook.BindAddress != ":9443" || cfg.Webhook.CertDir != nil {
+		t.Errorf("unexpected webhook configuration %+v", cfg.Webhook)
+	}
+	if enabled, ok := cfg.FeatureGates["VPN"]; !ok || enabled {
+		t.Errorf("expected the VPN feature gate to be turned off, got %v", cfg.FeatureGates)
+	}
+	if cfg.MetricsBindAddress != nil {
+		t.Errorf("metricsBindAddress left out should keep its flag, got %q", *cfg.MetricsBindAddress)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	const header = "apiVersion: virtualrouter.config.tmax.hypercloud.com/v1alpha1\nkind: ControllerConfiguration\n"
+	cases := map[string]struct {
+		content string
+		want    string
+	}{
+		"unknown field":         {header + "worker: 4\n", "unknown field"},
+		"unknown kind":          {"apiVersion: virtualrouter.config.tmax.hypercloud.com/v1alpha1\nkind: Other\n", "no kind"},
+		"no workers":            {header + "workers: 0\n", "workers"},
+		"negative resync":       {header + "resyncPeriod: -1s\n", "resyncPeriod"},
+		"no API queries":        {header + "kubeAPIQPS: 0\n", "kubeAPIQPS"},
+		"no parallelism":        {header + "ensureParallelism: 0\n", "ensureParallelism"},
+		"invalid CIDR":          {header + "managementCIDRs:\n- 10.0.0.0\n", "managementCIDRs"},
+		"sidecar profile twice": {header + "sidecarProfiles:\n- name: bgp\n  image: bird\n- name: bgp\n  image: bird\n", "sidecarProfiles"},
+		"unknown gate":          {header + "featureGates:\n  Unknown: true\n", "unrecognized feature gate"},
+		"duplicated fields":     {header + "workers: 1\nworkers: 2\n", "workers"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Load() = %v, want an error about %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// AUDIT_CONFIGMAP_NAME is the ConfigMap of the router namespace, prefixed
+	// with the VirtualRouter name, keeping the latest data plane changes of a
+	// router
+	AUDIT_CONFIGMAP_NAME string = "virtualrouter-audit"
+	// AUDIT_CONFIGMAP_KEY holds the records, a JSON object per line, oldest
+	// first
+	AUDIT_CONFIGMAP_KEY string = "records"
+)
+
+// AuditRecord is a change the daemon made to the data plane of a router pod.
+type AuditRecord struct {
+	Time metav1.Time `json:"time"`
+	// Node is the node of the daemon making the change
+	Node string `json:"node"`
+	// VirtualRouter is the namespace/name of the VirtualRouter applied
+	VirtualRouter string `json:"virtualRouter"`
+	// Pod is the router pod changed
+	Pod string `json:"pod"`
+	// Revision is the generation of the VirtualRouter applied
+	Revision int64 `json:"revision"`
+	// Operations are the changes, as planned in dry run
+	Operations []string `json:"operations"`
+	// Error is why applying them failed, in part or as a whole
+	Error string `json:"error,omitempty"`
+}
+
+// RulesetPlan returns the changes EnsureSNATPool, EnsureVRFEgress,
+// EnsureInterNetwork and EnsureHardening would make to the rules the daemon owns in the router
+// container.
+func (n *NetworkDaemon) RulesetPlan(virtualrouter *v1.VirtualRouter) []string {
+	var operations []string
+	containerName := virtualrouter.Name
+	snatPool, applied := snatPoolConfigFor(virtualrouter), n.snatPools[containerName]
+	switch {
+	case snatPool != nil && (applied == nil || !reflect.DeepEqual(snatPool.addresses, applied.addresses) || !reflect.DeepEqual(snatPool.sources, applied.sources)):
+		operations = append(operations, fmt.Sprintf("set SNAT pool [%s] for [%s]", strings.Join(snatPool.addresses, ", "), strings.Join(snatPool.sources, ", ")))
+	case snatPool == nil && applied != nil:
+		operations = append(operations, "clear SNAT pool")
+	}
+	hardening, appliedHardening := hardeningConfigFor(virtualrouter), n.hardening[containerName]
+	switch {
+	case hardening != nil && !reflect.DeepEqual(hardening, appliedHardening):
+		operations = append(operations, fmt.Sprintf("set firewall hardening %+v", hardening.hardening))
+	case hardening == nil && appliedHardening != nil:
+		operations = append(operations, "clear firewall hardening")
+	}
+	flowOffload, appliedFlowOffload := flowOffloadConfigFor(virtualrouter), n.flowOffloads[containerName]
+	switch {
+	case flowOffload != nil && (appliedFlowOffload == nil || *flowOffload != *appliedFlowOffload):
+		operations = append(operations, fmt.Sprintf("set flow offload (hardware %t)", flowOffload.hardware))
+	case flowOffload == nil && appliedFlowOffload != nil:
+		operations = append(operations, "clear flow offload")
+	}
+	vrfEgress, appliedVRFEgress := vrfEgressConfigFor(virtualrouter), n.vrfEgress[containerName]
+	switch {
+	case vrfEgress != nil && !reflect.DeepEqual(vrfEgress, appliedVRFEgress):
+		operations = append(operations, fmt.Sprintf("set VRF source NAT to %s for %d VRFs", vrfEgress.externalIP, len(vrfEgress.vrfs)))
+	case vrfEgress == nil && appliedVRFEgress != nil:
+		operations = append(operations, "clear VRF source NAT")
+	}
+	interNetwork, appliedInterNetwork := interNetworkConfigFor(virtualrouter), n.interNetworks[containerName]
+	switch {
+	case interNetwork != nil && !reflect.DeepEqual(interNetwork, appliedInterNetwork):
+		operations = append(operations, fmt.Sprintf("set inter-network rules dropping %d and translating %d network pairs", len(interNetwork.dropped), len(interNetwork.translated)))
+	case interNetwork == nil && appliedInterNetwork != nil:
+		operations = append(operations, "clear inter-network rules")
+	}
+	return operations
+}
+
+// audit records the changes made to the data plane of the router pod, if
+// any, in the log and, with auditRecords, in the audit ConfigMap of the
+// router. Failing to keep the record doesn't fail the sync.
+func (c *Controller) audit(virtualRouter *v1.VirtualRouter, pod *corev1.Pod, operations []string, err error) {
+	if len(operations) == 0 || pod == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:          metav1.NewTime(time.Now()),
+		Node:          pod.Spec.NodeName,
+		VirtualRouter: virtualRouter.Namespace + "/" + virtualRouter.Name,
+		Pod:           pod.Name,
+		Revision:      virtualRouter.Generation,
+		Operations:    operations,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	klog.InfoS("Data plane changed", "virtualRouter", klog.KObj(virtualRouter), "pod", klog.KObj(pod), "node", record.Node,
+		"revision", record.Revision, "operations", operations, "error", record.Error)
+
+	if c.auditRecords <= 0 {
+		return
+	}
+	if err := c.appendAuditRecord(virtualRouter, pod.Namespace, record); err != nil {
+		klog.ErrorS(err, "Keeping audit record failed", "virtualRouter", klog.KObj(virtualRouter))
+	}
+}
+
+// appendAuditRecord adds the record to the audit ConfigMap, creating it if
+// missing. The daemons of every node running the router write to it, so the
+// update is retried on conflicts.
+func (c *Controller) appendAuditRecord(virtualRouter *v1.VirtualRouter, namespace string, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(namespace)
+	name := virtualRouter.Name + "-" + AUDIT_CONFIGMAP_NAME
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(context.TODO(), name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Data:       map[string]string{AUDIT_CONFIGMAP_KEY: appendAuditLine("", string(line), c.auditRecords)},
+			}
+			if namespace == virtualRouter.Namespace {
+				// in a tenant namespace, the records go with the router
+				configMap.OwnerReferences = []metav1.OwnerReference{
+					*metav1.NewControllerRef(virtualRouter, v1.SchemeGroupVersion.WithKind("VirtualRouter")),
+				}
+			}
+			_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// created by the daemon of another node meanwhile
+				return errors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		configMapCopy := configMap.DeepCopy()
+		if configMapCopy.Data == nil {
+			configMapCopy.Data = map[string]string{}
+		}
+		configMapCopy.Data[AUDIT_CONFIGMAP_KEY] = appendAuditLine(configMapCopy.Data[AUDIT_CONFIGMAP_KEY], string(line), c.auditRecords)
+		_, err = configMaps.Update(context.TODO(), configMapCopy, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// appendAuditLine appends the line to the records, dropping the oldest ones
+// beyond max.
+func appendAuditLine(records string, line string, max int) string {
+	lines := append(strings.Split(strings.TrimSuffix(records, "\n"), "\n"), line)
+	if records == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestAppendAuditLine(t *testing.T) {
+	tests := map[string]struct {
+		records  string
+		expected string
+	}{
+		"first":          {"", "c\n"},
+		"appended":       {"a\n", "a\nc\n"},
+		"oldest dropped": {"a\nb\n", "b\nc\n"},
+	}
+	for name, test := range tests {
+		if records := appendAuditLine(test.records, "c", 2); records != test.expected {
+			t.Errorf("%s: expected %q, got %q", name, test.expected, records)
+		}
+	}
+}
+
+func TestRulesetPlan(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:        "192.168.0.1",
+			InternalNetmask:   "255.255.255.0",
+			SNATPool:          &v1.SNATPool{Pool: "10.0.0.0/24", Size: 1},
+			FirewallHardening: &v1.FirewallHardening{DropInvalid: true},
+		},
+		Status: v1.VirtualRouterStatus{
+			SNATPoolAllocations: []v1.IPAMAllocation{{Pool: "10.0.0.0/24", Address: "10.0.0.10/24"}},
+		},
+	}
+	n := NewDaemon(nil, nil, "")
+	expected := []string{
+		"set SNAT pool [10.0.0.10/32] for [192.168.0.0/24]",
+		"set firewall hardening {DropInvalid:true MaxConnectionsPerSource:0 SYNRate:0 SYNBurst:0}",
+	}
+	if operations := n.RulesetPlan(virtualRouter); !reflect.DeepEqual(operations, expected) {
+		t.Errorf("expected %q, got %q", expected, operations)
+	}
+
+	// nothing to change once applied
+	n.snatPools["test"] = snatPoolConfigFor(virtualRouter)
+	n.hardening["test"] = hardeningConfigFor(virtualRouter)
+	if operations := n.RulesetPlan(virtualRouter); len(operations) != 0 {
+		t.Errorf("expected no operations, got %q", operations)
+	}
+
+	virtualRouter.Spec.SNATPool = nil
+	virtualRouter.Spec.FirewallHardening = nil
+	expected = []string{"clear SNAT pool", "clear firewall hardening"}
+	if operations := n.RulesetPlan(virtualRouter); !reflect.DeepEqual(operations, expected) {
+		t.Errorf("expected %q, got %q", expected, operations)
+	}
+}
+
+func TestAppendAuditRecord(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 4}}
+	kubeclient := k8sfake.NewSimpleClientset()
+	c := &Controller{kubeclientset: kubeclient, auditRecords: 2}
+	for _, revision := range []int64{1, 2, 3} {
+		record := AuditRecord{VirtualRouter: "default/test", Revision: revision, Operations: []string{"set MTU 1400"}}
+		if err := c.appendAuditRecord(virtualRouter, "test", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configMap, err := kubeclient.CoreV1().ConfigMaps("test").Get(context.TODO(), "test-"+AUDIT_CONFIGMAP_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the router namespace goes with the router, no owner is needed
+	if len(configMap.OwnerReferences) != 0 {
+		t.Errorf("expected no owner, got %v", configMap.OwnerReferences)
+	}
+	var revisions []int64
+	for _, line := range strings.Split(strings.TrimSpace(configMap.Data[AUDIT_CONFIGMAP_KEY]), "\n") {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		revisions = append(revisions, record.Revision)
+	}
+	if !reflect.DeepEqual(revisions, []int64{2, 3}) {
+		t.Errorf("expected the records of revisions 2 and 3, got %v", revisions)
+	}
+}
@@ -0,0 +1,169 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// CAPTURE_ANNOTATION asks the daemons for a packet capture on the router
+	// pods of a VirtualRouter, as a PacketCapture in JSON. A capture is taken
+	// once per ID, so a new one is asked for with another ID.
+	CAPTURE_ANNOTATION string = "network.tmaxanc.com/capture"
+
+	// PacketCaptured is used as part of the Event 'reason' when a capture
+	// asked with the annotation is stored
+	PacketCaptured = "PacketCaptured"
+	// ErrPacketCapture is used as part of the Event 'reason' when a capture
+	// asked with the annotation can't be taken
+	ErrPacketCapture = "ErrPacketCapture"
+)
+
+// PacketCapture is a capture of the traffic of router pods.
+type PacketCapture struct {
+	ID string `json:"id"`
+	// Pod is the router pod captured, the active one if empty
+	Pod string `json:"pod,omitempty"`
+	// Interface is the interface of the router pod captured, any if empty
+	Interface string `json:"interface,omitempty"`
+	// Filter is a BPF filter of the packets, in tcpdump syntax
+	Filter string `json:"filter,omitempty"`
+	// Duration is how long the capture runs, DEFAULT_CAPTURE_DURATION if
+	// unset and MAX_CAPTURE_DURATION at most
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+func parsePacketCapture(value string) (*PacketCapture, error) {
+	var packetCapture PacketCapture
+	if err := json.Unmarshal([]byte(value), &packetCapture); err != nil {
+		return nil, err
+	}
+	if packetCapture.ID == "" || filepath.Base(packetCapture.ID) != packetCapture.ID || packetCapture.ID == "." || packetCapture.ID == ".." {
+		return nil, fmt.Errorf("invalid capture id %q", packetCapture.ID)
+	}
+	if packetCapture.Duration.Duration < 0 || packetCapture.Duration.Duration > MAX_CAPTURE_DURATION {
+		return nil, fmt.Errorf("invalid capture duration %s, up to %s", packetCapture.Duration.Duration, MAX_CAPTURE_DURATION)
+	}
+	if err := checkCaptureFilter(packetCapture.Filter); err != nil {
+		return nil, err
+	}
+	if packetCapture.Duration.Duration == 0 {
+		packetCapture.Duration.Duration = DEFAULT_CAPTURE_DURATION
+	}
+	if packetCapture.Interface == "" {
+		packetCapture.Interface = "any"
+	}
+	return &packetCapture, nil
+}
+
+// capturePath is where the capture of the router pod is stored:
+// <captureDir>/<namespace>/<VirtualRouter>/<id>-<pod>.pcap
+func (c *Controller) capturePath(virtualRouter *v1.VirtualRouter, packetCapture *PacketCapture, pod *corev1.Pod) string {
+	return filepath.Join(c.captureDir, virtualRouter.Namespace, virtualRouter.Name, packetCapture.ID+"-"+pod.Name+".pcap")
+}
+
+// ensureCapture starts the capture asked with CAPTURE_ANNOTATION on the
+// router pods of the node, the active one unless the capture names another,
+// unless it was taken already. Captures run in the background, and their
+// outcome is recorded in Events of the VirtualRouter.
+func (c *Controller) ensureCapture(virtualRouter *v1.VirtualRouter, routerPods []*corev1.Pod) {
+	value, ok := virtualRouter.Annotations[CAPTURE_ANNOTATION]
+	if !ok || c.captureDir == "" {
+		return
+	}
+	key := virtualRouter.Namespace + "/" + virtualRouter.Name + "/" + value
+	packetCapture, err := parsePacketCapture(value)
+	if err != nil {
+		if c.startCapture(key) {
+			c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, ErrPacketCapture, "Invalid %s annotation: %v", CAPTURE_ANNOTATION, err)
+		}
+		return
+	}
+	for _, pod := range routerPods {
+		if packetCapture.Pod != "" && pod.Name != packetCapture.Pod || packetCapture.Pod == "" && pod.Spec.NodeName != virtualRouter.Status.ActiveNode {
+			continue
+		}
+		path := c.capturePath(virtualRouter, packetCapture, pod)
+		if _, err := os.Stat(path); err == nil {
+			// taken before the daemon restarted
+			continue
+		}
+		if !c.startCapture(key + "/" + pod.Name) {
+			continue
+		}
+		go func(pod *corev1.Pod) {
+			size, err := c.capturePod(virtualRouter, pod, packetCapture, path)
+			if err != nil {
+				klog.ErrorS(err, "Capturing packets failed", "virtualRouter", klog.KObj(virtualRouter), "pod", klog.KObj(pod))
+				c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, ErrPacketCapture, "Capture %s on router pod %s failed: %v", packetCapture.ID, pod.Name, err)
+				return
+			}
+			c.recorder.Eventf(virtualRouter, corev1.EventTypeNormal, PacketCaptured, "Capture %s of %s on router pod %s stored in %s (%d bytes)",
+				packetCapture.ID, packetCapture.Interface, pod.Name, path, size)
+		}(pod)
+	}
+}
+
+// startCapture reports whether the capture of the key is to be started, and
+// remembers it is. Failed captures aren't taken again, until the daemon
+// restarts.
+func (c *Controller) startCapture(key string) bool {
+	c.capturesMu.Lock()
+	defer c.capturesMu.Unlock()
+	if c.capturesStarted[key] {
+		return false
+	}
+	if c.capturesStarted == nil {
+		c.capturesStarted = map[string]bool{}
+	}
+	c.capturesStarted[key] = true
+	return true
+}
+
+// capturePod captures the packets of the router container of the pod to the
+// file of the path, written under another name until the capture is over.
+// It returns the size of the capture.
+func (c *Controller) capturePod(virtualRouter *v1.VirtualRouter, pod *corev1.Pod, packetCapture *PacketCapture, path string) (int64, error) {
+	containerID := runningContainerID(pod, virtualRouter.Name)
+	if containerID == "" {
+		return 0, fmt.Errorf("router container of pod %s is not running", pod.Name)
+	}
+	pid, err := routerPid(c.networkDaemon, containerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return 0, err
+	}
+	partial := path + ".part"
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(partial)
+	klog.InfoS("Capturing packets", "virtualRouter", klog.KObj(virtualRouter), "id", packetCapture.ID, "interface", packetCapture.Interface,
+		"filter", packetCapture.Filter, "duration", packetCapture.Duration.Duration)
+	ctx, cancel := context.WithTimeout(context.Background(), packetCapture.Duration.Duration)
+	defer cancel()
+	err = capture(ctx, pid, packetCapture.Interface, packetCapture.Filter, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(partial)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(partial, path)
+}
@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestEnsureCapture(t *testing.T) {
+	defer func(pid func(*NetworkDaemon, string) (int, error), run func(context.Context, int, string, string, io.Writer) error) {
+		routerPid, capture = pid, run
+	}(routerPid, capture)
+	// the router container of each pod has its own pid
+	pids := map[string]int{"0123abcd": 42, "4567cdef": 43}
+	routerPid = func(n *NetworkDaemon, containerID string) (int, error) {
+		if pid, ok := pids[containerID]; ok {
+			return pid, nil
+		}
+		return 0, fmt.Errorf("no container %s", containerID)
+	}
+	captured := make(chan string, 10)
+	capture = func(ctx context.Context, pid int, iface string, filter string, w io.Writer) error {
+		deadline, _ := ctx.Deadline()
+		captured <- fmt.Sprintf("%d %s %s %s", pid, iface, filter, time.Until(deadline).Round(time.Second))
+		if filter == "invalid" {
+			return fmt.Errorf("tcpdump: syntax error")
+		}
+		_, err := w.Write([]byte("pcap"))
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	recorder := record.NewFakeRecorder(10)
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Status:     v1.VirtualRouterStatus{ActiveNode: "node1"},
+	}
+	routerPod := func(name string, nodeName string, containerID string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Annotations: map[string]string{
+				"customresourceName":      "test",
+				"customresourceNamespace": "default",
+			}},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "test", ContainerID: "cri-o://" + containerID, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}},
+		}
+	}
+	routerPods := []*corev1.Pod{routerPod("test-a", "node1", "0123abcd"), routerPod("test-b", "node2", "4567cdef")}
+	c := &Controller{networkDaemon: NewDaemon(nil, nil, internalNetlink.FeatureNftables), recorder: recorder, captureDir: dir, capturesStarted: map[string]bool{}}
+	expectEvent := func(prefix string) {
+		t.Helper()
+		select {
+		case event := <-recorder.Events:
+			if !strings.HasPrefix(event, prefix) {
+				t.Errorf("expected an event %q, got %q", prefix, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected an event %q", prefix)
+		}
+	}
+
+	// the active router pod by default
+	virtualRouter.Annotations = map[string]string{CAPTURE_ANNOTATION: `{"id": "1", "interface": "ethext", "filter": "tcp port 443", "duration": "30s"}`}
+	c.ensureCapture(virtualRouter, routerPods)
+	expectEvent("Normal PacketCaptured Capture 1 of ethext on router pod test-a")
+	if got := <-captured; got != "42 ethext tcp port 443 30s" {
+		t.Errorf("expected a capture of ethext for 30s, got %q", got)
+	}
+	path := filepath.Join(dir, "default", "test", "1-test-a.pcap")
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != "pcap" {
+		t.Errorf("expected the capture in %s, got %q: %v", path, content, err)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("expected no partial capture left, got %v", err)
+	}
+
+	// taken once, even by another daemon
+	c.ensureCapture(virtualRouter, routerPods)
+	(&Controller{networkDaemon: c.networkDaemon, recorder: recorder, captureDir: dir}).ensureCapture(virtualRouter, routerPods)
+
+	// a named router pod, with the defaults
+	virtualRouter.Annotations[CAPTURE_ANNOTATION] = `{"id": "2", "pod": "test-b"}`
+	c.ensureCapture(virtualRouter, routerPods)
+	expectEvent("Normal PacketCaptured Capture 2 of any on router pod test-b")
+	if got := <-captured; got != "43 any  10s" {
+		t.Errorf("expected a capture of any on test-b for 10s, got %q", got)
+	}
+
+	// failures are reported once
+	virtualRouter.Annotations[CAPTURE_ANNOTATION] = `{"id": "3", "filter": "invalid"}`
+	c.ensureCapture(virtualRouter, routerPods)
+	expectEvent("Warning ErrPacketCapture Capture 3 on router pod test-a failed: tcpdump: syntax error")
+	<-captured
+	c.ensureCapture(virtualRouter, routerPods)
+	if _, err := os.Stat(filepath.Join(dir, "default", "test", "3-test-a.pcap")); !os.IsNotExist(err) {
+		t.Errorf("expected no failed capture stored, got %v", err)
+	}
+
+	for _, value := range []string{`{"duration": "10s"}`, `{"id": "../4"}`, `{"id": "5", "duration": "1h"}`, `{"id": "6", "filter": "-z reboot"}`, `id`} {
+		virtualRouter.Annotations[CAPTURE_ANNOTATION] = value
+		c.ensureCapture(virtualRouter, routerPods)
+		c.ensureCapture(virtualRouter, routerPods)
+		expectEvent("Warning ErrPacketCapture Invalid " + CAPTURE_ANNOTATION + " annotation")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("expected no more events, got %q", event)
+	case got := <-captured:
+		t.Errorf("expected no more captures, got %q", got)
+	default:
+	}
+}
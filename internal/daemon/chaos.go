@@ -0,0 +1,445 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+const (
+	// DEFAULT_FAULT_DURATION is how long an injected fault lasts unless
+	// given, and MAX_FAULT_DURATION the longest one allowed, so a forgotten
+	// fault doesn't keep a router down
+	DEFAULT_FAULT_DURATION = time.Minute
+	MAX_FAULT_DURATION     = 30 * time.Minute
+	// CHAOS_RULESET names the nftables table, and comments the iptables
+	// rules, blackholing the external interface of a router
+	CHAOS_RULESET = "virtualrouter-chaos"
+	// CHAOS_SUBRESOURCE is the subresource of virtualrouters callers of the
+	// chaos endpoint must be allowed to create, delete or list, there being
+	// no such subresource on the API server
+	CHAOS_SUBRESOURCE = "chaos"
+)
+
+// Fault is a failure injected into a router container
+type Fault string
+
+const (
+	// FaultDropVIP removes the addresses of the external interface, as if
+	// the router lost its external IP
+	FaultDropVIP Fault = "drop-vip"
+	// FaultBlackholeExternal drops every packet in and out of the external
+	// interface, as if its uplink was cut
+	FaultBlackholeExternal Fault = "blackhole-external"
+	// FaultFlushConntrack removes the tracked connections of the router at
+	// once, as if it restarted. There is nothing to revert.
+	FaultFlushConntrack Fault = "flush-conntrack"
+)
+
+// runInRouter runs the command in the network namespace of the process,
+// feeding it the input, and returns what it prints
+var runInRouter = func(pid int, input string, command string, args ...string) ([]byte, error) {
+	cmd := exec.Command("nsenter", append([]string{"-t", strconv.Itoa(pid), "-n", command}, args...)...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+// setRouterRoutes copies the routes through the interface of the process
+// into the router table, once its addresses are back
+var setRouterRoutes = func(pid int, iface string) error {
+	return internalNetlink.SetRoute2Container(pid, iface, DEFAULT_TABLE_NUMBER)
+}
+
+// InjectedFault is a fault injected into a router container, active until
+// it expires
+type InjectedFault struct {
+	Namespace     string    `json:"namespace"`
+	VirtualRouter string    `json:"virtualRouter"`
+	Fault         Fault     `json:"fault"`
+	Expires       time.Time `json:"expires"`
+
+	containerID string
+	revert      func() error
+	timer       *time.Timer
+}
+
+// faults are the faults active in the router containers of the node by
+// namespace and name of the VirtualRouter and fault, nil while being
+// injected
+type faults struct {
+	lock   sync.Mutex
+	active map[string]*InjectedFault
+}
+
+func faultKey(namespace string, virtualRouter string, fault Fault) string {
+	return namespace + "/" + virtualRouter + "/" + string(fault)
+}
+
+// claim reserves the fault of the VirtualRouter for it to be injected once,
+// returning false if it already is.
+func (f *faults) claim(namespace string, virtualRouter string, fault Fault) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.active == nil {
+		f.active = make(map[string]*InjectedFault)
+	}
+	if _, exist := f.active[faultKey(namespace, virtualRouter, fault)]; exist {
+		return false
+	}
+	f.active[faultKey(namespace, virtualRouter, fault)] = nil
+	return true
+}
+
+// release gives up a claim the fault failed to be injected for.
+func (f *faults) release(namespace string, virtualRouter string, fault Fault) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.active, faultKey(namespace, virtualRouter, fault))
+}
+
+// start records the injected fault, reverted after the duration.
+func (f *faults) start(injected *InjectedFault, duration time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	injected.Expires = time.Now().Add(duration)
+	injected.timer = time.AfterFunc(duration, func() {
+		if err := f.revert(injected.Namespace, injected.VirtualRouter, injected.Fault); err != nil {
+			klog.ErrorS(err, "Reverting fault failed", "virtualRouter", klog.KRef(injected.Namespace, injected.VirtualRouter), "fault", injected.Fault)
+		}
+	})
+	f.active[faultKey(injected.Namespace, injected.VirtualRouter, injected.Fault)] = injected
+}
+
+// revert reverts the fault of the VirtualRouter if it is active.
+func (f *faults) revert(namespace string, virtualRouter string, fault Fault) error {
+	f.lock.Lock()
+	injected := f.active[faultKey(namespace, virtualRouter, fault)]
+	if injected == nil {
+		// not injected, or not yet
+		f.lock.Unlock()
+		return nil
+	}
+	delete(f.active, faultKey(namespace, virtualRouter, fault))
+	f.lock.Unlock()
+
+	injected.timer.Stop()
+	klog.InfoS("Reverting fault", "virtualRouter", klog.KRef(namespace, virtualRouter), "fault", fault)
+	return injected.revert()
+}
+
+// forget drops the faults injected into the router container without
+// reverting them, the container being gone.
+func (f *faults) forget(containerID string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for key, injected := range f.active {
+		if injected != nil && injected.containerID == containerID {
+			injected.timer.Stop()
+			delete(f.active, key)
+		}
+	}
+}
+
+// list returns the active faults of the namespace, of all namespaces if
+// empty, sorted.
+func (f *faults) list(namespace string) []*InjectedFault {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	list := []*InjectedFault{}
+	for _, injected := range f.active {
+		if injected != nil && (namespace == "" || injected.Namespace == namespace) {
+			list = append(list, injected)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return faultKey(list[i].Namespace, list[i].VirtualRouter, list[i].Fault) < faultKey(list[j].Namespace, list[j].VirtualRouter, list[j].Fault)
+	})
+	return list
+}
+
+// dropVIP removes the global addresses of the external interface of the
+// process, returning how to put them back.
+func dropVIP(pid int) (func() error, error) {
+	iface := DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME
+	output, err := runInRouter(pid, "", "ip", "-o", "addr", "show", "dev", iface, "scope", "global")
+	if err != nil {
+		return nil, err
+	}
+	// 3: ethext    inet 192.168.9.10/24 brd 192.168.9.255 scope global ethext
+	var addresses []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "inet" || fields[i] == "inet6" {
+				addresses = append(addresses, fields[i+1])
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no address on %s", iface)
+	}
+
+	var dropped []string
+	restore := func() error {
+		for _, address := range dropped {
+			if _, err := runInRouter(pid, "", "ip", "addr", "replace", address, "dev", iface); err != nil {
+				return err
+			}
+		}
+		// the routes of the router table through them went with them
+		return setRouterRoutes(pid, iface)
+	}
+	for _, address := range addresses {
+		if _, err := runInRouter(pid, "", "ip", "addr", "del", address, "dev", iface); err != nil {
+			restore()
+			return nil, err
+		}
+		dropped = append(dropped, address)
+	}
+	return restore, nil
+}
+
+// blackholeRules are the iptables rules dropping the packets in and out of
+// the interface
+func blackholeRules(iface string) [][]string {
+	var rules [][]string
+	for _, rule := range [][]string{
+		{"INPUT", "-i", iface},
+		{"FORWARD", "-i", iface},
+		{"FORWARD", "-o", iface},
+		{"OUTPUT", "-o", iface},
+	} {
+		rules = append(rules, append(rule, "-m", "comment", "--comment", CHAOS_RULESET, "-j", "DROP"))
+	}
+	return rules
+}
+
+// blackholeExternal drops the packets in and out of the external interface
+// of the process ahead of the rules of the router, returning how to stop.
+func blackholeExternal(pid int, backend internalNetlink.Feature) (func() error, error) {
+	iface := DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME
+	if backend == internalNetlink.FeatureNftables {
+		ruleset := fmt.Sprintf(`table inet %[1]s {
+	chain input { type filter hook input priority -400; iifname "%[2]s" drop; }
+	chain forward { type filter hook forward priority -400; iifname "%[2]s" drop; oifname "%[2]s" drop; }
+	chain output { type filter hook output priority -400; oifname "%[2]s" drop; }
+}
+`, CHAOS_RULESET, iface)
+		if _, err := runInRouter(pid, ruleset, "nft", "-f", "-"); err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := runInRouter(pid, "", "nft", "delete", "table", "inet", CHAOS_RULESET)
+			return err
+		}, nil
+	}
+
+	// the node may have no IPv6 support
+	commands := []string{"iptables"}
+	if _, err := runInRouter(pid, "", "ip6tables", "-S", "INPUT"); err == nil {
+		commands = append(commands, "ip6tables")
+	}
+	var inserted [][]string
+	stop := func() error {
+		var lastErr error
+		for _, rule := range inserted {
+			if _, err := runInRouter(pid, "", rule[0], append([]string{"-D"}, rule[1:]...)...); err != nil {
+				lastErr = err
+			}
+		}
+		return lastErr
+	}
+	for _, command := range commands {
+		for _, rule := range blackholeRules(iface) {
+			if _, err := runInRouter(pid, "", command, append([]string{"-I", rule[0], "1"}, rule[1:]...)...); err != nil {
+				stop()
+				return nil, err
+			}
+			inserted = append(inserted, append([]string{command}, rule...))
+		}
+	}
+	return stop, nil
+}
+
+// faultError is an error injecting a fault or authorizing a debug request,
+// with the HTTP status telling why
+type faultError struct {
+	status int
+	err    error
+}
+
+func (e *faultError) Error() string {
+	return e.err.Error()
+}
+
+// InjectFault injects the fault into the router container of the
+// VirtualRouter, reverting it after the duration.
+func (n *NetworkDaemon) InjectFault(namespace string, virtualRouter string, containerID string, fault Fault, duration time.Duration) (*InjectedFault, error) {
+	switch fault {
+	case FaultDropVIP, FaultBlackholeExternal, FaultFlushConntrack:
+	default:
+		return nil, &faultError{http.StatusBadRequest, fmt.Errorf("unknown fault %q, one of %s, %s or %s", fault, FaultDropVIP, FaultBlackholeExternal, FaultFlushConntrack)}
+	}
+	pid := lookupContainerPid(n, containerID)
+	if pid <= 0 {
+		return nil, &faultError{http.StatusNotFound, fmt.Errorf("wrong pid(%d) of the router container of VirtualRouter %s/%s", pid, namespace, virtualRouter)}
+	}
+
+	var err error
+	injected := &InjectedFault{Namespace: namespace, VirtualRouter: virtualRouter, Fault: fault, containerID: containerID}
+	if fault == FaultFlushConntrack {
+		klog.InfoS("Injecting fault", "virtualRouter", klog.KRef(namespace, virtualRouter), "fault", fault)
+		_, err := runInRouter(pid, "", "conntrack", "-F")
+		return injected, err
+	}
+	if !n.faults.claim(namespace, virtualRouter, fault) {
+		return nil, &faultError{http.StatusConflict, fmt.Errorf("%s is already injected into VirtualRouter %s/%s", fault, namespace, virtualRouter)}
+	}
+	klog.InfoS("Injecting fault", "virtualRouter", klog.KRef(namespace, virtualRouter), "fault", fault, "duration", duration)
+	if fault == FaultDropVIP {
+		injected.revert, err = dropVIP(pid)
+	} else if backend, ok := n.PacketFilterBackend(); ok {
+		injected.revert, err = blackholeExternal(pid, backend)
+	} else {
+		err = fmt.Errorf("no packet filter supported on this node")
+	}
+	if err != nil {
+		n.faults.release(namespace, virtualRouter, fault)
+		return nil, err
+	}
+	n.faults.start(injected, duration)
+	return injected, nil
+}
+
+// RevertFault reverts the fault injected into the router container of the
+// VirtualRouter, if it still is.
+func (n *NetworkDaemon) RevertFault(namespace string, virtualRouter string, fault Fault) error {
+	return n.faults.revert(namespace, virtualRouter, fault)
+}
+
+// routerContainerID returns the ID of the running router container of the
+// VirtualRouter on this node, from the status of its router pod.
+func (c *Controller) routerContainerID(namespace string, virtualRouter string) (string, error) {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods {
+		if pod.GetAnnotations()["customresourceName"] != virtualRouter || pod.GetAnnotations()["customresourceNamespace"] != namespace || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if containerID := runningContainerID(pod, virtualRouter); containerID != "" {
+			return containerID, nil
+		}
+	}
+	return "", &faultError{http.StatusNotFound, fmt.Errorf("no running router container of VirtualRouter %s/%s on this node", namespace, virtualRouter)}
+}
+
+// runningContainerID returns the ID of the container of the pod if it is
+// running, or an empty string.
+func runningContainerID(pod *corev1.Pod, containerName string) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		// cri-o://<id>
+		if status.Name == containerName && status.State.Running != nil {
+			if i := strings.Index(status.ContainerID, "://"); i >= 0 {
+				return status.ContainerID[i+3:]
+			}
+		}
+	}
+	return ""
+}
+
+// chaosError writes the error, with the status of a faultError.
+func chaosError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if faultErr, ok := err.(*faultError); ok {
+		status = faultErr.status
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// ChaosHandler injects faults into router containers to test failover.
+// POST /debug/chaos with the namespace and the virtualrouter, the fault and
+// a duration injects one, reverted once the duration is over, or on DELETE
+// with the namespace, the virtualrouter and the fault. GET lists the faults
+// active, of the namespace if given. Callers authenticate with a bearer
+// token, and must be allowed to create, delete or list the chaos
+// subresource of virtualrouters respectively.
+func (c *Controller) ChaosHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		namespace, virtualRouter, fault := query.Get("namespace"), query.Get("virtualrouter"), Fault(query.Get("fault"))
+		var verb string
+		switch r.Method {
+		case http.MethodGet:
+			verb = "list"
+		case http.MethodPost:
+			verb = "create"
+		case http.MethodDelete:
+			verb = "delete"
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if verb != "list" && (namespace == "" || virtualRouter == "") {
+			http.Error(w, "namespace and virtualrouter are required", http.StatusBadRequest)
+			return
+		}
+		if err := c.authorize(r, verb, CHAOS_SUBRESOURCE, namespace, virtualRouter); err != nil {
+			klog.ErrorS(err, "Authorizing chaos request failed", "method", r.Method, "virtualRouter", klog.KRef(namespace, virtualRouter))
+			chaosError(w, err)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(c.networkDaemon.faults.list(namespace))
+		case http.MethodPost:
+			duration := DEFAULT_FAULT_DURATION
+			if value := query.Get("duration"); value != "" {
+				var err error
+				if duration, err = time.ParseDuration(value); err != nil || duration <= 0 || duration > MAX_FAULT_DURATION {
+					http.Error(w, fmt.Sprintf("invalid duration %q, up to %s", value, MAX_FAULT_DURATION), http.StatusBadRequest)
+					return
+				}
+			}
+			containerID, err := c.routerContainerID(namespace, virtualRouter)
+			if err == nil {
+				var injected *InjectedFault
+				if injected, err = c.networkDaemon.InjectFault(namespace, virtualRouter, containerID, fault, duration); err == nil {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(injected)
+					return
+				}
+			}
+			klog.ErrorS(err, "Injecting fault failed", "virtualRouter", klog.KRef(namespace, virtualRouter), "fault", fault)
+			chaosError(w, err)
+		case http.MethodDelete:
+			if err := c.networkDaemon.RevertFault(namespace, virtualRouter, fault); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
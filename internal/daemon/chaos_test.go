@@ -0,0 +1,283 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+)
+
+// fakeRouter records the commands run in router containers
+type fakeRouter struct {
+	lock     sync.Mutex
+	commands []string
+}
+
+func (f *fakeRouter) run(pid int, input string, command string, args ...string) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	line := strings.Join(append([]string{command}, args...), " ")
+	f.commands = append(f.commands, line)
+	switch {
+	case strings.HasPrefix(line, "ip -o addr show"):
+		return []byte("3: ethext    inet 192.168.9.10/24 brd 192.168.9.255 scope global ethext\\       valid_lft forever preferred_lft forever\n" +
+			"3: ethext    inet6 2001:db8::10/64 scope global \\       valid_lft forever preferred_lft forever\n"), nil
+	case line == "ip6tables -S INPUT":
+		return nil, fmt.Errorf("ip6tables: exit status 3: can't initialize ip6tables table `filter'")
+	}
+	return nil, nil
+}
+
+func (f *fakeRouter) ran() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	commands := f.commands
+	f.commands = nil
+	return commands
+}
+
+func withChaosInjection(t *testing.T) *fakeRouter {
+	gate := features.DefaultMutableFeatureGate
+	pid, run, routes := lookupContainerPid, runInRouter, setRouterRoutes
+	t.Cleanup(func() {
+		features.DefaultMutableFeatureGate, features.DefaultFeatureGate = gate, gate
+		lookupContainerPid, runInRouter, setRouterRoutes = pid, run, routes
+	})
+	features.DefaultMutableFeatureGate = gate.DeepCopy()
+	features.DefaultFeatureGate = features.DefaultMutableFeatureGate
+	if err := features.DefaultMutableFeatureGate.Set("ChaosInjection=true"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := &fakeRouter{}
+	lookupContainerPid = func(n *NetworkDaemon, containerID string) int {
+		if containerID != "0123abcd" {
+			return 0
+		}
+		return 42
+	}
+	runInRouter = router.run
+	setRouterRoutes = func(pid int, iface string) error {
+		router.run(pid, "", "routes", iface)
+		return nil
+	}
+	return router
+}
+
+// newChaosController returns a controller of the daemon whose node runs
+// the router pod of VirtualRouter default/test, authenticating the tokens
+// admin, allowed everything, and viewer, allowed to list faults and get
+// rulesets only.
+func newChaosController(n *NetworkDaemon) *Controller {
+	kubeclient := fake.NewSimpleClientset()
+	kubeclient.PrependReactor("create", "tokenreviews", func(action core.Action) (bool, runtime.Object, error) {
+		review := action.(core.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "admin", "viewer":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: review.Spec.Token}}
+		default:
+			review.Status = authenticationv1.TokenReviewStatus{Error: "unknown token"}
+		}
+		return true, review, nil
+	})
+	kubeclient.PrependReactor("create", "subjectaccessreviews", func(action core.Action) (bool, runtime.Object, error) {
+		review := action.(core.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		viewed := attributes.Subresource == CHAOS_SUBRESOURCE && attributes.Verb == "list" || attributes.Subresource == RULESET_SUBRESOURCE && attributes.Verb == "get"
+		review.Status.Allowed = attributes.Group == "tmax.hypercloud.com" && attributes.Resource == "virtualrouters" &&
+			(review.Spec.User == "admin" || review.Spec.User == "viewer" && viewed)
+		return true, review, nil
+	})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-a", Namespace: "test", Annotations: map[string]string{
+			"customresourceName":      "test",
+			"customresourceNamespace": "default",
+		}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "test", ContainerID: "cri-o://0123abcd", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}},
+	})
+	return &Controller{kubeclientset: kubeclient, networkDaemon: n, podLister: corelisters.NewPodLister(indexer)}
+}
+
+func serveChaos(c *Controller, method string, path string, token string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	c.DebugHandler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestChaosDropVIP(t *testing.T) {
+	router := withChaosInjection(t)
+	n := NewDaemon(nil, nil, internalNetlink.FeatureNftables)
+	c := newChaosController(n)
+
+	if recorder := serveChaos(c, http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip&duration=10m", "admin"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the fault to be injected, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	expected := []string{
+		"ip -o addr show dev ethext scope global",
+		"ip addr del 192.168.9.10/24 dev ethext",
+		"ip addr del 2001:db8::10/64 dev ethext",
+	}
+	if commands := router.ran(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected commands %v, got %v", expected, commands)
+	}
+
+	recorder := serveChaos(c, http.MethodGet, "/debug/chaos", "viewer")
+	var active []InjectedFault
+	if err := json.NewDecoder(recorder.Body).Decode(&active); err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Namespace != "default" || active[0].VirtualRouter != "test" || active[0].Fault != FaultDropVIP {
+		t.Errorf("expected the fault to be listed, got %+v", active)
+	}
+	recorder = serveChaos(c, http.MethodGet, "/debug/chaos?namespace=other", "viewer")
+	if body := strings.TrimSpace(recorder.Body.String()); body != "[]" {
+		t.Errorf("expected no fault listed in another namespace, got %s", body)
+	}
+	if recorder := serveChaos(c, http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "admin"); recorder.Code != http.StatusConflict {
+		t.Errorf("expected a fault injected twice to conflict, got %d", recorder.Code)
+	}
+
+	// the same name in another namespace is another router
+	if err := n.RevertFault("other", "test", FaultDropVIP); err != nil {
+		t.Fatal(err)
+	}
+	if commands := router.ran(); len(commands) != 0 {
+		t.Errorf("expected nothing reverted for another namespace, got %v", commands)
+	}
+
+	if recorder := serveChaos(c, http.MethodDelete, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "admin"); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected the fault to be reverted, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	expected = []string{
+		"ip addr replace 192.168.9.10/24 dev ethext",
+		"ip addr replace 2001:db8::10/64 dev ethext",
+		"routes ethext",
+	}
+	if commands := router.ran(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected commands %v, got %v", expected, commands)
+	}
+	if active := n.faults.list(""); len(active) != 0 {
+		t.Errorf("expected no fault left, got %+v", active)
+	}
+
+	// the faults of a router container gone are forgotten
+	if _, err := n.InjectFault("default", "test", "0123abcd", FaultDropVIP, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	n.faults.forget("0123abcd")
+	if active := n.faults.list(""); len(active) != 0 {
+		t.Errorf("expected the fault to be forgotten, got %+v", active)
+	}
+}
+
+func TestChaosBlackholeExternal(t *testing.T) {
+	router := withChaosInjection(t)
+
+	n := NewDaemon(nil, nil, internalNetlink.FeatureIptables)
+	if _, err := n.InjectFault("default", "test", "0123abcd", FaultBlackholeExternal, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"ip6tables -S INPUT",
+		"iptables -I INPUT 1 -i ethext -m comment --comment virtualrouter-chaos -j DROP",
+		"iptables -I FORWARD 1 -i ethext -m comment --comment virtualrouter-chaos -j DROP",
+		"iptables -I FORWARD 1 -o ethext -m comment --comment virtualrouter-chaos -j DROP",
+		"iptables -I OUTPUT 1 -o ethext -m comment --comment virtualrouter-chaos -j DROP",
+	}
+	if commands := router.ran(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected commands %v, got %v", expected, commands)
+	}
+	if err := n.RevertFault("default", "test", FaultBlackholeExternal); err != nil {
+		t.Fatal(err)
+	}
+	if commands := router.ran(); len(commands) != 4 || commands[0] != "iptables -D INPUT -i ethext -m comment --comment virtualrouter-chaos -j DROP" {
+		t.Errorf("expected the rules to be deleted, got %v", commands)
+	}
+
+	// the fault reverts itself once over
+	n = NewDaemon(nil, nil, internalNetlink.FeatureNftables)
+	if _, err := n.InjectFault("default", "test", "0123abcd", FaultBlackholeExternal, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if commands := router.ran(); len(commands) != 1 || commands[0] != "nft -f -" {
+		t.Errorf("expected the nftables ruleset to be loaded, got %v", commands)
+	}
+	var commands []string
+	for deadline := time.Now().Add(time.Second); len(commands) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		commands = router.ran()
+	}
+	if !reflect.DeepEqual(commands, []string{"nft delete table inet virtualrouter-chaos"}) {
+		t.Errorf("expected the nftables table to be deleted once expired, got %v", commands)
+	}
+}
+
+func TestChaosHandler(t *testing.T) {
+	router := withChaosInjection(t)
+	n := NewDaemon(nil, nil, internalNetlink.FeatureNftables)
+	c := newChaosController(n)
+	for _, test := range []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=flush-conntrack", "admin", http.StatusOK},
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=reboot", "admin", http.StatusBadRequest},
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=other&fault=drop-vip", "admin", http.StatusNotFound},
+		{http.MethodPost, "/debug/chaos?namespace=other&virtualrouter=test&fault=drop-vip", "admin", http.StatusNotFound},
+		{http.MethodPost, "/debug/chaos?virtualrouter=test&fault=drop-vip", "admin", http.StatusBadRequest},
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip&duration=1h", "admin", http.StatusBadRequest},
+		{http.MethodDelete, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "admin", http.StatusNoContent},
+		{http.MethodPut, "/debug/chaos", "admin", http.StatusMethodNotAllowed},
+		// only callers allowed to are served
+		{http.MethodGet, "/debug/chaos", "", http.StatusUnauthorized},
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "unknown", http.StatusUnauthorized},
+		{http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "viewer", http.StatusForbidden},
+		{http.MethodDelete, "/debug/chaos?namespace=default&virtualrouter=test&fault=drop-vip", "viewer", http.StatusForbidden},
+	} {
+		if recorder := serveChaos(c, test.method, test.path, test.token); recorder.Code != test.code {
+			t.Errorf("%s %s as %q: expected %d, got %d: %s", test.method, test.path, test.token, test.code, recorder.Code, recorder.Body.String())
+		}
+	}
+	if commands := router.ran(); !reflect.DeepEqual(commands, []string{"conntrack -F"}) {
+		t.Errorf("expected only conntrack to be flushed, got %v", commands)
+	}
+	if active := n.faults.list(""); len(active) != 0 {
+		t.Errorf("expected nothing to revert after flushing conntrack, got %+v", active)
+	}
+
+	// the endpoint is only served with the feature gate
+	if err := features.DefaultMutableFeatureGate.Set("ChaosInjection=false"); err != nil {
+		t.Fatal(err)
+	}
+	if recorder := serveChaos(c, http.MethodPost, "/debug/chaos?namespace=default&virtualrouter=test&fault=flush-conntrack", "admin"); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected no chaos endpoint without the feature gate, got %d", recorder.Code)
+	}
+}
@@ -18,15 +18,25 @@ package daemon
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -37,6 +47,10 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
 	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
 	samplescheme "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
@@ -68,8 +82,39 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when a VirtualRouter
 	// is synced successfully
 	MessageResourceSynced = "VirtualRouter synced successfully"
+	// ErrUnsupportedFeature is used as part of the Event 'reason' and the readiness
+	// gate reason when the node can't provide a feature the VirtualRouter needs
+	ErrUnsupportedFeature = "UnsupportedDataPlaneFeature"
+	// RulesetRolledBack is used as part of the Event 'reason' when rules the
+	// daemon owns failed to apply and were put back as they were
+	RulesetRolledBack = "RulesetRolledBack"
+
+	// DataPlaneConfigured is the readiness gate reason once the full ruleset
+	// of the router is applied
+	DataPlaneConfigured = "DataPlaneConfigured"
+	// The readiness gate reasons when a step of the data plane of the router
+	// fails to apply, keeping its pods unready
+	ErrMACAddresses = "MACAddressesFailed"
+	ErrSNATPool     = "SNATPoolFailed"
+	ErrVRFEgress    = "VRFEgressFailed"
+	ErrInterNetwork = "InterNetworkRulesFailed"
+	ErrHardening    = "FirewallHardeningFailed"
+	ErrFlowOffload  = "FlowOffloadFailed"
+	ErrWireGuard    = "WireGuardFailed"
 )
 
+// PACKET_FILTER_BACKEND_ANNOTATION tells the router pod which packet filter its
+// rules are to be rendered with on this node
+const PACKET_FILTER_BACKEND_ANNOTATION string = "network.tmaxanc.com/packet-filter-backend"
+
+// PACKET_FILTER_FAMILY_ANNOTATION tells the router pod the nftables family
+// its rules are to be rendered in: ip, or inet for dual-stack routers. With
+// iptables, inet stands for rendering them with ip6tables as well.
+const PACKET_FILTER_FAMILY_ANNOTATION string = "network.tmaxanc.com/packet-filter-family"
+
+// FEATURE_LABEL_PREFIX prefixes the node labels publishing the feature matrix
+const FEATURE_LABEL_PREFIX string = "feature.network.tmaxanc.com/"
+
 type podKey string
 type virtualrouterKey string
 
@@ -79,6 +124,8 @@ type Controller struct {
 	kubeclientset kubernetes.Interface
 	// sampleclientset is a clientset for our own API group
 	sampleclientset clientset.Interface
+	// dynamicclient reads the FireWallRules the packets logged are told by
+	dynamicclient dynamic.Interface
 
 	networkDaemon *NetworkDaemon
 
@@ -91,15 +138,77 @@ type Controller struct {
 	workqueue workqueue.RateLimitingInterface
 
 	recorder record.EventRecorder
+
+	// dryRun has the daemon only report the netlink operations it would
+	// perform for every VirtualRouter
+	dryRun bool
+	// dryRunPlans are the operations last reported per router container
+	dryRunPlans   map[string]string
+	dryRunPlansMu sync.Mutex
+
+	// firewallCounterInterval is how often the firewall counters of the
+	// router pods are exported, never if 0
+	firewallCounterInterval time.Duration
+	// dnsHealthInterval is how often the DNS forwarders of the router pods
+	// are probed, never if 0
+	dnsHealthInterval time.Duration
+	// snatPoolMetricsInterval is how often the SNAT pool metrics of the
+	// router pods are updated
+	snatPoolMetricsInterval time.Duration
+	// wireGuardHandshakeInterval is how often the latest WireGuard
+	// handshakes of the router pods are exported, never if 0
+	wireGuardHandshakeInterval time.Duration
+	// dataPlaneCheckInterval is how often the data plane of the router pods
+	// is checked, never if 0
+	dataPlaneCheckInterval time.Duration
+	// reconcileInterval is how often the host links and the interfaces of
+	// the router pods are converged, never if 0
+	reconcileInterval time.Duration
+	// trafficMetricsInterval is how often the traffic metrics of the router
+	// pods autoscalers scale on are updated, never if 0
+	trafficMetricsInterval time.Duration
+	// flowExportInterval is how often the connections of the router pods
+	// are exported to their flow collectors, never if 0
+	flowExportInterval time.Duration
+	// auditRecords is how many data plane changes the audit ConfigMap of
+	// every router keeps, none if 0
+	auditRecords int
+	// captureDir is where the captures asked with CAPTURE_ANNOTATION are
+	// stored, none are taken if empty
+	captureDir string
+	// xdpProgram is the XDP object of the fast path of routers with
+	// spec.fastPath, which fall back to the packet filter if empty
+	xdpProgram string
+	// capturesStarted are the captures taken or running, not to take them
+	// again on every sync
+	capturesStarted map[string]bool
+	capturesMu      sync.Mutex
+	// fastPathInformers watch the rules the fast path is planned with, only
+	// with an xdpProgram
+	fastPathInformers dynamicinformer.DynamicSharedInformerFactory
+	fastPathListers   *virtualroutermanager.FastPathListers
 }
 
 // NewController returns a new sample controller
 func NewController(
 	kubeclientset kubernetes.Interface,
 	sampleclientset clientset.Interface,
+	dynamicclient dynamic.Interface,
 	daemon *NetworkDaemon,
 	podInformer coreinformers.PodInformer,
-	virtualRouterInformer informers.VirtualRouterInformer) *Controller {
+	virtualRouterInformer informers.VirtualRouterInformer,
+	dryRun bool,
+	firewallCounterInterval time.Duration,
+	dnsHealthInterval time.Duration,
+	snatPoolMetricsInterval time.Duration,
+	wireGuardHandshakeInterval time.Duration,
+	dataPlaneCheckInterval time.Duration,
+	reconcileInterval time.Duration,
+	trafficMetricsInterval time.Duration,
+	flowExportInterval time.Duration,
+	auditRecords int,
+	captureDir string,
+	xdpProgram string) *Controller {
 
 	// Create event broadcaster
 	// Add virtual-router types to the default Kubernetes Scheme so Events can be
@@ -114,6 +223,7 @@ func NewController(
 	controller := &Controller{
 		kubeclientset:        kubeclientset,
 		sampleclientset:      sampleclientset,
+		dynamicclient:        dynamicclient,
 		networkDaemon:        daemon,
 		podLister:            podInformer.Lister(),
 		podSynced:            podInformer.Informer().HasSynced,
@@ -121,6 +231,25 @@ func NewController(
 		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
 		workqueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "VirtualRouters"),
 		recorder:             recorder,
+		dryRun:               dryRun,
+		dryRunPlans:          map[string]string{},
+
+		firewallCounterInterval:    firewallCounterInterval,
+		dnsHealthInterval:          dnsHealthInterval,
+		snatPoolMetricsInterval:    snatPoolMetricsInterval,
+		wireGuardHandshakeInterval: wireGuardHandshakeInterval,
+		dataPlaneCheckInterval:     dataPlaneCheckInterval,
+		reconcileInterval:          reconcileInterval,
+		trafficMetricsInterval:     trafficMetricsInterval,
+		flowExportInterval:         flowExportInterval,
+		auditRecords:               auditRecords,
+		captureDir:                 captureDir,
+		xdpProgram:                 xdpProgram,
+		capturesStarted:            map[string]bool{},
+	}
+	if xdpProgram != "" && dynamicclient != nil {
+		controller.fastPathInformers = dynamicinformer.NewDynamicSharedInformerFactory(dynamicclient, 0)
+		controller.fastPathListers = virtualroutermanager.NewFastPathListers(controller.fastPathInformers)
 	}
 
 	klog.Info("Setting up event handlers")
@@ -161,12 +290,45 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	if ok := cache.WaitForCacheSync(stopCh, c.virtualRoutersSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	if c.fastPathInformers != nil {
+		c.fastPathInformers.Start(stopCh)
+		for resource, synced := range c.fastPathInformers.WaitForCacheSync(stopCh) {
+			if !synced {
+				return fmt.Errorf("failed to wait for the cache of %s to sync", resource.Resource)
+			}
+		}
+	}
 
 	klog.Info("Starting workers")
 	// Launch two workers to process VirtualRouter resources
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
+	// nothing is attached in dry run
+	if c.firewallCounterInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(firewallCountersKey{}) }, c.firewallCounterInterval, stopCh)
+	}
+	if c.dnsHealthInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(dnsHealthKey{}) }, c.dnsHealthInterval, stopCh)
+	}
+	if c.snatPoolMetricsInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(snatPoolKey{}) }, c.snatPoolMetricsInterval, stopCh)
+	}
+	if c.wireGuardHandshakeInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(wireGuardKey{}) }, c.wireGuardHandshakeInterval, stopCh)
+	}
+	if c.dataPlaneCheckInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(dataPlaneKey{}) }, c.dataPlaneCheckInterval, stopCh)
+	}
+	if c.reconcileInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(reconcileKey{}) }, c.reconcileInterval, stopCh)
+	}
+	if c.trafficMetricsInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(trafficKey{}) }, c.trafficMetricsInterval, stopCh)
+	}
+	if c.flowExportInterval > 0 && !c.dryRun {
+		go wait.Until(func() { c.workqueue.Add(flowExportKey{}) }, c.flowExportInterval, stopCh)
+	}
 
 	klog.Info("Started workers")
 	<-stopCh
@@ -200,6 +362,22 @@ func (c *Controller) processNextWorkItem() bool {
 			objName = (string)(podKey(key))
 		case virtualrouterKey:
 			objName = (string)(virtualrouterKey(key))
+		case firewallCountersKey:
+			objName = "firewall counters"
+		case dnsHealthKey:
+			objName = "DNS health"
+		case snatPoolKey:
+			objName = "SNAT pool metrics"
+		case wireGuardKey:
+			objName = "WireGuard handshakes"
+		case dataPlaneKey:
+			objName = "data plane health"
+		case reconcileKey:
+			objName = "data plane reconcile"
+		case trafficKey:
+			objName = "traffic metrics"
+		case flowExportKey:
+			objName = "flow export"
 		}
 		klog.Errorf("error syncing '%s': %s, requeuing", objName, err.Error())
 
@@ -216,6 +394,26 @@ func (c *Controller) processNextWorkItem() bool {
 // with the current status of the resource.
 func (c *Controller) syncHandler(obj interface{}) error {
 	switch key := obj.(type) {
+	case firewallCountersKey:
+		if err := c.networkDaemon.exportHardeningMetrics(); err != nil {
+			klog.ErrorS(err, "Exporting firewall hardening metrics failed")
+		}
+		c.refreshFirewallLogging()
+		return c.exportFirewallCounters()
+	case dnsHealthKey:
+		return c.exportDNSHealth()
+	case snatPoolKey:
+		return c.networkDaemon.exportSNATPoolMetrics()
+	case wireGuardKey:
+		return c.exportWireGuardHandshakes()
+	case dataPlaneKey:
+		return c.exportDataPlaneHealth()
+	case reconcileKey:
+		return c.reconcileDataPlane()
+	case trafficKey:
+		return c.exportTrafficMetrics()
+	case flowExportKey:
+		return c.networkDaemon.exportFlows()
 	case podKey:
 		namespace, name, err := cache.SplitMetaNamespaceKey(string(key))
 		if err != nil {
@@ -242,7 +440,10 @@ func (c *Controller) syncHandler(obj interface{}) error {
 			}
 			return nil
 		}
-		if !v1Pod.IsPodReady(virtualRouterPod) {
+		// The pod itself can't become Ready before the data plane is set up,
+		// as it is gated on VIRTUALROUTER_READINESS_GATE, so wait for its
+		// containers only.
+		if _, condition := v1Pod.GetPodCondition(&virtualRouterPod.Status, corev1.ContainersReady); condition == nil || condition.Status != corev1.ConditionTrue {
 			return nil
 		}
 
@@ -259,10 +460,79 @@ func (c *Controller) syncHandler(obj interface{}) error {
 			return err
 		}
 
-		if err := c.networkDaemon.AttachingPod(name, virtualRouterCR); err != nil {
+		if virtualroutermanager.IsPaused(virtualRouterCR) {
+			klog.Infof("VirtualRouter %s/%s is paused, not attaching '%s'", crNS, crName, string(key))
+			return nil
+		}
+
+		// the manager reports the invalid spec, retrying won't help
+		if err := virtualroutermanager.ValidateSpec(effectiveVirtualRouter(virtualRouterCR).Spec); err != nil {
+			klog.Infof("VirtualRouter %s/%s has an invalid spec, not attaching '%s': %v", crNS, crName, string(key), err)
+			return nil
+		}
+
+		if c.dryRun || virtualroutermanager.IsDryRun(virtualRouterCR) {
+			c.reportDryRun(virtualRouterCR)
+			return nil
+		}
+
+		operations := c.networkDaemon.Plan(virtualRouterCR.Name, effectiveVirtualRouter(virtualRouterCR).Spec)
+		ctx, span := tracing.Start(virtualRouterCR, appliedGeneration(virtualRouterPod) >= virtualRouterCR.Generation, "Attach router pod",
+			attribute.String("pod", string(key)), attribute.Int64("generation", virtualRouterCR.Generation))
+		err = tracing.Trace(ctx, "AttachingPod", func() error {
+			return c.networkDaemon.AttachingPod(name, effectiveVirtualRouter(virtualRouterCR))
+		})
+		defer span.End()
+		c.audit(virtualRouterCR, virtualRouterPod, operations, err)
+		if err != nil {
+			if unsupported, ok := err.(*UnsupportedFeatureError); ok {
+				// retrying won't help, so keep the pod unready and say why
+				klog.ErrorS(err, "VirtualRouter is not supported on this node", "pod", key)
+				c.recorder.Event(virtualRouterPod, corev1.EventTypeWarning, ErrUnsupportedFeature, unsupported.Error())
+				return c.setDataPlaneCondition(virtualRouterPod, corev1.ConditionFalse, ErrUnsupportedFeature, unsupported.Error())
+			}
 			klog.ErrorS(err, "Sync failed")
 			return err
 		}
+		routerPods := []*corev1.Pod{virtualRouterPod}
+		if err := c.networkDaemon.EnsureMACAddresses(virtualRouterCR, virtualRouterPod); err != nil {
+			klog.ErrorS(err, "Setting MAC addresses failed", "pod", key)
+			return c.failDataPlane(routerPods, ErrMACAddresses, err)
+		}
+
+		// probing is retried on the next resync rather than holding the
+		// router back
+		if err := c.networkDaemon.EnsureSLAProbe(effectiveVirtualRouter(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Starting SLA probe failed", "pod", key)
+		}
+		if err := c.networkDaemon.EnsureFlowExport(effectiveVirtualRouter(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Setting flow export failed", "pod", key)
+		}
+		if err := c.networkDaemon.EnsureFirewallLogging(effectiveVirtualRouter(virtualRouterCR), c.firewallRuleIDs(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Setting firewall logging failed", "pod", key)
+		}
+		if err := c.applyRuleset(virtualRouterCR, routerPods); err != nil {
+			klog.ErrorS(err, "Applying ruleset failed", "pod", key)
+			return err
+		}
+
+		// the pod is Ready, and the generation applied, only once the full
+		// ruleset is
+		virtualRouterPod, err = c.annotateRouterPod(virtualRouterPod, virtualRouterCR.Generation, virtualroutermanager.IsDualStack(virtualRouterCR.Spec))
+		if err != nil {
+			klog.ErrorS(err, "Annotating router pod failed", "pod", key)
+			return err
+		}
+		if err := c.setDataPlaneCondition(virtualRouterPod, corev1.ConditionTrue, DataPlaneConfigured, ""); err != nil {
+			klog.ErrorS(err, "Setting readiness gate failed", "pod", key)
+			return err
+		}
+
+		// announcing is retried on the next sync rather than holding the
+		// router back
+		if err := c.networkDaemon.EnsureAnnounced(effectiveVirtualRouter(virtualRouterCR), virtualRouterPod.Spec.NodeName); err != nil {
+			klog.ErrorS(err, "Announcing external addresses failed", "pod", key)
+		}
 
 		klog.Infof("Successfully synced '%s'", string(key))
 
@@ -284,16 +554,217 @@ func (c *Controller) syncHandler(obj interface{}) error {
 			return err
 		}
 
-		if err := c.networkDaemon.Sync(name, virtualRouterCR.Spec); err != nil {
+		if virtualroutermanager.IsPaused(virtualRouterCR) {
+			klog.Infof("VirtualRouter '%s' is paused, skipping", string(key))
+			return nil
+		}
+
+		if err := virtualroutermanager.ValidateSpec(effectiveVirtualRouter(virtualRouterCR).Spec); err != nil {
+			klog.Infof("VirtualRouter '%s' has an invalid spec, skipping: %v", string(key), err)
+			return nil
+		}
+
+		// the manager tells from the router pods whether the spec is applied
+		var routerPods []*corev1.Pod
+		pods, err := c.podLister.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		reconciled := true
+		for _, pod := range pods {
+			if pod.GetAnnotations()["customresourceName"] != name || pod.GetAnnotations()["customresourceNamespace"] != namespace || !pod.DeletionTimestamp.IsZero() {
+				continue
+			}
+			if _, condition := v1Pod.GetPodCondition(&pod.Status, corev1.ContainersReady); condition == nil || condition.Status != corev1.ConditionTrue {
+				// not attached yet, its own sync applies the spec
+				continue
+			}
+			routerPods = append(routerPods, pod)
+			if appliedGeneration(pod) < virtualRouterCR.Generation {
+				reconciled = false
+			}
+		}
+
+		if c.dryRun || virtualroutermanager.IsDryRun(virtualRouterCR) {
+			if len(routerPods) > 0 {
+				c.reportDryRun(virtualRouterCR)
+			}
+			return nil
+		}
+
+		// the router container of the node is changed, whichever pod it is of
+		var routerPod *corev1.Pod
+		if len(routerPods) > 0 {
+			routerPod = routerPods[0]
+		}
+		operations := c.networkDaemon.Plan(name, effectiveVirtualRouter(virtualRouterCR).Spec)
+		ctx, span := tracing.Start(virtualRouterCR, reconciled, "Apply VirtualRouter",
+			attribute.String("virtualrouter", string(key)), attribute.Int64("generation", virtualRouterCR.Generation))
+		err = tracing.Trace(ctx, "Sync", func() error {
+			return c.networkDaemon.Sync(name, effectiveVirtualRouter(virtualRouterCR).Spec)
+		})
+		defer span.End()
+		c.audit(virtualRouterCR, routerPod, operations, err)
+		if err != nil {
+			if unsupported, ok := err.(*UnsupportedFeatureError); ok {
+				klog.ErrorS(err, "VirtualRouter is not supported on this node", "virtualRouter", key)
+				c.recorder.Event(virtualRouterCR, corev1.EventTypeWarning, ErrUnsupportedFeature, unsupported.Error())
+				return nil
+			}
 			klog.ErrorS(err, "Sync failed")
 			return err
 		}
+		for _, pod := range routerPods {
+			if err := c.networkDaemon.EnsureMACAddresses(virtualRouterCR, pod); err != nil {
+				klog.ErrorS(err, "Setting MAC addresses failed", "pod", pod.Name)
+				return c.failDataPlane(routerPods, ErrMACAddresses, err)
+			}
+		}
+
+		if err := c.networkDaemon.EnsureSLAProbe(effectiveVirtualRouter(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Starting SLA probe failed", "virtualRouter", key)
+		}
+		if err := c.networkDaemon.EnsureFlowExport(effectiveVirtualRouter(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Setting flow export failed", "virtualRouter", key)
+		}
+		if err := c.networkDaemon.EnsureFirewallLogging(effectiveVirtualRouter(virtualRouterCR), c.firewallRuleIDs(virtualRouterCR)); err != nil {
+			klog.ErrorS(err, "Setting firewall logging failed", "virtualRouter", key)
+		}
+		if err := c.applyRuleset(virtualRouterCR, routerPods); err != nil {
+			klog.ErrorS(err, "Applying ruleset failed", "virtualRouter", key)
+			return err
+		}
+
+		for _, pod := range routerPods {
+			annotatedPod, err := c.annotateRouterPod(pod, virtualRouterCR.Generation, virtualroutermanager.IsDualStack(virtualRouterCR.Spec))
+			if err != nil {
+				klog.ErrorS(err, "Annotating router pod failed", "pod", pod.Name)
+				return err
+			}
+			if err := c.setDataPlaneCondition(annotatedPod, corev1.ConditionTrue, DataPlaneConfigured, ""); err != nil {
+				klog.ErrorS(err, "Setting readiness gate failed", "pod", pod.Name)
+				return err
+			}
+		}
+		for _, pod := range routerPods {
+			if err := c.networkDaemon.EnsureAnnounced(effectiveVirtualRouter(virtualRouterCR), pod.Spec.NodeName); err != nil {
+				klog.ErrorS(err, "Announcing external addresses failed", "virtualRouter", key)
+			}
+		}
+		c.ensureCapture(virtualRouterCR, routerPods)
 
 		klog.Infof("Successfully synced '%s'", string(key))
 	}
 	return nil
 }
 
+// applyRuleset applies the SNAT pool, VRF egress, inter-network rules,
+// firewall hardening, flow offload, WireGuard and fast path of the
+// VirtualRouter. When a step fails, the router pods are kept unready with the
+// step as the reason.
+func (c *Controller) applyRuleset(virtualRouterCR *samplev1alpha1.VirtualRouter, routerPods []*corev1.Pod) error {
+	var routerPod *corev1.Pod
+	if len(routerPods) > 0 {
+		routerPod = routerPods[0]
+	}
+	virtualRouter := effectiveVirtualRouter(virtualRouterCR)
+	rulesetOperations := c.networkDaemon.RulesetPlan(virtualRouter)
+	if err := c.networkDaemon.EnsureSNATPool(virtualRouter); err != nil {
+		c.audit(virtualRouterCR, routerPod, rulesetOperations, err)
+		return c.failDataPlane(routerPods, ErrSNATPool, fmt.Errorf("setting SNAT pool failed: %v", err))
+	}
+	if err := c.networkDaemon.EnsureVRFEgress(virtualRouter); err != nil {
+		c.audit(virtualRouterCR, routerPod, rulesetOperations, err)
+		return c.failDataPlane(routerPods, ErrVRFEgress, fmt.Errorf("setting VRF egress failed: %v", err))
+	}
+	if err := c.networkDaemon.EnsureInterNetwork(virtualRouter); err != nil {
+		c.audit(virtualRouterCR, routerPod, rulesetOperations, err)
+		return c.failDataPlane(routerPods, ErrInterNetwork, fmt.Errorf("setting inter-network rules failed: %v", err))
+	}
+	if err := c.networkDaemon.EnsureHardening(virtualRouter); err != nil {
+		c.reportRollback(virtualRouterCR, err)
+		c.audit(virtualRouterCR, routerPod, rulesetOperations, err)
+		return c.failDataPlane(routerPods, ErrHardening, fmt.Errorf("setting firewall hardening failed: %v", err))
+	}
+	if refused, err := c.networkDaemon.EnsureFlowOffload(virtualRouter); err != nil {
+		c.audit(virtualRouterCR, routerPod, rulesetOperations, err)
+		return c.failDataPlane(routerPods, ErrFlowOffload, fmt.Errorf("setting flow offload failed: %v", err))
+	} else if refused {
+		c.recorder.Event(virtualRouterCR, corev1.EventTypeWarning, HardwareOffloadUnsupported, "Interfaces of the router pod refused hardware offload, connections are offloaded in software")
+	}
+	c.audit(virtualRouterCR, routerPod, rulesetOperations, nil)
+	if err := c.ensureWireGuard(virtualRouter); err != nil {
+		return c.failDataPlane(routerPods, ErrWireGuard, fmt.Errorf("setting WireGuard failed: %v", err))
+	}
+	// the fast path falls back to the packet filter rather than failing
+	c.ensureFastPath(virtualRouter)
+	return nil
+}
+
+// failDataPlane keeps the router pods unready with the reason a step of their
+// data plane failed to apply, and returns the error to retry the step on.
+func (c *Controller) failDataPlane(routerPods []*corev1.Pod, reason string, err error) error {
+	for _, pod := range routerPods {
+		if conditionErr := c.setDataPlaneCondition(pod, corev1.ConditionFalse, reason, err.Error()); conditionErr != nil {
+			klog.ErrorS(conditionErr, "Setting readiness gate failed", "pod", klog.KObj(pod))
+		}
+	}
+	return err
+}
+
+// reportRollback records a Warning Event on the VirtualRouter when applying
+// its rules failed and they were rolled back to the last ones applied.
+func (c *Controller) reportRollback(virtualRouter *samplev1alpha1.VirtualRouter, err error) {
+	if rollback, ok := err.(*packetfilter.RollbackError); ok {
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, RulesetRolledBack, rollback.Error())
+	}
+}
+
+// reportDryRun logs the netlink operations the VirtualRouter would need on
+// this node, and records them in an Event when they differ from those last
+// reported. Nothing is applied, so the router pods stay unready.
+func (c *Controller) reportDryRun(virtualRouter *samplev1alpha1.VirtualRouter) {
+	containerName := virtualRouter.Name
+	operations := strings.Join(c.networkDaemon.Plan(containerName, effectiveVirtualRouter(virtualRouter).Spec), ", ")
+	klog.InfoS("[dry-run] Planned data plane operations", "virtualRouter", klog.KObj(virtualRouter), "operations", operations)
+
+	c.dryRunPlansMu.Lock()
+	defer c.dryRunPlansMu.Unlock()
+	if previous, ok := c.dryRunPlans[containerName]; (ok && previous == operations) || operations == "" {
+		return
+	}
+	c.dryRunPlans[containerName] = operations
+	c.recorder.Eventf(virtualRouter, corev1.EventTypeNormal, virtualroutermanager.DryRun, virtualroutermanager.MessageDryRun, operations)
+}
+
+// effectiveVirtualRouter returns the VirtualRouter with the external IP the
+// router may use: the address allocated from the IPAM when the spec leaves
+// it empty, and the last approved one while a new external IP awaits the
+// approval of the IPAM.
+func effectiveVirtualRouter(virtualRouter *samplev1alpha1.VirtualRouter) *samplev1alpha1.VirtualRouter {
+	allocation := virtualRouter.Status.IPAMAllocation
+	approval := virtualRouter.Status.ExternalIPApproval
+	useAllocation := virtualRouter.Spec.ExternalIP == "" && allocation != nil
+	useApproved := approval != nil && approval.ApprovedIP != virtualRouter.Spec.ExternalIP
+	if !useAllocation && !useApproved {
+		return virtualRouter
+	}
+
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	if useAllocation {
+		if ip, network, err := net.ParseCIDR(allocation.Address); err == nil {
+			virtualRouterCopy.Spec.ExternalIP = ip.String()
+			if virtualRouterCopy.Spec.ExternalNetmask == "" {
+				virtualRouterCopy.Spec.ExternalNetmask = net.IP(network.Mask).String()
+			}
+		}
+	}
+	if useApproved {
+		virtualRouterCopy.Spec.ExternalIP = approval.ApprovedIP
+	}
+	return virtualRouterCopy
+}
+
 // enqueueVirtualRouter takes a VirtualRouter resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than VirtualRouter.
@@ -330,6 +801,85 @@ func (c *Controller) deleteFinalizer(podName string, virtualrouterPod *corev1.Po
 	return nil
 }
 
+// setDataPlaneCondition sets the readiness gate of the router pod, letting the
+// pod become Ready once its full ruleset is applied, or keeping it
+// unready with the reason the data plane can't be set up.
+func (c *Controller) setDataPlaneCondition(virtualrouterPod *corev1.Pod, status corev1.ConditionStatus, reason string, message string) error {
+	if _, condition := v1Pod.GetPodCondition(&virtualrouterPod.Status, virtualroutermanager.VIRTUALROUTER_READINESS_GATE); condition != nil &&
+		condition.Status == status && condition.Reason == reason && condition.Message == message {
+		return nil
+	}
+	virtualrouterPodCopy := virtualrouterPod.DeepCopy()
+	v1Pod.UpdatePodCondition(&virtualrouterPodCopy.Status, &corev1.PodCondition{
+		Type:    virtualroutermanager.VIRTUALROUTER_READINESS_GATE,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	_, err := c.kubeclientset.CoreV1().Pods(virtualrouterPodCopy.Namespace).UpdateStatus(context.TODO(), virtualrouterPodCopy, v1.UpdateOptions{})
+	return err
+}
+
+// appliedGeneration returns the generation of the VirtualRouter spec last
+// applied to the data plane of the router pod, or 0 if none was.
+func appliedGeneration(virtualrouterPod *corev1.Pod) int64 {
+	generation, _ := strconv.ParseInt(virtualrouterPod.GetAnnotations()[virtualroutermanager.APPLIED_GENERATION_ANNOTATION], 10, 64)
+	return generation
+}
+
+// annotateRouterPod annotates the router pod with the packet filter picked
+// for this node, the family its rules are rendered in and the generation of
+// the VirtualRouter spec applied to its data plane, returning the pod as last
+// written.
+func (c *Controller) annotateRouterPod(virtualrouterPod *corev1.Pod, generation int64, dualStack bool) (*corev1.Pod, error) {
+	annotations := map[string]string{
+		virtualroutermanager.APPLIED_GENERATION_ANNOTATION: strconv.FormatInt(generation, 10),
+		PACKET_FILTER_FAMILY_ANNOTATION:                    "ip",
+	}
+	if dualStack {
+		annotations[PACKET_FILTER_FAMILY_ANNOTATION] = "inet"
+	}
+	if backend, ok := c.networkDaemon.PacketFilterBackend(); ok {
+		annotations[PACKET_FILTER_BACKEND_ANNOTATION] = string(backend)
+	}
+	changed := false
+	for key, value := range annotations {
+		if virtualrouterPod.GetAnnotations()[key] != value {
+			changed = true
+		}
+	}
+	if !changed {
+		return virtualrouterPod, nil
+	}
+	virtualrouterPodCopy := virtualrouterPod.DeepCopy()
+	if virtualrouterPodCopy.Annotations == nil {
+		virtualrouterPodCopy.Annotations = map[string]string{}
+	}
+	for key, value := range annotations {
+		virtualrouterPodCopy.Annotations[key] = value
+	}
+	return c.kubeclientset.CoreV1().Pods(virtualrouterPodCopy.Namespace).Update(context.TODO(), virtualrouterPodCopy, v1.UpdateOptions{})
+}
+
+// LabelNodeFeatures publishes the feature matrix as node labels, so
+// VirtualRouters can be kept off nodes lacking what they need.
+func LabelNodeFeatures(kubeclientset kubernetes.Interface, nodeName string, features internalNetlink.FeatureMatrix) error {
+	nodeLabels := map[string]string{}
+	for _, feature := range internalNetlink.Features {
+		nodeLabels[FEATURE_LABEL_PREFIX+string(feature)] = fmt.Sprintf("%t", features.Supports(feature))
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": nodeLabels,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kubeclientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
+
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
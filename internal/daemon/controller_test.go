@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	v1Pod "k8s.io/kubernetes/pkg/api/v1/pod"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+func TestFailDataPlane(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "router-0", Namespace: "default"},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: virtualroutermanager.VIRTUALROUTER_READINESS_GATE, Status: corev1.ConditionTrue, Reason: DataPlaneConfigured},
+		}},
+	}
+	kubeclient := k8sfake.NewSimpleClientset(pod)
+	c := &Controller{kubeclientset: kubeclient}
+
+	failure := errors.New("setting SNAT pool failed: no running container found")
+	if err := c.failDataPlane([]*corev1.Pod{pod}, ErrSNATPool, failure); err != failure {
+		t.Fatalf("expected the step error back, got %v", err)
+	}
+
+	updated, err := kubeclient.CoreV1().Pods("default").Get(context.TODO(), "router-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, condition := v1Pod.GetPodCondition(&updated.Status, virtualroutermanager.VIRTUALROUTER_READINESS_GATE)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != ErrSNATPool || condition.Message != failure.Error() {
+		t.Errorf("expected the readiness gate False with reason %s, got %+v", ErrSNATPool, condition)
+	}
+}
+
+// newSyncController returns a controller syncing the router pod router-0 of
+// the VirtualRouter, its containers ready, on the daemon.
+func newSyncController(n *NetworkDaemon, virtualrouter *v1.VirtualRouter) (*Controller, *k8sfake.Clientset, *record.FakeRecorder) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "router-0", Namespace: "default", Annotations: map[string]string{
+			"customresourceName":      virtualrouter.Name,
+			"customresourceNamespace": virtualrouter.Namespace,
+		}},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+		}},
+	}
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	podIndexer.Add(pod)
+	virtualRouterIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	virtualRouterIndexer.Add(virtualrouter)
+	kubeclient := k8sfake.NewSimpleClientset(pod)
+	recorder := record.NewFakeRecorder(10)
+	return &Controller{
+		kubeclientset:        kubeclient,
+		networkDaemon:        n,
+		podLister:            corelisters.NewPodLister(podIndexer),
+		virtualRoutersLister: listers.NewVirtualRouterLister(virtualRouterIndexer),
+		recorder:             recorder,
+	}, kubeclient, recorder
+}
+
+func TestSyncUnsupportedFeature(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	n.features = internalNetlink.FeatureMatrix{
+		internalNetlink.FeaturePolicyRouting: true,
+		internalNetlink.FeatureIptables:      true,
+	}
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+		},
+	}
+	c, kubeclient, recorder := newSyncController(n, virtualrouter)
+
+	// retrying won't help, so the pod is not requeued
+	if err := c.syncHandler(podKey("default/router-0")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actions := backend.Actions(); len(actions) != 0 {
+		t.Errorf("expected nothing attached, got %v", actions)
+	}
+	updated, err := kubeclient.CoreV1().Pods("default").Get(context.TODO(), "router-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, condition := v1Pod.GetPodCondition(&updated.Status, virtualroutermanager.VIRTUALROUTER_READINESS_GATE)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != ErrUnsupportedFeature || !strings.Contains(condition.Message, "nftables") {
+		t.Errorf("expected the readiness gate False with reason %s, got %+v", ErrUnsupportedFeature, condition)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, corev1.EventTypeWarning+" "+ErrUnsupportedFeature) {
+			t.Errorf("expected a %s Warning, got %q", ErrUnsupportedFeature, event)
+		}
+	default:
+		t.Errorf("expected a %s Warning", ErrUnsupportedFeature)
+	}
+}
+
+func TestSyncKeepsGateUntilRulesetApplied(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default", Generation: 2},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+			// the hardening is applied after the pod is attached, and can't
+			// be as the router container is not found
+			FirewallHardening: &v1.FirewallHardening{DropInvalid: true},
+		},
+	}
+	c, kubeclient, _ := newSyncController(n, virtualrouter)
+
+	if err := c.syncHandler(podKey("default/router-0")); err == nil {
+		t.Fatal("expected the sync to fail on the hardening")
+	}
+	if actions := backend.Actions(); len(actions) == 0 {
+		t.Fatal("expected the pod attached before the hardening")
+	}
+	updated, err := kubeclient.CoreV1().Pods("default").Get(context.TODO(), "router-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, condition := v1Pod.GetPodCondition(&updated.Status, virtualroutermanager.VIRTUALROUTER_READINESS_GATE)
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != ErrHardening {
+		t.Errorf("expected the readiness gate False with reason %s, got %+v", ErrHardening, condition)
+	}
+	if generation, ok := updated.Annotations[virtualroutermanager.APPLIED_GENERATION_ANNOTATION]; ok {
+		t.Errorf("expected no generation applied, got %s", generation)
+	}
+
+	// once the ruleset applies, the gate is passed and the generation applied
+	virtualrouter.Spec.FirewallHardening = nil
+	c, kubeclient, _ = newSyncController(n, virtualrouter)
+	if err := c.syncHandler(podKey("default/router-0")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err = kubeclient.CoreV1().Pods("default").Get(context.TODO(), "router-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, condition = v1Pod.GetPodCondition(&updated.Status, virtualroutermanager.VIRTUALROUTER_READINESS_GATE)
+	if condition == nil || condition.Status != corev1.ConditionTrue || condition.Reason != DataPlaneConfigured {
+		t.Errorf("expected the readiness gate True with reason %s, got %+v", DataPlaneConfigured, condition)
+	}
+	if generation := updated.Annotations[virtualroutermanager.APPLIED_GENERATION_ANNOTATION]; generation != "2" {
+		t.Errorf("expected generation 2 applied, got %q", generation)
+	}
+}
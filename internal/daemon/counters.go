@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// FIREWALL_RULE_CHAIN is the filter chain the router renders FireWallRules
+// into
+const FIREWALL_RULE_CHAIN string = "forward_fwrule"
+
+// firewallCountersKey asks for the firewall counters of every attached router
+// pod to be exported
+type firewallCountersKey struct{}
+
+// iptablesSave dumps the filter table, with counters, in the network
+// namespace of the process with iptables-save or ip6tables-save
+var iptablesSave = func(pid int, command string) ([]byte, error) {
+	return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", command, "-c", "-t", "filter").Output()
+}
+
+// FirewallCounters reads the counters of the firewall rules in the data plane
+// of the router pod, of both families for dual-stack routers. It returns
+// false if the pod isn't attached.
+func (n *NetworkDaemon) FirewallCounters(podName string) (map[string]virtualroutermanager.RuleCounters, bool, error) {
+	desc, exist := n.pod2containerMap[podName]
+	if !exist {
+		return nil, false, nil
+	}
+	containerID := internalCrio.GetContainerIDFromContainerName(desc.containerName, n.crioCfg)
+	if containerID == "" {
+		return nil, false, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return nil, false, fmt.Errorf("wrong pid(%d) of container %s", containerPid, desc.containerName)
+	}
+	commands := []string{"iptables-save"}
+	if spec, exist := n.runnigState[desc.containerName]; exist && virtualroutermanager.IsDualStack(*spec) {
+		commands = append(commands, "ip6tables-save")
+	}
+	counters := map[string]virtualroutermanager.RuleCounters{}
+	for _, command := range commands {
+		output, err := iptablesSave(containerPid, command)
+		if err != nil {
+			return nil, false, err
+		}
+		// rules of the two families never share a key
+		for key, sum := range parseFirewallCounters(string(output)) {
+			counters[key] = sum
+		}
+	}
+	return counters, true, nil
+}
+
+// renderedFirewallRule is a rule of FIREWALL_RULE_CHAIN, as the router
+// rendered a rule of a FireWallRule, with its counters.
+type renderedFirewallRule struct {
+	srcIP, dstIP, protocol, policy string
+	packets, bytes                 uint64
+}
+
+func (r *renderedFirewallRule) key() string {
+	return virtualroutermanager.FirewallRuleKey(r.srcIP, r.dstIP, r.protocol, r.policy)
+}
+
+// parseFirewallRules returns the rules of FIREWALL_RULE_CHAIN in
+// iptables-save -c output, in order.
+func parseFirewallRules(output string) []renderedFirewallRule {
+	var rules []renderedFirewallRule
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+		end := strings.Index(line, "]")
+		if end < 0 {
+			continue
+		}
+		var rule renderedFirewallRule
+		if _, err := fmt.Sscanf(line[1:end], "%d:%d", &rule.packets, &rule.bytes); err != nil {
+			continue
+		}
+		fields := strings.Fields(line[end+1:])
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != FIREWALL_RULE_CHAIN {
+			continue
+		}
+		negated := false
+		for i := 2; i < len(fields); i++ {
+			if fields[i] == "!" {
+				negated = true
+				continue
+			}
+			if i+1 >= len(fields) {
+				break
+			}
+			switch fields[i] {
+			case "-s":
+				rule.srcIP = fields[i+1]
+			case "-d":
+				rule.dstIP = fields[i+1]
+			case "-p":
+				rule.protocol = fields[i+1]
+			case "-j":
+				rule.policy = fields[i+1]
+			default:
+				continue
+			}
+			i++
+		}
+		// the router renders no negated matches
+		if negated || rule.policy == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// parseFirewallCounters adds up the counters of the rules of
+// FIREWALL_RULE_CHAIN in iptables-save -c output by the firewall rule they
+// were rendered from.
+func parseFirewallCounters(output string) map[string]virtualroutermanager.RuleCounters {
+	counters := map[string]virtualroutermanager.RuleCounters{}
+	for _, rule := range parseFirewallRules(output) {
+		sum := counters[rule.key()]
+		sum.Packets += rule.packets
+		sum.Bytes += rule.bytes
+		counters[rule.key()] = sum
+	}
+	return counters
+}
+
+// exportFirewallCounters annotates every attached router pod of the node with
+// the counters of its firewall rules, for the controller to report.
+func (c *Controller) exportFirewallCounters() error {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		counters, attached, err := c.networkDaemon.FirewallCounters(pod.Name)
+		if err != nil {
+			klog.ErrorS(err, "Reading firewall counters failed", "pod", pod.Namespace+"/"+pod.Name)
+			continue
+		}
+		if !attached {
+			continue
+		}
+		content, err := json.Marshal(counters)
+		if err != nil {
+			return err
+		}
+		if pod.GetAnnotations()[virtualroutermanager.FIREWALL_COUNTERS_ANNOTATION] == string(content) {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{virtualroutermanager.FIREWALL_COUNTERS_ANNOTATION: string(content)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := c.kubeclientset.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, v1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+func TestParseFirewallCounters(t *testing.T) {
+	output := `# Generated by iptables-save v1.8.4 on Mon Nov  1 00:00:00 2021
+*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [120:9600]
+:OUTPUT ACCEPT [0:0]
+:forward_fwrule - [0:0]
+[120:9600] -A FORWARD -j forward_fwrule
+[3:180] -A forward_fwrule -d 10.0.0.10/32 -p tcp -j ACCEPT
+[2:120] -A forward_fwrule -d 10.0.0.10/32 -p tcp -j ACCEPT
+[9:900] -A forward_fwrule -s 10.0.0.0/24 -j DROP
+[4:400] -A forward_fwrule ! -s 10.0.0.0/24 -j DROP
+COMMIT
+`
+	expected := map[string]virtualroutermanager.RuleCounters{
+		virtualroutermanager.FirewallRuleKey("", "10.0.0.10", "tcp", "ACCEPT"): {Packets: 5, Bytes: 300},
+		virtualroutermanager.FirewallRuleKey("10.0.0.0/24", "", "", "DROP"):    {Packets: 9, Bytes: 900},
+	}
+	if counters := parseFirewallCounters(output); !reflect.DeepEqual(counters, expected) {
+		t.Errorf("expected counters %v, got %v", expected, counters)
+	}
+}
@@ -2,10 +2,18 @@ package daemon
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
 	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
 	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
 	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 )
 
@@ -14,14 +22,66 @@ const (
 	DEFAULT_TABLE_NUMBER                           int    = 200
 	DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME string = "ethint"
 	DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME string = "ethext"
+	// INTERNAL_NETWORK_INTERFACE_PREFIX is what the interfaces of the
+	// internal networks of spec.internalNetworks are named with, after
+	// their network
+	INTERNAL_NETWORK_INTERFACE_PREFIX string = "int-"
 )
 
 type NetworkDaemon struct {
 	crioCfg          *internalCrio.CrioConfig
 	netlinkCfg       *internalNetlink.Config
+	features         internalNetlink.FeatureMatrix
 	runnigState      map[string]*v1.VirtualRouterSpec
 	pod2containerMap map[string]*containerDesc
 	vlanUse          map[int][]string
+	probes           map[string]*slaProber
+	snatPools        map[string]*snatPoolConfig
+	flowExports      map[string]*flowExportConfig
+	firewallLoggers  map[string]*firewallLogger
+	hardening        map[string]*hardeningConfig
+	wireGuards       map[string]*internalNetlink.WireGuard
+	fastPaths        map[string]*fastPathConfig
+	flowOffloads     map[string]*flowOffloadConfig
+	vrfEgress        map[string]*vrfEgressConfig
+	interNetworks    map[string]*interNetworkConfig
+	// announced are the external addresses last announced by the router
+	// containers while their pod is the active one
+	announced map[string][]string
+	// macAddresses are the internal and external MACs set on the router
+	// containers from status.macAddresses
+	macAddresses map[string][2]string
+	// nodeSysctls are the sysctls of the node set for spec.nodeSysctls, by
+	// name, and allowedNodeSysctls those routers may set
+	nodeSysctls        map[string]*nodeSysctl
+	allowedNodeSysctls []string
+	// dataPlaneHealth is the last data plane health of the attached router
+	// pods by pod name, read by the HTTP probe as well
+	dataPlaneHealth   map[string]virtualroutermanager.DataPlaneHealth
+	dataPlaneHealthMu sync.Mutex
+	// trafficSamples are the packet counters of the attached router pods
+	// the packet rates exported were last taken from
+	trafficSamples map[types.NamespacedName]packetSample
+	// packetFilterBackend is the packet filter picked by flag, autodetected
+	// if empty
+	packetFilterBackend internalNetlink.Feature
+	// netlink attaches the router containers to the node, the kernel of
+	// the node unless faked in tests
+	netlink internalNetlink.Backend
+	// faults are the faults injected into the router containers through
+	// the chaos endpoint
+	faults faults
+}
+
+// UnsupportedFeatureError is returned when the node kernel lacks a feature
+// the VirtualRouter needs, which no retry is going to fix.
+type UnsupportedFeatureError struct {
+	Feature string
+	Reason  string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("node does not support %s, which is required for %s", e.Feature, e.Reason)
 }
 
 type containerDesc struct {
@@ -29,6 +89,17 @@ type containerDesc struct {
 	containerID   string
 }
 
+// lookupContainerID and lookupContainerPid ask the container runtime of the
+// node for the router containers
+var (
+	lookupContainerID = func(n *NetworkDaemon, containerName string) string {
+		return internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	}
+	lookupContainerPid = func(n *NetworkDaemon, containerID string) int {
+		return internalCrio.GetContainerPid(containerID, n.crioCfg)
+	}
+)
+
 // type virtualrouterSpec struct {
 // 	vlan         int
 // 	internalIPs  []string
@@ -36,13 +107,30 @@ type containerDesc struct {
 // 	internalCIDR string
 // }
 
-func NewDaemon(crioCfg *internalCrio.CrioConfig, netlinkCfg *internalNetlink.Config) *NetworkDaemon {
+// NewDaemon returns a daemon rendering rules with the packet filter backend,
+// nftables or iptables, autodetected from the node if empty.
+func NewDaemon(crioCfg *internalCrio.CrioConfig, netlinkCfg *internalNetlink.Config, packetFilterBackend internalNetlink.Feature) *NetworkDaemon {
 	return &NetworkDaemon{
-		crioCfg:          crioCfg,
-		netlinkCfg:       netlinkCfg,
-		pod2containerMap: make(map[string]*containerDesc),
-		runnigState:      make(map[string]*v1.VirtualRouterSpec),
-		vlanUse:          make(map[int][]string),
+		crioCfg:             crioCfg,
+		netlinkCfg:          netlinkCfg,
+		netlink:             internalNetlink.NewBackend(),
+		packetFilterBackend: packetFilterBackend,
+		pod2containerMap:    make(map[string]*containerDesc),
+		runnigState:         make(map[string]*v1.VirtualRouterSpec),
+		vlanUse:             make(map[int][]string),
+		probes:              make(map[string]*slaProber),
+		snatPools:           make(map[string]*snatPoolConfig),
+		vrfEgress:           make(map[string]*vrfEgressConfig),
+		interNetworks:       make(map[string]*interNetworkConfig),
+		flowExports:         make(map[string]*flowExportConfig),
+		firewallLoggers:     make(map[string]*firewallLogger),
+		hardening:           make(map[string]*hardeningConfig),
+		wireGuards:          make(map[string]*internalNetlink.WireGuard),
+		fastPaths:           make(map[string]*fastPathConfig),
+		flowOffloads:        make(map[string]*flowOffloadConfig),
+		announced:           make(map[string][]string),
+		macAddresses:        make(map[string][2]string),
+		nodeSysctls:         make(map[string]*nodeSysctl),
 	}
 }
 
@@ -80,6 +168,95 @@ func (n *NetworkDaemon) Initialize() error {
 		klog.ErrorS(err, "Netlink Initialization failed")
 		return err
 	}
+
+	features, err := internalNetlink.ProbeFeatures()
+	if err != nil {
+		klog.ErrorS(err, "Feature probing failed, VirtualRouters won't be checked against node features")
+		return nil
+	}
+	n.features = features
+	klog.InfoS("Feature probing done", "features", features.String())
+	return nil
+}
+
+// Features returns the feature matrix of the node, nil if probing failed
+func (n *NetworkDaemon) Features() internalNetlink.FeatureMatrix {
+	return n.features
+}
+
+// PacketFilterBackend picks the packet filter the router should render its
+// rules with: the one given to the daemon if the node supports it, otherwise
+// nftables, falling back to legacy iptables.
+func (n *NetworkDaemon) PacketFilterBackend() (internalNetlink.Feature, bool) {
+	if n.packetFilterBackend != "" {
+		// nothing is known of a node that couldn't be probed
+		return n.packetFilterBackend, n.features == nil || n.features.Supports(n.packetFilterBackend)
+	}
+	return n.features.Select(internalNetlink.FeatureNftables, internalNetlink.FeatureIptables)
+}
+
+// packetFilter returns the backend the daemon programs its own rules with,
+// the packet filter the router renders its rules with.
+func (n *NetworkDaemon) packetFilter() (packetfilter.Backend, error) {
+	backend, ok := n.PacketFilterBackend()
+	if !ok {
+		return nil, fmt.Errorf("no packet filter supported on this node")
+	}
+	return packetfilter.New(string(backend))
+}
+
+// CheckFeatures rejects a spec the node can't realize before anything is
+// applied, rather than failing halfway through.
+func (n *NetworkDaemon) CheckFeatures(virtualrouterSpec v1.VirtualRouterSpec) error {
+	if err := n.checkNodeSysctls(virtualrouterSpec); err != nil {
+		return err
+	}
+	if n.features == nil {
+		return nil
+	}
+	if !n.features.Supports(internalNetlink.FeaturePolicyRouting) {
+		return &UnsupportedFeatureError{Feature: string(internalNetlink.FeaturePolicyRouting), Reason: "the router routing table"}
+	}
+	if virtualrouterSpec.VlanNumber != 0 && !n.features.Supports(internalNetlink.FeatureBridgeVlanFiltering) {
+		return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureBridgeVlanFiltering), Reason: fmt.Sprintf("vlan %d", virtualrouterSpec.VlanNumber)}
+	}
+	if virtualrouterSpec.WireGuard != nil && !n.features.Supports(internalNetlink.FeatureWireGuard) {
+		return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureWireGuard), Reason: "the WireGuard VPN of the router"}
+	}
+	for _, network := range virtualrouterSpec.InternalNetworks {
+		if network.VRFTable != 0 && !n.features.Supports(internalNetlink.FeatureVRF) {
+			return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureVRF), Reason: fmt.Sprintf("the VRF of internal network %s", network.Name)}
+		}
+	}
+	backend, ok := n.PacketFilterBackend()
+	if !ok {
+		feature := fmt.Sprintf("%s or %s", internalNetlink.FeatureNftables, internalNetlink.FeatureIptables)
+		if n.packetFilterBackend != "" {
+			feature = string(n.packetFilterBackend)
+		}
+		return &UnsupportedFeatureError{
+			Feature: feature,
+			Reason:  "the router firewall and NAT rules",
+		}
+	}
+	if virtualrouterSpec.FlowOffload != nil {
+		// iptables has no flowtables
+		if backend != internalNetlink.FeatureNftables {
+			return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureNftables), Reason: "the flow offload of the router"}
+		}
+		if !n.features.Supports(internalNetlink.FeatureFlowtable) {
+			return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureFlowtable), Reason: "the flow offload of the router"}
+		}
+	}
+	if virtualroutermanager.IsDualStack(virtualrouterSpec) {
+		if !n.features.Supports(internalNetlink.FeatureIPv6) {
+			return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureIPv6), Reason: "the IPv6 addresses of a dual-stack router"}
+		}
+		// nftables filters both families in inet tables
+		if backend == internalNetlink.FeatureIptables && !n.features.Supports(internalNetlink.FeatureIp6tables) {
+			return &UnsupportedFeatureError{Feature: string(internalNetlink.FeatureIp6tables), Reason: "the IPv6 firewall and NAT rules of a dual-stack router"}
+		}
+	}
 	return nil
 }
 
@@ -93,6 +270,20 @@ func (n *NetworkDaemon) ClearAll() error {
 
 func (n *NetworkDaemon) ClearContainer(containerName string, containerID string) error {
 	klog.InfoS("ClearContainer Start", "ContainerID", containerID)
+	n.StopSLAProbe(containerName)
+	n.clearSNATPool(containerName)
+	delete(n.flowExports, containerName)
+	n.stopFirewallLogging(containerName)
+	n.clearHardening(containerName)
+	n.clearFastPath(containerName)
+	delete(n.flowOffloads, containerName)
+	delete(n.vrfEgress, containerName)
+	delete(n.interNetworks, containerName)
+	delete(n.wireGuards, containerName)
+	delete(n.announced, containerName)
+	delete(n.macAddresses, containerName)
+	n.releaseNodeSysctls(containerName, nil)
+	n.faults.forget(containerID)
 	if _, exist := n.runnigState[containerName]; !exist {
 		return nil
 	}
@@ -101,14 +292,21 @@ func (n *NetworkDaemon) ClearContainer(containerName string, containerID string)
 			return err
 		}
 	}
+	if err := n.netlink.ClearInternalNetworks(containerID[:7], internalNetworks(*n.runnigState[containerName]), n.netlinkCfg); err != nil {
+		klog.ErrorS(err, "ClearInternalNetworks failed", "containerID", containerID[:7])
+		return err
+	}
 
-	if err := internalNetlink.ClearVethInterface(containerID[:7], true); err != nil {
+	if err := n.netlink.ClearVethInterface(containerID[:7], true); err != nil {
 		klog.ErrorS(err, "ClearVethInterface failed", "containerID", containerID[:7], "isInternal", true)
 		return err
 	}
-	if err := internalNetlink.ClearVethInterface(containerID[:7], false); err != nil {
-		klog.ErrorS(err, "ClearVethInterface failed", "containerID", containerID[:7], "isInternal", false)
-		return err
+	// virtual functions go back to the node with the network namespace
+	if n.runnigState[containerName].ExternalSRIOV == nil {
+		if err := n.netlink.ClearVethInterface(containerID[:7], false); err != nil {
+			klog.ErrorS(err, "ClearVethInterface failed", "containerID", containerID[:7], "isInternal", false)
+			return err
+		}
 	}
 
 	delete(n.runnigState, containerName)
@@ -120,10 +318,13 @@ func (n *NetworkDaemon) ClearContainer(containerName string, containerID string)
 func (n *NetworkDaemon) AttachingPod(podName string, virtualrouter *v1.VirtualRouter) error {
 	var containerName string
 	var err error
+	if err = n.CheckFeatures(virtualrouter.Spec); err != nil {
+		return err
+	}
 	if desc, exist := n.pod2containerMap[podName]; exist {
 		containerName = desc.containerName
 	} else {
-		containerID := internalCrio.GetContainerIDFromContainerName(virtualrouter.Name, n.crioCfg)
+		containerID := lookupContainerID(n, virtualrouter.Name)
 		if containerID == "" {
 			klog.Errorf("There is no running container with ContainerName: %s", containerName)
 			return fmt.Errorf("no running container found")
@@ -150,7 +351,12 @@ func (n *NetworkDaemon) AttachingPod(podName string, virtualrouter *v1.VirtualRo
 		klog.ErrorS(err, "Interface to Container faild", "containerName", containerName)
 		return err
 	}
-	if err = n.ConnectInterface(containerName, false); err != nil {
+	if virtualrouter.Spec.ExternalSRIOV != nil {
+		err = n.ConnectVF(containerName, virtualrouter.Spec.ExternalSRIOV)
+	} else {
+		err = n.ConnectInterface(containerName, false)
+	}
+	if err != nil {
 		klog.ErrorS(err, "Interface to Container faild", "containerName", containerName)
 		return err
 	}
@@ -177,7 +383,7 @@ func (n *NetworkDaemon) DettachingPod(podName string) error {
 	return nil
 }
 
-func (n *NetworkDaemon) Sync(containerName string, virtualrouterSpec v1.VirtualRouterSpec) error {
+func (n *NetworkDaemon) Sync(containerName string, virtualrouterSpec v1.VirtualRouterSpec) (err error) {
 	var podExist bool = false
 	for _, descs := range n.pod2containerMap {
 		if descs.containerName == containerName {
@@ -188,50 +394,48 @@ func (n *NetworkDaemon) Sync(containerName string, virtualrouterSpec v1.VirtualR
 	if !podExist {
 		return nil
 	}
-	var vlanChanged, internalIPChanged, externalIPChanged, internalNetmaskChanged, externalNetmaskChanged, gatewayIPChanged bool
+	if err := n.CheckFeatures(virtualrouterSpec); err != nil {
+		return err
+	}
 	var vlan int = int(virtualrouterSpec.VlanNumber)
 
+	var changes specChanges
+	var appliedPolicyRouting []v1.RoutingTable
+	var appliedInternalNetworks []internalNetlink.InternalNetwork
+	var appliedVRFTables []int
+	var appliedTunnels []internalNetlink.Tunnel
+	var appliedStaticNeighbors []internalNetlink.StaticNeighbor
 	if virtualrouterSpecSnapshot, exist := n.runnigState[containerName]; !exist {
 		n.runnigState[containerName] = &virtualrouterSpec
+		// the steps after a failed one were never applied, so the next
+		// sync applies it all again, adopting what is there already
+		defer func() {
+			if err != nil {
+				n.forgetAppliedSpec(containerName)
+			}
+		}()
 		if vlan != 0 {
 			n.vlanUse[vlan] = append(n.vlanUse[vlan], containerName)
-			vlanChanged = true
-		}
-		internalIPChanged = true
-		externalIPChanged = true
-		internalNetmaskChanged = true
-		externalNetmaskChanged = true
-		gatewayIPChanged = true
-		if err := n.SetRouteRule2Container(containerName, DEFAULT_MASK_NUMBER, DEFAULT_TABLE_NUMBER); err != nil {
+		}
+		changes = diffSpec(nil, virtualrouterSpec)
+		if err := n.SetRouteRule2Container(containerName, remoteNetlink.FAMILY_V4, DEFAULT_MASK_NUMBER, DEFAULT_TABLE_NUMBER); err != nil {
 			return err
 		}
 	} else {
-		if vlan != int(virtualrouterSpecSnapshot.VlanNumber) {
-			vlanChanged = true
-		}
-		if virtualrouterSpec.InternalNetmask != virtualrouterSpecSnapshot.InternalNetmask {
-			internalNetmaskChanged = true
-		}
-		if virtualrouterSpec.ExternalNetmask != virtualrouterSpecSnapshot.ExternalNetmask {
-			internalNetmaskChanged = true
-		}
-		if virtualrouterSpec.InternalIP != virtualrouterSpecSnapshot.InternalIP {
-			internalIPChanged = true
-		}
-		if virtualrouterSpec.ExternalIP != virtualrouterSpecSnapshot.ExternalIP {
-			internalIPChanged = true
-		}
-		if virtualrouterSpec.GatewayIP != virtualrouterSpecSnapshot.GatewayIP {
-			gatewayIPChanged = true
-		}
+		changes = diffSpec(virtualrouterSpecSnapshot, virtualrouterSpec)
+		appliedPolicyRouting = virtualrouterSpecSnapshot.PolicyRouting
+		appliedInternalNetworks = internalNetworks(*virtualrouterSpecSnapshot)
+		appliedVRFTables = vrfTables(*virtualrouterSpecSnapshot)
+		appliedTunnels = tunnels(*virtualrouterSpecSnapshot)
+		appliedStaticNeighbors = staticNeighbors(*virtualrouterSpecSnapshot)
 	}
 
 	// No Change
-	if !vlanChanged && !internalNetmaskChanged && !externalNetmaskChanged && !internalIPChanged && !externalIPChanged && !gatewayIPChanged {
+	if changes == (specChanges{}) {
 		return nil
 	}
 
-	if vlanChanged {
+	if changes.vlan {
 		if err := n.AssignVlan(containerName, vlan, int(n.runnigState[containerName].VlanNumber)); err != nil {
 			klog.ErrorS(err, "UnssignVlan failed", "containerName", containerName, "vlan", vlan)
 			return err
@@ -239,7 +443,7 @@ func (n *NetworkDaemon) Sync(containerName string, virtualrouterSpec v1.VirtualR
 
 	}
 
-	if internalIPChanged || internalNetmaskChanged {
+	if changes.internalIP || changes.internalNetmask {
 		if err := n.AssignIPaddress(containerName, virtualrouterSpec.InternalIP, virtualrouterSpec.InternalNetmask, true); err != nil {
 			klog.ErrorS(err, "AssignIPAddress failed", "containerName", containerName, "IPs", virtualrouterSpec.InternalIP)
 			return err
@@ -247,64 +451,550 @@ func (n *NetworkDaemon) Sync(containerName string, virtualrouterSpec v1.VirtualR
 
 	}
 
-	if externalIPChanged || externalNetmaskChanged {
+	if changes.externalIP || changes.externalNetmask {
 		if err := n.AssignIPaddress(containerName, virtualrouterSpec.ExternalIP, virtualrouterSpec.ExternalNetmask, false); err != nil {
 			klog.ErrorS(err, "AssignVlan failed", "containerName", containerName, "IPs", virtualrouterSpec.ExternalIP)
 			return err
 		}
 	}
 
-	if gatewayIPChanged {
-		if err := n.SetDefaultRoute2Container(containerName, virtualrouterSpec.GatewayIP); err != nil {
+	if changes.gatewayIP {
+		if err := n.SetDefaultRoute2Container(containerName, remoteNetlink.FAMILY_V4, virtualrouterSpec.GatewayIP); err != nil {
 			klog.ErrorS(err, "SetRoute2Container failed", "containerName", containerName, "gatewayIP", virtualrouterSpec.GatewayIP)
 			return err
 		}
 	}
 
+	if changes.internalIPv6 {
+		if err := n.AssignIPv6Address(containerName, virtualrouterSpec.InternalIPv6CIDR, true); err != nil {
+			klog.ErrorS(err, "AssignIPv6Address failed", "containerName", containerName, "IPs", virtualrouterSpec.InternalIPv6CIDR)
+			return err
+		}
+	}
+
+	if changes.externalIPv6 {
+		if err := n.AssignIPv6Address(containerName, virtualrouterSpec.ExternalIPv6CIDR, false); err != nil {
+			klog.ErrorS(err, "AssignIPv6Address failed", "containerName", containerName, "IPs", virtualrouterSpec.ExternalIPv6CIDR)
+			return err
+		}
+	}
+
+	if (changes.internalIPv6 || changes.externalIPv6) && virtualroutermanager.IsDualStack(virtualrouterSpec) {
+		if err := n.SetRouteRule2Container(containerName, remoteNetlink.FAMILY_V6, DEFAULT_MASK_NUMBER, DEFAULT_TABLE_NUMBER); err != nil {
+			return err
+		}
+	}
+
+	if changes.gatewayIPv6 {
+		if err := n.SetDefaultRoute2Container(containerName, remoteNetlink.FAMILY_V6, virtualrouterSpec.GatewayIPv6); err != nil {
+			klog.ErrorS(err, "SetRoute2Container failed", "containerName", containerName, "gatewayIPv6", virtualrouterSpec.GatewayIPv6)
+			return err
+		}
+	}
+
+	if changes.internalNetworks {
+		if err := n.SetInternalNetworks(containerName, appliedInternalNetworks, internalNetworks(virtualrouterSpec)); err != nil {
+			klog.ErrorS(err, "SetInternalNetworks failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	// the VRFs are routed out once their networks are in place
+	if changes.vrfRoutes {
+		if err := n.SetVRFRoutes(containerName, appliedVRFTables, vrfTables(virtualrouterSpec), virtualrouterSpec.GatewayIP); err != nil {
+			klog.ErrorS(err, "SetVRFRoutes failed", "containerName", containerName, "gatewayIP", virtualrouterSpec.GatewayIP)
+			return err
+		}
+	}
+
+	if changes.policyRouting {
+		if err := n.SetPolicyRouting(containerName, appliedPolicyRouting, virtualrouterSpec.PolicyRouting); err != nil {
+			klog.ErrorS(err, "SetPolicyRouting failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.qos {
+		if err := n.SetQoS(containerName, virtualrouterSpec.QoS); err != nil {
+			klog.ErrorS(err, "SetQoS failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.tunnels {
+		if err := n.SetTunnels(containerName, appliedTunnels, tunnels(virtualrouterSpec)); err != nil {
+			klog.ErrorS(err, "SetTunnels failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.staticNeighbors {
+		if err := n.SetStaticNeighbors(containerName, appliedStaticNeighbors, staticNeighbors(virtualrouterSpec)); err != nil {
+			klog.ErrorS(err, "SetStaticNeighbors failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.externalVF {
+		if err := n.ConnectVF(containerName, virtualrouterSpec.ExternalSRIOV); err != nil {
+			klog.ErrorS(err, "ConnectVF failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.mtu {
+		if err := n.SetMTU(containerName, virtualrouterSpec); err != nil {
+			klog.ErrorS(err, "SetMTU failed", "containerName", containerName)
+			return err
+		}
+	}
+
+	if changes.nodeSysctls {
+		if err := n.SetNodeSysctls(containerName, virtualrouterSpec.NodeSysctls); err != nil {
+			klog.ErrorS(err, "SetNodeSysctls failed", "containerName", containerName)
+			return err
+		}
+	}
+
 	n.runnigState[containerName] = &virtualrouterSpec
 	return nil
 }
 
-func (n *NetworkDaemon) SetRouteRule2Container(containerName string, markNumber int, tableNumber int) error {
+// SetTunnels replaces the tunnels applied to the container with the given
+// ones.
+func (n *NetworkDaemon) SetTunnels(containerName string, applied []internalNetlink.Tunnel, tunnels []internalNetlink.Tunnel) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := internalNetlink.SetTunnels2Container(containerPid, applied, tunnels, DEFAULT_TABLE_NUMBER); err != nil {
+		klog.ErrorS(err, "Set tunnels to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// tunnels returns the tunnels of the spec, sent from the external IP unless
+// given another local address
+func tunnels(virtualrouterSpec v1.VirtualRouterSpec) []internalNetlink.Tunnel {
+	var tunnels []internalNetlink.Tunnel
+	for _, tunnel := range virtualrouterSpec.Tunnels {
+		local := tunnel.Local
+		if local == "" {
+			local = virtualrouterSpec.ExternalIP
+		}
+		tunnels = append(tunnels, internalNetlink.Tunnel{
+			Name:    tunnel.Name,
+			Mode:    strings.ToLower(string(tunnel.Mode)),
+			Local:   local,
+			Remote:  tunnel.Remote,
+			Key:     uint32(tunnel.Key),
+			TTL:     uint8(tunnel.TTL),
+			Address: tunnel.Address,
+			Routes:  tunnel.Routes,
+		})
+	}
+	return tunnels
+}
+
+// SetInternalNetworks replaces the internal networks applied to the container
+// with the given ones.
+func (n *NetworkDaemon) SetInternalNetworks(containerName string, applied []internalNetlink.InternalNetwork, networks []internalNetlink.InternalNetwork) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := n.netlink.SetInternalNetworks2Container(containerPid, containerID[:7], applied, networks, DEFAULT_TABLE_NUMBER, n.netlinkCfg); err != nil {
+		klog.ErrorS(err, "Set internal networks to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// SetVRFRoutes replaces the egress of the VRF tables applied to the container
+// with a default route via the gateway in each of the given ones.
+func (n *NetworkDaemon) SetVRFRoutes(containerName string, applied []int, tables []int, gatewayIP string) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := n.netlink.SetVRFRoutes2Container(containerPid, applied, tables, gatewayIP, VRF_EGRESS_MARK_OFFSET); err != nil {
+		klog.ErrorS(err, "Set VRF routes to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// internalNetworks returns the internal networks of the spec besides that of
+// the internal interface
+func internalNetworks(virtualrouterSpec v1.VirtualRouterSpec) []internalNetlink.InternalNetwork {
+	var networks []internalNetlink.InternalNetwork
+	for _, network := range virtualrouterSpec.InternalNetworks {
+		networks = append(networks, internalNetlink.InternalNetwork{
+			Link:     INTERNAL_NETWORK_INTERFACE_PREFIX + network.Name,
+			Vlan:     int(network.VlanNumber),
+			CIDR:     network.CIDR,
+			VRFTable: int(network.VRFTable),
+		})
+	}
+	return networks
+}
+
+// SetQoS replaces the traffic shaping applied to the container with that of
+// the spec.
+func (n *NetworkDaemon) SetQoS(containerName string, qos *v1.QoS) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	ingress, egress := qosLimits(qos)
+	if err := internalNetlink.SetQoS2Container(containerPid, ingress, egress); err != nil {
+		klog.ErrorS(err, "Set QoS to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// qosLimits returns the ingress and egress limits of the spec, rates in bits
+// per second and bursts in bytes
+func qosLimits(qos *v1.QoS) (*internalNetlink.QoSLimit, *internalNetlink.QoSLimit) {
+	if qos == nil {
+		return nil, nil
+	}
+	return qosLimit(qos.Ingress), qosLimit(qos.Egress)
+}
+
+func qosLimit(limit *v1.QoSLimit) *internalNetlink.QoSLimit {
+	if limit == nil {
+		return nil
+	}
+	qosLimit := &internalNetlink.QoSLimit{Rate: uint64(limit.Rate.Value())}
+	if limit.Burst != nil {
+		qosLimit.Burst = uint32(limit.Burst.Value())
+	}
+	for _, class := range limit.Classes {
+		qosClass := internalNetlink.QoSClass{CIDR: class.CIDR, Rate: uint64(class.Rate.Value())}
+		if class.Ceil != nil {
+			qosClass.Ceil = uint64(class.Ceil.Value())
+		}
+		qosLimit.Classes = append(qosLimit.Classes, qosClass)
+	}
+	return qosLimit
+}
+
+// SetPolicyRouting replaces the secondary routing tables applied to the
+// container with those of the spec.
+func (n *NetworkDaemon) SetPolicyRouting(containerName string, applied []v1.RoutingTable, tables []v1.RoutingTable) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := internalNetlink.SetPolicyRouting2Container(containerPid, policyTables(applied), policyTables(tables)); err != nil {
+		klog.ErrorS(err, "Set policy routing to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+func policyTables(tables []v1.RoutingTable) []internalNetlink.PolicyTable {
+	var policyTables []internalNetlink.PolicyTable
+	for _, table := range tables {
+		policyTable := internalNetlink.PolicyTable{ID: int(table.ID)}
+		for _, route := range table.Routes {
+			policyTable.Routes = append(policyTable.Routes, internalNetlink.PolicyRoute{Dst: route.Destination, Gw: route.Gateway})
+		}
+		for _, rule := range table.Rules {
+			policyTable.Rules = append(policyTable.Rules, internalNetlink.PolicyRule{Src: rule.Source, Mark: int(rule.Mark), Priority: int(rule.Priority)})
+		}
+		policyTables = append(policyTables, policyTable)
+	}
+	return policyTables
+}
+
+// specChanges are the parts of the data plane of a router Sync sets up again.
+type specChanges struct {
+	vlan, internalIP, externalIP, internalNetmask, externalNetmask, gatewayIP bool
+	internalIPv6, externalIPv6, gatewayIPv6                                   bool
+	policyRouting, qos, tunnels, staticNeighbors, mtu                         bool
+	externalVF, nodeSysctls, internalNetworks, vrfRoutes                      bool
+}
+
+// diffSpec returns what Sync sets up for the spec given the spec last
+// applied, nil for a router set up for the first time.
+func diffSpec(applied *v1.VirtualRouterSpec, virtualrouterSpec v1.VirtualRouterSpec) specChanges {
+	if applied == nil {
+		return specChanges{
+			vlan:             virtualrouterSpec.VlanNumber != 0,
+			internalIP:       true,
+			externalIP:       true,
+			internalNetmask:  true,
+			externalNetmask:  true,
+			gatewayIP:        true,
+			internalIPv6:     virtualrouterSpec.InternalIPv6CIDR != "",
+			externalIPv6:     virtualrouterSpec.ExternalIPv6CIDR != "",
+			gatewayIPv6:      virtualrouterSpec.GatewayIPv6 != "",
+			policyRouting:    len(virtualrouterSpec.PolicyRouting) > 0,
+			qos:              virtualrouterSpec.QoS != nil,
+			tunnels:          len(virtualrouterSpec.Tunnels) > 0,
+			staticNeighbors:  len(virtualrouterSpec.StaticNeighbors) > 0,
+			mtu:              virtualrouterSpec.MTU != nil,
+			nodeSysctls:      len(virtualrouterSpec.NodeSysctls) > 0,
+			internalNetworks: len(virtualrouterSpec.InternalNetworks) > 0,
+			vrfRoutes:        len(vrfTables(virtualrouterSpec)) > 0,
+		}
+	}
+	var changes specChanges
+	if virtualrouterSpec.VlanNumber != applied.VlanNumber {
+		changes.vlan = true
+	}
+	if virtualrouterSpec.InternalNetmask != applied.InternalNetmask {
+		changes.internalNetmask = true
+	}
+	if virtualrouterSpec.ExternalNetmask != applied.ExternalNetmask {
+		changes.internalNetmask = true
+	}
+	if virtualrouterSpec.InternalIP != applied.InternalIP {
+		changes.internalIP = true
+	}
+	if virtualrouterSpec.ExternalIP != applied.ExternalIP {
+		changes.internalIP = true
+	}
+	if virtualrouterSpec.GatewayIP != applied.GatewayIP {
+		changes.gatewayIP = true
+	}
+	if virtualrouterSpec.InternalIPv6CIDR != applied.InternalIPv6CIDR {
+		changes.internalIPv6 = true
+	}
+	if virtualrouterSpec.ExternalIPv6CIDR != applied.ExternalIPv6CIDR {
+		changes.externalIPv6 = true
+	}
+	// the default route goes with the addresses it is reached through
+	if virtualrouterSpec.GatewayIPv6 != applied.GatewayIPv6 || changes.externalIPv6 {
+		changes.gatewayIPv6 = true
+	}
+	if !reflect.DeepEqual(virtualrouterSpec.PolicyRouting, applied.PolicyRouting) {
+		changes.policyRouting = true
+	}
+	// quantities are compared by value, 100M and 100000k are the same rate
+	appliedIngress, appliedEgress := qosLimits(applied.QoS)
+	ingress, egress := qosLimits(virtualrouterSpec.QoS)
+	if !reflect.DeepEqual(ingress, appliedIngress) || !reflect.DeepEqual(egress, appliedEgress) {
+		changes.qos = true
+	}
+	// tunnels are sent from the external IP unless given a local address
+	if !reflect.DeepEqual(tunnels(virtualrouterSpec), tunnels(*applied)) {
+		changes.tunnels = true
+	}
+	// neighbors on the external interface by default
+	if !reflect.DeepEqual(staticNeighbors(virtualrouterSpec), staticNeighbors(*applied)) {
+		changes.staticNeighbors = true
+	}
+	// the path is probed again once it leads elsewhere
+	pathChanged := virtualrouterSpec.ExternalIP != applied.ExternalIP || virtualrouterSpec.GatewayIP != applied.GatewayIP
+	if !reflect.DeepEqual(virtualrouterSpec.MTU, applied.MTU) || virtualrouterSpec.MTU != nil && virtualrouterSpec.MTU.Auto && pathChanged {
+		changes.mtu = true
+	}
+	// routers taking a virtual function on or off are rolled out anew
+	if virtualrouterSpec.ExternalSRIOV != nil && applied.ExternalSRIOV != nil && !reflect.DeepEqual(virtualrouterSpec.ExternalSRIOV, applied.ExternalSRIOV) {
+		changes.externalVF = true
+	}
+	if !reflect.DeepEqual(virtualrouterSpec.NodeSysctls, applied.NodeSysctls) {
+		changes.nodeSysctls = true
+	}
+	if !reflect.DeepEqual(internalNetworks(virtualrouterSpec), internalNetworks(*applied)) {
+		changes.internalNetworks = true
+	}
+	// the VRFs are routed out through the gateway of the router
+	tables, appliedTables := vrfTables(virtualrouterSpec), vrfTables(*applied)
+	if !reflect.DeepEqual(tables, appliedTables) || len(tables) > 0 && (changes.gatewayIP || changes.internalNetworks) {
+		changes.vrfRoutes = true
+	}
+	return changes
+}
+
+// Plan returns the netlink operations AttachingPod and Sync would perform
+// for the spec, without performing them.
+func (n *NetworkDaemon) Plan(containerName string, virtualrouterSpec v1.VirtualRouterSpec) []string {
+	var operations []string
+	applied, exist := n.runnigState[containerName]
+	if !exist {
+		operations = append(operations,
+			fmt.Sprintf("connect internal interface %s", DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME),
+			fmt.Sprintf("connect external interface %s", DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME+externalVFPlan(virtualrouterSpec.ExternalSRIOV)),
+			fmt.Sprintf("set route rule mark %d table %d", DEFAULT_MASK_NUMBER, DEFAULT_TABLE_NUMBER))
+	}
+	changes := diffSpec(applied, virtualrouterSpec)
+	if changes.vlan {
+		var oldVlan int32
+		if applied != nil {
+			oldVlan = applied.VlanNumber
+		}
+		operations = append(operations, fmt.Sprintf("assign vlan %d (was %d)", virtualrouterSpec.VlanNumber, oldVlan))
+	}
+	if changes.internalIP || changes.internalNetmask {
+		operations = append(operations, fmt.Sprintf("assign internal address %s/%s", virtualrouterSpec.InternalIP, virtualrouterSpec.InternalNetmask))
+	}
+	if changes.externalIP || changes.externalNetmask {
+		operations = append(operations, fmt.Sprintf("assign external address %s/%s", virtualrouterSpec.ExternalIP, virtualrouterSpec.ExternalNetmask))
+	}
+	if changes.gatewayIP {
+		operations = append(operations, fmt.Sprintf("set default route via %s", virtualrouterSpec.GatewayIP))
+	}
+	if changes.internalIPv6 {
+		operations = append(operations, fmt.Sprintf("assign internal IPv6 address %s", virtualrouterSpec.InternalIPv6CIDR))
+	}
+	if changes.externalIPv6 {
+		operations = append(operations, fmt.Sprintf("assign external IPv6 address %s", virtualrouterSpec.ExternalIPv6CIDR))
+	}
+	if changes.gatewayIPv6 {
+		operations = append(operations, fmt.Sprintf("set IPv6 default route via %s", virtualrouterSpec.GatewayIPv6))
+	}
+	if changes.internalNetworks {
+		var networks []string
+		for _, network := range internalNetworks(virtualrouterSpec) {
+			description := fmt.Sprintf("%s in vlan %d with %s", network.Link, network.Vlan, network.CIDR)
+			if network.VRFTable != 0 {
+				description += fmt.Sprintf(" in vrf table %d", network.VRFTable)
+			}
+			networks = append(networks, description)
+		}
+		operations = append(operations, fmt.Sprintf("set internal networks [%s]", strings.Join(networks, ", ")))
+	}
+	if changes.vrfRoutes {
+		var tables []string
+		for _, table := range vrfTables(virtualrouterSpec) {
+			tables = append(tables, strconv.Itoa(table))
+		}
+		operations = append(operations, fmt.Sprintf("set vrf default routes via %s in tables [%s]", virtualrouterSpec.GatewayIP, strings.Join(tables, ", ")))
+	}
+	if changes.policyRouting {
+		var ids []string
+		for _, table := range virtualrouterSpec.PolicyRouting {
+			ids = append(ids, strconv.Itoa(int(table.ID)))
+		}
+		operations = append(operations, fmt.Sprintf("set policy routing tables [%s]", strings.Join(ids, ", ")))
+	}
+	if changes.qos {
+		ingress, egress := qosLimits(virtualrouterSpec.QoS)
+		operations = append(operations, fmt.Sprintf("set QoS ingress %s, egress %s", qosPlan(ingress), qosPlan(egress)))
+	}
+	if changes.tunnels {
+		var names []string
+		for _, tunnel := range tunnels(virtualrouterSpec) {
+			names = append(names, fmt.Sprintf("%s %s to %s", tunnel.Name, tunnel.Mode, tunnel.Remote))
+		}
+		operations = append(operations, fmt.Sprintf("set tunnels [%s]", strings.Join(names, ", ")))
+	}
+	if changes.staticNeighbors {
+		var neighbors []string
+		for _, neighbor := range staticNeighbors(virtualrouterSpec) {
+			interfaceName := DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME
+			if neighbor.Internal {
+				interfaceName = DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME
+			}
+			neighbors = append(neighbors, fmt.Sprintf("%s at %s on %s", neighbor.IP, neighbor.MAC, interfaceName))
+		}
+		operations = append(operations, fmt.Sprintf("set static neighbors [%s]", strings.Join(neighbors, ", ")))
+	}
+	if changes.externalVF {
+		operations = append(operations, fmt.Sprintf("set up external interface %s", DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME+externalVFPlan(virtualrouterSpec.ExternalSRIOV)))
+	}
+	if changes.mtu {
+		operations = append(operations, fmt.Sprintf("set MTU %s", mtuPlan(virtualrouterSpec.MTU)))
+	}
+	if changes.nodeSysctls {
+		operations = append(operations, fmt.Sprintf("set node sysctls [%s]", nodeSysctlsPlan(virtualrouterSpec.NodeSysctls)))
+	}
+	return operations
+}
+
+func qosPlan(limit *internalNetlink.QoSLimit) string {
+	if limit == nil {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d bit/s with %d classes", limit.Rate, len(limit.Classes))
+}
+
+func (n *NetworkDaemon) SetRouteRule2Container(containerName string, family int, markNumber int, tableNumber int) error {
 	var containerID string
 	var containerPid int
 
-	containerID = internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	containerID = lookupContainerID(n, containerName)
 	if containerID == "" {
 		klog.Errorf("There is no running container with ContainerName: %s", containerName)
 		return fmt.Errorf("no running container found")
 	}
 
-	containerPid = internalCrio.GetContainerPid(containerID, n.crioCfg)
+	containerPid = lookupContainerPid(n, containerID)
 	if containerPid <= 0 {
 		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
 		return fmt.Errorf("internal error")
 	}
 
-	if err := internalNetlink.SetRouteRule2Container(containerPid, markNumber, tableNumber); err != nil {
+	if err := n.netlink.SetRouteRule2Container(containerPid, family, markNumber, tableNumber); err != nil {
 		klog.ErrorS(err, "Set Route rule to Container failed", "ContainerName", containerName, "ContainerID", containerID)
 		return err
 	}
 	return nil
 }
 
-func (n *NetworkDaemon) SetDefaultRoute2Container(containerName string, gatewayIP string) error {
+func (n *NetworkDaemon) SetDefaultRoute2Container(containerName string, family int, gatewayIP string) error {
 	var containerID string
 	var containerPid int
 
-	containerID = internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	containerID = lookupContainerID(n, containerName)
 	if containerID == "" {
 		klog.Errorf("There is no running container with ContainerName: %s", containerName)
 		return fmt.Errorf("no running container found")
 	}
 
-	containerPid = internalCrio.GetContainerPid(containerID, n.crioCfg)
+	containerPid = lookupContainerPid(n, containerID)
 	if containerPid <= 0 {
 		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
 		return fmt.Errorf("internal error")
 	}
 
-	if err := internalNetlink.SetDefaultRoute2Container(containerPid, gatewayIP, DEFAULT_TABLE_NUMBER); err != nil {
+	if err := n.netlink.SetDefaultRoute2Container(containerPid, family, gatewayIP, DEFAULT_TABLE_NUMBER); err != nil {
 		klog.ErrorS(err, "Set Routing rule to Container failed", "ContainerName", containerName, "ContainerID", containerID)
 		return err
 	}
@@ -312,13 +1002,13 @@ func (n *NetworkDaemon) SetDefaultRoute2Container(containerName string, gatewayI
 }
 
 func (n *NetworkDaemon) AssignVlan(containerName string, newVlan int, oldVlan int) error {
-	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	containerID := lookupContainerID(n, containerName)
 	if containerID == "" {
 		klog.Errorf("There is no running container with ContainerName: %s", containerName)
 		return fmt.Errorf("no running container found")
 	}
 
-	if err := internalNetlink.SetVlan("int"+containerID[:7], newVlan, oldVlan, n.netlinkCfg); err != nil {
+	if err := n.netlink.SetVlan("int"+containerID[:7], newVlan, oldVlan, n.netlinkCfg); err != nil {
 		klog.ErrorS(err, "SetVlan failed", "vlan", newVlan)
 		return err
 	}
@@ -329,19 +1019,19 @@ func (n *NetworkDaemon) AssignIPaddress(containerName string, ip string, netmask
 	var containerID string
 	var containerPid int
 
-	containerID = internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	containerID = lookupContainerID(n, containerName)
 	if containerID == "" {
 		klog.Errorf("There is no running container with ContainerName: %s", containerName)
 		return fmt.Errorf("no running container found")
 	}
 
-	containerPid = internalCrio.GetContainerPid(containerID, n.crioCfg)
+	containerPid = lookupContainerPid(n, containerID)
 	if containerPid <= 0 {
 		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
 		return fmt.Errorf("internal error")
 	}
 
-	if err := internalNetlink.SetIPaddress2Container(containerPid, ip, netmask, isInternal); err != nil {
+	if err := n.netlink.SetIPaddress2Container(containerPid, ip, netmask, isInternal); err != nil {
 		klog.ErrorS(err, "Set Interface to Container failed", "ContainerName", containerName, "ContainerID", containerID)
 		return err
 	}
@@ -352,7 +1042,7 @@ func (n *NetworkDaemon) AssignIPaddress(containerName string, ip string, netmask
 	} else {
 		interfaceName = DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME
 	}
-	if err := internalNetlink.SetRoute2Container(containerPid, interfaceName, DEFAULT_TABLE_NUMBER); err != nil {
+	if err := n.netlink.SetRoute2Container(containerPid, interfaceName, DEFAULT_TABLE_NUMBER); err != nil {
 		klog.ErrorS(err, "Set Interface to Container failed", "ContainerName", containerName, "ContainerID", containerID)
 		return err
 	}
@@ -360,23 +1050,54 @@ func (n *NetworkDaemon) AssignIPaddress(containerName string, ip string, netmask
 	return nil
 }
 
+// AssignIPv6Address replaces the IPv6 address of the internal or external
+// interface of the container, and the routes of the router table through it.
+func (n *NetworkDaemon) AssignIPv6Address(containerName string, cidr string, isInternal bool) error {
+	containerID := lookupContainerID(n, containerName)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := lookupContainerPid(n, containerID)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := n.netlink.SetIPv6Address2Container(containerPid, cidr, isInternal); err != nil {
+		klog.ErrorS(err, "Set IPv6 address to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+
+	interfaceName := DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME
+	if isInternal {
+		interfaceName = DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME
+	}
+	if err := n.netlink.SetRoute2Container(containerPid, interfaceName, DEFAULT_TABLE_NUMBER); err != nil {
+		klog.ErrorS(err, "Set Interface to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
 func (n *NetworkDaemon) ConnectInterface(containerName string, isInternal bool) error {
 	var containerID string
 	var containerPid int
 
-	containerID = internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	containerID = lookupContainerID(n, containerName)
 	if containerID == "" {
 		klog.Errorf("There is no running container with ContainerName: %s", containerName)
 		return fmt.Errorf("no running container found")
 	}
 
-	containerPid = internalCrio.GetContainerPid(containerID, n.crioCfg)
+	containerPid = lookupContainerPid(n, containerID)
 	if containerPid <= 0 {
 		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
 		return fmt.Errorf("internal error")
 	}
 
-	if err := internalNetlink.SetInterface2Container(containerPid, containerID[:7], isInternal, n.netlinkCfg); err != nil {
+	if err := n.netlink.SetInterface2Container(containerPid, containerID[:7], isInternal, n.netlinkCfg); err != nil {
 		klog.ErrorS(err, "Set Interface to Container failed", "ContainerName", containerName, "ContainerID", containerID)
 		return err
 	}
@@ -1,23 +1,33 @@
 package daemon_test
 
 import (
-	"fmt"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	daemon "github.com/tmax-cloud/virtualrouter-controller/internal/daemon"
 	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
 	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var _ daemon.NetworkDaemon
 
 func TestDaemonInitialize(t *testing.T) {
+	const crioSocket = "/var/run/crio/crio.sock"
+	if os.Geteuid() != 0 {
+		t.Skip("not running as root, the bridges and interfaces can't be created")
+	}
+	if _, err := os.Stat(crioSocket); err != nil {
+		t.Skipf("no CRI-O on this node: %v", err)
+	}
 	d := daemon.NewDaemon(
 		&crio.CrioConfig{
-			RuntimeEndpoint:      "unix:///var/run/crio/crio.sock",
+			RuntimeEndpoint:      "unix://" + crioSocket,
 			RuntimeEndpointIsSet: true,
-			ImageEndpoint:        "unix:///var/run/crio/crio.sock",
+			ImageEndpoint:        "unix://" + crioSocket,
 			ImageEndpointIsSet:   true,
 			Timeout:              time.Duration(2000000000),
 		}, &netlink.Config{
@@ -27,24 +37,68 @@ func TestDaemonInitialize(t *testing.T) {
 			NewExternalInterfaceName: "extif",
 			InternalBridgeName:       "intbr",
 			ExternalBridgeName:       "extbr",
-		})
+		}, "")
 	if err := d.Initialize(); err != nil {
-		fmt.Errorf("Error: %+v", err)
+		t.Fatalf("Initialize failed: %v", err)
 	}
-
-	// crio.GetContainerIDFromContainerName("", d.)
-
-	fmt.Println("Initailize done")
+	defer func() {
+		if err := d.ClearAll(); err != nil {
+			t.Errorf("ClearAll failed: %v", err)
+		}
+	}()
 
 	if err := d.ConnectInterface("virtualrouter1", true); err != nil {
-		fmt.Errorf("Error: %+v", err)
+		t.Errorf("ConnectInterface failed: %v", err)
 	}
+}
 
-	fmt.Println("ConnectInterface done")
-
-	if err := d.ClearAll(); err != nil {
-		fmt.Errorf("Error: %+v", err)
+func TestDaemonPlan(t *testing.T) {
+	d := daemon.NewDaemon(&crio.CrioConfig{}, &netlink.Config{}, "")
+	operations := d.Plan("virtualrouter1", v1.VirtualRouterSpec{
+		VlanNumber:       100,
+		InternalIP:       "10.0.0.1",
+		InternalNetmask:  "24",
+		ExternalIP:       "192.168.9.10",
+		ExternalNetmask:  "24",
+		GatewayIP:        "192.168.9.1",
+		InternalIPv6CIDR: "fd00:10::1/64",
+		ExternalIPv6CIDR: "2001:db8::10/64",
+		GatewayIPv6:      "fe80::1",
+		PolicyRouting: []v1.RoutingTable{
+			{ID: 10, Routes: []v1.PolicyRoute{{Gateway: "192.168.8.1"}}, Rules: []v1.PolicyRule{{Source: "10.0.1.0/24"}}},
+			{ID: 20, Routes: []v1.PolicyRoute{{Gateway: "192.168.7.1"}}, Rules: []v1.PolicyRule{{Mark: 20}}},
+		},
+		QoS: &v1.QoS{Ingress: &v1.QoSLimit{
+			Rate:    resource.MustParse("100M"),
+			Classes: []v1.QoSClass{{CIDR: "10.0.0.0/25", Rate: resource.MustParse("50M")}},
+		}},
+		Tunnels: []v1.Tunnel{
+			{Name: "gre1", Mode: v1.TunnelGRE, Remote: "203.0.113.1", Key: 10, Address: "169.254.10.1/30", Routes: []string{"10.20.0.0/16"}},
+		},
+		StaticNeighbors: []v1.StaticNeighbor{
+			{IP: "192.168.9.1", MAC: "52:54:00:12:34:56"},
+			{IP: "fd00:10::5", MAC: "52:54:00:AB:CD:EF", Interface: v1.NeighborInternal},
+		},
+		MTU: &v1.MTU{Internal: 1400, Auto: true},
+	})
+	expected := []string{
+		"connect internal interface ethint",
+		"connect external interface ethext",
+		"set route rule mark 200 table 200",
+		"assign vlan 100 (was 0)",
+		"assign internal address 10.0.0.1/24",
+		"assign external address 192.168.9.10/24",
+		"set default route via 192.168.9.1",
+		"assign internal IPv6 address fd00:10::1/64",
+		"assign external IPv6 address 2001:db8::10/64",
+		"set IPv6 default route via fe80::1",
+		"set policy routing tables [10, 20]",
+		"set QoS ingress 100000000 bit/s with 1 classes, egress unlimited",
+		"set tunnels [gre1 gre to 203.0.113.1]",
+		"set static neighbors [192.168.9.1 at 52:54:00:12:34:56 on ethext, fd00:10::5 at 52:54:00:ab:cd:ef on ethint]",
+		"set MTU internal 1400, external auto",
+	}
+	if !reflect.DeepEqual(operations, expected) {
+		t.Errorf("expected operations %v, got %v", expected, operations)
 	}
-
-	fmt.Println("Clear done")
 }
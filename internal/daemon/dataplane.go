@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	// DATA_PLANE_CHECK_TIMEOUT bounds the wait for the external gateway of a
+	// router pod to answer
+	DATA_PLANE_CHECK_TIMEOUT = 2 * time.Second
+	// DATA_PLANE_FAILURE_THRESHOLD is how many data plane checks in a row
+	// fail before a router pod is reported unhealthy, so a lost echo doesn't
+	// fail a router over
+	DATA_PLANE_FAILURE_THRESHOLD = 3
+)
+
+// dataPlaneKey asks for the data plane of every attached router pod to be
+// checked
+type dataPlaneKey struct{}
+
+// checkBridges fails if a host bridge of the routers is missing or down
+var checkBridges = internalNetlink.CheckBridges
+
+// pingGateway sends an ICMP echo request to the gateway from the source
+// address, in the network namespace of the process
+var pingGateway = func(pid int, source string, gateway string) error {
+	ns := internalNetlink.GetNsHandle(internalNetlink.CrioType(pid))
+	if !ns.IsOpen() {
+		return fmt.Errorf("no network namespace of pid %d", pid)
+	}
+	defer ns.Close()
+
+	// sockets stay in the namespace they are opened in
+	var conn *icmp.PacketConn
+	if err := internalNetlink.RunInNs(ns, func() (err error) {
+		conn, err = icmp.ListenPacket("ip4:icmp", source)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer conn.Close()
+	id := (os.Getpid() + int(atomic.AddUint32(&slaProbeID, 1))) & 0xffff
+	_, err := echo(conn, &net.IPAddr{IP: net.ParseIP(gateway)}, id, 0, DATA_PLANE_CHECK_TIMEOUT)
+	return err
+}
+
+// conntrackCount reads the number of tracked connections in the network
+// namespace of the process, failing if conntrack can't be used there
+var conntrackCount = func(pid int) error {
+	return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "conntrack", "-C").Run()
+}
+
+// CheckDataPlane checks that the router pod can forward traffic: the host
+// bridges are up, its external gateway answers from the external IP and
+// conntrack can be read in it. It returns false if the pod isn't attached.
+func (n *NetworkDaemon) CheckDataPlane(podName string, spec v1.VirtualRouterSpec) (bool, error) {
+	desc, exist := n.pod2containerMap[podName]
+	if !exist {
+		return false, nil
+	}
+	containerID := internalCrio.GetContainerIDFromContainerName(desc.containerName, n.crioCfg)
+	if containerID == "" {
+		return true, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return true, fmt.Errorf("wrong pid(%d) of container %s", containerPid, desc.containerName)
+	}
+
+	var failures []string
+	if err := checkBridges(n.netlinkCfg); err != nil {
+		failures = append(failures, err.Error())
+	}
+	if spec.GatewayIP != "" && spec.ExternalIP != "" {
+		if err := pingGateway(containerPid, spec.ExternalIP, spec.GatewayIP); err != nil {
+			failures = append(failures, fmt.Sprintf("gateway %s unreachable: %v", spec.GatewayIP, err))
+		}
+	}
+	if err := conntrackCount(containerPid); err != nil {
+		failures = append(failures, fmt.Sprintf("conntrack: %v", err))
+	}
+	if len(failures) > 0 {
+		return true, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return true, nil
+}
+
+// nextDataPlaneHealth returns the health of a router pod after a check,
+// unhealthy once DATA_PLANE_FAILURE_THRESHOLD checks in a row failed.
+func nextDataPlaneHealth(previous virtualroutermanager.DataPlaneHealth, err error) virtualroutermanager.DataPlaneHealth {
+	if err == nil {
+		return virtualroutermanager.DataPlaneHealth{Healthy: true}
+	}
+	failures := previous.ConsecutiveFailures + 1
+	return virtualroutermanager.DataPlaneHealth{
+		Healthy:             failures < DATA_PLANE_FAILURE_THRESHOLD,
+		Message:             err.Error(),
+		ConsecutiveFailures: failures,
+	}
+}
+
+// exportDataPlaneHealth checks the data plane of every attached router pod of
+// the node, keeping the results for the HTTP probe and annotating the pods
+// with them for the controller to report.
+func (c *Controller) exportDataPlaneHealth() error {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	previous := c.networkDaemon.DataPlaneHealth()
+	checked := map[string]virtualroutermanager.DataPlaneHealth{}
+	defer func() { c.networkDaemon.setDataPlaneHealth(checked) }()
+	for _, pod := range pods {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		crName, crNS := pod.GetAnnotations()["customresourceName"], pod.GetAnnotations()["customresourceNamespace"]
+		if crName == "" || crNS == "" {
+			continue
+		}
+		virtualRouter, err := c.virtualRoutersLister.VirtualRouters(crNS).Get(crName)
+		if err != nil {
+			continue
+		}
+
+		attached, err := c.networkDaemon.CheckDataPlane(pod.Name, effectiveVirtualRouter(virtualRouter).Spec)
+		if !attached {
+			continue
+		}
+		if err != nil {
+			klog.V(4).InfoS("Data plane check failed", "pod", pod.Namespace+"/"+pod.Name, "err", err)
+		}
+		health := nextDataPlaneHealth(previous[pod.Name], err)
+		checked[pod.Name] = health
+
+		content, err := json.Marshal(health)
+		if err != nil {
+			return err
+		}
+		if pod.GetAnnotations()[virtualroutermanager.DATA_PLANE_HEALTH_ANNOTATION] == string(content) {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{virtualroutermanager.DATA_PLANE_HEALTH_ANNOTATION: string(content)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := c.kubeclientset.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DataPlaneHealth returns the last data plane health of the attached router
+// pods, by pod name.
+func (n *NetworkDaemon) DataPlaneHealth() map[string]virtualroutermanager.DataPlaneHealth {
+	n.dataPlaneHealthMu.Lock()
+	defer n.dataPlaneHealthMu.Unlock()
+	health := make(map[string]virtualroutermanager.DataPlaneHealth, len(n.dataPlaneHealth))
+	for podName, podHealth := range n.dataPlaneHealth {
+		health[podName] = podHealth
+	}
+	return health
+}
+
+func (n *NetworkDaemon) setDataPlaneHealth(health map[string]virtualroutermanager.DataPlaneHealth) {
+	n.dataPlaneHealthMu.Lock()
+	defer n.dataPlaneHealthMu.Unlock()
+	n.dataPlaneHealth = health
+}
+
+// DataPlaneHealthHandler serves the last data plane health of the attached
+// router pods as JSON, of the one named by the pod query parameter if given.
+// It answers 503 if a router pod is unhealthy, and 404 for a pod not checked.
+func (n *NetworkDaemon) DataPlaneHealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := n.DataPlaneHealth()
+		if podName := r.URL.Query().Get("pod"); podName != "" {
+			podHealth, exist := health[podName]
+			if !exist {
+				http.Error(w, fmt.Sprintf("router pod %s not checked on this node", podName), http.StatusNotFound)
+				return
+			}
+			health = map[string]virtualroutermanager.DataPlaneHealth{podName: podHealth}
+		}
+		status := http.StatusOK
+		for _, podHealth := range health {
+			if !podHealth.Healthy {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(health); err != nil {
+			klog.ErrorS(err, "Writing data plane health failed")
+		}
+	})
+}
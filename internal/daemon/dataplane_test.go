@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+func TestNextDataPlaneHealth(t *testing.T) {
+	failure := fmt.Errorf("conntrack: exit status 1")
+	health := virtualroutermanager.DataPlaneHealth{Healthy: true}
+	// a pod is unhealthy only after failing the threshold in a row
+	for i := 1; i <= DATA_PLANE_FAILURE_THRESHOLD; i++ {
+		health = nextDataPlaneHealth(health, failure)
+		if health.ConsecutiveFailures != i || health.Healthy != (i < DATA_PLANE_FAILURE_THRESHOLD) || health.Message != failure.Error() {
+			t.Fatalf("unexpected health after %d failures: %+v", i, health)
+		}
+	}
+	if health = nextDataPlaneHealth(health, nil); health != (virtualroutermanager.DataPlaneHealth{Healthy: true}) {
+		t.Errorf("expected healthy after a passed check, got %+v", health)
+	}
+}
+
+func TestDataPlaneHealthHandler(t *testing.T) {
+	n := NewDaemon(nil, nil, "")
+	n.setDataPlaneHealth(map[string]virtualroutermanager.DataPlaneHealth{
+		"router-a": {Healthy: true},
+		"router-b": {Message: "bridge extbr is down", ConsecutiveFailures: 3},
+	})
+	for query, expected := range map[string]int{
+		"":              http.StatusServiceUnavailable,
+		"?pod=router-a": http.StatusOK,
+		"?pod=router-b": http.StatusServiceUnavailable,
+		"?pod=router-c": http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		n.DataPlaneHealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz/dataplane"+query, nil))
+		if recorder.Code != expected {
+			t.Errorf("%q: expected %d, got %d: %s", query, expected, recorder.Code, recorder.Body.String())
+		}
+	}
+}
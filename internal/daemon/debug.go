@@ -0,0 +1,264 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DEFAULT_CAPTURE_DURATION is how long a packet capture runs unless
+	// given, and MAX_CAPTURE_DURATION the longest one allowed
+	DEFAULT_CAPTURE_DURATION = 10 * time.Second
+	MAX_CAPTURE_DURATION     = 5 * time.Minute
+	// RULESET_SUBRESOURCE and PCAP_SUBRESOURCE are the subresources of
+	// virtualrouters callers of /debug/ruleset and /debug/pcap must be
+	// allowed to get, there being no such subresources on the API server
+	RULESET_SUBRESOURCE = "ruleset"
+	PCAP_SUBRESOURCE    = "pcap"
+	// DEBUG_TOKEN_HEADER carries the bearer token of callers going through
+	// the proxy of the API server, which doesn't pass the Authorization
+	// header on
+	DEBUG_TOKEN_HEADER = "X-Virtualrouter-Token"
+)
+
+// routerPid returns the pid of the router container. The container runtime
+// is asked directly, as the debug handlers don't run in the goroutine of the
+// controller keeping track of the attached pods.
+var routerPid = func(n *NetworkDaemon, containerID string) (int, error) {
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return 0, fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerID)
+	}
+	return containerPid, nil
+}
+
+// dumpRuleset lists the whole ruleset in the network namespace of the
+// process, with nft or iptables-save and ip6tables-save, counters included
+var dumpRuleset = func(pid int, backend internalNetlink.Feature) ([]byte, error) {
+	if backend == internalNetlink.FeatureNftables {
+		return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "nft", "list", "ruleset").Output()
+	}
+	output, err := exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "iptables-save", "-c").Output()
+	if err != nil {
+		return nil, err
+	}
+	// the node may have no IPv6 support
+	if ipv6, err := exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "ip6tables-save", "-c").Output(); err == nil {
+		output = append(output, ipv6...)
+	}
+	return output, nil
+}
+
+// checkCaptureFilter refuses a filter tcpdump would take for options, such
+// as -w or -z.
+func checkCaptureFilter(filter string) error {
+	if strings.HasPrefix(strings.TrimSpace(filter), "-") {
+		return fmt.Errorf("invalid filter %q, a BPF filter can't start with -", filter)
+	}
+	return nil
+}
+
+// captureArgs are the arguments of nsenter running tcpdump in the network
+// namespace of the process, the filter past "--" so none of it is an option.
+func captureArgs(pid int, iface string, filter string) []string {
+	args := []string{"-t", strconv.Itoa(pid), "-n", "tcpdump", "-i", iface, "-U", "-w", "-"}
+	if filter != "" {
+		args = append(args, "--", filter)
+	}
+	return args
+}
+
+// capture runs tcpdump in the network namespace of the process until the
+// context is done, writing the packets to w in pcap format
+var capture = func(ctx context.Context, pid int, iface string, filter string, w io.Writer) error {
+	if err := checkCaptureFilter(filter); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "nsenter", captureArgs(pid, iface, filter)...)
+	var stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = w, &stderr
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("tcpdump: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// authorize authenticates the bearer token of the request with a
+// TokenReview, and checks with a SubjectAccessReview that its user may take
+// the verb on the subresource of the VirtualRouter, of all those of the
+// namespace if the name is empty.
+func (c *Controller) authorize(r *http.Request, verb string, subresource string, namespace string, virtualRouter string) error {
+	token := r.Header.Get(DEBUG_TOKEN_HEADER)
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if token == "" {
+		return &faultError{http.StatusUnauthorized, fmt.Errorf("a bearer token is required")}
+	}
+	review, err := c.kubeclientset.AuthenticationV1().TokenReviews().Create(context.TODO(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Authenticated {
+		return &faultError{http.StatusUnauthorized, fmt.Errorf("invalid bearer token: %s", review.Status.Error)}
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	access, err := c.kubeclientset.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        verb,
+				Group:       v1.SchemeGroupVersion.Group,
+				Resource:    "virtualrouters",
+				Subresource: subresource,
+				Name:        virtualRouter,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !access.Status.Allowed {
+		return &faultError{http.StatusForbidden, fmt.Errorf("%s may not %s virtualrouters/%s in namespace %q", user.Username, verb, subresource, namespace)}
+	}
+	return nil
+}
+
+// debugRouterPid authorizes the request to get the subresource of the
+// VirtualRouter given by the namespace and virtualrouter query parameters,
+// and returns the pid of its router container on this node.
+func (c *Controller) debugRouterPid(r *http.Request, subresource string) (int, error) {
+	namespace, virtualRouter := r.URL.Query().Get("namespace"), r.URL.Query().Get("virtualrouter")
+	if namespace == "" || virtualRouter == "" {
+		return 0, &faultError{http.StatusBadRequest, fmt.Errorf("namespace and virtualrouter are required")}
+	}
+	if err := c.authorize(r, "get", subresource, namespace, virtualRouter); err != nil {
+		klog.ErrorS(err, "Authorizing debug request failed", "path", r.URL.Path, "virtualRouter", klog.KRef(namespace, virtualRouter))
+		return 0, err
+	}
+	containerID, err := c.routerContainerID(namespace, virtualRouter)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := routerPid(c.networkDaemon, containerID)
+	if err != nil {
+		return 0, &faultError{http.StatusNotFound, err}
+	}
+	return pid, nil
+}
+
+// DebugHandler serves the live ruleset of a router container at
+// /debug/ruleset, and captures of its traffic in pcap format at /debug/pcap,
+// both given the VirtualRouter by the namespace and virtualrouter query
+// parameters. A capture takes the interface, any by default, a BPF filter
+// and a duration. Callers authenticate with a bearer token, and must be
+// allowed to get the ruleset or pcap subresource of the VirtualRouter. The
+// ChaosHandler is served at /debug/chaos with the ChaosInjection feature
+// gate.
+func (c *Controller) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/ruleset", func(w http.ResponseWriter, r *http.Request) {
+		pid, err := c.debugRouterPid(r, RULESET_SUBRESOURCE)
+		if err != nil {
+			chaosError(w, err)
+			return
+		}
+		backend, ok := c.networkDaemon.PacketFilterBackend()
+		if !ok {
+			http.Error(w, "no packet filter supported on this node", http.StatusInternalServerError)
+			return
+		}
+		output, err := dumpRuleset(pid, backend)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(output)
+	})
+	mux.HandleFunc("/debug/pcap", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		duration := DEFAULT_CAPTURE_DURATION
+		if value := query.Get("duration"); value != "" {
+			var err error
+			if duration, err = time.ParseDuration(value); err != nil || duration <= 0 || duration > MAX_CAPTURE_DURATION {
+				http.Error(w, fmt.Sprintf("invalid duration %q, up to %s", value, MAX_CAPTURE_DURATION), http.StatusBadRequest)
+				return
+			}
+		}
+		iface := query.Get("interface")
+		if iface == "" {
+			iface = "any"
+		}
+		if err := checkCaptureFilter(query.Get("filter")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pid, err := c.debugRouterPid(r, PCAP_SUBRESOURCE)
+		if err != nil {
+			chaosError(w, err)
+			return
+		}
+
+		virtualRouter := klog.KRef(query.Get("namespace"), query.Get("virtualrouter"))
+		klog.InfoS("Capturing packets", "virtualRouter", virtualRouter, "interface", iface, "filter", query.Get("filter"), "duration", duration)
+		ctx, cancel := context.WithTimeout(r.Context(), duration)
+		defer cancel()
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		out := &flushWriter{w: w}
+		if err := capture(ctx, pid, iface, query.Get("filter"), out); err != nil {
+			klog.ErrorS(err, "Capturing packets failed", "virtualRouter", virtualRouter)
+			if out.written == 0 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			// otherwise the status went with the first packets, and the
+			// capture is just cut short
+		}
+	})
+	if features.Enabled(features.ChaosInjection) {
+		mux.Handle("/debug/chaos", c.ChaosHandler())
+	}
+	return mux
+}
+
+// flushWriter sends what is written right away, for captures to stream
+type flushWriter struct {
+	w       http.ResponseWriter
+	written int
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.written += n
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
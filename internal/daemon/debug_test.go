@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+func TestDebugHandler(t *testing.T) {
+	defer func(pid func(*NetworkDaemon, string) (int, error), dump func(int, internalNetlink.Feature) ([]byte, error),
+		run func(context.Context, int, string, string, io.Writer) error) {
+		routerPid, dumpRuleset, capture = pid, dump, run
+	}(routerPid, dumpRuleset, capture)
+	routerPid = func(n *NetworkDaemon, containerID string) (int, error) {
+		if containerID != "0123abcd" {
+			return 0, fmt.Errorf("wrong pid(0) of container %s", containerID)
+		}
+		return 42, nil
+	}
+	dumpRuleset = func(pid int, backend internalNetlink.Feature) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s ruleset of %d", backend, pid)), nil
+	}
+	var captured string
+	capture = func(ctx context.Context, pid int, iface string, filter string, w io.Writer) error {
+		captured = fmt.Sprintf("%d %s %s", pid, iface, filter)
+		if filter == "invalid" {
+			return fmt.Errorf("tcpdump: syntax error")
+		}
+		_, err := w.Write([]byte("pcap"))
+		return err
+	}
+
+	c := newChaosController(NewDaemon(nil, nil, internalNetlink.FeatureNftables))
+	for _, test := range []struct {
+		path     string
+		token    string
+		header   string
+		code     int
+		body     string
+		captured string
+	}{
+		{path: "/debug/ruleset?namespace=default&virtualrouter=test", token: "admin", code: http.StatusOK, body: "nftables ruleset of 42"},
+		{path: "/debug/ruleset?namespace=default&virtualrouter=test", header: "viewer", code: http.StatusOK, body: "nftables ruleset of 42"},
+		{path: "/debug/ruleset?namespace=default&virtualrouter=other", token: "admin", code: http.StatusNotFound},
+		// the router of the same name in another namespace is another one
+		{path: "/debug/ruleset?namespace=other&virtualrouter=test", token: "admin", code: http.StatusNotFound},
+		{path: "/debug/ruleset?virtualrouter=test", token: "admin", code: http.StatusBadRequest},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test", token: "admin", code: http.StatusOK, body: "pcap", captured: "42 any "},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test&interface=extif&filter=tcp+port+80&duration=1m", token: "admin", code: http.StatusOK, body: "pcap", captured: "42 extif tcp port 80"},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test&duration=1h", token: "admin", code: http.StatusBadRequest},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test&filter=invalid", token: "admin", code: http.StatusInternalServerError, captured: "42 any invalid"},
+		// options of tcpdump aren't to be passed as the filter
+		{path: "/debug/pcap?namespace=default&virtualrouter=test&filter=-w+/etc/passwd", token: "admin", code: http.StatusBadRequest},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test&filter=+-z+reboot", token: "admin", code: http.StatusBadRequest},
+		// only callers allowed to are served
+		{path: "/debug/ruleset?namespace=default&virtualrouter=test", code: http.StatusUnauthorized},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test", token: "unknown", code: http.StatusUnauthorized},
+		{path: "/debug/pcap?namespace=default&virtualrouter=test", token: "viewer", code: http.StatusForbidden},
+	} {
+		captured = ""
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		if test.header != "" {
+			request.Header.Set(DEBUG_TOKEN_HEADER, test.header)
+		}
+		c.DebugHandler().ServeHTTP(recorder, request)
+		if recorder.Code != test.code {
+			t.Errorf("%s: expected %d, got %d: %s", test.path, test.code, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if test.code == http.StatusOK && recorder.Body.String() != test.body {
+			t.Errorf("%s: expected %q, got %q", test.path, test.body, recorder.Body.String())
+		}
+		if captured != test.captured {
+			t.Errorf("%s: expected capture %q, got %q", test.path, test.captured, captured)
+		}
+	}
+}
+
+func TestCaptureArgs(t *testing.T) {
+	expected := []string{"-t", "42", "-n", "tcpdump", "-i", "any", "-U", "-w", "-", "--", "tcp port 80"}
+	if args := captureArgs(42, "any", "tcp port 80"); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+	if args := captureArgs(42, "any", ""); len(args) != 9 {
+		t.Errorf("expected no filter, got %v", args)
+	}
+	if err := capture(context.Background(), 42, "any", "-w /etc/passwd", ioutil.Discard); err == nil {
+		t.Error("expected a filter starting with - to be refused")
+	}
+}
@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// DNS_HEALTH_TIMEOUT bounds a probe of the forwarder of a router pod
+const DNS_HEALTH_TIMEOUT = 5 * time.Second
+
+// dnsHealthKey asks for the DNS forwarder of every attached router pod to be
+// probed
+type dnsHealthKey struct{}
+
+// lookupHost resolves the name with the DNS server, dialing it from the
+// network namespace of the process
+var lookupHost = func(pid int, server string, name string) error {
+	ns := internalNetlink.GetNsHandle(internalNetlink.CrioType(pid))
+	if !ns.IsOpen() {
+		return fmt.Errorf("no network namespace of pid %d", pid)
+	}
+	defer ns.Close()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+			err = internalNetlink.RunInNs(ns, func() (err error) {
+				conn, err = (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(server, "53"))
+				return err
+			})
+			return conn, err
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DNS_HEALTH_TIMEOUT)
+	defer cancel()
+	_, err := resolver.LookupHost(ctx, name)
+	return err
+}
+
+// CheckDNS resolves the name through the DNS forwarder of the router pod,
+// listening on the given router address. It returns false if the pod isn't
+// attached.
+func (n *NetworkDaemon) CheckDNS(podName string, routerIP string, name string) (bool, error) {
+	desc, exist := n.pod2containerMap[podName]
+	if !exist {
+		return false, nil
+	}
+	containerID := internalCrio.GetContainerIDFromContainerName(desc.containerName, n.crioCfg)
+	if containerID == "" {
+		return false, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return false, fmt.Errorf("wrong pid(%d) of container %s", containerPid, desc.containerName)
+	}
+	return true, lookupHost(containerPid, routerIP, name)
+}
+
+// exportDNSHealth probes the DNS forwarder of every attached router pod of
+// the node, and annotates the pods with the result for the controller to
+// report.
+func (c *Controller) exportDNSHealth() error {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		crName, crNS := pod.GetAnnotations()["customresourceName"], pod.GetAnnotations()["customresourceNamespace"]
+		if crName == "" || crNS == "" {
+			continue
+		}
+		virtualRouter, err := c.virtualRoutersLister.VirtualRouters(crNS).Get(crName)
+		if err != nil {
+			continue
+		}
+		spec := effectiveVirtualRouter(virtualRouter).Spec
+		if spec.DNS == nil || virtualroutermanager.IsPaused(virtualRouter) {
+			continue
+		}
+
+		health := virtualroutermanager.DNSHealth{Healthy: true}
+		attached, err := c.networkDaemon.CheckDNS(pod.Name, spec.InternalIP, virtualroutermanager.DNSHealthCheckName(spec.DNS))
+		if !attached {
+			continue
+		}
+		if err != nil {
+			klog.V(4).InfoS("DNS forwarder unhealthy", "pod", pod.Namespace+"/"+pod.Name, "err", err)
+			health = virtualroutermanager.DNSHealth{Message: err.Error()}
+		}
+		content, err := json.Marshal(health)
+		if err != nil {
+			return err
+		}
+		if pod.GetAnnotations()[virtualroutermanager.DNS_HEALTH_ANNOTATION] == string(content) {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{virtualroutermanager.DNS_HEALTH_ANNOTATION: string(content)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := c.kubeclientset.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, v1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package daemon
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	// XDP_PIN_DIR is where the fast path programs and maps of the router
+	// containers are pinned, a directory per container
+	XDP_PIN_DIR string = "/sys/fs/bpf/virtualrouter"
+	// XDP_PROGRAM is the program of the fast path object attached, and
+	// XDP_NAT_MAP and XDP_STATS_MAP its maps
+	XDP_PROGRAM   string = "vr_fastpath"
+	XDP_NAT_MAP   string = "vr_fp_nat"
+	XDP_STATS_MAP string = "vr_fp_stats"
+
+	// which address of a packet a translation of XDP_NAT_MAP matches
+	xdpSourceAddress      byte = 0
+	xdpDestinationAddress byte = 1
+
+	// FastPathEnabled is used as part of the Event 'reason' when the fast
+	// path of a router is attached or its rules change
+	FastPathEnabled = "FastPathEnabled"
+	// FastPathFallback is used as part of the Event 'reason' when a router
+	// with spec.fastPath is left to the packet filter
+	FastPathFallback = "FastPathFallback"
+)
+
+var fastPathPackets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: virtualroutermanager.FAST_PATH_PACKETS_METRIC,
+	Help: "Packets a router pod received since its fast path was attached, forwarded on the fast path or left to the packet filter.",
+}, []string{"namespace", "pod", "path"})
+
+var (
+	// runBpftool runs bpftool in the network namespace of the process, or in
+	// that of the daemon if pid is 0
+	runBpftool = func(pid int, args ...string) ([]byte, error) {
+		command := exec.Command("bpftool", args...)
+		if pid > 0 {
+			command = exec.Command("nsenter", append([]string{"-t", strconv.Itoa(pid), "-n", "bpftool"}, args...)...)
+		}
+		output, err := command.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return output, fmt.Errorf("bpftool %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return output, err
+	}
+	// removePins unpins the programs and maps of a router container
+	removePins = os.RemoveAll
+	// lookBpftool fails if bpftool isn't installed in the daemon image
+	lookBpftool = func() error {
+		_, err := exec.LookPath("bpftool")
+		return err
+	}
+)
+
+// fastPathConfig is the fast path of a router container, attached unless it
+// falls back.
+type fastPathConfig struct {
+	namespace, name string
+	containerID     string
+	rules           []virtualroutermanager.FastPathRule
+	// fallback is why the router is left to the packet filter
+	fallback string
+	// receivedAtAttach are the packets the container had received when the
+	// fast path was attached, which its metrics count from
+	receivedAtAttach uint64
+}
+
+func (c *fastPathConfig) pinDir() string {
+	return filepath.Join(XDP_PIN_DIR, c.containerID)
+}
+
+// xdpNATEntries returns the keys and values of XDP_NAT_MAP for the rules. A
+// rule translates back the other address of the replies.
+func xdpNATEntries(rules []virtualroutermanager.FastPathRule) [][2][]byte {
+	entry := func(addressOf byte, address, translated []byte) [2][]byte {
+		return [2][]byte{append([]byte{addressOf, 0, 0, 0}, address...), translated}
+	}
+	var entries [][2][]byte
+	for _, rule := range rules {
+		match, translate := []byte(rule.Match.To4()), []byte(rule.Translate.To4())
+		if rule.SNAT {
+			entries = append(entries, entry(xdpSourceAddress, match, translate), entry(xdpDestinationAddress, translate, match))
+		} else {
+			entries = append(entries, entry(xdpDestinationAddress, match, translate), entry(xdpSourceAddress, translate, match))
+		}
+	}
+	return entries
+}
+
+func hexArgs(bytes []byte) []string {
+	args := []string{"hex"}
+	for _, b := range bytes {
+		args = append(args, fmt.Sprintf("%02x", b))
+	}
+	return args
+}
+
+// attachFastPath loads the program with its own maps for the router
+// container, fills in the translations and attaches it to both interfaces.
+func attachFastPath(pid int, program string, config *fastPathConfig) error {
+	pinDir := config.pinDir()
+	if err := removePins(pinDir); err != nil {
+		return err
+	}
+	if _, err := runBpftool(0, "prog", "loadall", program, filepath.Join(pinDir, "prog"), "type", "xdp", "pinmaps", filepath.Join(pinDir, "maps")); err != nil {
+		return err
+	}
+	natMap := filepath.Join(pinDir, "maps", XDP_NAT_MAP)
+	for _, entry := range xdpNATEntries(config.rules) {
+		args := append([]string{"map", "update", "pinned", natMap, "key"}, hexArgs(entry[0])...)
+		args = append(append(args, "value"), hexArgs(entry[1])...)
+		if _, err := runBpftool(0, args...); err != nil {
+			return err
+		}
+	}
+	for _, iface := range []string{DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME, DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME} {
+		if _, err := runBpftool(pid, "net", "attach", "xdp", "pinned", filepath.Join(pinDir, "prog", XDP_PROGRAM), "dev", iface, "overwrite"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detachFastPath detaches the program from the interfaces of the router
+// container, if it is still running, and unpins it.
+func detachFastPath(pid int, config *fastPathConfig) error {
+	if pid > 0 {
+		for _, iface := range []string{DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME, DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME} {
+			if _, err := runBpftool(pid, "net", "detach", "xdp", "dev", iface); err != nil {
+				return err
+			}
+		}
+	}
+	return removePins(config.pinDir())
+}
+
+// xdpStatsEntry is an entry of a per-CPU map dumped by bpftool -j, with its
+// raw bytes.
+type xdpStatsEntry struct {
+	Values []struct {
+		CPU   int      `json:"cpu"`
+		Value []string `json:"value"`
+	} `json:"values"`
+}
+
+// parseFastPathPackets adds up the fast path packets of every CPU in the
+// output of bpftool -j map lookup of XDP_STATS_MAP. Counters are in the byte
+// order of the node, little-endian on amd64 and arm64.
+func parseFastPathPackets(output []byte) (uint64, error) {
+	var entry xdpStatsEntry
+	if err := json.Unmarshal(output, &entry); err != nil {
+		return 0, err
+	}
+	var packets uint64
+	for _, value := range entry.Values {
+		if len(value.Value) != 8 {
+			return 0, fmt.Errorf("unexpected counter of %d bytes", len(value.Value))
+		}
+		counter := make([]byte, 8)
+		for i, b := range value.Value {
+			parsed, err := strconv.ParseUint(strings.TrimPrefix(b, "0x"), 16, 8)
+			if err != nil {
+				return 0, err
+			}
+			counter[i] = byte(parsed)
+		}
+		packets += binary.LittleEndian.Uint64(counter)
+	}
+	return packets, nil
+}
+
+// fastPathFallbackOf returns why the router is left to the packet filter on
+// this node, empty if its fast path is attached.
+func (n *NetworkDaemon) fastPathFallbackOf(plan *virtualroutermanager.FastPathPlan, program string) string {
+	switch {
+	case n.features != nil && !n.features.Supports(internalNetlink.FeatureXDP):
+		return "the node kernel has no XDP"
+	case program == "":
+		return "the daemon has no --xdp-program"
+	case lookBpftool() != nil:
+		return "the daemon image has no bpftool"
+	case plan == nil:
+		return "the NAT rules can't be listed"
+	case plan.Fallback != "":
+		return plan.Fallback
+	case len(plan.Rules) == 0:
+		return "no NAT rule is translated 1:1"
+	}
+	return ""
+}
+
+// EnsureFastPath attaches the fast path of the router container of the
+// VirtualRouter on this node with the rules of the plan, and falls back to
+// the packet filter, detaching it, when the plan or the node can't have it or
+// attaching fails. The packet filter keeps every NAT rule, so whatever the
+// fast path doesn't take is translated as before. It returns why the router
+// falls back, and whether that or the rules changed.
+func (n *NetworkDaemon) EnsureFastPath(virtualrouter *v1.VirtualRouter, plan *virtualroutermanager.FastPathPlan, program string) (string, bool, error) {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return "", false, nil
+	}
+	applied, exist := n.fastPaths[containerName]
+	if virtualrouter.Spec.FastPath == "" && !exist {
+		return "", false, nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return "", false, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return "", false, fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+
+	if virtualrouter.Spec.FastPath == "" {
+		if applied.fallback == "" {
+			if err := detachFastPath(containerPid, applied); err != nil {
+				klog.ErrorS(err, "Detaching fast path failed", "containerName", containerName)
+				return "", false, err
+			}
+		}
+		delete(n.fastPaths, containerName)
+		klog.InfoS("Fast path cleared", "containerName", containerName)
+		return "", false, nil
+	}
+
+	config := &fastPathConfig{
+		namespace:   virtualrouter.Namespace,
+		name:        virtualrouter.Name,
+		containerID: containerID,
+		fallback:    n.fastPathFallbackOf(plan, program),
+	}
+	if config.fallback == "" {
+		config.rules = plan.Rules
+	}
+	if exist && applied.containerID == config.containerID && applied.fallback == config.fallback && reflect.DeepEqual(applied.rules, config.rules) {
+		return config.fallback, false, nil
+	}
+	if exist && applied.fallback == "" {
+		if err := detachFastPath(containerPid, applied); err != nil {
+			klog.ErrorS(err, "Detaching fast path failed", "containerName", containerName)
+			return applied.fallback, false, err
+		}
+	}
+	var err error
+	if config.fallback == "" {
+		config.receivedAtAttach, _ = routerPacketsReceived(containerID[:7])
+		if err = attachFastPath(containerPid, program, config); err != nil {
+			klog.ErrorS(err, "Attaching fast path failed, falling back to the packet filter", "containerName", containerName)
+			detachFastPath(containerPid, config)
+			config.rules = nil
+			config.fallback = fmt.Sprintf("attaching XDP failed: %v", err)
+		}
+	}
+	changed := !exist || applied.fallback != config.fallback || !reflect.DeepEqual(applied.rules, config.rules)
+	n.fastPaths[containerName] = config
+	if config.fallback != "" {
+		klog.InfoS("Fast path falls back to the packet filter", "containerName", containerName, "reason", config.fallback)
+	} else {
+		klog.InfoS("Fast path attached", "containerName", containerName, "rules", len(config.rules))
+	}
+	return config.fallback, changed, err
+}
+
+// clearFastPath forgets the fast path of the router container, gone with its
+// network namespace, and unpins it.
+func (n *NetworkDaemon) clearFastPath(containerName string) {
+	config, exist := n.fastPaths[containerName]
+	if !exist {
+		return
+	}
+	delete(n.fastPaths, containerName)
+	if config.fallback == "" {
+		if err := removePins(config.pinDir()); err != nil {
+			klog.ErrorS(err, "Unpinning fast path failed", "containerName", containerName)
+		}
+	}
+}
+
+// fastPathPacketsOf returns the packets the router container forwarded on
+// its fast path and left to the packet filter since it was attached, false
+// if it has none attached.
+func (n *NetworkDaemon) fastPathPacketsOf(containerName string, received uint64) (uint64, uint64, bool, error) {
+	config, exist := n.fastPaths[containerName]
+	if !exist || config.fallback != "" {
+		return 0, 0, false, nil
+	}
+	output, err := runBpftool(0, append([]string{"-j", "map", "lookup", "pinned", filepath.Join(config.pinDir(), "maps", XDP_STATS_MAP), "key"}, hexArgs([]byte{0, 0, 0, 0})...)...)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	fast, err := parseFastPathPackets(output)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	var slow uint64
+	if received > config.receivedAtAttach+fast {
+		slow = received - config.receivedAtAttach - fast
+	}
+	return fast, slow, true, nil
+}
+
+// ensureFastPath plans the fast path of the VirtualRouter and has the daemon
+// attach it or fall back, telling in an Event when either changes. Failures
+// are retried on the next sync rather than holding the router back, as the
+// packet filter translates every rule anyway.
+func (c *Controller) ensureFastPath(virtualRouter *v1.VirtualRouter) {
+	var plan *virtualroutermanager.FastPathPlan
+	if virtualRouter.Spec.FastPath != "" && c.fastPathListers != nil {
+		var err error
+		plan, err = virtualroutermanager.PlanFastPath(c.fastPathListers, virtualroutermanager.RouterNamespace(virtualRouter), virtualRouter.Spec)
+		if err != nil {
+			klog.ErrorS(err, "Planning fast path failed", "virtualRouter", klog.KObj(virtualRouter))
+			return
+		}
+	}
+	fallback, changed, err := c.networkDaemon.EnsureFastPath(virtualRouter, plan, c.xdpProgram)
+	if err != nil {
+		klog.ErrorS(err, "Setting fast path failed", "virtualRouter", klog.KObj(virtualRouter))
+	}
+	if !changed {
+		return
+	}
+	if fallback != "" {
+		c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, FastPathFallback, "Fast path falls back to the packet filter: %s", fallback)
+		return
+	}
+	var ineligible []string
+	for rule, reason := range plan.Ineligible {
+		ineligible = append(ineligible, rule+": "+reason)
+	}
+	message := fmt.Sprintf("Fast path translates %d NAT rules", len(plan.Rules))
+	if len(ineligible) > 0 {
+		sort.Strings(ineligible)
+		message += ", leaving to the packet filter " + strings.Join(ineligible, ", ")
+	}
+	c.recorder.Event(virtualRouter, corev1.EventTypeNormal, FastPathEnabled, message)
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+func TestParseFastPathPackets(t *testing.T) {
+	output := `{"key":["0x00","0x00","0x00","0x00"],"values":[{"cpu":0,"value":["0x0a","0x00","0x00","0x00","0x00","0x00","0x00","0x00"]},{"cpu":1,"value":["0x00","0x01","0x00","0x00","0x00","0x00","0x00","0x00"]}]}`
+	packets, err := parseFastPathPackets([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packets != 10+256 {
+		t.Errorf("expected %d packets, got %d", 10+256, packets)
+	}
+	if _, err := parseFastPathPackets([]byte(`{"values":[{"cpu":0,"value":["0x01"]}]}`)); err == nil {
+		t.Errorf("expected a short counter to fail")
+	}
+}
+
+func TestAttachFastPath(t *testing.T) {
+	var commands []string
+	defer func(run func(int, ...string) ([]byte, error), remove func(string) error) {
+		runBpftool, removePins = run, remove
+	}(runBpftool, removePins)
+	runBpftool = func(pid int, args ...string) ([]byte, error) {
+		commands = append(commands, fmt.Sprintf("%d %s", pid, strings.Join(args, " ")))
+		return nil, nil
+	}
+	removePins = func(path string) error {
+		commands = append(commands, "rm "+path)
+		return nil
+	}
+
+	config := &fastPathConfig{
+		containerID: "abcdef",
+		rules: []virtualroutermanager.FastPathRule{
+			{SNAT: true, Match: net.ParseIP("192.168.0.5"), Translate: net.ParseIP("10.0.0.5")},
+			{Match: net.ParseIP("10.0.0.6"), Translate: net.ParseIP("192.168.0.6")},
+		},
+	}
+	if err := attachFastPath(42, "/xdp/fastpath.o", config); err != nil {
+		t.Fatal(err)
+	}
+	// replies are translated back by the other address
+	expected := []string{
+		"rm /sys/fs/bpf/virtualrouter/abcdef",
+		"0 prog loadall /xdp/fastpath.o /sys/fs/bpf/virtualrouter/abcdef/prog type xdp pinmaps /sys/fs/bpf/virtualrouter/abcdef/maps",
+		"0 map update pinned /sys/fs/bpf/virtualrouter/abcdef/maps/vr_fp_nat key hex 00 00 00 00 c0 a8 00 05 value hex 0a 00 00 05",
+		"0 map update pinned /sys/fs/bpf/virtualrouter/abcdef/maps/vr_fp_nat key hex 01 00 00 00 0a 00 00 05 value hex c0 a8 00 05",
+		"0 map update pinned /sys/fs/bpf/virtualrouter/abcdef/maps/vr_fp_nat key hex 01 00 00 00 0a 00 00 06 value hex c0 a8 00 06",
+		"0 map update pinned /sys/fs/bpf/virtualrouter/abcdef/maps/vr_fp_nat key hex 00 00 00 00 c0 a8 00 06 value hex 0a 00 00 06",
+		"42 net attach xdp pinned /sys/fs/bpf/virtualrouter/abcdef/prog/vr_fastpath dev ethint overwrite",
+		"42 net attach xdp pinned /sys/fs/bpf/virtualrouter/abcdef/prog/vr_fastpath dev ethext overwrite",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(commands, "\n"))
+	}
+
+	commands = nil
+	if err := detachFastPath(42, config); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"42 net detach xdp dev ethint",
+		"42 net detach xdp dev ethext",
+		"rm /sys/fs/bpf/virtualrouter/abcdef",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(commands, "\n"))
+	}
+}
+
+func TestFastPathFallback(t *testing.T) {
+	defer func(look func() error) { lookBpftool = look }(lookBpftool)
+	bpftool := true
+	lookBpftool = func() error {
+		if !bpftool {
+			return errors.New(`exec: "bpftool": executable file not found in $PATH`)
+		}
+		return nil
+	}
+
+	rules := []virtualroutermanager.FastPathRule{{SNAT: true, Match: net.ParseIP("192.168.0.5"), Translate: net.ParseIP("10.0.0.5")}}
+	tests := []struct {
+		name     string
+		features internalNetlink.FeatureMatrix
+		plan     *virtualroutermanager.FastPathPlan
+		program  string
+		expected string
+	}{
+		{"attached", internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}, &virtualroutermanager.FastPathPlan{Rules: rules}, "/xdp/fastpath.o", ""},
+		{"no XDP", internalNetlink.FeatureMatrix{}, &virtualroutermanager.FastPathPlan{Rules: rules}, "/xdp/fastpath.o", "the node kernel has no XDP"},
+		{"no program", internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}, &virtualroutermanager.FastPathPlan{Rules: rules}, "", "the daemon has no --xdp-program"},
+		{"not listed", internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}, nil, "/xdp/fastpath.o", "the NAT rules can't be listed"},
+		{"router falls back", internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}, &virtualroutermanager.FastPathPlan{Fallback: "spec.qos shapes traffic with tc"}, "/xdp/fastpath.o", "spec.qos shapes traffic with tc"},
+		{"no rule", internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}, &virtualroutermanager.FastPathPlan{}, "/xdp/fastpath.o", "no NAT rule is translated 1:1"},
+	}
+	for _, test := range tests {
+		n := &NetworkDaemon{features: test.features}
+		if fallback := n.fastPathFallbackOf(test.plan, test.program); fallback != test.expected {
+			t.Errorf("%s: expected fallback %q, got %q", test.name, test.expected, fallback)
+		}
+	}
+
+	// told in the FastPathFallback Event rather than failing to attach
+	bpftool = false
+	n := &NetworkDaemon{features: internalNetlink.FeatureMatrix{internalNetlink.FeatureXDP: true}}
+	if fallback := n.fastPathFallbackOf(&virtualroutermanager.FastPathPlan{Rules: rules}, "/xdp/fastpath.o"); fallback != "the daemon image has no bpftool" {
+		t.Errorf("no bpftool: expected fallback %q, got %q", "the daemon image has no bpftool", fallback)
+	}
+}
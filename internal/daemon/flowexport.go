@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/flowexport"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// flowExportKey asks for the connections of every attached router pod
+// exporting flows to be exported
+type flowExportKey struct{}
+
+var (
+	// enableConntrackAccounting has conntrack count the packets and bytes
+	// of the connections opened from now on in the network namespace of the
+	// process
+	enableConntrackAccounting = func(pid int) error {
+		return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "sysctl", "-w", "net.netfilter.nf_conntrack_acct=1").Run()
+	}
+	// conntrackConnections dumps all the connections in the network
+	// namespace of the process
+	conntrackConnections = func(pid int) ([]byte, error) {
+		return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "conntrack", "-L").Output()
+	}
+	// sendFlows sends the messages to the collector over UDP
+	sendFlows = func(collector string, messages [][]byte) error {
+		conn, err := net.Dial("udp", collector)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		for _, message := range messages {
+			if _, err := conn.Write(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+)
+
+// flowExportConfig is how the connections of a router container are
+// exported.
+type flowExportConfig struct {
+	spec     v1.FlowExport
+	exporter *flowexport.Exporter
+	// counters are the counters of the connections sampled at the last
+	// export, flows carrying the traffic since
+	counters map[string]connectionCounters
+}
+
+// connectionCounters are the packets and bytes of a connection in the
+// original and reply directions.
+type connectionCounters struct {
+	packets, bytes           uint64
+	replyPackets, replyBytes uint64
+}
+
+// connection is a connection of conntrack -L output, its tuples as they go
+// in the original direction and come back in the reply direction.
+type connection struct {
+	key      string
+	protocol uint8
+	original tuple
+	reply    tuple
+	counters connectionCounters
+}
+
+type tuple struct {
+	source, destination         net.IP
+	sourcePort, destinationPort uint16
+}
+
+// EnsureFlowExport has the connections of the router container of the
+// VirtualRouter on this node exported as its spec says, turning the
+// accounting of conntrack on, and stops exporting them once the spec drops
+// it. The export itself runs on every flow export interval.
+func (n *NetworkDaemon) EnsureFlowExport(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	applied, exist := n.flowExports[containerName]
+	if virtualrouter.Spec.FlowExport == nil {
+		if exist {
+			delete(n.flowExports, containerName)
+			klog.InfoS("Flow export stopped", "containerName", containerName)
+		}
+		return nil
+	}
+	if exist && applied.spec == *virtualrouter.Spec.FlowExport {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	if err := enableConntrackAccounting(containerPid); err != nil {
+		klog.ErrorS(err, "Turning conntrack accounting on failed", "containerName", containerName)
+		return err
+	}
+
+	// every router container is an observation domain of its own, whose
+	// sequence numbers start over
+	domain := fnv.New32a()
+	domain.Write([]byte(virtualrouter.Namespace + "/" + virtualrouter.Name + "/" + containerID))
+	spec := *virtualrouter.Spec.FlowExport
+	n.flowExports[containerName] = &flowExportConfig{
+		spec:     spec,
+		exporter: flowexport.NewExporter(string(spec.Protocol), domain.Sum32(), uint32(spec.SamplingRate), time.Now()),
+		counters: map[string]connectionCounters{},
+	}
+	klog.InfoS("Flow export set", "containerName", containerName, "collector", spec.Collector, "protocol", spec.Protocol, "samplingRate", spec.SamplingRate)
+	return nil
+}
+
+// parseConnections returns the connections of conntrack -L output, counters
+// included when conntrack accounting is on.
+func parseConnections(output string) []connection {
+	var connections []connection
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		protocol, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			continue
+		}
+		c := connection{protocol: uint8(protocol)}
+		// the keys are given for the original direction, then the reply one
+		seen := map[string]int{}
+		for _, field := range fields[2:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			seen[kv[0]]++
+			t, packets, bytes := &c.original, &c.counters.packets, &c.counters.bytes
+			if seen[kv[0]] == 2 {
+				t, packets, bytes = &c.reply, &c.counters.replyPackets, &c.counters.replyBytes
+			}
+			switch kv[0] {
+			case "src":
+				t.source = net.ParseIP(kv[1])
+			case "dst":
+				t.destination = net.ParseIP(kv[1])
+			case "sport":
+				port, _ := strconv.ParseUint(kv[1], 10, 16)
+				t.sourcePort = uint16(port)
+			case "dport":
+				port, _ := strconv.ParseUint(kv[1], 10, 16)
+				t.destinationPort = uint16(port)
+			case "packets":
+				*packets, _ = strconv.ParseUint(kv[1], 10, 64)
+			case "bytes":
+				*bytes, _ = strconv.ParseUint(kv[1], 10, 64)
+			}
+		}
+		if c.original.source == nil || c.reply.source == nil {
+			continue
+		}
+		c.key = fmt.Sprintf("%d %s:%d %s:%d", c.protocol, c.original.source, c.original.sourcePort, c.original.destination, c.original.destinationPort)
+		connections = append(connections, c)
+	}
+	return connections
+}
+
+// sampled tells whether the connection is among the 1 in samplingRate
+// exported. A connection is sampled by its tuple, so it is either exported
+// at every interval or never.
+func sampled(key string, samplingRate int32) bool {
+	if samplingRate <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%uint32(samplingRate) == 0
+}
+
+// delta returns the counter since the previous sample of it, all of it if the
+// counter went back, as it does when the tuple is taken by a new connection.
+func delta(previous, current uint64) uint64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// flows returns the flows of the sampled connections in both directions,
+// with the traffic since the previous counters, and the counters to take the
+// next flows from. Connections closed in between lose their last traffic.
+func flows(connections []connection, previous map[string]connectionCounters, samplingRate int32) ([]flowexport.Flow, map[string]connectionCounters) {
+	var result []flowexport.Flow
+	counters := map[string]connectionCounters{}
+	for _, c := range connections {
+		if !sampled(c.key, samplingRate) {
+			continue
+		}
+		counters[c.key] = c.counters
+		last := previous[c.key]
+		if packets := delta(last.packets, c.counters.packets); packets > 0 {
+			result = append(result, flowexport.Flow{
+				Protocol:               c.protocol,
+				Source:                 c.original.source,
+				Destination:            c.original.destination,
+				SourcePort:             c.original.sourcePort,
+				DestinationPort:        c.original.destinationPort,
+				PostNATSource:          c.reply.destination,
+				PostNATDestination:     c.reply.source,
+				PostNATSourcePort:      c.reply.destinationPort,
+				PostNATDestinationPort: c.reply.sourcePort,
+				Packets:                packets,
+				Bytes:                  delta(last.bytes, c.counters.bytes),
+			})
+		}
+		if packets := delta(last.replyPackets, c.counters.replyPackets); packets > 0 {
+			result = append(result, flowexport.Flow{
+				Protocol:               c.protocol,
+				Source:                 c.reply.source,
+				Destination:            c.reply.destination,
+				SourcePort:             c.reply.sourcePort,
+				DestinationPort:        c.reply.destinationPort,
+				PostNATSource:          c.original.destination,
+				PostNATDestination:     c.original.source,
+				PostNATSourcePort:      c.original.destinationPort,
+				PostNATDestinationPort: c.original.sourcePort,
+				Packets:                packets,
+				Bytes:                  delta(last.replyBytes, c.counters.replyBytes),
+			})
+		}
+	}
+	return result, counters
+}
+
+// exportFlows sends the traffic of the sampled connections of the router pods
+// exporting flows since the last export to their collectors.
+func (n *NetworkDaemon) exportFlows() error {
+	for containerName, config := range n.flowExports {
+		containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+		if containerID == "" {
+			continue
+		}
+		containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+		if containerPid <= 0 {
+			continue
+		}
+		output, err := conntrackConnections(containerPid)
+		if err != nil {
+			klog.ErrorS(err, "Listing connections failed", "containerName", containerName)
+			continue
+		}
+		var records []flowexport.Flow
+		records, config.counters = flows(parseConnections(string(output)), config.counters, config.spec.SamplingRate)
+		messages := config.exporter.Encode(records, time.Now())
+		if err := sendFlows(config.spec.Collector, messages); err != nil {
+			klog.ErrorS(err, "Exporting flows failed", "containerName", containerName, "collector", config.spec.Collector)
+			continue
+		}
+		klog.V(4).InfoS("Flows exported", "containerName", containerName, "collector", config.spec.Collector, "flows", len(records), "messages", len(messages))
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Package flowexport encodes the connections of router pods as IPFIX or
+// NetFlow v9 flow records.
+package flowexport
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	IPFIX      = "IPFIX"
+	NETFLOW_V9 = "NetFlowV9"
+
+	// MAX_MESSAGE_SIZE keeps messages in a single UDP datagram on links of
+	// the default MTU
+	MAX_MESSAGE_SIZE = 1400
+
+	ipfixVersion       = 10
+	ipfixTemplateSet   = 2
+	ipfixHeaderSize    = 16
+	netflowVersion     = 9
+	netflowTemplateSet = 0
+	netflowHeaderSize  = 20
+	setHeaderSize      = 4
+
+	ipv4TemplateID = 256
+	ipv6TemplateID = 257
+)
+
+// Information elements of the records, numbered the same in IPFIX and
+// NetFlow v9
+const (
+	octetDeltaCount               = 1
+	packetDeltaCount              = 2
+	protocolIdentifier            = 4
+	sourceTransportPort           = 7
+	sourceIPv4Address             = 8
+	destinationTransportPort      = 11
+	destinationIPv4Address        = 12
+	sourceIPv6Address             = 27
+	destinationIPv6Address        = 28
+	samplingInterval              = 34
+	postNATSourceIPv4Address      = 225
+	postNATDestinationIPv4Address = 226
+	postNAPTSourceTransportPort   = 227
+	postNAPTDestinationPort       = 228
+	postNATSourceIPv6Address      = 281
+	postNATDestinationIPv6Address = 282
+)
+
+type field struct {
+	id, length uint16
+}
+
+type template struct {
+	id     uint16
+	fields []field
+	size   int
+}
+
+func newTemplate(id uint16, addressLength uint16, source, destination, postNATSource, postNATDestination uint16) *template {
+	t := &template{id: id, fields: []field{
+		{source, addressLength},
+		{destination, addressLength},
+		{sourceTransportPort, 2},
+		{destinationTransportPort, 2},
+		{protocolIdentifier, 1},
+		{postNATSource, addressLength},
+		{postNATDestination, addressLength},
+		{postNAPTSourceTransportPort, 2},
+		{postNAPTDestinationPort, 2},
+		{packetDeltaCount, 8},
+		{octetDeltaCount, 8},
+		{samplingInterval, 4},
+	}}
+	for _, f := range t.fields {
+		t.size += int(f.length)
+	}
+	return t
+}
+
+var (
+	ipv4Template = newTemplate(ipv4TemplateID, net.IPv4len, sourceIPv4Address, destinationIPv4Address, postNATSourceIPv4Address, postNATDestinationIPv4Address)
+	ipv6Template = newTemplate(ipv6TemplateID, net.IPv6len, sourceIPv6Address, destinationIPv6Address, postNATSourceIPv6Address, postNATDestinationIPv6Address)
+)
+
+// Flow is the traffic of a connection in one direction since it was last
+// exported.
+type Flow struct {
+	Protocol        uint8
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      uint16
+	DestinationPort uint16
+	// PostNATSource and the other PostNAT fields are the addresses and ports
+	// of the flow once translated, as the other direction is addressed
+	PostNATSource          net.IP
+	PostNATDestination     net.IP
+	PostNATSourcePort      uint16
+	PostNATDestinationPort uint16
+	Packets                uint64
+	Bytes                  uint64
+}
+
+func (f *Flow) template() *template {
+	if f.Source.To4() != nil {
+		return ipv4Template
+	}
+	return ipv6Template
+}
+
+func (f *Flow) encode(b []byte, t *template, samplingRate uint32) []byte {
+	address := func(ip net.IP) []byte {
+		if t == ipv4Template {
+			if ip = ip.To4(); ip == nil {
+				return make([]byte, net.IPv4len)
+			}
+			return ip
+		}
+		if ip = ip.To16(); ip == nil {
+			return make([]byte, net.IPv6len)
+		}
+		return ip
+	}
+	b = append(b, address(f.Source)...)
+	b = append(b, address(f.Destination)...)
+	b = appendUint16(b, f.SourcePort)
+	b = appendUint16(b, f.DestinationPort)
+	b = append(b, f.Protocol)
+	b = append(b, address(f.PostNATSource)...)
+	b = append(b, address(f.PostNATDestination)...)
+	b = appendUint16(b, f.PostNATSourcePort)
+	b = appendUint16(b, f.PostNATDestinationPort)
+	b = appendUint64(b, f.Packets)
+	b = appendUint64(b, f.Bytes)
+	return appendUint32(b, samplingRate)
+}
+
+// Exporter encodes the flows of an observation domain, a router container,
+// into messages of its protocol. The templates are sent in every message, as
+// UDP collectors may miss any of them.
+type Exporter struct {
+	protocol          string
+	observationDomain uint32
+	samplingRate      uint32
+	// sequence counts the data records sent with IPFIX, and the messages
+	// with NetFlow v9
+	sequence uint32
+	started  time.Time
+}
+
+// NewExporter returns an Exporter of the protocol, IPFIX unless NETFLOW_V9,
+// telling collectors 1 in samplingRate flows are exported.
+func NewExporter(protocol string, observationDomain uint32, samplingRate uint32, started time.Time) *Exporter {
+	if protocol != NETFLOW_V9 {
+		protocol = IPFIX
+	}
+	if samplingRate == 0 {
+		samplingRate = 1
+	}
+	return &Exporter{protocol: protocol, observationDomain: observationDomain, samplingRate: samplingRate, started: started}
+}
+
+// Encode returns the messages carrying the flows, none without flows.
+func (e *Exporter) Encode(flows []Flow, now time.Time) [][]byte {
+	flows = append([]Flow(nil), flows...)
+	// IPv4 flows first, for the records of a template to share sets
+	sort.SliceStable(flows, func(i, j int) bool {
+		return flows[i].template().id < flows[j].template().id
+	})
+
+	headerSize, templateSetID := ipfixHeaderSize, uint16(ipfixTemplateSet)
+	if e.protocol == NETFLOW_V9 {
+		headerSize, templateSetID = netflowHeaderSize, netflowTemplateSet
+	}
+	templates := []byte{}
+	for _, t := range []*template{ipv4Template, ipv6Template} {
+		templates = appendUint16(templates, t.id)
+		templates = appendUint16(templates, uint16(len(t.fields)))
+		for _, f := range t.fields {
+			templates = appendUint16(templates, f.id)
+			templates = appendUint16(templates, f.length)
+		}
+	}
+
+	var messages [][]byte
+	for len(flows) > 0 {
+		body := appendUint16(nil, templateSetID)
+		body = appendUint16(body, uint16(setHeaderSize+len(templates)))
+		body = append(body, templates...)
+		records := 0
+		for len(flows) > 0 {
+			t := flows[0].template()
+			if headerSize+len(body)+setHeaderSize+t.size > MAX_MESSAGE_SIZE {
+				break
+			}
+			set := appendUint16(nil, t.id)
+			set = append(set, 0, 0)
+			for len(flows) > 0 && flows[0].template() == t && headerSize+len(body)+len(set)+t.size <= MAX_MESSAGE_SIZE {
+				set = flows[0].encode(set, t, e.samplingRate)
+				flows = flows[1:]
+				records++
+			}
+			// NetFlow v9 flowsets are padded to 32 bits
+			for e.protocol == NETFLOW_V9 && len(set)%4 != 0 && headerSize+len(body)+len(set) < MAX_MESSAGE_SIZE {
+				set = append(set, 0)
+			}
+			binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+			body = append(body, set...)
+		}
+		messages = append(messages, append(e.header(headerSize+len(body), records, now), body...))
+	}
+	return messages
+}
+
+// header returns the message header, counting the records of the message in
+// the sequence.
+func (e *Exporter) header(length int, records int, now time.Time) []byte {
+	var header []byte
+	if e.protocol == NETFLOW_V9 {
+		header = appendUint16(header, netflowVersion)
+		// the count includes the two template records
+		header = appendUint16(header, uint16(records+2))
+		header = appendUint32(header, uint32(now.Sub(e.started).Milliseconds()))
+		header = appendUint32(header, uint32(now.Unix()))
+		header = appendUint32(header, e.sequence)
+		header = appendUint32(header, e.observationDomain)
+		e.sequence++
+		return header
+	}
+	header = appendUint16(header, ipfixVersion)
+	header = appendUint16(header, uint16(length))
+	header = appendUint32(header, uint32(now.Unix()))
+	header = appendUint32(header, e.sequence)
+	header = appendUint32(header, e.observationDomain)
+	e.sequence += uint32(records)
+	return header
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,123 @@
+package flowexport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// decodedSet is a set of a message, with the records of a data set
+type decodedSet struct {
+	id      uint16
+	records int
+}
+
+func decode(t *testing.T, message []byte, headerSize int) []decodedSet {
+	t.Helper()
+	var sets []decodedSet
+	for b := message[headerSize:]; len(b) > 0; {
+		if len(b) < setHeaderSize {
+			t.Fatalf("truncated set header: %x", b)
+		}
+		id, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if length < setHeaderSize || length > len(b) {
+			t.Fatalf("set %d of length %d in %d bytes", id, length, len(b))
+		}
+		set := decodedSet{id: id}
+		switch id {
+		case ipv4TemplateID:
+			set.records = (length - setHeaderSize) / ipv4Template.size
+		case ipv6TemplateID:
+			set.records = (length - setHeaderSize) / ipv6Template.size
+		}
+		sets = append(sets, set)
+		b = b[length:]
+	}
+	return sets
+}
+
+func TestEncode(t *testing.T) {
+	started := time.Unix(1600000000, 0)
+	now := started.Add(90 * time.Second)
+	flow := Flow{
+		Protocol:               6,
+		Source:                 net.ParseIP("10.0.0.2"),
+		Destination:            net.ParseIP("8.8.8.8"),
+		SourcePort:             40000,
+		DestinationPort:        443,
+		PostNATSource:          net.ParseIP("192.168.1.10"),
+		PostNATDestination:     net.ParseIP("8.8.8.8"),
+		PostNATSourcePort:      1024,
+		PostNATDestinationPort: 443,
+		Packets:                10,
+		Bytes:                  1000,
+	}
+	flow6 := Flow{Protocol: 17, Source: net.ParseIP("fd00:10::2"), Destination: net.ParseIP("2001:db8::1"), SourcePort: 5353, DestinationPort: 53, Packets: 1, Bytes: 80}
+
+	e := NewExporter(IPFIX, 7, 4, started)
+	if messages := e.Encode(nil, now); len(messages) != 0 {
+		t.Errorf("expected no message without flows, got %d", len(messages))
+	}
+	messages := e.Encode([]Flow{flow6, flow, flow}, now)
+	if len(messages) != 1 {
+		t.Fatalf("expected a message, got %d", len(messages))
+	}
+	message := messages[0]
+	if version, length := binary.BigEndian.Uint16(message), int(binary.BigEndian.Uint16(message[2:])); version != 10 || length != len(message) {
+		t.Errorf("expected an IPFIX message of %d bytes, got version %d of %d bytes", len(message), version, length)
+	}
+	if exportTime, sequence, domain := binary.BigEndian.Uint32(message[4:]), binary.BigEndian.Uint32(message[8:]), binary.BigEndian.Uint32(message[12:]); exportTime != uint32(now.Unix()) || sequence != 0 || domain != 7 {
+		t.Errorf("expected export time %d, sequence 0 and domain 7, got %d, %d and %d", now.Unix(), exportTime, sequence, domain)
+	}
+	sets := decode(t, message, ipfixHeaderSize)
+	if len(sets) != 3 || sets[0].id != ipfixTemplateSet || sets[1] != (decodedSet{ipv4TemplateID, 2}) || sets[2] != (decodedSet{ipv6TemplateID, 1}) {
+		t.Errorf("expected the templates, 2 IPv4 records and 1 IPv6 record, got %+v", sets)
+	}
+	// the first IPv4 record follows the template set and the set header
+	record := message[ipfixHeaderSize+setHeaderSize+2*(4+4*len(ipv4Template.fields))+setHeaderSize:]
+	if source, postNATSource := net.IP(record[0:4]), net.IP(record[13:17]); !source.Equal(flow.Source) || !postNATSource.Equal(flow.PostNATSource) {
+		t.Errorf("expected source %s translated to %s, got %s and %s", flow.Source, flow.PostNATSource, source, postNATSource)
+	}
+	if packets, bytes, sampling := binary.BigEndian.Uint64(record[25:]), binary.BigEndian.Uint64(record[33:]), binary.BigEndian.Uint32(record[41:]); packets != 10 || bytes != 1000 || sampling != 4 {
+		t.Errorf("expected 10 packets, 1000 bytes sampled 1 in 4, got %d, %d and %d", packets, bytes, sampling)
+	}
+	// IPFIX counts data records
+	if messages := e.Encode([]Flow{flow}, now); binary.BigEndian.Uint32(messages[0][8:]) != 3 {
+		t.Errorf("expected sequence 3, got %d", binary.BigEndian.Uint32(messages[0][8:]))
+	}
+
+	e = NewExporter(NETFLOW_V9, 7, 0, started)
+	var flows []Flow
+	for i := 0; i < 100; i++ {
+		flows = append(flows, flow)
+	}
+	messages = e.Encode(flows, now)
+	if len(messages) < 2 {
+		t.Fatalf("expected 100 records to take several messages, got %d", len(messages))
+	}
+	records := 0
+	for i, message := range messages {
+		if len(message) > MAX_MESSAGE_SIZE {
+			t.Errorf("message %d: %d bytes, over %d", i, len(message), MAX_MESSAGE_SIZE)
+		}
+		if version, sequence, uptime := binary.BigEndian.Uint16(message), binary.BigEndian.Uint32(message[12:]), binary.BigEndian.Uint32(message[4:]); version != 9 || sequence != uint32(i) || uptime != 90000 {
+			t.Errorf("message %d: expected NetFlow v9 sequence %d at uptime 90000, got version %d sequence %d at %d", i, i, version, sequence, uptime)
+		}
+		sets := decode(t, message, netflowHeaderSize)
+		if sets[0].id != netflowTemplateSet {
+			t.Errorf("message %d: expected the templates first, got %+v", i, sets)
+		}
+		count := 0
+		for _, set := range sets {
+			count += set.records
+		}
+		if int(binary.BigEndian.Uint16(message[2:])) != count+2 {
+			t.Errorf("message %d: expected a count of %d records and the 2 templates, got %d", i, count, binary.BigEndian.Uint16(message[2:]))
+		}
+		records += count
+	}
+	if records != 100 {
+		t.Errorf("expected 100 records, got %d", records)
+	}
+}
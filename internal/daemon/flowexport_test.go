@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFlows(t *testing.T) {
+	output := `tcp      6 431999 ESTABLISHED src=192.168.0.5 dst=8.8.8.8 sport=40000 dport=443 packets=10 bytes=1000 src=8.8.8.8 dst=10.0.0.10 sport=443 dport=1024 packets=8 bytes=5000 [ASSURED] mark=0 use=1
+udp      17 29 src=192.168.0.7 dst=8.8.8.8 sport=5353 dport=53 packets=1 bytes=60 src=8.8.8.8 dst=10.0.0.10 sport=53 dport=5353 packets=0 bytes=0 mark=0 use=1
+icmp     1 29 src=192.168.0.8 dst=1.1.1.1 type=8 code=0 id=7 packets=3 bytes=252 src=1.1.1.1 dst=10.0.0.10 type=0 code=0 id=7 packets=3 bytes=252 mark=0 use=1
+`
+	connections := parseConnections(output)
+	if len(connections) != 3 {
+		t.Fatalf("expected 3 connections, got %d", len(connections))
+	}
+	if c := connections[0]; c.protocol != 6 || c.original.sourcePort != 40000 || !c.reply.destination.Equal(net.ParseIP("10.0.0.10")) ||
+		c.counters != (connectionCounters{packets: 10, bytes: 1000, replyPackets: 8, replyBytes: 5000}) {
+		t.Errorf("unexpected connection %+v", c)
+	}
+
+	records, counters := flows(connections, nil, 0)
+	// the udp connection had no reply
+	if len(records) != 5 {
+		t.Fatalf("expected 5 flows, got %d", len(records))
+	}
+	out, in := records[0], records[1]
+	if !out.Source.Equal(net.ParseIP("192.168.0.5")) || !out.PostNATSource.Equal(net.ParseIP("10.0.0.10")) || out.PostNATSourcePort != 1024 || out.Packets != 10 || out.Bytes != 1000 {
+		t.Errorf("expected 192.168.0.5:40000 translated to 10.0.0.10:1024 with 10 packets and 1000 bytes, got %+v", out)
+	}
+	if !in.Source.Equal(net.ParseIP("8.8.8.8")) || !in.Destination.Equal(net.ParseIP("10.0.0.10")) || !in.PostNATDestination.Equal(net.ParseIP("192.168.0.5")) || in.PostNATDestinationPort != 40000 || in.Packets != 8 || in.Bytes != 5000 {
+		t.Errorf("expected 8.8.8.8:443 to 10.0.0.10:1024 translated to 192.168.0.5:40000 with 8 packets and 5000 bytes, got %+v", in)
+	}
+
+	// the next flows carry the traffic since
+	connections[0].counters.packets, connections[0].counters.bytes = 15, 1500
+	records, _ = flows(connections, counters, 0)
+	if len(records) != 1 || records[0].Packets != 5 || records[0].Bytes != 500 {
+		t.Errorf("expected a flow of 5 packets and 500 bytes, got %+v", records)
+	}
+
+	// a connection is sampled at every export or never
+	sampledRecords, _ := flows(connections, nil, 2)
+	for i := 0; i < 3; i++ {
+		if again, _ := flows(connections, nil, 2); len(again) != len(sampledRecords) {
+			t.Errorf("expected the same %d flows sampled, got %d", len(sampledRecords), len(again))
+		}
+	}
+}
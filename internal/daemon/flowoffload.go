@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// FLOW_OFFLOAD_CHAIN is the filter chain offloading the established
+	// connections of a router to FLOW_OFFLOAD_TABLE
+	FLOW_OFFLOAD_CHAIN string = "vr_flowoffload"
+	FLOW_OFFLOAD_TABLE string = "vr_flowtable"
+
+	// HardwareOffloadUnsupported is used as part of the Event 'reason' when
+	// the interfaces of a router can't offload its connections to hardware
+	HardwareOffloadUnsupported = "HardwareOffloadUnsupported"
+)
+
+// flowOffloadConfig is what the flowtable of a router is programmed with.
+type flowOffloadConfig struct {
+	hardware bool
+	// counter keeps the conntrack counters of offloaded connections for the
+	// flow export
+	counter bool
+}
+
+// flowOffloadConfigFor returns how the flowtable of the VirtualRouter is to
+// be programmed, or nil if it has none.
+func flowOffloadConfigFor(virtualrouter *v1.VirtualRouter) *flowOffloadConfig {
+	if virtualrouter.Spec.FlowOffload == nil {
+		return nil
+	}
+	return &flowOffloadConfig{
+		hardware: virtualrouter.Spec.FlowOffload.Hardware,
+		counter:  virtualrouter.Spec.FlowExport != nil,
+	}
+}
+
+// flowOffloadRuleset returns the flowtable of the router on its interfaces,
+// of both families, with the chain offloading its TCP and UDP connections
+// once established, empty if there is none. The first packets of a
+// connection go through the rules of the router as before.
+func flowOffloadRuleset(config *flowOffloadConfig, hardware bool) *packetfilter.Ruleset {
+	ruleset := &packetfilter.Ruleset{
+		Name:   FLOW_OFFLOAD_CHAIN,
+		Family: packetfilter.FamilyInet,
+		Type:   packetfilter.TypeFilter,
+		Hook:   packetfilter.HookForward,
+		Chains: []packetfilter.Chain{{Name: FLOW_OFFLOAD_CHAIN}},
+	}
+	if config == nil {
+		return ruleset
+	}
+	ruleset.FlowTable = &packetfilter.FlowTable{
+		Name:     FLOW_OFFLOAD_TABLE,
+		Devices:  []string{DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME, DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME},
+		Hardware: hardware,
+		Counter:  config.counter,
+	}
+	for _, protocol := range []string{"tcp", "udp"} {
+		ruleset.Chains[0].Rules = append(ruleset.Chains[0].Rules, packetfilter.Rule{
+			Match:       packetfilter.Match{Protocol: protocol, CtState: "established"},
+			FlowOffload: FLOW_OFFLOAD_TABLE,
+		})
+	}
+	return ruleset
+}
+
+// EnsureFlowOffload programs the flowtable of the router container of the
+// VirtualRouter on this node as its spec says, and clears it once it is gone.
+// Unlike the SNAT pool, it is only applied again when it changes, as
+// replacing it sends the offloaded connections back through the rules. Where
+// the interfaces refuse hardware offload, the software flowtable is
+// programmed instead and true returned.
+func (n *NetworkDaemon) EnsureFlowOffload(virtualrouter *v1.VirtualRouter) (bool, error) {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return false, nil
+	}
+	config := flowOffloadConfigFor(virtualrouter)
+	applied, exist := n.flowOffloads[containerName]
+	if config == nil && !exist || config != nil && exist && *config == *applied {
+		return false, nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return false, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return false, fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return false, err
+	}
+
+	if config == nil {
+		if err := backend.Delete(containerPid, flowOffloadRuleset(nil, false)); err != nil {
+			klog.ErrorS(err, "Deleting flowtable failed", "containerName", containerName)
+			return false, err
+		}
+		delete(n.flowOffloads, containerName)
+		klog.InfoS("Flow offload cleared", "containerName", containerName)
+		return false, nil
+	}
+	refused := false
+	if err := backend.Apply(containerPid, flowOffloadRuleset(config, config.hardware)); err != nil {
+		if !config.hardware {
+			klog.ErrorS(err, "Setting flowtable failed", "containerName", containerName)
+			return false, err
+		}
+		klog.ErrorS(err, "Setting hardware flowtable failed, falling back to software", "containerName", containerName)
+		if err := backend.Apply(containerPid, flowOffloadRuleset(config, false)); err != nil {
+			klog.ErrorS(err, "Setting flowtable failed", "containerName", containerName)
+			return false, err
+		}
+		refused = true
+	}
+	n.flowOffloads[containerName] = config
+	klog.InfoS("Flow offload set", "containerName", containerName, "hardware", config.hardware && !refused)
+	return refused, nil
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestFlowOffloadRuleset(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			FlowOffload: &v1.FlowOffload{Hardware: true},
+			FlowExport:  &v1.FlowExport{Collector: "10.0.0.100:4739"},
+		},
+	}
+	backend, err := packetfilter.New(packetfilter.NFTABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := flowOffloadConfigFor(virtualRouter)
+	expected := `table inet vr_flowoffload
+delete table inet vr_flowoffload
+table inet vr_flowoffload {
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_flowoffload
+	}
+	flowtable vr_flowtable {
+		hook ingress priority 0; devices = { "ethint", "ethext" };
+		flags offload;
+		counter
+	}
+	chain vr_flowoffload {
+		meta l4proto tcp ct state established flow add @vr_flowtable
+		meta l4proto udp ct state established flow add @vr_flowtable
+	}
+}
+`
+	if rules := backend.Compile(flowOffloadRuleset(config, config.hardware)); rules != expected {
+		t.Errorf("expected rules\n%s\ngot\n%s", expected, rules)
+	}
+
+	// software offload, without the flow export keeping the counters
+	virtualRouter.Spec.FlowExport = nil
+	config = flowOffloadConfigFor(virtualRouter)
+	expected = `table inet vr_flowoffload
+delete table inet vr_flowoffload
+table inet vr_flowoffload {
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_flowoffload
+	}
+	flowtable vr_flowtable {
+		hook ingress priority 0; devices = { "ethint", "ethext" };
+	}
+	chain vr_flowoffload {
+		meta l4proto tcp ct state established flow add @vr_flowtable
+		meta l4proto udp ct state established flow add @vr_flowtable
+	}
+}
+`
+	if rules := backend.Compile(flowOffloadRuleset(config, false)); rules != expected {
+		t.Errorf("expected rules\n%s\ngot\n%s", expected, rules)
+	}
+
+	virtualRouter.Spec.FlowOffload = nil
+	if config := flowOffloadConfigFor(virtualRouter); config != nil {
+		t.Errorf("expected no flow offload, got %+v", config)
+	}
+}
+
+func TestFlowOffloadFeatures(t *testing.T) {
+	spec := v1.VirtualRouterSpec{FlowOffload: &v1.FlowOffload{}}
+	supported := internalNetlink.FeatureMatrix{
+		internalNetlink.FeaturePolicyRouting: true,
+		internalNetlink.FeatureNftables:      true,
+		internalNetlink.FeatureIptables:      true,
+		internalNetlink.FeatureFlowtable:     true,
+	}
+	if err := (&NetworkDaemon{features: supported}).CheckFeatures(spec); err != nil {
+		t.Errorf("expected flow offload to be supported, got %v", err)
+	}
+	// iptables has no flowtables
+	err := (&NetworkDaemon{features: supported, packetFilterBackend: internalNetlink.FeatureIptables}).CheckFeatures(spec)
+	if unsupported, ok := err.(*UnsupportedFeatureError); !ok || unsupported.Feature != string(internalNetlink.FeatureNftables) {
+		t.Errorf("expected nftables to be required, got %v", err)
+	}
+	supported[internalNetlink.FeatureFlowtable] = false
+	err = (&NetworkDaemon{features: supported}).CheckFeatures(spec)
+	if unsupported, ok := err.(*UnsupportedFeatureError); !ok || unsupported.Feature != string(internalNetlink.FeatureFlowtable) {
+		t.Errorf("expected flowtables to be required, got %v", err)
+	}
+}
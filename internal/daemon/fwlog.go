@@ -0,0 +1,463 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/nflog"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	// FIREWALL_LOG_CHAIN is the filter chain logging the forwarded packets
+	// the FireWallRules of a router match, ahead of them
+	FIREWALL_LOG_CHAIN string = "vr_fwlog"
+	// FIREWALL_LOG_GROUP is the NFLOG group the packets are logged to, read
+	// back in the network namespace of the router
+	FIREWALL_LOG_GROUP int = 100
+	// FIREWALL_LOG_PREFIX starts the prefix of the logged packets, followed
+	// by the hash of the rule matching them
+	FIREWALL_LOG_PREFIX string = "vrfw:"
+	// DEFAULT_FIREWALL_LOG_RATE is the most packets of a rule logged per
+	// second unless given
+	DEFAULT_FIREWALL_LOG_RATE int32 = 10
+
+	// syslogFacility is local0, the severity is added by the policy
+	syslogFacility = 16
+	// syslogStructuredDataID names the structured data of the messages, under
+	// the private enterprise number reserved for documentation
+	syslogStructuredDataID = "vrfw@32473"
+	// firewallLogRetry is how long the logger waits to capture again once
+	// the capture stopped
+	firewallLogRetry = 10 * time.Second
+)
+
+// dialSyslog connects to the syslog endpoint over udp or tcp
+var dialSyslog = func(network, address string) (net.Conn, error) {
+	return net.DialTimeout(network, address, 10*time.Second)
+}
+
+// loggedRule is a rule of FIREWALL_RULE_CHAIN whose packets are logged, with
+// the FireWallRules it was rendered from.
+type loggedRule struct {
+	key, policy string
+	// ids are the rules of FireWallRules rendered as the rule, such as
+	// router-ns/allow-web[0]
+	ids []string
+}
+
+// firewallLogger logs the packets the FireWallRules of a router container
+// match to a syslog endpoint.
+type firewallLogger struct {
+	namespace, name string
+	spec            v1.FirewallLogging
+	dualStack       bool
+	// rulesets are the logging rulesets applied
+	rulesets []*packetfilter.Ruleset
+	cancel   context.CancelFunc
+
+	// rules are the logged rules by the prefix of their packets, refreshed
+	// while the logger runs
+	rules   map[string]loggedRule
+	rulesMu sync.Mutex
+}
+
+// firewallLogPrefix returns the prefix the packets of the rule are logged
+// with. NFLOG prefixes are short, so the rule is told by a hash of its key.
+func firewallLogPrefix(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%s%08x", FIREWALL_LOG_PREFIX, h.Sum32())
+}
+
+// loggedPolicies returns the policies of the rules whose packets are logged.
+func loggedPolicies(logging v1.FirewallLogging) map[string]bool {
+	policies := map[string]bool{}
+	for _, policy := range logging.Policies {
+		policies[string(policy)] = true
+	}
+	if len(policies) == 0 {
+		policies[string(v1.FirewallDrop)] = true
+	}
+	return policies
+}
+
+// firewallLogRuleset returns the chain logging the packets of the rules of
+// FIREWALL_RULE_CHAIN with a logged policy, adding them to logged. Every rule
+// up to the last one logged is mirrored by one returning the packets it
+// matches, so a packet is logged for the first rule matching it only, the
+// one taking its policy.
+func firewallLogRuleset(family packetfilter.Family, rules []renderedFirewallRule, logging v1.FirewallLogging, logged map[string]loggedRule) *packetfilter.Ruleset {
+	ruleset := &packetfilter.Ruleset{
+		Name:   FIREWALL_LOG_CHAIN,
+		Family: family,
+		Type:   packetfilter.TypeFilter,
+		Hook:   packetfilter.HookForward,
+		Chains: []packetfilter.Chain{{Name: FIREWALL_LOG_CHAIN}},
+	}
+	policies := loggedPolicies(logging)
+	rate := logging.RateLimit
+	if rate == 0 {
+		rate = DEFAULT_FIREWALL_LOG_RATE
+	}
+	last := -1
+	for i, rule := range rules {
+		if policies[rule.policy] {
+			last = i
+		}
+	}
+	var chainRules []packetfilter.Rule
+	for _, rule := range rules[:last+1] {
+		match := packetfilter.Match{Source: rule.srcIP, Destination: rule.dstIP, Protocol: rule.protocol}
+		if policies[rule.policy] {
+			prefix := firewallLogPrefix(rule.key())
+			logMatch := match
+			logMatch.Limit = &packetfilter.RateLimit{Rate: int(rate), Burst: int(rate)}
+			chainRules = append(chainRules, packetfilter.Rule{
+				Match: logMatch,
+				Log:   &packetfilter.NFLog{Group: FIREWALL_LOG_GROUP, Prefix: prefix},
+			})
+			logged[prefix] = loggedRule{key: rule.key(), policy: rule.policy}
+		}
+		chainRules = append(chainRules, packetfilter.Rule{Match: match, Return: true})
+	}
+	ruleset.Chains[0].Rules = chainRules
+	return ruleset
+}
+
+// EnsureFirewallLogging logs the packets matched by the FireWallRules of the
+// router container of the VirtualRouter on this node as its spec says, given
+// the rules of the FireWallRules by FirewallRuleKey, and stops once the spec
+// drops it. The rules are read from the data plane of the router, so they
+// are logged as the router renders them.
+func (n *NetworkDaemon) EnsureFirewallLogging(virtualrouter *v1.VirtualRouter, ruleIDs map[string][]string) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	logging := virtualrouter.Spec.Logging
+	applied, exist := n.firewallLoggers[containerName]
+	if logging == nil && !exist {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+
+	if logging == nil {
+		for _, ruleset := range applied.rulesets {
+			if err := backend.Delete(containerPid, ruleset); err != nil {
+				klog.ErrorS(err, "Deleting firewall logging rules failed", "containerName", containerName, "family", ruleset.Family)
+				return err
+			}
+		}
+		n.stopFirewallLogging(containerName)
+		klog.InfoS("Firewall logging stopped", "containerName", containerName)
+		return nil
+	}
+
+	dualStack := virtualroutermanager.IsDualStack(virtualrouter.Spec)
+	namespace := virtualroutermanager.RouterNamespace(virtualrouter)
+	rules := map[string]loggedRule{}
+	var rulesets []*packetfilter.Ruleset
+	for _, family := range hardeningFamilies(dualStack) {
+		command := "iptables-save"
+		if family == packetfilter.FamilyIPv6 {
+			command = "ip6tables-save"
+		}
+		output, err := iptablesSave(containerPid, command)
+		if err != nil {
+			return err
+		}
+		rulesets = append(rulesets, firewallLogRuleset(family, parseFirewallRules(string(output)), *logging, rules))
+	}
+	for prefix, rule := range rules {
+		for _, id := range ruleIDs[rule.key] {
+			rule.ids = append(rule.ids, namespace+"/"+id)
+		}
+		rules[prefix] = rule
+	}
+
+	var previous []*packetfilter.Ruleset
+	if exist {
+		previous = applied.rulesets
+	}
+	if !reflect.DeepEqual(rulesets, previous) {
+		if err := packetfilter.ApplyAll(backend, containerPid, rulesets, previous); err != nil {
+			klog.ErrorS(err, "Setting firewall logging rules failed", "containerName", containerName)
+			return err
+		}
+		// families no longer logged are cleared once the others are in place
+		for _, ruleset := range previous {
+			if findFamily(rulesets, ruleset.Family) {
+				continue
+			}
+			if err := backend.Delete(containerPid, ruleset); err != nil {
+				klog.ErrorS(err, "Deleting firewall logging rules failed", "containerName", containerName, "family", ruleset.Family)
+				return err
+			}
+		}
+	}
+
+	if exist && applied.spec.Endpoint == logging.Endpoint {
+		applied.spec, applied.dualStack, applied.rulesets = *logging, dualStack, rulesets
+		applied.setRules(rules)
+		return nil
+	}
+	n.stopFirewallLogging(containerName)
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := &firewallLogger{
+		namespace: virtualrouter.Namespace,
+		name:      virtualrouter.Name,
+		spec:      *logging,
+		dualStack: dualStack,
+		rulesets:  rulesets,
+		cancel:    cancel,
+		rules:     rules,
+	}
+	n.firewallLoggers[containerName] = logger
+	go logger.run(ctx, containerPid)
+	klog.InfoS("Firewall logging set", "containerName", containerName, "endpoint", logging.Endpoint, "rules", len(rules))
+	return nil
+}
+
+func findFamily(rulesets []*packetfilter.Ruleset, family packetfilter.Family) bool {
+	for _, ruleset := range rulesets {
+		if ruleset.Family == family {
+			return true
+		}
+	}
+	return false
+}
+
+// stopFirewallLogging stops logging the packets of the router container. The
+// logging rules go away with its network namespace, or are deleted first.
+func (n *NetworkDaemon) stopFirewallLogging(containerName string) {
+	logger, exist := n.firewallLoggers[containerName]
+	if !exist {
+		return
+	}
+	logger.cancel()
+	delete(n.firewallLoggers, containerName)
+}
+
+// firewallLoggingRouters returns the namespaces and names of the
+// VirtualRouters whose packets are logged, to be refreshed as their rules
+// change.
+func (n *NetworkDaemon) firewallLoggingRouters() [][2]string {
+	var routers [][2]string
+	for _, logger := range n.firewallLoggers {
+		routers = append(routers, [2]string{logger.namespace, logger.name})
+	}
+	return routers
+}
+
+func (l *firewallLogger) setRules(rules map[string]loggedRule) {
+	l.rulesMu.Lock()
+	defer l.rulesMu.Unlock()
+	l.rules = rules
+}
+
+func (l *firewallLogger) rule(prefix string) (loggedRule, bool) {
+	l.rulesMu.Lock()
+	defer l.rulesMu.Unlock()
+	rule, exist := l.rules[prefix]
+	return rule, exist
+}
+
+// run captures the packets logged in the network namespace of the process
+// and sends them to the syslog endpoint until the context is done, capturing
+// again whenever the capture stops.
+func (l *firewallLogger) run(ctx context.Context, pid int) {
+	endpoint := strings.SplitN(l.spec.Endpoint, "://", 2)
+	writer := &syslogWriter{network: endpoint[0], address: endpoint[1]}
+	defer writer.close()
+	hostname, _ := os.Hostname()
+	for {
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(capture(ctx, pid, fmt.Sprintf("nflog:%d", FIREWALL_LOG_GROUP), "", w))
+		}()
+		err := l.forward(r, writer, hostname)
+		r.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		klog.ErrorS(err, "Capturing firewall logs stopped", "namespace", l.namespace, "virtualRouter", l.name)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(firewallLogRetry):
+		}
+	}
+}
+
+// forward sends the packets of the pcap stream logged by the rules of the
+// logger to the syslog endpoint, until the stream ends.
+func (l *firewallLogger) forward(r io.Reader, writer *syslogWriter, hostname string) error {
+	reader, err := nflog.NewReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		packet, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		rule, exist := l.rule(packet.Prefix)
+		if !exist {
+			klog.V(4).InfoS("Dropping the log of no firewall rule", "virtualRouter", l.name, "prefix", packet.Prefix)
+			continue
+		}
+		writer.write(syslogMessage(hostname, l.namespace, l.name, rule, packet))
+	}
+}
+
+// syslogMessage formats the packet logged by the rule as an RFC 5424 message,
+// with the FireWallRules of the rule and the packet in structured data.
+func syslogMessage(hostname, namespace, name string, rule loggedRule, packet *nflog.Packet) string {
+	severity := 4
+	if rule.policy == string(v1.FirewallAccept) {
+		severity = 6
+	}
+	if hostname == "" {
+		hostname = "-"
+	}
+	firewallRules := strings.Join(rule.ids, ",")
+	params := [][2]string{
+		{"virtualRouter", namespace + "/" + name},
+		{"firewallRules", firewallRules},
+		{"rule", rule.key},
+		{"action", rule.policy},
+		{"protocol", packet.ProtocolName()},
+		{"src", packet.Source.String()},
+		{"dst", packet.Destination.String()},
+	}
+	source, destination := packet.Source.String(), packet.Destination.String()
+	switch packet.Protocol {
+	case 6, 17, 132:
+		params = append(params, [2]string{"sport", fmt.Sprint(packet.SourcePort)}, [2]string{"dport", fmt.Sprint(packet.DestinationPort)})
+		source = net.JoinHostPort(source, fmt.Sprint(packet.SourcePort))
+		destination = net.JoinHostPort(destination, fmt.Sprint(packet.DestinationPort))
+	}
+	params = append(params, [2]string{"length", fmt.Sprint(packet.Length)})
+
+	var data strings.Builder
+	data.WriteString("[" + syslogStructuredDataID)
+	for _, param := range params {
+		fmt.Fprintf(&data, ` %s="%s"`, param[0], escapeStructuredData(param[1]))
+	}
+	data.WriteString("]")
+	if firewallRules == "" {
+		firewallRules = "no FireWallRule"
+	}
+	return fmt.Sprintf("<%d>1 %s %s virtualrouter - firewall %s %s %s %s -> %s by %s",
+		syslogFacility*8+severity, packet.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname,
+		data.String(), rule.policy, packet.ProtocolName(), source, destination, firewallRules)
+}
+
+// escapeStructuredData escapes the characters RFC 5424 doesn't allow in a
+// structured data parameter value as they are.
+func escapeStructuredData(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// syslogWriter sends messages to a syslog endpoint, connecting again after a
+// failure. Messages over tcp are framed by a newline, those over udp are a
+// datagram each.
+type syslogWriter struct {
+	network, address string
+	conn             net.Conn
+	// failing is set once a message couldn't be sent, not to log every
+	// failure until one is sent again
+	failing bool
+}
+
+func (w *syslogWriter) write(message string) {
+	err := w.send(message)
+	if err != nil {
+		if w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+		if !w.failing {
+			klog.ErrorS(err, "Sending firewall logs failed", "endpoint", w.network+"://"+w.address)
+		}
+	}
+	w.failing = err != nil
+}
+
+func (w *syslogWriter) send(message string) error {
+	if w.conn == nil {
+		conn, err := dialSyslog(w.network, w.address)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	if w.network == "tcp" {
+		message += "\n"
+	}
+	_, err := w.conn.Write([]byte(message))
+	return err
+}
+
+func (w *syslogWriter) close() {
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// firewallRuleIDs returns the rules of the FireWallRules of the router
+// namespace of the VirtualRouter by FirewallRuleKey, nothing being listed
+// for routers not logging them. Rules are logged without them if they can't
+// be listed.
+func (c *Controller) firewallRuleIDs(virtualRouter *v1.VirtualRouter) map[string][]string {
+	if c.dynamicclient == nil || virtualRouter.Spec.Logging == nil {
+		return nil
+	}
+	ids, err := virtualroutermanager.FirewallRuleIDs(c.dynamicclient, virtualroutermanager.RouterNamespace(virtualRouter))
+	if err != nil {
+		klog.ErrorS(err, "Listing FireWallRules failed", "virtualRouter", klog.KObj(virtualRouter))
+	}
+	return ids
+}
+
+// refreshFirewallLogging logs the packets of the rules of the routers logging
+// them as they are now, the router rendering FireWallRules changed since.
+func (c *Controller) refreshFirewallLogging() {
+	for _, router := range c.networkDaemon.firewallLoggingRouters() {
+		virtualRouter, err := c.virtualRoutersLister.VirtualRouters(router[0]).Get(router[1])
+		if err != nil {
+			continue
+		}
+		virtualRouter = effectiveVirtualRouter(virtualRouter)
+		if err := c.networkDaemon.EnsureFirewallLogging(virtualRouter, c.firewallRuleIDs(virtualRouter)); err != nil {
+			klog.ErrorS(err, "Refreshing firewall logging failed", "virtualRouter", klog.KObj(virtualRouter))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/nflog"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestFirewallLogRuleset(t *testing.T) {
+	output := `*filter
+:forward_fwrule - [0:0]
+[3:180] -A forward_fwrule -d 10.0.0.10/32 -p tcp -j ACCEPT
+[9:900] -A forward_fwrule -s 10.0.0.0/24 -j DROP
+[0:0] -A forward_fwrule -s 10.0.1.0/24 -j ACCEPT
+COMMIT
+`
+	rules := parseFirewallRules(output)
+	logged := map[string]loggedRule{}
+	ruleset := firewallLogRuleset(packetfilter.FamilyIPv4, rules, v1.FirewallLogging{Endpoint: "udp://syslog:514", RateLimit: 5}, logged)
+
+	// the accepted packets are returned before the dropped ones are logged,
+	// and nothing follows the last rule logged
+	prefix := firewallLogPrefix(rules[1].key())
+	expected := []packetfilter.Rule{
+		{Match: packetfilter.Match{Destination: "10.0.0.10/32", Protocol: "tcp"}, Return: true},
+		{Match: packetfilter.Match{Source: "10.0.0.0/24", Limit: &packetfilter.RateLimit{Rate: 5, Burst: 5}}, Log: &packetfilter.NFLog{Group: FIREWALL_LOG_GROUP, Prefix: prefix}},
+		{Match: packetfilter.Match{Source: "10.0.0.0/24"}, Return: true},
+	}
+	if !reflect.DeepEqual(ruleset.Chains[0].Rules, expected) {
+		t.Errorf("expected rules %+v, got %+v", expected, ruleset.Chains[0].Rules)
+	}
+	if len(logged) != 1 || logged[prefix].policy != "DROP" || logged[prefix].key != rules[1].key() {
+		t.Errorf("expected the DROP rule logged with prefix %s, got %+v", prefix, logged)
+	}
+
+	logged = map[string]loggedRule{}
+	ruleset = firewallLogRuleset(packetfilter.FamilyIPv4, rules, v1.FirewallLogging{Policies: []v1.FirewallPolicy{v1.FirewallAccept}}, logged)
+	if len(ruleset.Chains[0].Rules) != 5 || len(logged) != 2 || ruleset.Chains[0].Rules[0].Limit.Rate != int(DEFAULT_FIREWALL_LOG_RATE) {
+		t.Errorf("expected both ACCEPT rules logged at the default rate, got %+v", ruleset.Chains[0].Rules)
+	}
+}
+
+func TestSyslogMessage(t *testing.T) {
+	rule := loggedRule{key: "10.0.0.0/24|||DROP", policy: "DROP", ids: []string{"router-ns/deny-lan[1]"}}
+	packet := &nflog.Packet{
+		Time:            time.Date(2021, 11, 1, 9, 30, 0, 5000, time.UTC),
+		Protocol:        6,
+		Source:          net.ParseIP("10.0.0.2"),
+		Destination:     net.ParseIP("8.8.8.8"),
+		SourcePort:      40000,
+		DestinationPort: 443,
+		Length:          60,
+	}
+	expected := `<132>1 2021-11-01T09:30:00.000005Z node-a virtualrouter - firewall [vrfw@32473 virtualRouter="tenant/vr" firewallRules="router-ns/deny-lan[1\]" ` +
+		`rule="10.0.0.0/24|||DROP" action="DROP" protocol="tcp" src="10.0.0.2" dst="8.8.8.8" sport="40000" dport="443" length="60"] ` +
+		`DROP tcp 10.0.0.2:40000 -> 8.8.8.8:443 by router-ns/deny-lan[1]`
+	if message := syslogMessage("node-a", "tenant", "vr", rule, packet); message != expected {
+		t.Errorf("expected message\n%s\ngot\n%s", expected, message)
+	}
+}
+
+func TestFirewallRuleIDs(t *testing.T) {
+	dynamicclient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	c := &Controller{dynamicclient: dynamicclient}
+	virtualRouter := &v1.VirtualRouter{ObjectMeta: metav1.ObjectMeta{Name: "vr", Namespace: "tenant"}}
+
+	// routers not logging cost no List
+	if ids := c.firewallRuleIDs(virtualRouter); ids != nil {
+		t.Errorf("expected no rule IDs, got %v", ids)
+	}
+	if actions := dynamicclient.Actions(); len(actions) != 0 {
+		t.Errorf("expected no actions, got %v", actions)
+	}
+
+	virtualRouter.Spec.Logging = &v1.FirewallLogging{Endpoint: "udp://syslog:514"}
+	c.firewallRuleIDs(virtualRouter)
+	if actions := dynamicclient.Actions(); len(actions) != 1 || !actions[0].Matches("list", "firewallrules") {
+		t.Errorf("expected the FireWallRules to be listed, got %v", actions)
+	}
+}
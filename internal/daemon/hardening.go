@@ -0,0 +1,226 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	// HARDENING_CHAIN is the filter chain dropping the forwarded traffic
+	// flooding a router, ahead of its FireWallRules
+	HARDENING_CHAIN string = "vr_hardening"
+
+	// the reasons packets are dropped for, the counters of the rules
+	HARDENING_INVALID   = "invalid"
+	HARDENING_CONNLIMIT = "connlimit"
+	HARDENING_SYN_FLOOD = "syn-flood"
+)
+
+var hardeningDropped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: virtualroutermanager.HARDENING_DROPPED_METRIC,
+	Help: "Packets dropped by the firewall hardening of a router since it was set up, by reason.",
+}, []string{"namespace", "virtualrouter", "reason"})
+
+// hardeningConfig is what the firewall hardening of a router is programmed
+// with.
+type hardeningConfig struct {
+	namespace, name string
+	hardening       v1.FirewallHardening
+	dualStack       bool
+}
+
+// hardeningConfigFor returns how the firewall hardening of the VirtualRouter
+// is to be programmed, or nil if it has none.
+func hardeningConfigFor(virtualrouter *v1.VirtualRouter) *hardeningConfig {
+	hardening := virtualrouter.Spec.FirewallHardening
+	if hardening == nil || *hardening == (v1.FirewallHardening{}) {
+		return nil
+	}
+	return &hardeningConfig{
+		namespace: virtualrouter.Namespace,
+		name:      virtualrouter.Name,
+		hardening: *hardening,
+		dualStack: virtualroutermanager.IsDualStack(virtualrouter.Spec),
+	}
+}
+
+// hardeningRuleset returns the filter chain of the hardening for the family,
+// empty if there is none. Invalid packets are dropped first, so they count
+// towards no limit.
+func hardeningRuleset(family packetfilter.Family, config *hardeningConfig) *packetfilter.Ruleset {
+	ruleset := &packetfilter.Ruleset{
+		Name:   HARDENING_CHAIN,
+		Family: family,
+		Type:   packetfilter.TypeFilter,
+		Hook:   packetfilter.HookForward,
+		Chains: []packetfilter.Chain{{Name: HARDENING_CHAIN}},
+	}
+	if config == nil {
+		return ruleset
+	}
+	hardening := config.hardening
+	var rules []packetfilter.Rule
+	if hardening.DropInvalid {
+		rules = append(rules, packetfilter.Rule{
+			Match:   packetfilter.Match{CtState: "invalid"},
+			Drop:    true,
+			Counter: HARDENING_INVALID,
+		})
+	}
+	if hardening.MaxConnectionsPerSource > 0 {
+		rules = append(rules, packetfilter.Rule{
+			Match:   packetfilter.Match{CtState: "new", ConnLimitAbove: int(hardening.MaxConnectionsPerSource)},
+			Drop:    true,
+			Counter: HARDENING_CONNLIMIT,
+		})
+	}
+	if hardening.SYNRate > 0 {
+		burst := hardening.SYNBurst
+		if burst == 0 {
+			burst = hardening.SYNRate
+		}
+		rules = append(rules, packetfilter.Rule{
+			Match:  packetfilter.Match{TCPSyn: true, Limit: &packetfilter.RateLimit{Rate: int(hardening.SYNRate), Burst: int(burst)}},
+			Return: true,
+		}, packetfilter.Rule{
+			Match:   packetfilter.Match{TCPSyn: true},
+			Drop:    true,
+			Counter: HARDENING_SYN_FLOOD,
+		})
+	}
+	ruleset.Chains[0].Rules = rules
+	return ruleset
+}
+
+// hardeningFamilies are the families the hardening of a router is programmed
+// for.
+func hardeningFamilies(dualStack bool) []packetfilter.Family {
+	if dualStack {
+		return []packetfilter.Family{packetfilter.FamilyIPv4, packetfilter.FamilyIPv6}
+	}
+	return []packetfilter.Family{packetfilter.FamilyIPv4}
+}
+
+// EnsureHardening programs the firewall hardening of the router container of
+// the VirtualRouter on this node as its spec says, and clears it once it is
+// gone. Like the SNAT pool, it is applied again on every call.
+func (n *NetworkDaemon) EnsureHardening(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	config := hardeningConfigFor(virtualrouter)
+	applied, exist := n.hardening[containerName]
+	if config == nil && !exist {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+
+	if config != nil {
+		// the families are replaced together, or left as they were
+		var rulesets, previous []*packetfilter.Ruleset
+		for _, family := range hardeningFamilies(config.dualStack) {
+			rulesets = append(rulesets, hardeningRuleset(family, config))
+		}
+		if exist {
+			for _, family := range hardeningFamilies(applied.dualStack) {
+				previous = append(previous, hardeningRuleset(family, applied))
+			}
+		}
+		if err := packetfilter.ApplyAll(backend, containerPid, rulesets, previous); err != nil {
+			klog.ErrorS(err, "Setting firewall hardening rules failed", "containerName", containerName)
+			return err
+		}
+	}
+	// families no longer hardened are cleared once the others are in place
+	if exist && (config == nil || applied.dualStack && !config.dualStack) {
+		families := hardeningFamilies(applied.dualStack)
+		if config != nil {
+			families = families[1:]
+		}
+		for _, family := range families {
+			if err := backend.Delete(containerPid, hardeningRuleset(family, nil)); err != nil {
+				klog.ErrorS(err, "Deleting firewall hardening rules failed", "containerName", containerName, "family", family)
+				return err
+			}
+		}
+	}
+	if config == nil {
+		n.clearHardening(containerName)
+		klog.InfoS("Firewall hardening cleared", "containerName", containerName)
+		return nil
+	}
+	if !exist {
+		klog.InfoS("Firewall hardening set", "containerName", containerName)
+	}
+	n.hardening[containerName] = config
+	return nil
+}
+
+// clearHardening forgets the firewall hardening of the router container, and
+// its metrics.
+func (n *NetworkDaemon) clearHardening(containerName string) {
+	config, exist := n.hardening[containerName]
+	if !exist {
+		return
+	}
+	delete(n.hardening, containerName)
+	for _, reason := range []string{HARDENING_INVALID, HARDENING_CONNLIMIT, HARDENING_SYN_FLOOD} {
+		hardeningDropped.DeleteLabelValues(config.namespace, config.name, reason)
+	}
+}
+
+// exportHardeningMetrics updates the dropped packet metrics of the firewall
+// hardening of the attached router pods, of both families for dual-stack
+// routers.
+func (n *NetworkDaemon) exportHardeningMetrics() error {
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+	for containerName, config := range n.hardening {
+		containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+		if containerID == "" {
+			continue
+		}
+		containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+		if containerPid <= 0 {
+			continue
+		}
+		dropped := map[string]uint64{}
+		for _, family := range hardeningFamilies(config.dualStack) {
+			counters, err := backend.Counters(containerPid, hardeningRuleset(family, config))
+			if err != nil {
+				klog.ErrorS(err, "Reading firewall hardening counters failed", "containerName", containerName, "family", family)
+				continue
+			}
+			for reason, packets := range counters {
+				dropped[reason] += packets
+			}
+		}
+		for reason, packets := range dropped {
+			hardeningDropped.WithLabelValues(config.namespace, config.name, reason).Set(float64(packets))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestHardeningRuleset(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			FirewallHardening: &v1.FirewallHardening{DropInvalid: true, MaxConnectionsPerSource: 100, SYNRate: 50},
+		},
+	}
+	backend, err := packetfilter.New(packetfilter.IPTABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the burst defaults to the rate
+	expected := `*filter
+:vr_hardening - [0:0]
+-A vr_hardening -m conntrack --ctstate INVALID -m comment --comment invalid -j DROP
+-A vr_hardening -m conntrack --ctstate NEW -m connlimit --connlimit-above 100 -m comment --comment connlimit -j DROP
+-A vr_hardening -p tcp --syn -m limit --limit 50/second --limit-burst 50 -j RETURN
+-A vr_hardening -p tcp --syn -m comment --comment syn-flood -j DROP
+COMMIT
+`
+	config := hardeningConfigFor(virtualRouter)
+	if rules := backend.Compile(hardeningRuleset(packetfilter.FamilyIPv4, config)); rules != expected {
+		t.Errorf("expected rules\n%s\ngot\n%s", expected, rules)
+	}
+	if families := hardeningFamilies(config.dualStack); len(families) != 1 {
+		t.Errorf("expected IPv4 only, got %v", families)
+	}
+
+	// hardening with nothing set is none
+	virtualRouter.Spec.FirewallHardening = &v1.FirewallHardening{}
+	if config := hardeningConfigFor(virtualRouter); config != nil {
+		t.Errorf("expected no hardening, got %+v", config)
+	}
+	if rules := backend.Compile(hardeningRuleset(packetfilter.FamilyIPv4, nil)); rules != "*filter\n:vr_hardening - [0:0]\nCOMMIT\n" {
+		t.Errorf("expected empty chain, got\n%s", rules)
+	}
+}
@@ -0,0 +1,472 @@
+package daemon
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink/fake"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	fakeContainerID  = "0123456789abcdef"
+	fakeContainerPid = 4242
+)
+
+// newFakeDaemon returns a daemon attaching router containers with a fake
+// netlink backend, the router container of every VirtualRouter running
+func newFakeDaemon(t *testing.T) (*NetworkDaemon, *fake.Backend) {
+	lookupID, lookupPid := lookupContainerID, lookupContainerPid
+	t.Cleanup(func() { lookupContainerID, lookupContainerPid = lookupID, lookupPid })
+	lookupContainerID = func(n *NetworkDaemon, containerName string) string { return fakeContainerID }
+	lookupContainerPid = func(n *NetworkDaemon, containerID string) int { return fakeContainerPid }
+
+	backend := fake.NewBackend("intbr", "extbr")
+	n := NewDaemon(&internalCrio.CrioConfig{}, &internalNetlink.Config{
+		InternalBridgeName: "intbr",
+		ExternalBridgeName: "extbr",
+	}, internalNetlink.FeatureNftables)
+	n.netlink = backend
+	return n, backend
+}
+
+func TestAttachingPod(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			VlanNumber:      100,
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+		},
+	}
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil {
+		t.Fatalf("unexpected error attaching the pod: %v", err)
+	}
+
+	internal, external := backend.HostLink("int0123456"), backend.HostLink("ext0123456")
+	if internal == nil || internal.Bridge != "intbr" || !reflect.DeepEqual(internal.Vlans, []int{100}) {
+		t.Errorf("expected the internal veth in vlan 100 of the internal bridge, got %+v", internal)
+	}
+	if external == nil || external.Bridge != "extbr" {
+		t.Errorf("expected the external veth in the external bridge, got %+v", external)
+	}
+	ns := backend.Namespace(fakeContainerPid)
+	if ns == nil {
+		t.Fatalf("expected the container to be attached")
+	}
+	if link := ns.Links["ethint"]; link == nil || !link.Up || !reflect.DeepEqual(link.Addresses, []string{"10.0.0.1/24"}) {
+		t.Errorf("expected ethint up with 10.0.0.1/24, got %+v", link)
+	}
+	if link := ns.Links["ethext"]; link == nil || !link.Up || !reflect.DeepEqual(link.Addresses, []string{"192.168.9.10/24"}) {
+		t.Errorf("expected ethext up with 192.168.9.10/24, got %+v", link)
+	}
+	if expected := []fake.Rule{{Family: remoteNetlink.FAMILY_V4, Mark: DEFAULT_MASK_NUMBER, Table: DEFAULT_TABLE_NUMBER}}; !reflect.DeepEqual(ns.Rules, expected) {
+		t.Errorf("expected the marked traffic looked up in the router table, got %+v", ns.Rules)
+	}
+	expected := []fake.Route{
+		{Family: remoteNetlink.FAMILY_V4, Table: DEFAULT_TABLE_NUMBER, Gw: "192.168.9.1"},
+		{Family: remoteNetlink.FAMILY_V4, Table: DEFAULT_TABLE_NUMBER, Dst: "10.0.0.0/24", Link: "ethint"},
+		{Family: remoteNetlink.FAMILY_V4, Table: DEFAULT_TABLE_NUMBER, Dst: "192.168.9.0/24", Link: "ethext"},
+	}
+	if routes := ns.Table(DEFAULT_TABLE_NUMBER); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the router table %+v, got %+v", expected, routes)
+	}
+
+	// attaching again changes nothing
+	actions := len(backend.Actions())
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil || len(backend.Actions()) != actions {
+		t.Errorf("expected attaching again to do nothing, got %v, %v", err, backend.Actions()[actions:])
+	}
+
+	// a changed spec is applied on its own
+	spec := virtualrouter.Spec
+	spec.VlanNumber = 200
+	spec.GatewayIP = "192.168.9.254"
+	if err := n.Sync("router", spec); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+	if expected := []string{"SetVlan", "SetDefaultRoute2Container"}; !reflect.DeepEqual(backend.Actions()[actions:], expected) {
+		t.Errorf("expected actions %v, got %v", expected, backend.Actions()[actions:])
+	}
+	if internal := backend.HostLink("int0123456"); !reflect.DeepEqual(internal.Vlans, []int{200}) {
+		t.Errorf("expected the internal veth moved to vlan 200, got %v", internal.Vlans)
+	}
+	if routes := backend.Namespace(fakeContainerPid).Table(DEFAULT_TABLE_NUMBER); routes[0].Gw != "192.168.9.254" {
+		t.Errorf("expected the default route via 192.168.9.254, got %+v", routes)
+	}
+
+	if err := n.DettachingPod("router-0"); err != nil {
+		t.Fatalf("unexpected error detaching the pod: %v", err)
+	}
+	if backend.HostLink("int0123456") != nil || backend.HostLink("ext0123456") != nil {
+		t.Errorf("expected the veths to be removed")
+	}
+	if links := backend.Namespace(fakeContainerPid).Links; len(links) != 0 {
+		t.Errorf("expected no links left in the container, got %v", links)
+	}
+}
+
+func TestAttachingPodInternalNetworks(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			VlanNumber:      100,
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+			InternalNetworks: []v1.InternalNetwork{
+				{Name: "tenant-a", VlanNumber: 101, CIDR: "10.1.0.1/24"},
+			},
+		},
+	}
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil {
+		t.Fatalf("unexpected error attaching the pod: %v", err)
+	}
+
+	if host := backend.HostLink("n0123456101"); host == nil || host.Bridge != "intbr" || !reflect.DeepEqual(host.Vlans, []int{101}) {
+		t.Errorf("expected the veth of tenant-a in vlan 101 of the internal bridge, got %+v", host)
+	}
+	ns := backend.Namespace(fakeContainerPid)
+	if link := ns.Links["int-tenant-a"]; link == nil || !link.Up || !reflect.DeepEqual(link.Addresses, []string{"10.1.0.1/24"}) {
+		t.Errorf("expected int-tenant-a up with 10.1.0.1/24, got %+v", link)
+	}
+	route := fake.Route{Family: remoteNetlink.FAMILY_V4, Table: DEFAULT_TABLE_NUMBER, Dst: "10.1.0.0/24", Link: "int-tenant-a"}
+	if routes := ns.Table(DEFAULT_TABLE_NUMBER); !containsRoute(routes, route) {
+		t.Errorf("expected the route %+v in the router table, got %+v", route, routes)
+	}
+
+	// replacing the network moves the router to the new one only
+	spec := virtualrouter.Spec
+	spec.InternalNetworks = []v1.InternalNetwork{
+		{Name: "tenant-b", VlanNumber: 102, CIDR: "10.2.0.1/24"},
+	}
+	actions := len(backend.Actions())
+	if err := n.Sync("router", spec); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+	if expected := []string{"SetInternalNetworks2Container"}; !reflect.DeepEqual(backend.Actions()[actions:], expected) {
+		t.Errorf("expected actions %v, got %v", expected, backend.Actions()[actions:])
+	}
+	if backend.HostLink("n0123456101") != nil || backend.HostLink("n0123456102") == nil {
+		t.Errorf("expected the veth of tenant-a replaced by that of tenant-b")
+	}
+	ns = backend.Namespace(fakeContainerPid)
+	if ns.Links["int-tenant-a"] != nil || ns.Links["int-tenant-b"] == nil {
+		t.Errorf("expected int-tenant-a replaced by int-tenant-b, got %v", ns.Links)
+	}
+	if routes := ns.Table(DEFAULT_TABLE_NUMBER); containsRoute(routes, route) {
+		t.Errorf("expected the route through int-tenant-a removed, got %+v", routes)
+	}
+
+	if err := n.DettachingPod("router-0"); err != nil {
+		t.Fatalf("unexpected error detaching the pod: %v", err)
+	}
+	if backend.HostLink("n0123456102") != nil {
+		t.Errorf("expected the veth of tenant-b to be removed")
+	}
+	if links := backend.Namespace(fakeContainerPid).Links; len(links) != 0 {
+		t.Errorf("expected no links left in the container, got %v", links)
+	}
+}
+
+func TestAttachingPodVRFs(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			VlanNumber:      100,
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+			// tenants of overlapping networks, each in a VRF
+			InternalNetworks: []v1.InternalNetwork{
+				{Name: "tenant-a", VlanNumber: 101, CIDR: "10.0.0.1/24", VRFTable: 10},
+				{Name: "tenant-b", VlanNumber: 102, CIDR: "10.0.0.1/24", VRFTable: 11},
+			},
+		},
+	}
+	err := (&NetworkDaemon{features: internalNetlink.FeatureMatrix{
+		internalNetlink.FeaturePolicyRouting:       true,
+		internalNetlink.FeatureBridgeVlanFiltering: true,
+		internalNetlink.FeatureNftables:            true,
+	}}).CheckFeatures(virtualrouter.Spec)
+	if unsupported, ok := err.(*UnsupportedFeatureError); !ok || unsupported.Feature != string(internalNetlink.FeatureVRF) {
+		t.Errorf("expected VRFs to be required, got %v", err)
+	}
+
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil {
+		t.Fatalf("unexpected error attaching the pod: %v", err)
+	}
+	ns := backend.Namespace(fakeContainerPid)
+	if expected := map[string]int{"vrf10": 10, "vrf11": 11}; !reflect.DeepEqual(ns.VRFs, expected) {
+		t.Errorf("expected the VRFs %v, got %v", expected, ns.VRFs)
+	}
+	for name, vrf := range map[string]string{"int-tenant-a": "vrf10", "int-tenant-b": "vrf11"} {
+		if link := ns.Links[name]; link == nil || link.Master != vrf {
+			t.Errorf("expected %s enslaved to %s, got %+v", name, vrf, link)
+		}
+	}
+	// each VRF is routed out via the gateway, and its replies back in
+	for table, link := range map[int]string{10: "int-tenant-a", 11: "int-tenant-b"} {
+		expected := []fake.Route{
+			{Family: remoteNetlink.FAMILY_V4, Table: table, Gw: "192.168.9.1", Link: DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME},
+			{Family: remoteNetlink.FAMILY_V4, Table: table, Dst: "10.0.0.0/24", Link: link},
+		}
+		if routes := ns.Table(table); !reflect.DeepEqual(routes, expected) {
+			t.Errorf("expected the table %d %+v, got %+v", table, expected, routes)
+		}
+		rule := fake.Rule{Family: remoteNetlink.FAMILY_V4, Mark: VRF_EGRESS_MARK_OFFSET + table, Table: table}
+		if !containsRule(ns.Rules, rule) {
+			t.Errorf("expected the rule %+v, got %+v", rule, ns.Rules)
+		}
+	}
+	for _, route := range ns.Table(DEFAULT_TABLE_NUMBER) {
+		if route.Link == "int-tenant-a" || route.Link == "int-tenant-b" {
+			t.Errorf("expected no route of a VRF in the router table, got %+v", route)
+		}
+	}
+
+	// a VRF left without networks is removed
+	spec := virtualrouter.Spec
+	spec.InternalNetworks = spec.InternalNetworks[:1]
+	if err := n.Sync("router", spec); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+	ns = backend.Namespace(fakeContainerPid)
+	if expected := map[string]int{"vrf10": 10}; !reflect.DeepEqual(ns.VRFs, expected) {
+		t.Errorf("expected the VRFs %v, got %v", expected, ns.VRFs)
+	}
+	if routes := ns.Table(11); len(routes) != 0 {
+		t.Errorf("expected the table 11 to be empty, got %+v", routes)
+	}
+	if rule := (fake.Rule{Family: remoteNetlink.FAMILY_V4, Mark: VRF_EGRESS_MARK_OFFSET + 11, Table: 11}); containsRule(ns.Rules, rule) {
+		t.Errorf("expected the rule %+v to be removed, got %+v", rule, ns.Rules)
+	}
+
+	// the VRFs follow the gateway
+	spec.GatewayIP = "192.168.9.254"
+	if err := n.Sync("router", spec); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+	route := fake.Route{Family: remoteNetlink.FAMILY_V4, Table: 10, Gw: "192.168.9.254", Link: DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME}
+	if routes := backend.Namespace(fakeContainerPid).Table(10); len(routes) != 2 || !containsRoute(routes, route) {
+		t.Errorf("expected the default route %+v in the table 10, got %+v", route, routes)
+	}
+}
+
+func containsRule(rules []fake.Rule, rule fake.Rule) bool {
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRoute(routes []fake.Route, route fake.Route) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAttachingPodFailure(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+		},
+	}
+	backend.Fail("SetInterface2Container", errors.New("no space left on device"))
+	if err := n.AttachingPod("router-0", virtualrouter); err == nil {
+		t.Fatalf("expected attaching to fail")
+	}
+	if _, exist := n.pod2containerMap["router-0"]; exist {
+		t.Errorf("expected a pod failing to attach to be forgotten, to be attached again")
+	}
+
+	backend.Fail("SetInterface2Container", nil)
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil {
+		t.Fatalf("unexpected error attaching the pod again: %v", err)
+	}
+	if link := backend.Namespace(fakeContainerPid).Links["ethext"]; link == nil || !reflect.DeepEqual(link.Addresses, []string{"192.168.9.10/24"}) {
+		t.Errorf("expected ethext with 192.168.9.10/24, got %+v", link)
+	}
+
+	// a failed first apply is applied all over again, the links there
+	// already adopted
+	if err := n.DettachingPod("router-0"); err != nil {
+		t.Fatalf("unexpected error detaching the pod: %v", err)
+	}
+	backend.Fail("SetDefaultRoute2Container", errors.New("network is unreachable"))
+	if err := n.AttachingPod("router-0", virtualrouter); err == nil {
+		t.Fatalf("expected attaching to fail")
+	}
+	if _, exist := n.runnigState["router"]; exist {
+		t.Errorf("expected the spec of a failed first apply to be forgotten")
+	}
+	backend.Fail("SetDefaultRoute2Container", nil)
+	if err := n.AttachingPod("router-0", virtualrouter); err != nil {
+		t.Fatalf("unexpected error attaching the pod again: %v", err)
+	}
+	if routes := backend.Namespace(fakeContainerPid).Table(DEFAULT_TABLE_NUMBER); len(routes) == 0 || routes[0].Gw != "192.168.9.1" {
+		t.Errorf("expected the default route via 192.168.9.1, got %+v", routes)
+	}
+}
+
+func TestCheckFeaturesPacketFilterBackend(t *testing.T) {
+	spec := v1.VirtualRouterSpec{
+		InternalIP:      "10.0.0.1",
+		InternalNetmask: "255.255.255.0",
+		ExternalIP:      "192.168.9.10",
+		ExternalNetmask: "255.255.255.0",
+		GatewayIP:       "192.168.9.1",
+	}
+	dualStack := spec
+	dualStack.InternalIPv6CIDR = "2001:db8:1::1/64"
+	dualStack.ExternalIPv6CIDR = "2001:db8::10/64"
+
+	tests := []struct {
+		name     string
+		features []internalNetlink.Feature
+		forced   internalNetlink.Feature
+		spec     v1.VirtualRouterSpec
+		backend  internalNetlink.Feature
+		// unsupported is the feature rejected, none if empty
+		unsupported string
+	}{
+		{
+			name:     "nftables preferred",
+			features: []internalNetlink.Feature{internalNetlink.FeatureNftables, internalNetlink.FeatureIptables},
+			spec:     spec,
+			backend:  internalNetlink.FeatureNftables,
+		},
+		{
+			name:     "iptables fallback",
+			features: []internalNetlink.Feature{internalNetlink.FeatureIptables},
+			spec:     spec,
+			backend:  internalNetlink.FeatureIptables,
+		},
+		{
+			name:        "no packet filter",
+			spec:        spec,
+			unsupported: "nftables or iptables",
+		},
+		{
+			name:     "iptables forced",
+			features: []internalNetlink.Feature{internalNetlink.FeatureNftables, internalNetlink.FeatureIptables},
+			forced:   internalNetlink.FeatureIptables,
+			spec:     spec,
+			backend:  internalNetlink.FeatureIptables,
+		},
+		{
+			name:        "forced backend missing",
+			features:    []internalNetlink.Feature{internalNetlink.FeatureIptables},
+			forced:      internalNetlink.FeatureNftables,
+			spec:        spec,
+			backend:     internalNetlink.FeatureNftables,
+			unsupported: string(internalNetlink.FeatureNftables),
+		},
+		{
+			name:     "dual-stack nftables",
+			features: []internalNetlink.Feature{internalNetlink.FeatureNftables, internalNetlink.FeatureIPv6},
+			spec:     dualStack,
+			backend:  internalNetlink.FeatureNftables,
+		},
+		{
+			name:        "dual-stack iptables without ip6tables",
+			features:    []internalNetlink.Feature{internalNetlink.FeatureIptables, internalNetlink.FeatureIPv6},
+			spec:        dualStack,
+			backend:     internalNetlink.FeatureIptables,
+			unsupported: string(internalNetlink.FeatureIp6tables),
+		},
+		{
+			name:     "dual-stack iptables",
+			features: []internalNetlink.Feature{internalNetlink.FeatureIptables, internalNetlink.FeatureIp6tables, internalNetlink.FeatureIPv6},
+			spec:     dualStack,
+			backend:  internalNetlink.FeatureIptables,
+		},
+		{
+			name:        "dual-stack without ipv6",
+			features:    []internalNetlink.Feature{internalNetlink.FeatureNftables},
+			spec:        dualStack,
+			backend:     internalNetlink.FeatureNftables,
+			unsupported: string(internalNetlink.FeatureIPv6),
+		},
+	}
+	for _, test := range tests {
+		features := internalNetlink.FeatureMatrix{internalNetlink.FeaturePolicyRouting: true}
+		for _, feature := range test.features {
+			features[feature] = true
+		}
+		n := &NetworkDaemon{features: features, packetFilterBackend: test.forced}
+
+		if backend, _ := n.PacketFilterBackend(); backend != test.backend {
+			t.Errorf("%s: expected the %q backend, got %q", test.name, test.backend, backend)
+		}
+		err := n.CheckFeatures(test.spec)
+		if test.unsupported == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if unsupported, ok := err.(*UnsupportedFeatureError); !ok || unsupported.Feature != test.unsupported {
+			t.Errorf("%s: expected %s to be required, got %v", test.name, test.unsupported, err)
+		}
+	}
+}
+
+func TestAttachingPodUnsupportedFeature(t *testing.T) {
+	n, backend := newFakeDaemon(t)
+	// the daemon is told to use nftables, the node has iptables only
+	n.features = internalNetlink.FeatureMatrix{
+		internalNetlink.FeaturePolicyRouting: true,
+		internalNetlink.FeatureIptables:      true,
+	}
+	virtualrouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			ExternalNetmask: "255.255.255.0",
+			GatewayIP:       "192.168.9.1",
+		},
+	}
+	err := n.AttachingPod("router-0", virtualrouter)
+	if unsupported, ok := err.(*UnsupportedFeatureError); !ok || unsupported.Feature != string(internalNetlink.FeatureNftables) {
+		t.Fatalf("expected nftables to be required, got %v", err)
+	}
+	if actions := backend.Actions(); len(actions) != 0 {
+		t.Errorf("expected nothing attached, got %v", actions)
+	}
+	if _, exist := n.pod2containerMap["router-0"]; exist {
+		t.Errorf("expected a rejected pod to be forgotten")
+	}
+	if _, exist := n.runnigState["router"]; exist {
+		t.Errorf("expected nothing applied for a rejected router")
+	}
+}
@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// INTER_NETWORK_CHAIN is the filter chain dropping the new connections
+	// between internal networks no inter-network policy allows, ahead of the
+	// FireWallRules accepting those that are
+	INTER_NETWORK_CHAIN string = "vr_internetwork"
+	// INTER_NETWORK_SNAT_CHAIN is the nat chain translating the connections
+	// of NAT policies to the address of the router on the network they go to
+	INTER_NETWORK_SNAT_CHAIN string = "vr_internetwork_snat"
+)
+
+// interNetwork is an internal network of a router as the inter-network rules
+// match it.
+type interNetwork struct {
+	name     string
+	network  string
+	routerIP string
+	link     string
+	vrfTable int
+}
+
+// interNetworkConfig is what the rules between the internal networks of a
+// router are programmed with.
+type interNetworkConfig struct {
+	// dropped are the rules of the filter chain, and translated those of the
+	// nat chain
+	dropped, translated []packetfilter.Rule
+}
+
+// interNetworks returns the internal networks of the spec, that of
+// spec.internalIP first if it has one.
+func interNetworks(virtualrouterSpec v1.VirtualRouterSpec) []interNetwork {
+	var networks []interNetwork
+	if ip := net.ParseIP(virtualrouterSpec.InternalIP).To4(); ip != nil {
+		mask := net.IPMask(net.ParseIP(virtualrouterSpec.InternalNetmask).To4())
+		networks = append(networks, interNetwork{
+			name:     v1.DEFAULT_INTERNAL_NETWORK_NAME,
+			network:  (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String(),
+			routerIP: ip.String(),
+			link:     DEFAULT_VIRTURALROUTER_INTERNAL_INTERFACE_NAME,
+		})
+	}
+	for _, network := range virtualrouterSpec.InternalNetworks {
+		ip, ipNet, err := net.ParseCIDR(network.CIDR)
+		if err != nil {
+			continue
+		}
+		networks = append(networks, interNetwork{
+			name:     network.Name,
+			network:  ipNet.String(),
+			routerIP: ip.String(),
+			link:     INTERNAL_NETWORK_INTERFACE_PREFIX + network.Name,
+			vrfTable: int(network.VRFTable),
+		})
+	}
+	return networks
+}
+
+// interNetworkConfigFor returns how the rules between the internal networks
+// of the VirtualRouter are to be programmed, or nil if it has no network but
+// that of spec.internalIP. The FireWallRule of the controller accepts the
+// traffic of every policy allowing it both ways, so the new connections of
+// every other pair of networks of a VRF are dropped here, by the interfaces
+// they come in on and go out of as networks of different VRFs may overlap.
+func interNetworkConfigFor(virtualrouter *v1.VirtualRouter) *interNetworkConfig {
+	if len(virtualrouter.Spec.InternalNetworks) == 0 {
+		return nil
+	}
+	actions := map[[2]string]v1.InterNetworkAction{}
+	for _, policy := range virtualrouter.Spec.InterNetworkPolicies {
+		actions[[2]string{policy.From, policy.To}] = policy.Action
+	}
+	config := &interNetworkConfig{}
+	networks := interNetworks(virtualrouter.Spec)
+	for _, from := range networks {
+		for _, to := range networks {
+			if from.name == to.name || from.vrfTable != to.vrfTable {
+				continue
+			}
+			switch actions[[2]string{from.name, to.name}] {
+			case v1.InterNetworkNAT:
+				config.translated = append(config.translated, packetfilter.Rule{
+					Match: packetfilter.Match{Source: from.network, Destination: to.network, OutInterface: to.link},
+					SNAT:  to.routerIP,
+				})
+			case v1.InterNetworkAllow:
+			default:
+				config.dropped = append(config.dropped, packetfilter.Rule{
+					Match: packetfilter.Match{Source: from.network, Destination: to.network, InInterface: from.link, OutInterface: to.link, CtState: "new"},
+					Drop:  true,
+				})
+			}
+		}
+	}
+	return config
+}
+
+// interNetworkRulesets returns the filter and nat chains of the rules between
+// the internal networks, empty if there is none.
+func interNetworkRulesets(config *interNetworkConfig) []*packetfilter.Ruleset {
+	filter := &packetfilter.Ruleset{
+		Name:   INTER_NETWORK_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeFilter,
+		Hook:   packetfilter.HookForward,
+		Chains: []packetfilter.Chain{{Name: INTER_NETWORK_CHAIN}},
+	}
+	snat := &packetfilter.Ruleset{
+		Name:   INTER_NETWORK_SNAT_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeNAT,
+		Hook:   packetfilter.HookPostrouting,
+		Chains: []packetfilter.Chain{{Name: INTER_NETWORK_SNAT_CHAIN}},
+	}
+	if config != nil {
+		filter.Chains[0].Rules = config.dropped
+		snat.Chains[0].Rules = config.translated
+	}
+	return []*packetfilter.Ruleset{filter, snat}
+}
+
+// EnsureInterNetwork programs the rules between the internal networks of the
+// router container of the VirtualRouter on this node as its inter-network
+// policies say, and clears them once it has no network but that of
+// spec.internalIP. Like the SNAT pool, they are applied again on every call.
+func (n *NetworkDaemon) EnsureInterNetwork(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	config := interNetworkConfigFor(virtualrouter)
+	applied, exist := n.interNetworks[containerName]
+	if config == nil && !exist {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		for _, ruleset := range interNetworkRulesets(nil) {
+			if err := backend.Delete(containerPid, ruleset); err != nil {
+				klog.ErrorS(err, "Deleting inter-network rules failed", "containerName", containerName, "ruleset", ruleset.Name)
+				return err
+			}
+		}
+		delete(n.interNetworks, containerName)
+		klog.InfoS("Inter-network rules cleared", "containerName", containerName)
+		return nil
+	}
+	var previous []*packetfilter.Ruleset
+	if exist {
+		previous = interNetworkRulesets(applied)
+	}
+	if err := packetfilter.ApplyAll(backend, containerPid, interNetworkRulesets(config), previous); err != nil {
+		klog.ErrorS(err, "Setting inter-network rules failed", "containerName", containerName)
+		return err
+	}
+	if !exist {
+		klog.InfoS("Inter-network rules set", "containerName", containerName)
+	}
+	n.interNetworks[containerName] = config
+	return nil
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestInterNetworkRulesets(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			InternalNetworks: []v1.InternalNetwork{
+				{Name: "tenant-a", VlanNumber: 101, CIDR: "10.0.1.1/24"},
+				{Name: "tenant-b", VlanNumber: 102, CIDR: "10.0.2.1/24"},
+				// overlapping the network of spec.internalIP in a VRF
+				{Name: "vrf-a", VlanNumber: 103, CIDR: "10.0.0.1/24", VRFTable: 10},
+			},
+			InterNetworkPolicies: []v1.InterNetworkPolicy{
+				{From: "default", To: "tenant-a", Action: v1.InterNetworkAllow},
+				{From: "tenant-b", To: "default", Action: v1.InterNetworkNAT},
+				{From: "tenant-a", To: "tenant-b", Action: v1.InterNetworkDeny},
+			},
+		},
+	}
+	// the rules are compared as iptables renders them, the backends are
+	// tested against each other in the packetfilter package
+	backend, err := packetfilter.New(packetfilter.IPTABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, ruleset := range interNetworkRulesets(interNetworkConfigFor(virtualRouter)) {
+		rules = append(rules, backend.Compile(ruleset))
+	}
+	expected := []string{`*filter
+:vr_internetwork - [0:0]
+-A vr_internetwork -s 10.0.0.0/24 -d 10.0.2.0/24 -i ethint -o int-tenant-b -m conntrack --ctstate NEW -j DROP
+-A vr_internetwork -s 10.0.1.0/24 -d 10.0.0.0/24 -i int-tenant-a -o ethint -m conntrack --ctstate NEW -j DROP
+-A vr_internetwork -s 10.0.1.0/24 -d 10.0.2.0/24 -i int-tenant-a -o int-tenant-b -m conntrack --ctstate NEW -j DROP
+-A vr_internetwork -s 10.0.2.0/24 -d 10.0.1.0/24 -i int-tenant-b -o int-tenant-a -m conntrack --ctstate NEW -j DROP
+COMMIT
+`, `*nat
+:vr_internetwork_snat - [0:0]
+-A vr_internetwork_snat -s 10.0.2.0/24 -d 10.0.0.0/24 -o ethint -j SNAT --to-source 10.0.0.1
+COMMIT
+`}
+	if joined := strings.Join(rules, ""); joined != strings.Join(expected, "") {
+		t.Errorf("expected rules\n%s\ngot\n%s", strings.Join(expected, ""), joined)
+	}
+
+	// routers with no network but that of spec.internalIP have none of it
+	virtualRouter.Spec.InternalNetworks = nil
+	virtualRouter.Spec.InterNetworkPolicies = nil
+	if config := interNetworkConfigFor(virtualRouter); config != nil {
+		t.Errorf("expected no inter-network rules, got %+v", config)
+	}
+	if rules := backend.Compile(interNetworkRulesets(nil)[0]); rules != "*filter\n:vr_internetwork - [0:0]\nCOMMIT\n" {
+		t.Errorf("expected an empty chain, got\n%s", rules)
+	}
+}
@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// setHardwareAddr sets the MAC of an interface of the container
+var setHardwareAddr = internalNetlink.SetHardwareAddr2Container
+
+// podMACAddresses returns the MACs to set on the internal and external
+// interfaces of the router pod, nil for those left as they are. The MAC
+// spec.externalSRIOV gives the virtual function is set by ConnectVF.
+func podMACAddresses(virtualrouter *v1.VirtualRouter, pod *corev1.Pod) (net.HardwareAddr, net.HardwareAddr, error) {
+	addresses, ok := virtualroutermanager.PodMACAddresses(virtualrouter, pod)
+	if !ok {
+		return nil, nil, nil
+	}
+	internal, err := net.ParseMAC(addresses.Internal)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid internal MAC %q: %v", addresses.Internal, err)
+	}
+	if sriov := virtualrouter.Spec.ExternalSRIOV; sriov != nil && sriov.MAC != "" {
+		return internal, nil, nil
+	}
+	external, err := net.ParseMAC(addresses.External)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid external MAC %q: %v", addresses.External, err)
+	}
+	return internal, external, nil
+}
+
+// EnsureMACAddresses sets the MACs status.macAddresses gives the router pod
+// on the interfaces of its container. A changed external MAC is announced
+// again. Dropping spec.macAddresses leaves the MACs until the pod restarts.
+func (n *NetworkDaemon) EnsureMACAddresses(virtualrouter *v1.VirtualRouter, pod *corev1.Pod) error {
+	desc, exist := n.pod2containerMap[pod.Name]
+	if !exist {
+		return nil
+	}
+	containerName := desc.containerName
+	internal, external, err := podMACAddresses(virtualrouter, pod)
+	if err != nil || internal == nil {
+		return err
+	}
+	applied := [2]string{internal.String(), external.String()}
+	if n.macAddresses[containerName] == applied {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := setHardwareAddr(containerPid, internal, true); err != nil {
+		klog.ErrorS(err, "Set MAC to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	if external != nil {
+		if err := setHardwareAddr(containerPid, external, false); err != nil {
+			klog.ErrorS(err, "Set MAC to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+			return err
+		}
+		delete(n.announced, containerName)
+	}
+	n.macAddresses[containerName] = applied
+	return nil
+}
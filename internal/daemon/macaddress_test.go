@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestPodMACAddresses(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "router-7d9f8-abcde"}}
+	virtualrouter := &v1.VirtualRouter{
+		Status: v1.VirtualRouterStatus{MACAddresses: []v1.RouterMACAddress{
+			{Ordinal: 0, Internal: "02:1a:2b:3c:4d:5e", External: "02:1a:2b:3c:4d:5f"},
+		}},
+	}
+	if internal, external, err := podMACAddresses(virtualrouter, pod); err != nil || internal != nil || external != nil {
+		t.Errorf("expected no MACs without spec.macAddresses, got %v, %v, %v", internal, external, err)
+	}
+
+	virtualrouter.Spec.MACAddresses = &v1.RouterMACAddresses{}
+	internal, external, err := podMACAddresses(virtualrouter, pod)
+	if err != nil || internal.String() != "02:1a:2b:3c:4d:5e" || external.String() != "02:1a:2b:3c:4d:5f" {
+		t.Errorf("expected the MACs of the router pod, got %v, %v, %v", internal, external, err)
+	}
+
+	// the MAC given the virtual function takes precedence
+	virtualrouter.Spec.ExternalSRIOV = &v1.SRIOV{ResourceName: "intel.com/sriov_netdevice", MAC: "52:54:00:12:34:56"}
+	if internal, external, err := podMACAddresses(virtualrouter, pod); err != nil || internal == nil || external != nil {
+		t.Errorf("expected the external MAC left to the virtual function, got %v, %v, %v", internal, external, err)
+	}
+
+	virtualrouter.Status.MACAddresses[0].Internal = "invalid"
+	if _, _, err := podMACAddresses(virtualrouter, pod); err == nil {
+		t.Error("expected an invalid MAC refused")
+	}
+}
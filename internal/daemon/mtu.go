@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+var (
+	// externalUplinkMTU is the largest MTU traffic can leave the node with
+	externalUplinkMTU = internalNetlink.ExternalUplinkMTU
+	// probePathMTU is the largest packet reaching the gateway unfragmented
+	probePathMTU = internalNetlink.ProbePathMTU
+	// setMTU sets the MTU of an interface of the container and its host end
+	setMTU = internalNetlink.SetMTU2Container
+)
+
+// SetMTU sets the MTU of the interfaces of the container to those of the
+// spec, probing the path MTU towards the gateway for those it leaves to the
+// daemon. The external interface is opened up to the uplink of the node
+// for the probe.
+func (n *NetworkDaemon) SetMTU(containerName string, virtualrouterSpec v1.VirtualRouterSpec) error {
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	internal, external, err := interfaceMTUs(virtualrouterSpec.MTU, func(external int) (int, error) {
+		max := external
+		if max == 0 {
+			uplink, err := externalUplinkMTU(n.netlinkCfg)
+			if err != nil {
+				return 0, err
+			}
+			if err := setMTU(containerPid, containerID[:7], uplink, false); err != nil {
+				return 0, err
+			}
+			max = uplink
+		}
+		pathMTU, err := probePathMTU(containerPid, virtualrouterSpec.ExternalIP, virtualrouterSpec.GatewayIP, max)
+		if err != nil {
+			return 0, err
+		}
+		klog.InfoS("Path MTU probed", "containerName", containerName, "gatewayIP", virtualrouterSpec.GatewayIP, "mtu", pathMTU)
+		return pathMTU, nil
+	})
+	if err != nil {
+		klog.ErrorS(err, "Probing path MTU failed", "ContainerName", containerName)
+		return err
+	}
+	if err := setMTU(containerPid, containerID[:7], internal, true); err != nil {
+		klog.ErrorS(err, "Set MTU to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	if err := setMTU(containerPid, containerID[:7], external, false); err != nil {
+		klog.ErrorS(err, "Set MTU to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// interfaceMTUs returns the MTUs of the internal and external interfaces,
+// those of the spec or the path MTU the probe finds through the external
+// interface with the external MTU given, 0 for the uplink one.
+func interfaceMTUs(mtu *v1.MTU, probe func(external int) (int, error)) (int, int, error) {
+	if mtu == nil {
+		return internalNetlink.DefaultMTU, internalNetlink.DefaultMTU, nil
+	}
+	internal, external := int(mtu.Internal), int(mtu.External)
+	if mtu.Auto && (internal == 0 || external == 0) {
+		pathMTU, err := probe(external)
+		if err != nil {
+			return 0, 0, err
+		}
+		if internal == 0 {
+			internal = pathMTU
+		}
+		if external == 0 {
+			external = pathMTU
+		}
+	}
+	if internal == 0 {
+		internal = internalNetlink.DefaultMTU
+	}
+	if external == 0 {
+		external = internalNetlink.DefaultMTU
+	}
+	return internal, external, nil
+}
+
+// mtuPlan describes the MTUs of the spec, auto for those probed
+func mtuPlan(mtu *v1.MTU) string {
+	describe := func(value int32) string {
+		switch {
+		case value != 0:
+			return fmt.Sprint(value)
+		case mtu.Auto:
+			return "auto"
+		}
+		return fmt.Sprint(internalNetlink.DefaultMTU)
+	}
+	if mtu == nil {
+		return fmt.Sprintf("internal %d, external %d", internalNetlink.DefaultMTU, internalNetlink.DefaultMTU)
+	}
+	return fmt.Sprintf("internal %s, external %s", describe(mtu.Internal), describe(mtu.External))
+}
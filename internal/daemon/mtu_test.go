@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestInterfaceMTUs(t *testing.T) {
+	tests := []struct {
+		name               string
+		mtu                *v1.MTU
+		internal, external int
+		probed             bool
+		probeMax           int
+	}{
+		{name: "default", internal: 1500, external: 1500},
+		{name: "given", mtu: &v1.MTU{Internal: 9000, External: 1450}, internal: 9000, external: 1450},
+		{name: "given auto", mtu: &v1.MTU{Internal: 9000, External: 1450, Auto: true}, internal: 9000, external: 1450},
+		{name: "one given", mtu: &v1.MTU{External: 1450}, internal: 1500, external: 1450},
+		{name: "auto", mtu: &v1.MTU{Auto: true}, internal: 1450, external: 1450, probed: true},
+		{name: "auto internal", mtu: &v1.MTU{External: 1480, Auto: true}, internal: 1450, external: 1480, probed: true, probeMax: 1480},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probed := false
+			internal, external, err := interfaceMTUs(test.mtu, func(max int) (int, error) {
+				probed = true
+				if max != test.probeMax {
+					t.Errorf("expected probing up to %d, got %d", test.probeMax, max)
+				}
+				return 1450, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if internal != test.internal || external != test.external {
+				t.Errorf("expected MTU %d/%d, got %d/%d", test.internal, test.external, internal, external)
+			}
+			if probed != test.probed {
+				t.Errorf("expected probed %v, got %v", test.probed, probed)
+			}
+		})
+	}
+
+	if _, _, err := interfaceMTUs(&v1.MTU{Auto: true}, func(int) (int, error) { return 0, fmt.Errorf("unreachable") }); err == nil {
+		t.Errorf("expected the probe failure")
+	}
+}
+
+func TestMTUChanges(t *testing.T) {
+	applied := v1.VirtualRouterSpec{ExternalIP: "192.168.9.10", GatewayIP: "192.168.9.1", MTU: &v1.MTU{Auto: true}}
+	tests := []struct {
+		name    string
+		spec    v1.VirtualRouterSpec
+		changed bool
+	}{
+		{"same", applied, false},
+		{"gateway", v1.VirtualRouterSpec{ExternalIP: "192.168.9.10", GatewayIP: "192.168.9.254", MTU: &v1.MTU{Auto: true}}, true},
+		{"given", v1.VirtualRouterSpec{ExternalIP: "192.168.9.10", GatewayIP: "192.168.9.1", MTU: &v1.MTU{External: 1450}}, true},
+		{"removed", v1.VirtualRouterSpec{ExternalIP: "192.168.9.10", GatewayIP: "192.168.9.1"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := diffSpec(&applied, test.spec); changes.mtu != test.changed {
+				t.Errorf("expected MTU changed %v, got %v", test.changed, changes.mtu)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// announceAddress has the upstream devices learn the MAC of the router
+// container for the external address
+var announceAddress = func(pid int, address string) error {
+	return internalNetlink.AnnounceAddress2Container(pid, address, false)
+}
+
+// SetStaticNeighbors replaces the static neighbors applied to the container
+// with the given ones.
+func (n *NetworkDaemon) SetStaticNeighbors(containerName string, applied []internalNetlink.StaticNeighbor, neighbors []internalNetlink.StaticNeighbor) error {
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	if err := internalNetlink.SetStaticNeighbors2Container(containerPid, applied, neighbors); err != nil {
+		klog.ErrorS(err, "Set static neighbors to Container failed", "ContainerName", containerName, "ContainerID", containerID)
+		return err
+	}
+	return nil
+}
+
+// staticNeighbors returns the static neighbors of the spec with their
+// addresses in canonical form, so writing a MAC in capitals changes nothing
+func staticNeighbors(virtualrouterSpec v1.VirtualRouterSpec) []internalNetlink.StaticNeighbor {
+	var neighbors []internalNetlink.StaticNeighbor
+	for _, neighbor := range virtualrouterSpec.StaticNeighbors {
+		ip, mac := neighbor.IP, neighbor.MAC
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		if parsed, err := net.ParseMAC(mac); err == nil {
+			mac = parsed.String()
+		}
+		neighbors = append(neighbors, internalNetlink.StaticNeighbor{
+			IP:       ip,
+			MAC:      mac,
+			Internal: virtualroutermanager.NeighborInterface(neighbor) == v1.NeighborInternal,
+		})
+	}
+	return neighbors
+}
+
+// announcedAddresses returns the external addresses of the spec upstream
+// devices are to learn the MAC of the active router container for.
+func announcedAddresses(virtualrouterSpec v1.VirtualRouterSpec) []string {
+	var addresses []string
+	if virtualrouterSpec.ExternalIP != "" {
+		addresses = append(addresses, virtualrouterSpec.ExternalIP)
+	}
+	if ip, _, err := net.ParseCIDR(virtualrouterSpec.ExternalIPv6CIDR); err == nil {
+		addresses = append(addresses, ip.String())
+	}
+	return addresses
+}
+
+// EnsureAnnounced sends gratuitous ARPs and unsolicited neighbor
+// advertisements for the external addresses of the router container once its
+// pod on the node becomes the active one, or its addresses change while it
+// is, so upstream switches don't keep forwarding to the pod it took over
+// from until their entries age out.
+func (n *NetworkDaemon) EnsureAnnounced(virtualrouter *v1.VirtualRouter, nodeName string) error {
+	containerName := virtualrouter.Name
+	applied, exist := n.runnigState[containerName]
+	if !exist {
+		return nil
+	}
+	if nodeName == "" || virtualrouter.Status.ActiveNode != nodeName {
+		// announced again when the pod is active again
+		delete(n.announced, containerName)
+		return nil
+	}
+	addresses := announcedAddresses(*applied)
+	if announced, exist := n.announced[containerName]; exist && reflect.DeepEqual(announced, addresses) {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	for _, address := range addresses {
+		if err := announceAddress(containerPid, address); err != nil {
+			klog.ErrorS(err, "Announcing external address failed", "containerName", containerName, "address", address)
+			return err
+		}
+	}
+	n.announced[containerName] = addresses
+	klog.InfoS("External addresses announced", "containerName", containerName, "addresses", addresses)
+	return nil
+}
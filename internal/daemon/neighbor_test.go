@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestStaticNeighborsChanges(t *testing.T) {
+	applied := v1.VirtualRouterSpec{StaticNeighbors: []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:ab:cd:ef"}}}
+	tests := []struct {
+		name      string
+		neighbors []v1.StaticNeighbor
+		changed   bool
+	}{
+		{"same", []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:ab:cd:ef"}}, false},
+		{"mac in capitals", []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:AB:CD:EF"}}, false},
+		{"external by default", []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:ab:cd:ef", Interface: v1.NeighborExternal}}, false},
+		{"other mac", []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:ab:cd:00"}}, true},
+		{"internal", []v1.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:ab:cd:ef", Interface: v1.NeighborInternal}}, true},
+		{"removed", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := diffSpec(&applied, v1.VirtualRouterSpec{StaticNeighbors: test.neighbors})
+			if changes.staticNeighbors != test.changed {
+				t.Errorf("expected static neighbors changed %v, got %v", test.changed, changes.staticNeighbors)
+			}
+		})
+	}
+}
+
+func TestEnsureAnnounced(t *testing.T) {
+	var announced []string
+	original := announceAddress
+	announceAddress = func(pid int, address string) error {
+		announced = append(announced, address)
+		return nil
+	}
+	defer func() { announceAddress = original }()
+
+	n := NewDaemon(nil, nil, "")
+	spec := v1.VirtualRouterSpec{ExternalIP: "192.168.9.10", ExternalIPv6CIDR: "2001:db8::10/64"}
+	n.runnigState["test"] = &spec
+	n.announced["test"] = announcedAddresses(spec)
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec:       spec,
+		Status:     v1.VirtualRouterStatus{ActiveNode: "node1"},
+	}
+
+	// already announced while active
+	if err := n.EnsureAnnounced(virtualRouter, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(announced) != 0 {
+		t.Errorf("expected nothing announced again, got %v", announced)
+	}
+	// standing by forgets the announcement, to make it again on takeover
+	if err := n.EnsureAnnounced(virtualRouter, "node2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := n.announced["test"]; exist {
+		t.Errorf("expected the announcement of a standby router forgotten")
+	}
+	if expected := []string{"192.168.9.10", "2001:db8::10"}; !reflect.DeepEqual(announcedAddresses(spec), expected) {
+		t.Errorf("expected addresses %v, got %v", expected, announcedAddresses(spec))
+	}
+}
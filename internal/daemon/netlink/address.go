@@ -0,0 +1,141 @@
+package netlink
+
+import (
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// SetSecondaryAddresses2Container replaces the addresses applied before to
+// the interface of the container with the given ones, leaving its primary
+// address alone. Addresses are given in CIDR notation.
+func SetSecondaryAddresses2Container(containerPid int, interfaceName string, applied []string, addresses []string) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	link, err := targetNetlinkHandle.LinkByName(interfaceName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", interfaceName)
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, address := range addresses {
+		wanted[address] = true
+	}
+	for _, address := range applied {
+		if wanted[address] {
+			continue
+		}
+		addr, err := remoteNetlink.ParseAddr(address)
+		if err != nil {
+			continue
+		}
+		// the address may be gone with the primary one already
+		if err := targetNetlinkHandle.AddrDel(link, addr); err != nil {
+			klog.V(4).InfoS("AddrDel failed", "interfaceName", interfaceName, "addr", address, "err", err)
+		}
+	}
+	for _, address := range addresses {
+		addr, err := remoteNetlink.ParseAddr(address)
+		if err != nil {
+			klog.ErrorS(err, "ParseAddr is failed", "addr", address)
+			return err
+		}
+		if err := targetNetlinkHandle.AddrReplace(link, addr); err != nil {
+			klog.ErrorS(err, "AddrReplace is failed", "interfaceName", interfaceName, "addr", address)
+			return err
+		}
+	}
+	return nil
+}
+
+// SetIPv6Address2Container replaces the global IPv6 addresses of the internal
+// or external interface of the container with the given one, given in CIDR
+// notation. Link-local addresses are left alone, and an empty address only
+// removes the others.
+func SetIPv6Address2Container(containerPid int, cidr string, isInternal bool) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	interfaceName := DefaultExternalContainerInterface
+	if isInternal {
+		interfaceName = DefaultInternalContainerInterface
+	}
+	link, err := targetNetlinkHandle.LinkByName(interfaceName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", interfaceName)
+		return err
+	}
+
+	var addr *remoteNetlink.Addr
+	if cidr != "" {
+		if addr, err = remoteNetlink.ParseAddr(cidr); err != nil {
+			klog.ErrorS(err, "ParseAddr is failed", "addr", cidr)
+			return err
+		}
+	}
+	addrs, err := targetNetlinkHandle.AddrList(link, remoteNetlink.FAMILY_V6)
+	if err != nil {
+		klog.ErrorS(err, "Listing Address failed", "interfaceName", interfaceName)
+		return err
+	}
+	for i := range addrs {
+		if addrs[i].IP.IsLinkLocalUnicast() || (addr != nil && addr.Equal(addrs[i])) {
+			continue
+		}
+		if err := targetNetlinkHandle.AddrDel(link, &addrs[i]); err != nil {
+			klog.ErrorS(err, "Deleting address failed", "interfaceName", interfaceName, "address", addrs[i].String())
+			return err
+		}
+	}
+	if addr == nil {
+		return nil
+	}
+	if err := targetNetlinkHandle.AddrReplace(link, addr); err != nil {
+		klog.ErrorS(err, "AddrReplace is failed", "interfaceName", interfaceName, "addr", cidr)
+		return err
+	}
+	klog.InfoS("AddrReplace is done", "interfaceName", interfaceName, "addr", cidr)
+	return setLinkUp(targetNetlinkHandle, link)
+}
+
+// SetHardwareAddr2Container sets the MAC of the internal or external
+// interface of the container. Veths and the drivers of virtual functions take
+// a new MAC while up, so the addresses and routes of the interface are kept.
+func SetHardwareAddr2Container(containerPid int, mac net.HardwareAddr, isInternal bool) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	interfaceName := DefaultExternalContainerInterface
+	if isInternal {
+		interfaceName = DefaultInternalContainerInterface
+	}
+	link, err := targetNetlinkHandle.LinkByName(interfaceName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", interfaceName)
+		return err
+	}
+	if link.Attrs().HardwareAddr.String() == mac.String() {
+		return nil
+	}
+	if err := targetNetlinkHandle.LinkSetHardwareAddr(link, mac); err != nil {
+		klog.ErrorS(err, "LinkSetHardwareAddr failed", "interfaceName", interfaceName, "mac", mac.String())
+		return err
+	}
+	klog.InfoS("MAC set", "interfaceName", interfaceName, "mac", mac.String())
+	return nil
+}
@@ -0,0 +1,96 @@
+package netlink
+
+// Backend is what the daemon attaches a router container to the node with:
+// the veth pairs into the host bridges, the addresses of the router
+// interfaces and the routes of the router table through them. The kernel
+// backend is the one the daemon runs with, the fake one in
+// internal/daemon/netlink/fake lets reconciliation run without root.
+type Backend interface {
+	// SetInterface2Container connects the internal or external interface
+	// of the container to its host bridge
+	SetInterface2Container(containerPid int, interfaceName string, isInternal bool, cfg *Config) error
+	// ClearVethInterface removes the host end of the internal or external
+	// veth pair of the container, and with it the container end
+	ClearVethInterface(interfaceName string, isInternal bool) error
+	// SetVlan moves the internal interface of the container from the old
+	// vlan to the new one, 0 being none
+	SetVlan(interfaceName string, newVlan int, oldVlan int, cfg *Config) error
+	// SetIPaddress2Container replaces the IPv4 address of the internal or
+	// external interface of the container
+	SetIPaddress2Container(containerPid int, ip string, netmask string, isInternal bool) error
+	// SetIPv6Address2Container replaces the IPv6 address of the internal or
+	// external interface of the container, or only removes it if empty
+	SetIPv6Address2Container(containerPid int, cidr string, isInternal bool) error
+	// SetRoute2Container copies the routes through the interface of the
+	// container into the table
+	SetRoute2Container(containerPid int, interfaceName string, tableNum int) error
+	// SetRouteRule2Container looks the marked traffic of the family up in
+	// the table
+	SetRouteRule2Container(containerPid int, family int, markNumber int, tableNumber int) error
+	// SetDefaultRoute2Container replaces the default route of the family in
+	// the table, or only removes it if no gateway is given
+	SetDefaultRoute2Container(containerPid int, family int, gwIP string, tableNum int) error
+	// SetInternalNetworks2Container replaces the internal networks applied
+	// to the container with the given ones, routed through the table or
+	// the VRFs of their own tables
+	SetInternalNetworks2Container(containerPid int, interfaceName string, applied []InternalNetwork, networks []InternalNetwork, tableNum int, cfg *Config) error
+	// SetVRFRoutes2Container replaces the egress of the VRF tables applied
+	// to the container with a default route via the gateway on the external
+	// interface in each of the given ones, and a rule looking the marked
+	// replies up there
+	SetVRFRoutes2Container(containerPid int, applied []int, tables []int, gwIP string, markOffset int) error
+	// ClearInternalNetworks removes the internal networks of the container
+	ClearInternalNetworks(interfaceName string, networks []InternalNetwork, cfg *Config) error
+}
+
+// kernel programs the network namespaces of the node through netlink
+type kernel struct{}
+
+// NewBackend returns the Backend programming the kernel of the node.
+func NewBackend() Backend {
+	return kernel{}
+}
+
+func (kernel) SetInterface2Container(containerPid int, interfaceName string, isInternal bool, cfg *Config) error {
+	return SetInterface2Container(containerPid, interfaceName, isInternal, cfg)
+}
+
+func (kernel) ClearVethInterface(interfaceName string, isInternal bool) error {
+	return ClearVethInterface(interfaceName, isInternal)
+}
+
+func (kernel) SetVlan(interfaceName string, newVlan int, oldVlan int, cfg *Config) error {
+	return SetVlan(interfaceName, newVlan, oldVlan, cfg)
+}
+
+func (kernel) SetIPaddress2Container(containerPid int, ip string, netmask string, isInternal bool) error {
+	return SetIPaddress2Container(containerPid, ip, netmask, isInternal)
+}
+
+func (kernel) SetIPv6Address2Container(containerPid int, cidr string, isInternal bool) error {
+	return SetIPv6Address2Container(containerPid, cidr, isInternal)
+}
+
+func (kernel) SetRoute2Container(containerPid int, interfaceName string, tableNum int) error {
+	return SetRoute2Container(containerPid, interfaceName, tableNum)
+}
+
+func (kernel) SetRouteRule2Container(containerPid int, family int, markNumber int, tableNumber int) error {
+	return SetRouteRule2Container(containerPid, family, markNumber, tableNumber)
+}
+
+func (kernel) SetDefaultRoute2Container(containerPid int, family int, gwIP string, tableNum int) error {
+	return SetDefaultRoute2Container(containerPid, family, gwIP, tableNum)
+}
+
+func (kernel) SetInternalNetworks2Container(containerPid int, interfaceName string, applied []InternalNetwork, networks []InternalNetwork, tableNum int, cfg *Config) error {
+	return SetInternalNetworks2Container(containerPid, interfaceName, applied, networks, tableNum, cfg)
+}
+
+func (kernel) SetVRFRoutes2Container(containerPid int, applied []int, tables []int, gwIP string, markOffset int) error {
+	return SetVRFRoutes2Container(containerPid, applied, tables, gwIP, markOffset)
+}
+
+func (kernel) ClearInternalNetworks(interfaceName string, networks []InternalNetwork, cfg *Config) error {
+	return ClearInternalNetworks(interfaceName, networks, cfg)
+}
@@ -13,6 +13,11 @@ type Config struct {
 	InternalIPCIDR string
 	ExternalIPCIDR string
 
+	// InternalIPv6CIDR and ExternalIPv6CIDR are the IPv6 counterparts of
+	// InternalIPCIDR and ExternalIPCIDR on dual-stack nodes
+	InternalIPv6CIDR string
+	ExternalIPv6CIDR string
+
 	InternalIP      string
 	InternalNetmask string
 
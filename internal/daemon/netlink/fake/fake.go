@@ -0,0 +1,541 @@
+// Package fake has an in-memory netlink.Backend, keeping the links,
+// addresses, rules and routes the daemon would program in the network
+// namespaces of the node, to test reconciliation without root.
+package fake
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+// Link is an interface of a network namespace
+type Link struct {
+	Name string
+	Up   bool
+	// Bridge is the host bridge the link is attached to, host links only
+	Bridge string
+	// Vlans are the vlans of the bridge port, host links only
+	Vlans []int
+	// Addresses are the addresses of the link in CIDR notation, IPv4 first
+	Addresses []string
+	// Master is the VRF device the link is enslaved to, container links only
+	Master string
+}
+
+// Rule looks the marked traffic of the family up in the table
+type Rule struct {
+	Family int
+	Mark   int
+	Table  int
+}
+
+// Route is a route of a table, Dst empty for the default route
+type Route struct {
+	Family int
+	Table  int
+	Dst    string
+	Gw     string
+	Link   string
+}
+
+// Namespace is the network namespace of a container
+type Namespace struct {
+	Links  map[string]*Link
+	Rules  []Rule
+	Routes []Route
+	// VRFs are the tables of the VRF devices by name
+	VRFs map[string]int
+}
+
+// Backend is an in-memory netlink.Backend. The host bridges given are the
+// ones the host has; containers are created in it by attaching them.
+type Backend struct {
+	lock sync.Mutex
+
+	bridges    map[string]bool
+	hostLinks  map[string]*Link
+	peers      map[string]int
+	namespaces map[int]*Namespace
+	errors     map[string]error
+	actions    []string
+}
+
+var _ internalNetlink.Backend = &Backend{}
+
+// NewBackend returns a Backend of a host with the bridges.
+func NewBackend(bridges ...string) *Backend {
+	b := &Backend{
+		bridges:    make(map[string]bool),
+		hostLinks:  make(map[string]*Link),
+		peers:      make(map[string]int),
+		namespaces: make(map[int]*Namespace),
+		errors:     make(map[string]error),
+	}
+	for _, bridge := range bridges {
+		b.bridges[bridge] = true
+	}
+	return b
+}
+
+// Fail makes the method of the Backend return the error until cleared with
+// a nil error.
+func (b *Backend) Fail(method string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		delete(b.errors, method)
+		return
+	}
+	b.errors[method] = err
+}
+
+// Actions returns the methods of the Backend called, in order.
+func (b *Backend) Actions() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.actions...)
+}
+
+// HostLink returns a copy of the host link, nil if there is none.
+func (b *Backend) HostLink(name string) *Link {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if link, ok := b.hostLinks[name]; ok {
+		return copyLink(link)
+	}
+	return nil
+}
+
+// Namespace returns a copy of the network namespace of the container, nil
+// if nothing was attached to it.
+func (b *Backend) Namespace(containerPid int) *Namespace {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ns, ok := b.namespaces[containerPid]
+	if !ok {
+		return nil
+	}
+	copied := &Namespace{
+		Links:  make(map[string]*Link, len(ns.Links)),
+		Rules:  append([]Rule(nil), ns.Rules...),
+		Routes: append([]Route(nil), ns.Routes...),
+		VRFs:   make(map[string]int, len(ns.VRFs)),
+	}
+	for name, link := range ns.Links {
+		copied.Links[name] = copyLink(link)
+	}
+	for name, table := range ns.VRFs {
+		copied.VRFs[name] = table
+	}
+	return copied
+}
+
+func copyLink(link *Link) *Link {
+	copied := *link
+	copied.Vlans = append([]int(nil), link.Vlans...)
+	copied.Addresses = append([]string(nil), link.Addresses...)
+	return &copied
+}
+
+// call records the method called, returning the error it is made to fail
+// with
+func (b *Backend) call(method string) error {
+	b.actions = append(b.actions, method)
+	return b.errors[method]
+}
+
+func (b *Backend) namespace(containerPid int) *Namespace {
+	ns, ok := b.namespaces[containerPid]
+	if !ok {
+		ns = &Namespace{Links: make(map[string]*Link), VRFs: make(map[string]int)}
+		b.namespaces[containerPid] = ns
+	}
+	return ns
+}
+
+func (b *Backend) containerLink(containerPid int, name string) (*Link, error) {
+	if ns, ok := b.namespaces[containerPid]; ok {
+		if link, ok := ns.Links[name]; ok {
+			return link, nil
+		}
+	}
+	return nil, remoteNetlink.LinkNotFoundError{}
+}
+
+func linkNames(isInternal bool, interfaceName string) (string, string) {
+	if isInternal {
+		return "int" + interfaceName, internalNetlink.DefaultInternalContainerInterface
+	}
+	return "ext" + interfaceName, internalNetlink.DefaultExternalContainerInterface
+}
+
+func (b *Backend) SetInterface2Container(containerPid int, interfaceName string, isInternal bool, cfg *internalNetlink.Config) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetInterface2Container"); err != nil {
+		return err
+	}
+	hostName, peerName := linkNames(isInternal, interfaceName)
+	if _, exist := b.hostLinks[hostName]; exist {
+		return nil
+	}
+	bridge := cfg.ExternalBridgeName
+	if isInternal {
+		bridge = cfg.InternalBridgeName
+	}
+	if !b.bridges[bridge] {
+		return remoteNetlink.LinkNotFoundError{}
+	}
+	b.hostLinks[hostName] = &Link{Name: hostName, Up: true, Bridge: bridge}
+	b.peers[hostName] = containerPid
+	b.namespace(containerPid).Links[peerName] = &Link{Name: peerName, Up: true}
+	return nil
+}
+
+func (b *Backend) ClearVethInterface(interfaceName string, isInternal bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("ClearVethInterface"); err != nil {
+		return err
+	}
+	hostName, peerName := linkNames(isInternal, interfaceName)
+	if _, exist := b.hostLinks[hostName]; !exist {
+		return nil
+	}
+	if ns, ok := b.namespaces[b.peers[hostName]]; ok {
+		delete(ns.Links, peerName)
+		routes := ns.Routes[:0]
+		for _, route := range ns.Routes {
+			if route.Link != peerName {
+				routes = append(routes, route)
+			}
+		}
+		ns.Routes = routes
+	}
+	delete(b.hostLinks, hostName)
+	delete(b.peers, hostName)
+	return nil
+}
+
+func (b *Backend) SetVlan(interfaceName string, newVlan int, oldVlan int, cfg *internalNetlink.Config) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetVlan"); err != nil {
+		return err
+	}
+	link, exist := b.hostLinks[interfaceName]
+	if !exist {
+		if newVlan != 0 {
+			return remoteNetlink.LinkNotFoundError{}
+		}
+		return nil
+	}
+	vlans := link.Vlans[:0]
+	for _, vlan := range link.Vlans {
+		if vlan != oldVlan {
+			vlans = append(vlans, vlan)
+		}
+	}
+	if newVlan != 0 {
+		vlans = append(vlans, newVlan)
+	}
+	link.Vlans = vlans
+	return nil
+}
+
+func (b *Backend) SetIPaddress2Container(containerPid int, ip string, netmask string, isInternal bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetIPaddress2Container"); err != nil {
+		return err
+	}
+	_, peerName := linkNames(isInternal, "")
+	link, err := b.containerLink(containerPid, peerName)
+	if err != nil {
+		return err
+	}
+	ones, _ := net.IPMask(net.ParseIP(netmask).To4()).Size()
+	addr := ip + "/" + strconv.Itoa(ones)
+	if _, _, err := net.ParseCIDR(addr); err != nil {
+		return err
+	}
+	addresses := []string{addr}
+	for _, address := range link.Addresses {
+		if !isIPv4(address) {
+			addresses = append(addresses, address)
+		}
+	}
+	link.Addresses = addresses
+	link.Up = true
+	return nil
+}
+
+func (b *Backend) SetIPv6Address2Container(containerPid int, cidr string, isInternal bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetIPv6Address2Container"); err != nil {
+		return err
+	}
+	_, peerName := linkNames(isInternal, "")
+	link, err := b.containerLink(containerPid, peerName)
+	if err != nil {
+		return err
+	}
+	var addresses []string
+	for _, address := range link.Addresses {
+		if isIPv4(address) {
+			addresses = append(addresses, address)
+		}
+	}
+	if cidr != "" {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return err
+		}
+		addresses = append(addresses, cidr)
+	}
+	link.Addresses = addresses
+	link.Up = true
+	return nil
+}
+
+func (b *Backend) SetRoute2Container(containerPid int, interfaceName string, tableNum int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetRoute2Container"); err != nil {
+		return err
+	}
+	link, err := b.containerLink(containerPid, interfaceName)
+	if err != nil {
+		return err
+	}
+	b.setRoutes(b.namespaces[containerPid], link, tableNum)
+	return nil
+}
+
+// setRoutes replaces the routes of the table through the link with the
+// connected routes of its addresses
+func (b *Backend) setRoutes(ns *Namespace, link *Link, tableNum int) {
+	routes := ns.Routes[:0]
+	for _, route := range ns.Routes {
+		// the default route is set apart
+		if route.Table != tableNum || route.Link != link.Name || route.Dst == "" {
+			routes = append(routes, route)
+		}
+	}
+	for _, address := range link.Addresses {
+		ip, network, _ := net.ParseCIDR(address)
+		family := remoteNetlink.FAMILY_V4
+		if ip.To4() == nil {
+			family = remoteNetlink.FAMILY_V6
+		}
+		routes = append(routes, Route{Family: family, Table: tableNum, Dst: network.String(), Link: link.Name})
+	}
+	ns.Routes = routes
+}
+
+func (b *Backend) SetRouteRule2Container(containerPid int, family int, markNumber int, tableNumber int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetRouteRule2Container"); err != nil {
+		return err
+	}
+	ns := b.namespace(containerPid)
+	rule := Rule{Family: family, Mark: markNumber, Table: tableNumber}
+	for _, r := range ns.Rules {
+		if r == rule {
+			return nil
+		}
+	}
+	ns.Rules = append(ns.Rules, rule)
+	return nil
+}
+
+func (b *Backend) SetDefaultRoute2Container(containerPid int, family int, gwIP string, tableNum int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetDefaultRoute2Container"); err != nil {
+		return err
+	}
+	ns := b.namespace(containerPid)
+	routes := ns.Routes[:0]
+	for _, route := range ns.Routes {
+		if route.Family != family || route.Table != tableNum || route.Dst != "" {
+			routes = append(routes, route)
+		}
+	}
+	ns.Routes = routes
+	if gwIP == "" {
+		return nil
+	}
+	gw := net.ParseIP(gwIP)
+	if gw == nil {
+		return fmt.Errorf("invalid gateway %q", gwIP)
+	}
+	route := Route{Family: family, Table: tableNum, Gw: gwIP}
+	// a link-local gateway is only reachable through a given link
+	if gw.IsLinkLocalUnicast() {
+		if _, err := b.containerLink(containerPid, internalNetlink.DefaultExternalContainerInterface); err != nil {
+			return err
+		}
+		route.Link = internalNetlink.DefaultExternalContainerInterface
+	}
+	ns.Routes = append(ns.Routes, route)
+	return nil
+}
+
+func (b *Backend) SetInternalNetworks2Container(containerPid int, interfaceName string, applied []internalNetlink.InternalNetwork, networks []internalNetlink.InternalNetwork, tableNum int, cfg *internalNetlink.Config) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetInternalNetworks2Container"); err != nil {
+		return err
+	}
+	desired := map[internalNetlink.InternalNetwork]bool{}
+	for _, network := range networks {
+		desired[network] = true
+	}
+	for _, network := range applied {
+		if !desired[network] {
+			b.clearInternalNetwork(interfaceName, network)
+		}
+	}
+	if !b.bridges[cfg.InternalBridgeName] {
+		return remoteNetlink.LinkNotFoundError{}
+	}
+	ns := b.namespace(containerPid)
+	used := map[int]bool{}
+	for _, network := range networks {
+		used[network.VRFTable] = true
+	}
+	for name, table := range ns.VRFs {
+		if !used[table] {
+			delete(ns.VRFs, name)
+		}
+	}
+	for _, network := range networks {
+		if _, _, err := net.ParseCIDR(network.CIDR); err != nil {
+			return err
+		}
+		hostName := internalNetlink.InternalNetworkHostLink(interfaceName, network.Vlan)
+		b.hostLinks[hostName] = &Link{Name: hostName, Up: true, Bridge: cfg.InternalBridgeName, Vlans: []int{network.Vlan}}
+		b.peers[hostName] = containerPid
+		link := &Link{Name: network.Link, Up: true, Addresses: []string{network.CIDR}}
+		ns.Links[network.Link] = link
+		// the kernel routes the links of a VRF in its table
+		if network.VRFTable != 0 {
+			link.Master = internalNetlink.InternalNetworkVRF(network.VRFTable)
+			ns.VRFs[link.Master] = network.VRFTable
+			b.setRoutes(ns, link, network.VRFTable)
+			continue
+		}
+		b.setRoutes(ns, link, tableNum)
+	}
+	return nil
+}
+
+func (b *Backend) SetVRFRoutes2Container(containerPid int, applied []int, tables []int, gwIP string, markOffset int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("SetVRFRoutes2Container"); err != nil {
+		return err
+	}
+	ns := b.namespace(containerPid)
+	cleared := map[int]bool{}
+	for _, table := range applied {
+		cleared[table] = true
+	}
+	for _, table := range tables {
+		cleared[table] = true
+	}
+	routes := ns.Routes[:0]
+	for _, route := range ns.Routes {
+		if !cleared[route.Table] || route.Family != remoteNetlink.FAMILY_V4 || route.Dst != "" {
+			routes = append(routes, route)
+		}
+	}
+	ns.Routes = routes
+	rules := ns.Rules[:0]
+	for _, rule := range ns.Rules {
+		if !cleared[rule.Table] || rule.Mark != markOffset+rule.Table {
+			rules = append(rules, rule)
+		}
+	}
+	ns.Rules = rules
+	if len(tables) == 0 {
+		return nil
+	}
+	if _, err := b.containerLink(containerPid, internalNetlink.DefaultExternalContainerInterface); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if gwIP != "" {
+			if net.ParseIP(gwIP) == nil {
+				return fmt.Errorf("invalid gateway %q", gwIP)
+			}
+			ns.Routes = append(ns.Routes, Route{Family: remoteNetlink.FAMILY_V4, Table: table, Gw: gwIP, Link: internalNetlink.DefaultExternalContainerInterface})
+		}
+		ns.Rules = append(ns.Rules, Rule{Family: remoteNetlink.FAMILY_V4, Mark: markOffset + table, Table: table})
+	}
+	return nil
+}
+
+func (b *Backend) ClearInternalNetworks(interfaceName string, networks []internalNetlink.InternalNetwork, cfg *internalNetlink.Config) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.call("ClearInternalNetworks"); err != nil {
+		return err
+	}
+	for _, network := range networks {
+		b.clearInternalNetwork(interfaceName, network)
+	}
+	return nil
+}
+
+// clearInternalNetwork removes the veth pair of the internal network and the
+// routes through it
+func (b *Backend) clearInternalNetwork(interfaceName string, network internalNetlink.InternalNetwork) {
+	hostName := internalNetlink.InternalNetworkHostLink(interfaceName, network.Vlan)
+	if _, exist := b.hostLinks[hostName]; !exist {
+		return
+	}
+	if ns, ok := b.namespaces[b.peers[hostName]]; ok {
+		delete(ns.Links, network.Link)
+		routes := ns.Routes[:0]
+		for _, route := range ns.Routes {
+			if route.Link != network.Link {
+				routes = append(routes, route)
+			}
+		}
+		ns.Routes = routes
+	}
+	delete(b.hostLinks, hostName)
+	delete(b.peers, hostName)
+}
+
+// Table returns the routes of the table, the default route first.
+func (n *Namespace) Table(table int) []Route {
+	var routes []Route
+	for _, route := range n.Routes {
+		if route.Table == table {
+			routes = append(routes, route)
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Family != routes[j].Family {
+			return routes[i].Family < routes[j].Family
+		}
+		return routes[i].Dst < routes[j].Dst
+	})
+	return routes
+}
+
+func isIPv4(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() != nil
+}
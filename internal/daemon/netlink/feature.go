@@ -0,0 +1,181 @@
+package netlink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// Feature is a kernel data plane capability the router may depend on
+type Feature string
+
+const (
+	FeatureNftables            Feature = "nftables"
+	FeatureIptables            Feature = "iptables"
+	FeatureIp6tables           Feature = "ip6tables"
+	FeatureIPv6                Feature = "ipv6"
+	FeatureIPVS                Feature = "ipvs"
+	FeatureXDP                 Feature = "xdp"
+	FeatureWireGuard           Feature = "wireguard"
+	FeatureVRF                 Feature = "vrf"
+	FeatureBridgeVlanFiltering Feature = "bridge-vlan-filtering"
+	FeaturePolicyRouting       Feature = "policy-routing"
+	FeatureFlowtable           Feature = "flowtable"
+
+	// probe links are created and removed right away while probing
+	probeLinkPrefix = "vrprobe-"
+	// probeVrfTable is bound to the VRF probe link, never used for routing
+	probeVrfTable uint32 = 65000
+)
+
+// Features lists every probed feature, in the order they are reported
+var Features = []Feature{
+	FeatureNftables,
+	FeatureIptables,
+	FeatureIp6tables,
+	FeatureIPv6,
+	FeatureIPVS,
+	FeatureXDP,
+	FeatureWireGuard,
+	FeatureVRF,
+	FeatureBridgeVlanFiltering,
+	FeaturePolicyRouting,
+	FeatureFlowtable,
+}
+
+// FeatureMatrix records which features the kernel of the node supports
+type FeatureMatrix map[Feature]bool
+
+func (m FeatureMatrix) Supports(feature Feature) bool {
+	return m[feature]
+}
+
+// Select returns the first supported feature among equivalent ones, given
+// in order of preference.
+func (m FeatureMatrix) Select(equivalents ...Feature) (Feature, bool) {
+	for _, feature := range equivalents {
+		if m.Supports(feature) {
+			return feature, true
+		}
+	}
+	return "", false
+}
+
+func (m FeatureMatrix) String() string {
+	var features []string
+	for _, feature := range Features {
+		features = append(features, fmt.Sprintf("%s=%t", feature, m.Supports(feature)))
+	}
+	return strings.Join(features, ",")
+}
+
+// ProbeFeatures checks the kernel support of every feature. Link types are
+// probed by creating a throwaway link, the others by the proc and sysfs
+// entries their modules expose.
+func ProbeFeatures() (FeatureMatrix, error) {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Probing features failed while getting rootNetlinkHandle")
+		return nil, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	_, ruleErr := rootNetlinkHandle.RuleList(remoteNetlink.FAMILY_V4)
+
+	features := probeModuleFeatures("/")
+	features[FeatureWireGuard] = probeLink(rootNetlinkHandle, &remoteNetlink.Wireguard{LinkAttrs: remoteNetlink.LinkAttrs{Name: probeLinkPrefix + "wg"}})
+	features[FeatureVRF] = probeLink(rootNetlinkHandle, &remoteNetlink.Vrf{LinkAttrs: remoteNetlink.LinkAttrs{Name: probeLinkPrefix + "vrf"}, Table: probeVrfTable})
+	features[FeatureBridgeVlanFiltering] = probeLink(rootNetlinkHandle, &remoteNetlink.Bridge{
+		LinkAttrs:     remoteNetlink.LinkAttrs{Name: probeLinkPrefix + "br"},
+		VlanFiltering: &[]bool{true}[0],
+	})
+	features[FeaturePolicyRouting] = ruleErr == nil
+	return features, nil
+}
+
+// probeModuleFeatures checks the features told by the proc and sysfs entries
+// under root, the root of the node filesystem.
+func probeModuleFeatures(root string) FeatureMatrix {
+	exists := func(paths ...string) bool {
+		for i := range paths {
+			paths[i] = filepath.Join(root, paths[i])
+		}
+		return pathExists(paths...)
+	}
+	return FeatureMatrix{
+		FeatureNftables:  exists("/sys/module/nf_tables", "/proc/net/netfilter/nf_tables"),
+		FeatureIptables:  exists("/sys/module/ip_tables", "/proc/net/ip_tables_names"),
+		FeatureIp6tables: exists("/sys/module/ip6_tables", "/proc/net/ip6_tables_names"),
+		FeatureIPv6:      exists("/proc/sys/net/ipv6"),
+		FeatureIPVS:      exists("/sys/module/ip_vs", "/proc/net/ip_vs"),
+		FeatureXDP:       kernelAtLeast(readKernelRelease(root), 4, 12),
+		FeatureFlowtable: exists("/sys/module/nf_flow_table"),
+	}
+}
+
+// probeLink reports whether the kernel accepts the link, and for bridges
+// whether it kept VLAN filtering on, since older kernels silently ignore it.
+func probeLink(rootNetlinkHandle *remoteNetlink.Handle, link remoteNetlink.Link) bool {
+	if err := clearLink(rootNetlinkHandle, link.Attrs().Name); err != nil {
+		return false
+	}
+	if err := rootNetlinkHandle.LinkAdd(link); err != nil {
+		klog.InfoS("Link type is not supported", "type", link.Type(), "err", err)
+		return false
+	}
+	defer clearLink(rootNetlinkHandle, link.Attrs().Name)
+
+	if _, isBridge := link.(*remoteNetlink.Bridge); !isBridge {
+		return true
+	}
+	created, err := rootNetlinkHandle.LinkByName(link.Attrs().Name)
+	if err != nil {
+		return false
+	}
+	bridge, ok := created.(*remoteNetlink.Bridge)
+	return ok && bridge.VlanFiltering != nil && *bridge.VlanFiltering
+}
+
+func pathExists(paths ...string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func readKernelRelease(root string) string {
+	release, err := ioutil.ReadFile(filepath.Join(root, "/proc/sys/kernel/osrelease"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(release))
+}
+
+// kernelAtLeast compares a release such as "5.4.0-91-generic" against major.minor
+func kernelAtLeast(release string, major int, minor int) bool {
+	versions := strings.SplitN(release, ".", 3)
+	if len(versions) < 2 {
+		return false
+	}
+	releaseMajor, err := strconv.Atoi(versions[0])
+	if err != nil {
+		return false
+	}
+	minorDigits := strings.IndexFunc(versions[1], func(r rune) bool { return r < '0' || r > '9' })
+	if minorDigits >= 0 {
+		versions[1] = versions[1][:minorDigits]
+	}
+	releaseMinor, err := strconv.Atoi(versions[1])
+	if err != nil {
+		return false
+	}
+	return releaseMajor > major || (releaseMajor == major && releaseMinor >= minor)
+}
@@ -0,0 +1,121 @@
+package netlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeatureMatrixSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		matrix   FeatureMatrix
+		expected Feature
+		ok       bool
+	}{
+		{"preferred", FeatureMatrix{FeatureNftables: true, FeatureIptables: true}, FeatureNftables, true},
+		{"fallback", FeatureMatrix{FeatureIptables: true}, FeatureIptables, true},
+		{"none", FeatureMatrix{FeatureIPVS: true}, "", false},
+	}
+	for _, test := range tests {
+		feature, ok := test.matrix.Select(FeatureNftables, FeatureIptables)
+		if feature != test.expected || ok != test.ok {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", test.name, test.expected, test.ok, feature, ok)
+		}
+	}
+}
+
+// fakeNodeRoot lays out the proc and sysfs entries under a temporary root
+func fakeNodeRoot(t *testing.T, release string, entries ...string) string {
+	root, err := ioutil.TempDir("", "features")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	for _, entry := range entries {
+		if err := os.MkdirAll(filepath.Join(root, entry), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if release != "" {
+		if err := os.MkdirAll(filepath.Join(root, "/proc/sys/kernel"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, "/proc/sys/kernel/osrelease"), []byte(release+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestProbeModuleFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  string
+		entries  []string
+		expected []Feature
+	}{
+		{
+			name:     "nftables module",
+			release:  "5.4.0-91-generic",
+			entries:  []string{"/sys/module/nf_tables", "/sys/module/nf_flow_table", "/proc/sys/net/ipv6"},
+			expected: []Feature{FeatureNftables, FeatureFlowtable, FeatureIPv6, FeatureXDP},
+		},
+		{
+			// built into the kernel, nf_tables has no module but its proc entry
+			name:     "nftables built in",
+			release:  "5.10.0",
+			entries:  []string{"/proc/net/netfilter/nf_tables"},
+			expected: []Feature{FeatureNftables, FeatureXDP},
+		},
+		{
+			name:     "iptables only",
+			release:  "3.10.0-1160.el7.x86_64",
+			entries:  []string{"/sys/module/ip_tables", "/proc/net/ip6_tables_names", "/proc/sys/net/ipv6"},
+			expected: []Feature{FeatureIptables, FeatureIp6tables, FeatureIPv6},
+		},
+		{
+			name:     "ipvs",
+			release:  "4.12.0",
+			entries:  []string{"/proc/net/ip_vs", "/proc/net/ip_tables_names"},
+			expected: []Feature{FeatureIptables, FeatureIPVS, FeatureXDP},
+		},
+		{
+			name:     "nothing",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		features := probeModuleFeatures(fakeNodeRoot(t, test.release, test.entries...))
+		expected := FeatureMatrix{}
+		for _, feature := range test.expected {
+			expected[feature] = true
+		}
+		for _, feature := range Features {
+			if features.Supports(feature) != expected.Supports(feature) {
+				t.Errorf("%s: expected %s=%t, got %s", test.name, feature, expected.Supports(feature), features)
+			}
+		}
+	}
+}
+
+func TestKernelAtLeast(t *testing.T) {
+	tests := []struct {
+		release  string
+		expected bool
+	}{
+		{"4.12.0", true},
+		{"4.11.12", false},
+		{"5.4.0-91-generic", true},
+		{"3.10.0-1160.el7.x86_64", false},
+		{"4.19+", true},
+		{"4", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if supported := kernelAtLeast(test.release, 4, 12); supported != test.expected {
+			t.Errorf("%q: expected %t, got %t", test.release, test.expected, supported)
+		}
+	}
+}
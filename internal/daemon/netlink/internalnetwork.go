@@ -0,0 +1,341 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// InternalNetwork is an internal network of a container besides that of its
+// internal interface, on a vlan of the internal bridge. Link is the name of
+// its interface in the container and CIDR the address of the container on
+// it. VRFTable is the table of the VRF the link is enslaved to, 0 if the
+// network is routed in the router table.
+type InternalNetwork struct {
+	Link     string
+	Vlan     int
+	CIDR     string
+	VRFTable int
+}
+
+// InternalNetworkHostLink returns the host end of the veth pair of the
+// internal network on the vlan, for the container whose interfaces are named
+// after interfaceName.
+func InternalNetworkHostLink(interfaceName string, vlan int) string {
+	return fmt.Sprintf("n%s%d", interfaceName, vlan)
+}
+
+// InternalNetworkVRF returns the VRF device of the table in the container.
+func InternalNetworkVRF(table int) string {
+	return fmt.Sprintf("vrf%d", table)
+}
+
+// unusedVRFs returns the tables of the VRFs of the applied networks none of
+// the given ones is in.
+func unusedVRFs(applied []InternalNetwork, networks []InternalNetwork) []int {
+	used := map[int]bool{}
+	for _, network := range networks {
+		used[network.VRFTable] = true
+	}
+	var unused []int
+	for _, network := range applied {
+		if network.VRFTable != 0 && !used[network.VRFTable] {
+			unused = append(unused, network.VRFTable)
+			used[network.VRFTable] = true
+		}
+	}
+	return unused
+}
+
+// SetInternalNetworks2Container replaces the internal networks applied before
+// to the container with the given ones. The networks are connected to the
+// internal bridge like the internal interface, and the routes through them
+// copied into the table, unless they are in a VRF: their links are enslaved
+// to the VRF device of their table, the kernel routing them there, and VRFs
+// left without networks are removed.
+func SetInternalNetworks2Container(containerPid int, interfaceName string, applied []InternalNetwork, networks []InternalNetwork, tableNum int, cfg *Config) error {
+	desired := map[InternalNetwork]bool{}
+	for _, network := range networks {
+		desired[network] = true
+	}
+	kept := map[InternalNetwork]bool{}
+	var removed []InternalNetwork
+	for _, network := range applied {
+		if desired[network] {
+			kept[network] = true
+			continue
+		}
+		removed = append(removed, network)
+	}
+	if err := ClearInternalNetworks(interfaceName, removed, cfg); err != nil {
+		return err
+	}
+	unused := unusedVRFs(applied, networks)
+	if len(kept) == len(networks) && len(unused) == 0 {
+		return nil
+	}
+
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Initializing failed while getting rootNetlinkHandle")
+		return err
+	}
+	defer rootNetlinkHandle.Delete()
+	internalBridge, _ := bridgeNames(cfg)
+	bridge, err := rootNetlinkHandle.LinkByName(internalBridge)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", internalBridge)
+		return err
+	}
+	nsHandle := GetNsHandle(CrioType(containerPid))
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(nsHandle)
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	for _, table := range unused {
+		if vrf, err := targetNetlinkHandle.LinkByName(InternalNetworkVRF(table)); err == nil {
+			if err := targetNetlinkHandle.LinkDel(vrf); err != nil {
+				klog.ErrorS(err, "LinkDel failed", "interfaceName", InternalNetworkVRF(table))
+				return err
+			}
+		}
+	}
+	for _, network := range networks {
+		if kept[network] {
+			continue
+		}
+		hostName := InternalNetworkHostLink(interfaceName, network.Vlan)
+		// attaching again finds the veth pair of a container attached before
+		if _, err := rootNetlinkHandle.LinkByName(hostName); err != nil {
+			veth := &remoteNetlink.Veth{
+				LinkAttrs: remoteNetlink.LinkAttrs{Name: hostName},
+				PeerName:  network.Link,
+			}
+			if err := rootNetlinkHandle.LinkAdd(veth); err != nil {
+				klog.ErrorS(err, "LinkAdd failed", "interfaceName", hostName, "peerName", network.Link)
+				return err
+			}
+			peer, err := rootNetlinkHandle.LinkByName(network.Link)
+			if err != nil {
+				klog.ErrorS(err, "LinkByName is failed", "interfaceName", network.Link)
+				return err
+			}
+			if err := rootNetlinkHandle.LinkSetNsFd(peer, int(nsHandle)); err != nil {
+				klog.ErrorS(err, "Setting Veth interface to target NS failed", "interfaceName", network.Link)
+				return err
+			}
+		}
+		host, err := rootNetlinkHandle.LinkByName(hostName)
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", hostName)
+			return err
+		}
+		if err := attachInterface2Bridge(rootNetlinkHandle, host, bridge); err != nil {
+			klog.ErrorS(err, "attach failed", "interfaceName", hostName, "bridgeName", internalBridge)
+			return err
+		}
+		if err := setLinkUp(rootNetlinkHandle, host); err != nil {
+			return err
+		}
+		if err := SetVlan(hostName, network.Vlan, 0, cfg); err != nil {
+			return err
+		}
+
+		link, err := targetNetlinkHandle.LinkByName(network.Link)
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", network.Link)
+			return err
+		}
+		// enslaving the link moves its routes, it is done ahead of addressing
+		if network.VRFTable != 0 {
+			vrf, err := ensureVRF(targetNetlinkHandle, network.VRFTable)
+			if err != nil {
+				return err
+			}
+			if err := targetNetlinkHandle.LinkSetMaster(link, vrf); err != nil {
+				klog.ErrorS(err, "LinkSetMaster failed", "interfaceName", network.Link, "master", vrf.Attrs().Name)
+				return err
+			}
+		}
+		addr, err := remoteNetlink.ParseAddr(network.CIDR)
+		if err != nil {
+			klog.ErrorS(err, "ParseAddr is failed", "addr", network.CIDR)
+			return err
+		}
+		if err := targetNetlinkHandle.AddrReplace(link, addr); err != nil {
+			klog.ErrorS(err, "AddrReplace failed", "interfaceName", network.Link, "address", network.CIDR)
+			return err
+		}
+		if err := setLinkUp(targetNetlinkHandle, link); err != nil {
+			return err
+		}
+		if network.VRFTable == 0 {
+			if err := SetRoute2Container(containerPid, network.Link, tableNum); err != nil {
+				return err
+			}
+		}
+		klog.InfoS("Internal network set", "interfaceName", network.Link, "vlan", network.Vlan, "cidr", network.CIDR, "vrfTable", network.VRFTable)
+	}
+	return nil
+}
+
+// ensureVRF returns the VRF device of the table, created and set up if the
+// container has none.
+func ensureVRF(handle *remoteNetlink.Handle, table int) (remoteNetlink.Link, error) {
+	name := InternalNetworkVRF(table)
+	if vrf, err := handle.LinkByName(name); err == nil {
+		return vrf, nil
+	}
+	if err := handle.LinkAdd(&remoteNetlink.Vrf{
+		LinkAttrs: remoteNetlink.LinkAttrs{Name: name},
+		Table:     uint32(table),
+	}); err != nil {
+		klog.ErrorS(err, "LinkAdd failed", "interfaceName", name, "table", table)
+		return nil, err
+	}
+	vrf, err := handle.LinkByName(name)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", name)
+		return nil, err
+	}
+	if err := setLinkUp(handle, vrf); err != nil {
+		return nil, err
+	}
+	return vrf, nil
+}
+
+// SetVRFRoutes2Container replaces the egress of the VRF tables applied
+// before to the container with that of the given ones: a default route via
+// the gateway on the external interface, leaked out of the VRF, and a rule
+// looking the replies up in the table, marked with the mark offset by the
+// table. A table left without egress loses both.
+func SetVRFRoutes2Container(containerPid int, applied []int, tables []int, gwIP string, markOffset int) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	desired := map[int]bool{}
+	for _, table := range tables {
+		desired[table] = true
+	}
+	rules, err := targetNetlinkHandle.RuleList(remoteNetlink.FAMILY_V4)
+	if err != nil {
+		klog.ErrorS(err, "RuleList failed")
+		return err
+	}
+	for _, table := range applied {
+		if desired[table] {
+			continue
+		}
+		if err := clearDefaultRoute(targetNetlinkHandle, table); err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if rule.Table == table && rule.Mark == markOffset+table {
+				if err := targetNetlinkHandle.RuleDel(&rule); err != nil {
+					klog.ErrorS(err, "RuleDel failed", "table", table)
+					return err
+				}
+			}
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	link, err := targetNetlinkHandle.LinkByName(DefaultExternalContainerInterface)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", DefaultExternalContainerInterface)
+		return err
+	}
+	for _, table := range tables {
+		if err := clearDefaultRoute(targetNetlinkHandle, table); err != nil {
+			return err
+		}
+		if gwIP != "" {
+			// the gateway is resolved outside the VRF, on the external link
+			route := &remoteNetlink.Route{
+				Table:     table,
+				Gw:        net.ParseIP(gwIP),
+				LinkIndex: link.Attrs().Index,
+			}
+			if err := targetNetlinkHandle.RouteAdd(route); err != nil {
+				klog.ErrorS(err, "RouteAdd failed", "table", table, "gatewayIP", gwIP)
+				return err
+			}
+		}
+		exist := false
+		for _, rule := range rules {
+			if rule.Table == table && rule.Mark == markOffset+table {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			rule := remoteNetlink.NewRule()
+			rule.Family = remoteNetlink.FAMILY_V4
+			rule.Mark = markOffset + table
+			rule.Table = table
+			if err := targetNetlinkHandle.RuleAdd(rule); err != nil {
+				klog.ErrorS(err, "RuleAdd failed", "table", table)
+				return err
+			}
+		}
+		klog.InfoS("VRF egress set", "table", table, "gatewayIP", gwIP)
+	}
+	return nil
+}
+
+// clearDefaultRoute removes the IPv4 default route of the table.
+func clearDefaultRoute(handle *remoteNetlink.Handle, table int) error {
+	routes, err := handle.RouteListFiltered(remoteNetlink.FAMILY_V4, &remoteNetlink.Route{
+		Table: table,
+	}, remoteNetlink.RT_FILTER_DST|remoteNetlink.RT_FILTER_TABLE)
+	if err != nil {
+		klog.ErrorS(err, "RouteListFiltered failed", "table", table)
+		return err
+	}
+	for _, route := range routes {
+		if route.Dst == nil && route.Table == table {
+			if err := handle.RouteDel(&route); err != nil {
+				klog.ErrorS(err, "RouteDel failed", "table", table)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ClearInternalNetworks removes the host ends of the veth pairs of the
+// internal networks of the container, and with them the container ends and
+// the routes through them.
+func ClearInternalNetworks(interfaceName string, networks []InternalNetwork, cfg *Config) error {
+	if len(networks) == 0 {
+		return nil
+	}
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Initializing failed while getting rootNetlinkHandle")
+		return err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	for _, network := range networks {
+		hostName := InternalNetworkHostLink(interfaceName, network.Vlan)
+		if err := SetVlan(hostName, 0, network.Vlan, cfg); err != nil {
+			return err
+		}
+		if err := clearVethInterface(rootNetlinkHandle, hostName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package netlink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultMTU is the MTU interfaces are created with
+	DefaultMTU = 1500
+	// MinPathMTU is the smallest path MTU probed for, the IPv6 minimum
+	MinPathMTU = 1280
+	// pathMTUProbeAttempts is how many echo requests of a size go unanswered
+	// before the size is taken as too large, so a lost echo doesn't lower
+	// the MTU
+	pathMTUProbeAttempts = 2
+	// pathMTUProbeTimeout bounds the wait for the reply to an echo request
+	pathMTUProbeTimeout = time.Second
+	// ipv4ICMPHeaderLength is the size of the headers of an ICMP echo
+	// request ahead of its payload
+	ipv4ICMPHeaderLength = 20 + 8
+)
+
+// pathMTUProbeID tells apart the echo requests of probes running at once
+var pathMTUProbeID uint32
+
+// pingDF sends an ICMP echo request of the size, headers included, from the
+// source address with the Don't Fragment bit set, in the network namespace
+// of the process, and waits for the reply
+var pingDF = func(pid int, source string, destination string, size int) error {
+	ns := GetNsHandle(CrioType(pid))
+	if !ns.IsOpen() {
+		return fmt.Errorf("no network namespace of pid %d", pid)
+	}
+	defer ns.Close()
+
+	// sockets stay in the namespace they are opened in
+	var conn net.PacketConn
+	if err := RunInNs(ns, func() (err error) {
+		conn, err = (&net.ListenConfig{Control: setDontFragment}).ListenPacket(context.Background(), "ip4:icmp", source)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer conn.Close()
+	return echoDF(conn, &net.IPAddr{IP: net.ParseIP(destination)}, size)
+}
+
+// setDontFragment has the kernel send the packets of the socket with the
+// Don't Fragment bit set, failing those larger than the MTU of the route
+// rather than fragmenting them.
+func setDontFragment(network string, address string, c syscall.RawConn) error {
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
+}
+
+// echoDF sends an ICMP echo request of the size, headers included, to the
+// target and waits for its reply.
+func echoDF(conn net.PacketConn, target net.Addr, size int) error {
+	if size < ipv4ICMPHeaderLength {
+		return fmt.Errorf("%d byte packets can't hold an echo request", size)
+	}
+	id := (os.Getpid() + int(atomic.AddUint32(&pathMTUProbeID, 1))) & 0xffff
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: make([]byte, size-ipv4ICMPHeaderLength)},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(request, target); err != nil {
+		return err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(pathMTUProbeTimeout)); err != nil {
+		return err
+	}
+	buffer := make([]byte, size)
+	for {
+		length, peer, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		if peer.String() != target.String() {
+			continue
+		}
+		reply, err := icmp.ParseMessage(1, buffer[:length])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == id {
+			return nil
+		}
+	}
+}
+
+// ProbePathMTU returns the largest packet up to max that reaches the
+// destination from the source address of the container unfragmented. The
+// interface the destination is reached through must take max large packets.
+func ProbePathMTU(containerPid int, source string, destination string, max int) (int, error) {
+	fits := func(size int) bool {
+		for attempt := 0; attempt < pathMTUProbeAttempts; attempt++ {
+			if pingDF(containerPid, source, destination, size) == nil {
+				return true
+			}
+		}
+		return false
+	}
+	min := MinPathMTU
+	if max < min {
+		min = max
+	}
+	if !fits(min) {
+		return 0, fmt.Errorf("%s unreachable from %s with %d byte packets", destination, source, min)
+	}
+	return searchPathMTU(min, max, fits), nil
+}
+
+// searchPathMTU returns the largest size from min, which fits, to max that
+// fits.
+func searchPathMTU(min int, max int, fits func(int) bool) int {
+	for min < max {
+		size := (min + max + 1) / 2
+		if fits(size) {
+			min = size
+		} else {
+			max = size - 1
+		}
+	}
+	return min
+}
+
+// ExternalUplinkMTU returns the largest MTU of the ports of the external
+// bridge other than the router veths, the MTU of the bridge itself if it has
+// none. Router interfaces can't take larger packets out of the node.
+func ExternalUplinkMTU(cfg *Config) (int, error) {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return 0, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	bridgeName := cfg.ExternalBridgeName
+	if bridgeName == "" {
+		bridgeName = DefaultExternalBridgeName
+	}
+	bridge, err := rootNetlinkHandle.LinkByName(bridgeName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", bridgeName)
+		return 0, err
+	}
+	links, err := rootNetlinkHandle.LinkList()
+	if err != nil {
+		return 0, err
+	}
+	var mtu int
+	for _, link := range links {
+		if link.Attrs().MasterIndex != bridge.Attrs().Index || link.Type() == TYPEVETH {
+			continue
+		}
+		if link.Attrs().MTU > mtu {
+			mtu = link.Attrs().MTU
+		}
+	}
+	if mtu == 0 {
+		mtu = bridge.Attrs().MTU
+	}
+	return mtu, nil
+}
+
+// SetMTU2Container sets the MTU of the internal or external interface of the
+// container and of its host end, named after the given name like
+// ClearVethInterface. The bridge the host end is on follows the smallest MTU
+// of its ports by itself. External interfaces of SR-IOV virtual functions
+// have no host end.
+func SetMTU2Container(containerPid int, interfaceName string, mtu int, isInternal bool) error {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Setting MTU failed while getting rootNetlinkHandle")
+		return err
+	}
+	defer rootNetlinkHandle.Delete()
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	hostInterfaceName, containerInterfaceName := "ext"+interfaceName, DefaultExternalContainerInterface
+	if isInternal {
+		hostInterfaceName, containerInterfaceName = "int"+interfaceName, DefaultInternalContainerInterface
+	}
+	for _, target := range []struct {
+		handle        *remoteNetlink.Handle
+		interfaceName string
+	}{{rootNetlinkHandle, hostInterfaceName}, {targetNetlinkHandle, containerInterfaceName}} {
+		link, err := target.handle.LinkByName(target.interfaceName)
+		if _, notFound := err.(remoteNetlink.LinkNotFoundError); notFound && !isInternal && target.handle == rootNetlinkHandle {
+			continue
+		}
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", target.interfaceName)
+			return err
+		}
+		if link.Attrs().MTU == mtu {
+			continue
+		}
+		if err := target.handle.LinkSetMTU(link, mtu); err != nil {
+			klog.ErrorS(err, "LinkSetMTU failed", "interfaceName", target.interfaceName, "mtu", mtu)
+			return err
+		}
+	}
+	klog.InfoS("MTU set", "interfaceName", containerInterfaceName, "mtu", mtu)
+	return nil
+}
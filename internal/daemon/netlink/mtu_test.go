@@ -0,0 +1,89 @@
+package netlink
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSearchPathMTU(t *testing.T) {
+	for _, pathMTU := range []int{1280, 1400, 1450, 1499, 1500} {
+		tries := 0
+		got := searchPathMTU(1280, 1500, func(size int) bool {
+			tries++
+			return size <= pathMTU
+		})
+		if got != pathMTU {
+			t.Errorf("expected path MTU %d, got %d", pathMTU, got)
+		}
+		if tries > 8 {
+			t.Errorf("expected a binary search, got %d tries for %d", tries, pathMTU)
+		}
+	}
+}
+
+func TestProbePathMTUUnreachable(t *testing.T) {
+	defer func(original func(int, string, string, int) error) { pingDF = original }(pingDF)
+	var sizes []int
+	pingDF = func(pid int, source string, destination string, size int) error {
+		sizes = append(sizes, size)
+		return errors.New("i/o timeout")
+	}
+
+	if mtu, err := ProbePathMTU(42, "192.168.9.10", "192.168.9.1", 1500); err == nil {
+		t.Fatalf("expected an error, got path MTU %d", mtu)
+	}
+	// a lost echo is retried, and nothing larger than the minimum is tried
+	if len(sizes) != pathMTUProbeAttempts {
+		t.Errorf("expected %d attempts, got %v", pathMTUProbeAttempts, sizes)
+	}
+	for _, size := range sizes {
+		if size != MinPathMTU {
+			t.Errorf("expected %d byte probes, got %v", MinPathMTU, sizes)
+		}
+	}
+}
+
+// packetConn fails to send packets larger than mtu, like a socket with the
+// Don't Fragment bit set, and answers none
+type packetConn struct {
+	net.PacketConn
+	mtu  int
+	sent []int
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.sent = append(c.sent, len(b))
+	if len(b)+20 > c.mtu {
+		return 0, syscall.EMSGSIZE
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return 0, nil, errors.New("i/o timeout")
+}
+
+func TestEchoDFFailures(t *testing.T) {
+	target := &net.IPAddr{IP: net.ParseIP("192.168.9.1")}
+	conn := &packetConn{mtu: 1400}
+	if err := echoDF(conn, target, 1500); !errors.Is(err, syscall.EMSGSIZE) {
+		t.Errorf("expected the oversized request refused, got %v", err)
+	}
+	if err := echoDF(conn, target, 1400); err == nil {
+		t.Errorf("expected the unanswered request to fail")
+	}
+	// the size counts the IP header, which the kernel adds
+	if len(conn.sent) != 2 || conn.sent[0] != 1480 || conn.sent[1] != 1380 {
+		t.Errorf("expected ICMP messages of 1480 and 1380 bytes, got %v", conn.sent)
+	}
+	if err := echoDF(conn, target, 20); err == nil {
+		t.Errorf("expected a size below the headers refused")
+	}
+}
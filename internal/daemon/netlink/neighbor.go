@@ -0,0 +1,236 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// GratuitousARPCount is how many gratuitous ARPs or unsolicited
+	// neighbor advertisements announce an address, as a single one may be
+	// lost
+	GratuitousARPCount = 3
+	// GratuitousARPInterval is the time between the announcements
+	GratuitousARPInterval = 100 * time.Millisecond
+)
+
+// StaticNeighbor is a permanent neighbor entry of the internal or external
+// interface of a container
+type StaticNeighbor struct {
+	IP       string
+	MAC      string
+	Internal bool
+}
+
+// SetStaticNeighbors2Container replaces the static neighbors applied to the
+// container with the given ones. Entries are replaced rather than added, so
+// an entry learnt for the address before is overwritten.
+func SetStaticNeighbors2Container(containerPid int, applied []StaticNeighbor, neighbors []StaticNeighbor) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	wanted := map[StaticNeighbor]bool{}
+	for _, neighbor := range neighbors {
+		wanted[neighbor] = true
+	}
+	for _, neighbor := range applied {
+		if wanted[neighbor] {
+			continue
+		}
+		neigh, err := staticNeigh(targetNetlinkHandle, neighbor)
+		if err != nil {
+			return err
+		}
+		if err := targetNetlinkHandle.NeighDel(neigh); err != nil && err != unix.ENOENT {
+			klog.ErrorS(err, "NeighDel failed", "ip", neighbor.IP, "mac", neighbor.MAC)
+			return err
+		}
+	}
+	for _, neighbor := range neighbors {
+		neigh, err := staticNeigh(targetNetlinkHandle, neighbor)
+		if err != nil {
+			return err
+		}
+		if err := targetNetlinkHandle.NeighSet(neigh); err != nil {
+			klog.ErrorS(err, "NeighSet failed", "ip", neighbor.IP, "mac", neighbor.MAC)
+			return err
+		}
+	}
+	return nil
+}
+
+func staticNeigh(handle *remoteNetlink.Handle, neighbor StaticNeighbor) (*remoteNetlink.Neigh, error) {
+	interfaceName := DefaultExternalContainerInterface
+	if neighbor.Internal {
+		interfaceName = DefaultInternalContainerInterface
+	}
+	link, err := handle.LinkByName(interfaceName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", interfaceName)
+		return nil, err
+	}
+	ip := net.ParseIP(neighbor.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid neighbor address %q", neighbor.IP)
+	}
+	mac, err := net.ParseMAC(neighbor.MAC)
+	if err != nil {
+		return nil, err
+	}
+	family := remoteNetlink.FAMILY_V6
+	if ip.To4() != nil {
+		family = remoteNetlink.FAMILY_V4
+	}
+	return &remoteNetlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       family,
+		State:        remoteNetlink.NUD_PERMANENT,
+		IP:           ip,
+		HardwareAddr: mac,
+	}, nil
+}
+
+// AnnounceAddress2Container has the upstream devices of the external or
+// internal interface of the container learn its MAC for the address at once,
+// with gratuitous ARPs for an IPv4 address and unsolicited neighbor
+// advertisements for an IPv6 one. The address is taken with or without its
+// prefix length.
+func AnnounceAddress2Container(containerPid int, address string, isInternal bool) error {
+	ns := GetNsHandle(CrioType(containerPid))
+	if !ns.IsOpen() {
+		return fmt.Errorf("no network namespace of pid %d", containerPid)
+	}
+	defer ns.Close()
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+	}
+	interfaceName := DefaultExternalContainerInterface
+	if isInternal {
+		interfaceName = DefaultInternalContainerInterface
+	}
+
+	// sockets stay in the namespace they are opened in
+	var send func() error
+	var closeSocket func() error
+	if err := RunInNs(ns, func() error {
+		link, err := net.InterfaceByName(interfaceName)
+		if err != nil {
+			return err
+		}
+		if ip.To4() != nil {
+			send, closeSocket, err = gratuitousARPSender(link, ip)
+		} else {
+			send, closeSocket, err = unsolicitedNASender(link, ip)
+		}
+		return err
+	}); err != nil {
+		klog.ErrorS(err, "Opening announcement socket failed", "interfaceName", interfaceName, "address", address)
+		return err
+	}
+	defer closeSocket()
+
+	for i := 0; i < GratuitousARPCount; i++ {
+		if i > 0 {
+			time.Sleep(GratuitousARPInterval)
+		}
+		if err := send(); err != nil {
+			klog.ErrorS(err, "Announcing address failed", "interfaceName", interfaceName, "address", address)
+			return err
+		}
+	}
+	klog.InfoS("Address announced", "interfaceName", interfaceName, "address", ip)
+	return nil
+}
+
+func gratuitousARPSender(link *net.Interface, ip net.IP) (func() error, func() error, error) {
+	protocol := htons(unix.ETH_P_ARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(protocol))
+	if err != nil {
+		return nil, nil, err
+	}
+	broadcast := &unix.SockaddrLinklayer{Protocol: protocol, Ifindex: link.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	packet := GratuitousARP(link.HardwareAddr, ip)
+	send := func() error { return unix.Sendto(fd, packet, 0, broadcast) }
+	return send, func() error { return unix.Close(fd) }, nil
+}
+
+func unsolicitedNASender(link *net.Interface, ip net.IP) (func() error, func() error, error) {
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, nil, err
+	}
+	// neighbor discovery messages are dropped unless sent with a hop limit
+	// of 255
+	if err := conn.IPv6PacketConn().SetMulticastHopLimit(255); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := conn.IPv6PacketConn().SetMulticastInterface(link); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	message := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborAdvertisement,
+		Body: &icmp.RawBody{Data: UnsolicitedNeighborAdvertisement(link.HardwareAddr, ip)},
+	}
+	// the kernel fills in the checksum of ICMPv6 sockets
+	packet, err := message.Marshal(nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	allNodes := &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: link.Name}
+	send := func() error {
+		_, err := conn.WriteTo(packet, allNodes)
+		return err
+	}
+	return send, conn.Close, nil
+}
+
+// GratuitousARP returns the ARP request announcing the IPv4 address at the
+// MAC, asking for the address itself.
+func GratuitousARP(mac net.HardwareAddr, ip net.IP) []byte {
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:], 1)      // Ethernet
+	binary.BigEndian.PutUint16(packet[2:], 0x0800) // IPv4
+	packet[4], packet[5] = 6, 4
+	binary.BigEndian.PutUint16(packet[6:], 1) // request
+	copy(packet[8:14], mac)
+	copy(packet[14:18], ip.To4())
+	copy(packet[24:28], ip.To4())
+	return packet
+}
+
+// UnsolicitedNeighborAdvertisement returns the body of the neighbor
+// advertisement announcing the IPv6 address at the MAC, overriding the
+// entries of the neighbors.
+func UnsolicitedNeighborAdvertisement(mac net.HardwareAddr, ip net.IP) []byte {
+	body := make([]byte, 4+16+8)
+	body[0] = 0x20 // override
+	copy(body[4:20], ip.To16())
+	body[20], body[21] = 2, 1 // target link-layer address, 8 bytes
+	copy(body[22:28], mac)
+	return body
+}
+
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
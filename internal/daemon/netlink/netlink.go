@@ -2,9 +2,11 @@ package netlink
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"syscall"
 
 	remoteNetlink "github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
@@ -76,7 +78,9 @@ func Initialize(cfg *Config) error {
 		return err
 	}
 
-	var defaultGW net.IP = getDefaultGW()
+	var defaultGW net.IP = getDefaultGW(remoteNetlink.FAMILY_V4)
+	// moving the addresses of the node drops its IPv6 default route as well
+	var defaultGW6 net.IP = getDefaultGW(remoteNetlink.FAMILY_V6)
 
 	if err := initInternalInterface(rootNetlinkHandle, cfg); err != nil {
 		klog.ErrorS(err, "Initializing failed while setting InternalInterface")
@@ -86,10 +90,18 @@ func Initialize(cfg *Config) error {
 		klog.ErrorS(err, "Initializing failed while setting InternalInterface")
 	}
 
+	if defaultGW6 != nil {
+		if err := setDefaultGW(rootNetlinkHandle, remoteNetlink.FAMILY_V6, defaultGW6); err != nil {
+			klog.ErrorS(err, "setDefaultGW failed", "gw", defaultGW6)
+		} else {
+			klog.InfoS("setDefaultGW done", "gw", defaultGW6)
+		}
+	}
+
 	if defaultGW == nil {
 		return nil
 	} else {
-		if err := setDefaultGW(rootNetlinkHandle, defaultGW); err != nil {
+		if err := setDefaultGW(rootNetlinkHandle, remoteNetlink.FAMILY_V4, defaultGW); err != nil {
 			klog.ErrorS(err, "setDefaultGW failed", "gw", defaultGW)
 			return err
 		} else {
@@ -190,7 +202,7 @@ func Clear(cfg *Config) error {
 		}
 	}
 
-	if err := setDefaultGW(rootNetlinkHandle, originSnapshot.defaultGW); err != nil {
+	if err := setDefaultGW(rootNetlinkHandle, remoteNetlink.FAMILY_V4, originSnapshot.defaultGW); err != nil {
 		klog.ErrorS(err, "setDefaultGW failed", "gw", originSnapshot.defaultGW)
 		return err
 	}
@@ -399,8 +411,8 @@ func initExternalInterface(rootNetlinkHandle *remoteNetlink.Handle, cfg *Config)
 	return nil
 }
 
-func getDefaultGW() net.IP {
-	routes, _ := remoteNetlink.RouteListFiltered(remoteNetlink.FAMILY_V4, &remoteNetlink.Route{
+func getDefaultGW(family int) net.IP {
+	routes, _ := remoteNetlink.RouteListFiltered(family, &remoteNetlink.Route{
 		Dst: nil,
 	}, remoteNetlink.RT_FILTER_DST)
 	if len(routes) == 0 {
@@ -409,8 +421,8 @@ func getDefaultGW() net.IP {
 	return routes[0].Gw
 }
 
-func setDefaultGW(rootNetlinkHandle *remoteNetlink.Handle, gw net.IP) error {
-	routes, _ := remoteNetlink.RouteListFiltered(remoteNetlink.FAMILY_V4, &remoteNetlink.Route{
+func setDefaultGW(rootNetlinkHandle *remoteNetlink.Handle, family int, gw net.IP) error {
+	routes, _ := remoteNetlink.RouteListFiltered(family, &remoteNetlink.Route{
 		Dst: nil,
 	}, remoteNetlink.RT_FILTER_DST)
 	for _, route := range routes {
@@ -501,7 +513,7 @@ func clearLink(rootNetlinkHandle *remoteNetlink.Handle, linkName string) error {
 	return nil
 }
 
-func SetRouteRule2Container(containerPid int, markNumber int, tableNumber int) error {
+func SetRouteRule2Container(containerPid int, family int, markNumber int, tableNumber int) error {
 	var targetNetlinkHandle *remoteNetlink.Handle
 
 	if netlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid))); err != nil {
@@ -512,10 +524,11 @@ func SetRouteRule2Container(containerPid int, markNumber int, tableNumber int) e
 	}
 
 	rule := remoteNetlink.NewRule()
+	rule.Family = family
 	rule.Mark = markNumber
 	rule.Table = tableNumber
 
-	if ruleList, err := targetNetlinkHandle.RuleList(0); err != nil {
+	if ruleList, err := targetNetlinkHandle.RuleList(family); err != nil {
 		klog.Error(err)
 	} else {
 		for _, v := range ruleList {
@@ -534,7 +547,9 @@ func SetRouteRule2Container(containerPid int, markNumber int, tableNumber int) e
 	return nil
 }
 
-func SetDefaultRoute2Container(containerPid int, gwIP string, tableNum int) error {
+// SetDefaultRoute2Container replaces the default route of the family in the
+// table with one via the gateway, or only removes it if no gateway is given.
+func SetDefaultRoute2Container(containerPid int, family int, gwIP string, tableNum int) error {
 	var targetNetlinkHandle *remoteNetlink.Handle
 	var err error
 	// var newinterfaceName string
@@ -544,7 +559,7 @@ func SetDefaultRoute2Container(containerPid int, gwIP string, tableNum int) erro
 		return err
 	}
 
-	routes, _ := targetNetlinkHandle.RouteListFiltered(remoteNetlink.FAMILY_V4, &remoteNetlink.Route{
+	routes, _ := targetNetlinkHandle.RouteListFiltered(family, &remoteNetlink.Route{
 		Table: tableNum,
 		Dst:   nil,
 	}, remoteNetlink.RT_FILTER_DST|remoteNetlink.RT_FILTER_TABLE)
@@ -554,11 +569,25 @@ func SetDefaultRoute2Container(containerPid int, gwIP string, tableNum int) erro
 		}
 	}
 
-	if err := targetNetlinkHandle.RouteAdd(&remoteNetlink.Route{
+	if gwIP == "" {
+		return nil
+	}
+
+	route := &remoteNetlink.Route{
 		Table: tableNum,
 		Dst:   nil,
 		Gw:    net.ParseIP(gwIP),
-	}); err != nil {
+	}
+	// a link-local gateway is only reachable through a given link
+	if route.Gw.IsLinkLocalUnicast() {
+		link, err := targetNetlinkHandle.LinkByName(DefaultExternalContainerInterface)
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", DefaultExternalContainerInterface)
+			return err
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+	if err := targetNetlinkHandle.RouteAdd(route); err != nil {
 		klog.Error(err)
 	}
 
@@ -673,7 +702,8 @@ func SetIPaddress2Container(containerPid int, ip string, netmask string, isInter
 		vethPeerIntf = link
 	}
 
-	if l, err := targetNetlinkHandle.AddrList(vethPeerIntf, remoteNetlink.FAMILY_ALL); err != nil {
+	// IPv6 addresses are set apart by SetIPv6Address2Container
+	if l, err := targetNetlinkHandle.AddrList(vethPeerIntf, remoteNetlink.FAMILY_V4); err != nil {
 		klog.ErrorS(err, "Listing Address failed", "interfaceName", vethPeerIntf.Attrs().Name)
 		return err
 	} else {
@@ -870,6 +900,12 @@ func setLink(netlinkHandle *remoteNetlink.Handle, link remoteNetlink.Link) (remo
 	return link, nil
 }
 
+// isExist tells whether adding failed because the object is there already,
+// as when a container is attached again after its applied spec was forgotten.
+func isExist(err error) bool {
+	return errors.Is(err, syscall.EEXIST)
+}
+
 func setLinkUp(netlinkHandle *remoteNetlink.Handle, link remoteNetlink.Link) error {
 	return netlinkHandle.LinkSetUp(link)
 }
@@ -1006,3 +1042,30 @@ func delVlan(rootNetlinkHandle *remoteNetlink.Handle, interfaceName string, vlan
 	}
 	return nil
 }
+
+// CheckBridges returns an error naming the first host bridge of the routers
+// missing or down.
+func CheckBridges(cfg *Config) error {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	bridgeNames := []string{cfg.InternalBridgeName, cfg.ExternalBridgeName}
+	for i, defaultName := range []string{DefaultInternalBridgeName, DefaultExternalBridgeName} {
+		if bridgeNames[i] == "" {
+			bridgeNames[i] = defaultName
+		}
+	}
+	for _, bridgeName := range bridgeNames {
+		link, err := rootNetlinkHandle.LinkByName(bridgeName)
+		if err != nil {
+			return fmt.Errorf("bridge %s: %v", bridgeName, err)
+		}
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("bridge %s is down", bridgeName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package netlink
+
+import (
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// PolicyTable is a secondary routing table of a container and the rules
+// selecting the traffic routed with it
+type PolicyTable struct {
+	ID     int
+	Routes []PolicyRoute
+	Rules  []PolicyRule
+}
+
+// PolicyRoute is a route of a PolicyTable, the default route if Dst is empty
+type PolicyRoute struct {
+	Dst string
+	Gw  string
+}
+
+// PolicyRule selects traffic by source network and firewall mark, each
+// ignored if left empty. Priority is chosen by the kernel if 0.
+type PolicyRule struct {
+	Src      string
+	Mark     int
+	Priority int
+}
+
+// SetPolicyRouting2Container replaces the routing tables applied before, and
+// their rules, in the container with the given ones.
+func SetPolicyRouting2Container(containerPid int, applied []PolicyTable, tables []PolicyTable) error {
+	var targetNetlinkHandle *remoteNetlink.Handle
+	var err error
+
+	if targetNetlinkHandle, err = GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid))); err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	for _, table := range applied {
+		for _, rule := range table.Rules {
+			if err := targetNetlinkHandle.RuleDel(policyRule(table.ID, rule)); err != nil {
+				klog.ErrorS(err, "RuleDel failed", "table", table.ID)
+			}
+		}
+		routes, err := targetNetlinkHandle.RouteListFiltered(remoteNetlink.FAMILY_V4, &remoteNetlink.Route{Table: table.ID}, remoteNetlink.RT_FILTER_TABLE)
+		if err != nil {
+			klog.ErrorS(err, "RouteListFiltered failed", "table", table.ID)
+			return err
+		}
+		for i := range routes {
+			if err := targetNetlinkHandle.RouteDel(&routes[i]); err != nil {
+				klog.ErrorS(err, "RouteDel failed", "table", table.ID)
+			}
+		}
+	}
+
+	for _, table := range tables {
+		for _, route := range table.Routes {
+			var dst *net.IPNet
+			if route.Dst != "" {
+				if _, dst, err = net.ParseCIDR(route.Dst); err != nil {
+					return err
+				}
+			}
+			if err := targetNetlinkHandle.RouteReplace(&remoteNetlink.Route{
+				Table: table.ID,
+				Dst:   dst,
+				Gw:    net.ParseIP(route.Gw),
+			}); err != nil {
+				klog.ErrorS(err, "RouteReplace failed", "table", table.ID, "dst", route.Dst, "gw", route.Gw)
+				return err
+			}
+		}
+		for _, rule := range table.Rules {
+			if err := targetNetlinkHandle.RuleAdd(policyRule(table.ID, rule)); err != nil && !isExist(err) {
+				klog.ErrorS(err, "RuleAdd failed", "table", table.ID, "src", rule.Src, "mark", rule.Mark)
+				return err
+			}
+		}
+		klog.InfoS("Policy routing table set", "table", table.ID)
+	}
+	return nil
+}
+
+func policyRule(table int, rule PolicyRule) *remoteNetlink.Rule {
+	netlinkRule := remoteNetlink.NewRule()
+	netlinkRule.Table = table
+	if rule.Src != "" {
+		_, netlinkRule.Src, _ = net.ParseCIDR(rule.Src)
+	}
+	if rule.Mark != 0 {
+		netlinkRule.Mark = rule.Mark
+	}
+	if rule.Priority != 0 {
+		netlinkRule.Priority = rule.Priority
+	}
+	return netlinkRule
+}
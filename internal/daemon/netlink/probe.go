@@ -0,0 +1,167 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// probeInterfacePrefix names the host side of a probe endpoint
+	probeInterfacePrefix = "prb"
+	// probeNsPrefix names the network namespace of a probe endpoint
+	probeNsPrefix = "vrprobe-"
+	// ProbeEndpointInterfaceName is the interface of a probe endpoint
+	ProbeEndpointInterfaceName = "ethprobe"
+)
+
+// SetProbeEndpoint creates a network namespace standing for a host of the
+// internal network of a router: its interface is on the internal bridge in
+// the vlan of the router, and its default route goes through the router.
+// The namespace is named after the given name, the interface after the
+// first 7 characters of it, like the router interfaces.
+func SetProbeEndpoint(name string, vlan int, ip string, netmask string, routerIP string, cfg *Config) (netns.NsHandle, error) {
+	ClearProbeEndpoint(name)
+
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Setting probe endpoint failed while getting rootNetlinkHandle")
+		return netns.None(), err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	probeNs, err := newNamedNs(probeNsPrefix + name)
+	if err != nil {
+		klog.ErrorS(err, "Creating probe namespace failed", "name", name)
+		return netns.None(), err
+	}
+	fail := func(err error) (netns.NsHandle, error) {
+		probeNs.Close()
+		ClearProbeEndpoint(name)
+		return netns.None(), err
+	}
+
+	interfaceName := probeInterfacePrefix + name[:7]
+	vethIntf, err := setLink(rootNetlinkHandle, &remoteNetlink.Veth{
+		LinkAttrs: remoteNetlink.LinkAttrs{Name: interfaceName},
+		PeerName:  ProbeEndpointInterfaceName,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	vethPeerIntf, err := rootNetlinkHandle.LinkByName(ProbeEndpointInterfaceName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", ProbeEndpointInterfaceName)
+		return fail(err)
+	}
+	if err := rootNetlinkHandle.LinkSetNsFd(vethPeerIntf, int(probeNs)); err != nil {
+		klog.ErrorS(err, "Setting Veth interface to probe NS failed", "interfaceName", ProbeEndpointInterfaceName)
+		return fail(err)
+	}
+
+	bridgeIntf, err := rootNetlinkHandle.LinkByName(cfg.InternalBridgeName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", cfg.InternalBridgeName)
+		return fail(err)
+	}
+	if err := attachInterface2Bridge(rootNetlinkHandle, vethIntf, bridgeIntf); err != nil {
+		klog.ErrorS(err, "attach failed", "interfaceName", interfaceName, "bridgeName", cfg.InternalBridgeName)
+		return fail(err)
+	}
+	if vlan != 0 {
+		if err := addVlan(rootNetlinkHandle, interfaceName, vlan, true, true); err != nil {
+			return fail(err)
+		}
+	}
+	if err := setLinkUp(rootNetlinkHandle, vethIntf); err != nil {
+		return fail(err)
+	}
+
+	probeNetlinkHandle, err := GetTargetNetlinkHandle(probeNs)
+	if err != nil {
+		return fail(err)
+	}
+	defer probeNetlinkHandle.Delete()
+	endpointIntf, err := probeNetlinkHandle.LinkByName(ProbeEndpointInterfaceName)
+	if err != nil {
+		return fail(err)
+	}
+	ipMask, _ := net.IPMask(net.ParseIP(netmask).To4()).Size()
+	addr, err := remoteNetlink.ParseAddr(ip + "/" + strconv.Itoa(ipMask))
+	if err != nil {
+		klog.ErrorS(err, "ParseAddr is failed", "addr", ip)
+		return fail(err)
+	}
+	if err := probeNetlinkHandle.AddrAdd(endpointIntf, addr); err != nil {
+		return fail(err)
+	}
+	if err := setLinkUp(probeNetlinkHandle, endpointIntf); err != nil {
+		return fail(err)
+	}
+	if lo, err := probeNetlinkHandle.LinkByName("lo"); err == nil {
+		setLinkUp(probeNetlinkHandle, lo)
+	}
+	if err := probeNetlinkHandle.RouteAdd(&remoteNetlink.Route{Gw: net.ParseIP(routerIP)}); err != nil {
+		klog.ErrorS(err, "Setting default route of probe endpoint failed", "gateway", routerIP)
+		return fail(err)
+	}
+
+	klog.InfoS("Probe endpoint set", "name", name, "addr", addr.IPNet.String(), "gateway", routerIP)
+	return probeNs, nil
+}
+
+// ClearProbeEndpoint removes the network namespace of the probe endpoint,
+// which takes its interfaces with it.
+func ClearProbeEndpoint(name string) error {
+	err := netns.DeleteNamed(probeNsPrefix + name)
+	// the host side is gone with its peer already, unless it was left
+	// behind by a failed setup
+	if rootNetlinkHandle, err := GetRootNetlinkHandle(); err == nil {
+		defer rootNetlinkHandle.Delete()
+		clearLink(rootNetlinkHandle, probeInterfacePrefix+name[:7])
+	}
+	return err
+}
+
+// RunInNs runs fn with the calling goroutine in the network namespace.
+// Sockets opened by fn stay in the namespace.
+func RunInNs(ns netns.NsHandle, fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origin.Close()
+	if err := netns.Set(ns); err != nil {
+		return err
+	}
+	defer netns.Set(origin)
+	return fn()
+}
+
+// newNamedNs creates a named network namespace, keeping the calling
+// goroutine in its own namespace.
+func newNamedNs(name string) (netns.NsHandle, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return netns.None(), err
+	}
+	defer origin.Close()
+	defer netns.Set(origin)
+
+	ns, err := netns.NewNamed(name)
+	if err != nil {
+		return netns.None(), fmt.Errorf("creating network namespace %s: %v", name, err)
+	}
+	return ns, nil
+}
@@ -0,0 +1,266 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// QoSIfbInterface is the IFB device the traffic received on the internal
+	// interface is redirected through to be shaped
+	QoSIfbInterface = "ifbint"
+
+	qosRootClass    uint16 = 1
+	qosDefaultClass uint16 = 2
+	qosFirstClass   uint16 = 10
+	// qosMinRate is the rate in bits per second left to the traffic of no
+	// class when the classes take all of the limit
+	qosMinRate uint64 = 8000
+
+	ethPAll uint16 = 0x0003
+	ethPIP  uint16 = 0x0800
+)
+
+// QoSLimit is a rate limit in bits per second shared by its classes. Burst
+// is in bytes, chosen from the rate if 0.
+type QoSLimit struct {
+	Rate    uint64
+	Burst   uint32
+	Classes []QoSClass
+}
+
+// QoSClass is a share of a QoSLimit guaranteed to an IPv4 network. Ceil is
+// the rate of the limit if 0.
+type QoSClass struct {
+	CIDR string
+	Rate uint64
+	Ceil uint64
+}
+
+// SetQoS2Container shapes the traffic the container sends on its internal
+// interface with the ingress limit, and the traffic it receives there with
+// the egress limit, by redirecting it through an IFB device. Classes match
+// the destination and the source address respectively. A nil limit removes
+// the shaping of its direction.
+func SetQoS2Container(containerPid int, ingress *QoSLimit, egress *QoSLimit) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	link, err := targetNetlinkHandle.LinkByName(DefaultInternalContainerInterface)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", DefaultInternalContainerInterface)
+		return err
+	}
+
+	if err := setShaping(targetNetlinkHandle, link, ingress, true); err != nil {
+		return err
+	}
+
+	ifb, err := setIfbRedirect(targetNetlinkHandle, link, egress != nil)
+	if err != nil {
+		return err
+	}
+	if ifb == nil {
+		return nil
+	}
+	return setShaping(targetNetlinkHandle, ifb, egress, false)
+}
+
+// setShaping replaces the HTB qdisc of the link, and its classes, with those
+// of the limit
+func setShaping(handle *remoteNetlink.Handle, link remoteNetlink.Link, limit *QoSLimit, byDestination bool) error {
+	name := link.Attrs().Name
+	qdiscs, err := handle.QdiscList(link)
+	if err != nil {
+		klog.ErrorS(err, "QdiscList failed", "interfaceName", name)
+		return err
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent == remoteNetlink.HANDLE_ROOT && qdisc.Type() == "htb" {
+			if err := handle.QdiscDel(qdisc); err != nil {
+				klog.ErrorS(err, "QdiscDel failed", "interfaceName", name)
+				return err
+			}
+		}
+	}
+	if limit == nil {
+		return nil
+	}
+
+	index := link.Attrs().Index
+	root := remoteNetlink.MakeHandle(qosRootClass, 0)
+	htb := remoteNetlink.NewHtb(remoteNetlink.QdiscAttrs{
+		LinkIndex: index,
+		Handle:    root,
+		Parent:    remoteNetlink.HANDLE_ROOT,
+	})
+	htb.Defcls = uint32(qosDefaultClass)
+	if err := handle.QdiscAdd(htb); err != nil {
+		klog.ErrorS(err, "QdiscAdd failed", "interfaceName", name, "qdisc", "htb")
+		return err
+	}
+
+	parent := remoteNetlink.MakeHandle(qosRootClass, qosRootClass)
+	if err := handle.ClassAdd(remoteNetlink.NewHtbClass(
+		remoteNetlink.ClassAttrs{LinkIndex: index, Parent: root, Handle: parent},
+		remoteNetlink.HtbClassAttrs{Rate: limit.Rate, Ceil: limit.Rate, Buffer: limit.Burst, Cbuffer: limit.Burst},
+	)); err != nil {
+		klog.ErrorS(err, "ClassAdd failed", "interfaceName", name, "rate", limit.Rate)
+		return err
+	}
+
+	defaultRate := limit.Rate
+	for _, class := range limit.Classes {
+		if class.Rate >= defaultRate {
+			defaultRate = 0
+			break
+		}
+		defaultRate -= class.Rate
+	}
+	if defaultRate < qosMinRate {
+		defaultRate = qosMinRate
+	}
+	if err := addLeafClass(handle, index, parent, qosDefaultClass, defaultRate, limit.Rate); err != nil {
+		klog.ErrorS(err, "ClassAdd failed", "interfaceName", name, "class", "default")
+		return err
+	}
+
+	for i, class := range limit.Classes {
+		minor := qosFirstClass + uint16(i)
+		ceil := class.Ceil
+		if ceil == 0 {
+			ceil = limit.Rate
+		}
+		_, network, err := net.ParseCIDR(class.CIDR)
+		if err == nil && network.IP.To4() == nil {
+			err = fmt.Errorf("%s is not an IPv4 network", class.CIDR)
+		}
+		if err != nil {
+			klog.ErrorS(err, "Invalid QoS class", "cidr", class.CIDR)
+			return err
+		}
+		if err := addLeafClass(handle, index, parent, minor, class.Rate, ceil); err != nil {
+			klog.ErrorS(err, "ClassAdd failed", "interfaceName", name, "cidr", class.CIDR)
+			return err
+		}
+		// the source address is at offset 12 of the IPv4 header, the
+		// destination at 16
+		var offset int32 = 12
+		if byDestination {
+			offset = 16
+		}
+		if err := handle.FilterAdd(&remoteNetlink.U32{
+			FilterAttrs: remoteNetlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    root,
+				Priority:  1,
+				Protocol:  ethPIP,
+			},
+			ClassId: remoteNetlink.MakeHandle(qosRootClass, minor),
+			Sel: &remoteNetlink.TcU32Sel{
+				Flags: remoteNetlink.TC_U32_TERMINAL,
+				Keys: []remoteNetlink.TcU32Key{{
+					Mask: binary.BigEndian.Uint32(network.Mask),
+					Val:  binary.BigEndian.Uint32(network.IP.To4()),
+					Off:  offset,
+				}},
+			},
+		}); err != nil {
+			klog.ErrorS(err, "FilterAdd failed", "interfaceName", name, "cidr", class.CIDR)
+			return err
+		}
+	}
+	klog.InfoS("Shaping set", "interfaceName", name, "rate", limit.Rate, "classes", len(limit.Classes))
+	return nil
+}
+
+// addLeafClass adds an HTB class queueing its traffic fairly by flow
+func addLeafClass(handle *remoteNetlink.Handle, index int, parent uint32, minor uint16, rate uint64, ceil uint64) error {
+	classHandle := remoteNetlink.MakeHandle(qosRootClass, minor)
+	if err := handle.ClassAdd(remoteNetlink.NewHtbClass(
+		remoteNetlink.ClassAttrs{LinkIndex: index, Parent: parent, Handle: classHandle},
+		remoteNetlink.HtbClassAttrs{Rate: rate, Ceil: ceil},
+	)); err != nil {
+		return err
+	}
+	return handle.QdiscAdd(remoteNetlink.NewFqCodel(remoteNetlink.QdiscAttrs{
+		LinkIndex: index,
+		Handle:    remoteNetlink.MakeHandle(minor, 0),
+		Parent:    classHandle,
+	}))
+}
+
+// setIfbRedirect redirects the traffic received on the link through the IFB
+// device, returned, or removes the redirect and the device
+func setIfbRedirect(handle *remoteNetlink.Handle, link remoteNetlink.Link, enabled bool) (remoteNetlink.Link, error) {
+	name := link.Attrs().Name
+	qdiscs, err := handle.QdiscList(link)
+	if err != nil {
+		klog.ErrorS(err, "QdiscList failed", "interfaceName", name)
+		return nil, err
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() == "ingress" {
+			if err := handle.QdiscDel(qdisc); err != nil {
+				klog.ErrorS(err, "QdiscDel failed", "interfaceName", name)
+				return nil, err
+			}
+		}
+	}
+	ifb, err := handle.LinkByName(QoSIfbInterface)
+	if !enabled {
+		if err == nil {
+			if err := handle.LinkDel(ifb); err != nil {
+				klog.ErrorS(err, "LinkDel failed", "interfaceName", QoSIfbInterface)
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	if err != nil {
+		if err := handle.LinkAdd(&remoteNetlink.Ifb{LinkAttrs: remoteNetlink.LinkAttrs{Name: QoSIfbInterface}}); err != nil {
+			klog.ErrorS(err, "LinkAdd failed", "interfaceName", QoSIfbInterface)
+			return nil, err
+		}
+		if ifb, err = handle.LinkByName(QoSIfbInterface); err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", QoSIfbInterface)
+			return nil, err
+		}
+	}
+	if err := setLinkUp(handle, ifb); err != nil {
+		return nil, err
+	}
+
+	index := link.Attrs().Index
+	ingress := remoteNetlink.MakeHandle(0xffff, 0)
+	if err := handle.QdiscAdd(&remoteNetlink.Ingress{QdiscAttrs: remoteNetlink.QdiscAttrs{
+		LinkIndex: index,
+		Handle:    ingress,
+		Parent:    remoteNetlink.HANDLE_INGRESS,
+	}}); err != nil {
+		klog.ErrorS(err, "QdiscAdd failed", "interfaceName", name, "qdisc", "ingress")
+		return nil, err
+	}
+	if err := handle.FilterAdd(&remoteNetlink.U32{
+		FilterAttrs: remoteNetlink.FilterAttrs{
+			LinkIndex: index,
+			Parent:    ingress,
+			Priority:  1,
+			Protocol:  ethPAll,
+		},
+		Actions: []remoteNetlink.Action{remoteNetlink.NewMirredAction(ifb.Attrs().Index)},
+	}); err != nil {
+		klog.ErrorS(err, "FilterAdd failed", "interfaceName", name, "redirect", QoSIfbInterface)
+		return nil, err
+	}
+	return ifb, nil
+}
@@ -0,0 +1,252 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// LinkState is the state of a host link the routers depend on
+type LinkState struct {
+	Name string
+	// Master is the bridge the link is a port of, none if empty
+	Master string
+	Up     bool
+	// Container is the name the interfaces of the router container the
+	// link is the host end of are named after, empty for the links of the
+	// node
+	Container string
+}
+
+// bridgeNames returns the internal and external bridges of the config
+func bridgeNames(cfg *Config) (string, string) {
+	internalBridge, externalBridge := cfg.InternalBridgeName, cfg.ExternalBridgeName
+	if internalBridge == "" {
+		internalBridge = DefaultInternalBridgeName
+	}
+	if externalBridge == "" {
+		externalBridge = DefaultExternalBridgeName
+	}
+	return internalBridge, externalBridge
+}
+
+// DesiredHostLinks returns the host links Initialize and the attached router
+// containers set up: the bridges, the interfaces of the node and the veths
+// their addresses are moved to, and the host ends of the containers given by
+// the name their interfaces are named after. Containers of externalVFs have an
+// SR-IOV virtual function as their external interface, which has no host end.
+func DesiredHostLinks(cfg *Config, containers []string, externalVFs map[string]bool) []LinkState {
+	internalBridge, externalBridge := bridgeNames(cfg)
+	links := []LinkState{
+		{Name: internalBridge, Up: true},
+		{Name: externalBridge, Up: true},
+	}
+	for _, node := range []struct{ origin, veth, bridge string }{
+		{cfg.OriginInternalInterfaceName, cfg.NewInternalInterfaceName, internalBridge},
+		{cfg.OriginExternalInterfaceName, cfg.NewExternalInterfaceName, externalBridge},
+	} {
+		if node.origin == "" || node.veth == "" {
+			continue
+		}
+		links = append(links,
+			LinkState{Name: node.origin, Master: node.bridge, Up: true},
+			LinkState{Name: node.veth + "0", Master: node.bridge, Up: true},
+			LinkState{Name: node.veth + "1", Up: true})
+	}
+	for _, container := range containers {
+		links = append(links, LinkState{Name: "int" + container, Master: internalBridge, Up: true, Container: container})
+		if !externalVFs[container] {
+			links = append(links, LinkState{Name: "ext" + container, Master: externalBridge, Up: true, Container: container})
+		}
+	}
+	return links
+}
+
+// ActualHostLinks returns the state of the links of the host by name.
+func ActualHostLinks() (map[string]LinkState, error) {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return nil, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	links, err := rootNetlinkHandle.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	actual := map[string]LinkState{}
+	for _, link := range links {
+		actual[link.Attrs().Name] = LinkState{
+			Name:   link.Attrs().Name,
+			Master: names[link.Attrs().MasterIndex],
+			Up:     link.Attrs().Flags&net.FlagUp != 0,
+		}
+	}
+	return actual, nil
+}
+
+// DiffHostLinks returns the desired links missing from the actual ones, and
+// those in another state.
+func DiffHostLinks(desired []LinkState, actual map[string]LinkState) ([]LinkState, []LinkState) {
+	var missing, drifted []LinkState
+	for _, link := range desired {
+		state, exist := actual[link.Name]
+		switch {
+		case !exist:
+			missing = append(missing, link)
+		case state.Master != link.Master || state.Up != link.Up:
+			drifted = append(drifted, link)
+		}
+	}
+	return missing, drifted
+}
+
+// ReconcileHost converges the host links to those Initialize and the
+// attached router containers set up. Bridges and links of the node are set up
+// again, and drifted links put back on their bridge and up. It returns the
+// containers to be attached again: those whose host ends went missing, which
+// only attaching them again recreates, or lost their vlans while off their
+// bridge. All of them if a link of the node was touched, as the vlans of the
+// routers go with the bridges and the node interfaces.
+func ReconcileHost(cfg *Config, containers []string, externalVFs map[string]bool) ([]string, error) {
+	actual, err := ActualHostLinks()
+	if err != nil {
+		return nil, err
+	}
+	missing, drifted := DiffHostLinks(DesiredHostLinks(cfg, containers, externalVFs), actual)
+	if len(missing) == 0 && len(drifted) == 0 {
+		return nil, nil
+	}
+
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return nil, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	broken := map[string]bool{}
+	nodeTouched := false
+	for _, link := range missing {
+		klog.InfoS("Host link missing", "interfaceName", link.Name)
+		if link.Container != "" {
+			broken[link.Container] = true
+			continue
+		}
+		nodeTouched = true
+	}
+	if nodeTouched {
+		// setting up the node again is idempotent, and puts back what is
+		// missing
+		if _, err := setInternalBridge(rootNetlinkHandle, cfg); err != nil {
+			return nil, err
+		}
+		if _, err := setExternalBridge(rootNetlinkHandle, cfg); err != nil {
+			return nil, err
+		}
+		if cfg.OriginInternalInterfaceName != "" && cfg.NewInternalInterfaceName != "" {
+			if err := initInternalInterface(rootNetlinkHandle, cfg); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.OriginExternalInterfaceName != "" && cfg.NewExternalInterfaceName != "" {
+			if err := initExternalInterface(rootNetlinkHandle, cfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, link := range drifted {
+		klog.InfoS("Host link drifted", "interfaceName", link.Name, "master", actual[link.Name].Master, "up", actual[link.Name].Up)
+		if err := convergeLink(rootNetlinkHandle, link); err != nil {
+			return nil, err
+		}
+		if link.Container != "" {
+			broken[link.Container] = true
+		} else if link.Master != actual[link.Name].Master {
+			nodeTouched = true
+		}
+	}
+
+	if nodeTouched {
+		return containers, nil
+	}
+	var reattach []string
+	for _, container := range containers {
+		if broken[container] {
+			reattach = append(reattach, container)
+		}
+	}
+	return reattach, nil
+}
+
+func convergeLink(handle *remoteNetlink.Handle, state LinkState) error {
+	link, err := handle.LinkByName(state.Name)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", state.Name)
+		return err
+	}
+	if state.Master != "" && link.Attrs().MasterIndex == 0 {
+		bridge, err := handle.LinkByName(state.Master)
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", state.Master)
+			return err
+		}
+		if err := attachInterface2Bridge(handle, link, bridge); err != nil {
+			klog.ErrorS(err, "attach failed", "interfaceName", state.Name, "bridgeName", state.Master)
+			return err
+		}
+	}
+	if state.Up && link.Attrs().Flags&net.FlagUp == 0 {
+		if err := setLinkUp(handle, link); err != nil {
+			klog.ErrorS(err, "setLinkUp is failed", "interfaceName", state.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckContainerLinks returns why the interfaces of the container are not as
+// attaching set them up: both up, with the IPv4 address given of each.
+func CheckContainerLinks(containerPid int, internalIP string, externalIP string) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	for _, expected := range []struct{ interfaceName, ip string }{
+		{DefaultInternalContainerInterface, internalIP},
+		{DefaultExternalContainerInterface, externalIP},
+	} {
+		link, err := targetNetlinkHandle.LinkByName(expected.interfaceName)
+		if err != nil {
+			return fmt.Errorf("%s: %v", expected.interfaceName, err)
+		}
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("%s is down", expected.interfaceName)
+		}
+		if expected.ip == "" {
+			continue
+		}
+		addrs, err := targetNetlinkHandle.AddrList(link, remoteNetlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, addr := range addrs {
+			if addr.IP.Equal(net.ParseIP(expected.ip)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s lost address %s", expected.interfaceName, expected.ip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package netlink_test
+
+import (
+	"reflect"
+	"testing"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+func TestDiffHostLinks(t *testing.T) {
+	cfg := &internalNetlink.Config{
+		OriginInternalInterfaceName: "eth1",
+		NewInternalInterfaceName:    "vintl",
+	}
+	desired := internalNetlink.DesiredHostLinks(cfg, []string{"abcdef0"}, nil)
+	actual := map[string]internalNetlink.LinkState{}
+	for _, link := range desired {
+		actual[link.Name] = internalNetlink.LinkState{Name: link.Name, Master: link.Master, Up: link.Up}
+	}
+	if missing, drifted := internalNetlink.DiffHostLinks(desired, actual); len(missing) != 0 || len(drifted) != 0 {
+		t.Fatalf("expected converged links, got missing %v, drifted %v", missing, drifted)
+	}
+
+	delete(actual, "intabcdef0")
+	actual["vintl1"] = internalNetlink.LinkState{Name: "vintl1"}
+	actual["extabcdef0"] = internalNetlink.LinkState{Name: "extabcdef0", Up: true}
+	missing, drifted := internalNetlink.DiffHostLinks(desired, actual)
+	expectedMissing := []internalNetlink.LinkState{
+		{Name: "intabcdef0", Master: internalNetlink.DefaultInternalBridgeName, Up: true, Container: "abcdef0"},
+	}
+	expectedDrifted := []internalNetlink.LinkState{
+		{Name: "vintl1", Up: true},
+		{Name: "extabcdef0", Master: internalNetlink.DefaultExternalBridgeName, Up: true, Container: "abcdef0"},
+	}
+	if !reflect.DeepEqual(missing, expectedMissing) {
+		t.Errorf("expected missing %v, got %v", expectedMissing, missing)
+	}
+	if !reflect.DeepEqual(drifted, expectedDrifted) {
+		t.Errorf("expected drifted %v, got %v", expectedDrifted, drifted)
+	}
+}
+
+func TestDesiredHostLinksOfExternalVFs(t *testing.T) {
+	desired := internalNetlink.DesiredHostLinks(&internalNetlink.Config{}, []string{"abcdef0", "1234567"}, map[string]bool{"1234567": true})
+	names := map[string]bool{}
+	for _, link := range desired {
+		names[link.Name] = true
+	}
+	// the virtual function of the router is no port of the external bridge
+	if !names["extabcdef0"] || !names["int1234567"] || names["ext1234567"] {
+		t.Errorf("expected no host end of the external VF, got %v", desired)
+	}
+}
@@ -0,0 +1,149 @@
+package netlink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// SysfsPCIDevices is where the PCI devices of the node are found
+var SysfsPCIDevices = "/sys/bus/pci/devices"
+
+// VF is how a virtual function is set up on its physical function
+type VF struct {
+	// PCIAddress of the virtual function, such as 0000:03:02.1
+	PCIAddress string
+	// VLAN the virtual function tags and strips, none if 0
+	VLAN int
+	// MAC set on the virtual function, left as it is if nil
+	MAC        net.HardwareAddr
+	SpoofCheck bool
+}
+
+// PCIDeviceEnv returns the environment variable the SR-IOV device plugin
+// tells the PCI addresses of the devices of the resource in, such as
+// PCIDEVICE_INTEL_COM_SRIOV_NETDEVICE for intel.com/sriov_netdevice.
+func PCIDeviceEnv(resourceName string) string {
+	return "PCIDEVICE_" + strings.ToUpper(strings.NewReplacer(".", "_", "/", "_", "-", "_").Replace(resourceName))
+}
+
+// VFOf returns the physical function interface of the virtual function of
+// the PCI address, its index on it and its own interface, empty if it has
+// been moved to another network namespace.
+func VFOf(pciAddress string) (string, int, string, error) {
+	device := filepath.Join(SysfsPCIDevices, pciAddress)
+	pfNames, err := ioutil.ReadDir(filepath.Join(device, "physfn", "net"))
+	if err != nil || len(pfNames) == 0 {
+		return "", 0, "", fmt.Errorf("%s is not a virtual function of a network device: %v", pciAddress, err)
+	}
+	virtfns, err := filepath.Glob(filepath.Join(device, "physfn", "virtfn*"))
+	if err != nil {
+		return "", 0, "", err
+	}
+	index := -1
+	for _, virtfn := range virtfns {
+		target, err := os.Readlink(virtfn)
+		if err != nil || filepath.Base(target) != pciAddress {
+			continue
+		}
+		if index, err = strconv.Atoi(strings.TrimPrefix(filepath.Base(virtfn), "virtfn")); err != nil {
+			return "", 0, "", err
+		}
+		break
+	}
+	if index < 0 {
+		return "", 0, "", fmt.Errorf("%s is none of the virtual functions of %s", pciAddress, pfNames[0].Name())
+	}
+	vfName := ""
+	if vfNames, err := ioutil.ReadDir(filepath.Join(device, "net")); err == nil && len(vfNames) > 0 {
+		vfName = vfNames[0].Name()
+	}
+	return pfNames[0].Name(), index, vfName, nil
+}
+
+// SetVF2Container sets up the virtual function on its physical function and
+// moves its interface into the network namespace of the container as the
+// external interface, in place of the veth of the external bridge. A virtual
+// function already moved is only set up again.
+func SetVF2Container(containerPid int, vf VF) error {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		klog.ErrorS(err, "Setting VF failed while getting rootNetlinkHandle")
+		return err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	pfName, index, vfName, err := VFOf(vf.PCIAddress)
+	if err != nil {
+		return err
+	}
+	pf, err := rootNetlinkHandle.LinkByName(pfName)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", pfName)
+		return err
+	}
+	if err := rootNetlinkHandle.LinkSetVfVlan(pf, index, vf.VLAN); err != nil {
+		klog.ErrorS(err, "LinkSetVfVlan failed", "interfaceName", pfName, "vf", index, "vlan", vf.VLAN)
+		return err
+	}
+	if vf.MAC != nil {
+		if err := rootNetlinkHandle.LinkSetVfHardwareAddr(pf, index, vf.MAC); err != nil {
+			klog.ErrorS(err, "LinkSetVfHardwareAddr failed", "interfaceName", pfName, "vf", index, "mac", vf.MAC.String())
+			return err
+		}
+	}
+	if err := rootNetlinkHandle.LinkSetVfSpoofchk(pf, index, vf.SpoofCheck); err != nil {
+		klog.ErrorS(err, "LinkSetVfSpoofchk failed", "interfaceName", pfName, "vf", index, "spoofCheck", vf.SpoofCheck)
+		return err
+	}
+
+	targetNs := GetNsHandle(CrioType(containerPid))
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(targetNs)
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	link, err := targetNetlinkHandle.LinkByName(DefaultExternalContainerInterface)
+	if err != nil {
+		if vfName == "" {
+			return fmt.Errorf("virtual function %s has no interface to attach", vf.PCIAddress)
+		}
+		vfLink, err := rootNetlinkHandle.LinkByName(vfName)
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", vfName)
+			return err
+		}
+		if err := rootNetlinkHandle.LinkSetNsFd(vfLink, int(targetNs)); err != nil {
+			klog.ErrorS(err, "Setting VF interface to target NS failed", "interfaceName", vfName)
+			return err
+		}
+		if link, err = targetNetlinkHandle.LinkByName(vfName); err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", vfName)
+			return err
+		}
+		if err := targetNetlinkHandle.LinkSetName(link, DefaultExternalContainerInterface); err != nil {
+			klog.ErrorS(err, "Renaming VF interface failed", "interfaceName", vfName)
+			return err
+		}
+	}
+	if vf.MAC != nil && link.Attrs().HardwareAddr.String() != vf.MAC.String() {
+		// drivers only take the MAC of the physical function on a reset
+		if err := targetNetlinkHandle.LinkSetHardwareAddr(link, vf.MAC); err != nil {
+			klog.ErrorS(err, "LinkSetHardwareAddr failed", "interfaceName", DefaultExternalContainerInterface)
+			return err
+		}
+	}
+	if err := setLinkUp(targetNetlinkHandle, link); err != nil {
+		return err
+	}
+	klog.InfoS("VF attached", "pciAddress", vf.PCIAddress, "pf", pfName, "vf", index)
+	return nil
+}
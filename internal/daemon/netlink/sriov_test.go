@@ -0,0 +1,53 @@
+package netlink_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+func TestVFOf(t *testing.T) {
+	if env := internalNetlink.PCIDeviceEnv("intel.com/sriov_netdevice"); env != "PCIDEVICE_INTEL_COM_SRIOV_NETDEVICE" {
+		t.Errorf("unexpected environment variable %s", env)
+	}
+
+	sysfs, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sysfs)
+	defer func(original string) { internalNetlink.SysfsPCIDevices = original }(internalNetlink.SysfsPCIDevices)
+	internalNetlink.SysfsPCIDevices = sysfs
+
+	for _, dir := range []string{"0000:03:00.0/net/ens1f0", "0000:03:02.0", "0000:03:02.1/net/ens1f0v1"} {
+		if err := os.MkdirAll(filepath.Join(sysfs, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"0000:03:00.0/virtfn0": "../0000:03:02.0",
+		"0000:03:00.0/virtfn1": "../0000:03:02.1",
+		"0000:03:02.0/physfn":  "../0000:03:00.0",
+		"0000:03:02.1/physfn":  "../0000:03:00.0",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(sysfs, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pf, index, vf, err := internalNetlink.VFOf("0000:03:02.1")
+	if err != nil || pf != "ens1f0" || index != 1 || vf != "ens1f0v1" {
+		t.Errorf("expected VF 1 ens1f0v1 of ens1f0, got %d %q of %q, %v", index, vf, pf, err)
+	}
+	// moved into a router pod
+	if _, index, vf, err = internalNetlink.VFOf("0000:03:02.0"); err != nil || index != 0 || vf != "" {
+		t.Errorf("expected VF 0 without an interface, got %d %q, %v", index, vf, err)
+	}
+	if _, _, _, err := internalNetlink.VFOf("0000:03:00.0"); err == nil {
+		t.Error("expected a physical function not to be a virtual function")
+	}
+}
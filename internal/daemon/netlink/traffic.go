@@ -0,0 +1,63 @@
+package netlink
+
+import (
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// RouterPacketsReceived returns the packets the router container has received
+// on its internal and external interfaces, named after the given name like
+// ClearVethInterface, as sent to it by their host ends. External interfaces
+// of SR-IOV virtual functions have no host end, and are left out.
+func RouterPacketsReceived(interfaceName string) (uint64, error) {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return 0, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	var packets uint64
+	for _, hostInterfaceName := range []string{"int" + interfaceName, "ext" + interfaceName} {
+		link, err := rootNetlinkHandle.LinkByName(hostInterfaceName)
+		if _, notFound := err.(remoteNetlink.LinkNotFoundError); notFound && hostInterfaceName != "int"+interfaceName {
+			continue
+		}
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", hostInterfaceName)
+			return 0, err
+		}
+		if statistics := link.Attrs().Statistics; statistics != nil {
+			packets += statistics.TxPackets
+		}
+	}
+	return packets, nil
+}
+
+// RouterInterfaceErrors returns the errors and drops of the internal and
+// external interfaces of the router container, named after the given name
+// like ClearVethInterface, as their host ends count them in both directions.
+// External interfaces of SR-IOV virtual functions are left out as
+// RouterPacketsReceived leaves them.
+func RouterInterfaceErrors(interfaceName string) (map[string]uint64, error) {
+	rootNetlinkHandle, err := GetRootNetlinkHandle()
+	if err != nil {
+		return nil, err
+	}
+	defer rootNetlinkHandle.Delete()
+
+	errors := map[string]uint64{}
+	for iface, hostInterfaceName := range map[string]string{"internal": "int" + interfaceName, "external": "ext" + interfaceName} {
+		link, err := rootNetlinkHandle.LinkByName(hostInterfaceName)
+		if _, notFound := err.(remoteNetlink.LinkNotFoundError); notFound && iface == "external" {
+			continue
+		}
+		if err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", hostInterfaceName)
+			return nil, err
+		}
+		if statistics := link.Attrs().Statistics; statistics != nil {
+			errors[iface] = statistics.RxErrors + statistics.TxErrors + statistics.RxDropped + statistics.TxDropped
+		}
+	}
+	return errors, nil
+}
@@ -0,0 +1,167 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+const (
+	TunnelGRE  = "gre"
+	TunnelIPIP = "ipip"
+)
+
+// Tunnel is a GRE or IPIP tunnel of a container. Key is for GRE only, none if
+// 0, and TTL is inherited if 0. Address is the CIDR of the container on the
+// tunnel, none if empty.
+type Tunnel struct {
+	Name    string
+	Mode    string
+	Local   string
+	Remote  string
+	Key     uint32
+	TTL     uint8
+	Address string
+	Routes  []string
+}
+
+// SetTunnels2Container replaces the tunnels applied before in the container
+// with the given ones. The tunneled packets leave the external interface
+// through the table, and so do the routes of the tunnels.
+func SetTunnels2Container(containerPid int, applied []Tunnel, tunnels []Tunnel, tableNum int) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	// the routes of a tunnel go with its link
+	for _, tunnel := range applied {
+		if err := targetNetlinkHandle.RuleDel(tunnelRule(tunnel, tableNum)); err != nil {
+			klog.ErrorS(err, "RuleDel failed", "tunnel", tunnel.Name, "remote", tunnel.Remote)
+		}
+		if link, err := targetNetlinkHandle.LinkByName(tunnel.Name); err == nil {
+			if err := targetNetlinkHandle.LinkDel(link); err != nil {
+				klog.ErrorS(err, "LinkDel failed", "tunnel", tunnel.Name)
+				return err
+			}
+		}
+	}
+
+	if len(tunnels) == 0 {
+		return nil
+	}
+	external, err := targetNetlinkHandle.LinkByName(DefaultExternalContainerInterface)
+	if err != nil {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", DefaultExternalContainerInterface)
+		return err
+	}
+	for _, tunnel := range tunnels {
+		if err := addTunnel(targetNetlinkHandle, external, tunnel, tableNum); err != nil {
+			return err
+		}
+		klog.InfoS("Tunnel set", "tunnel", tunnel.Name, "mode", tunnel.Mode, "remote", tunnel.Remote)
+	}
+	return nil
+}
+
+func addTunnel(handle *remoteNetlink.Handle, external remoteNetlink.Link, tunnel Tunnel, tableNum int) error {
+	link, err := tunnelLink(tunnel, uint32(external.Attrs().Index))
+	if err != nil {
+		return err
+	}
+	if err := handle.LinkAdd(link); err != nil {
+		if !isExist(err) {
+			klog.ErrorS(err, "LinkAdd failed", "tunnel", tunnel.Name, "mode", tunnel.Mode)
+			return err
+		}
+		// a container attached again adopts the tunnel it still has
+		if err := handle.LinkModify(link); err != nil {
+			klog.ErrorS(err, "LinkModify failed", "tunnel", tunnel.Name, "mode", tunnel.Mode)
+			return err
+		}
+		if link, err = handle.LinkByName(tunnel.Name); err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "tunnel", tunnel.Name)
+			return err
+		}
+	}
+	if tunnel.Address != "" {
+		addr, err := remoteNetlink.ParseAddr(tunnel.Address)
+		if err != nil {
+			klog.ErrorS(err, "ParseAddr is failed", "addr", tunnel.Address)
+			return err
+		}
+		if err := handle.AddrReplace(link, addr); err != nil {
+			klog.ErrorS(err, "AddrReplace failed", "tunnel", tunnel.Name, "address", tunnel.Address)
+			return err
+		}
+	}
+	if err := handle.LinkSetUp(link); err != nil {
+		klog.ErrorS(err, "LinkSetUp failed", "tunnel", tunnel.Name)
+		return err
+	}
+	// the remote endpoint is reached the way external traffic is
+	if err := handle.RuleAdd(tunnelRule(tunnel, tableNum)); err != nil && !isExist(err) {
+		klog.ErrorS(err, "RuleAdd failed", "tunnel", tunnel.Name, "remote", tunnel.Remote)
+		return err
+	}
+	for _, route := range tunnel.Routes {
+		_, dst, err := net.ParseCIDR(route)
+		if err != nil {
+			return err
+		}
+		if err := handle.RouteReplace(&remoteNetlink.Route{
+			Table:     tableNum,
+			Dst:       dst,
+			LinkIndex: link.Attrs().Index,
+		}); err != nil {
+			klog.ErrorS(err, "RouteReplace failed", "tunnel", tunnel.Name, "dst", route)
+			return err
+		}
+	}
+	return nil
+}
+
+// tunnelLink returns the link of the tunnel, bound to the external interface
+func tunnelLink(tunnel Tunnel, parentIndex uint32) (remoteNetlink.Link, error) {
+	attrs := remoteNetlink.NewLinkAttrs()
+	attrs.Name = tunnel.Name
+	local, remote := net.ParseIP(tunnel.Local).To4(), net.ParseIP(tunnel.Remote).To4()
+	if local == nil || remote == nil {
+		return nil, fmt.Errorf("tunnel %s: invalid endpoints %q and %q", tunnel.Name, tunnel.Local, tunnel.Remote)
+	}
+	switch tunnel.Mode {
+	case TunnelGRE:
+		// netlink flags the keys as set when they aren't 0
+		return &remoteNetlink.Gretun{
+			LinkAttrs: attrs,
+			Link:      parentIndex,
+			IKey:      tunnel.Key,
+			OKey:      tunnel.Key,
+			Local:     local,
+			Remote:    remote,
+			Ttl:       tunnel.TTL,
+			PMtuDisc:  1,
+		}, nil
+	case TunnelIPIP:
+		return &remoteNetlink.Iptun{
+			LinkAttrs: attrs,
+			Link:      parentIndex,
+			Local:     local,
+			Remote:    remote,
+			Ttl:       tunnel.TTL,
+			PMtuDisc:  1,
+		}, nil
+	}
+	return nil, fmt.Errorf("tunnel %s: unknown mode %q", tunnel.Name, tunnel.Mode)
+}
+
+func tunnelRule(tunnel Tunnel, tableNum int) *remoteNetlink.Rule {
+	rule := remoteNetlink.NewRule()
+	rule.Table = tableNum
+	rule.Dst = &net.IPNet{IP: net.ParseIP(tunnel.Remote).To4(), Mask: net.CIDRMask(32, 32)}
+	return rule
+}
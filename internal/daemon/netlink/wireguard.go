@@ -0,0 +1,218 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// WireGuardInterfaceName is the wg interface of a router container
+const WireGuardInterfaceName = "wg0"
+
+// WireGuard is the VPN of a container. The keys are base64 encoded, and the
+// packets of the VPN itself are sent with the firewall mark.
+type WireGuard struct {
+	PrivateKey string
+	ListenPort int
+	FwMark     int
+	Address    string
+	Peers      []WireGuardPeer
+}
+
+// WireGuardPeer is a peer of the VPN, PersistentKeepalive none if 0
+type WireGuardPeer struct {
+	PublicKey           string
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive int
+}
+
+// wg runs the wg tool in the network namespace of the process, the input
+// given on its standard input
+var wg = func(pid int, input string, args ...string) ([]byte, error) {
+	cmd := exec.Command("nsenter", append([]string{"-t", strconv.Itoa(pid), "-n", "wg"}, args...)...)
+	cmd.Stdin = strings.NewReader(input)
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, fmt.Errorf("wg: %v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}
+
+// WireGuardConfig renders the configuration of the interface in the format
+// of wg setconf.
+func WireGuardConfig(wireGuard *WireGuard) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "[Interface]\nPrivateKey = %s\nListenPort = %d\n", wireGuard.PrivateKey, wireGuard.ListenPort)
+	if wireGuard.FwMark != 0 {
+		fmt.Fprintf(&config, "FwMark = %d\n", wireGuard.FwMark)
+	}
+	for _, peer := range wireGuard.Peers {
+		fmt.Fprintf(&config, "\n[Peer]\nPublicKey = %s\nAllowedIPs = %s\n", peer.PublicKey, strings.Join(peer.AllowedIPs, ", "))
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&config, "Endpoint = %s\n", peer.Endpoint)
+		}
+		if peer.PersistentKeepalive != 0 {
+			fmt.Fprintf(&config, "PersistentKeepalive = %d\n", peer.PersistentKeepalive)
+		}
+	}
+	return config.String()
+}
+
+// SetWireGuard2Container programs the wg interface of the container, or
+// removes it if nil. Peers missing from the configuration are removed too,
+// and the allowed IPs of the peers are routed through the interface in the
+// table.
+func SetWireGuard2Container(containerPid int, wireGuard *WireGuard, tableNum int) error {
+	targetNetlinkHandle, err := GetTargetNetlinkHandle(GetNsHandle(CrioType(containerPid)))
+	if err != nil {
+		klog.ErrorS(err, "GetTargetNetlinkHandle")
+		return err
+	}
+	defer targetNetlinkHandle.Delete()
+
+	link, err := targetNetlinkHandle.LinkByName(WireGuardInterfaceName)
+	if _, notFound := err.(remoteNetlink.LinkNotFoundError); err != nil && !notFound {
+		klog.ErrorS(err, "LinkByName is failed", "interfaceName", WireGuardInterfaceName)
+		return err
+	}
+	if wireGuard == nil {
+		// the routes of the interface go with it
+		if link == nil {
+			return nil
+		}
+		if err := targetNetlinkHandle.LinkDel(link); err != nil {
+			klog.ErrorS(err, "LinkDel failed", "interfaceName", WireGuardInterfaceName)
+			return err
+		}
+		klog.InfoS("WireGuard cleared", "interfaceName", WireGuardInterfaceName)
+		return nil
+	}
+
+	if link == nil {
+		attrs := remoteNetlink.NewLinkAttrs()
+		attrs.Name = WireGuardInterfaceName
+		if err := targetNetlinkHandle.LinkAdd(&remoteNetlink.Wireguard{LinkAttrs: attrs}); err != nil {
+			klog.ErrorS(err, "LinkAdd failed", "interfaceName", WireGuardInterfaceName)
+			return err
+		}
+		if link, err = targetNetlinkHandle.LinkByName(WireGuardInterfaceName); err != nil {
+			klog.ErrorS(err, "LinkByName is failed", "interfaceName", WireGuardInterfaceName)
+			return err
+		}
+	}
+	if _, err := wg(containerPid, WireGuardConfig(wireGuard), "setconf", WireGuardInterfaceName, "/dev/stdin"); err != nil {
+		klog.ErrorS(err, "Configuring WireGuard failed", "interfaceName", WireGuardInterfaceName)
+		return err
+	}
+
+	addr, err := remoteNetlink.ParseAddr(wireGuard.Address)
+	if err != nil {
+		klog.ErrorS(err, "ParseAddr is failed", "addr", wireGuard.Address)
+		return err
+	}
+	addrs, err := targetNetlinkHandle.AddrList(link, remoteNetlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, applied := range addrs {
+		if applied.IPNet.String() != addr.IPNet.String() && applied.IP.IsGlobalUnicast() {
+			if err := targetNetlinkHandle.AddrDel(link, &applied); err != nil {
+				klog.ErrorS(err, "AddrDel failed", "interfaceName", WireGuardInterfaceName, "addr", applied.IPNet)
+				return err
+			}
+		}
+	}
+	if err := targetNetlinkHandle.AddrReplace(link, addr); err != nil {
+		klog.ErrorS(err, "AddrReplace failed", "interfaceName", WireGuardInterfaceName, "addr", wireGuard.Address)
+		return err
+	}
+	if err := targetNetlinkHandle.LinkSetUp(link); err != nil {
+		klog.ErrorS(err, "LinkSetUp failed", "interfaceName", WireGuardInterfaceName)
+		return err
+	}
+
+	return setWireGuardRoutes(targetNetlinkHandle, link, wireGuard, tableNum)
+}
+
+// setWireGuardRoutes routes the allowed IPs of the peers through the
+// interface, removing the routes of peers gone or changed.
+func setWireGuardRoutes(handle *remoteNetlink.Handle, link remoteNetlink.Link, wireGuard *WireGuard, tableNum int) error {
+	wanted := map[string]*net.IPNet{}
+	for _, peer := range wireGuard.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			_, dst, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				return err
+			}
+			wanted[dst.String()] = dst
+		}
+	}
+
+	routes, err := handle.RouteListFiltered(remoteNetlink.FAMILY_ALL, &remoteNetlink.Route{
+		Table:     tableNum,
+		LinkIndex: link.Attrs().Index,
+	}, remoteNetlink.RT_FILTER_TABLE|remoteNetlink.RT_FILTER_OIF)
+	if err != nil {
+		klog.ErrorS(err, "RouteListFiltered failed", "interfaceName", WireGuardInterfaceName)
+		return err
+	}
+	for _, route := range routes {
+		if route.Dst != nil && wanted[route.Dst.String()] != nil {
+			continue
+		}
+		route := route
+		if err := handle.RouteDel(&route); err != nil {
+			klog.ErrorS(err, "RouteDel failed", "interfaceName", WireGuardInterfaceName, "dst", route.Dst)
+			return err
+		}
+	}
+	for _, dst := range wanted {
+		if err := handle.RouteReplace(&remoteNetlink.Route{
+			Table:     tableNum,
+			Dst:       dst,
+			LinkIndex: link.Attrs().Index,
+		}); err != nil {
+			klog.ErrorS(err, "RouteReplace failed", "interfaceName", WireGuardInterfaceName, "dst", dst)
+			return err
+		}
+	}
+	return nil
+}
+
+// WireGuardHandshakes returns the Unix times of the latest handshakes of the
+// wg interface of the container by public key of the peer, 0 for peers never
+// shaken hands with.
+func WireGuardHandshakes(containerPid int) (map[string]int64, error) {
+	output, err := wg(containerPid, "", "show", WireGuardInterfaceName, "latest-handshakes")
+	if err != nil {
+		return nil, err
+	}
+	return parseWireGuardHandshakes(string(output))
+}
+
+// parseWireGuardHandshakes parses the "<public key>\t<unix time>" lines of wg
+// show latest-handshakes.
+func parseWireGuardHandshakes(output string) (map[string]int64, error) {
+	handshakes := map[string]int64{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected wg output %q", line)
+		}
+		handshake, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected wg output %q", line)
+		}
+		handshakes[fields[0]] = handshake
+	}
+	return handshakes, nil
+}
@@ -0,0 +1,195 @@
+// Package nflog reads the packets logged through NFLOG from a pcap stream,
+// as tcpdump writes it capturing on an nflog interface.
+package nflog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// linkTypeNFLOG is the link type of pcap streams of nflog interfaces
+	linkTypeNFLOG = 239
+
+	pcapHeaderSize       = 24
+	pcapRecordHeaderSize = 16
+	nflogHeaderSize      = 4
+
+	// TLV types of the attributes of a logged packet
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	familyIPv4 = 2
+	familyIPv6 = 10
+)
+
+// Packet is a packet logged through NFLOG.
+type Packet struct {
+	Time time.Time
+	// Group is the netlink group the packet was logged to
+	Group uint16
+	// Prefix is the prefix of the rule logging the packet
+	Prefix          string
+	Protocol        uint8
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      uint16
+	DestinationPort uint16
+	// Length is the length of the packet on the wire
+	Length int
+}
+
+// ProtocolName returns the name of the layer 4 protocol of the packet, or its
+// number if it has none.
+func (p *Packet) ProtocolName() string {
+	switch p.Protocol {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "ipv6-icmp"
+	case 132:
+		return "sctp"
+	}
+	return fmt.Sprint(p.Protocol)
+}
+
+// Reader reads the packets of a pcap stream of an nflog interface.
+type Reader struct {
+	r io.Reader
+	// order is the byte order of the capturing host, which the pcap headers
+	// and the TLVs of the packets are in
+	order binary.ByteOrder
+	nanos bool
+}
+
+// NewReader reads the pcap header from r, failing if it isn't the stream of
+// an nflog interface.
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, pcapHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	reader := &Reader{r: r}
+	switch binary.LittleEndian.Uint32(header) {
+	case 0xa1b2c3d4:
+		reader.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		reader.order, reader.nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		reader.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		reader.order, reader.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a pcap stream")
+	}
+	if linkType := reader.order.Uint32(header[20:]); linkType != linkTypeNFLOG {
+		return nil, fmt.Errorf("link type %d of the pcap stream is not NFLOG", linkType)
+	}
+	return reader, nil
+}
+
+// Next returns the next packet of the stream, skipping those of no IPv4 or
+// IPv6 packet. It returns io.EOF at the end of the stream.
+func (r *Reader) Next() (*Packet, error) {
+	for {
+		header := make([]byte, pcapRecordHeaderSize)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			return nil, err
+		}
+		seconds, fraction := r.order.Uint32(header), r.order.Uint32(header[4:])
+		data := make([]byte, r.order.Uint32(header[8:]))
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if !r.nanos {
+			fraction *= 1000
+		}
+		packet := r.parse(data)
+		if packet == nil {
+			continue
+		}
+		packet.Time = time.Unix(int64(seconds), int64(fraction))
+		return packet, nil
+	}
+}
+
+// parse parses the NFLOG header and attributes of a record, nil if it has no
+// IPv4 or IPv6 packet.
+func (r *Reader) parse(data []byte) *Packet {
+	if len(data) < nflogHeaderSize {
+		return nil
+	}
+	family := data[0]
+	packet := &Packet{Group: binary.BigEndian.Uint16(data[2:])}
+	var payload []byte
+	for b := data[nflogHeaderSize:]; len(b) >= 4; {
+		length, tlvType := int(r.order.Uint16(b)), r.order.Uint16(b[2:])
+		if length < 4 || length > len(b) {
+			break
+		}
+		switch tlvType {
+		case nfulaPrefix:
+			packet.Prefix = strings.TrimRight(string(b[4:length]), "\x00")
+		case nfulaPayload:
+			payload = b[4:length]
+		}
+		// attributes are padded to 32 bits
+		padded := (length + 3) &^ 3
+		if padded > len(b) {
+			break
+		}
+		b = b[padded:]
+	}
+	if !parseIP(family, payload, packet) {
+		return nil
+	}
+	return packet
+}
+
+// parseIP fills the addresses, protocol and ports of the packet in from its
+// IP header.
+func parseIP(family uint8, payload []byte, packet *Packet) bool {
+	var transport []byte
+	switch family {
+	case familyIPv4:
+		if len(payload) < 20 || payload[0]>>4 != 4 {
+			return false
+		}
+		headerLength := int(payload[0]&0x0f) * 4
+		packet.Protocol = payload[9]
+		packet.Source, packet.Destination = net.IP(payload[12:16]), net.IP(payload[16:20])
+		packet.Length = int(binary.BigEndian.Uint16(payload[2:]))
+		if headerLength <= len(payload) {
+			transport = payload[headerLength:]
+		}
+	case familyIPv6:
+		if len(payload) < 40 || payload[0]>>4 != 6 {
+			return false
+		}
+		// extension headers are not followed, their packets have no ports
+		packet.Protocol = payload[6]
+		packet.Source, packet.Destination = net.IP(payload[8:24]), net.IP(payload[24:40])
+		packet.Length = 40 + int(binary.BigEndian.Uint16(payload[4:]))
+		transport = payload[40:]
+	default:
+		return false
+	}
+	switch packet.Protocol {
+	case 6, 17, 132:
+		if len(transport) >= 4 {
+			packet.SourcePort, packet.DestinationPort = binary.BigEndian.Uint16(transport), binary.BigEndian.Uint16(transport[2:])
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package nflog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// record returns a pcap record of a packet logged with the prefix, in the
+// byte order of the capturing host.
+func record(order binary.ByteOrder, at time.Time, family uint8, prefix string, payload []byte) []byte {
+	var data []byte
+	data = append(data, family, 0, 0, 100)
+	tlv := func(tlvType uint16, value []byte) {
+		header := make([]byte, 4)
+		order.PutUint16(header, uint16(4+len(value)))
+		order.PutUint16(header[2:], tlvType)
+		data = append(data, header...)
+		data = append(data, value...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	tlv(nfulaPrefix, append([]byte(prefix), 0))
+	tlv(nfulaPayload, payload)
+
+	header := make([]byte, pcapRecordHeaderSize)
+	order.PutUint32(header, uint32(at.Unix()))
+	order.PutUint32(header[4:], uint32(at.Nanosecond()/1000))
+	order.PutUint32(header[8:], uint32(len(data)))
+	order.PutUint32(header[12:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestReader(t *testing.T) {
+	at := time.Unix(1600000000, 5000)
+	ipv4 := []byte{0x45, 0, 0, 60, 0, 0, 0, 0, 64, 6, 0, 0, 10, 0, 0, 2, 8, 8, 8, 8, 0x9c, 0x40, 0x01, 0xbb}
+	ipv6 := make([]byte, 48)
+	ipv6[0], ipv6[5], ipv6[6] = 0x60, 8, 17
+	copy(ipv6[8:], net.ParseIP("fd00:10::2"))
+	copy(ipv6[24:], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[40:], 5353)
+	binary.BigEndian.PutUint16(ipv6[42:], 53)
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		header := make([]byte, pcapHeaderSize)
+		order.PutUint32(header, 0xa1b2c3d4)
+		order.PutUint32(header[20:], linkTypeNFLOG)
+		stream := append([]byte(nil), header...)
+		stream = append(stream, record(order, at, familyIPv4, "vrfw:1", ipv4)...)
+		stream = append(stream, record(order, at, 7, "vrfw:2", ipv4)...)
+		stream = append(stream, record(order, at, familyIPv6, "vrfw:3", ipv6)...)
+
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%s: %v", order, err)
+		}
+		packet, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", order, err)
+		}
+		if packet.Prefix != "vrfw:1" || packet.Group != 100 || packet.ProtocolName() != "tcp" || !packet.Source.Equal(net.ParseIP("10.0.0.2")) ||
+			!packet.Destination.Equal(net.ParseIP("8.8.8.8")) || packet.SourcePort != 40000 || packet.DestinationPort != 443 || packet.Length != 60 || !packet.Time.Equal(at) {
+			t.Errorf("%s: unexpected packet %+v", order, packet)
+		}
+		// the packet of an unknown family is skipped
+		packet, err = r.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", order, err)
+		}
+		if packet.Prefix != "vrfw:3" || packet.ProtocolName() != "udp" || !packet.Source.Equal(net.ParseIP("fd00:10::2")) || packet.DestinationPort != 53 || packet.Length != 48 {
+			t.Errorf("%s: unexpected packet %+v", order, packet)
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("%s: expected the end of the stream, got %v", order, err)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader(make([]byte, pcapHeaderSize))); err == nil {
+		t.Errorf("expected an error reading no pcap stream")
+	}
+}
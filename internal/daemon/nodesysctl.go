@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// PROC_SYS is where the sysctls of the node are files
+const PROC_SYS string = "/proc/sys"
+
+// writeSysctl sets the sysctl of the node
+var writeSysctl = func(name, value string) error {
+	path, err := sysctlPath(name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+// sysctlPath returns the file of the sysctl under /proc/sys, refusing names
+// that would lead out of it whatever the controller let through.
+func sysctlPath(name string) (string, error) {
+	relative, err := virtualroutermanager.NodeSysctlPath(name)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Clean(filepath.Join(PROC_SYS, relative))
+	if !strings.HasPrefix(path, PROC_SYS+"/") {
+		return "", fmt.Errorf("sysctl %q is not a file under %s", name, PROC_SYS)
+	}
+	return path, nil
+}
+
+// nodeSysctl is a sysctl of the node set for spec.nodeSysctls of router
+// containers
+type nodeSysctl struct {
+	// original is the value before the sysctl was first set, put back once
+	// no router container sets it
+	original string
+	// holders are the values the router containers set, by container name
+	holders map[string]string
+}
+
+// SetAllowedNodeSysctls sets the sysctls, or prefixes ending with *, routers
+// may set on the node by spec.nodeSysctls. None are allowed by default.
+func (n *NetworkDaemon) SetAllowedNodeSysctls(patterns []string) {
+	n.allowedNodeSysctls = patterns
+}
+
+// checkNodeSysctls rejects the node sysctls of the spec the daemon isn't
+// allowed to set.
+func (n *NetworkDaemon) checkNodeSysctls(virtualrouterSpec v1.VirtualRouterSpec) error {
+	for _, sysctl := range virtualrouterSpec.NodeSysctls {
+		// prefixes of --allowed-node-sysctls would take names leading out of
+		// /proc/sys
+		if _, err := sysctlPath(sysctl.Name); err != nil {
+			return &UnsupportedFeatureError{Feature: "setting sysctl " + sysctl.Name, Reason: "the node sysctls of the router, not being a file under " + PROC_SYS}
+		}
+		if !virtualroutermanager.SysctlAllowed(sysctl.Name, n.allowedNodeSysctls) {
+			return &UnsupportedFeatureError{Feature: "setting sysctl " + sysctl.Name, Reason: "the node sysctls of the router, not being of --allowed-node-sysctls"}
+		}
+	}
+	return nil
+}
+
+// SetNodeSysctls sets the node sysctls of the router container, and puts back
+// those it no longer sets unless another router container sets them too. A
+// sysctl another router container sets to another value is refused, nothing
+// being set. The values put back are only known to the running daemon.
+func (n *NetworkDaemon) SetNodeSysctls(containerName string, sysctls []v1.RouterSysctl) error {
+	for _, sysctl := range sysctls {
+		held, exist := n.nodeSysctls[sysctl.Name]
+		if !exist {
+			continue
+		}
+		for holder, value := range held.holders {
+			if holder != containerName && value != sysctl.Value {
+				return fmt.Errorf("sysctl %s is set to %s for router container %s", sysctl.Name, value, holder)
+			}
+		}
+	}
+
+	keep := map[string]bool{}
+	for _, sysctl := range sysctls {
+		keep[sysctl.Name] = true
+	}
+	n.releaseNodeSysctls(containerName, keep)
+
+	for _, sysctl := range sysctls {
+		held, exist := n.nodeSysctls[sysctl.Name]
+		if !exist {
+			original, err := readSysctl(sysctl.Name)
+			if err != nil {
+				return fmt.Errorf("reading sysctl %s: %v", sysctl.Name, err)
+			}
+			held = &nodeSysctl{original: original, holders: map[string]string{}}
+		}
+		if err := writeSysctl(sysctl.Name, sysctl.Value); err != nil {
+			return fmt.Errorf("setting sysctl %s: %v", sysctl.Name, err)
+		}
+		held.holders[containerName] = sysctl.Value
+		n.nodeSysctls[sysctl.Name] = held
+		klog.InfoS("Set node sysctl", "containerName", containerName, "sysctl", sysctl.Name, "value", sysctl.Value)
+	}
+	return nil
+}
+
+// releaseNodeSysctls drops the router container from the holders of the node
+// sysctls other than those kept, and puts back the original value of those
+// no router container holds any longer.
+func (n *NetworkDaemon) releaseNodeSysctls(containerName string, keep map[string]bool) {
+	var names []string
+	for name := range n.nodeSysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		held := n.nodeSysctls[name]
+		if _, exist := held.holders[containerName]; !exist || keep[name] {
+			continue
+		}
+		delete(held.holders, containerName)
+		if len(held.holders) > 0 {
+			continue
+		}
+		if err := writeSysctl(name, held.original); err != nil {
+			klog.ErrorS(err, "Putting back node sysctl failed", "sysctl", name, "value", held.original)
+		} else {
+			klog.InfoS("Put back node sysctl", "sysctl", name, "value", held.original)
+		}
+		delete(n.nodeSysctls, name)
+	}
+}
+
+func nodeSysctlsPlan(sysctls []v1.RouterSysctl) string {
+	var pairs []string
+	for _, sysctl := range sysctls {
+		pairs = append(pairs, sysctl.Name+"="+sysctl.Value)
+	}
+	return strings.Join(pairs, ", ")
+}
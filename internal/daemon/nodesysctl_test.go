@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestNodeSysctls(t *testing.T) {
+	defer func(read func(string) (string, error), write func(string, string) error) {
+		readSysctl, writeSysctl = read, write
+	}(readSysctl, writeSysctl)
+	node := map[string]string{"net.core.rmem_max": "212992", "net.core.somaxconn": "4096"}
+	readSysctl = func(name string) (string, error) { return node[name], nil }
+	writeSysctl = func(name, value string) error {
+		node[name] = value
+		return nil
+	}
+
+	n := NewDaemon(nil, nil, "")
+	spec := v1.VirtualRouterSpec{NodeSysctls: []v1.RouterSysctl{{Name: "net.core.rmem_max", Value: "26214400"}}}
+	if err := n.CheckFeatures(spec); err == nil {
+		t.Error("expected a sysctl not allowed to be refused")
+	}
+	n.SetAllowedNodeSysctls([]string{"net.core.*"})
+	if err := n.CheckFeatures(spec); err != nil {
+		t.Errorf("expected an allowed sysctl, got %v", err)
+	}
+
+	if err := n.SetNodeSysctls("router-a", spec.NodeSysctls); err != nil || node["net.core.rmem_max"] != "26214400" {
+		t.Fatalf("expected the sysctl set, got %v and %v", err, node)
+	}
+	if err := n.SetNodeSysctls("router-b", []v1.RouterSysctl{{Name: "net.core.rmem_max", Value: "1048576"}}); err == nil {
+		t.Error("expected another value of a sysctl held to be refused")
+	}
+	both := []v1.RouterSysctl{{Name: "net.core.rmem_max", Value: "26214400"}, {Name: "net.core.somaxconn", Value: "8192"}}
+	if err := n.SetNodeSysctls("router-b", both); err != nil {
+		t.Fatal(err)
+	}
+
+	// put back once neither router holds it
+	n.releaseNodeSysctls("router-a", nil)
+	if node["net.core.rmem_max"] != "26214400" {
+		t.Errorf("expected the sysctl router-b holds kept, got %v", node)
+	}
+	if err := n.SetNodeSysctls("router-b", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"net.core.rmem_max": "212992", "net.core.somaxconn": "4096"}
+	if !reflect.DeepEqual(node, expected) || len(n.nodeSysctls) != 0 {
+		t.Errorf("expected %v put back, got %v", expected, node)
+	}
+
+	if path, err := sysctlPath("net.ipv4.conf.eth0/100.rp_filter"); err != nil || path != "/proc/sys/net/ipv4/conf/eth0.100/rp_filter" {
+		t.Errorf("expected the sysctl of eth0.100, got %s (%v)", path, err)
+	}
+	// slashes are the dots of paths, which must not lead out of /proc/sys
+	for _, name := range []string{"net.core.//.//.//.etc.x", "net.core../x", "net..core"} {
+		if path, err := sysctlPath(name); err == nil {
+			t.Errorf("%s: expected to be refused, got %s", name, path)
+		}
+	}
+	traversal := v1.VirtualRouterSpec{NodeSysctls: []v1.RouterSysctl{{Name: "net.core.//.//.//.etc.x", Value: "0"}}}
+	if err := n.CheckFeatures(traversal); err == nil {
+		t.Error("expected a sysctl out of /proc/sys to be refused though of an allowed prefix")
+	}
+}
@@ -0,0 +1,173 @@
+package packetfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// iptablesBackend programs rulesets as chains of the iptables table of their
+// type, jumped to from the first rule of the built-in chain of their hook.
+type iptablesBackend struct{}
+
+func (b *iptablesBackend) Name() string {
+	return IPTABLES
+}
+
+func (b *iptablesBackend) command(ruleset *Ruleset) string {
+	if ruleset.Family == FamilyIPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+func (b *iptablesBackend) Compile(ruleset *Ruleset) string {
+	var rules strings.Builder
+	fmt.Fprintf(&rules, "*%s\n", ruleset.Type)
+	for _, chain := range ruleset.Chains {
+		fmt.Fprintf(&rules, ":%s - [0:0]\n", chain.Name)
+	}
+	for _, chain := range ruleset.Chains {
+		for _, rule := range chain.Rules {
+			fmt.Fprintf(&rules, "-A %s %s\n", chain.Name, strings.Join(iptablesRule(rule), " "))
+		}
+	}
+	rules.WriteString("COMMIT\n")
+	return rules.String()
+}
+
+// entry returns the rule of the built-in chain jumping to the ruleset.
+func (b *iptablesBackend) entry(ruleset *Ruleset) []string {
+	return iptablesRule(Rule{Match: ruleset.Entry, Jump: ruleset.Chains[0].Name})
+}
+
+func (b *iptablesBackend) Apply(pid int, ruleset *Ruleset) error {
+	// declared chains are flushed, the others left alone
+	if err := run(pid, b.Compile(ruleset), b.command(ruleset)+"-restore", "--noflush"); err != nil {
+		return err
+	}
+	builtin := strings.ToUpper(string(ruleset.Hook))
+	entry := b.entry(ruleset)
+	if err := run(pid, "", b.command(ruleset), append([]string{"-t", string(ruleset.Type), "-C", builtin}, entry...)...); err == nil {
+		return nil
+	}
+	return run(pid, "", b.command(ruleset), append([]string{"-t", string(ruleset.Type), "-I", builtin, "1"}, entry...)...)
+}
+
+func (b *iptablesBackend) Delete(pid int, ruleset *Ruleset) error {
+	command := b.command(ruleset)
+	table := string(ruleset.Type)
+	// the jump may be gone already
+	run(pid, "", command, append([]string{"-t", table, "-D", strings.ToUpper(string(ruleset.Hook))}, b.entry(ruleset)...)...)
+	// chains are only deleted once no rule jumps to them
+	for _, chain := range ruleset.Chains {
+		run(pid, "", command, "-t", table, "-F", chain.Name)
+	}
+	for _, chain := range ruleset.Chains {
+		if err := run(pid, "", command, "-t", table, "-X", chain.Name); err != nil && !strings.Contains(err.Error(), "No chain") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *iptablesBackend) Counters(pid int, ruleset *Ruleset) (map[string]uint64, error) {
+	saved, err := output(pid, b.command(ruleset)+"-save", "-c", "-t", string(ruleset.Type))
+	if err != nil {
+		return nil, err
+	}
+	return parseIptablesCounters(string(saved), ruleset), nil
+}
+
+// parseIptablesCounters adds up the packet counters of the rules of the
+// ruleset in iptables-save -c output by their comment.
+func parseIptablesCounters(saved string, ruleset *Ruleset) map[string]uint64 {
+	chains := map[string]bool{}
+	for _, chain := range ruleset.Chains {
+		chains[chain.Name] = true
+	}
+	counters := map[string]uint64{}
+	for _, line := range strings.Split(saved, "\n") {
+		end := strings.Index(line, "]")
+		if !strings.HasPrefix(line, "[") || end < 0 {
+			continue
+		}
+		var packets, bytes uint64
+		if _, err := fmt.Sscanf(line[1:end], "%d:%d", &packets, &bytes); err != nil {
+			continue
+		}
+		fields := strings.Fields(line[end+1:])
+		if len(fields) < 2 || fields[0] != "-A" || !chains[fields[1]] {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i++ {
+			if fields[i] == "--comment" {
+				counters[strings.Trim(fields[i+1], `"`)] += packets
+				break
+			}
+		}
+	}
+	return counters
+}
+
+func iptablesRule(rule Rule) []string {
+	var args []string
+	if rule.Source != "" {
+		args = append(args, "-s", rule.Source)
+	}
+	if rule.Destination != "" {
+		args = append(args, "-d", rule.Destination)
+	}
+	if rule.InInterface != "" {
+		args = append(args, "-i", rule.InInterface)
+	}
+	if rule.OutInterface != "" {
+		args = append(args, "-o", rule.OutInterface)
+	}
+	if rule.Protocol != "" && !rule.TCPSyn {
+		args = append(args, "-p", rule.Protocol)
+	}
+	if rule.Mark != 0 {
+		args = append(args, "-m", "mark", "--mark", fmt.Sprintf("%#x", rule.Mark))
+	}
+	if rule.ConnMark != 0 {
+		args = append(args, "-m", "connmark", "--mark", fmt.Sprintf("%#x", rule.ConnMark))
+	}
+	if rule.TCPSyn {
+		args = append(args, "-p", "tcp", "--syn")
+	}
+	if rule.CtState != "" {
+		args = append(args, "-m", "conntrack", "--ctstate", strings.ToUpper(rule.CtState))
+	}
+	// the mask defaults to a single address of the family
+	if rule.ConnLimitAbove != 0 {
+		args = append(args, "-m", "connlimit", "--connlimit-above", fmt.Sprint(rule.ConnLimitAbove))
+	}
+	if rule.Limit != nil {
+		args = append(args, "-m", "limit", "--limit", fmt.Sprintf("%d/second", rule.Limit.Rate), "--limit-burst", fmt.Sprint(rule.Limit.Burst))
+	}
+	if rule.Counter != "" {
+		args = append(args, "-m", "comment", "--comment", rule.Counter)
+	}
+	switch {
+	case rule.Jump != "":
+		args = append(args, "-j", rule.Jump)
+	case rule.SNAT != "":
+		args = append(args, "-j", "SNAT", "--to-source", rule.SNAT)
+	case rule.HashSourceMark != nil:
+		args = append(args, "-j", "HMARK", "--hmark-tuple", "src",
+			"--hmark-mod", fmt.Sprint(rule.HashSourceMark.Mod), "--hmark-offset", fmt.Sprintf("%#x", rule.HashSourceMark.Offset))
+	case rule.Drop:
+		args = append(args, "-j", "DROP")
+	case rule.Return:
+		args = append(args, "-j", "RETURN")
+	case rule.Log != nil:
+		args = append(args, "-j", "NFLOG", "--nflog-group", fmt.Sprint(rule.Log.Group), "--nflog-prefix", rule.Log.Prefix)
+	case rule.SetConnMark != 0:
+		args = append(args, "-j", "CONNMARK", "--set-mark", fmt.Sprintf("%#x", rule.SetConnMark))
+	case rule.RestoreConnMark:
+		args = append(args, "-j", "CONNMARK", "--restore-mark")
+	case rule.CtOriginalZone != 0:
+		args = append(args, "-j", "CT", "--zone-orig", fmt.Sprint(rule.CtOriginalZone))
+	}
+	return args
+}
@@ -0,0 +1,200 @@
+package packetfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nftablesPriorityOffset puts the base chain of a ruleset right ahead of the
+// chains of the router at the standard priority of the hook
+const nftablesPriorityOffset = -1
+
+// nftablesPriority is the standard priority of the chains of each type and
+// hook, such as srcnat, given as numbers for older nft
+var nftablesPriority = map[ChainType]map[Hook]int{
+	TypeNAT:    {HookPrerouting: -100, HookPostrouting: 100},
+	TypeFilter: {HookPrerouting: 0, HookForward: 0, HookPostrouting: 0},
+	TypeRaw:    {HookPrerouting: -300},
+	TypeMangle: {HookPrerouting: -150, HookForward: -150, HookPostrouting: -150},
+}
+
+// nftablesChainType is the type of the base chain of each type, the
+// iptables tables but nat being filter chains
+func nftablesChainType(chainType ChainType) ChainType {
+	if chainType == TypeNAT {
+		return TypeNAT
+	}
+	return TypeFilter
+}
+
+// nftablesBackend programs rulesets as tables of their own, replaced
+// atomically, with a base chain on their hook.
+type nftablesBackend struct{}
+
+func (b *nftablesBackend) Name() string {
+	return NFTABLES
+}
+
+func (b *nftablesBackend) Compile(ruleset *Ruleset) string {
+	var rules strings.Builder
+	// the table is declared first so deleting it can't fail
+	fmt.Fprintf(&rules, "table %s %s\n", ruleset.Family, ruleset.Name)
+	fmt.Fprintf(&rules, "delete table %s %s\n", ruleset.Family, ruleset.Name)
+	fmt.Fprintf(&rules, "table %s %s {\n", ruleset.Family, ruleset.Name)
+	fmt.Fprintf(&rules, "\tchain %s {\n", ruleset.Hook)
+	fmt.Fprintf(&rules, "\t\ttype %s hook %s priority %d; policy accept;\n", nftablesChainType(ruleset.Type), ruleset.Hook, nftablesPriority[ruleset.Type][ruleset.Hook]+nftablesPriorityOffset)
+	fmt.Fprintf(&rules, "\t\t%s\n", nftablesRule(ruleset.Family, ruleset.Chains[0].Name, Rule{Match: ruleset.Entry, Jump: ruleset.Chains[0].Name}))
+	rules.WriteString("\t}\n")
+	if flowTable := ruleset.FlowTable; flowTable != nil {
+		var devices []string
+		for _, device := range flowTable.Devices {
+			devices = append(devices, fmt.Sprintf("%q", device))
+		}
+		fmt.Fprintf(&rules, "\tflowtable %s {\n", flowTable.Name)
+		fmt.Fprintf(&rules, "\t\thook ingress priority 0; devices = { %s };\n", strings.Join(devices, ", "))
+		if flowTable.Hardware {
+			rules.WriteString("\t\tflags offload;\n")
+		}
+		if flowTable.Counter {
+			rules.WriteString("\t\tcounter\n")
+		}
+		rules.WriteString("\t}\n")
+	}
+	for _, chain := range ruleset.Chains {
+		for _, rule := range chain.Rules {
+			if rule.ConnLimitAbove != 0 {
+				fmt.Fprintf(&rules, "\tset %s { type %s; flags dynamic; }\n", nftablesConnLimitSet(chain.Name), nftablesAddrType[ruleset.Family])
+				break
+			}
+		}
+	}
+	for _, chain := range ruleset.Chains {
+		fmt.Fprintf(&rules, "\tchain %s {\n", chain.Name)
+		for _, rule := range chain.Rules {
+			fmt.Fprintf(&rules, "\t\t%s\n", nftablesRule(ruleset.Family, chain.Name, rule))
+		}
+		rules.WriteString("\t}\n")
+	}
+	rules.WriteString("}\n")
+	return rules.String()
+}
+
+func (b *nftablesBackend) Apply(pid int, ruleset *Ruleset) error {
+	return run(pid, b.Compile(ruleset), "nft", "-f", "-")
+}
+
+func (b *nftablesBackend) Delete(pid int, ruleset *Ruleset) error {
+	return run(pid, fmt.Sprintf("table %s %s\ndelete table %s %s\n", ruleset.Family, ruleset.Name, ruleset.Family, ruleset.Name), "nft", "-f", "-")
+}
+
+func (b *nftablesBackend) Counters(pid int, ruleset *Ruleset) (map[string]uint64, error) {
+	listed, err := output(pid, "nft", "list", "table", string(ruleset.Family), ruleset.Name)
+	if err != nil {
+		return nil, err
+	}
+	return parseNftablesCounters(string(listed)), nil
+}
+
+// parseNftablesCounters adds up the packet counters in nft list output by
+// the comment of their rule.
+func parseNftablesCounters(listed string) map[string]uint64 {
+	counters := map[string]uint64{}
+	for _, line := range strings.Split(listed, "\n") {
+		fields := strings.Fields(line)
+		var name string
+		var packets uint64
+		counted := false
+		for i := 0; i+1 < len(fields); i++ {
+			switch {
+			case fields[i] == "comment":
+				name = strings.Trim(fields[i+1], `"`)
+			case fields[i] == "counter" && i+2 < len(fields) && fields[i+1] == "packets":
+				if _, err := fmt.Sscan(fields[i+2], &packets); err == nil {
+					counted = true
+				}
+			}
+		}
+		if counted && name != "" {
+			counters[name] += packets
+		}
+	}
+	return counters
+}
+
+// nftablesAddrType is the type of the addresses of each family in sets
+var nftablesAddrType = map[Family]string{
+	FamilyIPv4: "ipv4_addr",
+	FamilyIPv6: "ipv6_addr",
+}
+
+// nftablesConnLimitSet is the set the sources connections are limited for in
+// a chain are tracked in
+func nftablesConnLimitSet(chain string) string {
+	return chain + "_connlimit"
+}
+
+func nftablesRule(family Family, chain string, rule Rule) string {
+	var statements []string
+	if rule.Source != "" {
+		statements = append(statements, fmt.Sprintf("%s saddr %s", family, rule.Source))
+	}
+	if rule.Destination != "" {
+		statements = append(statements, fmt.Sprintf("%s daddr %s", family, rule.Destination))
+	}
+	if rule.InInterface != "" {
+		statements = append(statements, fmt.Sprintf("iifname %q", rule.InInterface))
+	}
+	if rule.OutInterface != "" {
+		statements = append(statements, fmt.Sprintf("oifname %q", rule.OutInterface))
+	}
+	if rule.Protocol != "" && !rule.TCPSyn {
+		statements = append(statements, "meta l4proto "+rule.Protocol)
+	}
+	if rule.Mark != 0 {
+		statements = append(statements, fmt.Sprintf("meta mark %#x", rule.Mark))
+	}
+	if rule.ConnMark != 0 {
+		statements = append(statements, fmt.Sprintf("ct mark %#x", rule.ConnMark))
+	}
+	if rule.TCPSyn {
+		statements = append(statements, "tcp flags & (fin|syn|rst|ack) == syn")
+	}
+	if rule.CtState != "" {
+		statements = append(statements, "ct state "+rule.CtState)
+	}
+	if rule.ConnLimitAbove != 0 {
+		statements = append(statements, fmt.Sprintf("add @%s { %s saddr ct count over %d }", nftablesConnLimitSet(chain), family, rule.ConnLimitAbove))
+	}
+	if rule.Limit != nil {
+		statements = append(statements, fmt.Sprintf("limit rate %d/second burst %d packets", rule.Limit.Rate, rule.Limit.Burst))
+	}
+	if rule.Counter != "" {
+		statements = append(statements, "counter")
+	}
+	switch {
+	case rule.Jump != "":
+		statements = append(statements, "jump "+rule.Jump)
+	case rule.SNAT != "":
+		statements = append(statements, "snat to "+rule.SNAT)
+	case rule.HashSourceMark != nil:
+		statements = append(statements, fmt.Sprintf("meta mark set jhash %s saddr mod %d offset %#x", family, rule.HashSourceMark.Mod, rule.HashSourceMark.Offset))
+	case rule.Drop:
+		statements = append(statements, "drop")
+	case rule.Return:
+		statements = append(statements, "return")
+	case rule.Log != nil:
+		statements = append(statements, fmt.Sprintf("log prefix %q group %d", rule.Log.Prefix, rule.Log.Group))
+	case rule.FlowOffload != "":
+		statements = append(statements, "flow add @"+rule.FlowOffload)
+	case rule.SetConnMark != 0:
+		statements = append(statements, fmt.Sprintf("ct mark set %#x", rule.SetConnMark))
+	case rule.RestoreConnMark:
+		statements = append(statements, "meta mark set ct mark")
+	case rule.CtOriginalZone != 0:
+		statements = append(statements, fmt.Sprintf("ct original zone set %d", rule.CtOriginalZone))
+	}
+	if rule.Counter != "" {
+		statements = append(statements, fmt.Sprintf("comment %q", rule.Counter))
+	}
+	return strings.Join(statements, " ")
+}
@@ -0,0 +1,256 @@
+// Package packetfilter programs the rules the daemon owns in the network
+// namespace of router pods, with iptables or nftables.
+package packetfilter
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	IPTABLES = "iptables"
+	NFTABLES = "nftables"
+)
+
+// Family is the address family of a Ruleset, named as in nftables
+type Family string
+
+const (
+	FamilyIPv4 Family = "ip"
+	FamilyIPv6 Family = "ip6"
+	// FamilyInet is both families in one nftables table, for rulesets
+	// matching no address
+	FamilyInet Family = "inet"
+)
+
+// ChainType is what the chains of a Ruleset do, the iptables table they are
+// in
+type ChainType string
+
+const (
+	TypeNAT    ChainType = "nat"
+	TypeFilter ChainType = "filter"
+	// TypeRaw is entered ahead of conntrack, and TypeMangle ahead of
+	// routing, both filter chains in nftables
+	TypeRaw    ChainType = "raw"
+	TypeMangle ChainType = "mangle"
+)
+
+// Hook is the netfilter hook a Ruleset is entered from
+type Hook string
+
+const (
+	HookPrerouting  Hook = "prerouting"
+	HookForward     Hook = "forward"
+	HookPostrouting Hook = "postrouting"
+)
+
+// Ruleset is a set of chains the daemon owns, entered from a hook ahead of
+// the rules of the router itself. It is replaced as a whole.
+type Ruleset struct {
+	// Name names the nftables table holding the chains
+	Name   string
+	Family Family
+	Type   ChainType
+	Hook   Hook
+	// Entry selects the traffic of the hook jumping to the first chain
+	Entry Match
+	// Chains are the chains of the ruleset, the first one entered from the
+	// hook
+	Chains []Chain
+	// FlowTable is the flowtable of the ruleset connections are offloaded
+	// to, nftables only
+	FlowTable *FlowTable
+}
+
+// FlowTable forwards the packets of the connections offloaded to it from the
+// ingress of its devices, skipping the hooks
+type FlowTable struct {
+	Name    string
+	Devices []string
+	// Hardware has the devices forward the connections themselves
+	Hardware bool
+	// Counter keeps the conntrack counters of the connections up to date
+	Counter bool
+}
+
+// Chain is a chain of a Ruleset
+type Chain struct {
+	Name  string
+	Rules []Rule
+}
+
+// Match selects packets, each field ignored if left empty
+type Match struct {
+	// Source and Destination are networks in CIDR notation
+	Source       string
+	Destination  string
+	InInterface  string
+	OutInterface string
+	// Protocol is a layer 4 protocol, such as tcp or udp
+	Protocol string
+	Mark     int
+	// ConnMark is the mark of the connection of the packets
+	ConnMark int
+	// CtState is a conntrack state, such as new or invalid
+	CtState string
+	// TCPSyn matches the TCP packets opening a connection
+	TCPSyn bool
+	// ConnLimitAbove matches sources with more connections. nftables tracks
+	// them in a set named after the chain, so a chain has only one.
+	ConnLimitAbove int
+	// Limit matches the packets within the rate
+	Limit *RateLimit
+}
+
+// RateLimit is a rate in packets per second, and the packets matched at once
+// above it
+type RateLimit struct {
+	Rate  int
+	Burst int
+}
+
+// Rule is a match and the one action taken on the packets matched
+type Rule struct {
+	Match
+	// Jump is the chain the packets continue in
+	Jump string
+	// SNAT is the address the packets are source NATed to
+	SNAT string
+	// HashSourceMark marks the packets by the hash of their source address
+	HashSourceMark *HashMark
+	// Drop drops the packets, Return has them continue in the calling chain
+	Drop   bool
+	Return bool
+	// Counter names the packet counter of the rule, read with Counters
+	Counter string
+	// Log sends the packets to userspace through NFLOG, to continue in the
+	// chain
+	Log *NFLog
+	// FlowOffload offloads the connection of the packets to the flowtable of
+	// the ruleset named, nftables only
+	FlowOffload string
+	// SetConnMark marks the connection of the packets, and RestoreConnMark
+	// marks the packets with the mark of their connection
+	SetConnMark     int
+	RestoreConnMark bool
+	// CtOriginalZone tracks the connections of the packets in the conntrack
+	// zone in their original direction, replies being looked up in the
+	// default zone. Raw chains only.
+	CtOriginalZone int
+}
+
+// NFLog is the netlink group packets are logged to, with a prefix telling
+// which rule logged them
+type NFLog struct {
+	Group  int
+	Prefix string
+}
+
+// HashMark sets the mark to Offset plus the hash modulo Mod
+type HashMark struct {
+	Mod    int
+	Offset int
+}
+
+// Backend programs rulesets with a packet filter.
+type Backend interface {
+	// Name is the name of the packet filter, IPTABLES or NFTABLES
+	Name() string
+	// Compile renders the ruleset into the input of the restore tool of the
+	// packet filter.
+	Compile(ruleset *Ruleset) string
+	// Apply replaces the ruleset in the network namespace of the process.
+	Apply(pid int, ruleset *Ruleset) error
+	// Delete removes the ruleset from the network namespace of the process,
+	// if there.
+	Delete(pid int, ruleset *Ruleset) error
+	// Counters returns the packets matched by each named rule of the ruleset
+	// in the network namespace of the process.
+	Counters(pid int, ruleset *Ruleset) (map[string]uint64, error)
+}
+
+// New returns the backend of the packet filter, IPTABLES or NFTABLES.
+func New(name string) (Backend, error) {
+	switch name {
+	case IPTABLES:
+		return &iptablesBackend{}, nil
+	case NFTABLES:
+		return &nftablesBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown packet filter %q", name)
+}
+
+// run runs the command in the network namespace of the process, feeding it
+// the input
+var run = func(pid int, input string, command string, args ...string) error {
+	cmd := exec.Command("nsenter", append([]string{"-t", strconv.Itoa(pid), "-n", command}, args...)...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// output runs the command in the network namespace of the process, returning
+// what it prints
+var output = func(pid int, command string, args ...string) ([]byte, error) {
+	return exec.Command("nsenter", append([]string{"-t", strconv.Itoa(pid), "-n", command}, args...)...).Output()
+}
+
+// RollbackError is returned by ApplyAll when a ruleset failed to apply and
+// the rulesets applied before it were put back as they were.
+type RollbackError struct {
+	// Ruleset is the ruleset failing to apply
+	Ruleset *Ruleset
+	Err     error
+	// RollbackErr is why putting the previous rulesets back failed, if it did
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("applying %s %s failed: %v, and rolling back failed: %v", e.Ruleset.Family, e.Ruleset.Name, e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("applying %s %s failed, rolled back: %v", e.Ruleset.Family, e.Ruleset.Name, e.Err)
+}
+
+// ApplyAll replaces the rulesets in the network namespace of the process as
+// one change. Each ruleset is loaded atomically by the restore tool, so a
+// failing one is left as it was; the rulesets applied before it are put back
+// to their previous ruleset of the same family and name, or deleted if there
+// was none, and a *RollbackError is returned.
+func ApplyAll(b Backend, pid int, rulesets []*Ruleset, previous []*Ruleset) error {
+	for i, ruleset := range rulesets {
+		err := b.Apply(pid, ruleset)
+		if err == nil {
+			continue
+		}
+		rollback := &RollbackError{Ruleset: ruleset, Err: err}
+		for _, applied := range rulesets[:i] {
+			if last := findRuleset(previous, applied); last != nil {
+				err = b.Apply(pid, last)
+			} else {
+				err = b.Delete(pid, applied)
+			}
+			if err != nil && rollback.RollbackErr == nil {
+				rollback.RollbackErr = err
+			}
+		}
+		return rollback
+	}
+	return nil
+}
+
+func findRuleset(rulesets []*Ruleset, ruleset *Ruleset) *Ruleset {
+	for _, r := range rulesets {
+		if r.Family == ruleset.Family && r.Name == ruleset.Name {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+package packetfilter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the same rulesets are compiled by both backends
+var compileTests = []struct {
+	name     string
+	ruleset  *Ruleset
+	expected map[string]string
+}{
+	{
+		name: "snat by hashed source",
+		ruleset: &Ruleset{
+			Name:   "vr_snat_pool",
+			Family: FamilyIPv4,
+			Type:   TypeNAT,
+			Hook:   HookPostrouting,
+			Entry:  Match{OutInterface: "ethext"},
+			Chains: []Chain{
+				{Name: "vr_snat_pool", Rules: []Rule{
+					{Match: Match{Source: "10.0.0.0/24"}, Jump: "vr_snat_pool_map"},
+				}},
+				{Name: "vr_snat_pool_map", Rules: []Rule{
+					{HashSourceMark: &HashMark{Mod: 2, Offset: 0x1000}},
+					{Match: Match{Mark: 0x1000}, SNAT: "192.168.9.10"},
+					{Match: Match{Mark: 0x1001}, SNAT: "192.168.9.11"},
+				}},
+			},
+		},
+		expected: map[string]string{
+			IPTABLES: `*nat
+:vr_snat_pool - [0:0]
+:vr_snat_pool_map - [0:0]
+-A vr_snat_pool -s 10.0.0.0/24 -j vr_snat_pool_map
+-A vr_snat_pool_map -j HMARK --hmark-tuple src --hmark-mod 2 --hmark-offset 0x1000
+-A vr_snat_pool_map -m mark --mark 0x1000 -j SNAT --to-source 192.168.9.10
+-A vr_snat_pool_map -m mark --mark 0x1001 -j SNAT --to-source 192.168.9.11
+COMMIT
+`,
+			NFTABLES: `table ip vr_snat_pool
+delete table ip vr_snat_pool
+table ip vr_snat_pool {
+	chain postrouting {
+		type nat hook postrouting priority 99; policy accept;
+		oifname "ethext" jump vr_snat_pool
+	}
+	chain vr_snat_pool {
+		ip saddr 10.0.0.0/24 jump vr_snat_pool_map
+	}
+	chain vr_snat_pool_map {
+		meta mark set jhash ip saddr mod 2 offset 0x1000
+		meta mark 0x1000 snat to 192.168.9.10
+		meta mark 0x1001 snat to 192.168.9.11
+	}
+}
+`,
+		},
+	},
+	{
+		name: "filter drops",
+		ruleset: &Ruleset{
+			Name:   "vr_hardening",
+			Family: FamilyIPv4,
+			Type:   TypeFilter,
+			Hook:   HookForward,
+			Chains: []Chain{
+				{Name: "vr_hardening", Rules: []Rule{
+					{Match: Match{CtState: "invalid"}, Drop: true, Counter: "invalid"},
+					{Match: Match{CtState: "new", ConnLimitAbove: 100}, Drop: true, Counter: "connlimit"},
+					{Match: Match{TCPSyn: true, Limit: &RateLimit{Rate: 50, Burst: 100}}, Return: true},
+					{Match: Match{TCPSyn: true}, Drop: true, Counter: "syn-flood"},
+				}},
+			},
+		},
+		expected: map[string]string{
+			IPTABLES: `*filter
+:vr_hardening - [0:0]
+-A vr_hardening -m conntrack --ctstate INVALID -m comment --comment invalid -j DROP
+-A vr_hardening -m conntrack --ctstate NEW -m connlimit --connlimit-above 100 -m comment --comment connlimit -j DROP
+-A vr_hardening -p tcp --syn -m limit --limit 50/second --limit-burst 100 -j RETURN
+-A vr_hardening -p tcp --syn -m comment --comment syn-flood -j DROP
+COMMIT
+`,
+			NFTABLES: `table ip vr_hardening
+delete table ip vr_hardening
+table ip vr_hardening {
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_hardening
+	}
+	set vr_hardening_connlimit { type ipv4_addr; flags dynamic; }
+	chain vr_hardening {
+		ct state invalid counter drop comment "invalid"
+		ct state new add @vr_hardening_connlimit { ip saddr ct count over 100 } counter drop comment "connlimit"
+		tcp flags & (fin|syn|rst|ack) == syn limit rate 50/second burst 100 packets return
+		tcp flags & (fin|syn|rst|ack) == syn counter drop comment "syn-flood"
+	}
+}
+`,
+		},
+	},
+	{
+		name: "filter logs",
+		ruleset: &Ruleset{
+			Name:   "vr_fwlog",
+			Family: FamilyIPv4,
+			Type:   TypeFilter,
+			Hook:   HookForward,
+			Chains: []Chain{
+				{Name: "vr_fwlog", Rules: []Rule{
+					{Match: Match{Source: "10.0.0.0/24", Destination: "8.8.8.8/32", Protocol: "tcp", Limit: &RateLimit{Rate: 10, Burst: 10}}, Log: &NFLog{Group: 100, Prefix: "vrfw:1"}},
+					{Match: Match{Source: "10.0.0.0/24", Destination: "8.8.8.8/32", Protocol: "tcp"}, Return: true},
+				}},
+			},
+		},
+		expected: map[string]string{
+			IPTABLES: `*filter
+:vr_fwlog - [0:0]
+-A vr_fwlog -s 10.0.0.0/24 -d 8.8.8.8/32 -p tcp -m limit --limit 10/second --limit-burst 10 -j NFLOG --nflog-group 100 --nflog-prefix vrfw:1
+-A vr_fwlog -s 10.0.0.0/24 -d 8.8.8.8/32 -p tcp -j RETURN
+COMMIT
+`,
+			NFTABLES: `table ip vr_fwlog
+delete table ip vr_fwlog
+table ip vr_fwlog {
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_fwlog
+	}
+	chain vr_fwlog {
+		ip saddr 10.0.0.0/24 ip daddr 8.8.8.8/32 meta l4proto tcp limit rate 10/second burst 10 packets log prefix "vrfw:1" group 100
+		ip saddr 10.0.0.0/24 ip daddr 8.8.8.8/32 meta l4proto tcp return
+	}
+}
+`,
+		},
+	},
+	{
+		name: "connections marked by input interface",
+		ruleset: &Ruleset{
+			Name:   "vr_vrf_mark",
+			Family: FamilyIPv4,
+			Type:   TypeMangle,
+			Hook:   HookPrerouting,
+			Chains: []Chain{
+				{Name: "vr_vrf_mark", Rules: []Rule{
+					{Match: Match{InInterface: "int-a"}, SetConnMark: 0x200a},
+					{Match: Match{InInterface: "ethext", ConnMark: 0x200a}, RestoreConnMark: true},
+				}},
+			},
+		},
+		expected: map[string]string{
+			IPTABLES: `*mangle
+:vr_vrf_mark - [0:0]
+-A vr_vrf_mark -i int-a -j CONNMARK --set-mark 0x200a
+-A vr_vrf_mark -i ethext -m connmark --mark 0x200a -j CONNMARK --restore-mark
+COMMIT
+`,
+			NFTABLES: `table ip vr_vrf_mark
+delete table ip vr_vrf_mark
+table ip vr_vrf_mark {
+	chain prerouting {
+		type filter hook prerouting priority -151; policy accept;
+		jump vr_vrf_mark
+	}
+	chain vr_vrf_mark {
+		iifname "int-a" ct mark set 0x200a
+		iifname "ethext" ct mark 0x200a meta mark set ct mark
+	}
+}
+`,
+		},
+	},
+	{
+		name: "conntrack zones",
+		ruleset: &Ruleset{
+			Name:   "vr_vrf_zone",
+			Family: FamilyIPv4,
+			Type:   TypeRaw,
+			Hook:   HookPrerouting,
+			Chains: []Chain{
+				{Name: "vr_vrf_zone", Rules: []Rule{
+					{Match: Match{InInterface: "int-a"}, CtOriginalZone: 10},
+				}},
+			},
+		},
+		expected: map[string]string{
+			IPTABLES: `*raw
+:vr_vrf_zone - [0:0]
+-A vr_vrf_zone -i int-a -j CT --zone-orig 10
+COMMIT
+`,
+			NFTABLES: `table ip vr_vrf_zone
+delete table ip vr_vrf_zone
+table ip vr_vrf_zone {
+	chain prerouting {
+		type filter hook prerouting priority -301; policy accept;
+		jump vr_vrf_zone
+	}
+	chain vr_vrf_zone {
+		iifname "int-a" ct original zone set 10
+	}
+}
+`,
+		},
+	},
+	{
+		name: "ipv6 empty chain",
+		ruleset: &Ruleset{
+			Name:   "vr_test",
+			Family: FamilyIPv6,
+			Type:   TypeNAT,
+			Hook:   HookPostrouting,
+			Chains: []Chain{{Name: "vr_test"}},
+		},
+		expected: map[string]string{
+			IPTABLES: `*nat
+:vr_test - [0:0]
+COMMIT
+`,
+			NFTABLES: `table ip6 vr_test
+delete table ip6 vr_test
+table ip6 vr_test {
+	chain postrouting {
+		type nat hook postrouting priority 99; policy accept;
+		jump vr_test
+	}
+	chain vr_test {
+	}
+}
+`,
+		},
+	},
+	{
+		name: "flowtable offload",
+		ruleset: &Ruleset{
+			Name:   "vr_flowoffload",
+			Family: FamilyInet,
+			Type:   TypeFilter,
+			Hook:   HookForward,
+			Chains: []Chain{
+				{Name: "vr_flowoffload", Rules: []Rule{
+					{Match: Match{Protocol: "tcp", CtState: "established"}, FlowOffload: "vr_flowtable"},
+					{Match: Match{Protocol: "udp", CtState: "established"}, FlowOffload: "vr_flowtable"},
+				}},
+			},
+			FlowTable: &FlowTable{Name: "vr_flowtable", Devices: []string{"ethint", "ethext"}, Hardware: true, Counter: true},
+		},
+		// iptables has no flowtables
+		expected: map[string]string{
+			NFTABLES: `table inet vr_flowoffload
+delete table inet vr_flowoffload
+table inet vr_flowoffload {
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_flowoffload
+	}
+	flowtable vr_flowtable {
+		hook ingress priority 0; devices = { "ethint", "ethext" };
+		flags offload;
+		counter
+	}
+	chain vr_flowoffload {
+		meta l4proto tcp ct state established flow add @vr_flowtable
+		meta l4proto udp ct state established flow add @vr_flowtable
+	}
+}
+`,
+		},
+	},
+}
+
+func TestCompile(t *testing.T) {
+	for _, name := range []string{IPTABLES, NFTABLES} {
+		backend, err := New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range compileTests {
+			if _, exist := test.expected[name]; !exist {
+				continue
+			}
+			if compiled := backend.Compile(test.ruleset); compiled != test.expected[name] {
+				t.Errorf("%s, %s: expected\n%s\ngot\n%s", name, test.name, test.expected[name], compiled)
+			}
+		}
+	}
+}
+
+func TestApplyAndDelete(t *testing.T) {
+	ruleset := compileTests[0].ruleset
+	tests := []struct {
+		backend  string
+		apply    []string
+		delete   []string
+		existing bool
+	}{
+		{
+			backend: IPTABLES,
+			apply: []string{
+				"iptables-restore --noflush",
+				"iptables -t nat -C POSTROUTING -o ethext -j vr_snat_pool",
+				"iptables -t nat -I POSTROUTING 1 -o ethext -j vr_snat_pool",
+			},
+			delete: []string{
+				"iptables -t nat -D POSTROUTING -o ethext -j vr_snat_pool",
+				"iptables -t nat -F vr_snat_pool",
+				"iptables -t nat -F vr_snat_pool_map",
+				"iptables -t nat -X vr_snat_pool",
+				"iptables -t nat -X vr_snat_pool_map",
+			},
+		},
+		{
+			backend: NFTABLES,
+			apply:   []string{"nft -f -"},
+			delete:  []string{"nft -f -"},
+		},
+	}
+	defer func(original func(int, string, string, ...string) error) { run = original }(run)
+	for _, test := range tests {
+		var commands []string
+		run = func(pid int, input string, command string, args ...string) error {
+			commands = append(commands, strings.Join(append([]string{command}, args...), " "))
+			// the jump isn't there yet
+			if len(args) > 2 && args[2] == "-C" {
+				return errNotFound
+			}
+			return nil
+		}
+		backend, _ := New(test.backend)
+		if err := backend.Apply(1, ruleset); err != nil {
+			t.Errorf("%s: apply: %v", test.backend, err)
+		}
+		if !reflect.DeepEqual(commands, test.apply) {
+			t.Errorf("%s: expected apply commands %q, got %q", test.backend, test.apply, commands)
+		}
+		commands = nil
+		if err := backend.Delete(1, ruleset); err != nil {
+			t.Errorf("%s: delete: %v", test.backend, err)
+		}
+		if !reflect.DeepEqual(commands, test.delete) {
+			t.Errorf("%s: expected delete commands %q, got %q", test.backend, test.delete, commands)
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	ruleset := compileTests[1].ruleset
+	outputs := map[string]string{
+		IPTABLES: `# Generated by iptables-save
+*filter
+:FORWARD ACCEPT [0:0]
+:vr_hardening - [0:0]
+[12:720] -A FORWARD -j vr_hardening
+[3:180] -A vr_hardening -m conntrack --ctstate INVALID -m comment --comment invalid -j DROP
+[0:0] -A vr_hardening -m conntrack --ctstate NEW -m connlimit --connlimit-above 100 --connlimit-mask 32 --connlimit-saddr -m comment --comment connlimit -j DROP
+[40:2400] -A vr_hardening -p tcp -m tcp --tcp-flags FIN,SYN,RST,ACK SYN -m limit --limit 50/sec --limit-burst 100 -j RETURN
+[7:420] -A vr_hardening -p tcp -m tcp --tcp-flags FIN,SYN,RST,ACK SYN -m comment --comment syn-flood -j DROP
+[9:540] -A forward_fwrule -m comment --comment invalid -j DROP
+COMMIT
+`,
+		NFTABLES: `table ip vr_hardening {
+	set vr_hardening_connlimit {
+		type ipv4_addr
+		size 65535
+		flags dynamic
+	}
+
+	chain forward {
+		type filter hook forward priority -1; policy accept;
+		jump vr_hardening
+	}
+
+	chain vr_hardening {
+		ct state invalid counter packets 3 bytes 180 drop comment "invalid"
+		ct state new add @vr_hardening_connlimit { ip saddr ct count over 100 } counter packets 0 bytes 0 drop comment "connlimit"
+		tcp flags & (fin | syn | rst | ack) == syn limit rate 50/second burst 100 packets return
+		tcp flags & (fin | syn | rst | ack) == syn counter packets 7 bytes 420 drop comment "syn-flood"
+	}
+}
+`,
+	}
+	expected := map[string]uint64{"invalid": 3, "connlimit": 0, "syn-flood": 7}
+	defer func(original func(int, string, ...string) ([]byte, error)) { output = original }(output)
+	for _, name := range []string{IPTABLES, NFTABLES} {
+		output = func(pid int, command string, args ...string) ([]byte, error) {
+			return []byte(outputs[name]), nil
+		}
+		backend, _ := New(name)
+		counters, err := backend.Counters(1, ruleset)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(counters, expected) {
+			t.Errorf("%s: expected counters %v, got %v", name, expected, counters)
+		}
+	}
+}
+
+// recordingBackend records the rulesets applied and deleted, failing to
+// apply those of a family
+type recordingBackend struct {
+	nftablesBackend
+	fail  Family
+	calls []string
+}
+
+func (b *recordingBackend) Apply(pid int, ruleset *Ruleset) error {
+	if ruleset.Family == b.fail {
+		return errors.New("nft: syntax error")
+	}
+	b.calls = append(b.calls, fmt.Sprintf("apply %s %s %d", ruleset.Family, ruleset.Name, len(ruleset.Chains[0].Rules)))
+	return nil
+}
+
+func (b *recordingBackend) Delete(pid int, ruleset *Ruleset) error {
+	b.calls = append(b.calls, fmt.Sprintf("delete %s %s", ruleset.Family, ruleset.Name))
+	return nil
+}
+
+func TestApplyAll(t *testing.T) {
+	ruleset := func(family Family, rules int) *Ruleset {
+		return &Ruleset{Name: "vr_hardening", Family: family, Chains: []Chain{{Name: "vr_hardening", Rules: make([]Rule, rules)}}}
+	}
+	tests := map[string]struct {
+		rulesets, previous []*Ruleset
+		fail               Family
+		expected           []string
+		rolledBack         bool
+	}{
+		"applied": {
+			rulesets: []*Ruleset{ruleset(FamilyIPv4, 2), ruleset(FamilyIPv6, 2)},
+			previous: []*Ruleset{ruleset(FamilyIPv4, 1), ruleset(FamilyIPv6, 1)},
+			expected: []string{"apply ip vr_hardening 2", "apply ip6 vr_hardening 2"},
+		},
+		"rolled back to the previous rules": {
+			rulesets:   []*Ruleset{ruleset(FamilyIPv4, 2), ruleset(FamilyIPv6, 2)},
+			previous:   []*Ruleset{ruleset(FamilyIPv4, 1), ruleset(FamilyIPv6, 1)},
+			fail:       FamilyIPv6,
+			expected:   []string{"apply ip vr_hardening 2", "apply ip vr_hardening 1"},
+			rolledBack: true,
+		},
+		"rolled back without previous rules": {
+			rulesets:   []*Ruleset{ruleset(FamilyIPv4, 2), ruleset(FamilyIPv6, 2)},
+			fail:       FamilyIPv6,
+			expected:   []string{"apply ip vr_hardening 2", "delete ip vr_hardening"},
+			rolledBack: true,
+		},
+		"nothing applied before the failure": {
+			rulesets:   []*Ruleset{ruleset(FamilyIPv4, 2)},
+			previous:   []*Ruleset{ruleset(FamilyIPv4, 1)},
+			fail:       FamilyIPv4,
+			rolledBack: true,
+		},
+	}
+	for name, test := range tests {
+		backend := &recordingBackend{fail: test.fail}
+		err := ApplyAll(backend, 1, test.rulesets, test.previous)
+		if rollback, ok := err.(*RollbackError); ok != test.rolledBack || err != nil && !ok {
+			t.Errorf("%s: unexpected error %v", name, err)
+		} else if ok && rollback.RollbackErr != nil {
+			t.Errorf("%s: unexpected rollback error %v", name, rollback.RollbackErr)
+		}
+		if !reflect.DeepEqual(backend.calls, test.expected) {
+			t.Errorf("%s: expected %q, got %q", name, test.expected, backend.calls)
+		}
+	}
+}
+
+var errNotFound = errors.New("iptables: Bad rule (does a matching rule exist in that chain?).")
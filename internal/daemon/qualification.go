@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	remoteNetlink "github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// NodeRequirements are what a node is checked for before it is labeled
+// ready to run routers.
+type NodeRequirements struct {
+	// KernelModules must be loaded, or built in for those the feature
+	// matrix probes
+	KernelModules []string
+	// Sysctls must have the values, by dotted name
+	Sysctls map[string]string
+	// Interfaces must be present, such as the uplinks the bridges are on,
+	// an empty name being an uplink the node isn't annotated with
+	Interfaces []string
+}
+
+// moduleFeatures are the modules the feature matrix tells are available
+// whether loaded as modules or built in
+var moduleFeatures = map[string]internalNetlink.Feature{
+	"ip_vs":     internalNetlink.FeatureIPVS,
+	"wireguard": internalNetlink.FeatureWireGuard,
+	"vrf":       internalNetlink.FeatureVRF,
+	"nf_tables": internalNetlink.FeatureNftables,
+}
+
+var (
+	// moduleLoaded reports whether the kernel module is loaded
+	moduleLoaded = func(module string) bool {
+		_, err := os.Stat("/sys/module/" + module)
+		return err == nil
+	}
+	// readSysctl returns the value of the sysctl of the node
+	readSysctl = func(name string) (string, error) {
+		path, err := sysctlPath(name)
+		if err != nil {
+			return "", err
+		}
+		value, err := ioutil.ReadFile(path)
+		return strings.TrimSpace(string(value)), err
+	}
+	// linkExists reports whether the node has the interface
+	linkExists = func(name string) bool {
+		_, err := remoteNetlink.LinkByName(name)
+		return err == nil
+	}
+)
+
+// QualifyNode checks the node meets the requirements, and returns those it
+// doesn't, sorted. Nothing is loaded or set to meet them.
+func QualifyNode(requirements NodeRequirements, features internalNetlink.FeatureMatrix) []string {
+	var unmet []string
+	for _, module := range requirements.KernelModules {
+		if feature, ok := moduleFeatures[module]; (ok && features.Supports(feature)) || moduleLoaded(module) {
+			continue
+		}
+		unmet = append(unmet, fmt.Sprintf("kernel module %s is not loaded", module))
+	}
+	for name, expected := range requirements.Sysctls {
+		value, err := readSysctl(name)
+		if err != nil {
+			unmet = append(unmet, fmt.Sprintf("sysctl %s is missing", name))
+		} else if value != expected {
+			unmet = append(unmet, fmt.Sprintf("sysctl %s is %s, not %s", name, value, expected))
+		}
+	}
+	for _, name := range requirements.Interfaces {
+		if name == "" {
+			unmet = append(unmet, "uplink interface is not annotated")
+		} else if !linkExists(name) {
+			unmet = append(unmet, fmt.Sprintf("interface %s is missing", name))
+		}
+	}
+	sort.Strings(unmet)
+	return unmet
+}
+
+// LabelNodeQualification labels the node ready to run routers if it meets
+// every requirement, and not ready otherwise, with the requirements unmet in
+// an annotation.
+func LabelNodeQualification(kubeclientset kubernetes.Interface, nodeName string, unmet []string) error {
+	var reason interface{}
+	if len(unmet) > 0 {
+		reason = strings.Join(unmet, "; ")
+		klog.InfoS("Node is not ready to run routers", "node", nodeName, "reason", reason)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				virtualroutermanager.ROUTER_READY_NODE_LABEL: fmt.Sprintf("%t", len(unmet) == 0),
+			},
+			"annotations": map[string]interface{}{
+				virtualroutermanager.ROUTER_READY_REASON_ANNOTATION: reason,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = kubeclientset.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// ParseSysctls parses sysctl requirements given as name=value pairs
+// separated by commas.
+func ParseSysctls(value string) (map[string]string, error) {
+	sysctls := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid sysctl %q, expected name=value", pair)
+		}
+		sysctls[parts[0]] = parts[1]
+	}
+	return sysctls, nil
+}
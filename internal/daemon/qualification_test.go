@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+func TestQualifyNode(t *testing.T) {
+	defer func(loaded func(string) bool, read func(string) (string, error), exists func(string) bool) {
+		moduleLoaded, readSysctl, linkExists = loaded, read, exists
+	}(moduleLoaded, readSysctl, linkExists)
+	moduleLoaded = func(module string) bool { return module == "br_netfilter" }
+	sysctls := map[string]string{"net.ipv4.ip_forward": "1", "net.bridge.bridge-nf-call-iptables": "0"}
+	readSysctl = func(name string) (string, error) {
+		if value, ok := sysctls[name]; ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("no such file or directory")
+	}
+	linkExists = func(name string) bool { return name == "eth0" }
+
+	requirements := NodeRequirements{
+		KernelModules: []string{"br_netfilter", "ip_vs", "wireguard"},
+		Sysctls:       map[string]string{"net.ipv4.ip_forward": "1"},
+		Interfaces:    []string{"eth0", "eth1"},
+	}
+	// wireguard may be built in, which the feature matrix tells
+	features := internalNetlink.FeatureMatrix{internalNetlink.FeatureWireGuard: true}
+	expected := []string{"interface eth1 is missing", "kernel module ip_vs is not loaded"}
+	if unmet := QualifyNode(requirements, features); !reflect.DeepEqual(unmet, expected) {
+		t.Errorf("expected %v, got %v", expected, unmet)
+	}
+
+	requirements = NodeRequirements{
+		Sysctls:    map[string]string{"net.bridge.bridge-nf-call-iptables": "1", "net.ipv4.conf.all.rp_filter": "0"},
+		Interfaces: []string{"eth0", ""},
+	}
+	expected = []string{"sysctl net.bridge.bridge-nf-call-iptables is 0, not 1", "sysctl net.ipv4.conf.all.rp_filter is missing", "uplink interface is not annotated"}
+	if unmet := QualifyNode(requirements, features); !reflect.DeepEqual(unmet, expected) {
+		t.Errorf("expected %v, got %v", expected, unmet)
+	}
+}
+
+func TestParseSysctls(t *testing.T) {
+	sysctls, err := ParseSysctls("net.ipv4.ip_forward=1, net.ipv6.conf.all.forwarding=1,")
+	if err != nil || !reflect.DeepEqual(sysctls, map[string]string{"net.ipv4.ip_forward": "1", "net.ipv6.conf.all.forwarding": "1"}) {
+		t.Errorf("expected both sysctls, got %v, %v", sysctls, err)
+	}
+	if _, err := ParseSysctls("net.ipv4.ip_forward"); err == nil {
+		t.Error("expected a sysctl without a value refused")
+	}
+}
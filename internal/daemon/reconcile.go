@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+)
+
+// reconcileKey asks for the host links and the interfaces of every attached
+// router container to be converged to what attaching them set up
+type reconcileKey struct{}
+
+var (
+	// reconcileHost converges the host links, returning the containers to be
+	// attached again
+	reconcileHost = internalNetlink.ReconcileHost
+	// checkContainerLinks returns why the interfaces of a container are not
+	// as attaching set them up
+	checkContainerLinks = internalNetlink.CheckContainerLinks
+)
+
+// Reconcile compares the host links and the interfaces of the attached router
+// containers with what Initialize and attaching them set up, and converges
+// them. Links deleted by hand or lost in a reboot of the network are set up
+// again. It returns the router containers only attaching them again brings
+// back, sorted, whose applied spec is forgotten so their next sync applies it
+// all.
+func (n *NetworkDaemon) Reconcile() ([]string, error) {
+	containerNames := map[string]string{}
+	var containers []string
+	externalVFs := map[string]bool{}
+	for containerName, spec := range n.runnigState {
+		containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+		if containerID == "" {
+			continue
+		}
+		containerNames[containerID[:7]] = containerName
+		containers = append(containers, containerID[:7])
+		if spec.ExternalSRIOV != nil {
+			externalVFs[containerID[:7]] = true
+		}
+	}
+	sort.Strings(containers)
+
+	reattach, err := reconcileHost(n.netlinkCfg, containers, externalVFs)
+	if err != nil {
+		klog.ErrorS(err, "Reconciling host links failed")
+		return nil, err
+	}
+	broken := map[string]bool{}
+	for _, container := range reattach {
+		broken[containerNames[container]] = true
+	}
+	for _, container := range containers {
+		containerName := containerNames[container]
+		if broken[containerName] {
+			continue
+		}
+		containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+		containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+		if containerPid <= 0 {
+			continue
+		}
+		spec := n.runnigState[containerName]
+		if err := checkContainerLinks(containerPid, spec.InternalIP, spec.ExternalIP); err != nil {
+			klog.InfoS("Router container interfaces drifted", "containerName", containerName, "reason", err.Error())
+			broken[containerName] = true
+		}
+	}
+
+	var containerNamesBroken []string
+	for containerName := range broken {
+		n.forgetAppliedSpec(containerName)
+		containerNamesBroken = append(containerNamesBroken, containerName)
+	}
+	sort.Strings(containerNamesBroken)
+	return containerNamesBroken, nil
+}
+
+// forgetAppliedSpec has the next sync of the router container attach it
+// again and apply all of its spec, as for a container seen the first time.
+func (n *NetworkDaemon) forgetAppliedSpec(containerName string) {
+	delete(n.runnigState, containerName)
+	for vlan, containerNames := range n.vlanUse {
+		for i, name := range containerNames {
+			if name == containerName {
+				n.vlanUse[vlan] = append(containerNames[:i], containerNames[i+1:]...)
+				break
+			}
+		}
+	}
+	// the container may have another MAC to announce now
+	delete(n.announced, containerName)
+	delete(n.macAddresses, containerName)
+}
+
+// reconcileDataPlane converges the data plane of the node, and attaches the
+// router pods whose containers need it again.
+func (c *Controller) reconcileDataPlane() error {
+	containerNames, err := c.networkDaemon.Reconcile()
+	if err != nil || len(containerNames) == 0 {
+		return err
+	}
+	reattach := map[string]bool{}
+	for _, containerName := range containerNames {
+		reattach[containerName] = true
+	}
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		desc, exist := c.networkDaemon.pod2containerMap[pod.Name]
+		if !exist || !reattach[desc.containerName] {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(pod)
+		if err != nil {
+			return err
+		}
+		klog.InfoS("Attaching router pod again", "pod", key)
+		c.workqueue.Add(podKey(key))
+	}
+	return nil
+}
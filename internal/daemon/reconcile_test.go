@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestForgetAppliedSpec(t *testing.T) {
+	n := NewDaemon(nil, nil, "")
+	n.runnigState["test"] = &v1.VirtualRouterSpec{VlanNumber: 10}
+	n.runnigState["other"] = &v1.VirtualRouterSpec{VlanNumber: 10}
+	n.vlanUse[10] = []string{"other", "test"}
+	n.announced["test"] = []string{"192.168.9.10"}
+
+	n.forgetAppliedSpec("test")
+	if _, exist := n.runnigState["test"]; exist {
+		t.Errorf("expected the applied spec forgotten")
+	}
+	if _, exist := n.announced["test"]; exist {
+		t.Errorf("expected the announcement forgotten")
+	}
+	// the next sync adds the container to its vlan again
+	if expected := []string{"other"}; !reflect.DeepEqual(n.vlanUse[10], expected) {
+		t.Errorf("expected vlan users %v, got %v", expected, n.vlanUse[10])
+	}
+}
@@ -0,0 +1,239 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netns"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// SLA_PROBE_PATH_ROUTER is the path from the probe endpoint through the
+	// router
+	SLA_PROBE_PATH_ROUTER string = "router"
+	// SLA_PROBE_PATH_DIRECT is the path from the node to the target, the
+	// baseline of the router path
+	SLA_PROBE_PATH_DIRECT string = "direct"
+
+	DEFAULT_SLA_PROBE_INTERVAL = time.Second
+)
+
+var (
+	slaProbeRTT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "virtualrouter_sla_probe_rtt_seconds",
+		Help:    "Round trip time of SLA probes to the target of a VirtualRouter, through the router or directly from the node.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"namespace", "virtualrouter", "path"})
+	slaProbeLost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtualrouter_sla_probe_lost_total",
+		Help: "SLA probes to the target of a VirtualRouter left unanswered within the probe interval.",
+	}, []string{"namespace", "virtualrouter", "path"})
+
+	// slaProbeID tells the echo replies of the probers apart
+	slaProbeID uint32
+)
+
+// RegisterMetrics registers the metrics of the daemon.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(slaProbeRTT, slaProbeLost, snatPoolPortUtilization, snatPoolConnections, hardeningDropped,
+		routerPacketsPerSecond, routerSessions, routerInterfaceErrors, fastPathPackets)
+}
+
+// slaProbeConfig is what a probe endpoint is set up and probes with.
+type slaProbeConfig struct {
+	namespace, name string
+	vlan            int
+	sourceIP        string
+	internalNetmask string
+	internalIP      string
+	target          string
+	interval        time.Duration
+}
+
+// slaProbeConfigFor returns how the VirtualRouter is to be probed, or nil if
+// it isn't.
+func slaProbeConfigFor(virtualrouter *v1.VirtualRouter) *slaProbeConfig {
+	probe := virtualrouter.Spec.SLAProbe
+	if probe == nil || probe.SourceIP == "" || virtualrouter.Spec.InternalIP == "" {
+		return nil
+	}
+	config := &slaProbeConfig{
+		namespace:       virtualrouter.Namespace,
+		name:            virtualrouter.Name,
+		vlan:            int(virtualrouter.Spec.VlanNumber),
+		sourceIP:        probe.SourceIP,
+		internalNetmask: virtualrouter.Spec.InternalNetmask,
+		internalIP:      virtualrouter.Spec.InternalIP,
+		target:          probe.Target,
+		interval:        DEFAULT_SLA_PROBE_INTERVAL,
+	}
+	if config.target == "" {
+		config.target = virtualrouter.Spec.GatewayIP
+	}
+	if config.target == "" {
+		return nil
+	}
+	if probe.Interval != nil && probe.Interval.Duration > 0 {
+		config.interval = probe.Interval.Duration
+	}
+	return config
+}
+
+// slaProber probes the target of a router from its probe endpoint and from
+// the node until stopped.
+type slaProber struct {
+	config      slaProbeConfig
+	containerID string
+	stopCh      chan struct{}
+}
+
+// EnsureSLAProbe starts, restarts or stops probing the router container of
+// the VirtualRouter on this node as its spec says.
+func (n *NetworkDaemon) EnsureSLAProbe(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	config := slaProbeConfigFor(virtualrouter)
+	if prober, exist := n.probes[containerName]; exist {
+		if config != nil && prober.config == *config {
+			return nil
+		}
+		n.StopSLAProbe(containerName)
+	}
+	if config == nil {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	probeNs, err := internalNetlink.SetProbeEndpoint(containerID, config.vlan, config.sourceIP, config.internalNetmask, config.internalIP, n.netlinkCfg)
+	if err != nil {
+		klog.ErrorS(err, "Setting probe endpoint failed", "containerName", containerName)
+		return err
+	}
+	prober := &slaProber{config: *config, containerID: containerID, stopCh: make(chan struct{})}
+	n.probes[containerName] = prober
+	go prober.run(probeNs)
+	klog.InfoS("SLA probe started", "containerName", containerName, "target", config.target)
+	return nil
+}
+
+// StopSLAProbe stops probing the router container and removes its probe
+// endpoint.
+func (n *NetworkDaemon) StopSLAProbe(containerName string) {
+	prober, exist := n.probes[containerName]
+	if !exist {
+		return
+	}
+	close(prober.stopCh)
+	delete(n.probes, containerName)
+	if err := internalNetlink.ClearProbeEndpoint(prober.containerID); err != nil {
+		klog.ErrorS(err, "Clearing probe endpoint failed", "containerName", containerName)
+	}
+	for _, path := range []string{SLA_PROBE_PATH_ROUTER, SLA_PROBE_PATH_DIRECT} {
+		slaProbeRTT.DeleteLabelValues(prober.config.namespace, prober.config.name, path)
+		slaProbeLost.DeleteLabelValues(prober.config.namespace, prober.config.name, path)
+	}
+	klog.InfoS("SLA probe stopped", "containerName", containerName)
+}
+
+func (p *slaProber) run(probeNs netns.NsHandle) {
+	defer probeNs.Close()
+
+	// sockets stay in the namespace they are opened in
+	var routerConn *icmp.PacketConn
+	if err := internalNetlink.RunInNs(probeNs, func() (err error) {
+		routerConn, err = icmp.ListenPacket("ip4:icmp", p.config.sourceIP)
+		return err
+	}); err != nil {
+		klog.ErrorS(err, "Opening SLA probe socket failed", "virtualrouter", p.config.namespace+"/"+p.config.name)
+		return
+	}
+	defer routerConn.Close()
+	directConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		klog.ErrorS(err, "Opening SLA probe socket failed", "virtualrouter", p.config.namespace+"/"+p.config.name)
+		return
+	}
+	defer directConn.Close()
+
+	target := &net.IPAddr{IP: net.ParseIP(p.config.target)}
+	id := (os.Getpid() + int(atomic.AddUint32(&slaProbeID, 1))) & 0xffff
+	ticker := time.NewTicker(p.config.interval)
+	defer ticker.Stop()
+	for seq := 0; ; seq = (seq + 1) & 0xffff {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+		// both paths are probed at once so they see the same network
+		results := make(chan struct{}, 2)
+		for path, conn := range map[string]*icmp.PacketConn{SLA_PROBE_PATH_ROUTER: routerConn, SLA_PROBE_PATH_DIRECT: directConn} {
+			go func(path string, conn *icmp.PacketConn) {
+				defer func() { results <- struct{}{} }()
+				rtt, err := echo(conn, target, id, seq, p.config.interval)
+				if err != nil {
+					klog.V(4).InfoS("SLA probe lost", "virtualrouter", p.config.namespace+"/"+p.config.name, "path", path, "err", err)
+					slaProbeLost.WithLabelValues(p.config.namespace, p.config.name, path).Inc()
+					return
+				}
+				slaProbeRTT.WithLabelValues(p.config.namespace, p.config.name, path).Observe(rtt.Seconds())
+			}(path, conn)
+		}
+		<-results
+		<-results
+	}
+}
+
+// echo sends an ICMP echo request to the target and waits for its reply,
+// returning the round trip time.
+func echo(conn *icmp.PacketConn, target net.Addr, id int, seq int, timeout time.Duration) (time.Duration, error) {
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("virtualrouter-sla-probe")},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	if _, err := conn.WriteTo(request, target); err != nil {
+		return 0, err
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	buffer := make([]byte, 1500)
+	for {
+		length, peer, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return 0, err
+		}
+		if peer.String() != target.String() {
+			continue
+		}
+		reply, err := icmp.ParseMessage(1, buffer[:length])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == id && body.Seq == seq {
+			return time.Since(start), nil
+		}
+	}
+}
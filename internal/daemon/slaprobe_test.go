@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestSLAProbeConfig(t *testing.T) {
+	newVirtualRouter := func(probe *v1.SLAProbe) *v1.VirtualRouter {
+		return &v1.VirtualRouter{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: v1.VirtualRouterSpec{
+				VlanNumber:      100,
+				InternalIP:      "10.0.0.1",
+				InternalNetmask: "255.255.255.0",
+				GatewayIP:       "192.168.9.1",
+				SLAProbe:        probe,
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		probe    *v1.SLAProbe
+		expected *slaProbeConfig
+	}{
+		{"not probed", nil, nil},
+		{"defaults", &v1.SLAProbe{SourceIP: "10.0.0.250"}, &slaProbeConfig{
+			namespace: "default", name: "test", vlan: 100, sourceIP: "10.0.0.250", internalNetmask: "255.255.255.0",
+			internalIP: "10.0.0.1", target: "192.168.9.1", interval: time.Second,
+		}},
+		{"target and interval", &v1.SLAProbe{SourceIP: "10.0.0.250", Target: "8.8.8.8", Interval: &metav1.Duration{Duration: 5 * time.Second}}, &slaProbeConfig{
+			namespace: "default", name: "test", vlan: 100, sourceIP: "10.0.0.250", internalNetmask: "255.255.255.0",
+			internalIP: "10.0.0.1", target: "8.8.8.8", interval: 5 * time.Second,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := slaProbeConfigFor(newVirtualRouter(test.probe))
+			if (config == nil) != (test.expected == nil) || (config != nil && *config != *test.expected) {
+				t.Errorf("expected config %+v, got %+v", test.expected, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	// SNAT_POOL_CHAIN is the nat chain selecting the traffic translated to
+	// the SNAT pool of a router
+	SNAT_POOL_CHAIN string = "vr_snat_pool"
+	// SNAT_POOL_MAP_CHAIN is the nat chain mapping a source to an address of
+	// the pool
+	SNAT_POOL_MAP_CHAIN string = "vr_snat_pool_map"
+	// SNAT_POOL_MARK_OFFSET is the first of the marks a source is hashed to
+	SNAT_POOL_MARK_OFFSET int = 0x1000
+	// SNAT_POOL_PORTS is how many source ports a pool address has for a
+	// single remote endpoint
+	SNAT_POOL_PORTS = 65535 - 1024 + 1
+)
+
+var (
+	snatPoolPortUtilization = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "virtualrouter_snat_pool_port_utilization",
+		Help: "Share of the source ports of a SNAT pool address in use towards its busiest remote endpoint. The pool is to be grown as it nears 1.",
+	}, []string{"namespace", "virtualrouter", "address"})
+	snatPoolConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "virtualrouter_snat_pool_connections",
+		Help: "Connections translated to a SNAT pool address.",
+	}, []string{"namespace", "virtualrouter", "address"})
+)
+
+// snatPoolKey asks for the SNAT pool metrics of every attached router pod to
+// be updated
+type snatPoolKey struct{}
+
+// conntrackList dumps the source NATed connections in the network namespace
+// of the process
+var conntrackList = func(pid int) ([]byte, error) {
+	return exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "conntrack", "-L", "-n").Output()
+}
+
+// snatPoolConfig is what the SNAT pool of a router is programmed with.
+type snatPoolConfig struct {
+	namespace, name string
+	// addresses are the pool addresses in CIDR notation, in allocation order
+	addresses []string
+	sources   []string
+	// vrfTables are the VRFs left to their own source NAT, their networks
+	// overlapping the sources
+	vrfTables []int
+}
+
+// snatPoolConfigFor returns how the SNAT pool of the VirtualRouter is to be
+// programmed, or nil if it has none allocated.
+func snatPoolConfigFor(virtualrouter *v1.VirtualRouter) *snatPoolConfig {
+	if virtualrouter.Spec.SNATPool == nil || len(virtualrouter.Status.SNATPoolAllocations) == 0 {
+		return nil
+	}
+	config := &snatPoolConfig{
+		namespace: virtualrouter.Namespace,
+		name:      virtualrouter.Name,
+		sources:   virtualrouter.Spec.SNATPool.Sources,
+		vrfTables: vrfTables(virtualrouter.Spec),
+	}
+	for _, allocation := range virtualrouter.Status.SNATPoolAllocations {
+		ip, _, err := net.ParseCIDR(allocation.Address)
+		if err != nil {
+			continue
+		}
+		// host addresses add no routes next to the external one
+		config.addresses = append(config.addresses, ip.String()+"/32")
+	}
+	if len(config.sources) == 0 {
+		if network := virtualroutermanager.InternalNetwork(virtualrouter.Spec); network != nil {
+			config.sources = []string{network.String()}
+		}
+	}
+	if len(config.addresses) == 0 || len(config.sources) == 0 {
+		return nil
+	}
+	return config
+}
+
+// snatPoolRuleset returns the nat chains of the pool, empty if there is none.
+// Sources are hashed to a mark per pool address, so a source keeps its
+// address as long as the pool does.
+func snatPoolRuleset(config *snatPoolConfig) *packetfilter.Ruleset {
+	ruleset := &packetfilter.Ruleset{
+		Name:   SNAT_POOL_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeNAT,
+		Hook:   packetfilter.HookPostrouting,
+		Entry:  packetfilter.Match{OutInterface: DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME},
+		Chains: []packetfilter.Chain{{Name: SNAT_POOL_CHAIN}, {Name: SNAT_POOL_MAP_CHAIN}},
+	}
+	if config == nil {
+		return ruleset
+	}
+	for _, table := range config.vrfTables {
+		ruleset.Chains[0].Rules = append(ruleset.Chains[0].Rules, packetfilter.Rule{
+			Match:  packetfilter.Match{ConnMark: VRF_EGRESS_MARK_OFFSET + table},
+			Return: true,
+		})
+	}
+	for _, source := range config.sources {
+		ruleset.Chains[0].Rules = append(ruleset.Chains[0].Rules, packetfilter.Rule{
+			Match: packetfilter.Match{Source: source},
+			Jump:  SNAT_POOL_MAP_CHAIN,
+		})
+	}
+	ruleset.Chains[1].Rules = append(ruleset.Chains[1].Rules, packetfilter.Rule{
+		HashSourceMark: &packetfilter.HashMark{Mod: len(config.addresses), Offset: SNAT_POOL_MARK_OFFSET},
+	})
+	for i, address := range config.addresses {
+		ip, _, _ := net.ParseCIDR(address)
+		ruleset.Chains[1].Rules = append(ruleset.Chains[1].Rules, packetfilter.Rule{
+			Match: packetfilter.Match{Mark: SNAT_POOL_MARK_OFFSET + i},
+			SNAT:  ip.String(),
+		})
+	}
+	return ruleset
+}
+
+// EnsureSNATPool programs the SNAT pool of the router container of the
+// VirtualRouter on this node as its spec and status say, and clears it once
+// the pool is gone. It is applied again on every call, as setting the
+// external address drops the pool addresses.
+func (n *NetworkDaemon) EnsureSNATPool(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	config := snatPoolConfigFor(virtualrouter)
+	applied, exist := n.snatPools[containerName]
+	if config == nil && !exist {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+
+	var appliedAddresses, addresses []string
+	if exist {
+		appliedAddresses = applied.addresses
+	}
+	if config != nil {
+		addresses = config.addresses
+	}
+	if err := internalNetlink.SetSecondaryAddresses2Container(containerPid, DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME, appliedAddresses, addresses); err != nil {
+		klog.ErrorS(err, "Setting SNAT pool addresses failed", "containerName", containerName)
+		return err
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		if err := backend.Delete(containerPid, snatPoolRuleset(nil)); err != nil {
+			klog.ErrorS(err, "Deleting SNAT pool rules failed", "containerName", containerName)
+			return err
+		}
+		n.clearSNATPool(containerName)
+		klog.InfoS("SNAT pool cleared", "containerName", containerName)
+		return nil
+	}
+	// the pool goes before the NAT rules of the router
+	if err := backend.Apply(containerPid, snatPoolRuleset(config)); err != nil {
+		klog.ErrorS(err, "Setting SNAT pool rules failed", "containerName", containerName)
+		return err
+	}
+	if exist && !reflect.DeepEqual(applied.addresses, config.addresses) {
+		n.clearSNATPool(containerName)
+	}
+	if _, exist := n.snatPools[containerName]; !exist {
+		klog.InfoS("SNAT pool set", "containerName", containerName, "addresses", config.addresses)
+	}
+	n.snatPools[containerName] = config
+	return nil
+}
+
+// clearSNATPool forgets the SNAT pool of the router container, and the
+// metrics of its addresses.
+func (n *NetworkDaemon) clearSNATPool(containerName string) {
+	config, exist := n.snatPools[containerName]
+	if !exist {
+		return
+	}
+	delete(n.snatPools, containerName)
+	for _, address := range config.addresses {
+		ip, _, _ := net.ParseCIDR(address)
+		snatPoolPortUtilization.DeleteLabelValues(config.namespace, config.name, ip.String())
+		snatPoolConnections.DeleteLabelValues(config.namespace, config.name, ip.String())
+	}
+}
+
+// snatPoolUsage is how a pool address is used by the translated connections.
+type snatPoolUsage struct {
+	connections int
+	// busiest is the most ports taken towards a single remote endpoint
+	busiest int
+}
+
+// parseSNATPoolUsage counts the connections of conntrack -L output translated
+// to each of the addresses. A source port of a pool address is taken once per
+// remote endpoint, which is what runs out first.
+func parseSNATPoolUsage(output string, addresses []string) map[string]snatPoolUsage {
+	usage := map[string]snatPoolUsage{}
+	ports := map[string]map[string]int{}
+	for _, address := range addresses {
+		usage[address] = snatPoolUsage{}
+		ports[address] = map[string]int{}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// the second tuple is the reply, coming back to the pool address
+		seen := map[string]int{}
+		reply := map[string]string{}
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			seen[kv[0]]++
+			if seen[kv[0]] == 2 {
+				reply[kv[0]] = kv[1]
+			}
+		}
+		remotes, exist := ports[reply["dst"]]
+		if !exist {
+			continue
+		}
+		u := usage[reply["dst"]]
+		u.connections++
+		remote := fields[0] + "/" + reply["src"] + "/" + reply["sport"]
+		remotes[remote]++
+		if remotes[remote] > u.busiest {
+			u.busiest = remotes[remote]
+		}
+		usage[reply["dst"]] = u
+	}
+	return usage
+}
+
+// exportSNATPoolMetrics updates the metrics of the SNAT pools of the attached
+// router pods from their connection tracking tables.
+func (n *NetworkDaemon) exportSNATPoolMetrics() error {
+	for containerName, config := range n.snatPools {
+		containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+		if containerID == "" {
+			continue
+		}
+		containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+		if containerPid <= 0 {
+			continue
+		}
+		output, err := conntrackList(containerPid)
+		if err != nil {
+			klog.ErrorS(err, "Listing connections failed", "containerName", containerName)
+			continue
+		}
+		var addresses []string
+		for _, address := range config.addresses {
+			ip, _, _ := net.ParseCIDR(address)
+			addresses = append(addresses, ip.String())
+		}
+		for address, usage := range parseSNATPoolUsage(string(output), addresses) {
+			snatPoolPortUtilization.WithLabelValues(config.namespace, config.name, address).Set(float64(usage.busiest) / SNAT_POOL_PORTS)
+			snatPoolConnections.WithLabelValues(config.namespace, config.name, address).Set(float64(usage.connections))
+		}
+	}
+	return nil
+}
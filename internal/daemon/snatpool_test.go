@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestParseSNATPoolUsage(t *testing.T) {
+	output := `tcp      6 431999 ESTABLISHED src=192.168.0.5 dst=8.8.8.8 sport=40000 dport=443 src=8.8.8.8 dst=10.0.0.10 sport=443 dport=40000 [ASSURED] mark=4096 use=1
+tcp      6 431999 ESTABLISHED src=192.168.0.6 dst=8.8.8.8 sport=40000 dport=443 src=8.8.8.8 dst=10.0.0.10 sport=443 dport=1024 [ASSURED] mark=4096 use=1
+tcp      6 117 TIME_WAIT src=192.168.0.6 dst=1.1.1.1 sport=40001 dport=443 src=1.1.1.1 dst=10.0.0.10 sport=443 dport=40001 [ASSURED] mark=4096 use=1
+udp      17 29 src=192.168.0.7 dst=8.8.8.8 sport=5353 dport=53 src=8.8.8.8 dst=10.0.0.11 sport=53 dport=5353 mark=4097 use=1
+udp      17 29 src=192.168.0.8 dst=8.8.8.8 sport=5353 dport=53 src=8.8.8.8 dst=172.16.0.1 sport=53 dport=5353 mark=0 use=1
+`
+	expected := map[string]snatPoolUsage{
+		"10.0.0.10": {connections: 3, busiest: 2},
+		"10.0.0.11": {connections: 1, busiest: 1},
+		"10.0.0.12": {},
+	}
+	if usage := parseSNATPoolUsage(output, []string{"10.0.0.10", "10.0.0.11", "10.0.0.12"}); !reflect.DeepEqual(usage, expected) {
+		t.Errorf("expected usage %v, got %v", expected, usage)
+	}
+}
+
+func TestSNATPoolRuleset(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "192.168.0.1",
+			InternalNetmask: "255.255.255.0",
+			SNATPool:        &v1.SNATPool{Pool: "10.0.0.0/24", Size: 2},
+		},
+		Status: v1.VirtualRouterStatus{
+			SNATPoolAllocations: []v1.IPAMAllocation{
+				{Pool: "10.0.0.0/24", Address: "10.0.0.10/24"},
+				{Pool: "10.0.0.0/24", Address: "10.0.0.11/24"},
+			},
+		},
+	}
+	// the rules are compared as iptables renders them, the backends are
+	// tested against each other in the packetfilter package
+	backend, err := packetfilter.New(packetfilter.IPTABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `*nat
+:vr_snat_pool - [0:0]
+:vr_snat_pool_map - [0:0]
+-A vr_snat_pool -s 192.168.0.0/24 -j vr_snat_pool_map
+-A vr_snat_pool_map -j HMARK --hmark-tuple src --hmark-mod 2 --hmark-offset 0x1000
+-A vr_snat_pool_map -m mark --mark 0x1000 -j SNAT --to-source 10.0.0.10
+-A vr_snat_pool_map -m mark --mark 0x1001 -j SNAT --to-source 10.0.0.11
+COMMIT
+`
+	if rules := backend.Compile(snatPoolRuleset(snatPoolConfigFor(virtualRouter))); rules != expected {
+		t.Errorf("expected rules\n%s\ngot\n%s", expected, rules)
+	}
+
+	// a removed pool empties the chains
+	virtualRouter.Spec.SNATPool = nil
+	if config := snatPoolConfigFor(virtualRouter); config != nil {
+		t.Errorf("expected no SNAT pool, got %+v", config)
+	}
+	if rules := backend.Compile(snatPoolRuleset(nil)); rules != "*nat\n:vr_snat_pool - [0:0]\n:vr_snat_pool_map - [0:0]\nCOMMIT\n" {
+		t.Errorf("expected empty chains, got\n%s", rules)
+	}
+}
@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+var (
+	// readEnviron reads the environment of the process
+	readEnviron = func(pid int) ([]byte, error) {
+		return ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/environ")
+	}
+	// setVF sets up a virtual function as the external interface of the
+	// container
+	setVF = internalNetlink.SetVF2Container
+)
+
+// allocatedVF returns the PCI address of the first virtual function of the
+// resource the device plugin allocated to the process, as it tells it in its
+// environment.
+func allocatedVF(pid int, resourceName string) (string, error) {
+	environ, err := readEnviron(pid)
+	if err != nil {
+		return "", err
+	}
+	prefix := internalNetlink.PCIDeviceEnv(resourceName) + "="
+	for _, env := range bytes.Split(environ, []byte{0}) {
+		if !bytes.HasPrefix(env, []byte(prefix)) {
+			continue
+		}
+		addresses := strings.Split(strings.TrimPrefix(string(env), prefix), ",")
+		if addresses[0] != "" {
+			return addresses[0], nil
+		}
+	}
+	return "", fmt.Errorf("no virtual function of %s is allocated", resourceName)
+}
+
+// vfConfig returns how the virtual function of the PCI address is set up
+// for the spec.
+func vfConfig(pciAddress string, sriov *v1.SRIOV) internalNetlink.VF {
+	vf := internalNetlink.VF{
+		PCIAddress: pciAddress,
+		VLAN:       int(sriov.VLAN),
+		SpoofCheck: sriov.SpoofCheck == nil || *sriov.SpoofCheck,
+	}
+	// validated by the controller
+	vf.MAC, _ = net.ParseMAC(sriov.MAC)
+	return vf
+}
+
+// ConnectVF attaches the SR-IOV virtual function allocated to the container
+// as its external interface, or sets it up again for the spec once attached.
+func (n *NetworkDaemon) ConnectVF(containerName string, sriov *v1.SRIOV) error {
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		klog.Errorf("Wrong Pid(%d) value of Container(%s)", containerPid, containerName)
+		return fmt.Errorf("internal error")
+	}
+
+	pciAddress, err := allocatedVF(containerPid, sriov.ResourceName)
+	if err != nil {
+		klog.ErrorS(err, "Finding the VF of the container failed", "ContainerName", containerName)
+		return err
+	}
+	if err := setVF(containerPid, vfConfig(pciAddress, sriov)); err != nil {
+		klog.ErrorS(err, "Set VF to Container failed", "ContainerName", containerName, "pciAddress", pciAddress)
+		return err
+	}
+	return nil
+}
+
+// externalVFPlan tells the virtual function the external interface is, if
+// any.
+func externalVFPlan(sriov *v1.SRIOV) string {
+	if sriov == nil {
+		return ""
+	}
+	return fmt.Sprintf(" as a VF of %s, vlan %d", sriov.ResourceName, sriov.VLAN)
+}
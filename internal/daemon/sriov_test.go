@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestAllocatedVF(t *testing.T) {
+	defer func(original func(int) ([]byte, error)) { readEnviron = original }(readEnviron)
+	readEnviron = func(pid int) ([]byte, error) {
+		return []byte("PATH=/usr/bin\x00PCIDEVICE_INTEL_COM_SRIOV_NETDEVICE=0000:03:02.1,0000:03:02.2\x00"), nil
+	}
+	if address, err := allocatedVF(1, "intel.com/sriov_netdevice"); err != nil || address != "0000:03:02.1" {
+		t.Errorf("expected the first VF 0000:03:02.1, got %q, %v", address, err)
+	}
+	if _, err := allocatedVF(1, "mellanox.com/cx5_sriov"); err == nil {
+		t.Error("expected no VF of a resource not allocated")
+	}
+
+	readEnviron = func(pid int) ([]byte, error) { return nil, fmt.Errorf("no such process") }
+	if _, err := allocatedVF(1, "intel.com/sriov_netdevice"); err == nil {
+		t.Error("expected an error without the environment")
+	}
+}
+
+func TestVFConfig(t *testing.T) {
+	vf := vfConfig("0000:03:02.1", &v1.SRIOV{ResourceName: "intel.com/sriov_netdevice", VLAN: 100})
+	if vf.VLAN != 100 || !vf.SpoofCheck || vf.MAC != nil {
+		t.Errorf("expected vlan 100 with spoof check and the MAC of the VF, got %+v", vf)
+	}
+	spoofCheck := false
+	vf = vfConfig("0000:03:02.1", &v1.SRIOV{ResourceName: "intel.com/sriov_netdevice", MAC: "52:54:00:12:34:56", SpoofCheck: &spoofCheck})
+	if mac, _ := net.ParseMAC("52:54:00:12:34:56"); vf.SpoofCheck || vf.MAC.String() != mac.String() {
+		t.Errorf("expected the MAC without spoof check, got %+v", vf)
+	}
+
+	applied := v1.VirtualRouterSpec{ExternalSRIOV: &v1.SRIOV{ResourceName: "intel.com/sriov_netdevice", VLAN: 100}}
+	spec := v1.VirtualRouterSpec{ExternalSRIOV: &v1.SRIOV{ResourceName: "intel.com/sriov_netdevice", VLAN: 200}}
+	if !diffSpec(&applied, spec).externalVF {
+		t.Error("expected the VF set up again for another vlan")
+	}
+	if diffSpec(&applied, v1.VirtualRouterSpec{}).externalVF {
+		t.Error("expected routers leaving SR-IOV to be rolled out rather than set up again")
+	}
+}
@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// The traffic metrics are labelled with the router pod, for the custom
+// metrics adapter to serve them as metrics of the pod to autoscalers.
+var (
+	routerPacketsPerSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: virtualroutermanager.PACKETS_PER_SECOND_METRIC,
+		Help: "Packets per second a router pod receives on its internal and external interfaces together.",
+	}, []string{"namespace", "pod"})
+	routerSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: virtualroutermanager.SESSIONS_METRIC,
+		Help: "Connections tracked by a router pod.",
+	}, []string{"namespace", "pod"})
+	routerInterfaceErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: virtualroutermanager.INTERFACE_ERRORS_METRIC,
+		Help: "Errors and drops of the internal or external interface of a router pod since it was attached.",
+	}, []string{"namespace", "pod", "interface"})
+)
+
+// trafficKey asks for the traffic metrics of every attached router pod to be
+// updated
+type trafficKey struct{}
+
+var (
+	// routerPacketsReceived reads the packets a router container received
+	routerPacketsReceived = internalNetlink.RouterPacketsReceived
+	// readInterfaceErrors reads the errors of the interfaces of a router
+	// container
+	readInterfaceErrors = internalNetlink.RouterInterfaceErrors
+	// conntrackSessions reads the number of tracked connections in the
+	// network namespace of the process
+	conntrackSessions = func(pid int) (int, error) {
+		out, err := exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "conntrack", "-C").Output()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(strings.TrimSpace(string(out)))
+	}
+)
+
+// packetSample is the packet counter of a router pod at a time, none if
+// at is zero
+type packetSample struct {
+	packets uint64
+	at      time.Time
+}
+
+// packetRate returns the packets per second between two samples, false
+// without a previous one or if the counter went back, as it does when the
+// interfaces are created again.
+func packetRate(previous packetSample, current packetSample) (float64, bool) {
+	elapsed := current.at.Sub(previous.at).Seconds()
+	if previous.at.IsZero() || current.packets < previous.packets || elapsed <= 0 {
+		return 0, false
+	}
+	return float64(current.packets-previous.packets) / elapsed, true
+}
+
+// exportTrafficMetrics updates the packet rate, interface errors and tracked
+// connections of every attached router pod of the node. The packet rate of a
+// pod is first exported at its second sample.
+func (c *Controller) exportTrafficMetrics() error {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	n := c.networkDaemon
+	samples := map[types.NamespacedName]packetSample{}
+	defer func() { n.trafficSamples = samples }()
+	for _, pod := range pods {
+		desc, exist := n.pod2containerMap[pod.Name]
+		if !exist || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		containerID := internalCrio.GetContainerIDFromContainerName(desc.containerName, n.crioCfg)
+		if containerID == "" {
+			continue
+		}
+		containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+		if containerPid <= 0 {
+			continue
+		}
+
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		packets, err := routerPacketsReceived(containerID[:7])
+		if err != nil {
+			klog.ErrorS(err, "Reading packet counters failed", "pod", name.String())
+			samples[name] = packetSample{}
+		} else {
+			current := packetSample{packets: packets, at: time.Now()}
+			if rate, ok := packetRate(n.trafficSamples[name], current); ok {
+				routerPacketsPerSecond.WithLabelValues(pod.Namespace, pod.Name).Set(rate)
+			}
+			samples[name] = current
+			if fast, slow, attached, err := n.fastPathPacketsOf(desc.containerName, packets); err != nil {
+				klog.ErrorS(err, "Reading fast path counters failed", "pod", name.String())
+			} else if attached {
+				fastPathPackets.WithLabelValues(pod.Namespace, pod.Name, "fast").Set(float64(fast))
+				fastPathPackets.WithLabelValues(pod.Namespace, pod.Name, "slow").Set(float64(slow))
+			} else {
+				fastPathPackets.DeleteLabelValues(pod.Namespace, pod.Name, "fast")
+				fastPathPackets.DeleteLabelValues(pod.Namespace, pod.Name, "slow")
+			}
+		}
+		if errors, err := readInterfaceErrors(containerID[:7]); err != nil {
+			klog.ErrorS(err, "Reading interface errors failed", "pod", name.String())
+		} else {
+			for iface, count := range errors {
+				routerInterfaceErrors.WithLabelValues(pod.Namespace, pod.Name, iface).Set(float64(count))
+			}
+		}
+		sessions, err := conntrackSessions(containerPid)
+		if err != nil {
+			klog.ErrorS(err, "Counting tracked connections failed", "pod", name.String())
+			continue
+		}
+		routerSessions.WithLabelValues(pod.Namespace, pod.Name).Set(float64(sessions))
+	}
+	// pods gone from the node take their series with them
+	for name := range n.trafficSamples {
+		if _, exist := samples[name]; !exist {
+			routerPacketsPerSecond.DeleteLabelValues(name.Namespace, name.Name)
+			routerSessions.DeleteLabelValues(name.Namespace, name.Name)
+			fastPathPackets.DeleteLabelValues(name.Namespace, name.Name, "fast")
+			fastPathPackets.DeleteLabelValues(name.Namespace, name.Name, "slow")
+			for _, iface := range []string{"internal", "external"} {
+				routerInterfaceErrors.DeleteLabelValues(name.Namespace, name.Name, iface)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketRate(t *testing.T) {
+	at := time.Date(2021, time.November, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		previous packetSample
+		current  packetSample
+		rate     float64
+		ok       bool
+	}{
+		{"first sample", packetSample{}, packetSample{packets: 1000, at: at}, 0, false},
+		{"rate", packetSample{packets: 1000, at: at}, packetSample{packets: 31000, at: at.Add(15 * time.Second)}, 2000, true},
+		{"interfaces created again", packetSample{packets: 1000, at: at}, packetSample{packets: 10, at: at.Add(15 * time.Second)}, 0, false},
+	}
+	for _, test := range tests {
+		rate, ok := packetRate(test.previous, test.current)
+		if rate != test.rate || ok != test.ok {
+			t.Errorf("%s: expected (%v, %t), got (%v, %t)", test.name, test.rate, test.ok, rate, ok)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// VRF_ZONE_CHAIN is the raw chain tracking the connections of each VRF
+	// in a conntrack zone of its own, so overlapping networks do not share
+	// connections
+	VRF_ZONE_CHAIN string = "vr_vrf_zone"
+	// VRF_MARK_CHAIN is the mangle chain marking the connections of each VRF,
+	// and the replies to them so they are routed back into it
+	VRF_MARK_CHAIN string = "vr_vrf_mark"
+	// VRF_SNAT_CHAIN is the nat chain translating the connections of the VRFs
+	// to the external address
+	VRF_SNAT_CHAIN string = "vr_vrf_snat"
+	// VRF_EGRESS_MARK_OFFSET is added to the table of a VRF for the mark of
+	// its connections
+	VRF_EGRESS_MARK_OFFSET int = 0x2000
+)
+
+// vrfEgress is a VRF of a router reaching out of the external interface.
+type vrfEgress struct {
+	table int
+	// links are the interfaces of the internal networks in the VRF
+	links []string
+}
+
+// vrfEgressConfig is what the egress of the VRFs of a router is programmed
+// with.
+type vrfEgressConfig struct {
+	externalIP string
+	// vrfs are ordered by table
+	vrfs []vrfEgress
+}
+
+// vrfTables returns the tables of the VRFs of the internal networks of the
+// spec, in order.
+func vrfTables(virtualrouterSpec v1.VirtualRouterSpec) []int {
+	var tables []int
+	seen := map[int]bool{}
+	for _, network := range internalNetworks(virtualrouterSpec) {
+		if network.VRFTable != 0 && !seen[network.VRFTable] {
+			seen[network.VRFTable] = true
+			tables = append(tables, network.VRFTable)
+		}
+	}
+	sort.Ints(tables)
+	return tables
+}
+
+// vrfEgressConfigFor returns how the egress of the VRFs of the VirtualRouter
+// is to be programmed, or nil if none of its internal networks is in a VRF.
+func vrfEgressConfigFor(virtualrouter *v1.VirtualRouter) *vrfEgressConfig {
+	tables := vrfTables(virtualrouter.Spec)
+	if len(tables) == 0 || virtualrouter.Spec.ExternalIP == "" {
+		return nil
+	}
+	config := &vrfEgressConfig{externalIP: virtualrouter.Spec.ExternalIP}
+	for _, table := range tables {
+		vrf := vrfEgress{table: table}
+		for _, network := range internalNetworks(virtualrouter.Spec) {
+			if network.VRFTable == table {
+				vrf.links = append(vrf.links, network.Link)
+			}
+		}
+		config.vrfs = append(config.vrfs, vrf)
+	}
+	return config
+}
+
+// vrfEgressRulesets returns the raw, mangle and nat chains of the egress of
+// the VRFs, empty if there is none. The connections are marked by the link
+// they come in on, as the nat chain can no longer tell, and translated to
+// the external address by their mark.
+func vrfEgressRulesets(config *vrfEgressConfig) []*packetfilter.Ruleset {
+	zone := &packetfilter.Ruleset{
+		Name:   VRF_ZONE_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeRaw,
+		Hook:   packetfilter.HookPrerouting,
+		Chains: []packetfilter.Chain{{Name: VRF_ZONE_CHAIN}},
+	}
+	mark := &packetfilter.Ruleset{
+		Name:   VRF_MARK_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeMangle,
+		Hook:   packetfilter.HookPrerouting,
+		Chains: []packetfilter.Chain{{Name: VRF_MARK_CHAIN}},
+	}
+	snat := &packetfilter.Ruleset{
+		Name:   VRF_SNAT_CHAIN,
+		Family: packetfilter.FamilyIPv4,
+		Type:   packetfilter.TypeNAT,
+		Hook:   packetfilter.HookPostrouting,
+		Entry:  packetfilter.Match{OutInterface: DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME},
+		Chains: []packetfilter.Chain{{Name: VRF_SNAT_CHAIN}},
+	}
+	if config == nil {
+		return []*packetfilter.Ruleset{zone, mark, snat}
+	}
+	for _, vrf := range config.vrfs {
+		for _, link := range vrf.links {
+			zone.Chains[0].Rules = append(zone.Chains[0].Rules, packetfilter.Rule{
+				Match:          packetfilter.Match{InInterface: link},
+				CtOriginalZone: vrf.table,
+			})
+			mark.Chains[0].Rules = append(mark.Chains[0].Rules, packetfilter.Rule{
+				Match:       packetfilter.Match{InInterface: link},
+				SetConnMark: VRF_EGRESS_MARK_OFFSET + vrf.table,
+			})
+		}
+		// replies are looked up in the table of the VRF by their mark
+		mark.Chains[0].Rules = append(mark.Chains[0].Rules, packetfilter.Rule{
+			Match:           packetfilter.Match{InInterface: DEFAULT_VIRTURALROUTER_EXTERNAL_INTERFACE_NAME, ConnMark: VRF_EGRESS_MARK_OFFSET + vrf.table},
+			RestoreConnMark: true,
+		})
+		snat.Chains[0].Rules = append(snat.Chains[0].Rules, packetfilter.Rule{
+			Match: packetfilter.Match{ConnMark: VRF_EGRESS_MARK_OFFSET + vrf.table},
+			SNAT:  config.externalIP,
+		})
+	}
+	return []*packetfilter.Ruleset{zone, mark, snat}
+}
+
+// EnsureVRFEgress programs the source NAT of the VRFs of the router container
+// of the VirtualRouter on this node, next to the default routes Sync puts in
+// their tables, and clears it once no network is in a VRF. Like the SNAT
+// pool, it is applied again on every call.
+func (n *NetworkDaemon) EnsureVRFEgress(virtualrouter *v1.VirtualRouter) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	config := vrfEgressConfigFor(virtualrouter)
+	applied, exist := n.vrfEgress[containerName]
+	if config == nil && !exist {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	backend, err := n.packetFilter()
+	if err != nil {
+		return err
+	}
+
+	if config == nil {
+		for _, ruleset := range vrfEgressRulesets(nil) {
+			if err := backend.Delete(containerPid, ruleset); err != nil {
+				klog.ErrorS(err, "Deleting VRF egress rules failed", "containerName", containerName, "ruleset", ruleset.Name)
+				return err
+			}
+		}
+		delete(n.vrfEgress, containerName)
+		klog.InfoS("VRF egress cleared", "containerName", containerName)
+		return nil
+	}
+	var previous []*packetfilter.Ruleset
+	if exist {
+		previous = vrfEgressRulesets(applied)
+	}
+	if err := packetfilter.ApplyAll(backend, containerPid, vrfEgressRulesets(config), previous); err != nil {
+		klog.ErrorS(err, "Setting VRF egress rules failed", "containerName", containerName)
+		return err
+	}
+	if !exist {
+		klog.InfoS("VRF egress set", "containerName", containerName, "externalIP", config.externalIP)
+	}
+	n.vrfEgress[containerName] = config
+	return nil
+}
@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/daemon/packetfilter"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestVRFEgressRulesets(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			InternalIP:      "10.0.0.1",
+			InternalNetmask: "255.255.255.0",
+			ExternalIP:      "192.168.9.10",
+			InternalNetworks: []v1.InternalNetwork{
+				{Name: "tenant-b", VlanNumber: 102, CIDR: "10.0.0.1/24", VRFTable: 11},
+				{Name: "tenant-a", VlanNumber: 101, CIDR: "10.0.0.1/24", VRFTable: 10},
+				{Name: "tenant-a2", VlanNumber: 103, CIDR: "10.1.0.1/24", VRFTable: 10},
+				{Name: "shared", VlanNumber: 104, CIDR: "10.2.0.1/24"},
+			},
+		},
+	}
+	// the rules are compared as iptables renders them, the backends are
+	// tested against each other in the packetfilter package
+	backend, err := packetfilter.New(packetfilter.IPTABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, ruleset := range vrfEgressRulesets(vrfEgressConfigFor(virtualRouter)) {
+		rules = append(rules, backend.Compile(ruleset))
+	}
+	expected := []string{`*raw
+:vr_vrf_zone - [0:0]
+-A vr_vrf_zone -i int-tenant-a -j CT --zone-orig 10
+-A vr_vrf_zone -i int-tenant-a2 -j CT --zone-orig 10
+-A vr_vrf_zone -i int-tenant-b -j CT --zone-orig 11
+COMMIT
+`, `*mangle
+:vr_vrf_mark - [0:0]
+-A vr_vrf_mark -i int-tenant-a -j CONNMARK --set-mark 0x200a
+-A vr_vrf_mark -i int-tenant-a2 -j CONNMARK --set-mark 0x200a
+-A vr_vrf_mark -i ethext -m connmark --mark 0x200a -j CONNMARK --restore-mark
+-A vr_vrf_mark -i int-tenant-b -j CONNMARK --set-mark 0x200b
+-A vr_vrf_mark -i ethext -m connmark --mark 0x200b -j CONNMARK --restore-mark
+COMMIT
+`, `*nat
+:vr_vrf_snat - [0:0]
+-A vr_vrf_snat -m connmark --mark 0x200a -j SNAT --to-source 192.168.9.10
+-A vr_vrf_snat -m connmark --mark 0x200b -j SNAT --to-source 192.168.9.10
+COMMIT
+`}
+	if joined := strings.Join(rules, ""); joined != strings.Join(expected, "") {
+		t.Errorf("expected rules\n%s\ngot\n%s", strings.Join(expected, ""), joined)
+	}
+
+	// the SNAT pool leaves the VRFs to their own source NAT
+	virtualRouter.Spec.SNATPool = &v1.SNATPool{Pool: "192.168.9.0/24", Size: 1, Sources: []string{"10.0.0.0/24"}}
+	virtualRouter.Status.SNATPoolAllocations = []v1.IPAMAllocation{{Pool: "192.168.9.0/24", Address: "192.168.9.20/24"}}
+	pool := backend.Compile(snatPoolRuleset(snatPoolConfigFor(virtualRouter)))
+	if !strings.Contains(pool, "-A vr_snat_pool -m connmark --mark 0x200a -j RETURN\n-A vr_snat_pool -m connmark --mark 0x200b -j RETURN\n-A vr_snat_pool -s 10.0.0.0/24") {
+		t.Errorf("expected the VRFs to return ahead of the pool sources, got\n%s", pool)
+	}
+
+	// routers without VRFs have none of it
+	virtualRouter.Spec.InternalNetworks = virtualRouter.Spec.InternalNetworks[3:]
+	if config := vrfEgressConfigFor(virtualRouter); config != nil {
+		t.Errorf("expected no VRF egress, got %+v", config)
+	}
+	if rules := backend.Compile(vrfEgressRulesets(nil)[2]); rules != "*nat\n:vr_vrf_snat - [0:0]\nCOMMIT\n" {
+		t.Errorf("expected an empty chain, got\n%s", rules)
+	}
+}
@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	internalCrio "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/crio"
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+// wireGuardKey asks for the latest WireGuard handshakes of every attached
+// router pod to be exported
+type wireGuardKey struct{}
+
+// wireGuardFor returns how the wg interface of the VirtualRouter is to be
+// programmed with the private key, or nil if it has no VPN. The packets of
+// the VPN leave the external interface through the router table.
+func wireGuardFor(virtualrouter *v1.VirtualRouter, privateKey string) *internalNetlink.WireGuard {
+	spec := virtualrouter.Spec.WireGuard
+	if spec == nil {
+		return nil
+	}
+	wireGuard := &internalNetlink.WireGuard{
+		PrivateKey: privateKey,
+		ListenPort: int(virtualroutermanager.WireGuardListenPort(spec)),
+		FwMark:     DEFAULT_MASK_NUMBER,
+		Address:    spec.Address,
+	}
+	for _, peer := range spec.Peers {
+		wireGuard.Peers = append(wireGuard.Peers, internalNetlink.WireGuardPeer{
+			PublicKey:           peer.PublicKey,
+			Endpoint:            peer.Endpoint,
+			AllowedIPs:          peer.AllowedIPs,
+			PersistentKeepalive: int(peer.PersistentKeepalive),
+		})
+	}
+	return wireGuard
+}
+
+// EnsureWireGuard programs the wg interface of the router container of the
+// VirtualRouter on this node with the private key, and removes it once the
+// VPN is gone. Unlike the SNAT pool, it is only applied on changes.
+func (n *NetworkDaemon) EnsureWireGuard(virtualrouter *v1.VirtualRouter, privateKey string) error {
+	containerName := virtualrouter.Name
+	if _, exist := n.runnigState[containerName]; !exist {
+		return nil
+	}
+	wireGuard := wireGuardFor(virtualrouter, privateKey)
+	applied, exist := n.wireGuards[containerName]
+	if wireGuard == nil && !exist || exist && reflect.DeepEqual(applied, wireGuard) {
+		return nil
+	}
+
+	containerID := internalCrio.GetContainerIDFromContainerName(containerName, n.crioCfg)
+	if containerID == "" {
+		klog.Errorf("There is no running container with ContainerName: %s", containerName)
+		return fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return fmt.Errorf("wrong pid(%d) of container %s", containerPid, containerName)
+	}
+	if err := internalNetlink.SetWireGuard2Container(containerPid, wireGuard, DEFAULT_TABLE_NUMBER); err != nil {
+		klog.ErrorS(err, "Setting WireGuard failed", "containerName", containerName)
+		return err
+	}
+	if wireGuard == nil {
+		delete(n.wireGuards, containerName)
+		klog.InfoS("WireGuard cleared", "containerName", containerName)
+		return nil
+	}
+	n.wireGuards[containerName] = wireGuard
+	klog.InfoS("WireGuard set", "containerName", containerName, "peers", len(wireGuard.Peers))
+	return nil
+}
+
+// WireGuardHandshakes returns the latest handshakes of the router pod, false
+// if the pod isn't attached or has no VPN.
+func (n *NetworkDaemon) WireGuardHandshakes(podName string) (virtualroutermanager.WireGuardHandshakes, bool, error) {
+	desc, exist := n.pod2containerMap[podName]
+	if !exist || n.wireGuards[desc.containerName] == nil {
+		return nil, false, nil
+	}
+	containerID := internalCrio.GetContainerIDFromContainerName(desc.containerName, n.crioCfg)
+	if containerID == "" {
+		return nil, true, fmt.Errorf("no running container found")
+	}
+	containerPid := internalCrio.GetContainerPid(containerID, n.crioCfg)
+	if containerPid <= 0 {
+		return nil, true, fmt.Errorf("wrong pid(%d) of container %s", containerPid, desc.containerName)
+	}
+	handshakes, err := internalNetlink.WireGuardHandshakes(containerPid)
+	if err != nil {
+		return nil, true, err
+	}
+	return latestHandshakes(handshakes), true, nil
+}
+
+// latestHandshakes leaves out the peers never shaken hands with.
+func latestHandshakes(handshakes map[string]int64) virtualroutermanager.WireGuardHandshakes {
+	latest := virtualroutermanager.WireGuardHandshakes{}
+	for publicKey, handshake := range handshakes {
+		if handshake > 0 {
+			latest[publicKey] = handshake
+		}
+	}
+	return latest
+}
+
+// ensureWireGuard reads the private key of the router from the Secret the
+// controller generated it in, and programs the VPN with it.
+func (c *Controller) ensureWireGuard(virtualRouter *v1.VirtualRouter) error {
+	var privateKey string
+	if virtualRouter.Spec.WireGuard != nil {
+		namespace, name := virtualroutermanager.RouterNamespace(virtualRouter), virtualroutermanager.WireGuardSecretName(virtualRouter)
+		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		privateKey = string(secret.Data[virtualroutermanager.WIREGUARD_PRIVATE_KEY])
+		if privateKey == "" {
+			return fmt.Errorf("no WireGuard private key in secret %s/%s", namespace, name)
+		}
+	}
+	return c.networkDaemon.EnsureWireGuard(virtualRouter, privateKey)
+}
+
+// exportWireGuardHandshakes annotates every attached router pod of the node
+// with a VPN with its latest handshakes, for the controller to report.
+func (c *Controller) exportWireGuardHandshakes() error {
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		handshakes, attached, err := c.networkDaemon.WireGuardHandshakes(pod.Name)
+		if !attached {
+			continue
+		}
+		if err != nil {
+			klog.ErrorS(err, "Reading WireGuard handshakes failed", "pod", pod.Namespace+"/"+pod.Name)
+			continue
+		}
+		content, err := json.Marshal(handshakes)
+		if err != nil {
+			return err
+		}
+		if pod.GetAnnotations()[virtualroutermanager.WIREGUARD_HANDSHAKES_ANNOTATION] == string(content) {
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{virtualroutermanager.WIREGUARD_HANDSHAKES_ANNOTATION: string(content)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := c.kubeclientset.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internalNetlink "github.com/tmax-cloud/virtualrouter-controller/internal/daemon/netlink"
+	v1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func TestWireGuardConfig(t *testing.T) {
+	virtualRouter := &v1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.VirtualRouterSpec{
+			WireGuard: &v1.WireGuard{
+				Address: "10.100.0.1/24",
+				Peers: []v1.WireGuardPeer{
+					{PublicKey: "cGVlci1h", Endpoint: "203.0.113.1:51820", AllowedIPs: []string{"10.30.0.0/16", "10.31.0.0/16"}, PersistentKeepalive: 25},
+					{PublicKey: "cGVlci1i", AllowedIPs: []string{"10.32.0.0/16"}},
+				},
+			},
+		},
+	}
+	// the listen port defaults, and the VPN is sent through the router table
+	expected := `[Interface]
+PrivateKey = cHJpdmF0ZQ==
+ListenPort = 51820
+FwMark = 200
+
+[Peer]
+PublicKey = cGVlci1h
+AllowedIPs = 10.30.0.0/16, 10.31.0.0/16
+Endpoint = 203.0.113.1:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = cGVlci1i
+AllowedIPs = 10.32.0.0/16
+`
+	if config := internalNetlink.WireGuardConfig(wireGuardFor(virtualRouter, "cHJpdmF0ZQ==")); config != expected {
+		t.Errorf("expected config\n%s\ngot\n%s", expected, config)
+	}
+
+	virtualRouter.Spec.WireGuard = nil
+	if wireGuard := wireGuardFor(virtualRouter, ""); wireGuard != nil {
+		t.Errorf("expected no WireGuard, got %+v", wireGuard)
+	}
+}
+
+func TestLatestHandshakes(t *testing.T) {
+	latest := latestHandshakes(map[string]int64{"cGVlci1h": 1700000000, "cGVlci1i": 0})
+	if len(latest) != 1 || latest["cGVlci1h"] != 1700000000 {
+		t.Errorf("unexpected handshakes %v", latest)
+	}
+}
@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+const REDACTED string = "REDACTED"
+
+// ruleResources are the NFV rules applied by router pods, exported from the
+// namespace of each router
+var ruleResources = []schema.GroupVersionResource{
+	nfvv1.SchemeGroupVersion.WithResource("natrules"),
+	nfvv1.SchemeGroupVersion.WithResource("firewallrules"),
+	nfvv1.SchemeGroupVersion.WithResource("loadbalancerrules"),
+}
+
+// sensitiveKeys mark string fields whose value is never exported
+var sensitiveKeys = []string{"password", "secret", "token", "psk", "presharedkey", "privatekey"}
+
+// Sink stores a full snapshot of the exported configuration, keyed by the
+// relative path of each file.
+type Sink interface {
+	Store(files map[string][]byte, exportTime time.Time) error
+}
+
+// Exporter periodically renders the configuration of every VirtualRouter
+// and its rules, and hands it to a Sink as an as-built archive.
+type Exporter struct {
+	virtualRoutersLister listers.VirtualRouterLister
+	virtualRoutersSynced cache.InformerSynced
+	dynamicclient        dynamic.Interface
+	sink                 Sink
+	// namespaces, if set, are the only namespaces whose VirtualRouters are exported
+	namespaces sets.String
+	// controllerClass is the spec.controllerClass of the VirtualRouters
+	// exported
+	controllerClass string
+	clock           clock.Clock
+}
+
+func NewExporter(virtualRouterInformer informers.VirtualRouterInformer, dynamicclient dynamic.Interface, sink Sink, namespaces []string, controllerClass string) *Exporter {
+	return &Exporter{
+		virtualRoutersLister: virtualRouterInformer.Lister(),
+		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
+		dynamicclient:        dynamicclient,
+		sink:                 sink,
+		namespaces:           sets.NewString(namespaces...),
+		controllerClass:      controllerClass,
+		clock:                clock.RealClock{},
+	}
+}
+
+// Run exports every interval until stopCh is closed.
+func (e *Exporter) Run(interval time.Duration, stopCh <-chan struct{}) {
+	klog.Info("Starting configuration exporter")
+	if ok := cache.WaitForCacheSync(stopCh, e.virtualRoutersSynced); !ok {
+		klog.Error("failed to wait for caches to sync")
+		return
+	}
+	wait.Until(func() {
+		if err := e.Export(); err != nil {
+			klog.ErrorS(err, "Exporting configuration failed")
+		}
+	}, interval, stopCh)
+}
+
+func (e *Exporter) Export() error {
+	files, err := e.Render()
+	if err != nil {
+		return err
+	}
+	return e.sink.Store(files, e.clock.Now())
+}
+
+// Render returns the sanitized configuration of every VirtualRouter, under
+// <namespace>/<name>/ with one file for the VirtualRouter and one per kind of rule.
+func (e *Exporter) Render() (map[string][]byte, error) {
+	virtualRouters, err := e.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, virtualRouter := range virtualRouters {
+		if e.namespaces.Len() > 0 && !e.namespaces.Has(virtualRouter.Namespace) {
+			continue
+		}
+		if virtualRouter.Spec.ControllerClass != e.controllerClass {
+			continue
+		}
+		dir := path.Join(virtualRouter.Namespace, virtualRouter.Name)
+
+		virtualRouterCopy := virtualRouter.DeepCopy()
+		virtualRouterCopy.APIVersion = samplev1alpha1.SchemeGroupVersion.String()
+		virtualRouterCopy.Kind = "VirtualRouter"
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(virtualRouterCopy)
+		if err != nil {
+			return nil, err
+		}
+		if files[path.Join(dir, "virtualrouter.yaml")], err = renderDocuments([]map[string]interface{}{content}); err != nil {
+			return nil, err
+		}
+
+		// router rules live in the namespace of the router resources
+		for _, resource := range ruleResources {
+			list, err := e.dynamicclient.Resource(resource).Namespace(virtualroutermanager.RouterNamespace(virtualRouter)).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("listing %s of %s/%s: %v", resource.Resource, virtualRouter.Namespace, virtualRouter.Name, err)
+			}
+			if len(list.Items) == 0 {
+				continue
+			}
+			sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].GetName() < list.Items[j].GetName() })
+			var documents []map[string]interface{}
+			for _, item := range list.Items {
+				documents = append(documents, item.Object)
+			}
+			if files[path.Join(dir, resource.Resource+".yaml")], err = renderDocuments(documents); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+func renderDocuments(documents []map[string]interface{}) ([]byte, error) {
+	var rendered []string
+	for _, document := range documents {
+		out, err := yaml.Marshal(sanitize(document))
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, string(out))
+	}
+	return []byte(strings.Join(rendered, "---\n")), nil
+}
+
+// sanitize keeps what describes the configuration: the spec and the
+// identifying metadata. Status, server populated metadata and sensitive
+// values are left out.
+func sanitize(content map[string]interface{}) map[string]interface{} {
+	obj := (&unstructured.Unstructured{Object: content}).DeepCopy()
+	sanitized := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	sanitized.SetAPIVersion(obj.GetAPIVersion())
+	sanitized.SetKind(obj.GetKind())
+	sanitized.SetName(obj.GetName())
+	sanitized.SetNamespace(obj.GetNamespace())
+	sanitized.SetLabels(obj.GetLabels())
+	annotations := obj.GetAnnotations()
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	sanitized.SetAnnotations(annotations)
+	if spec, ok := obj.Object["spec"]; ok {
+		sanitized.Object["spec"] = redact(spec)
+	}
+	return sanitized.Object
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if _, isString := field.(string); isString && isSensitive(key) {
+				v[key] = REDACTED
+				continue
+			}
+			v[key] = redact(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return value
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitiveKey := range sensitiveKeys {
+		if strings.Contains(key, sensitiveKey) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// GitSink commits every export to a branch of a Git repository, so the
+// history of the branch is the history of the configuration. Credentials are
+// taken from the URL or the git configuration of the controller image, and
+// left out of what is logged.
+type GitSink struct {
+	URL    string
+	Branch string
+	// Dir is the working copy kept between exports
+	Dir         string
+	AuthorName  string
+	AuthorEmail string
+}
+
+func (g *GitSink) Store(files map[string][]byte, exportTime time.Time) error {
+	if err := g.checkout(); err != nil {
+		return err
+	}
+
+	// the export is a full snapshot, so whatever isn't in it was removed
+	entries, err := ioutil.ReadDir(g.Dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(g.Dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	for name, content := range files {
+		filePath := filepath.Join(g.Dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if _, err := g.git("add", "--all"); err != nil {
+		return err
+	}
+	if _, err := g.git("diff", "--cached", "--quiet"); err == nil {
+		klog.V(4).Info("Configuration unchanged, nothing to export")
+		return nil
+	}
+	message := fmt.Sprintf("Export virtual router configuration\n\nExported at %s", exportTime.UTC().Format(time.RFC3339))
+	if _, err := g.git("-c", "user.name="+g.AuthorName, "-c", "user.email="+g.AuthorEmail, "commit", "--quiet", "--message", message); err != nil {
+		return err
+	}
+	if _, err := g.git("push", "--quiet", "origin", "HEAD:refs/heads/"+g.Branch); err != nil {
+		return err
+	}
+	klog.InfoS("Configuration exported", "url", redactURL(g.URL), "branch", g.Branch)
+	return nil
+}
+
+// checkout brings the working copy to the tip of the remote branch, or to an
+// unborn branch if the remote doesn't have it yet.
+func (g *GitSink) checkout() error {
+	if _, err := os.Stat(filepath.Join(g.Dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(g.Dir, 0755); err != nil {
+			return err
+		}
+		if _, err := g.git("init", "--quiet"); err != nil {
+			return err
+		}
+		if _, err := g.git("remote", "add", "origin", g.URL); err != nil {
+			return err
+		}
+	}
+
+	if _, fetchErr := g.git("fetch", "--quiet", "origin", g.Branch); fetchErr != nil {
+		if _, err := g.git("rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+			return fetchErr
+		}
+		klog.InfoS("Branch not found on remote, starting it", "branch", g.Branch)
+		_, err := g.git("symbolic-ref", "HEAD", "refs/heads/"+g.Branch)
+		return err
+	}
+	_, err := g.git("checkout", "--quiet", "-B", g.Branch, "FETCH_HEAD")
+	return err
+}
+
+func (g *GitSink) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, g.redact(strings.TrimSpace(string(out))))
+	}
+	return string(out), nil
+}
+
+// redact replaces the credentials of the URL in the output of git.
+func (g *GitSink) redact(output string) string {
+	output = strings.ReplaceAll(output, g.URL, redactURL(g.URL))
+	u, err := url.Parse(g.URL)
+	if err != nil || u.User == nil {
+		return output
+	}
+	// git may print the URL otherwise escaped, or the password alone
+	output = strings.ReplaceAll(output, u.User.String()+"@", REDACTED+"@")
+	if password, ok := u.User.Password(); ok && password != "" {
+		output = strings.ReplaceAll(output, password, REDACTED)
+	}
+	return output
+}
+
+// redactURL returns the URL with the user information, which may hold a
+// password or a token, replaced.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = url.User(REDACTED)
+	return u.String()
+}
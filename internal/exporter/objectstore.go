@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// OBJECT_STORE_MANIFEST lists the objects of the last export, for those of
+// routers since removed to be deleted. It starts with a dot, so no namespace
+// the files are kept under is named like it.
+const OBJECT_STORE_MANIFEST string = ".export-manifest"
+
+// ObjectStoreSink uploads every file of an export with an HTTP PUT under a
+// base URL, such as a bucket of an S3 compatible store, and deletes the
+// objects of the last export missing from it. Keeping the history of the
+// objects is left to the versioning of the bucket.
+type ObjectStoreSink struct {
+	URL string
+	// Token is sent as a bearer token when set
+	Token  string
+	Client *http.Client
+}
+
+func (o *ObjectStoreSink) Store(files map[string][]byte, exportTime time.Time) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	previous, err := o.manifest()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := o.do(http.MethodPut, name, files[name]); err != nil {
+			return err
+		}
+	}
+	removed := previous.Difference(sets.NewString(names...)).List()
+	for _, name := range removed {
+		if _, err := o.do(http.MethodDelete, name, nil); err != nil {
+			return err
+		}
+	}
+	// written last, so objects an export failed to delete are tried again
+	if _, err := o.do(http.MethodPut, OBJECT_STORE_MANIFEST, []byte(strings.Join(names, "\n"))); err != nil {
+		return err
+	}
+	klog.InfoS("Configuration exported", "url", o.URL, "objects", len(names), "removed", len(removed), "exportTime", exportTime.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// manifest returns the objects of the last export, none if there was none.
+func (o *ObjectStoreSink) manifest() (sets.String, error) {
+	body, err := o.do(http.MethodGet, OBJECT_STORE_MANIFEST, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sets.NewString(strings.Fields(string(body))...), nil
+}
+
+// do sends the request for the object and returns the body of the response.
+// Objects not found are taken as empty, or as deleted already.
+func (o *ObjectStoreSink) do(method string, name string, content []byte) ([]byte, error) {
+	objectURL := strings.TrimSuffix(o.URL, "/") + "/" + name
+	req, err := http.NewRequest(method, objectURL, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		contentType := "application/yaml"
+		if name == OBJECT_STORE_MANIFEST {
+			contentType = "text/plain"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if o.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPut {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, objectURL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
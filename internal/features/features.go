@@ -0,0 +1,94 @@
+// Package features holds the feature gates of the controller and the daemon,
+// turning experimental subsystems on or off with --feature-gates, such as
+// --feature-gates=VPN=false.
+package features
+
+import (
+	"flag"
+	"strings"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// VPN programs spec.wireGuard on the routers. VirtualRouters with a VPN
+	// are left as they are, with an InvalidSpec condition, while it is off.
+	VPN featuregate.Feature = "VPN"
+	// XDPFastPath offloads the 1:1 NAT rules of routers with spec.fastPath
+	// to XDP programs attached by the daemons.
+	XDPFastPath featuregate.Feature = "XDPFastPath"
+	// NodeQualification keeps router pods on the nodes their daemon labeled
+	// network.tmaxanc.com/router-ready=true.
+	NodeQualification featuregate.Feature = "NodeQualification"
+	// ChaosInjection serves /debug/chaos on the debug address of the
+	// daemons, injecting failures into router pods to test failover.
+	ChaosInjection featuregate.Feature = "ChaosInjection"
+	// LoadBalancerBackendServices keeps the backends of LoadBalancerRules
+	// annotated with the Services they follow up to date, watching the
+	// EndpointSlices and LoadBalancerRules of the cluster.
+	LoadBalancerBackendServices featuregate.Feature = "LoadBalancerBackendServices"
+)
+
+// defaultFeatureGates are the feature gates known to the controller and the
+// daemon. Alpha features are off by default, beta features on.
+var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	VPN:                         {Default: true, PreRelease: featuregate.Beta},
+	XDPFastPath:                 {Default: false, PreRelease: featuregate.Alpha},
+	NodeQualification:           {Default: false, PreRelease: featuregate.Alpha},
+	ChaosInjection:              {Default: false, PreRelease: featuregate.Alpha},
+	LoadBalancerBackendServices: {Default: false, PreRelease: featuregate.Alpha},
+}
+
+// DefaultMutableFeatureGate is the feature gate of the binary, set from the
+// command line and the configuration file.
+var DefaultMutableFeatureGate featuregate.MutableFeatureGate = featuregate.NewFeatureGate()
+
+// DefaultFeatureGate is the read-only DefaultMutableFeatureGate.
+var DefaultFeatureGate featuregate.FeatureGate = DefaultMutableFeatureGate
+
+func init() {
+	utilruntime.Must(DefaultMutableFeatureGate.Add(defaultFeatureGates))
+}
+
+// Enabled returns whether the feature is on.
+func Enabled(feature featuregate.Feature) bool {
+	return DefaultFeatureGate.Enabled(feature)
+}
+
+// AddFlag adds --feature-gates to fs, setting DefaultMutableFeatureGate.
+func AddFlag(fs *flag.FlagSet) {
+	fs.Var(gatesFlag{}, "feature-gates", "Comma separated key=value pairs turning experimental features on or off. Options are:\n"+strings.Join(DefaultFeatureGate.KnownFeatures(), "\n"))
+}
+
+// SetFromConfig sets the feature gates of a configuration file, except those
+// given with --feature-gates, which take precedence.
+func SetFromConfig(gates map[string]bool) error {
+	if err := DefaultMutableFeatureGate.SetFromMap(gates); err != nil {
+		return err
+	}
+	for _, value := range commandLineGates {
+		if err := DefaultMutableFeatureGate.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandLineGates are the values given with --feature-gates.
+var commandLineGates []string
+
+// gatesFlag is the flag.Value of --feature-gates.
+type gatesFlag struct{}
+
+func (gatesFlag) String() string {
+	return strings.Join(commandLineGates, ",")
+}
+
+func (gatesFlag) Set(value string) error {
+	if err := DefaultMutableFeatureGate.Set(value); err != nil {
+		return err
+	}
+	commandLineGates = append(commandLineGates, value)
+	return nil
+}
@@ -0,0 +1,36 @@
+package features
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestCommandLineOverridesConfig(t *testing.T) {
+	gate, gates := DefaultMutableFeatureGate, commandLineGates
+	defer func() {
+		DefaultMutableFeatureGate, DefaultFeatureGate, commandLineGates = gate, gate, gates
+	}()
+	DefaultMutableFeatureGate = gate.DeepCopy()
+	DefaultFeatureGate = DefaultMutableFeatureGate
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddFlag(fs)
+	if err := fs.Parse([]string{"--feature-gates=VPN=false"}); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled(VPN) {
+		t.Fatal("expected VPN to be turned off by the flag")
+	}
+	if err := SetFromConfig(map[string]bool{"VPN": true}); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled(VPN) {
+		t.Error("expected the flag to take precedence over the configuration file")
+	}
+	if err := SetFromConfig(map[string]bool{"Unknown": true}); err == nil {
+		t.Error("expected an unknown feature gate to be rejected")
+	}
+	if err := fs.Parse([]string{"--feature-gates=VPN=maybe"}); err == nil {
+		t.Error("expected an invalid value to be rejected")
+	}
+}
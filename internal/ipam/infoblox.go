@@ -0,0 +1,90 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DEFAULT_NETWORK_VIEW string = "default"
+
+// Infoblox allocates from the networks of an Infoblox grid through WAPI.
+// Addresses are reserved as fixed addresses, networks as networks, both
+// from the next available range of the pool network.
+type Infoblox struct {
+	// URL is the WAPI base URL, such as https://infoblox/wapi/v2.10
+	URL         string
+	Username    string
+	Password    string
+	NetworkView string
+	Client      *http.Client
+}
+
+type infobloxObject struct {
+	Ref      string `json:"_ref"`
+	IPv4Addr string `json:"ipv4addr,omitempty"`
+	Network  string `json:"network,omitempty"`
+}
+
+func (i *Infoblox) AllocateAddress(pool string, description string) (Allocation, error) {
+	object, err := i.allocate("fixedaddress", "ipv4addr,network", description, map[string]interface{}{
+		"ipv4addr":     fmt.Sprintf("func:nextavailableip:%s,%s", pool, i.NetworkView),
+		"mac":          "00:00:00:00:00:00",
+		"network_view": i.NetworkView,
+		"comment":      description,
+	})
+	if err != nil {
+		return Allocation{}, err
+	}
+	_, network, err := net.ParseCIDR(object.Network)
+	if err != nil {
+		return Allocation{}, fmt.Errorf("invalid network %q of fixed address %s: %v", object.Network, object.IPv4Addr, err)
+	}
+	prefixLength, _ := network.Mask.Size()
+	return Allocation{CIDR: fmt.Sprintf("%s/%d", object.IPv4Addr, prefixLength), Reference: object.Ref}, nil
+}
+
+func (i *Infoblox) AllocatePrefix(pool string, prefixLength int, description string) (Allocation, error) {
+	object, err := i.allocate("network", "network", description, map[string]interface{}{
+		"network":      fmt.Sprintf("func:nextavailablenetwork:%s,%s,%d", pool, i.NetworkView, prefixLength),
+		"network_view": i.NetworkView,
+		"comment":      description,
+	})
+	if err != nil {
+		return Allocation{}, err
+	}
+	return Allocation{CIDR: object.Network, Reference: object.Ref}, nil
+}
+
+func (i *Infoblox) allocate(objectType string, returnFields string, description string, body map[string]interface{}) (infobloxObject, error) {
+	var existing []infobloxObject
+	query := url.Values{"comment": {description}, "network_view": {i.NetworkView}, "_return_fields": {returnFields}}
+	if err := i.do(http.MethodGet, objectType+"?"+query.Encode(), nil, &existing); err != nil {
+		return infobloxObject{}, err
+	}
+	if len(existing) > 0 {
+		return existing[0], nil
+	}
+
+	var created infobloxObject
+	query = url.Values{"_return_fields": {returnFields}}
+	if err := i.do(http.MethodPost, objectType+"?"+query.Encode(), body, &created); err != nil {
+		return infobloxObject{}, err
+	}
+	return created, nil
+}
+
+func (i *Infoblox) Release(allocation Allocation) error {
+	if err := i.do(http.MethodDelete, allocation.Reference, nil, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (i *Infoblox) do(method string, path string, body interface{}, out interface{}) error {
+	return doJSON(i.Client, method, strings.TrimSuffix(i.URL, "/")+"/"+path, func(req *http.Request) {
+		req.SetBasicAuth(i.Username, i.Password)
+	}, body, out)
+}
@@ -0,0 +1,107 @@
+package ipam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Allocation is an address or a network reserved in the IPAM.
+type Allocation struct {
+	// CIDR is the allocated address with the prefix length of its network,
+	// or the allocated network
+	CIDR string
+	// Reference identifies the allocation in the IPAM
+	Reference string
+}
+
+// Provider allocates addresses and networks from the enterprise IPAM of
+// record, so it stays the single source of truth.
+//
+// The description identifies the owner of an allocation. Allocating again
+// with the same description returns the existing allocation, so a retry
+// after a failure never reserves twice.
+type Provider interface {
+	// AllocateAddress reserves the next free address of the pool network,
+	// such as a router external IP or a VIP.
+	AllocateAddress(pool string, description string) (Allocation, error)
+	// AllocatePrefix reserves the next free network of prefixLength inside
+	// the pool network, such as a VPN client pool.
+	AllocatePrefix(pool string, prefixLength int, description string) (Allocation, error)
+	// Release frees the allocation. An allocation the IPAM no longer has
+	// counts as released, so releasing again after a failure succeeds.
+	Release(allocation Allocation) error
+}
+
+// NewProvider returns the provider of the given kind, "netbox" or "infoblox".
+// NetBox authenticates with the token, Infoblox with username and password.
+func NewProvider(kind string, url string, token string, username string, password string, timeout time.Duration) (Provider, error) {
+	client := &http.Client{Timeout: timeout}
+	switch kind {
+	case "netbox":
+		return &NetBox{URL: url, Token: token, Client: client}, nil
+	case "infoblox":
+		return &Infoblox{URL: url, Username: username, Password: password, NetworkView: DEFAULT_NETWORK_VIEW, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown IPAM provider %q", kind)
+}
+
+// responseError is a non-2xx response of the IPAM.
+type responseError struct {
+	method     string
+	url        string
+	status     string
+	statusCode int
+	message    []byte
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.method, e.url, e.status, e.message)
+}
+
+// isNotFound tells whether err is a 404 response of the IPAM.
+func isNotFound(err error) bool {
+	var response *responseError
+	return errors.As(err, &response) && response.statusCode == http.StatusNotFound
+}
+
+// doJSON sends body as JSON and decodes the JSON response into out, unless
+// out is nil.
+func doJSON(client *http.Client, method string, url string, authorize func(*http.Request), body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	authorize(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &responseError{method: method, url: url, status: resp.Status, statusCode: resp.StatusCode, message: bytes.TrimSpace(message)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
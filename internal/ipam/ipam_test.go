@@ -0,0 +1,153 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeNetBox serves one prefix, 10.0.0.0/24 with id 7, and hands out
+// addresses from .10 upwards.
+func fakeNetBox(t *testing.T) (*httptest.Server, *[]string) {
+	var requests []string
+	allocated := map[string]netboxObject{}
+	next := 10
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/ip-addresses/":
+			list := netboxList{}
+			if object, ok := allocated[r.URL.Query().Get("description")]; ok {
+				list.Results = append(list.Results, object)
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/prefixes/":
+			list := netboxList{}
+			if r.URL.Query().Get("prefix") == "10.0.0.0/24" {
+				list.Results = append(list.Results, netboxObject{ID: 7, Prefix: "10.0.0.0/24"})
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/ipam/prefixes/7/available-ips/":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			object := netboxObject{ID: next, Address: fmt.Sprintf("10.0.0.%d/24", next)}
+			next++
+			allocated[body["description"].(string)] = object
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(object)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/ipam/ip-addresses/10/":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, &requests
+}
+
+func TestNetBoxAllocateAddress(t *testing.T) {
+	server, requests := fakeNetBox(t)
+	defer server.Close()
+	provider, err := NewProvider("netbox", server.URL, "secret", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocation, err := provider.AllocateAddress("10.0.0.0/24", "default/test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (Allocation{CIDR: "10.0.0.10/24", Reference: "ip-addresses/10"}); allocation != expected {
+		t.Errorf("allocated %+v, expected %+v", allocation, expected)
+	}
+
+	// allocating again for the same owner returns the same address
+	again, err := provider.AllocateAddress("10.0.0.0/24", "default/test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again != allocation {
+		t.Errorf("allocated %+v again, expected %+v", again, allocation)
+	}
+
+	if err := provider.Release(allocation); err != nil {
+		t.Errorf("unexpected error releasing: %v", err)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "DELETE /api/ipam/ip-addresses/10/" {
+		t.Errorf("expected release to delete the address, got %s", last)
+	}
+
+	// an address already deleted counts as released
+	if err := provider.Release(Allocation{CIDR: "10.0.0.11/24", Reference: "ip-addresses/11"}); err != nil {
+		t.Errorf("unexpected error releasing a deleted address: %v", err)
+	}
+
+	if _, err := provider.AllocateAddress("10.9.0.0/24", "default/other"); err == nil {
+		t.Error("expected an error on an unknown prefix")
+	}
+}
+
+func TestInfobloxAllocate(t *testing.T) {
+	var posted []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte("[]"))
+		case r.Method == http.MethodPost && r.URL.Path == "/wapi/v2.10/fixedaddress":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body)
+			w.Write([]byte(`{"_ref": "fixedaddress/ZG5z:10.0.0.10/default", "ipv4addr": "10.0.0.10", "network": "10.0.0.0/24"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/wapi/v2.10/network":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body)
+			w.Write([]byte(`{"_ref": "network/ZG5z:10.8.1.0/24/default", "network": "10.8.1.0/24"}`))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/wapi/v2.10/fixedaddress/"):
+			w.Write([]byte(`"fixedaddress/ZG5z:10.0.0.10/default"`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	provider, err := NewProvider("infoblox", server.URL+"/wapi/v2.10", "", "admin", "secret", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocation, err := provider.AllocateAddress("10.0.0.0/24", "default/test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (Allocation{CIDR: "10.0.0.10/24", Reference: "fixedaddress/ZG5z:10.0.0.10/default"}); allocation != expected {
+		t.Errorf("allocated %+v, expected %+v", allocation, expected)
+	}
+	if posted[0]["ipv4addr"] != "func:nextavailableip:10.0.0.0/24,default" || posted[0]["comment"] != "default/test" {
+		t.Errorf("unexpected fixed address request %v", posted[0])
+	}
+
+	pool, err := provider.AllocatePrefix("10.8.0.0/16", 24, "default/test-vpn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pool.CIDR != "10.8.1.0/24" {
+		t.Errorf("allocated %s, expected 10.8.1.0/24", pool.CIDR)
+	}
+	if posted[1]["network"] != "func:nextavailablenetwork:10.8.0.0/16,default,24" {
+		t.Errorf("unexpected network request %v", posted[1])
+	}
+
+	if err := provider.Release(allocation); err != nil {
+		t.Errorf("unexpected error releasing: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package ipam
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NetBox allocates from the prefixes of a NetBox instance. Pools are
+// prefixes given in CIDR notation.
+type NetBox struct {
+	// URL is the base URL of NetBox, without the /api suffix
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+type netboxObject struct {
+	ID      int    `json:"id"`
+	Address string `json:"address,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+}
+
+type netboxList struct {
+	Count   int            `json:"count"`
+	Results []netboxObject `json:"results"`
+}
+
+func (n *NetBox) AllocateAddress(pool string, description string) (Allocation, error) {
+	return n.allocate(pool, "ip-addresses", "available-ips", map[string]interface{}{
+		"description": description,
+		"status":      "active",
+	})
+}
+
+func (n *NetBox) AllocatePrefix(pool string, prefixLength int, description string) (Allocation, error) {
+	return n.allocate(pool, "prefixes", "available-prefixes", map[string]interface{}{
+		"description":   description,
+		"prefix_length": prefixLength,
+		"status":        "active",
+	})
+}
+
+func (n *NetBox) allocate(pool string, resource string, available string, body map[string]interface{}) (Allocation, error) {
+	var existing netboxList
+	if err := n.do(http.MethodGet, resource+"/?description="+url.QueryEscape(body["description"].(string)), nil, &existing); err != nil {
+		return Allocation{}, err
+	}
+	if len(existing.Results) > 0 {
+		return n.allocation(resource, existing.Results[0]), nil
+	}
+
+	var prefixes netboxList
+	if err := n.do(http.MethodGet, "prefixes/?prefix="+url.QueryEscape(pool), nil, &prefixes); err != nil {
+		return Allocation{}, err
+	}
+	if len(prefixes.Results) == 0 {
+		return Allocation{}, fmt.Errorf("prefix %s not found in NetBox", pool)
+	}
+
+	var created netboxObject
+	if err := n.do(http.MethodPost, fmt.Sprintf("prefixes/%d/%s/", prefixes.Results[0].ID, available), body, &created); err != nil {
+		return Allocation{}, err
+	}
+	return n.allocation(resource, created), nil
+}
+
+func (n *NetBox) allocation(resource string, object netboxObject) Allocation {
+	cidr := object.Address
+	if resource == "prefixes" {
+		cidr = object.Prefix
+	}
+	return Allocation{CIDR: cidr, Reference: fmt.Sprintf("%s/%d", resource, object.ID)}
+}
+
+func (n *NetBox) Release(allocation Allocation) error {
+	if err := n.do(http.MethodDelete, allocation.Reference+"/", nil, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (n *NetBox) do(method string, path string, body interface{}, out interface{}) error {
+	return doJSON(n.Client, method, strings.TrimSuffix(n.URL, "/")+"/api/ipam/"+path, func(req *http.Request) {
+		req.Header.Set("Authorization", "Token "+n.Token)
+	}, body, out)
+}
@@ -0,0 +1,31 @@
+// Package macaddress parses the prefixes of the MAC pools the router pods
+// are given addresses of, for the controller and its configuration file to
+// share without depending on each other.
+package macaddress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParsePrefix returns the octets of the prefix of a MAC pool, one to five of
+// them, of a unicast address.
+func ParsePrefix(prefix string) ([]byte, error) {
+	octets := strings.Split(prefix, ":")
+	if len(octets) < 1 || len(octets) > 5 {
+		return nil, fmt.Errorf("prefix %q is not one to five octets", prefix)
+	}
+	var parsed []byte
+	for _, octet := range octets {
+		value, err := strconv.ParseUint(octet, 16, 8)
+		if err != nil || len(octet) != 2 {
+			return nil, fmt.Errorf("prefix %q has an invalid octet %q", prefix, octet)
+		}
+		parsed = append(parsed, byte(value))
+	}
+	if parsed[0]&0x01 != 0 {
+		return nil, fmt.Errorf("prefix %q is of multicast addresses", prefix)
+	}
+	return parsed, nil
+}
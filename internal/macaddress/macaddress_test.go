@@ -0,0 +1,22 @@
+package macaddress
+
+import "testing"
+
+func TestParsePrefix(t *testing.T) {
+	for _, test := range []struct {
+		prefix string
+		valid  bool
+	}{
+		{"02:42:0a", true},
+		{"02", true},
+		{"02:00:00:00:00", true},
+		{"02:00:00:00:00:00", false},
+		{"01:00:5e", false},
+		{"2:42", false},
+		{"", false},
+	} {
+		if _, err := ParsePrefix(test.prefix); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.prefix, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package multicluster lets a controller in a management cluster reconcile
+// the VirtualRouters of remote workload clusters, registered with Secrets
+// holding their kubeconfig, and aggregates the status of every cluster.
+package multicluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+)
+
+const (
+	// KUBECONFIG_KEY holds the kubeconfig of a remote cluster in the Secret
+	// it is registered with.
+	KUBECONFIG_KEY string = "kubeconfig"
+	// CLUSTER_STATUS_CONFIGMAP_NAME is the ConfigMap in the controller
+	// namespace of the management cluster the status of every cluster is
+	// aggregated in, one key per cluster.
+	CLUSTER_STATUS_CONFIGMAP_NAME string = "virtualrouter-clusters"
+)
+
+// Cluster is a remote cluster whose VirtualRouters are reconciled.
+type Cluster struct {
+	// Name is the name of the Secret the cluster is registered with
+	Name   string
+	Config *rest.Config
+}
+
+// LoadClusters reads the kubeconfig of the clusters registered with the
+// given Secrets of namespace.
+func LoadClusters(kubeclientset kubernetes.Interface, namespace string, secretNames []string) ([]Cluster, error) {
+	var clusters []Cluster
+	for _, secretName := range secretNames {
+		secret, err := kubeclientset.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig, ok := secret.Data[KUBECONFIG_KEY]
+		if !ok {
+			return nil, fmt.Errorf("no %s in secret %s/%s", KUBECONFIG_KEY, namespace, secretName)
+		}
+		config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig in secret %s/%s: %v", namespace, secretName, err)
+		}
+		clusters = append(clusters, Cluster{Name: secretName, Config: config})
+	}
+	return clusters, nil
+}
+
+// ClusterStatus sums up the VirtualRouters of a cluster.
+type ClusterStatus struct {
+	// VirtualRouters is the number of VirtualRouters handled
+	VirtualRouters int `json:"virtualRouters"`
+	// Phases counts the VirtualRouters of every phase
+	Phases map[samplev1alpha1.VirtualRouterPhase]int `json:"phases,omitempty"`
+	// NotRunning are the VirtualRouters not Running, as namespace/name
+	NotRunning []string `json:"notRunning,omitempty"`
+}
+
+type clusterSource struct {
+	virtualRoutersLister listers.VirtualRouterLister
+	virtualRoutersSynced cache.InformerSynced
+}
+
+// Aggregator periodically sums up the VirtualRouters of every cluster in the
+// CLUSTER_STATUS_CONFIGMAP_NAME ConfigMap of the management cluster.
+type Aggregator struct {
+	kubeclientset kubernetes.Interface
+	namespace     string
+	// namespaces, if set, are the only namespaces whose VirtualRouters are
+	// counted
+	namespaces sets.String
+	// controllerClass is the spec.controllerClass of the VirtualRouters
+	// counted
+	controllerClass string
+
+	lock     sync.Mutex
+	clusters map[string]clusterSource
+}
+
+// NewAggregator returns an Aggregator writing to the given namespace of the
+// management cluster.
+func NewAggregator(kubeclientset kubernetes.Interface, namespace string, namespaces []string, controllerClass string) *Aggregator {
+	return &Aggregator{
+		kubeclientset:   kubeclientset,
+		namespace:       namespace,
+		namespaces:      sets.NewString(namespaces...),
+		controllerClass: controllerClass,
+		clusters:        map[string]clusterSource{},
+	}
+}
+
+// AddCluster counts the VirtualRouters of the informer as those of the named
+// cluster.
+func (a *Aggregator) AddCluster(name string, virtualRouterInformer informers.VirtualRouterInformer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.clusters[name] = clusterSource{
+		virtualRoutersLister: virtualRouterInformer.Lister(),
+		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
+	}
+}
+
+// Run aggregates every interval until stopCh is closed.
+func (a *Aggregator) Run(interval time.Duration, stopCh <-chan struct{}) {
+	klog.Info("Starting cluster status aggregator")
+	wait.Until(func() {
+		if err := a.Aggregate(); err != nil {
+			klog.ErrorS(err, "Aggregating cluster status failed")
+		}
+	}, interval, stopCh)
+}
+
+// Aggregate writes the status of the clusters whose informers have synced.
+func (a *Aggregator) Aggregate() error {
+	statuses, err := a.Status()
+	if err != nil {
+		return err
+	}
+	data := map[string]string{}
+	for name, status := range statuses {
+		content, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		data[name] = string(content)
+	}
+
+	configMaps := a.kubeclientset.CoreV1().ConfigMaps(a.namespace)
+	configMap, err := configMaps.Get(context.TODO(), CLUSTER_STATUS_CONFIGMAP_NAME, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(context.TODO(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: CLUSTER_STATUS_CONFIGMAP_NAME, Namespace: a.namespace},
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	// clusters not synced yet keep their last status
+	for name, content := range configMap.Data {
+		if _, ok := data[name]; !ok && a.registered(name) {
+			data[name] = content
+		}
+	}
+	if reflect.DeepEqual(configMap.Data, data) {
+		return nil
+	}
+	configMapCopy := configMap.DeepCopy()
+	configMapCopy.Data = data
+	_, err = configMaps.Update(context.TODO(), configMapCopy, metav1.UpdateOptions{})
+	return err
+}
+
+func (a *Aggregator) registered(name string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, ok := a.clusters[name]
+	return ok
+}
+
+// Status returns the status of the clusters whose informers have synced.
+func (a *Aggregator) Status() (map[string]ClusterStatus, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	statuses := map[string]ClusterStatus{}
+	for name, cluster := range a.clusters {
+		if !cluster.virtualRoutersSynced() {
+			continue
+		}
+		virtualRouters, err := cluster.virtualRoutersLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		status := ClusterStatus{}
+		for _, virtualRouter := range virtualRouters {
+			if a.namespaces.Len() > 0 && !a.namespaces.Has(virtualRouter.Namespace) {
+				continue
+			}
+			if virtualRouter.Spec.ControllerClass != a.controllerClass {
+				continue
+			}
+			status.VirtualRouters++
+			phase := virtualRouter.Status.Phase
+			if phase == "" {
+				phase = samplev1alpha1.VirtualRouterPending
+			}
+			if status.Phases == nil {
+				status.Phases = map[samplev1alpha1.VirtualRouterPhase]int{}
+			}
+			status.Phases[phase]++
+			if phase != samplev1alpha1.VirtualRouterRunning {
+				status.NotRunning = append(status.NotRunning, virtualRouter.Namespace+"/"+virtualRouter.Name)
+			}
+		}
+		sort.Strings(status.NotRunning)
+		statuses[name] = status
+	}
+	return statuses, nil
+}
@@ -0,0 +1,105 @@
+package multicluster
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/fake"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example.com:6443
+contexts:
+- name: workload
+  context:
+    cluster: workload
+    user: controller
+current-context: workload
+users:
+- name: controller
+  user:
+    token: token
+`
+
+func TestLoadClusters(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "workload-a", Namespace: "virtualrouter"}, Data: map[string][]byte{KUBECONFIG_KEY: []byte(testKubeconfig)}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "virtualrouter"}},
+	)
+	clusters, err := LoadClusters(kubeClient, "virtualrouter", []string{"workload-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "workload-a" || clusters[0].Config.Host != "https://workload.example.com:6443" {
+		t.Errorf("unexpected clusters %+v", clusters)
+	}
+	if _, err := LoadClusters(kubeClient, "virtualrouter", []string{"empty"}); err == nil {
+		t.Error("expected a Secret without kubeconfig to be rejected")
+	}
+	if _, err := LoadClusters(kubeClient, "virtualrouter", []string{"missing"}); err == nil {
+		t.Error("expected a missing Secret to be rejected")
+	}
+}
+
+func newVirtualRouter(namespace, name string, phase networkcontroller.VirtualRouterPhase) *networkcontroller.VirtualRouter {
+	return &networkcontroller.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Status:     networkcontroller.VirtualRouterStatus{Phase: phase},
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset()
+	aggregator := NewAggregator(kubeClient, "virtualrouter", nil, "")
+	for name, virtualRouters := range map[string][]*networkcontroller.VirtualRouter{
+		"local":      {newVirtualRouter("default", "a", networkcontroller.VirtualRouterRunning)},
+		"workload-a": {newVirtualRouter("default", "a", networkcontroller.VirtualRouterRunning), newVirtualRouter("tenant", "b", networkcontroller.VirtualRouterDegraded), newVirtualRouter("tenant", "c", "")},
+	} {
+		i := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		for _, virtualRouter := range virtualRouters {
+			i.Tmax().V1().VirtualRouters().Informer().GetIndexer().Add(virtualRouter)
+		}
+		aggregator.AddCluster(name, i.Tmax().V1().VirtualRouters())
+		// the informers are never started, so they are marked synced by hand
+		source := aggregator.clusters[name]
+		source.virtualRoutersSynced = func() bool { return true }
+		aggregator.clusters[name] = source
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := aggregator.Aggregate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configMap, err := kubeClient.CoreV1().ConfigMaps("virtualrouter").Get(context.TODO(), CLUSTER_STATUS_CONFIGMAP_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status ClusterStatus
+	if err := json.Unmarshal([]byte(configMap.Data["workload-a"]), &status); err != nil {
+		t.Fatal(err)
+	}
+	expected := ClusterStatus{
+		VirtualRouters: 3,
+		Phases:         map[networkcontroller.VirtualRouterPhase]int{networkcontroller.VirtualRouterRunning: 1, networkcontroller.VirtualRouterDegraded: 1, networkcontroller.VirtualRouterPending: 1},
+		NotRunning:     []string{"tenant/b", "tenant/c"},
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("expected %+v, got %+v", expected, status)
+	}
+	if _, ok := configMap.Data["local"]; !ok {
+		t.Error("expected the status of the local cluster")
+	}
+}
@@ -0,0 +1,536 @@
+package tenantnetwork
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+	samplescheme "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/scheme"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+const controllerAgentName = "tenant-network"
+
+const (
+	// DEFAULT_POLICY_FIREWALL_RULE_NAME is the suffix of the FireWallRule
+	// holding the default policy of the tenant
+	DEFAULT_POLICY_FIREWALL_RULE_NAME string = "default-policy"
+	// QUOTA_NAME is the suffix of the ResourceQuota of the tenant
+	QUOTA_NAME string = "quota"
+	// ROUTER_DEPLOYMENT_NAME is the suffix of the Deployment of the tenant router
+	ROUTER_DEPLOYMENT_NAME string = "router"
+)
+
+const (
+	// SuccessSynced is used as part of the Event 'reason' when a TenantNetwork is synced
+	SuccessSynced = "Synced"
+	// ErrResourceExists is used as part of the Event 'reason' when a resource
+	// of the tenant exists but is not managed by the TenantNetwork
+	ErrResourceExists = "ErrResourceExists"
+	// ErrProvisionFailed is used as part of the Event 'reason' when the
+	// TenantNetwork can't be provisioned as requested
+	ErrProvisionFailed = "ProvisionFailed"
+
+	// MessageResourceExists is the message used for Events when a resource
+	// fails to sync due to a resource already existing
+	MessageResourceExists = "Resource %q already exists and is not managed by TenantNetwork"
+	// MessageResourceSynced is the message used for an Event fired when a TenantNetwork
+	// is synced successfully
+	MessageResourceSynced = "TenantNetwork synced successfully"
+)
+
+var firewallRuleResource = nfvv1.SchemeGroupVersion.WithResource("firewallrules")
+
+// Options holds the TenantNetwork controller settings given on the command line.
+type Options struct {
+	// ControllerNamespace is the namespace the controller runs in.
+	ControllerNamespace string
+	// FlavorConfigMap is the ConfigMap in ControllerNamespace holding the
+	// flavors offered to tenants.
+	FlavorConfigMap string
+	// IPAM, if set, allocates tenant subnets instead of the controller.
+	IPAM ipam.Provider
+	// EventClient, if set, records the Events of the controller, so they
+	// are recorded while the writes of the other clients are dry runs.
+	// The kubernetes client given records them if nil.
+	EventClient kubernetes.Interface
+}
+
+// Controller provisions the VirtualRouter, subnet, default firewall policy
+// and quota of every TenantNetwork.
+type Controller struct {
+	kubeclientset   kubernetes.Interface
+	sampleclientset clientset.Interface
+	dynamicclient   dynamic.Interface
+
+	options Options
+
+	tenantNetworksLister listers.TenantNetworkLister
+	tenantNetworksSynced cache.InformerSynced
+	virtualRoutersLister listers.VirtualRouterLister
+	virtualRoutersSynced cache.InformerSynced
+
+	// subnetLock serializes subnet allocation, and allocatedSubnets holds
+	// the subnets allocated but not yet seen in the cache.
+	subnetLock       sync.Mutex
+	allocatedSubnets map[string]bool
+
+	workqueue workqueue.RateLimitingInterface
+	recorder  record.EventRecorder
+}
+
+func NewController(
+	kubeclientset kubernetes.Interface,
+	sampleclientset clientset.Interface,
+	dynamicclient dynamic.Interface,
+	tenantNetworkInformer informers.TenantNetworkInformer,
+	virtualRouterInformer informers.VirtualRouterInformer,
+	options Options) *Controller {
+
+	utilruntime.Must(samplescheme.AddToScheme(scheme.Scheme))
+	eventClient := kubeclientset
+	if options.EventClient != nil {
+		eventClient = options.EventClient
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: eventClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
+	controller := &Controller{
+		kubeclientset:        kubeclientset,
+		sampleclientset:      sampleclientset,
+		dynamicclient:        dynamicclient,
+		options:              options,
+		tenantNetworksLister: tenantNetworkInformer.Lister(),
+		tenantNetworksSynced: tenantNetworkInformer.Informer().HasSynced,
+		virtualRoutersLister: virtualRouterInformer.Lister(),
+		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
+		allocatedSubnets:     map[string]bool{},
+		workqueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "TenantNetworks"),
+		recorder:             recorder,
+	}
+
+	klog.Info("Setting up TenantNetwork event handlers")
+	tenantNetworkInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueTenantNetwork,
+		UpdateFunc: func(old, new interface{}) {
+			oldTenantNetwork := old.(*samplev1alpha1.TenantNetwork)
+			newTenantNetwork := new.(*samplev1alpha1.TenantNetwork)
+			if oldTenantNetwork.Generation == newTenantNetwork.Generation && oldTenantNetwork.DeletionTimestamp.Equal(newTenantNetwork.DeletionTimestamp) {
+				// status writes and periodic resyncs need no reconciliation
+				return
+			}
+			controller.enqueueTenantNetwork(new)
+		},
+	})
+	// The phase of the tenant follows its VirtualRouter, and a VirtualRouter
+	// changed behind the back of the tenant is reverted.
+	virtualRouterInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleVirtualRouter,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(*samplev1alpha1.VirtualRouter).ResourceVersion == new.(*samplev1alpha1.VirtualRouter).ResourceVersion {
+				return
+			}
+			controller.handleVirtualRouter(new)
+		},
+		DeleteFunc: controller.handleVirtualRouter,
+	})
+
+	return controller
+}
+
+// Run waits for the caches to sync and processes TenantNetworks with
+// threadiness workers until stopCh is closed.
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	klog.Info("Starting TenantNetwork controller")
+	if ok := cache.WaitForCacheSync(stopCh, c.tenantNetworksSynced, c.virtualRoutersSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	klog.Info("Shutting down TenantNetwork workers")
+	return nil
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.workqueue.Done(obj)
+		key, ok := obj.(string)
+		if !ok {
+			c.workqueue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+		if err := c.syncHandler(key); err != nil {
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
+		}
+		c.workqueue.Forget(obj)
+		klog.Infof("Successfully synced '%s'", key)
+		return nil
+	}(obj)
+
+	if err != nil {
+		utilruntime.HandleError(err)
+	}
+	return true
+}
+
+// syncHandler provisions the resources of the TenantNetwork and updates its
+// status.
+func (c *Controller) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	tenantNetwork, err := c.tenantNetworksLister.TenantNetworks(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			utilruntime.HandleError(fmt.Errorf("tenantNetwork '%s' in work queue no longer exists", key))
+			return nil
+		}
+		return err
+	}
+
+	// everything else is owned by the TenantNetwork and garbage collected
+	if !tenantNetwork.DeletionTimestamp.IsZero() {
+		return c.releaseSubnet(tenantNetwork)
+	}
+
+	flavor, err := c.flavor(tenantNetwork.Spec.Flavor)
+	if err != nil {
+		return c.handleProvisionError(tenantNetwork, err)
+	}
+
+	provisioned, err := c.ensureSubnet(tenantNetwork, flavor)
+	if err != nil {
+		return c.handleProvisionError(tenantNetwork, err)
+	}
+	tenantNetwork = provisioned
+
+	virtualRouter, err := c.ensureVirtualRouter(tenantNetwork, flavor)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := c.ensureDefaultFirewallRule(tenantNetwork); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := c.ensureQuota(tenantNetwork, flavor); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := c.updateTenantNetworkStatus(tenantNetwork, virtualRouter, ""); err != nil {
+		return err
+	}
+
+	c.recorder.Event(tenantNetwork, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	return nil
+}
+
+// handleProvisionError marks the TenantNetwork failed when retrying can't
+// help, and returns the other errors to be retried.
+func (c *Controller) handleProvisionError(tenantNetwork *samplev1alpha1.TenantNetwork, err error) error {
+	if _, ok := err.(*provisionError); !ok {
+		klog.Error(err)
+		return err
+	}
+	c.recorder.Event(tenantNetwork, corev1.EventTypeWarning, ErrProvisionFailed, err.Error())
+	return c.updateTenantNetworkStatus(tenantNetwork, nil, err.Error())
+}
+
+func (c *Controller) ensureVirtualRouter(tenantNetwork *samplev1alpha1.TenantNetwork, flavor *Flavor) (*samplev1alpha1.VirtualRouter, error) {
+	desired, err := newVirtualRouter(tenantNetwork, flavor)
+	if err != nil {
+		return nil, err
+	}
+	virtualRouter, err := c.virtualRoutersLister.VirtualRouters(tenantNetwork.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		// the reconciliation of the VirtualRouter is traced from here
+		ctx, span := tracing.Tracer().Start(context.Background(), "Create VirtualRouter",
+			trace.WithAttributes(attribute.String("tenantnetwork", tenantNetwork.Namespace+"/"+tenantNetwork.Name)))
+		defer span.End()
+		tracing.InjectObject(ctx, desired)
+		return c.sampleclientset.TmaxV1().VirtualRouters(tenantNetwork.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(virtualRouter, tenantNetwork) {
+		msg := fmt.Sprintf(MessageResourceExists, virtualRouter.Name)
+		c.recorder.Event(tenantNetwork, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, fmt.Errorf(msg)
+	}
+
+	// flavor changes are rolled out to the VirtualRouters of the flavor
+	if equality.Semantic.DeepEqual(virtualRouter.Spec, desired.Spec) {
+		return virtualRouter, nil
+	}
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	virtualRouterCopy.Spec = desired.Spec
+	ctx, span := tracing.Tracer().Start(context.Background(), "Update VirtualRouter",
+		trace.WithAttributes(attribute.String("tenantnetwork", tenantNetwork.Namespace+"/"+tenantNetwork.Name)))
+	defer span.End()
+	tracing.InjectObject(ctx, virtualRouterCopy)
+	return c.sampleclientset.TmaxV1().VirtualRouters(tenantNetwork.Namespace).Update(context.TODO(), virtualRouterCopy, metav1.UpdateOptions{})
+}
+
+// newVirtualRouter renders the VirtualRouter of the tenant from its flavor.
+// The router takes the first address of the subnet, and is placed in the
+// tenant namespace so no namespace is created for it.
+func newVirtualRouter(tenantNetwork *samplev1alpha1.TenantNetwork, flavor *Flavor) (*samplev1alpha1.VirtualRouter, error) {
+	gatewayIP, netmask, err := gateway(tenantNetwork.Status.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	spec := *flavor.VirtualRouter.DeepCopy()
+	spec.DeploymentName = tenantNetwork.Name + "-" + ROUTER_DEPLOYMENT_NAME
+	spec.VlanNumber = tenantNetwork.Spec.VlanNumber
+	spec.InternalIP = gatewayIP
+	spec.InternalNetmask = netmask
+	spec.Placement.Strategy = samplev1alpha1.TenantPlacementStrategy
+	if spec.Replicas == nil {
+		spec.Replicas = func(i int32) *int32 { return &i }(1)
+	}
+
+	return &samplev1alpha1.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tenantNetwork.Name,
+			Namespace: tenantNetwork.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(tenantNetwork, samplev1alpha1.SchemeGroupVersion.WithKind("TenantNetwork")),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// ensureDefaultFirewallRule creates the FireWallRule accepting the traffic
+// into the tenant subnet with the Accept policy. The Drop policy needs none,
+// the router dropping what no rule accepts, so the rule is deleted.
+func (c *Controller) ensureDefaultFirewallRule(tenantNetwork *samplev1alpha1.TenantNetwork) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(newDefaultFirewallRule(tenantNetwork))
+	if err != nil {
+		return err
+	}
+	desired := &unstructured.Unstructured{Object: content}
+	accept := tenantNetwork.Spec.DefaultFirewallPolicy == samplev1alpha1.TenantFirewallPolicyAccept
+
+	firewallRules := c.dynamicclient.Resource(firewallRuleResource).Namespace(tenantNetwork.Namespace)
+	obj, err := firewallRules.Get(context.TODO(), desired.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if !accept {
+			return nil
+		}
+		_, err = firewallRules.Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		if !metav1.IsControlledBy(obj, tenantNetwork) {
+			return nil
+		}
+		uid := obj.GetUID()
+		err = firewallRules.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !metav1.IsControlledBy(obj, tenantNetwork) {
+		msg := fmt.Sprintf(MessageResourceExists, obj.GetName())
+		c.recorder.Event(tenantNetwork, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if equality.Semantic.DeepEqual(obj.Object["spec"], desired.Object["spec"]) {
+		return nil
+	}
+	obj = obj.DeepCopy()
+	obj.Object["spec"] = desired.Object["spec"]
+	_, err = firewallRules.Update(context.TODO(), obj, metav1.UpdateOptions{})
+	return err
+}
+
+// newDefaultFirewallRule accepts all traffic into the tenant subnet. The
+// router adds FireWallRules in the order they arrive and the first match
+// wins, so with the rule in place the DROP rules of the tenant don't take
+// effect: the Accept policy leaves the subnet open.
+func newDefaultFirewallRule(tenantNetwork *samplev1alpha1.TenantNetwork) *nfvv1.FireWallRule {
+	return &nfvv1.FireWallRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nfvv1.SchemeGroupVersion.String(),
+			Kind:       "FireWallRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tenantNetwork.Name + "-" + DEFAULT_POLICY_FIREWALL_RULE_NAME,
+			Namespace: tenantNetwork.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(tenantNetwork, samplev1alpha1.SchemeGroupVersion.WithKind("TenantNetwork")),
+			},
+		},
+		Spec: nfvv1.FireWallRuleSpec{
+			Rules: []nfvv1.Rules{{
+				Match:  nfvv1.Match{DstIP: tenantNetwork.Status.Subnet, Protocol: "all"},
+				Action: nfvv1.Action{Policy: "ACCEPT"},
+			}},
+		},
+	}
+}
+
+func (c *Controller) ensureQuota(tenantNetwork *samplev1alpha1.TenantNetwork, flavor *Flavor) error {
+	if len(flavor.Quota) == 0 {
+		return nil
+	}
+	desired := newQuota(tenantNetwork, flavor)
+	quota, err := c.kubeclientset.CoreV1().ResourceQuotas(tenantNetwork.Namespace).Get(context.TODO(), desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = c.kubeclientset.CoreV1().ResourceQuotas(tenantNetwork.Namespace).Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(quota, tenantNetwork) {
+		msg := fmt.Sprintf(MessageResourceExists, quota.Name)
+		c.recorder.Event(tenantNetwork, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if equality.Semantic.DeepEqual(quota.Spec.Hard, desired.Spec.Hard) {
+		return nil
+	}
+	quotaCopy := quota.DeepCopy()
+	quotaCopy.Spec.Hard = desired.Spec.Hard
+	_, err = c.kubeclientset.CoreV1().ResourceQuotas(tenantNetwork.Namespace).Update(context.TODO(), quotaCopy, metav1.UpdateOptions{})
+	return err
+}
+
+func newQuota(tenantNetwork *samplev1alpha1.TenantNetwork, flavor *Flavor) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tenantNetwork.Name + "-" + QUOTA_NAME,
+			Namespace: tenantNetwork.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(tenantNetwork, samplev1alpha1.SchemeGroupVersion.WithKind("TenantNetwork")),
+			},
+		},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: flavor.Quota,
+		},
+	}
+}
+
+// updateTenantNetworkStatus records the provisioned resources, or the
+// message of why provisioning failed.
+func (c *Controller) updateTenantNetworkStatus(tenantNetwork *samplev1alpha1.TenantNetwork, virtualRouter *samplev1alpha1.VirtualRouter, message string) error {
+	tenantNetworkCopy := tenantNetwork.DeepCopy()
+	tenantNetworkCopy.Status.ObservedGeneration = tenantNetwork.Generation
+	tenantNetworkCopy.Status.Message = message
+	switch {
+	case message != "":
+		tenantNetworkCopy.Status.Phase = samplev1alpha1.TenantNetworkFailed
+	case virtualRouter != nil && virtualRouter.Status.Phase == samplev1alpha1.VirtualRouterRunning:
+		tenantNetworkCopy.Status.Phase = samplev1alpha1.TenantNetworkReady
+	default:
+		tenantNetworkCopy.Status.Phase = samplev1alpha1.TenantNetworkProvisioning
+	}
+	if virtualRouter != nil {
+		tenantNetworkCopy.Status.VirtualRouter = virtualRouter.Name
+		tenantNetworkCopy.Status.GatewayIP = virtualRouter.Spec.InternalIP
+	}
+	_, err := c.sampleclientset.TmaxV1().TenantNetworks(tenantNetwork.Namespace).UpdateStatus(context.TODO(), tenantNetworkCopy, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) enqueueTenantNetwork(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleVirtualRouter enqueues the TenantNetwork owning the VirtualRouter.
+func (c *Controller) handleVirtualRouter(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != "TenantNetwork" {
+		return
+	}
+	tenantNetwork, err := c.tenantNetworksLister.TenantNetworks(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		klog.V(4).Infof("ignoring orphaned VirtualRouter '%s/%s' of tenantNetwork '%s'", object.GetNamespace(), object.GetName(), ownerRef.Name)
+		return
+	}
+	c.enqueueTenantNetwork(tenantNetwork)
+}
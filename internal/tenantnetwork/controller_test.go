@@ -0,0 +1,266 @@
+package tenantnetwork
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/fake"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
+)
+
+const testFlavor = `
+virtualRouter:
+  image: tmaxcloudck/virtualrouter:v0.2.5
+subnetPool: 10.10.0.0/16
+quota:
+  pods: "20"
+`
+
+func newTenantNetwork(name string) *networkcontroller.TenantNetwork {
+	return &networkcontroller.TenantNetwork{
+		TypeMeta: metav1.TypeMeta{APIVersion: networkcontroller.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "tenant",
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: networkcontroller.TenantNetworkSpec{
+			Flavor:     "small",
+			VlanNumber: 100,
+		},
+	}
+}
+
+func newTestController(t *testing.T, tenantNetworks []*networkcontroller.TenantNetwork, kubeobjects ...runtime.Object) (*Controller, *fake.Clientset, *k8sfake.Clientset, *dynamicfake.FakeDynamicClient) {
+	var objects []runtime.Object
+	for _, tn := range tenantNetworks {
+		objects = append(objects, tn)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	kubeclient := k8sfake.NewSimpleClientset(kubeobjects...)
+	nfvclient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	i := informers.NewSharedInformerFactory(client, 0)
+	c := NewController(kubeclient, client, nfvclient,
+		i.Tmax().V1().TenantNetworks(), i.Tmax().V1().VirtualRouters(),
+		Options{ControllerNamespace: "virtualrouter", FlavorConfigMap: "virtualrouter-flavors"})
+	c.recorder = &record.FakeRecorder{}
+	for _, tn := range tenantNetworks {
+		if err := i.Tmax().V1().TenantNetworks().Informer().GetIndexer().Add(tn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, client, kubeclient, nfvclient
+}
+
+func newFlavorConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "virtualrouter-flavors", Namespace: "virtualrouter"},
+		Data:       map[string]string{"small": testFlavor},
+	}
+}
+
+func TestProvisionsTenantNetwork(t *testing.T) {
+	taken := newTenantNetwork("taken")
+	taken.Status.Subnet = "10.10.0.0/24"
+	tn := newTenantNetwork("team-a")
+	c, client, kubeclient, nfvclient := newTestController(t, []*networkcontroller.TenantNetwork{taken, tn}, newFlavorConfigMap())
+
+	if err := c.syncHandler("tenant/team-a"); err != nil {
+		t.Fatalf("error syncing tenantNetwork: %v", err)
+	}
+
+	vr, err := client.TmaxV1().VirtualRouters("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("virtualRouter not created: %v", err)
+	}
+	if vr.Spec.InternalIP != "10.10.1.1" || vr.Spec.InternalNetmask != "255.255.255.0" {
+		t.Errorf("expected router at 10.10.1.1/255.255.255.0, got %s/%s", vr.Spec.InternalIP, vr.Spec.InternalNetmask)
+	}
+	if vr.Spec.Placement.Strategy != networkcontroller.TenantPlacementStrategy || vr.Spec.VlanNumber != 100 || vr.Spec.Image != "tmaxcloudck/virtualrouter:v0.2.5" {
+		t.Errorf("unexpected virtualRouter spec %+v", vr.Spec)
+	}
+	if !metav1.IsControlledBy(vr, tn) {
+		t.Errorf("virtualRouter is not controlled by the tenantNetwork")
+	}
+
+	// the router drops what no rule accepts, so the default Drop policy
+	// takes no rule, which would shadow the rules of the tenant
+	if _, err := nfvclient.Resource(firewallRuleResource).Namespace("tenant").Get(context.TODO(), "team-a-default-policy", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no default firewall rule with the Drop policy, got %v", err)
+	}
+
+	quota, err := kubeclient.CoreV1().ResourceQuotas("tenant").Get(context.TODO(), "team-a-quota", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("quota not created: %v", err)
+	}
+	if !quota.Spec.Hard[corev1.ResourcePods].Equal(resource.MustParse("20")) {
+		t.Errorf("unexpected quota %v", quota.Spec.Hard)
+	}
+
+	tn, err = client.TmaxV1().TenantNetworks("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn.Status.Phase != networkcontroller.TenantNetworkProvisioning || tn.Status.Subnet != "10.10.1.0/24" || tn.Status.GatewayIP != "10.10.1.1" || tn.Status.VirtualRouter != "team-a" {
+		t.Errorf("unexpected tenantNetwork status %+v", tn.Status)
+	}
+}
+
+func TestDefaultFirewallPolicy(t *testing.T) {
+	tn := newTenantNetwork("team-a")
+	tn.Spec.DefaultFirewallPolicy = networkcontroller.TenantFirewallPolicyAccept
+	tn.Status.Subnet = "10.10.1.0/24"
+	c, _, _, nfvclient := newTestController(t, []*networkcontroller.TenantNetwork{tn})
+	firewallRules := nfvclient.Resource(firewallRuleResource).Namespace("tenant")
+
+	if err := c.ensureDefaultFirewallRule(tn); err != nil {
+		t.Fatal(err)
+	}
+	fr, err := firewallRules.Get(context.TODO(), "team-a-default-policy", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("default firewall rule not created: %v", err)
+	}
+	rules := fr.Object["spec"].(map[string]interface{})["rules"].([]interface{})
+	rule := rules[0].(map[string]interface{})
+	if rule["action"].(map[string]interface{})["policy"] != "ACCEPT" || rule["match"].(map[string]interface{})["dstIP"] != "10.10.1.0/24" {
+		t.Errorf("unexpected default firewall rule %v", rule)
+	}
+
+	// back to the Drop policy, the rule is removed
+	tn.Spec.DefaultFirewallPolicy = networkcontroller.TenantFirewallPolicyDrop
+	if err := c.ensureDefaultFirewallRule(tn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := firewallRules.Get(context.TODO(), "team-a-default-policy", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the default firewall rule deleted, got %v", err)
+	}
+
+	// a rule of the name not made for the tenant is left alone
+	fr.SetOwnerReferences(nil)
+	fr.SetResourceVersion("")
+	if _, err := firewallRules.Create(context.TODO(), fr, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ensureDefaultFirewallRule(tn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := firewallRules.Get(context.TODO(), "team-a-default-policy", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the firewall rule of another owner kept, got %v", err)
+	}
+}
+
+func TestUnknownFlavorFails(t *testing.T) {
+	tn := newTenantNetwork("team-a")
+	tn.Spec.Flavor = "huge"
+	c, client, _, _ := newTestController(t, []*networkcontroller.TenantNetwork{tn}, newFlavorConfigMap())
+
+	if err := c.syncHandler("tenant/team-a"); err != nil {
+		t.Fatalf("error syncing tenantNetwork: %v", err)
+	}
+
+	tn, err := client.TmaxV1().TenantNetworks("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn.Status.Phase != networkcontroller.TenantNetworkFailed || tn.Status.Message != `flavor "huge" not found` {
+		t.Errorf("unexpected tenantNetwork status %+v", tn.Status)
+	}
+	if _, err := client.TmaxV1().VirtualRouters("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{}); err == nil {
+		t.Errorf("virtualRouter created for an unknown flavor")
+	}
+}
+
+func TestNextFreeSubnet(t *testing.T) {
+	tests := []struct {
+		pool         string
+		prefixLength int
+		used         []string
+		expected     string
+		expectError  bool
+	}{
+		{pool: "10.10.0.0/16", prefixLength: 24, expected: "10.10.0.0/24"},
+		{pool: "10.10.0.0/16", prefixLength: 24, used: []string{"10.10.0.0/24", "10.10.1.0/24"}, expected: "10.10.2.0/24"},
+		{pool: "10.10.0.0/16", prefixLength: 24, used: []string{"10.10.0.0/23"}, expected: "10.10.2.0/24"},
+		{pool: "10.10.0.0/16", prefixLength: 26, used: []string{"10.10.0.0/24"}, expected: "10.10.1.0/26"},
+		{pool: "10.10.0.0/24", prefixLength: 25, used: []string{"10.10.0.0/25", "10.10.0.128/25"}, expectError: true},
+		{pool: "10.10.0.0/24", prefixLength: 16, expectError: true},
+		{pool: "fd00::/64", prefixLength: 80, expectError: true},
+	}
+	for _, test := range tests {
+		subnet, err := nextFreeSubnet(test.pool, test.prefixLength, test.used)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("expected error for /%d in %s, got %s", test.prefixLength, test.pool, subnet)
+			}
+			continue
+		}
+		if err != nil || subnet != test.expected {
+			t.Errorf("expected %s for /%d in %s used %v, got %s (%v)", test.expected, test.prefixLength, test.pool, test.used, subnet, err)
+		}
+	}
+}
+
+func TestExhaustedSubnetPoolFails(t *testing.T) {
+	taken := newTenantNetwork("taken")
+	taken.Status.Subnet = "10.10.0.0/24"
+	tn := newTenantNetwork("team-a")
+	tn.Spec.Flavor = "tiny"
+	flavors := newFlavorConfigMap()
+	flavors.Data["tiny"] = `
+virtualRouter:
+  image: tmaxcloudck/virtualrouter:v0.2.5
+subnetPool: 10.10.0.0/24
+subnetPrefixLength: 24
+`
+	c, client, _, _ := newTestController(t, []*networkcontroller.TenantNetwork{taken, tn}, flavors)
+
+	if err := c.syncHandler("tenant/team-a"); err != nil {
+		t.Fatalf("error syncing tenantNetwork: %v", err)
+	}
+
+	tn, err := client.TmaxV1().TenantNetworks("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn.Status.Phase != networkcontroller.TenantNetworkFailed || !strings.Contains(tn.Status.Message, "no free /24 left") {
+		t.Errorf("unexpected tenantNetwork status %+v", tn.Status)
+	}
+	if _, err := client.TmaxV1().VirtualRouters("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{}); err == nil {
+		t.Errorf("virtualRouter created without a subnet")
+	}
+}
+
+func TestReleasesFinalizerWithoutIPAM(t *testing.T) {
+	tn := newTenantNetwork("team-a")
+	now := metav1.Now()
+	tn.DeletionTimestamp = &now
+	tn.Finalizers = []string{TENANT_NETWORK_FINALIZER}
+	tn.Status.Subnet = "10.10.1.0/24"
+	tn.Status.SubnetReference = "42"
+	c, client, _, _ := newTestController(t, []*networkcontroller.TenantNetwork{tn}, newFlavorConfigMap())
+
+	if err := c.syncHandler("tenant/team-a"); err != nil {
+		t.Fatalf("error syncing tenantNetwork: %v", err)
+	}
+
+	tn, err := client.TmaxV1().TenantNetworks("tenant").Get(context.TODO(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.Finalizers) != 0 {
+		t.Errorf("expected the finalizer to be removed, got %v", tn.Finalizers)
+	}
+}
@@ -0,0 +1,66 @@
+package tenantnetwork
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const DEFAULT_SUBNET_PREFIX_LENGTH int = 24
+
+// Flavor is what the platform team offers to tenants: the VirtualRouter they
+// get, the pool their subnet comes from and their quota. Flavors are the
+// keys of the flavor ConfigMap, each holding a Flavor in YAML.
+type Flavor struct {
+	// VirtualRouter is the spec of the tenant VirtualRouter. The deployment
+	// name, VLAN, internal address and placement are set by the controller.
+	VirtualRouter samplev1alpha1.VirtualRouterSpec `json:"virtualRouter"`
+	// SubnetPool is the network tenant subnets are allocated from
+	SubnetPool string `json:"subnetPool"`
+	// SubnetPrefixLength is the size of tenant subnets, defaults to 24
+	SubnetPrefixLength int `json:"subnetPrefixLength,omitempty"`
+	// Quota is the ResourceQuota of the tenant namespace, none if empty
+	Quota corev1.ResourceList `json:"quota,omitempty"`
+}
+
+// provisionError means the TenantNetwork can't be provisioned as requested,
+// which only a change of the flavors or of the TenantNetwork fixes.
+type provisionError struct {
+	message string
+}
+
+func (e *provisionError) Error() string {
+	return e.message
+}
+
+func (c *Controller) flavor(name string) (*Flavor, error) {
+	configMap, err := c.kubeclientset.CoreV1().ConfigMaps(c.options.ControllerNamespace).Get(context.TODO(), c.options.FlavorConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, &provisionError{fmt.Sprintf("flavor ConfigMap %s/%s not found", c.options.ControllerNamespace, c.options.FlavorConfigMap)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, ok := configMap.Data[name]
+	if !ok {
+		return nil, &provisionError{fmt.Sprintf("flavor %q not found", name)}
+	}
+
+	flavor := &Flavor{}
+	if err := yaml.UnmarshalStrict([]byte(content), flavor); err != nil {
+		return nil, &provisionError{fmt.Sprintf("invalid flavor %q: %v", name, err)}
+	}
+	if flavor.SubnetPool == "" {
+		return nil, &provisionError{fmt.Sprintf("flavor %q has no subnetPool", name)}
+	}
+	if flavor.SubnetPrefixLength == 0 {
+		flavor.SubnetPrefixLength = DEFAULT_SUBNET_PREFIX_LENGTH
+	}
+	return flavor, nil
+}
@@ -0,0 +1,177 @@
+package tenantnetwork
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// TENANT_NETWORK_FINALIZER keeps a TenantNetwork until its subnet is
+// released in the IPAM.
+const TENANT_NETWORK_FINALIZER string = "virtualrouter/tenantnetwork-finalizer"
+
+// ensureSubnet allocates the subnet of the tenant, from the IPAM when one is
+// set or else out of the subnets of the other TenantNetworks. The subnet is
+// recorded in the status right away, so it is never allocated twice.
+func (c *Controller) ensureSubnet(tenantNetwork *samplev1alpha1.TenantNetwork, flavor *Flavor) (*samplev1alpha1.TenantNetwork, error) {
+	if tenantNetwork.Status.Subnet != "" {
+		return tenantNetwork, nil
+	}
+
+	// concurrent workers would otherwise pick the same free subnet
+	c.subnetLock.Lock()
+	defer c.subnetLock.Unlock()
+
+	tenantNetworkCopy := tenantNetwork.DeepCopy()
+	if c.options.IPAM != nil {
+		allocation, err := c.options.IPAM.AllocatePrefix(flavor.SubnetPool, flavor.SubnetPrefixLength, fmt.Sprintf("tenantnetwork %s/%s (%s)", tenantNetwork.Namespace, tenantNetwork.Name, tenantNetwork.UID))
+		if err != nil {
+			return nil, err
+		}
+		tenantNetworkCopy.Status.Subnet = allocation.CIDR
+		tenantNetworkCopy.Status.SubnetReference = allocation.Reference
+		if !hasFinalizer(tenantNetworkCopy.Finalizers, TENANT_NETWORK_FINALIZER) {
+			tenantNetworkCopy.Finalizers = append(tenantNetworkCopy.Finalizers, TENANT_NETWORK_FINALIZER)
+			updated, err := c.sampleclientset.TmaxV1().TenantNetworks(tenantNetwork.Namespace).Update(context.TODO(), tenantNetworkCopy, metav1.UpdateOptions{})
+			if err != nil {
+				return nil, err
+			}
+			updated.Status = tenantNetworkCopy.Status
+			tenantNetworkCopy = updated
+		}
+	} else {
+		tenantNetworks, err := c.tenantNetworksLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		var used []string
+		recorded := map[string]bool{}
+		for _, other := range tenantNetworks {
+			if other.Status.Subnet != "" {
+				used = append(used, other.Status.Subnet)
+				recorded[other.Status.Subnet] = true
+			}
+		}
+		// the cache may lag behind subnets allocated moments ago
+		for subnet := range c.allocatedSubnets {
+			if recorded[subnet] {
+				delete(c.allocatedSubnets, subnet)
+				continue
+			}
+			used = append(used, subnet)
+		}
+		subnet, err := nextFreeSubnet(flavor.SubnetPool, flavor.SubnetPrefixLength, used)
+		if err != nil {
+			return nil, &provisionError{err.Error()}
+		}
+		tenantNetworkCopy.Status.Subnet = subnet
+	}
+
+	klog.Infof("Allocated subnet %s to TenantNetwork %s/%s", tenantNetworkCopy.Status.Subnet, tenantNetwork.Namespace, tenantNetwork.Name)
+	updated, err := c.sampleclientset.TmaxV1().TenantNetworks(tenantNetwork.Namespace).UpdateStatus(context.TODO(), tenantNetworkCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	c.allocatedSubnets[updated.Status.Subnet] = true
+	return updated, nil
+}
+
+// releaseSubnet frees the subnet of a deleted TenantNetwork in the IPAM.
+// Subnets allocated in the cluster are free as soon as the TenantNetwork is
+// gone.
+func (c *Controller) releaseSubnet(tenantNetwork *samplev1alpha1.TenantNetwork) error {
+	if !hasFinalizer(tenantNetwork.Finalizers, TENANT_NETWORK_FINALIZER) {
+		return nil
+	}
+	// with the IPAM unset since, the subnet is left to be released by hand
+	// rather than the TenantNetwork kept forever
+	if c.options.IPAM == nil {
+		klog.Warningf("Subnet %s of TenantNetwork %s/%s is not released without IPAM", tenantNetwork.Status.Subnet, tenantNetwork.Namespace, tenantNetwork.Name)
+	} else if tenantNetwork.Status.SubnetReference != "" {
+		if err := c.options.IPAM.Release(ipam.Allocation{CIDR: tenantNetwork.Status.Subnet, Reference: tenantNetwork.Status.SubnetReference}); err != nil {
+			return err
+		}
+	}
+	tenantNetworkCopy := tenantNetwork.DeepCopy()
+	tenantNetworkCopy.Finalizers = nil
+	for _, finalizer := range tenantNetwork.Finalizers {
+		if finalizer != TENANT_NETWORK_FINALIZER {
+			tenantNetworkCopy.Finalizers = append(tenantNetworkCopy.Finalizers, finalizer)
+		}
+	}
+	_, err := c.sampleclientset.TmaxV1().TenantNetworks(tenantNetwork.Namespace).Update(context.TODO(), tenantNetworkCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// nextFreeSubnet returns the first subnet of prefixLength inside pool that
+// overlaps none of the used ones.
+func nextFreeSubnet(pool string, prefixLength int, used []string) (string, error) {
+	_, poolNet, err := net.ParseCIDR(pool)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet pool %q: %v", pool, err)
+	}
+	poolLength, bits := poolNet.Mask.Size()
+	if bits != 32 || poolNet.IP.To4() == nil {
+		return "", fmt.Errorf("subnet pool %s is not an IPv4 network", pool)
+	}
+	if prefixLength < poolLength || prefixLength > 30 {
+		return "", fmt.Errorf("subnet prefix length %d doesn't fit pool %s", prefixLength, pool)
+	}
+
+	var usedNets []*net.IPNet
+	for _, subnet := range used {
+		if _, usedNet, err := net.ParseCIDR(subnet); err == nil {
+			usedNets = append(usedNets, usedNet)
+		}
+	}
+
+	start := binary.BigEndian.Uint32(poolNet.IP.To4())
+	size := uint32(1) << uint(32-prefixLength)
+	count := uint64(1) << uint(prefixLength-poolLength)
+	for i := uint64(0); i < count; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start+uint32(i)*size)
+		candidate := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLength, 32)}
+		free := true
+		for _, usedNet := range usedNets {
+			if usedNet.Contains(candidate.IP) || candidate.Contains(usedNet.IP) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("subnet pool %s has no free /%d left", pool, prefixLength)
+}
+
+// gateway returns the first address of the subnet, taken by the router, and
+// the netmask of the subnet.
+func gateway(subnet string) (string, string, error) {
+	_, subnetNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", "", err
+	}
+	ip := make(net.IP, len(subnetNet.IP))
+	copy(ip, subnetNet.IP)
+	ip[len(ip)-1]++
+	return ip.String(), net.IP(subnetNet.Mask).String(), nil
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
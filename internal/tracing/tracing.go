@@ -0,0 +1,125 @@
+// Package tracing traces the reconciliation of VirtualRouters, from the
+// controller syncs to the daemons applying them to the data plane.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// TRACE_ANNOTATION_PREFIX prefixes the W3C trace context kept in the
+// annotations of a VirtualRouter (network.tmaxanc.com/traceparent and
+// network.tmaxanc.com/tracestate). The controller and the daemons only talk
+// through the VirtualRouter, so it carries the trace of the change being
+// reconciled from one to the other.
+const TRACE_ANNOTATION_PREFIX string = "network.tmaxanc.com/"
+
+const instrumentationName = "github.com/tmax-cloud/virtualrouter-controller"
+
+var propagator = propagation.TraceContext{}
+
+// Setup exports the spans to the OTLP gRPC endpoint, and returns a function
+// flushing the spans left on shutdown. Nothing is exported if no endpoint is
+// given.
+func Setup(endpoint string, serviceName string) (func(), error) {
+	if endpoint == "" {
+		return func() {}, nil
+	}
+	exporter, err := otlptracegrpc.New(context.Background(),
+		otlptracegrpc.WithEndpoint(endpoint),
+		otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))))
+	otel.SetTracerProvider(provider)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			klog.Errorf("Error flushing spans: %s", err.Error())
+		}
+	}, nil
+}
+
+// Tracer returns the tracer of the controller and the daemons.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Trace runs fn in a span of the given name.
+func Trace(ctx context.Context, name string, fn func() error) error {
+	_, span := Tracer().Start(ctx, name)
+	err := fn()
+	End(span, err)
+	return err
+}
+
+// End ends the span, marking it failed if err is set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Start starts the span of a reconciliation of the object. The trace context
+// stays in the annotations after the change it was given with, so only the
+// reconciliation of that change joins its trace, and later ones are linked to
+// it.
+func Start(object metav1.Object, reconciled bool, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	parent := propagator.Extract(context.Background(), annotationCarrier(object.GetAnnotations()))
+	if !reconciled {
+		return Tracer().Start(parent, name, trace.WithAttributes(attributes...))
+	}
+	var links []trace.Link
+	if spanContext := trace.SpanContextFromContext(parent); spanContext.IsValid() {
+		links = append(links, trace.Link{SpanContext: spanContext})
+	}
+	return Tracer().Start(context.Background(), name, trace.WithAttributes(attributes...), trace.WithLinks(links...))
+}
+
+// InjectObject keeps the trace context of ctx in the annotations of the
+// object, so the reconciliation of the object joins the trace.
+func InjectObject(ctx context.Context, object metav1.Object) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	propagator.Inject(ctx, annotationCarrier(annotations))
+	object.SetAnnotations(annotations)
+}
+
+// annotationCarrier holds the trace context headers as prefixed annotations.
+type annotationCarrier map[string]string
+
+func (c annotationCarrier) Get(key string) string {
+	return c[TRACE_ANNOTATION_PREFIX+key]
+}
+
+func (c annotationCarrier) Set(key string, value string) {
+	c[TRACE_ANNOTATION_PREFIX+key] = value
+}
+
+func (c annotationCarrier) Keys() []string {
+	var keys []string
+	for key := range c {
+		if strings.HasPrefix(key, TRACE_ANNOTATION_PREFIX) {
+			keys = append(keys, strings.TrimPrefix(key, TRACE_ANNOTATION_PREFIX))
+		}
+	}
+	return keys
+}
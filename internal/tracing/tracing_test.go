@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTraceContextInAnnotations(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	otel.SetTracerProvider(provider)
+	ctx, span := provider.Tracer("test").Start(context.Background(), "change")
+	defer span.End()
+
+	object := &metav1.ObjectMeta{Annotations: map[string]string{"other": "kept"}}
+	InjectObject(ctx, object)
+	if object.Annotations["other"] != "kept" || object.Annotations[TRACE_ANNOTATION_PREFIX+"traceparent"] == "" {
+		t.Fatalf("unexpected annotations %v", object.Annotations)
+	}
+
+	// the change is reconciled within its trace
+	_, reconcile := Start(object, false, "reconcile")
+	if got := reconcile.SpanContext().TraceID(); got != span.SpanContext().TraceID() {
+		t.Errorf("expected trace %s, got %s", span.SpanContext().TraceID(), got)
+	}
+
+	// later reconciliations are linked to it
+	_, later := Start(object, true, "reconcile")
+	if later.SpanContext().TraceID() == span.SpanContext().TraceID() {
+		t.Errorf("reconciled change joined trace %s", span.SpanContext().TraceID())
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DEFAULT_WORKERS                int32         = 2
+	DEFAULT_TENANT_NETWORK_WORKERS int32         = 1
+	DEFAULT_RESYNC_PERIOD          time.Duration = 30 * time.Second
+)
+
+// SetDefaults_ControllerConfiguration sets the settings that have no flag.
+// The others are left out, keeping the value of their flag.
+func SetDefaults_ControllerConfiguration(obj *ControllerConfiguration) {
+	if obj.Workers == nil {
+		workers := DEFAULT_WORKERS
+		obj.Workers = &workers
+	}
+	if obj.TenantNetworkWorkers == nil {
+		workers := DEFAULT_TENANT_NETWORK_WORKERS
+		obj.TenantNetworkWorkers = &workers
+	}
+	if obj.ResyncPeriod == nil {
+		obj.ResyncPeriod = &metav1.Duration{Duration: DEFAULT_RESYNC_PERIOD}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkcontrollerv1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ControllerConfiguration is the configuration file of the controller, given
+// with --config. Settings left out keep the value of their flag, and flags
+// given on the command line override the file.
+type ControllerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Workers are the VirtualRouters synced at once
+	// +optional
+	Workers *int32 `json:"workers,omitempty"`
+	// TenantNetworkWorkers are the TenantNetworks synced at once
+	// +optional
+	TenantNetworkWorkers *int32 `json:"tenantNetworkWorkers,omitempty"`
+	// ResyncPeriod of the informers
+	// +optional
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	// WatchNamespaces are the namespaces whose VirtualRouters are handled,
+	// as --watch-namespaces
+	// +optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+	// KubeAPIQPS are the queries per second to the API server of the clients
+	// creating the objects of routers, and apart of those writing status, as
+	// --kube-api-qps
+	// +optional
+	KubeAPIQPS *int32 `json:"kubeAPIQPS,omitempty"`
+	// KubeAPIBurst is the burst of queries over KubeAPIQPS, as
+	// --kube-api-burst
+	// +optional
+	KubeAPIBurst *int32 `json:"kubeAPIBurst,omitempty"`
+	// ControllerClass is the spec.controllerClass of the VirtualRouters
+	// handled, as --controller-class
+	// +optional
+	ControllerClass *string `json:"controllerClass,omitempty"`
+	// ManagementCIDRs are kept reachable through every router, as
+	// --management-cidrs. Reloaded on SIGHUP.
+	// +optional
+	ManagementCIDRs []string `json:"managementCIDRs,omitempty"`
+	// DefaultImagePullSecrets are used to pull every router image, as
+	// --default-image-pull-secrets. Reloaded on SIGHUP.
+	// +optional
+	DefaultImagePullSecrets []string `json:"defaultImagePullSecrets,omitempty"`
+	// RuleExpiryWarning is how long ahead of the expiry of a temporary rule a
+	// warning is emitted, as --rule-expiry-warning. Reloaded on SIGHUP.
+	// +optional
+	RuleExpiryWarning *metav1.Duration `json:"ruleExpiryWarning,omitempty"`
+	// NodeFailureGracePeriod is how long router pods are left on a node not
+	// ready, as --node-failure-grace-period. Reloaded on SIGHUP.
+	// +optional
+	NodeFailureGracePeriod *metav1.Duration `json:"nodeFailureGracePeriod,omitempty"`
+	// DrainTimeout is how long the syncs running on shutdown are waited for
+	// before they are cancelled, as --drain-timeout
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+	// StatusBatchInterval is how long the status of VirtualRouters is held
+	// before it is written along with the others, as --status-batch-interval
+	// +optional
+	StatusBatchInterval *metav1.Duration `json:"statusBatchInterval,omitempty"`
+	// EnsureParallelism is how many of the objects of a router are created
+	// at once within a sync, as --ensure-parallelism
+	// +optional
+	EnsureParallelism *int32 `json:"ensureParallelism,omitempty"`
+	// AllowedRegistries are the registries router images must be of, as
+	// --allowed-registries. Reloaded on SIGHUP.
+	// +optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// AllowedUnsafeSysctls are the unsafe sysctls the kubelets allow, as
+	// --allowed-unsafe-sysctls. Reloaded on SIGHUP.
+	// +optional
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls,omitempty"`
+	// SidecarProfiles are the sidecars VirtualRouters declare by name in
+	// spec.sidecars[].profile, named after the profile. Reloaded on SIGHUP.
+	// +optional
+	SidecarProfiles []networkcontrollerv1.RouterSidecar `json:"sidecarProfiles,omitempty"`
+	// MACPools are the MAC pools VirtualRouters are given addresses from by
+	// name in spec.macAddresses.pool. Reloaded on SIGHUP.
+	// +optional
+	MACPools []networkcontrollerv1.MACPool `json:"macPools,omitempty"`
+	// ImageVerificationKey is the cosign public key router images must be
+	// signed with, as --image-verification-key
+	// +optional
+	ImageVerificationKey *string `json:"imageVerificationKey,omitempty"`
+	// MetricsBindAddress is the address metrics are served on, none if
+	// empty, as --metrics-bind-address
+	// +optional
+	MetricsBindAddress *string `json:"metricsBindAddress,omitempty"`
+	// Webhook is the validating webhook of the rules
+	// +optional
+	Webhook WebhookConfiguration `json:"webhook,omitempty"`
+	// FeatureGates turn experimental features on or off
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// WebhookConfiguration is where the validating webhook is served
+type WebhookConfiguration struct {
+	// BindAddress the webhook is served on, none if empty, as
+	// --webhook-bind-address
+	// +optional
+	BindAddress *string `json:"bindAddress,omitempty"`
+	// CertDir holds the tls.crt and tls.key the webhook is served with, as
+	// --webhook-cert-dir. The certificate is reloaded on SIGHUP.
+	// +optional
+	CertDir *string `json:"certDir,omitempty"`
+}
@@ -0,0 +1,230 @@
+package v1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// crdDir is where hack/update-crd.sh writes the CRDs generated from the
+// markers of the types.
+var crdDir = filepath.Join("..", "..", "..", "..", "..", "..", "deploy", "integrated")
+
+var crdFiles = []string{
+	"virtualrouter-crd.yaml",
+	"tenantnetwork-crd.yaml",
+	"virtualrouterquota-crd.yaml",
+	"virtualrouterprofile-crd.yaml",
+	"networkfreeze-crd.yaml",
+}
+
+func readCRD(t *testing.T, file string) *apiextensionsv1.CustomResourceDefinition {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join(crdDir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the apiextensions types vendored predate x-kubernetes-validations, so
+	// they are not decoded
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := yaml.Unmarshal(content, &crd); err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	return &crd
+}
+
+// typeMarkers returns the +kubebuilder markers in the comments above the type
+// of the kind, the markers being apart from the doc comment by a blank line.
+func typeMarkers(t *testing.T, kind string) []string {
+	t.Helper()
+	content, err := ioutil.ReadFile("types.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if line != "type "+kind+" struct {" {
+			continue
+		}
+		var markers []string
+		for j := i - 1; j >= 0 && (strings.HasPrefix(lines[j], "//") || lines[j] == "" && j > 0 && strings.HasPrefix(lines[j-1], "//")); j-- {
+			if marker := strings.TrimPrefix(lines[j], "// +kubebuilder:"); marker != lines[j] {
+				markers = append([]string{marker}, markers...)
+			}
+		}
+		return markers
+	}
+	t.Fatalf("no type %s", kind)
+	return nil
+}
+
+var printColumnMarker = regexp.MustCompile("^printcolumn:name=\"([^\"]+)\",type=(\\w+),JSONPath=`([^`]+)`(?:,priority=(\\d+))?$")
+
+// TestCRDPrinterColumns checks the printer columns and short names of the
+// CRDs are those of the markers of their types, so the CRDs were generated
+// again after the markers changed.
+func TestCRDPrinterColumns(t *testing.T) {
+	for _, file := range crdFiles {
+		crd := readCRD(t, file)
+		var columns []apiextensionsv1.CustomResourceColumnDefinition
+		var shortNames []string
+		for _, marker := range typeMarkers(t, crd.Spec.Names.Kind) {
+			if match := printColumnMarker.FindStringSubmatch(marker); match != nil {
+				column := apiextensionsv1.CustomResourceColumnDefinition{Name: match[1], Type: match[2], JSONPath: match[3]}
+				if match[4] != "" {
+					priority, _ := strconv.Atoi(match[4])
+					column.Priority = int32(priority)
+				}
+				columns = append(columns, column)
+			} else if strings.HasPrefix(marker, "printcolumn:") {
+				t.Errorf("%s: unparsed marker %s", file, marker)
+			}
+			if strings.HasPrefix(marker, "resource:") {
+				for _, argument := range strings.Split(strings.TrimPrefix(marker, "resource:"), ",") {
+					if value := strings.TrimPrefix(argument, "shortName="); value != argument {
+						shortNames = append(shortNames, strings.Split(value, ";")...)
+					}
+				}
+			}
+		}
+		if len(crd.Spec.Versions) != 1 {
+			t.Fatalf("%s: expected one version, got %d", file, len(crd.Spec.Versions))
+		}
+		if generated := crd.Spec.Versions[0].AdditionalPrinterColumns; !reflect.DeepEqual(generated, columns) {
+			t.Errorf("%s: expected the printer columns of the markers %+v, got %+v", file, columns, generated)
+		}
+		if !reflect.DeepEqual(crd.Spec.Names.ShortNames, shortNames) {
+			t.Errorf("%s: expected the short names of the markers %v, got %v", file, shortNames, crd.Spec.Names.ShortNames)
+		}
+	}
+}
+
+// crdSchema is the part of the OpenAPI schema of a CRD its rules are read
+// from.
+type crdSchema struct {
+	MaxLength   int                  `json:"maxLength"`
+	Properties  map[string]crdSchema `json:"properties"`
+	Validations []struct {
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	} `json:"x-kubernetes-validations"`
+}
+
+// virtualRouterSpecSchema returns the schema of the spec of VirtualRouters.
+func virtualRouterSpecSchema(t *testing.T) crdSchema {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join(crdDir, "virtualrouter-crd.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var crd struct {
+		Spec struct {
+			Versions []struct {
+				Schema struct {
+					OpenAPIV3Schema crdSchema `json:"openAPIV3Schema"`
+				} `json:"schema"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(content, &crd); err != nil {
+		t.Fatal(err)
+	}
+	return crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"]
+}
+
+var matchesRule = regexp.MustCompile(`^self == '' \|\| self\.matches\('(.+)'\)$`)
+
+// TestCRDIPv4Rules checks the expressions the CEL rules of the IPv4 fields
+// of VirtualRouters match, RE2 being the syntax of both CEL and Go. The
+// rules as a whole are evaluated by the API server in
+// TestEnvtestCRDValidation.
+func TestCRDIPv4Rules(t *testing.T) {
+	spec := virtualRouterSpecSchema(t).Properties
+	valid := []string{"0.0.0.0", "10.0.0.1", "192.168.9.10", "255.255.255.0", "255.255.255.255"}
+	invalid := []string{"10.0.0.256", "10.0.0.01", "010.0.0.1", "10.0.0", "10.0.0.1.1", "192.168.9.10/24", "fd00::1", "10.0.0.1 ", "a.b.c.d"}
+	for _, field := range []string{"internalIP", "internalNetmask", "externalIP", "externalNetmask", "gatewayIP"} {
+		property := spec[field]
+		if property.MaxLength != 15 || len(property.Validations) != 1 {
+			t.Errorf("%s: expected a length of at most 15 and a rule, got %+v", field, property)
+			continue
+		}
+		match := matchesRule.FindStringSubmatch(property.Validations[0].Rule)
+		if match == nil {
+			t.Errorf("%s: unexpected rule %s", field, property.Validations[0].Rule)
+			continue
+		}
+		expression := regexp.MustCompile(match[1])
+		for _, value := range valid {
+			if !expression.MatchString(value) {
+				t.Errorf("%s: expected %q admitted", field, value)
+			}
+		}
+		for _, value := range invalid {
+			if expression.MatchString(value) {
+				t.Errorf("%s: expected %q rejected with %q", field, value, property.Validations[0].Message)
+			}
+		}
+	}
+}
+
+var presenceRule = regexp.MustCompile(`^has\(self\.(\w+)\) (\|\||==) has\(self\.(\w+)\)$`)
+
+// TestCRDPresenceRules evaluates the rules of the spec of VirtualRouters on
+// which fields are given against the specs as the typed clients send them,
+// the fields left empty being omitted or not as their JSON tags say.
+func TestCRDPresenceRules(t *testing.T) {
+	var rules [][]string
+	for _, validation := range virtualRouterSpecSchema(t).Validations {
+		if match := presenceRule.FindStringSubmatch(validation.Rule); match != nil {
+			rules = append(rules, append(match[1:], validation.Message))
+		}
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected the image and IPv6 rules, got %q", rules)
+	}
+
+	image := "tmaxcloudck/virtualrouter:0.0.1"
+	profile := &VirtualRouterProfileReference{Name: "edge"}
+	tests := []struct {
+		name     string
+		spec     VirtualRouterSpec
+		expected string
+	}{
+		{"image", VirtualRouterSpec{Image: image}, ""},
+		{"profile", VirtualRouterSpec{ProfileRef: profile}, ""},
+		{"image and profile", VirtualRouterSpec{Image: image, ProfileRef: profile}, ""},
+		{"no image", VirtualRouterSpec{}, "image is given unless a profile is referenced"},
+		{"dual-stack", VirtualRouterSpec{Image: image, InternalIPv6CIDR: "fd00:10::1/64", ExternalIPv6CIDR: "2001:db8::10/64"}, ""},
+		{"internal IPv6 alone", VirtualRouterSpec{Image: image, InternalIPv6CIDR: "fd00:10::1/64"}, "internalIPv6CIDR and externalIPv6CIDR are given together"},
+		{"external IPv6 alone", VirtualRouterSpec{Image: image, ExternalIPv6CIDR: "2001:db8::10/64"}, "internalIPv6CIDR and externalIPv6CIDR are given together"},
+	}
+	for _, test := range tests {
+		content, err := json.Marshal(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(content, &fields); err != nil {
+			t.Fatal(err)
+		}
+		var rejected []string
+		for _, rule := range rules {
+			_, first := fields[rule[0]]
+			_, second := fields[rule[2]]
+			if rule[1] == "||" && !(first || second) || rule[1] == "==" && first != second {
+				rejected = append(rejected, rule[3])
+			}
+		}
+		if message := strings.Join(rejected, ", "); message != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, message)
+		}
+	}
+}
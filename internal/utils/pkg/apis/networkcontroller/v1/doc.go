@@ -17,5 +17,5 @@ limitations under the License.
 // +k8s:deepcopy-gen=package
 // +groupName=tmax.hypercloud.com
 
-// Package v1alpha1 is the v1alpha1 version of the API.
-package v1 // import "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1alpha1"
+// Package v1 is the v1 version of the API.
+package v1 // import "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
@@ -49,6 +49,14 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&VirtualRouter{},
 		&VirtualRouterList{},
+		&TenantNetwork{},
+		&TenantNetworkList{},
+		&VirtualRouterQuota{},
+		&VirtualRouterQuotaList{},
+		&VirtualRouterProfile{},
+		&VirtualRouterProfileList{},
+		&NetworkFreeze{},
+		&NetworkFreezeList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
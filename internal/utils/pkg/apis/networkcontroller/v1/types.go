@@ -18,39 +18,1181 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=vr
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.spec.replicas`
+// +kubebuilder:printcolumn:name="External-IP",type=string,JSONPath=`.status.externalIPs[*]`
+// +kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.metadata.generation`,priority=1
+// +kubebuilder:printcolumn:name="Applied",type=integer,JSONPath=`.status.appliedRevision`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // VirtualRouter is a specification for a VirtualRouter resource
 type VirtualRouter struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   VirtualRouterSpec   `json:"spec"`
+	Spec VirtualRouterSpec `json:"spec"`
+	// +optional
 	Status VirtualRouterStatus `json:"status"`
 }
 
 // VirtualRouterSpec is the spec for a VirtualRouter resource
+// +kubebuilder:validation:XValidation:rule="has(self.image) || has(self.profileRef)",message="image is given unless a profile is referenced"
+// +kubebuilder:validation:XValidation:rule="has(self.internalIPv6CIDR) == has(self.externalIPv6CIDR)",message="internalIPv6CIDR and externalIPv6CIDR are given together"
+// +kubebuilder:validation:XValidation:rule="(has(oldSelf.placement) && has(oldSelf.placement.strategy) ? oldSelf.placement.strategy : 'Namespace') == (has(self.placement) && has(self.placement.strategy) ? self.placement.strategy : 'Namespace')",message="placement.strategy can't be changed once the router is created"
 type VirtualRouterSpec struct {
-	DeploymentName  string          `json:"deploymentName"`
-	Replicas        *int32          `json:"replicas"`
-	VlanNumber      int32           `json:"vlanNumber" `
-	InternalIP      string          `json:"internalIP"`
-	InternalNetmask string          `json:"internalNetmask"`
-	ExternalIP      string          `json:"externalIP"`
-	ExternalNetmask string          `json:"externalNetmask"`
-	GatewayIP       string          `json:"gatewayIP"`
-	Image           string          `json:"image"`
-	NodeSelector    []NodeSelector  `json:"nodeSelector"`
-	Affinity        corev1.Affinity `json:"affinity"`
+	// +optional
+	DeploymentName string `json:"deploymentName"`
+	// +kubebuilder:validation:Minimum=1
+	Replicas *int32 `json:"replicas"`
+	// +optional
+	VlanNumber int32 `json:"vlanNumber" `
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:XValidation:rule="self == '' || self.matches('^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])[.]){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$')",message="not an IPv4 address"
+	// +optional
+	InternalIP string `json:"internalIP"`
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:XValidation:rule="self == '' || self.matches('^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])[.]){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$')",message="not an IPv4 netmask"
+	// +optional
+	InternalNetmask string `json:"internalNetmask"`
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:XValidation:rule="self == '' || self.matches('^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])[.]){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$')",message="not an IPv4 address"
+	// +optional
+	ExternalIP string `json:"externalIP"`
+	// ExternalIPPool is the IPAM network the external IP is allocated from
+	// when externalIP is left empty
+	// +optional
+	ExternalIPPool string `json:"externalIPPool,omitempty"`
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:XValidation:rule="self == '' || self.matches('^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])[.]){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$')",message="not an IPv4 netmask"
+	// +optional
+	ExternalNetmask string `json:"externalNetmask"`
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:XValidation:rule="self == '' || self.matches('^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])[.]){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$')",message="not an IPv4 address"
+	// +optional
+	GatewayIP string `json:"gatewayIP"`
+	// InternalIPv6CIDR is the IPv6 address of the router on the internal
+	// network with its prefix length, such as fd00:10::1/64, making the
+	// router dual-stack along with externalIPv6CIDR
+	// +kubebuilder:validation:Format=cidr
+	// +optional
+	InternalIPv6CIDR string `json:"internalIPv6CIDR,omitempty"`
+	// ExternalIPv6CIDR is the IPv6 address of the router on the external
+	// network with its prefix length
+	// +kubebuilder:validation:Format=cidr
+	// +optional
+	ExternalIPv6CIDR string `json:"externalIPv6CIDR,omitempty"`
+	// GatewayIPv6 is the IPv6 next hop of the router, in the external
+	// network or link-local
+	// +kubebuilder:validation:Format=ipv6
+	// +optional
+	GatewayIPv6 string `json:"gatewayIPv6,omitempty"`
+	// Image of the router, which the profile of spec.profileRef gives if
+	// left out
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Image string `json:"image,omitempty"`
+	// ProfileRef names the VirtualRouterProfile giving the router the
+	// defaults it leaves out
+	// +optional
+	ProfileRef *VirtualRouterProfileReference `json:"profileRef,omitempty"`
+	// Resources of the router container, which the profile of
+	// spec.profileRef gives if left out
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// +optional
+	NodeSelector []NodeSelector `json:"nodeSelector"`
+	// +optional
+	Affinity corev1.Affinity `json:"affinity"`
+	// Tolerations let router pods land on tainted gateway nodes
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// ImagePullSecrets are Secrets in the namespace of the VirtualRouter,
+	// mirrored into the router namespace to pull the router image
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// EnvFrom are Secrets in the namespace of the VirtualRouter exposed to the
+	// router container as environment variables, such as BGP passwords
+	// +optional
+	EnvFrom []SecretEnvSource `json:"envFrom,omitempty"`
+	// SecretRefs are Secrets in the namespace of the VirtualRouter mounted
+	// into the router container as files, such as VPN pre-shared keys
+	// +optional
+	SecretRefs []SecretMount `json:"secretRefs,omitempty"`
+	// UpgradeStrategy is how router pods are replaced when the spec changes
+	// +optional
+	UpgradeStrategy VirtualRouterUpgradeStrategy `json:"upgradeStrategy,omitempty"`
+	// SecurityProfile is how much router containers are trusted with,
+	// defaults to that of the profile of spec.profileRef, or Privileged
+	// +optional
+	SecurityProfile VirtualRouterSecurityProfile `json:"securityProfile,omitempty"`
+	// Sysctls are set in the network namespace of router pods before the
+	// router container starts, such as net.ipv4.conf.all.rp_filter, in the
+	// security context of the pod if the kubelets allow them, otherwise by
+	// an init container. They are added to, or override, the forwarding
+	// sysctls of the Restricted profile.
+	// +optional
+	Sysctls []RouterSysctl `json:"sysctls,omitempty"`
+	// NodeSysctls are set on the nodes router pods run on by the daemons,
+	// if allowed by their --allowed-node-sysctls, and put back once no
+	// router pod of the node sets them
+	// +optional
+	NodeSysctls []RouterSysctl `json:"nodeSysctls,omitempty"`
+	// Placement is where the resources of the router are created, it can't
+	// be changed once the router is created
+	// +optional
+	Placement VirtualRouterPlacement `json:"placement,omitempty"`
+	// ConfigSource is where router pods take their NAT, firewall, load
+	// balancer and interface configuration from
+	// +optional
+	ConfigSource VirtualRouterConfigSource `json:"configSource,omitempty"`
+	// SLAProbe has the daemons continuously probe an external address from
+	// the internal network through the router, exporting the latency
+	// +optional
+	SLAProbe *SLAProbe `json:"slaProbe,omitempty"`
+	// ControllerClass picks the controller the VirtualRouter is handled by,
+	// when controllers of several teams run in the cluster. Controllers
+	// handle only the VirtualRouters of their --controller-class, the
+	// default controller those without one.
+	// +optional
+	ControllerClass string `json:"controllerClass,omitempty"`
+	// PolicyRouting are secondary routing tables of the router, and the
+	// rules selecting the traffic routed with them
+	// +optional
+	PolicyRouting []RoutingTable `json:"policyRouting,omitempty"`
+	// DHCP has the router hand out addresses of the internal network
+	// +optional
+	DHCP *DHCP `json:"dhcp,omitempty"`
+	// DNS has the router resolve names for the internal network
+	// +optional
+	DNS *DNS `json:"dns,omitempty"`
+	// PortForwards expose services of the internal network on the external
+	// address of the router, compiled into a NATRule of the router
+	// +optional
+	PortForwards []PortForward `json:"portForwards,omitempty"`
+	// SNATPool has the traffic of the internal network leave the router from
+	// several external addresses allocated from the IPAM, each internal host
+	// always from the same one
+	// +optional
+	SNATPool *SNATPool `json:"snatPool,omitempty"`
+	// QoS shapes the traffic through the router, so hosts of the internal
+	// network share its bandwidth fairly
+	// +optional
+	QoS *QoS `json:"qos,omitempty"`
+	// FirewallHardening drops the traffic flooding the router and the
+	// networks behind it, ahead of the FireWallRules
+	// +optional
+	FirewallHardening *FirewallHardening `json:"firewallHardening,omitempty"`
+	// Tunnels connect the router to remote routers and devices over GRE or
+	// IPIP, for peers that don't speak VXLAN
+	// +optional
+	Tunnels []Tunnel `json:"tunnels,omitempty"`
+	// WireGuard is a VPN of the router with remote peers. The key pair of the
+	// router is generated by the controller and kept in a Secret of the
+	// router namespace.
+	// +optional
+	WireGuard *WireGuard `json:"wireGuard,omitempty"`
+	// DataPlaneFailover has the active router pod replaced when the daemon
+	// finds its data plane unable to forward traffic while it is running, as
+	// long as a standby router pod has a healthy one
+	// +optional
+	DataPlaneFailover bool `json:"dataPlaneFailover,omitempty"`
+	// StaticNeighbors are ARP and NDP entries pinned in router pods, for
+	// upstream devices that don't answer neighbor solicitations reliably
+	// +optional
+	StaticNeighbors []StaticNeighbor `json:"staticNeighbors,omitempty"`
+	// MTU of the interfaces of router pods, 1500 if not given
+	// +optional
+	MTU *MTU `json:"mtu,omitempty"`
+	// ExternalSRIOV has router pods request an SR-IOV virtual function of
+	// the device plugin resource and the daemons attach it as the external
+	// interface, instead of a veth on the external bridge
+	// +optional
+	ExternalSRIOV *SRIOV `json:"externalSRIOV,omitempty"`
+	// Autoscaling scales the router pods with their traffic through a
+	// HorizontalPodAutoscaler, replacing replicas. Only for NAT-only routers,
+	// whose pods keep no state another pod would need.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+	// FlowExport has the daemons export the connections through the router
+	// as flow records to a collector, for billing and anomaly detection
+	// +optional
+	FlowExport *FlowExport `json:"flowExport,omitempty"`
+	// Logging has the daemons log the packets matched by the FireWallRules of
+	// the router to a syslog endpoint, telling the rules they matched
+	// +optional
+	Logging *FirewallLogging `json:"logging,omitempty"`
+	// FastPath offloads the 1:1 NAT rules of the router to a fast path of
+	// the daemons, which fall back to the packet filter for the rules, or
+	// routers, it can't take. Experimental, behind the XDPFastPath feature
+	// gate.
+	// +optional
+	FastPath VirtualRouterFastPath `json:"fastPath,omitempty"`
+	// FlowOffload has the daemons offload the established connections of the
+	// router to an nftables flowtable, which forwards their packets without
+	// going through the rules again
+	// +optional
+	FlowOffload *FlowOffload `json:"flowOffload,omitempty"`
+	// Overrides are applied on top of the objects the controller renders
+	// for the router
+	// +optional
+	Overrides *VirtualRouterOverrides `json:"overrides,omitempty"`
+	// Sidecars are containers run in the router pods next to the router,
+	// sharing its network namespace, such as BGP speakers and exporters
+	// +optional
+	Sidecars []RouterSidecar `json:"sidecars,omitempty"`
+	// Persistence gives the router pods a PersistentVolumeClaim for the
+	// state they keep across restarts, such as DHCP leases, conntrack
+	// checkpoints and WireGuard keys
+	// +optional
+	Persistence *RouterPersistence `json:"persistence,omitempty"`
+	// WorkloadKind is the workload running the router pods, Deployment if
+	// not given. StatefulSet pods keep an ordinal identity, the first ready
+	// one being the active router, and a state claim each with persistence
+	// +optional
+	WorkloadKind RouterWorkloadKind `json:"workloadKind,omitempty"`
+	// MACAddresses gives the interfaces of the router pods MAC addresses
+	// kept across restarts, so upstream DHCP servers and static leases keep
+	// knowing the router. They are reported in status.macAddresses
+	// +optional
+	MACAddresses *RouterMACAddresses `json:"macAddresses,omitempty"`
+	// MaintenanceWindow defers the changes disrupting the router, such as
+	// rolling out its pods for a new image or a new ruleset, until the
+	// window opens. They are reported by the PendingChanges condition
+	// meanwhile
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+	// InternalNetworks are internal networks of the router besides that of
+	// spec.internalIP, each on its own vlan of the internal bridge, so one
+	// router serves several tenant subnets
+	// +optional
+	InternalNetworks []InternalNetwork `json:"internalNetworks,omitempty"`
+	// InterNetworkPolicies are how traffic is routed between the internal
+	// networks, compiled into a FireWallRule of the router and the rules the
+	// daemon sets in router pods. Traffic between networks no policy is
+	// given for is denied.
+	// +optional
+	InterNetworkPolicies []InterNetworkPolicy `json:"interNetworkPolicies,omitempty"`
 }
 
+// DEFAULT_INTERNAL_NETWORK_NAME is the name inter-network policies refer to
+// the internal network of spec.internalIP by
+const DEFAULT_INTERNAL_NETWORK_NAME string = "default"
+
+// InternalNetwork is an internal network of the router on a vlan of the
+// internal bridge
+type InternalNetwork struct {
+	// Name of the network, its interface in router pods being int-<name>
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]{0,8}[a-z0-9])?$`
+	Name string `json:"name"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	VlanNumber int32 `json:"vlanNumber"`
+	// CIDR is the IPv4 address of the router on the network with its
+	// prefix length, such as 10.0.1.1/24
+	// +kubebuilder:validation:Format=cidr
+	CIDR string `json:"cidr"`
+	// VRFTable places the network in a VRF of its own routing table, so its
+	// CIDR may overlap those of networks in other VRFs. Networks of the same
+	// table share the VRF; without one the network is routed in the router
+	// table with that of spec.internalIP.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=252
+	// +optional
+	VRFTable int32 `json:"vrfTable,omitempty"`
+}
+
+// InterNetworkPolicy is how traffic from the hosts of an internal network to
+// those of another is routed, replies included. Networks are given by name,
+// default being that of spec.internalIP.
+type InterNetworkPolicy struct {
+	From   string             `json:"from"`
+	To     string             `json:"to"`
+	Action InterNetworkAction `json:"action"`
+}
+
+// InterNetworkAction is what is done with the traffic of an
+// InterNetworkPolicy
+// +kubebuilder:validation:Enum=Allow;Deny;NAT
+type InterNetworkAction string
+
+const (
+	InterNetworkAllow InterNetworkAction = "Allow"
+	InterNetworkDeny  InterNetworkAction = "Deny"
+	// InterNetworkNAT allows the traffic, with the address of the router on
+	// the network it goes to as source, so the hosts there need no route
+	// back to the network it comes from
+	InterNetworkNAT InterNetworkAction = "NAT"
+)
+
+// MaintenanceWindow is when the changes disrupting a router are made
+type MaintenanceWindow struct {
+	// Schedule is when the window opens, as a cron expression of five
+	// fields: minute, hour, day of month, month and day of week, such as
+	// "0 2 * * 6" for 2 AM on Saturdays
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+	// Duration the window stays open for, at most 7 days
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone of the schedule, such as Asia/Seoul, UTC if not given
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// RouterMACAddresses is where the MAC addresses of a router come from
+type RouterMACAddresses struct {
+	// Pool is the MAC pool of the controller configuration the addresses
+	// are allocated from. Without a pool they are locally administered
+	// addresses derived from the UID of the VirtualRouter
+	// +optional
+	Pool string `json:"pool,omitempty"`
+}
+
+// MACPool is a range of MAC addresses routers are given addresses from
+type MACPool struct {
+	// Name routers refer to the pool by in spec.macAddresses.pool
+	Name string `json:"name"`
+	// Prefix is the leading one to five octets of the addresses, such as
+	// 02:42:0a, of a unicast address
+	Prefix string `json:"prefix"`
+}
+
+// RouterWorkloadKind is the kind of the workload running the router pods
+// +kubebuilder:validation:Enum=Deployment;StatefulSet
+type RouterWorkloadKind string
+
+const (
+	// DeploymentWorkloadKind runs interchangeable router pods, the longest
+	// running ready one being the active router
+	DeploymentWorkloadKind RouterWorkloadKind = "Deployment"
+	// StatefulSetWorkloadKind runs router pods named <deploymentName>-0,
+	// -1 and so on, router-0 being the active router whenever it is ready
+	// and the others standby, in the order of their ordinals
+	StatefulSetWorkloadKind RouterWorkloadKind = "StatefulSet"
+)
+
+// RouterPersistence is the PersistentVolumeClaim of the state of a router
+type RouterPersistence struct {
+	// StorageClassName of the claim, the default class of the cluster if
+	// not given
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// Size of the claim, 1Gi if not given. It can be grown where the
+	// storage class allows volume expansion, never shrunk
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+}
+
+// RouterSidecar is a container run in the router pods next to the router
+type RouterSidecar struct {
+	// Name of the container, unique among the containers of the pod
+	Name string `json:"name"`
+	// Profile is a sidecar profile of the controller configuration the
+	// container is made from. The fields set here take precedence over
+	// those of the profile, env being merged by name
+	// +optional
+	Profile string `json:"profile,omitempty"`
+	// Image of the container, pinned to a version, which the router pods
+	// are rolled onto when it changes. Required without a profile
+	// +optional
+	Image string `json:"image,omitempty"`
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// +optional
+	Args []string `json:"args,omitempty"`
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// +optional
+	Ports []corev1.ContainerPort `json:"ports,omitempty"`
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NetAdmin grants the container NET_ADMIN and NET_RAW, for it to
+	// configure the network of the router as BGP speakers and IPsec daemons
+	// do
+	// +optional
+	NetAdmin bool `json:"netAdmin,omitempty"`
+}
+
+// VirtualRouterOverrides of the objects rendered for a router
+type VirtualRouterOverrides struct {
+	// PodTemplate is a strategic merge patch of the pod template of the
+	// router Deployment, such as extra volumes, env and sidecar containers.
+	// The fields rendered from the spec, and those the router needs to run,
+	// can't be overridden
+	// +optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	PodTemplate *runtime.RawExtension `json:"podTemplate,omitempty"`
+}
+
+// FlowOffload of the connections of a router to a flowtable
+type FlowOffload struct {
+	// Hardware has the interfaces of the router forward the offloaded
+	// connections themselves where their NICs allow, falling back to the
+	// software flowtable where they don't
+	// +optional
+	Hardware bool `json:"hardware,omitempty"`
+}
+
+// FirewallLogging of the packets matched by FireWallRules
+type FirewallLogging struct {
+	// Endpoint is the syslog endpoint, udp://host:port or tcp://host:port,
+	// reached from the node network
+	// +kubebuilder:validation:XValidation:rule="self.startsWith('udp://') || self.startsWith('tcp://')",message="the endpoint is udp://host:port or tcp://host:port"
+	Endpoint string `json:"endpoint"`
+	// Policies are the policies of the rules whose packets are logged, DROP
+	// if empty
+	// +optional
+	Policies []FirewallPolicy `json:"policies,omitempty"`
+	// RateLimit is the most packets of each rule logged per second, 10 if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10000
+	// +optional
+	RateLimit int32 `json:"rateLimit,omitempty"`
+}
+
+// FirewallPolicy is the policy of a rule of a FireWallRule
+// +kubebuilder:validation:Enum=ACCEPT;DROP;REJECT
+type FirewallPolicy string
+
+const (
+	FirewallAccept FirewallPolicy = "ACCEPT"
+	FirewallDrop   FirewallPolicy = "DROP"
+	FirewallReject FirewallPolicy = "REJECT"
+)
+
+// FlowExport of the connections tracked by the router pods
+type FlowExport struct {
+	// Collector is the host:port of the collector, reached over UDP from
+	// the node network
+	Collector string `json:"collector"`
+	// Protocol of the flow records, IPFIX if left empty
+	// +kubebuilder:default=IPFIX
+	// +optional
+	Protocol FlowExportProtocol `json:"protocol,omitempty"`
+	// SamplingRate exports 1 in SamplingRate connections, all of them if 0
+	// or 1
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	SamplingRate int32 `json:"samplingRate,omitempty"`
+}
+
+// FlowExportProtocol is the protocol flow records are exported with
+// +kubebuilder:validation:Enum=IPFIX;NetFlowV9
+type FlowExportProtocol string
+
+const (
+	FlowExportIPFIX     FlowExportProtocol = "IPFIX"
+	FlowExportNetFlowV9 FlowExportProtocol = "NetFlowV9"
+)
+
+// Autoscaling of the router pods on the traffic metrics the daemons export
+// +kubebuilder:validation:XValidation:rule="!has(self.minReplicas) || self.minReplicas <= self.maxReplicas",message="minReplicas is more than maxReplicas"
+type Autoscaling struct {
+	// MinReplicas defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetPacketsPerSecond is the packets per second a router pod is to
+	// receive on average, on its internal and external interfaces together
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TargetPacketsPerSecond int64 `json:"targetPacketsPerSecond,omitempty"`
+	// TargetSessions is the connections a router pod is to track on average
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TargetSessions int64 `json:"targetSessions,omitempty"`
+}
+
+// MTU of the internal and external interfaces of the router
+type MTU struct {
+	// Internal is the MTU of the internal interface, 1500 if 0 unless
+	// probed
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=9216
+	// +optional
+	Internal int32 `json:"internal,omitempty"`
+	// External is the MTU of the external interface, 1500 if 0 unless
+	// probed
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=9216
+	// +optional
+	External int32 `json:"external,omitempty"`
+	// Auto has the daemons probe the path MTU towards the gateway and set
+	// the interfaces given no MTU to it
+	// +optional
+	Auto bool `json:"auto,omitempty"`
+}
+
+// SRIOV is the SR-IOV virtual function an interface of the router is
+type SRIOV struct {
+	// ResourceName is the resource of the SR-IOV device plugin the virtual
+	// functions are requested from, such as intel.com/sriov_netdevice
+	// +kubebuilder:validation:MinLength=1
+	ResourceName string `json:"resourceName"`
+	// VFCount is the number of virtual functions requested, 1 if 0. The
+	// first one is the interface of the router.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	VFCount int32 `json:"vfCount,omitempty"`
+	// VLAN the virtual function tags and strips, none if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	VLAN int32 `json:"vlan,omitempty"`
+	// MAC is set on the virtual function, which keeps its own if empty
+	// +kubebuilder:validation:Format=mac
+	// +optional
+	MAC string `json:"mac,omitempty"`
+	// SpoofCheck has the physical function drop the frames of the virtual
+	// function from another MAC, on if not set
+	// +optional
+	SpoofCheck *bool `json:"spoofCheck,omitempty"`
+}
+
+// StaticNeighbor is a permanent neighbor entry of the router
+type StaticNeighbor struct {
+	// IP is the IPv4 or IPv6 address of the neighbor
+	IP string `json:"ip"`
+	// MAC is the link layer address of the neighbor, such as
+	// 52:54:00:12:34:56
+	// +kubebuilder:validation:Format=mac
+	MAC string `json:"mac"`
+	// Interface the neighbor is reached through, External if left empty
+	// +kubebuilder:default=External
+	// +optional
+	Interface NeighborInterface `json:"interface,omitempty"`
+}
+
+// NeighborInterface is the network of the router a StaticNeighbor is on
+// +kubebuilder:validation:Enum=External;Internal
+type NeighborInterface string
+
+const (
+	NeighborExternal NeighborInterface = "External"
+	NeighborInternal NeighborInterface = "Internal"
+)
+
+// Tunnel is a point-to-point GRE or IPIP tunnel from the external network of
+// the router to a remote endpoint
+type Tunnel struct {
+	// Name of the tunnel interface in router pods, unique in the router
+	// +kubebuilder:validation:MaxLength=15
+	Name string     `json:"name"`
+	Mode TunnelMode `json:"mode"`
+	// Local is the address the tunnel is sent from, the external IP if left
+	// empty
+	// +optional
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote"`
+	// Key tells apart GRE tunnels between the same endpoints, none if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4294967295
+	// +optional
+	Key int64 `json:"key,omitempty"`
+	// TTL of the tunneled packets, inherited from the inner packets if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=255
+	// +optional
+	TTL int32 `json:"ttl,omitempty"`
+	// Address of the router on the tunnel with its prefix length, such as
+	// 169.254.10.1/30
+	// +kubebuilder:validation:Format=cidr
+	// +optional
+	Address string `json:"address,omitempty"`
+	// Routes are the networks routed through the tunnel
+	// +optional
+	Routes []string `json:"routes,omitempty"`
+}
+
+// TunnelMode is the encapsulation of a Tunnel
+// +kubebuilder:validation:Enum=GRE;IPIP
+type TunnelMode string
+
+const (
+	TunnelGRE  TunnelMode = "GRE"
+	TunnelIPIP TunnelMode = "IPIP"
+)
+
+// WireGuard is the wg interface of the router, listening on the external IP
+type WireGuard struct {
+	// ListenPort is the UDP port of the interface, 51820 if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	ListenPort int32 `json:"listenPort,omitempty"`
+	// Address of the router on the VPN with its prefix length, such as
+	// 10.100.0.1/24
+	// +kubebuilder:validation:Format=cidr
+	Address string          `json:"address"`
+	Peers   []WireGuardPeer `json:"peers,omitempty"`
+}
+
+// WireGuardPeer is a remote end of the VPN of the router
+type WireGuardPeer struct {
+	// PublicKey of the peer, base64 encoded
+	PublicKey string `json:"publicKey"`
+	// Endpoint is the host:port the peer is reached at, the peer connecting
+	// first if left empty
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// AllowedIPs are the networks behind the peer, routed through the VPN
+	AllowedIPs []string `json:"allowedIPs"`
+	// PersistentKeepalive is how many seconds apart keepalives are sent to
+	// the peer, none if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	PersistentKeepalive int32 `json:"persistentKeepalive,omitempty"`
+}
+
+// FirewallHardening limits the connections forwarded by the router. Dropped
+// packets are counted by the daemons.
+type FirewallHardening struct {
+	// DropInvalid drops the packets conntrack can't relate to a connection
+	// +optional
+	DropInvalid bool `json:"dropInvalid,omitempty"`
+	// MaxConnectionsPerSource limits the connections of each source
+	// address, unlimited if 0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxConnectionsPerSource int32 `json:"maxConnectionsPerSource,omitempty"`
+	// SYNRate limits the TCP connections opened per second, unlimited if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10000
+	// +optional
+	SYNRate int32 `json:"synRate,omitempty"`
+	// SYNBurst is how many connections are opened at once above the rate,
+	// the rate if 0
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10000
+	// +optional
+	SYNBurst int32 `json:"synBurst,omitempty"`
+}
+
+// QoS limits the traffic through the router in each direction
+type QoS struct {
+	// Ingress limits the traffic from the external network to the internal
+	// network
+	// +optional
+	Ingress *QoSLimit `json:"ingress,omitempty"`
+	// Egress limits the traffic from the internal network to the external
+	// network
+	// +optional
+	Egress *QoSLimit `json:"egress,omitempty"`
+}
+
+// QoSLimit is a rate limit shared by its classes. Traffic within a class,
+// and the traffic of no class, is queued fairly by flow.
+type QoSLimit struct {
+	// Rate in bits per second, such as 100M
+	Rate resource.Quantity `json:"rate"`
+	// Burst is the bytes sent at line rate above the rate, chosen from the
+	// rate if not set
+	// +optional
+	Burst *resource.Quantity `json:"burst,omitempty"`
+	// Classes guarantee hosts of the internal network a share of the rate.
+	// The traffic of no class shares what the classes leave.
+	// +optional
+	Classes []QoSClass `json:"classes,omitempty"`
+}
+
+// QoSClass is a share of a QoSLimit guaranteed to an IPv4 network of hosts
+// in the internal network
+type QoSClass struct {
+	// +kubebuilder:validation:Format=cidr
+	CIDR string `json:"cidr"`
+	// Rate in bits per second guaranteed to the class
+	Rate resource.Quantity `json:"rate"`
+	// Ceil is the most the class takes while the others leave the rate
+	// unused, the rate of the limit if not set
+	// +optional
+	Ceil *resource.Quantity `json:"ceil,omitempty"`
+}
+
+// SNATPool is a pool of external addresses the router source NATs with
+type SNATPool struct {
+	// Pool is the IPAM network the addresses are allocated from
+	Pool string `json:"pool"`
+	// Size is the number of addresses
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=16
+	Size int32 `json:"size"`
+	// Sources are the networks source NATed with the pool, the internal
+	// network if left empty
+	// +optional
+	Sources []string `json:"sources,omitempty"`
+}
+
+// PortForward forwards a port of the external address of the router to a
+// host of the internal network
+type PortForward struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	ExternalPort int32 `json:"externalPort"`
+	// Protocol is TCP if left empty
+	// +kubebuilder:default=TCP
+	// +optional
+	Protocol PortForwardProtocol `json:"protocol,omitempty"`
+	// +kubebuilder:validation:Format=ipv4
+	TargetIP string `json:"targetIP"`
+	// TargetPort is the external port if left empty
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+}
+
+// PortForwardProtocol is the protocol of a PortForward
+// +kubebuilder:validation:Enum=TCP;UDP
+type PortForwardProtocol string
+
+const (
+	PortForwardTCP PortForwardProtocol = "TCP"
+	PortForwardUDP PortForwardProtocol = "UDP"
+)
+
+// DNS is the DNS forwarder of the router on its internal interface
+type DNS struct {
+	// Upstreams are the resolvers queries are forwarded to
+	// +kubebuilder:validation:MinItems=1
+	Upstreams []string `json:"upstreams"`
+	// ConditionalForwarders forward the queries for domains to other
+	// resolvers than the upstreams
+	// +optional
+	ConditionalForwarders []DNSForwarder `json:"conditionalForwarders,omitempty"`
+	// Records are names the router answers itself
+	// +optional
+	Records []DNSRecord `json:"records,omitempty"`
+	// HealthCheckName is resolved through the router to tell whether the
+	// forwarder is healthy, example.com if left empty
+	// +optional
+	HealthCheckName string `json:"healthCheckName,omitempty"`
+}
+
+// DNSForwarder forwards the queries for a domain and its subdomains
+type DNSForwarder struct {
+	Domain  string   `json:"domain"`
+	Servers []string `json:"servers"`
+}
+
+// DNSRecord is an address record the router answers itself
+type DNSRecord struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// DHCP is the DHCP server of the router on its internal interface. The router
+// is given to clients as their gateway.
+type DHCP struct {
+	// RangeStart and RangeEnd are the first and last addresses handed out,
+	// in the internal network
+	RangeStart string `json:"rangeStart"`
+	RangeEnd   string `json:"rangeEnd"`
+	// LeaseTime of the addresses, 12 hours if not set
+	// +optional
+	LeaseTime *metav1.Duration `json:"leaseTime,omitempty"`
+	// DNSServers given to clients
+	// +optional
+	DNSServers []string `json:"dnsServers,omitempty"`
+	// Reservations are addresses always handed out to the same client. They
+	// may be outside of the range.
+	// +optional
+	Reservations []DHCPReservation `json:"reservations,omitempty"`
+}
+
+// DHCPReservation is an address reserved for a client
+type DHCPReservation struct {
+	// +kubebuilder:validation:Format=mac
+	MAC string `json:"mac"`
+	IP  string `json:"ip"`
+	// +optional
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// RoutingTable is a secondary routing table of the router
+type RoutingTable struct {
+	// ID of the table, unique in the router. Table 200 is used by the
+	// router itself.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=252
+	// +kubebuilder:validation:XValidation:rule="self != 200",message="table 200 is used by the router itself"
+	ID int32 `json:"id"`
+	// +optional
+	Routes []PolicyRoute `json:"routes,omitempty"`
+	// Rules select the traffic routed with the table
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// PolicyRoute is a route of a secondary routing table
+type PolicyRoute struct {
+	// Destination network, the default route if empty
+	// +optional
+	Destination string `json:"destination,omitempty"`
+	Gateway     string `json:"gateway"`
+}
+
+// PolicyRule selects traffic by its source network, its firewall mark or both
+type PolicyRule struct {
+	// +optional
+	Source string `json:"source,omitempty"`
+	// +optional
+	Mark int32 `json:"mark,omitempty"`
+	// Priority of the rule, lower ones first, chosen by the kernel if 0
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// SLAProbe sends ICMP echo requests from a probe endpoint in the internal
+// network through a router pod, its NAT and external interface, to an
+// external address. The same address is probed from the node directly, so
+// the latency the router adds can be told apart.
+type SLAProbe struct {
+	// SourceIP is an unused address of the internal network the probe
+	// endpoint takes on every node running a router pod
+	SourceIP string `json:"sourceIP"`
+	// Target is the external address probed, the gateway if left empty
+	// +optional
+	Target string `json:"target,omitempty"`
+	// Interval is the time between probes, a second if not set
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// VirtualRouterConfigSource is where router pods take their configuration from
+// +kubebuilder:validation:Enum=API;ConfigMap
+type VirtualRouterConfigSource string
+
+const (
+	// APIConfigSource has router pods watch the rules in the API, the default
+	APIConfigSource VirtualRouterConfigSource = "API"
+	// ConfigMapConfigSource has the controller compile the configuration into
+	// a ConfigMap mounted into router pods, which are rolled out when it
+	// changes
+	ConfigMapConfigSource VirtualRouterConfigSource = "ConfigMap"
+)
+
+type SecretEnvSource struct {
+	SecretName string `json:"secretName"`
+	// Prefix is prepended to the keys of the Secret to name the variables
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+type SecretMount struct {
+	SecretName string `json:"secretName"`
+	// MountPath defaults to /etc/virtualrouter/secrets/<secretName>
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// VirtualRouterPlacementStrategy is where the resources of a router are created
+// +kubebuilder:validation:Enum=Namespace;Tenant
+type VirtualRouterPlacementStrategy string
+
+const (
+	// NamespacePlacementStrategy creates the resources of the router in a
+	// dedicated namespace named after the VirtualRouter
+	NamespacePlacementStrategy VirtualRouterPlacementStrategy = "Namespace"
+	// TenantPlacementStrategy creates the resources of the router in the
+	// namespace of the VirtualRouter, prefixed with the VirtualRouter name
+	TenantPlacementStrategy VirtualRouterPlacementStrategy = "Tenant"
+)
+
+// VirtualRouterSecurityProfile is the security context of router containers
+// +kubebuilder:validation:Enum=Privileged;Restricted
+type VirtualRouterSecurityProfile string
+
+const (
+	// PrivilegedSecurityProfile runs router containers privileged
+	PrivilegedSecurityProfile VirtualRouterSecurityProfile = "Privileged"
+	// RestrictedSecurityProfile runs router containers unprivileged with only
+	// NET_ADMIN and NET_RAW, the runtime default seccomp profile and a
+	// read-only root filesystem. An init container sets the sysctls of the
+	// router that its read-only /proc/sys keeps it from setting.
+	RestrictedSecurityProfile VirtualRouterSecurityProfile = "Restricted"
+)
+
+// VirtualRouterFastPath is how the daemons forward the traffic of the router
+// without the packet filter
+// +kubebuilder:validation:Enum=XDP
+type VirtualRouterFastPath string
+
+const (
+	// XDPFastPath translates the 1:1 NAT rules in an XDP program attached
+	// to the interfaces of the router container
+	XDPFastPath VirtualRouterFastPath = "XDP"
+)
+
+// RouterSysctl is a sysctl of the network namespace of router pods
+type RouterSysctl struct {
+	// Name is a sysctl of the net tree, such as net.ipv4.ip_forward
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type VirtualRouterPlacement struct {
+	// Strategy defaults to Namespace
+	// +optional
+	Strategy VirtualRouterPlacementStrategy `json:"strategy,omitempty"`
+}
+
+// VirtualRouterUpgradeStrategyType is the way router pods are replaced
+// +kubebuilder:validation:Enum=RollingUpdate;DrainStandbyFirst
+type VirtualRouterUpgradeStrategyType string
+
+const (
+	// RollingUpdateUpgradeStrategyType brings up new router pods before the
+	// old ones are removed, so the router never runs below its replicas
+	RollingUpdateUpgradeStrategyType VirtualRouterUpgradeStrategyType = "RollingUpdate"
+	// DrainStandbyFirstUpgradeStrategyType replaces router pods one at a time
+	// without surging, standby pods first and the active pod last, for HA
+	// pairs that can't run a third router
+	DrainStandbyFirstUpgradeStrategyType VirtualRouterUpgradeStrategyType = "DrainStandbyFirst"
+)
+
+type VirtualRouterUpgradeStrategy struct {
+	// Type defaults to RollingUpdate
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Type VirtualRouterUpgradeStrategyType `json:"type,omitempty"`
+	// MaxSurge is the number or percentage of extra router pods during a
+	// RollingUpdate, defaults to 1
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+}
+
+// VirtualRouterPhase is a label for the condition of a VirtualRouter at the current time
+type VirtualRouterPhase string
+
+const (
+	// VirtualRouterPending means no router pod is available yet
+	VirtualRouterPending VirtualRouterPhase = "Pending"
+	// VirtualRouterRunning means all desired router pods are available
+	VirtualRouterRunning VirtualRouterPhase = "Running"
+	// VirtualRouterDegraded means only part of the desired router pods are available
+	VirtualRouterDegraded VirtualRouterPhase = "Degraded"
+	// VirtualRouterUpgrading means router pods are being replaced after a spec change
+	VirtualRouterUpgrading VirtualRouterPhase = "Upgrading"
+	// VirtualRouterTerminating means the VirtualRouter is being deleted
+	VirtualRouterTerminating VirtualRouterPhase = "Terminating"
+)
+
 // VirtualRouterStatus is the status for a VirtualRouter resource
 type VirtualRouterStatus struct {
 	AvailableReplicas int32 `json:"availableReplicas"`
+	// UpdatedReplicas is the number of router pods running the current spec
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// AppliedRevision is the generation of the spec the daemons applied to
+	// every router pod, behind metadata.generation while they are applying a
+	// newer one
+	AppliedRevision int64              `json:"appliedRevision,omitempty"`
+	Phase           VirtualRouterPhase `json:"phase,omitempty"`
+	// ExternalIPs are the external addresses assigned to the router, the
+	// IPv4 one first on dual-stack routers
+	ExternalIPs []string `json:"externalIPs,omitempty"`
+	// ActiveNode is the node running the active router pod
+	ActiveNode        string       `json:"activeNode,omitempty"`
+	LastReconcileTime *metav1.Time `json:"lastReconcileTime,omitempty"`
+	// ExternalIPApproval is set when external IPs need the approval of an
+	// external IPAM before they are assigned
+	ExternalIPApproval *ExternalIPApproval `json:"externalIPApproval,omitempty"`
+	// IPAMAllocation is the external IP allocated from spec.externalIPPool
+	IPAMAllocation *IPAMAllocation `json:"ipamAllocation,omitempty"`
+	// SNATPoolAllocations are the addresses allocated for spec.snatPool
+	// +optional
+	SNATPoolAllocations []IPAMAllocation `json:"snatPoolAllocations,omitempty"`
+	// MACAddresses are the MAC addresses of the router pods with
+	// spec.macAddresses, by ordinal
+	// +optional
+	MACAddresses []RouterMACAddress `json:"macAddresses,omitempty"`
+	// RuleExpirations are the expiring NAT, firewall and load balancer rules
+	// applied by the router
+	RuleExpirations []RuleExpiration `json:"ruleExpirations,omitempty"`
+	// InvalidRuleExpiries are the rules with expiry annotations that can't be
+	// parsed, warned of once per value
+	// +optional
+	InvalidRuleExpiries []InvalidRuleExpiry `json:"invalidRuleExpiries,omitempty"`
+	// WireGuard reports the key and the peers of the VPN of the router
+	// +optional
+	WireGuard *WireGuardStatus `json:"wireGuard,omitempty"`
+	// Conditions report whether the configuration is applied and why the
+	// router is held back
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ReconcileTiming is how long the phases of provisioning took, as of
+	// the last reconcile changing the status
+	ReconcileTiming *ReconcileTiming `json:"reconcileTiming,omitempty"`
+}
+
+// WireGuardStatus is the public key of the router peers configure, and the
+// last handshakes with its peers.
+type WireGuardStatus struct {
+	PublicKey string                `json:"publicKey,omitempty"`
+	Peers     []WireGuardPeerStatus `json:"peers,omitempty"`
+}
+
+// WireGuardPeerStatus is the last handshake of the active router pod with a
+// peer.
+type WireGuardPeerStatus struct {
+	PublicKey string `json:"publicKey"`
+	// LatestHandshakeTime is unset until the first handshake
+	// +optional
+	LatestHandshakeTime *metav1.Time `json:"latestHandshakeTime,omitempty"`
+}
+
+// ReconcileTiming tells where the time provisioning a router goes: the
+// controller phases are spent on the API server or the IPAM, Scheduling on
+// the scheduler and DataPlane on the daemon.
+type ReconcileTiming struct {
+	// ExternalIP is spent allocating the external IP from the IPAM and having
+	// it approved
+	ExternalIP metav1.Duration `json:"externalIP"`
+	// Namespace is spent ensuring the router namespace
+	Namespace metav1.Duration `json:"namespace"`
+	// RBAC is spent ensuring the service account, its role and role binding,
+	// and the pull secrets and credentials of the router
+	RBAC metav1.Duration `json:"rbac"`
+	// Rules is spent pushing the management firewall rule, expiring rules and
+	// writing the router configuration
+	Rules metav1.Duration `json:"rules"`
+	// Deployment is spent creating or updating the router Deployment
+	Deployment metav1.Duration `json:"deployment"`
+	// Scheduling is how long the newest router pod waited to be scheduled
+	Scheduling *metav1.Duration `json:"scheduling,omitempty"`
+	// DataPlane is how long the daemon took to set up the data plane of the
+	// newest router pod once its containers were ready
+	DataPlane *metav1.Duration `json:"dataPlane,omitempty"`
+}
+
+// NamespaceTerminatingCondition is True while the router namespace left by a
+// previous VirtualRouter of the same name is being deleted, which delays the
+// creation of the router
+const NamespaceTerminatingCondition string = "NamespaceTerminating"
+
+// NamespaceConflictCondition is True while the dedicated router namespace
+// exists but isn't owned by the VirtualRouter, which keeps the router from
+// being created
+const NamespaceConflictCondition string = "NamespaceConflict"
+
+// DeploymentNameConflictCondition is True while another VirtualRouter claims
+// the Deployment name in the same router namespace, which keeps the router
+// from being created
+const DeploymentNameConflictCondition string = "DeploymentNameConflict"
+
+// PausedCondition is True while the reconciliation of the VirtualRouter is
+// paused, and False once resumed
+const PausedCondition string = "Paused"
+
+// InvalidSpecCondition is True while the spec can't be applied, which keeps
+// the router from being changed
+const InvalidSpecCondition string = "InvalidSpec"
+
+// DegradedCondition is True while the controller holds the router back from
+// being changed, such as when its image breaks the image policy, with the
+// reason saying why
+const DegradedCondition string = "Degraded"
+
+// DNSHealthyCondition is True while the DNS forwarder of every router pod
+// resolves names, as probed by the daemons
+const DNSHealthyCondition string = "DNSHealthy"
+
+// DataPlaneHealthyCondition is True while the daemons find the data plane of
+// every router pod able to forward traffic
+const DataPlaneHealthyCondition string = "DataPlaneHealthy"
+
+// ConfigAppliedCondition is True once the daemons have applied the current
+// generation of the spec to the data plane of every router pod, and False
+// while they haven't or failed to
+const ConfigAppliedCondition string = "ConfigApplied"
+
+// PendingChangesCondition is True while changes disrupting the router are
+// deferred until its maintenance window
+const PendingChangesCondition string = "PendingChanges"
+
+// RuleExpiration is the expiry of a temporary rule
+type RuleExpiration struct {
+	// Kind is NATRule, FireWallRule or LoadBalancerRule
+	Kind      string      `json:"kind"`
+	Name      string      `json:"name"`
+	ExpiresAt metav1.Time `json:"expiresAt"`
+	// Warned is set once the warning ahead of expiry is emitted
+	Warned bool `json:"warned,omitempty"`
+	// Expired is set once the rule is deactivated. Deleted rules are dropped.
+	Expired bool `json:"expired,omitempty"`
+}
+
+// InvalidRuleExpiry is a rule whose expiry annotations can't be parsed
+type InvalidRuleExpiry struct {
+	// Kind is NATRule, FireWallRule or LoadBalancerRule
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Value is the malformed annotation value the warning was emitted for
+	Value string `json:"value"`
+}
+
+// IPAMAllocation is an address reserved in the enterprise IPAM
+type IPAMAllocation struct {
+	Pool string `json:"pool"`
+	// Address is the allocated address with the prefix length of its network
+	Address string `json:"address"`
+	// Reference identifies the allocation in the IPAM to release it
+	Reference string `json:"reference"`
+}
+
+// RouterMACAddress is the MAC addresses of the interfaces of a router pod
+type RouterMACAddress struct {
+	// Ordinal is the ordinal of the pod in StatefulSet mode, 0 for the pod
+	// of a Deployment
+	Ordinal int32 `json:"ordinal"`
+	// Pool the addresses are allocated from, none if derived from the UID
+	// +optional
+	Pool     string `json:"pool,omitempty"`
+	Internal string `json:"internal"`
+	External string `json:"external"`
+}
+
+// ExternalIPApprovalDecision is the answer of the external IP approval webhook
+type ExternalIPApprovalDecision string
+
+const (
+	ExternalIPApproved ExternalIPApprovalDecision = "Approved"
+	ExternalIPDenied   ExternalIPApprovalDecision = "Denied"
+	ExternalIPPending  ExternalIPApprovalDecision = "Pending"
+)
+
+type ExternalIPApproval struct {
+	// ExternalIP is the IP of the spec the decision is about
+	ExternalIP string                     `json:"externalIP"`
+	Decision   ExternalIPApprovalDecision `json:"decision"`
+	Reason     string                     `json:"reason,omitempty"`
+	// ApprovedIP is the last approved IP, kept on the router while a new one
+	// awaits approval
+	ApprovedIP         string      `json:"approvedIP,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -67,3 +1209,216 @@ type NodeSelector struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=tn
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Flavor",type=string,JSONPath=`.spec.flavor`
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.status.subnet`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TenantNetwork onboards the network of a namespace: a VirtualRouter of a
+// flavor, an internal subnet, a default firewall policy and a quota
+type TenantNetwork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TenantNetworkSpec `json:"spec"`
+	// +optional
+	Status TenantNetworkStatus `json:"status"`
+}
+
+type TenantNetworkSpec struct {
+	// Flavor names a flavor of the flavor ConfigMap in the controller namespace
+	Flavor string `json:"flavor"`
+	// VlanNumber is the VLAN of the internal network of the tenant
+	// +optional
+	VlanNumber int32 `json:"vlanNumber,omitempty"`
+	// DefaultFirewallPolicy is Drop, the default, to let into the tenant
+	// subnet only the traffic tenant rules accept, or Accept to let all of it in
+	// +optional
+	DefaultFirewallPolicy TenantFirewallPolicy `json:"defaultFirewallPolicy,omitempty"`
+}
+
+// TenantFirewallPolicy is the default policy of traffic into a tenant subnet
+// +kubebuilder:validation:Enum=Accept;Drop
+type TenantFirewallPolicy string
+
+const (
+	TenantFirewallPolicyAccept TenantFirewallPolicy = "Accept"
+	TenantFirewallPolicyDrop   TenantFirewallPolicy = "Drop"
+)
+
+// TenantNetworkPhase is a label for the condition of a TenantNetwork at the current time
+type TenantNetworkPhase string
+
+const (
+	// TenantNetworkProvisioning means the resources of the tenant are being created
+	TenantNetworkProvisioning TenantNetworkPhase = "Provisioning"
+	// TenantNetworkReady means the VirtualRouter of the tenant is running
+	TenantNetworkReady TenantNetworkPhase = "Ready"
+	// TenantNetworkFailed means the tenant can't be provisioned as requested
+	TenantNetworkFailed TenantNetworkPhase = "Failed"
+)
+
+type TenantNetworkStatus struct {
+	Phase TenantNetworkPhase `json:"phase,omitempty"`
+	// Message tells why the tenant can't be provisioned
+	Message string `json:"message,omitempty"`
+	// Subnet is the internal subnet allocated to the tenant
+	Subnet string `json:"subnet,omitempty"`
+	// SubnetReference identifies the subnet in the IPAM it was allocated from
+	SubnetReference string `json:"subnetReference,omitempty"`
+	// GatewayIP is the address of the VirtualRouter in the subnet
+	GatewayIP string `json:"gatewayIP,omitempty"`
+	// VirtualRouter is the name of the VirtualRouter of the tenant
+	VirtualRouter      string `json:"virtualRouter,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TenantNetworkList is a list of TenantNetwork resources
+type TenantNetworkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TenantNetwork `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:shortName=vrquota
+// +kubebuilder:printcolumn:name="Routers",type=integer,JSONPath=`.spec.maxVirtualRouters`
+// +kubebuilder:printcolumn:name="External-IPs",type=integer,JSONPath=`.spec.maxExternalIPs`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VirtualRouterQuota limits the VirtualRouters of its namespace and their
+// rules, enforced by the validating webhook. Every quota of a namespace
+// applies, a limit left out is not enforced.
+type VirtualRouterQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VirtualRouterQuotaSpec `json:"spec"`
+}
+
+type VirtualRouterQuotaSpec struct {
+	// MaxVirtualRouters is the most VirtualRouters of the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxVirtualRouters *int32 `json:"maxVirtualRouters,omitempty"`
+	// MaxExternalIPs is the most external addresses the VirtualRouters of the
+	// namespace ask for, counting the external IP, the IPv6 address and the
+	// addresses of the SNAT pool
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxExternalIPs *int32 `json:"maxExternalIPs,omitempty"`
+	// MaxNATRules is the most NAT rules of each VirtualRouter of the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxNATRules *int32 `json:"maxNATRules,omitempty"`
+	// MaxFireWallRules is the most firewall rules of each VirtualRouter of the
+	// namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxFireWallRules *int32 `json:"maxFireWallRules,omitempty"`
+	// MaxLoadBalancerRules is the most load balancer rules of each
+	// VirtualRouter of the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxLoadBalancerRules *int32 `json:"maxLoadBalancerRules,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtualRouterQuotaList is a list of VirtualRouterQuota resources
+type VirtualRouterQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VirtualRouterQuota `json:"items"`
+}
+
+// VirtualRouterProfileReference names a VirtualRouterProfile
+type VirtualRouterProfileReference struct {
+	Name string `json:"name"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:scope=Cluster,shortName=vrprofile
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+// +kubebuilder:printcolumn:name="Security-Profile",type=string,JSONPath=`.spec.securityProfile`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VirtualRouterProfile holds the defaults of the VirtualRouters referencing
+// it by spec.profileRef, so that they are changed for all of them at once.
+// A VirtualRouter setting a field itself keeps its own.
+type VirtualRouterProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VirtualRouterProfileSpec `json:"spec"`
+}
+
+type VirtualRouterProfileSpec struct {
+	// Image of the routers
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Resources of the router containers
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// SecurityProfile is how much router containers are trusted with
+	// +optional
+	SecurityProfile VirtualRouterSecurityProfile `json:"securityProfile,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtualRouterProfileList is a list of VirtualRouterProfile resources
+type VirtualRouterProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VirtualRouterProfile `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:scope=Cluster,shortName=netfreeze
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.spec.reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NetworkFreeze stops the controller from changing the data plane of any
+// router while it is present, as an emergency brake during incidents. The
+// status of routers is still reported, and router objects found missing are
+// still created.
+type NetworkFreeze struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NetworkFreezeSpec `json:"spec,omitempty"`
+}
+
+type NetworkFreezeSpec struct {
+	// Reason of the freeze, such as the incident it is for, reported with
+	// the changes it holds back
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NetworkFreezeList is a list of NetworkFreeze resources
+type NetworkFreezeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []NetworkFreeze `json:"items"`
+}
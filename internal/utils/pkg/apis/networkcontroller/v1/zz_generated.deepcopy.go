@@ -22,47 +22,1198 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	intstr "k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Autoscaling) DeepCopyInto(out *Autoscaling) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Autoscaling.
+func (in *Autoscaling) DeepCopy() *Autoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(Autoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCP) DeepCopyInto(out *DHCP) {
+	*out = *in
+	if in.LeaseTime != nil {
+		in, out := &in.LeaseTime, &out.LeaseTime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.DNSServers != nil {
+		in, out := &in.DNSServers, &out.DNSServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Reservations != nil {
+		in, out := &in.Reservations, &out.Reservations
+		*out = make([]DHCPReservation, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCP.
+func (in *DHCP) DeepCopy() *DHCP {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCP)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DHCPReservation) DeepCopyInto(out *DHCPReservation) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DHCPReservation.
+func (in *DHCPReservation) DeepCopy() *DHCPReservation {
+	if in == nil {
+		return nil
+	}
+	out := new(DHCPReservation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNS) DeepCopyInto(out *DNS) {
+	*out = *in
+	if in.Upstreams != nil {
+		in, out := &in.Upstreams, &out.Upstreams
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ConditionalForwarders != nil {
+		in, out := &in.ConditionalForwarders, &out.ConditionalForwarders
+		*out = make([]DNSForwarder, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Records != nil {
+		in, out := &in.Records, &out.Records
+		*out = make([]DNSRecord, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNS.
+func (in *DNS) DeepCopy() *DNS {
+	if in == nil {
+		return nil
+	}
+	out := new(DNS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSForwarder) DeepCopyInto(out *DNSForwarder) {
+	*out = *in
+	if in.Servers != nil {
+		in, out := &in.Servers, &out.Servers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSForwarder.
+func (in *DNSForwarder) DeepCopy() *DNSForwarder {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSForwarder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSRecord) DeepCopyInto(out *DNSRecord) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSRecord.
+func (in *DNSRecord) DeepCopy() *DNSRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalIPApproval) DeepCopyInto(out *ExternalIPApproval) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalIPApproval.
+func (in *ExternalIPApproval) DeepCopy() *ExternalIPApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalIPApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FirewallHardening) DeepCopyInto(out *FirewallHardening) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FirewallHardening.
+func (in *FirewallHardening) DeepCopy() *FirewallHardening {
+	if in == nil {
+		return nil
+	}
+	out := new(FirewallHardening)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FirewallLogging) DeepCopyInto(out *FirewallLogging) {
+	*out = *in
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]FirewallPolicy, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FirewallLogging.
+func (in *FirewallLogging) DeepCopy() *FirewallLogging {
+	if in == nil {
+		return nil
+	}
+	out := new(FirewallLogging)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlowExport) DeepCopyInto(out *FlowExport) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlowExport.
+func (in *FlowExport) DeepCopy() *FlowExport {
+	if in == nil {
+		return nil
+	}
+	out := new(FlowExport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlowOffload) DeepCopyInto(out *FlowOffload) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlowOffload.
+func (in *FlowOffload) DeepCopy() *FlowOffload {
+	if in == nil {
+		return nil
+	}
+	out := new(FlowOffload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPAMAllocation) DeepCopyInto(out *IPAMAllocation) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAMAllocation.
+func (in *IPAMAllocation) DeepCopy() *IPAMAllocation {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAMAllocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterNetworkPolicy) DeepCopyInto(out *InterNetworkPolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterNetworkPolicy.
+func (in *InterNetworkPolicy) DeepCopy() *InterNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(InterNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InternalNetwork) DeepCopyInto(out *InternalNetwork) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InternalNetwork.
+func (in *InternalNetwork) DeepCopy() *InternalNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(InternalNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InvalidRuleExpiry) DeepCopyInto(out *InvalidRuleExpiry) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InvalidRuleExpiry.
+func (in *InvalidRuleExpiry) DeepCopy() *InvalidRuleExpiry {
+	if in == nil {
+		return nil
+	}
+	out := new(InvalidRuleExpiry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MACPool) DeepCopyInto(out *MACPool) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MACPool.
+func (in *MACPool) DeepCopy() *MACPool {
+	if in == nil {
+		return nil
+	}
+	out := new(MACPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MTU) DeepCopyInto(out *MTU) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MTU.
+func (in *MTU) DeepCopy() *MTU {
+	if in == nil {
+		return nil
+	}
+	out := new(MTU)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkFreeze) DeepCopyInto(out *NetworkFreeze) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkFreeze.
+func (in *NetworkFreeze) DeepCopy() *NetworkFreeze {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkFreeze)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetworkFreeze) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkFreezeList) DeepCopyInto(out *NetworkFreezeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NetworkFreeze, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkFreezeList.
+func (in *NetworkFreezeList) DeepCopy() *NetworkFreezeList {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkFreezeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NetworkFreezeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkFreezeSpec) DeepCopyInto(out *NetworkFreezeSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkFreezeSpec.
+func (in *NetworkFreezeSpec) DeepCopy() *NetworkFreezeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkFreezeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSelector) DeepCopyInto(out *NodeSelector) {
 	*out = *in
 	return
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSelector.
-func (in *NodeSelector) DeepCopy() *NodeSelector {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSelector.
+func (in *NodeSelector) DeepCopy() *NodeSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyRoute) DeepCopyInto(out *PolicyRoute) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyRoute.
+func (in *PolicyRoute) DeepCopy() *PolicyRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyRule) DeepCopyInto(out *PolicyRule) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyRule.
+func (in *PolicyRule) DeepCopy() *PolicyRule {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortForward) DeepCopyInto(out *PortForward) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortForward.
+func (in *PortForward) DeepCopy() *PortForward {
+	if in == nil {
+		return nil
+	}
+	out := new(PortForward)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QoS) DeepCopyInto(out *QoS) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(QoSLimit)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Egress != nil {
+		in, out := &in.Egress, &out.Egress
+		*out = new(QoSLimit)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QoS.
+func (in *QoS) DeepCopy() *QoS {
+	if in == nil {
+		return nil
+	}
+	out := new(QoS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QoSClass) DeepCopyInto(out *QoSClass) {
+	*out = *in
+	out.Rate = in.Rate.DeepCopy()
+	if in.Ceil != nil {
+		in, out := &in.Ceil, &out.Ceil
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QoSClass.
+func (in *QoSClass) DeepCopy() *QoSClass {
+	if in == nil {
+		return nil
+	}
+	out := new(QoSClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QoSLimit) DeepCopyInto(out *QoSLimit) {
+	*out = *in
+	out.Rate = in.Rate.DeepCopy()
+	if in.Burst != nil {
+		in, out := &in.Burst, &out.Burst
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Classes != nil {
+		in, out := &in.Classes, &out.Classes
+		*out = make([]QoSClass, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QoSLimit.
+func (in *QoSLimit) DeepCopy() *QoSLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(QoSLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcileTiming) DeepCopyInto(out *ReconcileTiming) {
+	*out = *in
+	out.ExternalIP = in.ExternalIP
+	out.Namespace = in.Namespace
+	out.RBAC = in.RBAC
+	out.Rules = in.Rules
+	out.Deployment = in.Deployment
+	if in.Scheduling != nil {
+		in, out := &in.Scheduling, &out.Scheduling
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.DataPlane != nil {
+		in, out := &in.DataPlane, &out.DataPlane
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcileTiming.
+func (in *ReconcileTiming) DeepCopy() *ReconcileTiming {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcileTiming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouterMACAddress) DeepCopyInto(out *RouterMACAddress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterMACAddress.
+func (in *RouterMACAddress) DeepCopy() *RouterMACAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(RouterMACAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouterMACAddresses) DeepCopyInto(out *RouterMACAddresses) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterMACAddresses.
+func (in *RouterMACAddresses) DeepCopy() *RouterMACAddresses {
+	if in == nil {
+		return nil
+	}
+	out := new(RouterMACAddresses)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouterPersistence) DeepCopyInto(out *RouterPersistence) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterPersistence.
+func (in *RouterPersistence) DeepCopy() *RouterPersistence {
+	if in == nil {
+		return nil
+	}
+	out := new(RouterPersistence)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouterSidecar) DeepCopyInto(out *RouterSidecar) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]corev1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]corev1.ContainerPort, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterSidecar.
+func (in *RouterSidecar) DeepCopy() *RouterSidecar {
+	if in == nil {
+		return nil
+	}
+	out := new(RouterSidecar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouterSysctl) DeepCopyInto(out *RouterSysctl) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterSysctl.
+func (in *RouterSysctl) DeepCopy() *RouterSysctl {
+	if in == nil {
+		return nil
+	}
+	out := new(RouterSysctl)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingTable) DeepCopyInto(out *RoutingTable) {
+	*out = *in
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]PolicyRoute, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PolicyRule, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingTable.
+func (in *RoutingTable) DeepCopy() *RoutingTable {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingTable)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleExpiration) DeepCopyInto(out *RuleExpiration) {
+	*out = *in
+	in.ExpiresAt.DeepCopyInto(&out.ExpiresAt)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleExpiration.
+func (in *RuleExpiration) DeepCopy() *RuleExpiration {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleExpiration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SLAProbe) DeepCopyInto(out *SLAProbe) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SLAProbe.
+func (in *SLAProbe) DeepCopy() *SLAProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(SLAProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SNATPool) DeepCopyInto(out *SNATPool) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SNATPool.
+func (in *SNATPool) DeepCopy() *SNATPool {
+	if in == nil {
+		return nil
+	}
+	out := new(SNATPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOV) DeepCopyInto(out *SRIOV) {
+	*out = *in
+	if in.SpoofCheck != nil {
+		in, out := &in.SpoofCheck, &out.SpoofCheck
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOV.
+func (in *SRIOV) DeepCopy() *SRIOV {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOV)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretEnvSource) DeepCopyInto(out *SecretEnvSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretEnvSource.
+func (in *SecretEnvSource) DeepCopy() *SecretEnvSource {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretEnvSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretMount) DeepCopyInto(out *SecretMount) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretMount.
+func (in *SecretMount) DeepCopy() *SecretMount {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretMount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StaticNeighbor) DeepCopyInto(out *StaticNeighbor) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StaticNeighbor.
+func (in *StaticNeighbor) DeepCopy() *StaticNeighbor {
+	if in == nil {
+		return nil
+	}
+	out := new(StaticNeighbor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantNetwork) DeepCopyInto(out *TenantNetwork) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantNetwork.
+func (in *TenantNetwork) DeepCopy() *TenantNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TenantNetwork) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantNetworkList) DeepCopyInto(out *TenantNetworkList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TenantNetwork, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantNetworkList.
+func (in *TenantNetworkList) DeepCopy() *TenantNetworkList {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantNetworkList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TenantNetworkList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantNetworkSpec) DeepCopyInto(out *TenantNetworkSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantNetworkSpec.
+func (in *TenantNetworkSpec) DeepCopy() *TenantNetworkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantNetworkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantNetworkStatus) DeepCopyInto(out *TenantNetworkStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantNetworkStatus.
+func (in *TenantNetworkStatus) DeepCopy() *TenantNetworkStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantNetworkStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Tunnel) DeepCopyInto(out *Tunnel) {
+	*out = *in
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Tunnel.
+func (in *Tunnel) DeepCopy() *Tunnel {
+	if in == nil {
+		return nil
+	}
+	out := new(Tunnel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouter) DeepCopyInto(out *VirtualRouter) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouter.
+func (in *VirtualRouter) DeepCopy() *VirtualRouter {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualRouter) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterList) DeepCopyInto(out *VirtualRouterList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtualRouter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterList.
+func (in *VirtualRouterList) DeepCopy() *VirtualRouterList {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualRouterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterOverrides) DeepCopyInto(out *VirtualRouterOverrides) {
+	*out = *in
+	if in.PodTemplate != nil {
+		in, out := &in.PodTemplate, &out.PodTemplate
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterOverrides.
+func (in *VirtualRouterOverrides) DeepCopy() *VirtualRouterOverrides {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterOverrides)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterPlacement) DeepCopyInto(out *VirtualRouterPlacement) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterPlacement.
+func (in *VirtualRouterPlacement) DeepCopy() *VirtualRouterPlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterPlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterProfile) DeepCopyInto(out *VirtualRouterProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterProfile.
+func (in *VirtualRouterProfile) DeepCopy() *VirtualRouterProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualRouterProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterProfileList) DeepCopyInto(out *VirtualRouterProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtualRouterProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterProfileList.
+func (in *VirtualRouterProfileList) DeepCopy() *VirtualRouterProfileList {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeSelector)
+	out := new(VirtualRouterProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualRouterProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterProfileReference) DeepCopyInto(out *VirtualRouterProfileReference) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterProfileReference.
+func (in *VirtualRouterProfileReference) DeepCopy() *VirtualRouterProfileReference {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterProfileReference)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *VirtualRouter) DeepCopyInto(out *VirtualRouter) {
+func (in *VirtualRouterProfileSpec) DeepCopyInto(out *VirtualRouterProfileSpec) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterProfileSpec.
+func (in *VirtualRouterProfileSpec) DeepCopy() *VirtualRouterProfileSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterProfileSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterQuota) DeepCopyInto(out *VirtualRouterQuota) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
 	return
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouter.
-func (in *VirtualRouter) DeepCopy() *VirtualRouter {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterQuota.
+func (in *VirtualRouterQuota) DeepCopy() *VirtualRouterQuota {
 	if in == nil {
 		return nil
 	}
-	out := new(VirtualRouter)
+	out := new(VirtualRouterQuota)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *VirtualRouter) DeepCopyObject() runtime.Object {
+func (in *VirtualRouterQuota) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -70,13 +1221,13 @@ func (in *VirtualRouter) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *VirtualRouterList) DeepCopyInto(out *VirtualRouterList) {
+func (in *VirtualRouterQuotaList) DeepCopyInto(out *VirtualRouterQuotaList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]VirtualRouter, len(*in))
+		*out = make([]VirtualRouterQuota, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -84,24 +1235,65 @@ func (in *VirtualRouterList) DeepCopyInto(out *VirtualRouterList) {
 	return
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterList.
-func (in *VirtualRouterList) DeepCopy() *VirtualRouterList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterQuotaList.
+func (in *VirtualRouterQuotaList) DeepCopy() *VirtualRouterQuotaList {
 	if in == nil {
 		return nil
 	}
-	out := new(VirtualRouterList)
+	out := new(VirtualRouterQuotaList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *VirtualRouterList) DeepCopyObject() runtime.Object {
+func (in *VirtualRouterQuotaList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterQuotaSpec) DeepCopyInto(out *VirtualRouterQuotaSpec) {
+	*out = *in
+	if in.MaxVirtualRouters != nil {
+		in, out := &in.MaxVirtualRouters, &out.MaxVirtualRouters
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxExternalIPs != nil {
+		in, out := &in.MaxExternalIPs, &out.MaxExternalIPs
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxNATRules != nil {
+		in, out := &in.MaxNATRules, &out.MaxNATRules
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxFireWallRules != nil {
+		in, out := &in.MaxFireWallRules, &out.MaxFireWallRules
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxLoadBalancerRules != nil {
+		in, out := &in.MaxLoadBalancerRules, &out.MaxLoadBalancerRules
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterQuotaSpec.
+func (in *VirtualRouterQuotaSpec) DeepCopy() *VirtualRouterQuotaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterQuotaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualRouterSpec) DeepCopyInto(out *VirtualRouterSpec) {
 	*out = *in
@@ -110,12 +1302,189 @@ func (in *VirtualRouterSpec) DeepCopyInto(out *VirtualRouterSpec) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.ProfileRef != nil {
+		in, out := &in.ProfileRef, &out.ProfileRef
+		*out = new(VirtualRouterProfileReference)
+		**out = **in
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.NodeSelector != nil {
 		in, out := &in.NodeSelector, &out.NodeSelector
 		*out = make([]NodeSelector, len(*in))
 		copy(*out, *in)
 	}
 	in.Affinity.DeepCopyInto(&out.Affinity)
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]SecretEnvSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]SecretMount, len(*in))
+		copy(*out, *in)
+	}
+	in.UpgradeStrategy.DeepCopyInto(&out.UpgradeStrategy)
+	if in.Sysctls != nil {
+		in, out := &in.Sysctls, &out.Sysctls
+		*out = make([]RouterSysctl, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeSysctls != nil {
+		in, out := &in.NodeSysctls, &out.NodeSysctls
+		*out = make([]RouterSysctl, len(*in))
+		copy(*out, *in)
+	}
+	out.Placement = in.Placement
+	if in.SLAProbe != nil {
+		in, out := &in.SLAProbe, &out.SLAProbe
+		*out = new(SLAProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PolicyRouting != nil {
+		in, out := &in.PolicyRouting, &out.PolicyRouting
+		*out = make([]RoutingTable, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DHCP != nil {
+		in, out := &in.DHCP, &out.DHCP
+		*out = new(DHCP)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(DNS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PortForwards != nil {
+		in, out := &in.PortForwards, &out.PortForwards
+		*out = make([]PortForward, len(*in))
+		copy(*out, *in)
+	}
+	if in.SNATPool != nil {
+		in, out := &in.SNATPool, &out.SNATPool
+		*out = new(SNATPool)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.QoS != nil {
+		in, out := &in.QoS, &out.QoS
+		*out = new(QoS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FirewallHardening != nil {
+		in, out := &in.FirewallHardening, &out.FirewallHardening
+		*out = new(FirewallHardening)
+		**out = **in
+	}
+	if in.Tunnels != nil {
+		in, out := &in.Tunnels, &out.Tunnels
+		*out = make([]Tunnel, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.WireGuard != nil {
+		in, out := &in.WireGuard, &out.WireGuard
+		*out = new(WireGuard)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StaticNeighbors != nil {
+		in, out := &in.StaticNeighbors, &out.StaticNeighbors
+		*out = make([]StaticNeighbor, len(*in))
+		copy(*out, *in)
+	}
+	if in.MTU != nil {
+		in, out := &in.MTU, &out.MTU
+		*out = new(MTU)
+		**out = **in
+	}
+	if in.ExternalSRIOV != nil {
+		in, out := &in.ExternalSRIOV, &out.ExternalSRIOV
+		*out = new(SRIOV)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(Autoscaling)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FlowExport != nil {
+		in, out := &in.FlowExport, &out.FlowExport
+		*out = new(FlowExport)
+		**out = **in
+	}
+	if in.Logging != nil {
+		in, out := &in.Logging, &out.Logging
+		*out = new(FirewallLogging)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FlowOffload != nil {
+		in, out := &in.FlowOffload, &out.FlowOffload
+		*out = new(FlowOffload)
+		**out = **in
+	}
+	if in.Overrides != nil {
+		in, out := &in.Overrides, &out.Overrides
+		*out = new(VirtualRouterOverrides)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sidecars != nil {
+		in, out := &in.Sidecars, &out.Sidecars
+		*out = make([]RouterSidecar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Persistence != nil {
+		in, out := &in.Persistence, &out.Persistence
+		*out = new(RouterPersistence)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MACAddresses != nil {
+		in, out := &in.MACAddresses, &out.MACAddresses
+		*out = new(RouterMACAddresses)
+		**out = **in
+	}
+	if in.MaintenanceWindow != nil {
+		in, out := &in.MaintenanceWindow, &out.MaintenanceWindow
+		*out = new(MaintenanceWindow)
+		**out = **in
+	}
+	if in.InternalNetworks != nil {
+		in, out := &in.InternalNetworks, &out.InternalNetworks
+		*out = make([]InternalNetwork, len(*in))
+		copy(*out, *in)
+	}
+	if in.InterNetworkPolicies != nil {
+		in, out := &in.InterNetworkPolicies, &out.InterNetworkPolicies
+		*out = make([]InterNetworkPolicy, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -132,6 +1501,64 @@ func (in *VirtualRouterSpec) DeepCopy() *VirtualRouterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualRouterStatus) DeepCopyInto(out *VirtualRouterStatus) {
 	*out = *in
+	if in.ExternalIPs != nil {
+		in, out := &in.ExternalIPs, &out.ExternalIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastReconcileTime != nil {
+		in, out := &in.LastReconcileTime, &out.LastReconcileTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ExternalIPApproval != nil {
+		in, out := &in.ExternalIPApproval, &out.ExternalIPApproval
+		*out = new(ExternalIPApproval)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPAMAllocation != nil {
+		in, out := &in.IPAMAllocation, &out.IPAMAllocation
+		*out = new(IPAMAllocation)
+		**out = **in
+	}
+	if in.SNATPoolAllocations != nil {
+		in, out := &in.SNATPoolAllocations, &out.SNATPoolAllocations
+		*out = make([]IPAMAllocation, len(*in))
+		copy(*out, *in)
+	}
+	if in.MACAddresses != nil {
+		in, out := &in.MACAddresses, &out.MACAddresses
+		*out = make([]RouterMACAddress, len(*in))
+		copy(*out, *in)
+	}
+	if in.RuleExpirations != nil {
+		in, out := &in.RuleExpirations, &out.RuleExpirations
+		*out = make([]RuleExpiration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InvalidRuleExpiries != nil {
+		in, out := &in.InvalidRuleExpiries, &out.InvalidRuleExpiries
+		*out = make([]InvalidRuleExpiry, len(*in))
+		copy(*out, *in)
+	}
+	if in.WireGuard != nil {
+		in, out := &in.WireGuard, &out.WireGuard
+		*out = new(WireGuardStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ReconcileTiming != nil {
+		in, out := &in.ReconcileTiming, &out.ReconcileTiming
+		*out = new(ReconcileTiming)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -144,3 +1571,111 @@ func (in *VirtualRouterStatus) DeepCopy() *VirtualRouterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualRouterUpgradeStrategy) DeepCopyInto(out *VirtualRouterUpgradeStrategy) {
+	*out = *in
+	if in.MaxSurge != nil {
+		in, out := &in.MaxSurge, &out.MaxSurge
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualRouterUpgradeStrategy.
+func (in *VirtualRouterUpgradeStrategy) DeepCopy() *VirtualRouterUpgradeStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualRouterUpgradeStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireGuard) DeepCopyInto(out *WireGuard) {
+	*out = *in
+	if in.Peers != nil {
+		in, out := &in.Peers, &out.Peers
+		*out = make([]WireGuardPeer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireGuard.
+func (in *WireGuard) DeepCopy() *WireGuard {
+	if in == nil {
+		return nil
+	}
+	out := new(WireGuard)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireGuardPeer) DeepCopyInto(out *WireGuardPeer) {
+	*out = *in
+	if in.AllowedIPs != nil {
+		in, out := &in.AllowedIPs, &out.AllowedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireGuardPeer.
+func (in *WireGuardPeer) DeepCopy() *WireGuardPeer {
+	if in == nil {
+		return nil
+	}
+	out := new(WireGuardPeer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireGuardPeerStatus) DeepCopyInto(out *WireGuardPeerStatus) {
+	*out = *in
+	if in.LatestHandshakeTime != nil {
+		in, out := &in.LatestHandshakeTime, &out.LatestHandshakeTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireGuardPeerStatus.
+func (in *WireGuardPeerStatus) DeepCopy() *WireGuardPeerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireGuardPeerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireGuardStatus) DeepCopyInto(out *WireGuardStatus) {
+	*out = *in
+	if in.Peers != nil {
+		in, out := &in.Peers, &out.Peers
+		*out = make([]WireGuardPeerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireGuardStatus.
+func (in *WireGuardStatus) DeepCopy() *WireGuardStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireGuardStatus)
+	in.DeepCopyInto(out)
+	return out
+}
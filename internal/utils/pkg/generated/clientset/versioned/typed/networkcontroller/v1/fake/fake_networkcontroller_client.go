@@ -28,10 +28,26 @@ type FakeTmaxV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeTmaxV1) NetworkFreezes() v1.NetworkFreezeInterface {
+	return &FakeNetworkFreezes{c}
+}
+
+func (c *FakeTmaxV1) TenantNetworks(namespace string) v1.TenantNetworkInterface {
+	return &FakeTenantNetworks{c, namespace}
+}
+
 func (c *FakeTmaxV1) VirtualRouters(namespace string) v1.VirtualRouterInterface {
 	return &FakeVirtualRouters{c, namespace}
 }
 
+func (c *FakeTmaxV1) VirtualRouterProfiles() v1.VirtualRouterProfileInterface {
+	return &FakeVirtualRouterProfiles{c}
+}
+
+func (c *FakeTmaxV1) VirtualRouterQuotas(namespace string) v1.VirtualRouterQuotaInterface {
+	return &FakeVirtualRouterQuotas{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeTmaxV1) RESTClient() rest.Interface {
@@ -18,4 +18,12 @@ limitations under the License.
 
 package v1
 
+type NetworkFreezeExpansion interface{}
+
+type TenantNetworkExpansion interface{}
+
 type VirtualRouterExpansion interface{}
+
+type VirtualRouterProfileExpansion interface{}
+
+type VirtualRouterQuotaExpansion interface{}
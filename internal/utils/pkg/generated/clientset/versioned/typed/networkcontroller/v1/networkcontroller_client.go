@@ -26,7 +26,11 @@ import (
 
 type TmaxV1Interface interface {
 	RESTClient() rest.Interface
+	NetworkFreezesGetter
+	TenantNetworksGetter
 	VirtualRoutersGetter
+	VirtualRouterProfilesGetter
+	VirtualRouterQuotasGetter
 }
 
 // TmaxV1Client is used to interact with features provided by the tmax.hypercloud.com group.
@@ -34,10 +38,26 @@ type TmaxV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *TmaxV1Client) NetworkFreezes() NetworkFreezeInterface {
+	return newNetworkFreezes(c)
+}
+
+func (c *TmaxV1Client) TenantNetworks(namespace string) TenantNetworkInterface {
+	return newTenantNetworks(c, namespace)
+}
+
 func (c *TmaxV1Client) VirtualRouters(namespace string) VirtualRouterInterface {
 	return newVirtualRouters(c, namespace)
 }
 
+func (c *TmaxV1Client) VirtualRouterProfiles() VirtualRouterProfileInterface {
+	return newVirtualRouterProfiles(c)
+}
+
+func (c *TmaxV1Client) VirtualRouterQuotas(namespace string) VirtualRouterQuotaInterface {
+	return newVirtualRouterQuotas(c, namespace)
+}
+
 // NewForConfig creates a new TmaxV1Client for the given config.
 func NewForConfig(c *rest.Config) (*TmaxV1Client, error) {
 	config := *c
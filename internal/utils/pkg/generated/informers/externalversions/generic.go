@@ -53,8 +53,16 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=tmax.hypercloud.com, Version=v1
+	case v1.SchemeGroupVersion.WithResource("networkfreezes"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Tmax().V1().NetworkFreezes().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("tenantnetworks"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Tmax().V1().TenantNetworks().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("virtualrouters"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Tmax().V1().VirtualRouters().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtualrouterprofiles"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Tmax().V1().VirtualRouterProfiles().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtualrouterquotas"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Tmax().V1().VirtualRouterQuotas().Informer()}, nil
 
 	}
 
@@ -24,8 +24,16 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// NetworkFreezes returns a NetworkFreezeInformer.
+	NetworkFreezes() NetworkFreezeInformer
+	// TenantNetworks returns a TenantNetworkInformer.
+	TenantNetworks() TenantNetworkInformer
 	// VirtualRouters returns a VirtualRouterInformer.
 	VirtualRouters() VirtualRouterInformer
+	// VirtualRouterProfiles returns a VirtualRouterProfileInformer.
+	VirtualRouterProfiles() VirtualRouterProfileInformer
+	// VirtualRouterQuotas returns a VirtualRouterQuotaInformer.
+	VirtualRouterQuotas() VirtualRouterQuotaInformer
 }
 
 type version struct {
@@ -39,7 +47,27 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// NetworkFreezes returns a NetworkFreezeInformer.
+func (v *version) NetworkFreezes() NetworkFreezeInformer {
+	return &networkFreezeInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// TenantNetworks returns a TenantNetworkInformer.
+func (v *version) TenantNetworks() TenantNetworkInformer {
+	return &tenantNetworkInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // VirtualRouters returns a VirtualRouterInformer.
 func (v *version) VirtualRouters() VirtualRouterInformer {
 	return &virtualRouterInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// VirtualRouterProfiles returns a VirtualRouterProfileInformer.
+func (v *version) VirtualRouterProfiles() VirtualRouterProfileInformer {
+	return &virtualRouterProfileInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
+// VirtualRouterQuotas returns a VirtualRouterQuotaInformer.
+func (v *version) VirtualRouterQuotas() VirtualRouterQuotaInformer {
+	return &virtualRouterQuotaInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
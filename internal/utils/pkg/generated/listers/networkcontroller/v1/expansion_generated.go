@@ -18,6 +18,18 @@ limitations under the License.
 
 package v1
 
+// NetworkFreezeListerExpansion allows custom methods to be added to
+// NetworkFreezeLister.
+type NetworkFreezeListerExpansion interface{}
+
+// TenantNetworkListerExpansion allows custom methods to be added to
+// TenantNetworkLister.
+type TenantNetworkListerExpansion interface{}
+
+// TenantNetworkNamespaceListerExpansion allows custom methods to be added to
+// TenantNetworkNamespaceLister.
+type TenantNetworkNamespaceListerExpansion interface{}
+
 // VirtualRouterListerExpansion allows custom methods to be added to
 // VirtualRouterLister.
 type VirtualRouterListerExpansion interface{}
@@ -25,3 +37,15 @@ type VirtualRouterListerExpansion interface{}
 // VirtualRouterNamespaceListerExpansion allows custom methods to be added to
 // VirtualRouterNamespaceLister.
 type VirtualRouterNamespaceListerExpansion interface{}
+
+// VirtualRouterProfileListerExpansion allows custom methods to be added to
+// VirtualRouterProfileLister.
+type VirtualRouterProfileListerExpansion interface{}
+
+// VirtualRouterQuotaListerExpansion allows custom methods to be added to
+// VirtualRouterQuotaLister.
+type VirtualRouterQuotaListerExpansion interface{}
+
+// VirtualRouterQuotaNamespaceListerExpansion allows custom methods to be added to
+// VirtualRouterQuotaNamespaceLister.
+type VirtualRouterQuotaNamespaceListerExpansion interface{}
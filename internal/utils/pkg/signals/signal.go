@@ -41,3 +41,25 @@ func SetupSignalHandler() (stopCh <-chan struct{}) {
 
 	return stop
 }
+
+// SetupReloadHandler returns a channel receiving on every SIGHUP, asking the
+// program to reload its configuration. Nothing is received on platforms
+// without SIGHUP.
+func SetupReloadHandler() <-chan struct{} {
+	reload := make(chan struct{}, 1)
+	if len(reloadSignals) == 0 {
+		return reload
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, reloadSignals...)
+	go func() {
+		for range c {
+			select {
+			case reload <- struct{}{}:
+			default:
+				// a reload is pending already
+			}
+		}
+	}()
+	return reload
+}
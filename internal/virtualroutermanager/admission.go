@@ -0,0 +1,517 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// RULE_VALIDATION_PATH is where the validating webhook of the NAT, firewall
+// and load balancer rules is served.
+const RULE_VALIDATION_PATH string = "/validate-rules"
+
+// LB_MAX_WEIGHT is the most weight of a load balancer backend, router pods
+// send it weight percent of the connections.
+const LB_MAX_WEIGHT int = 100
+
+// ruleProtocols are the protocols a rule may match. Router pods render the
+// protocol verbatim after -p, so a typo only shows as a failed iptables-restore.
+var ruleProtocols = map[string]bool{
+	"all":       true,
+	"tcp":       true,
+	"udp":       true,
+	"sctp":      true,
+	"icmp":      true,
+	"icmpv6":    true,
+	"ipv6-icmp": true,
+}
+
+// portRange is a port or a range of ports of a rule, as iptables takes it
+// after --dport and --sport.
+type portRange struct {
+	first, last int
+}
+
+func (r portRange) overlaps(other portRange) bool {
+	return r.first <= other.last && other.first <= r.last
+}
+
+func (r portRange) String() string {
+	if r.first == r.last {
+		return strconv.Itoa(r.first)
+	}
+	return fmt.Sprintf("%d:%d", r.first, r.last)
+}
+
+func parsePortRange(value string) (portRange, error) {
+	parts := strings.SplitN(value, ":", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil || first < 1 || first > 65535 {
+		return portRange{}, fmt.Errorf("invalid port %q", value)
+	}
+	r := portRange{first: first, last: first}
+	if len(parts) == 2 {
+		if r.last, err = strconv.Atoi(parts[1]); err != nil || r.last < first || r.last > 65535 {
+			return portRange{}, fmt.Errorf("invalid port range %q", value)
+		}
+	}
+	return r, nil
+}
+
+// ruleProtocol is the protocol match of a rule: a protocol optionally
+// followed by the --dport and --sport options, the way the controller
+// compiles port forwards (tcp --dport 80).
+type ruleProtocol struct {
+	name         string
+	dport, sport *portRange
+}
+
+func parseRuleProtocol(protocol string) (ruleProtocol, error) {
+	fields := strings.Fields(protocol)
+	if len(fields) == 0 {
+		return ruleProtocol{}, nil
+	}
+	p := ruleProtocol{name: strings.ToLower(fields[0])}
+	if !ruleProtocols[p.name] {
+		return p, fmt.Errorf("unknown protocol %q", fields[0])
+	}
+	for i := 1; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			return p, fmt.Errorf("protocol %q: %s has no value", protocol, fields[i])
+		}
+		var port **portRange
+		switch fields[i] {
+		case "--dport", "--destination-port":
+			port = &p.dport
+		case "--sport", "--source-port":
+			port = &p.sport
+		default:
+			return p, fmt.Errorf("protocol %q: unsupported option %s, only --dport and --sport are", protocol, fields[i])
+		}
+		r, err := parsePortRange(fields[i+1])
+		if err != nil {
+			return p, fmt.Errorf("protocol %q: %v", protocol, err)
+		}
+		*port = &r
+	}
+	if (p.dport != nil || p.sport != nil) && p.name != "tcp" && p.name != "udp" && p.name != "sctp" {
+		return p, fmt.Errorf("protocol %q: ports are only matched for tcp, udp and sctp", protocol)
+	}
+	return p, nil
+}
+
+// validateRuleNetwork validates an address a rule matches, an IP or a CIDR.
+func validateRuleNetwork(field, address string) error {
+	if address == "" {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return nil
+	}
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("%s: invalid address %q", field, address)
+	}
+	return nil
+}
+
+// validateRuleTarget validates an address a rule translates to, an IP
+// optionally with a port (10.0.0.10:8080, [fd00::10]:8080).
+func validateRuleTarget(field, address string) error {
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("%s: invalid address %q", field, address)
+	}
+	if _, err := parsePortRange(port); err != nil {
+		return fmt.Errorf("%s: %v", field, err)
+	}
+	return nil
+}
+
+func validateRuleMatch(i int, match nfvv1.Match) (ruleProtocol, error) {
+	if err := validateRuleNetwork(fmt.Sprintf("rules[%d].match.srcIP", i), match.SrcIP); err != nil {
+		return ruleProtocol{}, err
+	}
+	if err := validateRuleNetwork(fmt.Sprintf("rules[%d].match.dstIP", i), match.DstIP); err != nil {
+		return ruleProtocol{}, err
+	}
+	protocol, err := parseRuleProtocol(match.Protocol)
+	if err != nil {
+		return protocol, fmt.Errorf("rules[%d].match.protocol: %v", i, err)
+	}
+	return protocol, nil
+}
+
+func validateNATRule(rule *nfvv1.NATRule) error {
+	for i, r := range rule.Spec.Rules {
+		if _, err := validateRuleMatch(i, r.Match); err != nil {
+			return err
+		}
+		if r.Action.Policy != "" {
+			return fmt.Errorf("rules[%d].action.policy: NAT rules translate addresses, they have no policy", i)
+		}
+		if r.Action.SrcIP == "" && r.Action.DstIP == "" {
+			return fmt.Errorf("rules[%d].action: srcIP (SNAT) or dstIP (DNAT) is required", i)
+		}
+		if r.Action.SrcIP != "" {
+			if err := validateRuleTarget(fmt.Sprintf("rules[%d].action.srcIP", i), r.Action.SrcIP); err != nil {
+				return err
+			}
+		}
+		if r.Action.DstIP != "" {
+			if err := validateRuleTarget(fmt.Sprintf("rules[%d].action.dstIP", i), r.Action.DstIP); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateFireWallRule(rule *nfvv1.FireWallRule) error {
+	for i, r := range rule.Spec.Rules {
+		if _, err := validateRuleMatch(i, r.Match); err != nil {
+			return err
+		}
+		switch r.Action.Policy {
+		case "ACCEPT", "DROP", "REJECT":
+		default:
+			return fmt.Errorf("rules[%d].action.policy: %q is none of ACCEPT, DROP and REJECT", i, r.Action.Policy)
+		}
+		if r.Action.SrcIP != "" || r.Action.DstIP != "" {
+			return fmt.Errorf("rules[%d].action: firewall rules don't translate addresses", i)
+		}
+	}
+	return nil
+}
+
+func validateLoadBalancerRule(rule *nfvv1.LoadBalancerRule) error {
+	for i, r := range rule.Spec.Rules {
+		if net.ParseIP(r.LoadBalancerIP) == nil {
+			return fmt.Errorf("rules[%d].loadBalancerIP: invalid address %q", i, r.LoadBalancerIP)
+		}
+		total := 0
+		for j, backend := range r.BackendIPs {
+			if err := validateRuleTarget(fmt.Sprintf("rules[%d].backendIPs[%d].backendIP", i, j), backend.BackendIP); err != nil {
+				return err
+			}
+			if backend.Weight < 0 || backend.Weight > LB_MAX_WEIGHT {
+				return fmt.Errorf("rules[%d].backendIPs[%d].weight: %d is not between 0 and %d", i, j, backend.Weight, LB_MAX_WEIGHT)
+			}
+			total += backend.Weight
+		}
+		if total > LB_MAX_WEIGHT {
+			return fmt.Errorf("rules[%d].backendIPs: the weights add up to %d, more than %d", i, total, LB_MAX_WEIGHT)
+		}
+	}
+	return nil
+}
+
+// dnatPort is the external address and port a DNAT rule takes.
+type dnatPort struct {
+	// dstIP is the network matched, empty for any
+	dstIP    string
+	protocol string
+	ports    portRange
+}
+
+func (p dnatPort) conflicts(other dnatPort) bool {
+	if p.protocol != other.protocol || !p.ports.overlaps(other.ports) {
+		return false
+	}
+	return p.dstIP == "" || other.dstIP == "" || p.dstIP == other.dstIP
+}
+
+func (p dnatPort) String() string {
+	dstIP := p.dstIP
+	if dstIP == "" {
+		dstIP = "any address"
+	}
+	return fmt.Sprintf("%s/%s of %s", p.protocol, p.ports, dstIP)
+}
+
+// dnatPorts returns the external ports the DNAT rules of the NATRule take.
+// Rules matching no destination port take none.
+func dnatPorts(rule *nfvv1.NATRule) []dnatPort {
+	var ports []dnatPort
+	for _, r := range rule.Spec.Rules {
+		if r.Action.DstIP == "" {
+			continue
+		}
+		protocol, err := parseRuleProtocol(r.Match.Protocol)
+		if err != nil || protocol.dport == nil {
+			continue
+		}
+		ports = append(ports, dnatPort{dstIP: normalizeRuleAddress(r.Match.DstIP), protocol: protocol.name, ports: *protocol.dport})
+	}
+	return ports
+}
+
+// RuleValidator is the validating admission webhook of the NAT, firewall and
+// load balancer rules. It rejects the rules router pods would fail to apply,
+// the rules of namespaces of no VirtualRouter, and NAT and load balancer
+// rules taking an external port or address another rule of the router
+// namespace already takes, or more rules than the VirtualRouterQuotas of the
+// router allow.
+type RuleValidator struct {
+	dynamicclient        dynamic.Interface
+	virtualRoutersLister listers.VirtualRouterLister
+	virtualRoutersSynced cache.InformerSynced
+	quotasLister         listers.VirtualRouterQuotaLister
+	quotasSynced         cache.InformerSynced
+}
+
+// NewRuleValidator returns the rule validator looking VirtualRouters and
+// their quotas up in the informers, which are to be started.
+func NewRuleValidator(dynamicclient dynamic.Interface, virtualRouterInformer informers.VirtualRouterInformer, quotaInformer informers.VirtualRouterQuotaInformer) *RuleValidator {
+	return &RuleValidator{
+		dynamicclient:        dynamicclient,
+		virtualRoutersLister: virtualRouterInformer.Lister(),
+		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
+		quotasLister:         quotaInformer.Lister(),
+		quotasSynced:         quotaInformer.Informer().HasSynced,
+	}
+}
+
+// ServeHTTP answers an AdmissionReview of a rule. The API server is answered
+// with an error until the VirtualRouters and their quotas are synced, which
+// fails the request or admits it as the failurePolicy of the webhook says.
+func (v *RuleValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !v.virtualRoutersSynced() || !v.quotasSynced() {
+		http.Error(w, "VirtualRouters are not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	serveAdmissionReview(w, r, v.Validate)
+}
+
+// serveAdmissionReview answers the AdmissionReview of the request, rejecting
+// the object if validate fails.
+func serveAdmissionReview(w http.ResponseWriter, r *http.Request, validate func(*admissionv1.AdmissionRequest) error) {
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := validate(review.Request); err != nil {
+		klog.Infof("Rejecting %s %s/%s: %v", review.Request.Kind.Kind, review.Request.Namespace, review.Request.Name, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: err.Error(),
+		}
+	}
+	review.Response = response
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		klog.Errorf("Error writing AdmissionReview: %v", err)
+	}
+}
+
+// Validate returns why the rule of the request is rejected, nil if it is
+// admitted. Updates leaving the spec as it is are admitted, so rules made
+// before the webhook can still be relabelled, expired and deleted.
+func (v *RuleValidator) Validate(request *admissionv1.AdmissionRequest) error {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return nil
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(request.Object.Raw); err != nil {
+		return err
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	if request.Operation == admissionv1.Update {
+		old := &unstructured.Unstructured{}
+		if err := old.UnmarshalJSON(request.OldObject.Raw); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(old.Object["spec"], obj.Object["spec"]) {
+			return nil
+		}
+		// the rules of a deactivated rule were admitted when it was made
+		if _, deactivated := old.GetAnnotations()[RULE_EXPIRED_RULES_ANNOTATION]; deactivated {
+			if _, ok := obj.GetAnnotations()[RULE_EXPIRED_RULES_ANNOTATION]; !ok {
+				return nil
+			}
+		}
+	}
+	namespace := request.Namespace
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+
+	switch request.Kind.Kind {
+	case "NATRule":
+		var rule nfvv1.NATRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rule); err != nil {
+			return err
+		}
+		if err := validateNATRule(&rule); err != nil {
+			return err
+		}
+		if err := v.validateRouter(namespace); err != nil {
+			return err
+		}
+		if err := v.validateDNATPorts(namespace, obj, &rule); err != nil {
+			return err
+		}
+		return v.validateRuleQuota(request, obj, natRuleResource, len(rule.Spec.Rules))
+	case "FireWallRule":
+		var rule nfvv1.FireWallRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rule); err != nil {
+			return err
+		}
+		if err := validateFireWallRule(&rule); err != nil {
+			return err
+		}
+		if err := v.validateRouter(namespace); err != nil {
+			return err
+		}
+		return v.validateRuleQuota(request, obj, firewallRuleResource, len(rule.Spec.Rules))
+	case "LoadBalancerRule":
+		var rule nfvv1.LoadBalancerRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rule); err != nil {
+			return err
+		}
+		if err := validateLoadBalancerRule(&rule); err != nil {
+			return err
+		}
+		if err := v.validateRouter(namespace); err != nil {
+			return err
+		}
+		if err := v.validateLoadBalancerIPs(namespace, obj, &rule); err != nil {
+			return err
+		}
+		return v.validateRuleQuota(request, obj, loadBalancerRuleResource, len(rule.Spec.Rules))
+	}
+	return fmt.Errorf("%s is not a rule", request.Kind.Kind)
+}
+
+// validateRouter fails unless the namespace is the router namespace of a
+// VirtualRouter, no router applying the rules of the others.
+func (v *RuleValidator) validateRouter(namespace string) error {
+	virtualRouters, err := v.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, virtualRouter := range virtualRouters {
+		if RouterNamespace(virtualRouter) == namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf("namespace %s is the router namespace of no VirtualRouter", namespace)
+}
+
+// otherRules lists the rules of the namespace but obj. Rules the same
+// VirtualRouter compiles, such as the port forwards and published Services,
+// are left out along with obj: the controller settles which of them takes a
+// port, and has to update them one after the other.
+func (v *RuleValidator) otherRules(obj *unstructured.Unstructured, resource *unstructured.UnstructuredList) []unstructured.Unstructured {
+	owner := metav1.GetControllerOf(obj)
+	var others []unstructured.Unstructured
+	for _, item := range resource.Items {
+		if item.GetName() == obj.GetName() {
+			continue
+		}
+		if owner != nil {
+			if itemOwner := metav1.GetControllerOf(&item); itemOwner != nil && itemOwner.UID == owner.UID {
+				continue
+			}
+		}
+		others = append(others, item)
+	}
+	return others
+}
+
+// validateDNATPorts fails if a DNAT rule takes an external port another DNAT
+// rule of the NATRule or the namespace takes, which only one of them would
+// ever get the connections of.
+func (v *RuleValidator) validateDNATPorts(namespace string, obj *unstructured.Unstructured, rule *nfvv1.NATRule) error {
+	ports := dnatPorts(rule)
+	if len(ports) == 0 {
+		return nil
+	}
+	for i := range ports {
+		for j := 0; j < i; j++ {
+			if ports[i].conflicts(ports[j]) {
+				return fmt.Errorf("DNAT of %s is given more than once", ports[i])
+			}
+		}
+	}
+
+	list, err := v.dynamicclient.Resource(natRuleResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, item := range v.otherRules(obj, list) {
+		var other nfvv1.NATRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &other); err != nil {
+			continue
+		}
+		for _, otherPort := range dnatPorts(&other) {
+			for _, port := range ports {
+				if port.conflicts(otherPort) {
+					return fmt.Errorf("DNAT of %s conflicts with NATRule %s taking %s", port, item.GetName(), otherPort)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateLoadBalancerIPs fails if a load balancer IP is balanced by another
+// rule of the LoadBalancerRule or the namespace.
+func (v *RuleValidator) validateLoadBalancerIPs(namespace string, obj *unstructured.Unstructured, rule *nfvv1.LoadBalancerRule) error {
+	ips := map[string]bool{}
+	for _, r := range rule.Spec.Rules {
+		ip := net.ParseIP(r.LoadBalancerIP).String()
+		if ips[ip] {
+			return fmt.Errorf("load balancer IP %s is given more than once", ip)
+		}
+		ips[ip] = true
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+
+	list, err := v.dynamicclient.Resource(loadBalancerRuleResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, item := range v.otherRules(obj, list) {
+		var other nfvv1.LoadBalancerRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &other); err != nil {
+			continue
+		}
+		for _, r := range other.Spec.Rules {
+			if ip := net.ParseIP(r.LoadBalancerIP); ip != nil && ips[ip.String()] {
+				return fmt.Errorf("load balancer IP %s is balanced by LoadBalancerRule %s", ip, item.GetName())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package virtualroutermanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// EXTERNAL_IP_APPROVAL_RETRY_INTERVAL is how often a pending external IP is
+// submitted again, as the decision is made outside of the cluster.
+const EXTERNAL_IP_APPROVAL_RETRY_INTERVAL time.Duration = 30 * time.Second
+
+const (
+	// ExternalIPApproved is used as part of the Event 'reason' when the
+	// external IP of a VirtualRouter is approved
+	ExternalIPApproved = "ExternalIPApproved"
+	// ErrExternalIPNotApproved is used as part of the Event 'reason' when the
+	// external IP of a VirtualRouter is pending or denied
+	ErrExternalIPNotApproved = "ExternalIPNotApproved"
+	// MessageExternalIPApproval is the message used for Events on the
+	// decision about an external IP
+	MessageExternalIPApproval = "External IP %s is %s: %s"
+)
+
+// ExternalIPApprovalRequest is what is submitted for approval before an
+// external IP is assigned to a router.
+type ExternalIPApprovalRequest struct {
+	Namespace       string    `json:"namespace"`
+	Name            string    `json:"name"`
+	UID             types.UID `json:"uid"`
+	ExternalIP      string    `json:"externalIP"`
+	ExternalNetmask string    `json:"externalNetmask,omitempty"`
+	GatewayIP       string    `json:"gatewayIP,omitempty"`
+}
+
+type ExternalIPApprovalResponse struct {
+	Decision samplev1alpha1.ExternalIPApprovalDecision `json:"decision"`
+	Reason   string                                    `json:"reason,omitempty"`
+}
+
+// ExternalIPApprover decides whether an external IP may be assigned, for
+// instance by asking the IPAM of record such as NetBox or Infoblox.
+type ExternalIPApprover interface {
+	Approve(request ExternalIPApprovalRequest) (ExternalIPApprovalResponse, error)
+}
+
+// WebhookExternalIPApprover POSTs the request as JSON to URL and expects an
+// ExternalIPApprovalResponse back.
+type WebhookExternalIPApprover struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookExternalIPApprover(url string, timeout time.Duration) *WebhookExternalIPApprover {
+	return &WebhookExternalIPApprover{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *WebhookExternalIPApprover) Approve(request ExternalIPApprovalRequest) (ExternalIPApprovalResponse, error) {
+	var response ExternalIPApprovalResponse
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return response, fmt.Errorf("external IP approval webhook returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return response, fmt.Errorf("decoding external IP approval response: %v", err)
+	}
+	switch response.Decision {
+	case samplev1alpha1.ExternalIPApproved, samplev1alpha1.ExternalIPDenied, samplev1alpha1.ExternalIPPending:
+		return response, nil
+	}
+	return response, fmt.Errorf("unknown external IP approval decision %q", response.Decision)
+}
+
+// externalIPApproval returns the decision about the external IP of the
+// router, or nil if external IPs need no approval. Approved IPs are not
+// submitted again; pending and denied ones are on every sync.
+func (c *Controller) externalIPApproval(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.ExternalIPApproval, error) {
+	if c.options.ExternalIPApprover == nil || virtualRouter.Spec.ExternalIP == "" {
+		return nil, nil
+	}
+
+	current := virtualRouter.Status.ExternalIPApproval
+	var approvedIP string
+	if current != nil {
+		approvedIP = current.ApprovedIP
+		if current.ExternalIP == virtualRouter.Spec.ExternalIP && current.Decision == samplev1alpha1.ExternalIPApproved {
+			return current, nil
+		}
+	} else if len(virtualRouter.Status.ExternalIPs) > 0 {
+		// the router was assigned its IP before approval was turned on
+		approvedIP = virtualRouter.Status.ExternalIPs[0]
+	}
+
+	if c.dryRunPlan != nil {
+		c.dryRunPlan.add(fmt.Sprintf("submit external IP %s for approval", virtualRouter.Spec.ExternalIP))
+		return current, nil
+	}
+
+	response, err := c.options.ExternalIPApprover.Approve(ExternalIPApprovalRequest{
+		Namespace:       virtualRouter.Namespace,
+		Name:            virtualRouter.Name,
+		UID:             virtualRouter.UID,
+		ExternalIP:      virtualRouter.Spec.ExternalIP,
+		ExternalNetmask: virtualRouter.Spec.ExternalNetmask,
+		GatewayIP:       virtualRouter.Spec.GatewayIP,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	approval := &samplev1alpha1.ExternalIPApproval{
+		ExternalIP:         virtualRouter.Spec.ExternalIP,
+		Decision:           response.Decision,
+		Reason:             response.Reason,
+		ApprovedIP:         approvedIP,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+	}
+	if response.Decision == samplev1alpha1.ExternalIPApproved {
+		approval.ApprovedIP = virtualRouter.Spec.ExternalIP
+	}
+	if current != nil && current.ExternalIP == approval.ExternalIP && current.Decision == approval.Decision {
+		approval.LastTransitionTime = current.LastTransitionTime
+		return approval, nil
+	}
+
+	klog.Infof("External IP %s of VirtualRouter %s/%s is %s", approval.ExternalIP, virtualRouter.Namespace, virtualRouter.Name, approval.Decision)
+	msg := fmt.Sprintf(MessageExternalIPApproval, approval.ExternalIP, approval.Decision, approval.Reason)
+	if approval.Decision == samplev1alpha1.ExternalIPApproved {
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, ExternalIPApproved, msg)
+	} else {
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrExternalIPNotApproved, msg)
+	}
+	return approval, nil
+}
+
+// isExternalIPApproved reports whether the router may run with the external
+// IP of its spec.
+func isExternalIPApproved(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	approval := virtualRouter.Status.ExternalIPApproval
+	return approval == nil || (approval.ExternalIP == virtualRouter.Spec.ExternalIP && approval.Decision == samplev1alpha1.ExternalIPApproved)
+}
@@ -0,0 +1,151 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	HORIZONTAL_POD_AUTOSCALER_NAME string = "virtualrouter-hpa"
+	// PACKETS_PER_SECOND_METRIC and SESSIONS_METRIC are the metrics of router
+	// pods the daemons export, served to autoscalers as pod metrics by the
+	// custom metrics adapter
+	PACKETS_PER_SECOND_METRIC string = "virtualrouter_packets_per_second"
+	SESSIONS_METRIC           string = "virtualrouter_sessions"
+)
+
+// validateAutoscaling takes replicas the router pods can scale between, on
+// at least one target. Routers handing out leases or keeping tunnel and VPN
+// sessions can't be scaled, as their pods don't share that state.
+func validateAutoscaling(spec samplev1alpha1.VirtualRouterSpec) error {
+	autoscaling := spec.Autoscaling
+	if autoscaling == nil {
+		return nil
+	}
+	if autoscaling.MaxReplicas < autoscalingMinReplicas(autoscaling) {
+		return fmt.Errorf("autoscaling: maxReplicas %d is less than minReplicas %d", autoscaling.MaxReplicas, autoscalingMinReplicas(autoscaling))
+	}
+	if autoscaling.TargetPacketsPerSecond <= 0 && autoscaling.TargetSessions <= 0 {
+		return fmt.Errorf("autoscaling: targetPacketsPerSecond or targetSessions is required")
+	}
+	switch {
+	case spec.DHCP != nil:
+		return fmt.Errorf("autoscaling: routers serving DHCP are not NAT-only")
+	case len(spec.Tunnels) > 0:
+		return fmt.Errorf("autoscaling: routers with tunnels are not NAT-only")
+	case spec.WireGuard != nil:
+		return fmt.Errorf("autoscaling: routers with WireGuard are not NAT-only")
+	}
+	return nil
+}
+
+func autoscalingMinReplicas(autoscaling *samplev1alpha1.Autoscaling) int32 {
+	if autoscaling.MinReplicas == nil {
+		return 1
+	}
+	return *autoscaling.MinReplicas
+}
+
+// newHorizontalPodAutoscaler returns the autoscaler of the router pods of the
+// Deployment, nil if the router isn't autoscaled. Targets are averages over
+// the router pods.
+func newHorizontalPodAutoscaler(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	autoscaling := virtualRouter.Spec.Autoscaling
+	if autoscaling == nil {
+		return nil
+	}
+	minReplicas := autoscalingMinReplicas(autoscaling)
+	var metrics []autoscalingv2beta2.MetricSpec
+	for _, target := range []struct {
+		metric string
+		value  int64
+	}{
+		{PACKETS_PER_SECOND_METRIC, autoscaling.TargetPacketsPerSecond},
+		{SESSIONS_METRIC, autoscaling.TargetSessions},
+	} {
+		if target.value <= 0 {
+			continue
+		}
+		metrics = append(metrics, autoscalingv2beta2.MetricSpec{
+			Type: autoscalingv2beta2.PodsMetricSourceType,
+			Pods: &autoscalingv2beta2.PodsMetricSource{
+				Metric: autoscalingv2beta2.MetricIdentifier{Name: target.metric},
+				Target: autoscalingv2beta2.MetricTarget{
+					Type:         autoscalingv2beta2.AverageValueMetricType,
+					AverageValue: resource.NewQuantity(target.value, resource.DecimalSI),
+				},
+			},
+		})
+	}
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, HORIZONTAL_POD_AUTOSCALER_NAME),
+			Namespace: deployment.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       deployment.Name,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+// ensureHorizontalPodAutoscaler creates, updates or deletes the autoscaler of
+// the router pods of the Deployment as spec.autoscaling says.
+func (c *Controller) ensureHorizontalPodAutoscaler(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	name := routerResourceName(virtualRouter, HORIZONTAL_POD_AUTOSCALER_NAME)
+	desired := newHorizontalPodAutoscaler(deployment, virtualRouter)
+	hpa, err := c.horizontalPodAutoscalersLister.HorizontalPodAutoscalers(deployment.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		if desired == nil {
+			return nil
+		}
+		_, err = c.kubeclientset.AutoscalingV2beta2().HorizontalPodAutoscalers(deployment.Namespace).Create(c.ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(hpa, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, hpa.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if desired == nil {
+		klog.Infof("Deleting HorizontalPodAutoscaler %s of VirtualRouter %s/%s", hpa.Name, virtualRouter.Namespace, virtualRouter.Name)
+		err := c.kubeclientset.AutoscalingV2beta2().HorizontalPodAutoscalers(deployment.Namespace).Delete(c.ctx, hpa.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !reflect.DeepEqual(hpa.Spec, desired.Spec) {
+		klog.Infof("Updating HorizontalPodAutoscaler %s of VirtualRouter %s/%s", hpa.Name, virtualRouter.Namespace, virtualRouter.Name)
+		hpaCopy := hpa.DeepCopy()
+		hpaCopy.Spec = desired.Spec
+		if _, err := c.kubeclientset.AutoscalingV2beta2().HorizontalPodAutoscalers(deployment.Namespace).Update(c.ctx, hpaCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// BACKEND_SERVICES_ANNOTATION has the backends of the rules of a
+	// LoadBalancerRule follow the ready endpoints of Services, as a list of
+	// <loadBalancerIP>=[<namespace>/]<service>. Services are those of the
+	// namespace of the LoadBalancerRule, and no other namespace may be given.
+	BACKEND_SERVICES_ANNOTATION string = "network.tmaxanc.com/backend-services"
+
+	// ErrInvalidBackendServices is used as part of the Event 'reason' when
+	// the backend services annotation of a LoadBalancerRule can't be parsed
+	ErrInvalidBackendServices = "ErrInvalidBackendServices"
+)
+
+var loadBalancerRuleResource = nfvv1.SchemeGroupVersion.WithResource("loadbalancerrules")
+
+// parseBackendServices returns the Service the backends of every load
+// balancer IP follow. Services of another namespace than that of the rule are
+// refused, as their endpoints belong to another tenant.
+func parseBackendServices(value string, namespace string) (map[string]types.NamespacedName, error) {
+	services := map[string]types.NamespacedName{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || net.ParseIP(parts[0]) == nil || parts[1] == "" {
+			return nil, fmt.Errorf("invalid backend service %q, expected <loadBalancerIP>=[<namespace>/]<service>", entry)
+		}
+		service := types.NamespacedName{Namespace: namespace, Name: parts[1]}
+		if i := strings.Index(parts[1], "/"); i >= 0 {
+			service = types.NamespacedName{Namespace: parts[1][:i], Name: parts[1][i+1:]}
+		}
+		if service.Namespace != namespace || service.Name == "" {
+			return nil, fmt.Errorf("invalid backend service %q, only Services of namespace %s can be followed", entry, namespace)
+		}
+		services[parts[0]] = service
+	}
+	return services, nil
+}
+
+// backendServiceIndex remembers which VirtualRouters have LoadBalancerRules
+// following a Service, for changes of its endpoints to reach them right away
+// rather than at their next resync.
+type backendServiceIndex struct {
+	mu      sync.Mutex
+	routers map[types.NamespacedName]map[string]bool
+}
+
+// set replaces the Services the VirtualRouter of the key follows.
+func (i *backendServiceIndex) set(key string, services []types.NamespacedName) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.routers == nil {
+		i.routers = map[types.NamespacedName]map[string]bool{}
+	}
+	for service, keys := range i.routers {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(i.routers, service)
+		}
+	}
+	for _, service := range services {
+		if i.routers[service] == nil {
+			i.routers[service] = map[string]bool{}
+		}
+		i.routers[service][key] = true
+	}
+}
+
+// get returns the keys of the VirtualRouters following the Service.
+func (i *backendServiceIndex) get(service types.NamespacedName) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var keys []string
+	for key := range i.routers[service] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// handleEndpointSlice enqueues the VirtualRouters whose LoadBalancerRules
+// follow the Service of the EndpointSlice.
+func (c *Controller) handleEndpointSlice(obj interface{}) {
+	slice, ok := obj.(*discoveryv1beta1.EndpointSlice)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if slice, ok = tombstone.Obj.(*discoveryv1beta1.EndpointSlice); !ok {
+			return
+		}
+	}
+	serviceName := slice.Labels[discoveryv1beta1.LabelServiceName]
+	if serviceName == "" {
+		return
+	}
+	for _, key := range c.backendServices.get(types.NamespacedName{Namespace: slice.Namespace, Name: serviceName}) {
+		c.workqueue.Add(key)
+	}
+}
+
+// serviceBackends returns the addresses of the ready endpoints of the
+// Service, sorted, each as a backend of weight 1.
+func (c *Controller) serviceBackends(service types.NamespacedName) ([]nfvv1.LBTarget, error) {
+	slices, err := c.endpointSlicesLister.EndpointSlices(service.Namespace).List(labels.SelectorFromSet(labels.Set{discoveryv1beta1.LabelServiceName: service.Name}))
+	if err != nil {
+		return nil, err
+	}
+	ready := map[string]bool{}
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				ready[address] = true
+			}
+		}
+	}
+	addresses := make([]string, 0, len(ready))
+	for address := range ready {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	backends := make([]nfvv1.LBTarget, 0, len(addresses))
+	for _, address := range addresses {
+		backends = append(backends, nfvv1.LBTarget{BackendIP: address, Weight: 1})
+	}
+	return backends, nil
+}
+
+// ensureLoadBalancerBackends sets the backends of the rules of the
+// LoadBalancerRules of the router namespace annotated with
+// BACKEND_SERVICES_ANNOTATION to the ready endpoints of their Services, with
+// the LoadBalancerBackendServices feature gate on. Only the LoadBalancerRules
+// whose backends changed are updated, which router pods watching them apply
+// right away, unless the gate freezes changes.
+func (c *Controller) ensureLoadBalancerBackends(key string, newNS string, gate *disruptionGate) error {
+	if c.loadBalancerRulesLister == nil {
+		return nil
+	}
+	var followed []types.NamespacedName
+	defer func() { c.backendServices.set(key, followed) }()
+
+	rules := c.dynamicclient.Resource(loadBalancerRuleResource).Namespace(newNS)
+	list, err := c.loadBalancerRulesLister.ByNamespace(newNS).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].(*unstructured.Unstructured).GetName() < list[j].(*unstructured.Unstructured).GetName()
+	})
+	for _, item := range list {
+		obj := item.(*unstructured.Unstructured)
+		value, ok := obj.GetAnnotations()[BACKEND_SERVICES_ANNOTATION]
+		if !ok {
+			continue
+		}
+		services, err := parseBackendServices(value, obj.GetNamespace())
+		if err != nil {
+			c.recorder.Event(obj, corev1.EventTypeWarning, ErrInvalidBackendServices, err.Error())
+			continue
+		}
+		for _, service := range services {
+			followed = append(followed, service)
+		}
+
+		var rule nfvv1.LoadBalancerRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rule); err != nil {
+			return err
+		}
+		changed := false
+		for j := range rule.Spec.Rules {
+			service, ok := services[rule.Spec.Rules[j].LoadBalancerIP]
+			if !ok {
+				continue
+			}
+			backends, err := c.serviceBackends(service)
+			if err != nil {
+				return err
+			}
+			if current := rule.Spec.Rules[j].BackendIPs; (len(current) > 0 || len(backends) > 0) && !reflect.DeepEqual(current, backends) {
+				rule.Spec.Rules[j].BackendIPs = backends
+				changed = true
+			}
+		}
+		if !changed || gate.freezes(changeBackends) {
+			continue
+		}
+		updated, err := toUnstructured(&rule)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Updating the backends of LoadBalancerRule %s/%s", obj.GetNamespace(), obj.GetName())
+		objCopy := obj.DeepCopy()
+		objCopy.Object["spec"] = updated.Object["spec"]
+		if _, err := rules.Update(c.ctx, objCopy, metav1.UpdateOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
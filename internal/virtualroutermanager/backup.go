@@ -0,0 +1,290 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// BACKUP_ANNOTATION asks for a backup of the VirtualRouter. Whenever its
+	// value changes, the VirtualRouter and the rules of its router namespace
+	// are written as a YAML bundle to the backup Secret, which keeps the
+	// value of the request it was written for under the same annotation.
+	BACKUP_ANNOTATION string = "network.tmaxanc.com/backup"
+	// BACKUP_SECRET_NAME is the Secret of the namespace of the VirtualRouter,
+	// prefixed with the VirtualRouter name, holding its backup. It isn't
+	// owned by the VirtualRouter, so the backup outlives it.
+	BACKUP_SECRET_NAME string = "virtualrouter-backup"
+	// BACKUP_BUNDLE_KEY holds the bundle in the backup Secret
+	BACKUP_BUNDLE_KEY string = "bundle.yaml"
+
+	// RESTORE_ANNOTATION names a backup Secret of the namespace of the
+	// VirtualRouter to restore the rules and the WireGuard keys of the router
+	// from. The bundled VirtualRouter is given it, so creating it from the
+	// bundle, as is or changed, restores the rest.
+	RESTORE_ANNOTATION string = "network.tmaxanc.com/restore-from"
+	// RESTORED_ANNOTATION replaces RESTORE_ANNOTATION once restored, so
+	// objects deleted afterwards aren't restored again
+	RESTORED_ANNOTATION string = "network.tmaxanc.com/restored-from"
+)
+
+var secretResource = corev1.SchemeGroupVersion.WithResource("secrets")
+
+// BackupSecretName returns the name of the backup Secret of the VirtualRouter.
+func BackupSecretName(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return virtualRouter.Name + "-" + BACKUP_SECRET_NAME
+}
+
+// renderBundle returns the VirtualRouter, the rules of its router namespace
+// and its WireGuard keys as YAML documents, the VirtualRouter first. The
+// rules the controller derives from the spec are left out, as it derives
+// them again from the restored spec. Nothing is redacted, so the bundle is
+// only ever kept in a Secret.
+func (c *Controller) renderBundle(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) ([]byte, error) {
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	virtualRouterCopy.APIVersion = samplev1alpha1.SchemeGroupVersion.String()
+	virtualRouterCopy.Kind = "VirtualRouter"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(virtualRouterCopy)
+	if err != nil {
+		return nil, err
+	}
+	bundled := bundleObject(&unstructured.Unstructured{Object: content})
+	bundled.SetOwnerReferences(nil)
+	annotations := bundled.GetAnnotations()
+	delete(annotations, BACKUP_ANNOTATION)
+	delete(annotations, RESTORED_ANNOTATION)
+	// the namespace of the router is owned by it, a clone is given its own
+	delete(annotations, ROUTER_NAMESPACE_ANNOTATION)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[RESTORE_ANNOTATION] = BackupSecretName(virtualRouter)
+	bundled.SetAnnotations(annotations)
+	documents := []*unstructured.Unstructured{bundled}
+
+	for _, r := range ruleResources {
+		list, err := c.dynamicclient.Resource(r.resource).Namespace(newNS).List(c.ctx, metav1.ListOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				// the rule type isn't installed
+				continue
+			}
+			return nil, err
+		}
+		for i := range list.Items {
+			if owner := metav1.GetControllerOf(&list.Items[i]); owner != nil && owner.Kind == "VirtualRouter" {
+				continue
+			}
+			documents = append(documents, bundleObject(&list.Items[i]))
+		}
+	}
+
+	if virtualRouter.Spec.WireGuard != nil {
+		secret, err := c.kubeclientset.CoreV1().Secrets(newNS).Get(c.ctx, WireGuardSecretName(virtualRouter), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+			if err != nil {
+				return nil, err
+			}
+			obj := &unstructured.Unstructured{Object: content}
+			obj.SetAPIVersion("v1")
+			obj.SetKind("Secret")
+			bundled := bundleObject(obj)
+			bundled.Object["type"] = obj.Object["type"]
+			bundled.Object["data"] = obj.Object["data"]
+			documents = append(documents, bundled)
+		}
+	}
+
+	var rendered []string
+	for _, document := range documents {
+		out, err := yaml.Marshal(document.Object)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, string(out))
+	}
+	return []byte(strings.Join(rendered, "---\n")), nil
+}
+
+// bundleObject keeps what is needed to create the object again: its spec and
+// the identifying metadata, along with its owners.
+func bundleObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	bundled := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	bundled.SetAPIVersion(obj.GetAPIVersion())
+	bundled.SetKind(obj.GetKind())
+	bundled.SetName(obj.GetName())
+	bundled.SetNamespace(obj.GetNamespace())
+	bundled.SetLabels(obj.GetLabels())
+	annotations := obj.GetAnnotations()
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	bundled.SetAnnotations(annotations)
+	bundled.SetOwnerReferences(obj.GetOwnerReferences())
+	if spec, ok := obj.Object["spec"]; ok {
+		bundled.Object["spec"] = runtime.DeepCopyJSONValue(spec)
+	}
+	return bundled
+}
+
+// parseBundle returns the objects of a bundle, in order.
+func parseBundle(bundle []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, document := range strings.Split("\n"+string(bundle), "\n---\n") {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(document), &obj.Object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// ensureBackup writes the backup of the VirtualRouter to its backup Secret
+// when BACKUP_ANNOTATION asks for one it wasn't written for yet.
+func (c *Controller) ensureBackup(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	request, ok := virtualRouter.Annotations[BACKUP_ANNOTATION]
+	if !ok || !virtualRouter.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	secrets := c.kubeclientset.CoreV1().Secrets(virtualRouter.Namespace)
+	secret, err := secrets.Get(c.ctx, BackupSecretName(virtualRouter), metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if exists && secret.Annotations[BACKUP_ANNOTATION] == request {
+		return nil
+	}
+
+	bundle, err := c.renderBundle(newNS, virtualRouter)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Backing up VirtualRouter %s/%s to Secret %s", virtualRouter.Namespace, virtualRouter.Name, BackupSecretName(virtualRouter))
+	if !exists {
+		_, err = secrets.Create(c.ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        BackupSecretName(virtualRouter),
+				Namespace:   virtualRouter.Namespace,
+				Annotations: map[string]string{BACKUP_ANNOTATION: request},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{BACKUP_BUNDLE_KEY: bundle},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	secretCopy := secret.DeepCopy()
+	if secretCopy.Annotations == nil {
+		secretCopy.Annotations = map[string]string{}
+	}
+	secretCopy.Annotations[BACKUP_ANNOTATION] = request
+	secretCopy.Data = map[string][]byte{BACKUP_BUNDLE_KEY: bundle}
+	_, err = secrets.Update(c.ctx, secretCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// restoreBackup creates the rules and the WireGuard keys of the backup
+// Secret named by RESTORE_ANNOTATION in the router namespace, owned by the
+// VirtualRouter where they were owned by the bundled one. Objects already
+// there are left as they are. It returns the VirtualRouter to go on with.
+func (c *Controller) restoreBackup(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	secretName, ok := virtualRouter.Annotations[RESTORE_ANNOTATION]
+	if !ok || !virtualRouter.DeletionTimestamp.IsZero() {
+		return virtualRouter, nil
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(virtualRouter.Namespace).Get(c.ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("reading the backup of VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	}
+	objects, err := parseBundle(secret.Data[BACKUP_BUNDLE_KEY])
+	if err != nil {
+		return nil, fmt.Errorf("parsing backup Secret %s/%s: %v", virtualRouter.Namespace, secretName, err)
+	}
+	if len(objects) == 0 || objects[0].GetKind() != "VirtualRouter" {
+		return nil, fmt.Errorf("backup Secret %s/%s holds no VirtualRouter", virtualRouter.Namespace, secretName)
+	}
+	bundled := objects[0]
+
+	for _, obj := range objects[1:] {
+		var resource schema.GroupVersionResource
+		name := obj.GetName()
+		if obj.GetKind() == "Secret" {
+			if virtualRouter.Spec.WireGuard == nil || name != bundledRouterResourceName(bundled, WIREGUARD_SECRET_NAME) {
+				continue
+			}
+			resource = secretResource
+			name = WireGuardSecretName(virtualRouter)
+		} else {
+			for _, r := range ruleResources {
+				if r.kind == obj.GetKind() {
+					resource = r.resource
+				}
+			}
+			if resource.Empty() {
+				klog.Warningf("Not restoring %s %s of backup Secret %s/%s", obj.GetKind(), name, virtualRouter.Namespace, secretName)
+				continue
+			}
+		}
+
+		restored := obj.DeepCopy()
+		restored.SetName(name)
+		restored.SetNamespace(newNS)
+		var ownerReferences []metav1.OwnerReference
+		for _, ref := range obj.GetOwnerReferences() {
+			// owners other than the bundled VirtualRouter can't be told apart
+			if ref.Kind != "VirtualRouter" || ref.Name != bundled.GetName() {
+				continue
+			}
+			ref.Name = virtualRouter.Name
+			ref.UID = virtualRouter.UID
+			ownerReferences = append(ownerReferences, ref)
+		}
+		restored.SetOwnerReferences(ownerReferences)
+
+		klog.Infof("Restoring %s %s/%s of VirtualRouter %s/%s", obj.GetKind(), newNS, name, virtualRouter.Namespace, virtualRouter.Name)
+		if resource == secretResource {
+			var restoredSecret corev1.Secret
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(restored.Object, &restoredSecret); err != nil {
+				return nil, err
+			}
+			_, err = c.kubeclientset.CoreV1().Secrets(newNS).Create(c.ctx, &restoredSecret, metav1.CreateOptions{})
+		} else {
+			_, err = c.dynamicclient.Resource(resource).Namespace(newNS).Create(c.ctx, restored, metav1.CreateOptions{})
+		}
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return nil, err
+		}
+	}
+
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	delete(virtualRouterCopy.Annotations, RESTORE_ANNOTATION)
+	virtualRouterCopy.Annotations[RESTORED_ANNOTATION] = secretName
+	return c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+}
+
+// bundledRouterResourceName is routerResourceName for the bundled
+// VirtualRouter.
+func bundledRouterResourceName(bundled *unstructured.Unstructured, name string) string {
+	var virtualRouter samplev1alpha1.VirtualRouter
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(bundled.Object, &virtualRouter); err != nil {
+		return ""
+	}
+	return routerResourceName(&virtualRouter, name)
+}
@@ -0,0 +1,38 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// DEFAULT_KUBE_API_QPS and DEFAULT_KUBE_API_BURST rate limit the requests
+	// of every client of the controller, well above the client-go defaults
+	// throttling controllers of hundreds of routers
+	DEFAULT_KUBE_API_QPS   float32 = 20
+	DEFAULT_KUBE_API_BURST int     = 30
+
+	// CLIENT_COMPONENT_OBJECTS and CLIENT_COMPONENT_STATUS name the clients
+	// creating and updating the objects of routers, and writing the status of
+	// VirtualRouters and Services, in their user agent
+	CLIENT_COMPONENT_OBJECTS string = "objects"
+	CLIENT_COMPONENT_STATUS  string = "status"
+)
+
+// userAgentName is the user agent of the controller, followed by the
+// component of the client
+const userAgentName = "virtualrouter-controller"
+
+// ClientConfig returns a copy of the configuration for the clients of a
+// component of the controller, rate limited of their own to qps and burst.
+// The component is told in the user agent, such as
+// virtualrouter-controller/status, for the API server audit log and the API
+// Priority and Fairness debug endpoints to tell the requests apart.
+func ClientConfig(cfg *rest.Config, component string, qps float32, burst int) *rest.Config {
+	componentCfg := rest.CopyConfig(cfg)
+	componentCfg.QPS = qps
+	componentCfg.Burst = burst
+	componentCfg.UserAgent = fmt.Sprintf("%s/%s %s", userAgentName, component, rest.DefaultKubernetesUserAgent())
+	return componentCfg
+}
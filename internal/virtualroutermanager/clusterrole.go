@@ -0,0 +1,208 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	rbac_v1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_CLUSTER_ROLE_NAME is the ClusterRole router pods are bound to
+	// with Options.RouterClusterRole. It aggregates the rules of the
+	// ClusterRoles labelled with ROUTER_CLUSTER_ROLE_AGGREGATION_LABEL, so
+	// deployments needing more can label a ClusterRole of their own.
+	ROUTER_CLUSTER_ROLE_NAME string = "virtualrouter-router-cluster-role"
+	// ROUTER_CLUSTER_VIEW_ROLE_NAME holds the rules aggregated by default:
+	// reading nodes and custom resource definitions
+	ROUTER_CLUSTER_VIEW_ROLE_NAME         string = "virtualrouter-router-cluster-view"
+	ROUTER_CLUSTER_ROLE_AGGREGATION_LABEL string = "network.tmaxanc.com/aggregate-to-virtualrouter-router"
+
+	// VIRTUALROUTER_CLUSTER_ROLE_FINALIZER keeps a VirtualRouter until its
+	// ClusterRoleBinding is deleted, which as a cluster-scoped object can't be
+	// collected along with it.
+	VIRTUALROUTER_CLUSTER_ROLE_FINALIZER string = "virtualrouter/cluster-role-finalizer"
+	// ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION holds the namespace/name
+	// of the VirtualRouter a ClusterRoleBinding was created for, so no other
+	// router takes it for its own or deletes it
+	ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION string = "network.tmaxanc.com/cluster-role-binding-owner"
+)
+
+// newRouterClusterRoles returns the aggregated ClusterRole of router pods and
+// the ClusterRole holding its default rules. Both are shared by all routers.
+func newRouterClusterRoles() []*rbac_v1.ClusterRole {
+	aggregationLabels := map[string]string{ROUTER_CLUSTER_ROLE_AGGREGATION_LABEL: "true"}
+	return []*rbac_v1.ClusterRole{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: ROUTER_CLUSTER_ROLE_NAME},
+			AggregationRule: &rbac_v1.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: aggregationLabels}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: ROUTER_CLUSTER_VIEW_ROLE_NAME, Labels: aggregationLabels},
+			Rules: []rbac_v1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"nodes"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{"apiextensions.k8s.io"},
+					Resources: []string{"customresourcedefinitions"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+			},
+		},
+	}
+}
+
+// routerClusterRoleBindingName names the ClusterRoleBinding of a router after
+// the VirtualRouter, as VirtualRouters claiming the same router namespace
+// would otherwise share it. Namespace and name may both hold dashes, so a
+// hash of the two keeps a-b/c and a/b-c apart.
+func routerClusterRoleBindingName(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	h := fnv.New32a()
+	h.Write([]byte(routerClusterRoleBindingOwner(virtualRouter)))
+	return fmt.Sprintf("%s-%08x", legacyRouterClusterRoleBindingName(virtualRouter), h.Sum32())
+}
+
+// legacyRouterClusterRoleBindingName is the name ClusterRoleBindings of
+// routers were created with before the hash was added. They are deleted
+// once owned.
+func legacyRouterClusterRoleBindingName(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return "virtualrouter-" + virtualRouter.Namespace + "-" + virtualRouter.Name
+}
+
+func routerClusterRoleBindingOwner(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return virtualRouter.Namespace + "/" + virtualRouter.Name
+}
+
+// newRouterClusterRoleBinding binds the router ClusterRole to the router
+// ServiceAccount.
+func newRouterClusterRoleBinding(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *rbac_v1.ClusterRoleBinding {
+	return &rbac_v1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        routerClusterRoleBindingName(virtualRouter),
+			Annotations: map[string]string{ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION: routerClusterRoleBindingOwner(virtualRouter)},
+		},
+		RoleRef: rbac_v1.RoleRef{
+			APIGroup: rbac_v1.SchemeGroupVersion.Group,
+			Kind:     "ClusterRole",
+			Name:     ROUTER_CLUSTER_ROLE_NAME,
+		},
+		Subjects: []rbac_v1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      routerResourceName(virtualRouter, SERVICE_ACCOUNT_NAME),
+				Namespace: newNS,
+			},
+		},
+	}
+}
+
+// ownsRouterClusterRoleBinding reports whether the ClusterRoleBinding is that
+// of the router: annotated with it, or, created before the annotation, binding
+// its ServiceAccount only.
+func ownsRouterClusterRoleBinding(binding *rbac_v1.ClusterRoleBinding, newNS string, virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	if owner, ok := binding.Annotations[ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION]; ok {
+		return owner == routerClusterRoleBindingOwner(virtualRouter)
+	}
+	return reflect.DeepEqual(binding.Subjects, newRouterClusterRoleBinding(newNS, virtualRouter).Subjects)
+}
+
+// ensureRouterClusterRoleBinding binds the router pods to the router
+// ClusterRole with Options.RouterClusterRole, holding the VirtualRouter with
+// a finalizer meanwhile, and deletes the binding once the option is turned
+// off or the VirtualRouter is deleted. It returns the VirtualRouter to go on
+// with.
+func (c *Controller) ensureRouterClusterRoleBinding(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	wanted := c.options.RouterClusterRole && virtualRouter.DeletionTimestamp.IsZero()
+	if !wanted && !hasFinalizer(virtualRouter, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER) {
+		return virtualRouter, nil
+	}
+
+	if wanted {
+		if !hasFinalizer(virtualRouter, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER) {
+			// the finalizer is added first, so no binding is ever left behind
+			virtualRouterCopy := virtualRouter.DeepCopy()
+			virtualRouterCopy.Finalizers = append(virtualRouterCopy.Finalizers, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER)
+			updated, err := c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+			if err != nil {
+				return nil, err
+			}
+			virtualRouter = updated
+		}
+		return virtualRouter, c.ensureRouterClusterRoles(newNS, virtualRouter)
+	}
+
+	for _, name := range []string{routerClusterRoleBindingName(virtualRouter), legacyRouterClusterRoleBindingName(virtualRouter)} {
+		if err := c.deleteRouterClusterRoleBinding(name, newNS, virtualRouter); err != nil {
+			return nil, err
+		}
+	}
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	virtualRouterCopy.Finalizers = removeFinalizer(virtualRouterCopy.Finalizers, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER)
+	return c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+}
+
+// ensureRouterClusterRoles creates the router ClusterRoles and the
+// ClusterRoleBinding of the router if missing.
+func (c *Controller) ensureRouterClusterRoles(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	for _, clusterRole := range newRouterClusterRoles() {
+		_, err := c.kubeclientset.RbacV1().ClusterRoles().Get(c.ctx, clusterRole.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = c.kubeclientset.RbacV1().ClusterRoles().Create(c.ctx, clusterRole, metav1.CreateOptions{})
+		}
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	binding := newRouterClusterRoleBinding(newNS, virtualRouter)
+	existing, err := c.kubeclientset.RbacV1().ClusterRoleBindings().Get(c.ctx, binding.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		if _, err := c.kubeclientset.RbacV1().ClusterRoleBindings().Create(c.ctx, binding, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case existing.Annotations[ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION] != binding.Annotations[ROUTER_CLUSTER_ROLE_BINDING_OWNER_ANNOTATION]:
+		return fmt.Errorf("ClusterRoleBinding %s is not that of VirtualRouter %s/%s", binding.Name, virtualRouter.Namespace, virtualRouter.Name)
+	case !reflect.DeepEqual(existing.Subjects, binding.Subjects):
+		existingCopy := existing.DeepCopy()
+		existingCopy.Subjects = binding.Subjects
+		if _, err := c.kubeclientset.RbacV1().ClusterRoleBindings().Update(c.ctx, existingCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return c.deleteRouterClusterRoleBinding(legacyRouterClusterRoleBindingName(virtualRouter), newNS, virtualRouter)
+}
+
+// deleteRouterClusterRoleBinding deletes the ClusterRoleBinding of the name
+// if it is that of the router, leaving those of other routers alone.
+func (c *Controller) deleteRouterClusterRoleBinding(name string, newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	binding, err := c.kubeclientset.RbacV1().ClusterRoleBindings().Get(c.ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !ownsRouterClusterRoleBinding(binding, newNS, virtualRouter) {
+		return nil
+	}
+	klog.Infof("Deleting ClusterRoleBinding %s of VirtualRouter %s/%s", name, virtualRouter.Namespace, virtualRouter.Name)
+	err = c.kubeclientset.RbacV1().ClusterRoleBindings().Delete(c.ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &binding.UID}})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package virtualroutermanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_CONFIG_NAME is the ConfigMap holding the compiled configuration
+	// of a router using the ConfigMap config source
+	ROUTER_CONFIG_NAME string = "virtualrouter-config"
+	// ROUTER_CONFIG_DIR is where the configuration is mounted in router pods,
+	// also given to them in the ROUTER_CONFIG_DIR environment variable
+	ROUTER_CONFIG_DIR string = "/etc/virtualrouter/config"
+	// CONFIG_CHECKSUM_ANNOTATION holds the checksum of the configuration in
+	// the pod template, so a configuration change rolls out router pods
+	CONFIG_CHECKSUM_ANNOTATION string = "network.tmaxanc.com/config-checksum"
+)
+
+const routerConfigVolumeName = "config"
+
+func usesConfigMap(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.Spec.ConfigSource == samplev1alpha1.ConfigMapConfigSource
+}
+
+// routerInterfaceConfig is the interface and route configuration of a
+// router, with the external address it is actually assigned.
+type routerInterfaceConfig struct {
+	VlanNumber      int32  `json:"vlanNumber"`
+	InternalIP      string `json:"internalIP"`
+	InternalNetmask string `json:"internalNetmask"`
+	ExternalIP      string `json:"externalIP"`
+	ExternalNetmask string `json:"externalNetmask"`
+	GatewayIP       string `json:"gatewayIP"`
+}
+
+// compiledRule is a NAT, firewall or load balancer rule as the router
+// applies it.
+type compiledRule struct {
+	Name  string      `json:"name"`
+	Rules interface{} `json:"rules"`
+}
+
+// renderRouterConfig compiles the configuration of the router: router.yaml
+// for its interfaces and routes, and a file per rule type holding the rules
+// of the router namespace sorted by name.
+func (c *Controller) renderRouterConfig(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (map[string]string, error) {
+	externalNetmask := virtualRouter.Spec.ExternalNetmask
+	if allocation := virtualRouter.Status.IPAMAllocation; externalNetmask == "" && allocation != nil {
+		if _, network, err := net.ParseCIDR(allocation.Address); err == nil {
+			externalNetmask = net.IP(network.Mask).String()
+		}
+	}
+	router, err := yaml.Marshal(routerInterfaceConfig{
+		VlanNumber:      virtualRouter.Spec.VlanNumber,
+		InternalIP:      virtualRouter.Spec.InternalIP,
+		InternalNetmask: virtualRouter.Spec.InternalNetmask,
+		ExternalIP:      effectiveExternalIP(virtualRouter),
+		ExternalNetmask: externalNetmask,
+		GatewayIP:       virtualRouter.Spec.GatewayIP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{"router.yaml": string(router)}
+
+	for _, r := range ruleResources {
+		list, err := c.dynamicclient.Resource(r.resource).Namespace(newNS).List(c.ctx, metav1.ListOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].GetName() < list.Items[j].GetName() })
+		compiled := []compiledRule{}
+		for _, item := range list.Items {
+			spec, _ := item.Object["spec"].(map[string]interface{})
+			compiled = append(compiled, compiledRule{Name: item.GetName(), Rules: spec["rules"]})
+		}
+		rules, err := yaml.Marshal(compiled)
+		if err != nil {
+			return nil, err
+		}
+		data[r.resource.Resource+".yaml"] = string(rules)
+	}
+	return data, nil
+}
+
+// configChecksum hashes the files of the configuration in name order.
+func configChecksum(data map[string]string) string {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hasher := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hasher, "%s\x00%s\x00", key, data[key])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// ensureRouterConfig writes the compiled configuration of a router using the
+// ConfigMap config source, and returns its checksum. It returns an empty
+// checksum for routers watching the API. A rewrite held back by the gate
+// leaves the written configuration, and its checksum, as they are.
+func (c *Controller) ensureRouterConfig(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) (string, error) {
+	if !usesConfigMap(virtualRouter) {
+		return "", nil
+	}
+	data, err := c.renderRouterConfig(newNS, virtualRouter)
+	if err != nil {
+		return "", err
+	}
+	if gate.isClosed() {
+		configMap, err := c.kubeclientset.CoreV1().ConfigMaps(newNS).Get(c.ctx, routerResourceName(virtualRouter, ROUTER_CONFIG_NAME), metav1.GetOptions{})
+		if err == nil && metav1.IsControlledBy(configMap, virtualRouter) && !reflect.DeepEqual(configMap.Data, data) {
+			gate.defers(changeRuleset)
+			return configChecksum(configMap.Data), nil
+		}
+	}
+	if err := c.ensureConfigMap(newRouterConfigMap(newNS, virtualRouter, ROUTER_CONFIG_NAME, data), virtualRouter, gate); err != nil {
+		return "", err
+	}
+	return configChecksum(data), nil
+}
+
+// ensureConfigMap creates or updates a ConfigMap of the router. Only a missing
+// ConfigMap is created while the gate freezes changes.
+func (c *Controller) ensureConfigMap(desired *corev1.ConfigMap, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) error {
+	configMap, err := c.kubeclientset.CoreV1().ConfigMaps(desired.Namespace).Get(c.ctx, desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = c.kubeclientset.CoreV1().ConfigMaps(desired.Namespace).Create(c.ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(configMap, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, configMap.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if !reflect.DeepEqual(configMap.Data, desired.Data) && !gate.freezes(changeDnsmasqConfig) {
+		klog.Infof("Updating ConfigMap %s of VirtualRouter %s/%s", configMap.Name, virtualRouter.Namespace, virtualRouter.Name)
+		configMapCopy := configMap.DeepCopy()
+		configMapCopy.Data = desired.Data
+		if _, err := c.kubeclientset.CoreV1().ConfigMaps(desired.Namespace).Update(c.ctx, configMapCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newRouterConfigMap(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, name),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Data: data,
+	}
+}
+
+// addRouterConfigVolume mounts the configuration into the router container.
+func addRouterConfigVolume(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	mountRouterConfigMap(deployment, routerConfigVolumeName, routerResourceName(virtualRouter, ROUTER_CONFIG_NAME), ROUTER_CONFIG_DIR, "ROUTER_CONFIG_DIR")
+}
+
+// mountRouterConfigMap mounts a ConfigMap of the router into the router
+// container, and gives the directory in the environment variable.
+func mountRouterConfigMap(deployment *appsv1.Deployment, volumeName string, configMapName string, dir string, env string) {
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: dir,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: env, Value: dir})
+}
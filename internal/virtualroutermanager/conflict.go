@@ -0,0 +1,115 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ErrDeploymentNameConflict is used as part of the Event 'reason' and the
+	// DeploymentNameConflict condition reason when another VirtualRouter
+	// claims the Deployment of the router
+	ErrDeploymentNameConflict = "ErrDeploymentNameConflict"
+	// MessageDeploymentNameConflict is the message used for Events and the
+	// DeploymentNameConflict condition when the Deployment is claimed by
+	// another VirtualRouter
+	MessageDeploymentNameConflict = "Deployment %s/%s is claimed by VirtualRouter %s/%s"
+)
+
+// claimsSameDeployment reports whether two VirtualRouters would manage the
+// same router resources: VirtualRouters of the same name in different
+// namespaces get the same dedicated namespace, and a tenant namespace can be
+// given the name of another router as well. A dedicated namespace belongs to
+// a single router, whatever its Deployment is called.
+func claimsSameDeployment(a, b *samplev1alpha1.VirtualRouter) bool {
+	if RouterNamespace(a) != RouterNamespace(b) {
+		return false
+	}
+	return a.Spec.DeploymentName == b.Spec.DeploymentName || !isTenantPlacement(a) || !isTenantPlacement(b)
+}
+
+// deploymentClaimant returns the VirtualRouter the router resources of the
+// given one belong to when others claim them as well, or nil if they are its
+// own. The claimant controlling the existing Deployment keeps it, otherwise
+// the oldest VirtualRouter wins, so the outcome doesn't depend on the order
+// the VirtualRouters are synced in.
+func (c *Controller) deploymentClaimant(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	claimants := []*samplev1alpha1.VirtualRouter{virtualRouter}
+	for _, other := range virtualRouters {
+		if other.UID != virtualRouter.UID && other.DeletionTimestamp.IsZero() && claimsSameDeployment(virtualRouter, other) {
+			claimants = append(claimants, other)
+		}
+	}
+	if len(claimants) == 1 {
+		return nil, nil
+	}
+
+	sort.Slice(claimants, func(i, j int) bool {
+		if !claimants[i].CreationTimestamp.Equal(&claimants[j].CreationTimestamp) {
+			return claimants[i].CreationTimestamp.Before(&claimants[j].CreationTimestamp)
+		}
+		if claimants[i].Namespace != claimants[j].Namespace {
+			return claimants[i].Namespace < claimants[j].Namespace
+		}
+		return claimants[i].Name < claimants[j].Name
+	})
+	owner := claimants[0]
+	if deployment, err := c.routerWorkload(RouterNamespace(virtualRouter), virtualRouter); err == nil {
+		if controllerRef := metav1.GetControllerOf(deployment); controllerRef != nil {
+			for _, claimant := range claimants {
+				if claimant.UID == controllerRef.UID {
+					owner = claimant
+				}
+			}
+		}
+	}
+	if owner.UID == virtualRouter.UID {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+// reportDeploymentNameConflict sets the DeploymentNameConflict condition of
+// the router whose Deployment the owner claims, leaving the Deployment to the
+// owner. enqueueConflictingVirtualRouters brings the router back once the
+// owner is changed or deleted.
+func (c *Controller) reportDeploymentNameConflict(virtualRouter, owner *samplev1alpha1.VirtualRouter) error {
+	message := fmt.Sprintf(MessageDeploymentNameConflict, RouterNamespace(virtualRouter), virtualRouter.Spec.DeploymentName, owner.Namespace, owner.Name)
+	klog.Warningf("VirtualRouter %s/%s: %s", virtualRouter.Namespace, virtualRouter.Name, message)
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.DeploymentNameConflictCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             ErrDeploymentNameConflict,
+		Message:            message,
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, nil)
+}
+
+// enqueueConflictingVirtualRouters requeues the VirtualRouters held back by
+// a Deployment name conflict, which the given change may have resolved.
+func (c *Controller) enqueueConflictingVirtualRouters() {
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, virtualRouter := range virtualRouters {
+		if meta.IsStatusConditionTrue(virtualRouter.Status.Conditions, samplev1alpha1.DeploymentNameConflictCondition) {
+			c.enqueueVirtualRouter(virtualRouter)
+		}
+	}
+}
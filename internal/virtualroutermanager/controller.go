@@ -3,25 +3,54 @@ package virtualroutermanager
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	rbac_v1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	appsinformers "k8s.io/client-go/informers/apps/v1"
+	autoscalinginformers "k8s.io/client-go/informers/autoscaling/v2beta2"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1beta1"
+	policyinformers "k8s.io/client-go/informers/policy/v1beta1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v2beta2"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1beta1"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	hashutil "k8s.io/kubernetes/pkg/util/hash"
 
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
 	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
 	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
 	samplescheme "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/scheme"
@@ -40,9 +69,22 @@ const (
 	VIRTUALROUTER_DAEMON_FINALIZER string = "virtualrouter/daemon-finalizer"
 )
 
+// VIRTUALROUTER_READINESS_GATE is the pod condition set by the daemon once the
+// router data plane (interfaces, addresses and routes) and the rules the
+// daemon owns are programmed. Router pods only become Ready after it, so no
+// traffic is sent to an empty router. The NATRules, FireWallRules and
+// LoadBalancerRules the router applies itself are not waited for.
+const VIRTUALROUTER_READINESS_GATE corev1.PodConditionType = "network.tmaxanc.com/DataPlaneReady"
+
+// APPLIED_GENERATION_ANNOTATION is set on router pods by the daemon to the
+// generation of the VirtualRouter spec last applied to their data plane.
+const APPLIED_GENERATION_ANNOTATION string = "network.tmaxanc.com/applied-generation"
+
+// DEPLOYMENT_SPEC_HASH_ANNOTATION holds the hash of the Deployment spec last
+// rendered from the VirtualRouter.
+const DEPLOYMENT_SPEC_HASH_ANNOTATION string = "network.tmaxanc.com/spec-hash"
+
 const (
-	// SuccessSynced is used as part of the Event 'reason' when a VirtualRouter is synced
-	SuccessSynced = "Synced"
 	// ErrResourceExists is used as part of the Event 'reason' when a VirtualRouter fails
 	// to sync due to a Deployment of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
@@ -50,62 +92,284 @@ const (
 	// MessageResourceExists is the message used for Events when a resource
 	// fails to sync due to a Deployment already existing
 	MessageResourceExists = "Resource %q already exists and is not managed by VirtualRouter"
-	// MessageResourceSynced is the message used for an Event fired when a VirtualRouter
-	// is synced successfully
-	MessageResourceSynced = "VirtualRouter synced successfully"
+	// ErrImagePullSecretNotFound is used as part of the Event 'reason' when a
+	// pull secret to mirror into the router namespace doesn't exist
+	ErrImagePullSecretNotFound = "ErrImagePullSecretNotFound"
+	// MessageImagePullSecretNotFound is the message used for Events when a
+	// pull secret to mirror doesn't exist
+	MessageImagePullSecretNotFound = "Image pull secret %s/%s not found"
 )
 
 const networkGroupName = "network.tmaxanc.com"
 
+// Options holds the controller settings given on the command line.
+type Options struct {
+	// ManagementCIDRs are the networks of the cluster control plane, health
+	// probes, metrics scrapers and DNS servers. Traffic between them and the
+	// tenant networks is pinned open on every router.
+	ManagementCIDRs []string
+	// DefaultImagePullSecrets are Secrets in ControllerNamespace used by
+	// every router on top of its own spec.imagePullSecrets.
+	DefaultImagePullSecrets []string
+	// ControllerNamespace is the namespace the controller runs in.
+	ControllerNamespace string
+	// ExternalIPApprover, if set, must approve external IPs before routers
+	// are started with them.
+	ExternalIPApprover ExternalIPApprover
+	// IPAM, if set, allocates external IPs from spec.externalIPPool.
+	IPAM ipam.Provider
+	// WatchNamespaces, if set, are the only namespaces whose VirtualRouters
+	// are handled.
+	WatchNamespaces []string
+	// RuleExpiryWarning is how long ahead of the expiry of a temporary rule
+	// a warning is emitted, DEFAULT_RULE_EXPIRY_WARNING if 0.
+	RuleExpiryWarning time.Duration
+	// NamespaceTemplate names the dedicated namespaces of new VirtualRouters
+	// from {namespace} and {name}, DEFAULT_NAMESPACE_TEMPLATE if empty.
+	NamespaceTemplate string
+	// RouterClusterRole binds router pods to ROUTER_CLUSTER_ROLE_NAME, for
+	// deployments where they watch cluster-scoped resources.
+	RouterClusterRole bool
+	// ServiceLoadBalancers publishes the LoadBalancer Services annotated with
+	// VIRTUALROUTER_SERVICE_ANNOTATION on the external IP of their router.
+	ServiceLoadBalancers bool
+	// NodeFailureGracePeriod is how long router pods are left on a node not
+	// ready before they are force deleted, DEFAULT_NODE_FAILURE_GRACE_PERIOD
+	// if 0, and never if negative.
+	NodeFailureGracePeriod time.Duration
+	// DryRun handles every VirtualRouter as if it had DRY_RUN_ANNOTATION set.
+	DryRun bool
+	// DryRunClients builds the clients VirtualRouters in dry run are synced
+	// with.
+	DryRunClients DryRunClients
+	// ControllerClass is the spec.controllerClass of the VirtualRouters
+	// handled, those without one if empty.
+	ControllerClass string
+	// Monitoring generates a Grafana dashboard ConfigMap and a PrometheusRule
+	// for every VirtualRouter. It needs the Prometheus Operator CRDs.
+	Monitoring bool
+	// MonitoringLabels are added to the dashboard ConfigMaps and the
+	// PrometheusRules, for Grafana and Prometheus to select them by.
+	MonitoringLabels map[string]string
+	// DrainTimeout is how long the syncs running on shutdown are waited for
+	// before their API calls are cancelled, DEFAULT_DRAIN_TIMEOUT if 0.
+	DrainTimeout time.Duration
+	// AllowedRegistries, if set, are the registries, or repository paths of
+	// registries such as registry.example.com/tmax, router images must be of.
+	AllowedRegistries []string
+	// AllowedUnsafeSysctls are the sysctls, or prefixes ending with *, the
+	// kubelets allow by --allowed-unsafe-sysctls, which router pods are
+	// given in their security context rather than by the init container.
+	AllowedUnsafeSysctls []string
+	// RouterInitImage is the image of the init container setting the
+	// sysctls of router pods, DEFAULT_ROUTER_INIT_IMAGE if empty.
+	RouterInitImage string
+	// ImageVerifier, if set, must verify the signature of router images
+	// before routers are rolled onto them, by the digests the router pods
+	// are then pinned to.
+	ImageVerifier ImageVerifier
+	// StatusKubeClient and StatusClient, if set, write the status of Services
+	// and VirtualRouters, with a rate limit apart from the clients creating
+	// the objects of routers. Those clients write it if nil.
+	StatusKubeClient kubernetes.Interface
+	StatusClient     clientset.Interface
+	// EventClient, if set, records the Events of the controller. It is
+	// given apart so Events are still recorded when the writes of the
+	// other clients are dry runs. The client creating the objects of
+	// routers records them if nil.
+	EventClient kubernetes.Interface
+	// StatusBatchInterval, if set, is how long the status of VirtualRouters
+	// is held before it is written along with the others, keeping only the
+	// last status of a VirtualRouter synced several times in between. The
+	// status is written at the end of every sync if 0.
+	StatusBatchInterval time.Duration
+	// EnsureParallelism is how many of the objects of a router not depending
+	// on each other, such as its ServiceAccount, Role and RoleBinding, are
+	// created or updated at once within a sync. They are created one after
+	// another if 0 or 1.
+	EnsureParallelism int
+	// SidecarProfiles are the sidecars VirtualRouters declare by name in
+	// spec.sidecars[].profile, named after the profile.
+	SidecarProfiles []samplev1alpha1.RouterSidecar
+	// MACPools are the MAC pools VirtualRouters are given addresses from by
+	// name in spec.macAddresses.pool.
+	MACPools []samplev1alpha1.MACPool
+}
+
 // Controller is the controller implementation for VirtualRouter resources
 type Controller struct {
 	// kubeclientset is a standard kubernetes clientset
 	kubeclientset kubernetes.Interface
 	// sampleclientset is a clientset for our own API group
 	sampleclientset clientset.Interface
+	// statuskubeclientset and statusclientset write the status of Services
+	// and VirtualRouters
+	statuskubeclientset kubernetes.Interface
+	statusclientset     clientset.Interface
+	// dynamicclient handles the NFV rules applied by router pods
+	dynamicclient dynamic.Interface
+
+	options Options
+	// optionsLock guards the options changed by Reload, and is shared by the
+	// dry run copies of the controller.
+	optionsLock *sync.RWMutex
 
-	deploymentsLister    appslisters.DeploymentLister
-	deploymentsSynced    cache.InformerSynced
-	virtualRoutersLister listers.VirtualRouterLister
-	virtualRoutersSynced cache.InformerSynced
+	deploymentsLister              appslisters.DeploymentLister
+	deploymentsSynced              cache.InformerSynced
+	statefulSetsLister             appslisters.StatefulSetLister
+	statefulSetsSynced             cache.InformerSynced
+	podDisruptionBudgetsLister     policylisters.PodDisruptionBudgetLister
+	podDisruptionBudgetsSynced     cache.InformerSynced
+	horizontalPodAutoscalersLister autoscalinglisters.HorizontalPodAutoscalerLister
+	horizontalPodAutoscalersSynced cache.InformerSynced
+	podsLister                     corelisters.PodLister
+	podsSynced                     cache.InformerSynced
+	nodesLister                    corelisters.NodeLister
+	nodesSynced                    cache.InformerSynced
+	servicesLister                 corelisters.ServiceLister
+	servicesSynced                 cache.InformerSynced
+	virtualRoutersLister           listers.VirtualRouterLister
+	virtualRoutersIndexer          cache.Indexer
+	virtualRoutersSynced           cache.InformerSynced
+	virtualRouterProfilesLister    listers.VirtualRouterProfileLister
+	virtualRouterProfilesSynced    cache.InformerSynced
+	networkFreezesLister           listers.NetworkFreezeLister
+	networkFreezesSynced           cache.InformerSynced
+
+	// ruleInformers watch the NATRules and FireWallRules the controller
+	// manages for the routers. The EndpointSlices and LoadBalancerRules are
+	// only watched with the LoadBalancerBackendServices feature gate on.
+	ruleInformers           dynamicinformer.DynamicSharedInformerFactory
+	natRulesLister          cache.GenericLister
+	natRulesSynced          cache.InformerSynced
+	firewallRulesLister     cache.GenericLister
+	firewallRulesSynced     cache.InformerSynced
+	endpointSlicesLister    discoverylisters.EndpointSliceLister
+	endpointSlicesSynced    cache.InformerSynced
+	loadBalancerRulesLister cache.GenericLister
+	loadBalancerRulesSynced cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
 	// means we can ensure we only process a fixed amount of resources at a
 	// time, and makes it easy to ensure we are never processing the same item
-	// simultaneously in two different workers.
-	workqueue workqueue.RateLimitingInterface
+	// simultaneously in two different workers. It keeps the keys not synced
+	// yet, persisted on shutdown.
+	workqueue *pendingKeyQueue
+	// ctx is the context of the API calls of the syncs, cancelled when the
+	// syncs still running on shutdown outlast the drain timeout.
+	ctx    context.Context
+	cancel context.CancelFunc
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+	// clock is used to stamp status timestamps, so tests can fake the time.
+	clock clock.Clock
+	// namespaceBackoff spaces out the retries of VirtualRouters waiting for
+	// their namespace to finish terminating.
+	namespaceBackoff workqueue.RateLimiter
+	// dryRunPlan collects the changes left unapplied when syncing a
+	// VirtualRouter in dry run, and is nil otherwise.
+	dryRunPlan *DryRunPlan
+	// dryRunPlans holds the changes last recorded for VirtualRouters in dry run.
+	dryRunPlans *dryRunPlans
+	// backendServices holds the Services LoadBalancerRules of every
+	// VirtualRouter follow.
+	backendServices *backendServiceIndex
+	// macAddresses holds the MAC addresses given to the router pods.
+	macAddresses *macAllocator
+	// verifiedImages holds the router image digests whose signatures were
+	// verified, and the digests the images were resolved to.
+	verifiedImages *verifiedImages
+	// statusWriter holds the statuses written every StatusBatchInterval.
+	statusWriter *statusWriter
 }
 
 // NewController returns a new sample controller
 func NewController(
 	kubeclientset kubernetes.Interface,
 	sampleclientset clientset.Interface,
+	dynamicclient dynamic.Interface,
 	deploymentInformer appsinformers.DeploymentInformer,
-	virtualRouterInformer informers.VirtualRouterInformer) *Controller {
+	statefulSetInformer appsinformers.StatefulSetInformer,
+	podDisruptionBudgetInformer policyinformers.PodDisruptionBudgetInformer,
+	horizontalPodAutoscalerInformer autoscalinginformers.HorizontalPodAutoscalerInformer,
+	podInformer coreinformers.PodInformer,
+	nodeInformer coreinformers.NodeInformer,
+	serviceInformer coreinformers.ServiceInformer,
+	endpointSliceInformer discoveryinformers.EndpointSliceInformer,
+	virtualRouterInformer informers.VirtualRouterInformer,
+	virtualRouterProfileInformer informers.VirtualRouterProfileInformer,
+	networkFreezeInformer informers.NetworkFreezeInformer,
+	options Options) *Controller {
 
 	// Create event broadcaster
 	// Add virtual-router types to the default Kubernetes Scheme so Events can be
 	// logged for virtual-router types.
 	utilruntime.Must(samplescheme.AddToScheme(scheme.Scheme))
 	klog.V(4).Info("Creating event broadcaster")
+	eventClient := kubeclientset
+	if options.EventClient != nil {
+		eventClient = options.EventClient
+	}
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartStructuredLogging(0)
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: eventClient.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
+	ctx, cancel := context.WithCancel(context.Background())
 	controller := &Controller{
-		kubeclientset:        kubeclientset,
-		sampleclientset:      sampleclientset,
-		deploymentsLister:    deploymentInformer.Lister(),
-		deploymentsSynced:    deploymentInformer.Informer().HasSynced,
-		virtualRoutersLister: virtualRouterInformer.Lister(),
-		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
-		workqueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "VirtualRouters"),
-		recorder:             recorder,
+		ctx:                            ctx,
+		cancel:                         cancel,
+		kubeclientset:                  kubeclientset,
+		sampleclientset:                sampleclientset,
+		statuskubeclientset:            kubeclientset,
+		statusclientset:                sampleclientset,
+		dynamicclient:                  dynamicclient,
+		options:                        options,
+		optionsLock:                    &sync.RWMutex{},
+		deploymentsLister:              deploymentInformer.Lister(),
+		deploymentsSynced:              deploymentInformer.Informer().HasSynced,
+		statefulSetsLister:             statefulSetInformer.Lister(),
+		statefulSetsSynced:             statefulSetInformer.Informer().HasSynced,
+		podDisruptionBudgetsLister:     podDisruptionBudgetInformer.Lister(),
+		podDisruptionBudgetsSynced:     podDisruptionBudgetInformer.Informer().HasSynced,
+		horizontalPodAutoscalersLister: horizontalPodAutoscalerInformer.Lister(),
+		horizontalPodAutoscalersSynced: horizontalPodAutoscalerInformer.Informer().HasSynced,
+		podsLister:                     podInformer.Lister(),
+		podsSynced:                     podInformer.Informer().HasSynced,
+		nodesLister:                    nodeInformer.Lister(),
+		nodesSynced:                    nodeInformer.Informer().HasSynced,
+		servicesLister:                 serviceInformer.Lister(),
+		servicesSynced:                 serviceInformer.Informer().HasSynced,
+		virtualRoutersLister:           virtualRouterInformer.Lister(),
+		virtualRoutersIndexer:          virtualRouterInformer.Informer().GetIndexer(),
+		virtualRoutersSynced:           virtualRouterInformer.Informer().HasSynced,
+		virtualRouterProfilesLister:    virtualRouterProfileInformer.Lister(),
+		virtualRouterProfilesSynced:    virtualRouterProfileInformer.Informer().HasSynced,
+		networkFreezesLister:           networkFreezeInformer.Lister(),
+		networkFreezesSynced:           networkFreezeInformer.Informer().HasSynced,
+		workqueue:                      newPendingKeyQueue(newPriorityQueue(workqueue.DefaultControllerRateLimiter())),
+		recorder:                       recorder,
+		clock:                          clock.RealClock{},
+		namespaceBackoff:               workqueue.NewItemExponentialFailureRateLimiter(NAMESPACE_TERMINATING_BASE_DELAY, NAMESPACE_TERMINATING_MAX_DELAY),
+		dryRunPlans:                    &dryRunPlans{plans: map[string]string{}},
+		backendServices:                &backendServiceIndex{},
+		macAddresses:                   newMACAllocator(),
+		verifiedImages:                 newVerifiedImages(),
+		statusWriter:                   newStatusWriter(),
+	}
+
+	if options.StatusKubeClient != nil {
+		controller.statuskubeclientset = options.StatusKubeClient
+	}
+	if options.StatusClient != nil {
+		controller.statusclientset = options.StatusClient
+	}
+
+	// the objects of routers are matched with their VirtualRouter by UID
+	if err := virtualRouterInformer.Informer().AddIndexers(cache.Indexers{VIRTUALROUTER_UID_INDEX: virtualRouterUIDIndexFunc}); err != nil {
+		utilruntime.HandleError(fmt.Errorf("error indexing VirtualRouters by UID: %v", err))
 	}
 
 	klog.Info("Setting up event handlers")
@@ -113,8 +377,45 @@ func NewController(
 	virtualRouterInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueVirtualRouter,
 		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueVirtualRouter(new)
+			if isStatusOnlyUpdate(old.(*samplev1alpha1.VirtualRouter), new.(*samplev1alpha1.VirtualRouter)) {
+				// Our own status writes would otherwise requeue the VirtualRouter
+				// forever, as every sync stamps a new reconcile time.
+				return
+			}
+			if deleting(old.(*samplev1alpha1.VirtualRouter), new.(*samplev1alpha1.VirtualRouter)) {
+				// the router is cleaned up ahead of routine syncs
+				controller.enqueueUrgentVirtualRouter(new)
+			} else {
+				controller.enqueueVirtualRouter(new)
+			}
+			controller.enqueueConflictingVirtualRouters()
+		},
+		DeleteFunc: func(obj interface{}) {
+			controller.enqueueConflictingVirtualRouters()
+		},
+	})
+	// Routers are rendered again with the profiles they reference
+	virtualRouterProfileInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleProfile,
+		UpdateFunc: func(old, new interface{}) {
+			if new.(*samplev1alpha1.VirtualRouterProfile).ResourceVersion == old.(*samplev1alpha1.VirtualRouterProfile).ResourceVersion {
+				return
+			}
+			controller.handleProfile(new)
+		},
+		DeleteFunc: controller.handleProfile,
+	})
+	// Routers report the changes a freeze holds back, and make them once
+	// it is lifted
+	networkFreezeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleFreeze,
+		UpdateFunc: func(old, new interface{}) {
+			if new.(*samplev1alpha1.NetworkFreeze).ResourceVersion == old.(*samplev1alpha1.NetworkFreeze).ResourceVersion {
+				return
+			}
+			controller.handleFreeze(new)
 		},
+		DeleteFunc: controller.handleFreeze,
 	})
 	// Set up an event handler for when Deployment resources change. This
 	// handler will lookup the owner of the given Deployment, and if it is
@@ -134,16 +435,116 @@ func NewController(
 			}
 			controller.handleObject(new)
 		},
+		// deleted routers are put back ahead of routine syncs
+		DeleteFunc: controller.handleDeletedObject,
+	})
+	// The StatefulSets of routers in StatefulSet mode are handled the same way.
+	statefulSetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			if new.(*appsv1.StatefulSet).ResourceVersion == old.(*appsv1.StatefulSet).ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleDeletedObject,
+	})
+	// The budgets and autoscalers of router pods are put back the same way
+	// when changed or deleted by hand.
+	podDisruptionBudgetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			newPDB := new.(*policyv1beta1.PodDisruptionBudget)
+			oldPDB := old.(*policyv1beta1.PodDisruptionBudget)
+			if newPDB.ResourceVersion == oldPDB.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
 		DeleteFunc: controller.handleObject,
 	})
+	horizontalPodAutoscalerInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			newHPA := new.(*autoscalingv2beta2.HorizontalPodAutoscaler)
+			oldHPA := old.(*autoscalingv2beta2.HorizontalPodAutoscaler)
+			if newHPA.ResourceVersion == oldHPA.ResourceVersion || reflect.DeepEqual(newHPA.Spec, oldHPA.Spec) {
+				// the autoscaler writes its status every time it scales
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+	// Router pods decide the active node reported in the status, so changes
+	// to them are handled the same way as Deployment changes. Router pods
+	// failing fail the router over ahead of routine syncs.
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handlePod,
+		UpdateFunc: func(old, new interface{}) {
+			newPod := new.(*corev1.Pod)
+			oldPod := old.(*corev1.Pod)
+			if newPod.ResourceVersion == oldPod.ResourceVersion {
+				return
+			}
+			if podFailed(oldPod, newPod) {
+				controller.handleFailedPod(new)
+				return
+			}
+			controller.handlePod(new)
+		},
+		DeleteFunc: controller.handleFailedPod,
+	})
+	// Router pods of nodes going not ready are force deleted once the node
+	// stays so for the grace period.
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: controller.handleNode,
+	})
+	controller.ruleInformers = dynamicinformer.NewDynamicSharedInformerFactory(dynamicclient, 0)
+	natRuleInformer := controller.ruleInformers.ForResource(natRuleResource)
+	controller.natRulesLister = natRuleInformer.Lister()
+	controller.natRulesSynced = natRuleInformer.Informer().HasSynced
+	firewallRuleInformer := controller.ruleInformers.ForResource(firewallRuleResource)
+	controller.firewallRulesLister = firewallRuleInformer.Lister()
+	controller.firewallRulesSynced = firewallRuleInformer.Informer().HasSynced
+	if features.Enabled(features.LoadBalancerBackendServices) {
+		controller.endpointSlicesLister = endpointSliceInformer.Lister()
+		controller.endpointSlicesSynced = endpointSliceInformer.Informer().HasSynced
+		loadBalancerRuleInformer := controller.ruleInformers.ForResource(loadBalancerRuleResource)
+		controller.loadBalancerRulesLister = loadBalancerRuleInformer.Lister()
+		controller.loadBalancerRulesSynced = loadBalancerRuleInformer.Informer().HasSynced
+		// LoadBalancerRules following a Service converge as its endpoints
+		// change
+		endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.handleEndpointSlice,
+			UpdateFunc: func(old, new interface{}) {
+				newSlice := new.(*discoveryv1beta1.EndpointSlice)
+				oldSlice := old.(*discoveryv1beta1.EndpointSlice)
+				if newSlice.ResourceVersion == oldSlice.ResourceVersion {
+					return
+				}
+				controller.handleEndpointSlice(new)
+			},
+			DeleteFunc: controller.handleEndpointSlice,
+		})
+	}
+	if options.ServiceLoadBalancers {
+		serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.handleService,
+			UpdateFunc: func(old, new interface{}) {
+				controller.handleService(old)
+				controller.handleService(new)
+			},
+			DeleteFunc: controller.handleService,
+		})
+	}
 
 	return controller
 }
 
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
-// is closed, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
+// is closed, at which point it will shutdown the workqueue, wait for workers
+// to finish processing their current work items up to the drain timeout, and
+// persist the keys left pending for the next start.
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
@@ -153,19 +554,34 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.virtualRoutersSynced); !ok {
+	synced := []cache.InformerSynced{c.deploymentsSynced, c.statefulSetsSynced, c.podDisruptionBudgetsSynced, c.horizontalPodAutoscalersSynced, c.podsSynced, c.nodesSynced, c.servicesSynced, c.virtualRoutersSynced, c.virtualRouterProfilesSynced, c.networkFreezesSynced}
+	c.ruleInformers.Start(stopCh)
+	synced = append(synced, c.natRulesSynced, c.firewallRulesSynced)
+	if c.loadBalancerRulesLister != nil {
+		synced = append(synced, c.endpointSlicesSynced, c.loadBalancerRulesSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	c.restorePendingKeys(threadiness, stopCh)
+	go c.runStatusWriter(stopCh)
+
 	klog.Info("Starting workers")
 	// Launch two workers to process VirtualRouter resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
 	}
 
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
+	c.drain(&workers)
 
 	return nil
 }
@@ -174,7 +590,8 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
 func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+	// the keys still queued on shutdown are persisted rather than synced
+	for !c.workqueue.ShuttingDown() && c.processNextWorkItem() {
 	}
 }
 
@@ -196,6 +613,7 @@ func (c *Controller) processNextWorkItem() bool {
 		// put back on the workqueue and attempted again after a back-off
 		// period.
 		defer c.workqueue.Done(obj)
+		version := c.workqueue.version(obj)
 		var key string
 		var ok bool
 		// We expect strings to come off the workqueue. These are of the
@@ -208,6 +626,7 @@ func (c *Controller) processNextWorkItem() bool {
 			// Forget here else we'd go into a loop of attempting to
 			// process a work item that is invalid.
 			c.workqueue.Forget(obj)
+			c.workqueue.synced(obj, version)
 			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
@@ -221,6 +640,7 @@ func (c *Controller) processNextWorkItem() bool {
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
 		c.workqueue.Forget(obj)
+		c.workqueue.synced(obj, version)
 		klog.Infof("Successfully synced '%s'", key)
 		return nil
 	}(obj)
@@ -236,7 +656,7 @@ func (c *Controller) processNextWorkItem() bool {
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the VirtualRouter resource
 // with the current status of the resource.
-func (c *Controller) syncHandler(key string) error {
+func (c *Controller) syncHandler(key string) (err error) {
 	klog.Info(key)
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
@@ -252,12 +672,38 @@ func (c *Controller) syncHandler(key string) error {
 		// processing.
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("virtualRouter '%s' in work queue no longer exists", key))
+			c.dryRunPlans.forget(key)
+			forgetProvisioningTiming(namespace, name)
+			forgetFailovers(namespace, name)
+			firewallRuleHits.set(key, nil)
+			c.backendServices.set(key, nil)
+			c.macAddresses.forget(key)
 			return nil
 		}
 
 		return err
 	}
 
+	// the controller of its class may have taken it over since it was queued
+	if !c.handlesClass(virtualRouter) {
+		return nil
+	}
+
+	// a deleted VirtualRouter is cleaned up even if paused
+	if IsPaused(virtualRouter) && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.syncPaused(virtualRouter)
+	}
+
+	if c.dryRunPlan == nil && (c.options.DryRun || IsDryRun(virtualRouter)) {
+		return c.syncDryRun(key, virtualRouter)
+	}
+
+	// A change made under a trace is reconciled as part of it.
+	ctx, span := tracing.Start(virtualRouter, virtualRouter.Status.ObservedGeneration >= virtualRouter.Generation, "VirtualRouter sync",
+		attribute.String("virtualrouter", key), attribute.Int64("generation", virtualRouter.Generation))
+	defer func() { tracing.End(span, err) }()
+	timer := newReconcileTimer(c.clock)
+
 	deploymentName := virtualRouter.Spec.DeploymentName
 	if deploymentName == "" {
 		// We choose to absorb the error here as the worker would requeue the
@@ -267,168 +713,839 @@ func (c *Controller) syncHandler(key string) error {
 		return nil
 	}
 
-	// create deployment with new Namespace same as virtualrouter resource name
-	newNS := virtualRouter.Name
-	if err := c.ensureVirtualRouterNamespace(newNS, virtualRouter); err != nil {
+	virtualRouter, err = c.pinRouterNamespace(virtualRouter)
+	if err != nil {
 		klog.Error(err)
 		return err
 	}
 
-	if err := c.ensureVirtualRouterSA(newNS, virtualRouter); err != nil {
+	// VirtualRouters managing the same router resources would take them
+	// from each other on every sync
+	claimant, err := c.deploymentClaimant(virtualRouter)
+	if err != nil {
 		klog.Error(err)
 		return err
 	}
+	if claimant != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportDeploymentNameConflict(virtualRouter, claimant)
+	}
+
+	// the router is checked as it is rendered, with the defaults of its
+	// profile
+	profile, err := routerProfile(c.virtualRouterProfilesLister, virtualRouter)
+	if err != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportInvalidSpec(virtualRouter, err)
+	}
+	profiled := ApplyProfile(virtualRouter, profile)
+	if err := ValidateSpec(profiled.Spec); err != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportInvalidSpec(virtualRouter, err)
+	}
+	if err := ValidatePodTemplateOverrides(profiled, c.sidecarProfiles()); err != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportInvalidSpec(virtualRouter, err)
+	}
+	if _, err := c.sidecarContainers(virtualRouter); err != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportInvalidSpec(virtualRouter, err)
+	}
+	if _, err := c.macPrefix(virtualRouter); err != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		return c.reportInvalidSpec(virtualRouter, err)
+	}
+
+	// routers are never rolled onto an image the image policy rejects
+	if virtualRouter.DeletionTimestamp.IsZero() {
+		if err := c.checkImagePolicy(profiled); err != nil {
+			if policyErr, ok := err.(*imagePolicyError); ok {
+				return c.reportImagePolicy(virtualRouter, policyErr)
+			}
+			return err
+		}
+	}
 
-	if err := c.ensureVirtualRouterRole(newNS, virtualRouter); err != nil {
+	var allocated *samplev1alpha1.VirtualRouter
+	err = timer.trace(ctx, PHASE_EXTERNAL_IP, "ensureIPAMAllocation", func() (err error) {
+		allocated, err = c.ensureIPAMAllocation(virtualRouter)
+		return err
+	})
+	if err != nil {
 		klog.Error(err)
 		return err
 	}
+	if !allocated.DeletionTimestamp.IsZero() && hasFinalizer(virtualRouter, VIRTUALROUTER_IPAM_FINALIZER) && !hasFinalizer(allocated, VIRTUALROUTER_IPAM_FINALIZER) {
+		// the external IP of the deleted VirtualRouter is released, and
+		// with its finalizer removed it may already be gone
+		return nil
+	}
+	virtualRouter = allocated
+	if virtualRouter.DeletionTimestamp.IsZero() {
+		if virtualRouter, err = c.ensureMACAddresses(virtualRouter); err != nil {
+			klog.Error(err)
+			return err
+		}
+	}
 
-	if err := c.ensureVirtualRouterRoleBinding(newNS, virtualRouter); err != nil {
+	// the ClusterRoleBinding of the router is its own, whoever holds the
+	// router resources
+	var bound *samplev1alpha1.VirtualRouter
+	err = timer.trace(ctx, PHASE_RBAC, "ensureRouterClusterRoleBinding", func() (err error) {
+		bound, err = c.ensureRouterClusterRoleBinding(RouterNamespace(virtualRouter), virtualRouter)
+		return err
+	})
+	if err != nil {
 		klog.Error(err)
 		return err
 	}
+	if !bound.DeletionTimestamp.IsZero() && hasFinalizer(virtualRouter, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER) && !hasFinalizer(bound, VIRTUALROUTER_CLUSTER_ROLE_FINALIZER) {
+		// with its finalizer removed the deleted VirtualRouter may be gone
+		return nil
+	}
+	if claimant != nil {
+		// the router resources of the deleted VirtualRouter are another's
+		return nil
+	}
+	virtualRouter = bound
 
-	// Get the deployment with the name specified in VirtualRouter.spec
-	deployment, err := c.deploymentsLister.Deployments(newNS).Get(deploymentName)
-	// If the resource doesn't exist, we'll create it
-	if errors.IsNotFound(err) {
-		klog.Info("NotFound Deploy start")
+	// create deployment with new Namespace same as virtualrouter resource name,
+	// or in the namespace of the VirtualRouter for tenant placement
+	newNS := RouterNamespace(virtualRouter)
+	if !isTenantPlacement(virtualRouter) {
+		if err := timer.trace(ctx, PHASE_NAMESPACE, "ensureVirtualRouterNamespace", func() error {
+			return c.ensureVirtualRouterNamespace(newNS, virtualRouter)
+		}); err != nil {
+			if isNamespaceTerminating(err) {
+				return c.waitForNamespace(key, newNS, virtualRouter)
+			}
+			if _, ok := err.(*namespaceConflictError); ok {
+				return c.reportNamespaceConflict(newNS, virtualRouter)
+			}
+			klog.Error(err)
+			return err
+		}
+	}
+	c.namespaceBackoff.Forget(key)
+
+	if isTenantPlacement(virtualRouter) && virtualRouter.DeletionTimestamp.IsZero() {
+		if err := c.checkPodSecurity(newNS, c.profiledRouter(virtualRouter)); err != nil {
+			if securityErr, ok := err.(*podSecurityError); ok {
+				return c.reportPodSecurity(virtualRouter, securityErr)
+			}
+			klog.Error(err)
+			return err
+		}
+	}
 
-		deployment, err = c.kubeclientset.AppsV1().Deployments(newNS).Create(context.TODO(), newDeployment(newNS, virtualRouter), metav1.CreateOptions{})
+	// The objects of the router don't depend on each other once the namespace
+	// exists, so they are created at once rather than a round trip after
+	// another. Router pods are never rolled onto credentials that don't exist,
+	// as the Deployment is only created after all of them.
+	var childObjectsErr *childObjectsError
+	timer.trace(ctx, PHASE_RBAC, "ensureChildObjects", func() error {
+		childObjectsErr = c.ensureAll(ctx, []ensureStep{
+			{"ServiceAccount", "ensureVirtualRouterSA", func() error {
+				return c.ensureVirtualRouterSA(newNS, virtualRouter)
+			}},
+			{"Role", "ensureVirtualRouterRole", func() error {
+				return c.ensureVirtualRouterRole(newNS, virtualRouter)
+			}},
+			{"RoleBinding", "ensureVirtualRouterRoleBinding", func() error {
+				return c.ensureVirtualRouterRoleBinding(newNS, virtualRouter)
+			}},
+			{"image pull Secrets", "ensureImagePullSecrets", func() error {
+				return c.ensureImagePullSecrets(newNS, virtualRouter)
+			}},
+			{"router Secrets", "ensureRouterSecrets", func() error {
+				return c.ensureRouterSecrets(newNS, virtualRouter)
+			}},
+			{"PersistentVolumeClaim", "ensureStateClaim", func() error {
+				return c.ensureStateClaim(newNS, virtualRouter)
+			}},
+		})
+		if childObjectsErr == nil {
+			return nil
+		}
+		return childObjectsErr
+	})
+	if childObjectsErr != nil {
+		return c.reportChildObjects(virtualRouter, childObjectsErr)
 	}
 
-	// If an error occurs during Get/Create, we'll requeue the item so we can
-	// attempt processing again later. This could have been caused by a
-	// temporary network failure, or any other transient reason.
+	// restored WireGuard keys are kept rather than generated anew
+	err = timer.trace(ctx, PHASE_RULES, "restoreBackup", func() (err error) {
+		virtualRouter, err = c.restoreBackup(newNS, virtualRouter)
+		return err
+	})
 	if err != nil {
+		klog.Error(err)
 		return err
 	}
 
-	// If the Deployment is not controlled by this VirtualRouter resource, we should log
-	// a warning to the event recorder and return error msg.
-	if !metav1.IsControlledBy(deployment, virtualRouter) {
-		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
-		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf(msg)
+	var wireGuardPublicKey string
+	err = timer.trace(ctx, PHASE_RBAC, "ensureWireGuardKeys", func() (err error) {
+		wireGuardPublicKey, err = c.ensureWireGuardKeys(newNS, virtualRouter)
+		return err
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	// changes disrupting the router wait for its maintenance window, and
+	// every change to its data plane for a freeze to be lifted
+	gate := c.disruptionGate(virtualRouter)
+
+	if err := timer.trace(ctx, PHASE_RULES, "ensureManagementFirewallRule", func() error {
+		return c.ensureManagementFirewallRule(newNS, virtualRouter)
+	}); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := timer.trace(ctx, PHASE_RULES, "ensurePortForwards", func() error {
+		return c.ensurePortForwards(newNS, virtualRouter, gate)
+	}); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := timer.trace(ctx, PHASE_RULES, "ensureInterNetworkRules", func() error {
+		return c.ensureInterNetworkRules(newNS, virtualRouter, gate)
+	}); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	if err := timer.trace(ctx, PHASE_RULES, "ensureLoadBalancerBackends", func() error {
+		return c.ensureLoadBalancerBackends(key, newNS, gate)
+	}); err != nil {
+		klog.Error(err)
+		return err
 	}
 
-	// If this number of the replicas on the VirtualRouter resource is specified, and the
-	// number does not equal the current desired replicas on the Deployment, we
-	// should update the Deployment resource.
-	if virtualRouter.Spec.Replicas != nil && *virtualRouter.Spec.Replicas != *deployment.Spec.Replicas {
-		klog.V(4).Infof("VirtualRouter %s replicas: %d, deployment replicas: %d", name, *virtualRouter.Spec.Replicas, *deployment.Spec.Replicas)
-		deployment, err = c.kubeclientset.AppsV1().Deployments(newNS).Update(context.TODO(), newDeployment(newNS, virtualRouter), metav1.UpdateOptions{})
+	if err := timer.trace(ctx, PHASE_RULES, "ensureServiceLoadBalancers", func() error {
+		return c.ensureServiceLoadBalancers(newNS, virtualRouter, gate)
+	}); err != nil {
+		klog.Error(err)
+		return err
 	}
 
-	// If an error occurs during Update, we'll requeue the item so we can
-	// attempt processing again later. This could have been caused by a
-	// temporary network failure, or any other transient reason.
+	var ruleExpirations []samplev1alpha1.RuleExpiration
+	var invalidRuleExpiries []samplev1alpha1.InvalidRuleExpiry
+	err = timer.trace(ctx, PHASE_RULES, "expireRules", func() (err error) {
+		ruleExpirations, invalidRuleExpiries, err = c.expireRules(newNS, virtualRouter, gate)
+		return err
+	})
 	if err != nil {
+		klog.Error(err)
 		return err
 	}
+	// rules expire once a freeze is lifted, which requeues the router
+	if next := c.nextRuleExpiryCheck(ruleExpirations); next > 0 && !gate.isFrozen() {
+		c.workqueue.AddAfter(key, next)
+	}
 
-	// Finally, we update the status block of the VirtualRouter resource to reflect the
-	// current state of the world
-	err = c.updateVirtualRouterStatus(virtualRouter, deployment)
+	if err := timer.trace(ctx, PHASE_RULES, "ensureBackup", func() error {
+		return c.ensureBackup(newNS, virtualRouter)
+	}); err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	// The decision about the external IP is kept in the status, which the
+	// daemon reads to only assign approved IPs.
+	var approval *samplev1alpha1.ExternalIPApproval
+	err = timer.trace(ctx, PHASE_EXTERNAL_IP, "externalIPApproval", func() (err error) {
+		approval, err = c.externalIPApproval(virtualRouter)
+		return err
+	})
 	if err != nil {
+		klog.Error(err)
 		return err
 	}
+	virtualRouter = virtualRouter.DeepCopy()
+	for _, conditionType := range []string{samplev1alpha1.NamespaceTerminatingCondition, samplev1alpha1.NamespaceConflictCondition, samplev1alpha1.DeploymentNameConflictCondition, samplev1alpha1.InvalidSpecCondition, samplev1alpha1.DegradedCondition} {
+		if meta.FindStatusCondition(virtualRouter.Status.Conditions, conditionType) != nil {
+			// RemoveStatusCondition can't be given an empty list
+			meta.RemoveStatusCondition(&virtualRouter.Status.Conditions, conditionType)
+		}
+	}
+	c.setResumed(virtualRouter)
+	virtualRouter.Status.ExternalIPApproval = approval
+	virtualRouter.Status.RuleExpirations = ruleExpirations
+	virtualRouter.Status.InvalidRuleExpiries = invalidRuleExpiries
+	if wireGuardPublicKey == "" {
+		virtualRouter.Status.WireGuard = nil
+	} else if virtualRouter.Status.WireGuard == nil || virtualRouter.Status.WireGuard.PublicKey != wireGuardPublicKey {
+		virtualRouter.Status.WireGuard = &samplev1alpha1.WireGuardStatus{PublicKey: wireGuardPublicKey}
+	}
+	if approval != nil && approval.Decision == samplev1alpha1.ExternalIPPending {
+		c.workqueue.AddAfter(key, EXTERNAL_IP_APPROVAL_RETRY_INTERVAL)
+	}
 
-	c.recorder.Event(virtualRouter, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
-	return nil
-}
+	var configChecksum string
+	err = timer.trace(ctx, PHASE_RULES, "ensureRouterConfig", func() (err error) {
+		configChecksum, err = c.ensureRouterConfig(newNS, virtualRouter, gate)
+		return err
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
 
-func (c *Controller) updateVirtualRouterStatus(virtualRouter *samplev1alpha1.VirtualRouter, deployment *appsv1.Deployment) error {
-	// NEVER modify objects from the store. It's a read-only, local cache.
-	// You can use DeepCopy() to make a deep copy of original object and modify this copy
-	// Or create a copy manually for better performance
-	virtualRouterCopy := virtualRouter.DeepCopy()
-	virtualRouterCopy.Status.AvailableReplicas = deployment.Status.AvailableReplicas
-	// If the CustomResourceSubresources feature gate is not enabled,
-	// we must use Update instead of UpdateStatus to update the Status block of the VirtualRouter resource.
-	// UpdateStatus will not allow changes to the Spec of the resource,
-	// which is ideal for ensuring nothing other than resource status has been updated.
-	_, err := c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(context.TODO(), virtualRouterCopy, metav1.UpdateOptions{})
-	return err
-}
+	var dhcpChecksum string
+	err = timer.trace(ctx, PHASE_RULES, "ensureDHCPConfig", func() (err error) {
+		dhcpChecksum, err = c.ensureDHCPConfig(newNS, virtualRouter, gate)
+		return err
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
 
-// enqueueVirtualRouter takes a VirtualRouter resource and converts it into a namespace/name
-// string which is then put onto the work queue. This method should *not* be
-// passed resources of any type other than VirtualRouter.
-func (c *Controller) enqueueVirtualRouter(obj interface{}) {
-	var key string
-	var err error
-	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-		utilruntime.HandleError(err)
-		return
+	var dnsChecksum string
+	err = timer.trace(ctx, PHASE_RULES, "ensureDNSConfig", func() (err error) {
+		dnsChecksum, err = c.ensureDNSConfig(newNS, virtualRouter, gate)
+		return err
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	checksums := map[string]string{
+		CONFIG_CHECKSUM_ANNOTATION: configChecksum,
+		DHCP_CHECKSUM_ANNOTATION:   dhcpChecksum,
+		DNS_CHECKSUM_ANNOTATION:    dnsChecksum,
 	}
-	c.workqueue.Add(key)
-}
 
-// handleObject will take any resource implementing metav1.Object and attempt
-// to find the VirtualRouter resource that 'owns' it. It does this by looking at the
-// objects metadata.ownerReferences field for an appropriate OwnerReference.
-// It then enqueues that VirtualRouter resource to be processed. If the object does not
-// have an appropriate OwnerReference, it will simply be skipped.
-func (c *Controller) handleObject(obj interface{}) {
-	var object metav1.Object
-	var ok bool
-	if object, ok = obj.(metav1.Object); !ok {
-		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-		if !ok {
-			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
-			return
+	// In StatefulSet mode the StatefulSet is read as the Deployment from
+	// here on, as statefulSetView.
+	var deployment *appsv1.Deployment
+	if usesStatefulSet(virtualRouter) {
+		err = timer.trace(ctx, PHASE_DEPLOYMENT, "ensureStatefulSet", func() (err error) {
+			deployment, err = c.ensureStatefulSet(newNS, virtualRouter, checksums, gate)
+			return err
+		})
+		if isNamespaceTerminating(err) {
+			return c.waitForNamespace(key, newNS, virtualRouter)
 		}
-		object, ok = tombstone.Obj.(metav1.Object)
-		if !ok {
-			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
-			return
+		if err != nil {
+			return err
 		}
-		klog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
-	}
-	klog.V(4).Infof("Processing object: %s", object.GetName())
-	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
-		// If this object is not owned by a VirtualRouter, we should not do anything more
-		// with it.
-		if ownerRef.Kind != "VirtualRouter" {
-			return
+		if deployment == nil {
+			// A new router isn't started before its external IP is approved
+			c.setPendingChanges(key, virtualRouter, gate)
+			virtualRouter.Status.ReconcileTiming = timer.timing()
+			return c.updateVirtualRouterStatus(virtualRouter, nil)
+		}
+	} else {
+		// Get the deployment with the name specified in VirtualRouter.spec
+		deployment, err = c.deploymentsLister.Deployments(newNS).Get(deploymentName)
+		// If the resource doesn't exist, we'll create it
+		if errors.IsNotFound(err) {
+			// A new router isn't started before its external IP is approved
+			if !isExternalIPApproved(virtualRouter) {
+				c.setPendingChanges(key, virtualRouter, gate)
+				virtualRouter.Status.ReconcileTiming = timer.timing()
+				return c.updateVirtualRouterStatus(virtualRouter, nil)
+			}
+			klog.Info("NotFound Deploy start")
+
+			err = timer.trace(ctx, PHASE_DEPLOYMENT, "createDeployment", func() (err error) {
+				deployment, err = c.kubeclientset.AppsV1().Deployments(newNS).Create(c.ctx, c.desiredDeployment(newNS, virtualRouter, checksums), metav1.CreateOptions{})
+				if errors.IsAlreadyExists(err) {
+					// only labelled Deployments are cached
+					deployment, err = c.adoptDeployment(newNS, deploymentName, virtualRouter)
+				}
+				return err
+			})
+			if isNamespaceTerminating(err) {
+				// the namespace was deleted after it was checked
+				return c.waitForNamespace(key, newNS, virtualRouter)
+			}
 		}
 
-		virtualRouter, err := c.virtualRoutersLister.VirtualRouters(object.GetNamespace()).Get(ownerRef.Name)
+		// If an error occurs during Get/Create, we'll requeue the item so we can
+		// attempt processing again later. This could have been caused by a
+		// temporary network failure, or any other transient reason.
 		if err != nil {
-			klog.V(4).Infof("ignoring orphaned object '%s' of virtualRouter '%s'", object.GetSelfLink(), ownerRef.Name)
-			return
+			return err
 		}
 
-		c.enqueueVirtualRouter(virtualRouter)
-		return
-	}
-}
+		// If the Deployment is not controlled by this VirtualRouter resource, we should log
+		// a warning to the event recorder and return error msg.
+		if !metav1.IsControlledBy(deployment, virtualRouter) {
+			msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
+			c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+			return fmt.Errorf(msg)
+		}
 
-// newDeployment creates a new Deployment for a VirtualRouter resource. It also sets
-// the appropriate OwnerReferences on the resource so handleObject can discover
-// the VirtualRouter resource that 'owns' it.
-func newDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *appsv1.Deployment {
-	labels := map[string]string{
-		"app": VIRTUALROUTER_LABEL,
-	}
-	nodeSelectorMap := make(map[string]string)
-	for _, nodeSelector := range virtualRouter.Spec.NodeSelector {
-		nodeSelectorMap[nodeSelector.Key] = nodeSelector.Value
+		// The API server defaults everything left empty in the Deployment, so it
+		// can't be compared with what the controller renders. The hash of the
+		// rendered spec is compared instead, along with the live fields edited
+		// by hand, and any change of either updates the Deployment, which
+		// rolls out router pods as the upgrade strategy says.
+		desired := c.desiredDeployment(newNS, virtualRouter, checksums)
+		hash := deployment.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION]
+		autoscaled := virtualRouter.Spec.Autoscaling != nil
+		templateDrifted := podTemplateDrifted(&deployment.Spec.Template, &desired.Spec.Template)
+		replicasDrifted := !autoscaled && !equalReplicas(deployment.Spec.Replicas, desired.Spec.Replicas)
+		changed := hash != desired.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] || templateDrifted || replicasDrifted
+		scales := !templateDrifted && (hash == desired.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] || scalesOnly(hash, deployment.Spec.Replicas, desired.Spec))
+		if changed && gate.holdsWorkload(scales) {
+			klog.V(4).Infof("VirtualRouter %s spec differs from deployment %s, deferred", name, deployment.Name)
+		} else if changed {
+			klog.V(4).Infof("VirtualRouter %s spec differs from deployment %s, updating", name, deployment.Name)
+			if virtualRouter.Spec.Autoscaling != nil {
+				// the replicas are the autoscaler's
+				desired.Spec.Replicas = deployment.Spec.Replicas
+			}
+			err = timer.trace(ctx, PHASE_DEPLOYMENT, "updateDeployment", func() (err error) {
+				deployment, err = c.kubeclientset.AppsV1().Deployments(newNS).Update(c.ctx, desired, metav1.UpdateOptions{})
+				return err
+			})
+		}
+
+		// If an error occurs during Update, we'll requeue the item so we can
+		// attempt processing again later. This could have been caused by a
+		// temporary network failure, or any other transient reason.
+		if err != nil {
+			return err
+		}
 	}
-	// var uuid = uuid.Must(uuid.NewRandom())
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      virtualRouter.Spec.DeploymentName,
-			Namespace: newNS,
+
+	err = timer.trace(ctx, PHASE_DEPLOYMENT, "deleteReplacedWorkload", func() error {
+		return c.deleteReplacedWorkload(newNS, virtualRouter, deployment)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	// the budget follows the replicas, which a freeze holds back
+	if !gate.isFrozen() {
+		err = timer.trace(ctx, PHASE_DEPLOYMENT, "ensurePodDisruptionBudget", func() error {
+			return c.ensurePodDisruptionBudget(deployment, virtualRouter)
+		})
+		if err != nil {
+			klog.Error(err)
+			return err
+		}
+	}
+
+	err = timer.trace(ctx, PHASE_DEPLOYMENT, "ensureHorizontalPodAutoscaler", func() error {
+		return c.ensureHorizontalPodAutoscaler(deployment, virtualRouter)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	err = timer.trace(ctx, PHASE_DEPLOYMENT, "ensureMonitoring", func() error {
+		return c.ensureMonitoring(newNS, virtualRouter)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	err = timer.trace(ctx, PHASE_RULES, "reportFirewallRuleHits", func() error {
+		pods, err := c.routerPods(deployment)
+		if err != nil {
+			return err
+		}
+		return c.reportFirewallRuleHits(key, newNS, virtualRouter, pods)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	err = timer.trace(ctx, PHASE_DEPLOYMENT, "failoverFailedNodes", func() error {
+		pods, err := c.routerPods(deployment)
+		if err != nil {
+			return err
+		}
+		return c.failoverFailedNodes(key, newNS, virtualRouter, pods)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	err = timer.trace(ctx, PHASE_DEPLOYMENT, "failoverDataPlane", func() error {
+		pods, err := c.routerPods(deployment)
+		if err != nil {
+			return err
+		}
+		return c.failoverDataPlane(newNS, virtualRouter, pods)
+	})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	c.setPendingChanges(key, virtualRouter, gate)
+
+	// Finally, we update the status block of the VirtualRouter resource to reflect the
+	// current state of the world
+	virtualRouter.Status.ReconcileTiming = timer.timing()
+	return timer.trace(ctx, PHASE_STATUS, "updateVirtualRouterStatus", func() error {
+		return c.updateVirtualRouterStatus(virtualRouter, deployment)
+	})
+}
+
+func (c *Controller) updateVirtualRouterStatus(virtualRouter *samplev1alpha1.VirtualRouter, deployment *appsv1.Deployment) error {
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	// You can use DeepCopy() to make a deep copy of original object and modify this copy
+	// Or create a copy manually for better performance
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	virtualRouterCopy.Status.AvailableReplicas = 0
+	virtualRouterCopy.Status.UpdatedReplicas = 0
+	virtualRouterCopy.Status.ActiveNode = ""
+	var pods []*corev1.Pod
+	if deployment != nil {
+		virtualRouterCopy.Status.AvailableReplicas = deployment.Status.AvailableReplicas
+		virtualRouterCopy.Status.UpdatedReplicas = deployment.Status.UpdatedReplicas
+		var err error
+		pods, err = c.routerPods(deployment)
+		if err != nil {
+			return err
+		}
+		virtualRouterCopy.Status.ActiveNode = activeNode(pods)
+		if timing := virtualRouterCopy.Status.ReconcileTiming; timing != nil {
+			addPodTiming(timing, pods)
+		}
+		if len(pods) > 0 {
+			meta.SetStatusCondition(&virtualRouterCopy.Status.Conditions, configAppliedCondition(virtualRouter, pods, c.clock.Now()))
+			virtualRouterCopy.Status.AppliedRevision = appliedRevision(pods)
+		}
+		if condition := dnsHealthyCondition(virtualRouter, pods, c.clock.Now()); condition != nil {
+			meta.SetStatusCondition(&virtualRouterCopy.Status.Conditions, *condition)
+		}
+		virtualRouterCopy.Status.WireGuard = wireGuardStatus(virtualRouter, pods)
+	}
+	setDataPlaneHealthyCondition(&virtualRouterCopy.Status, virtualRouter, pods, c.clock.Now())
+	if virtualRouter.Spec.DNS == nil && meta.FindStatusCondition(virtualRouterCopy.Status.Conditions, samplev1alpha1.DNSHealthyCondition) != nil {
+		meta.RemoveStatusCondition(&virtualRouterCopy.Status.Conditions, samplev1alpha1.DNSHealthyCondition)
+	}
+	virtualRouterCopy.Status.ObservedGeneration = virtualRouter.Generation
+	virtualRouterCopy.Status.Phase = virtualRouterPhase(virtualRouter, deployment)
+	if virtualRouterCopy.Status.Phase == samplev1alpha1.VirtualRouterRunning && activeDataPlaneUnhealthy(pods) {
+		// the router pods run, but the active one doesn't forward
+		virtualRouterCopy.Status.Phase = samplev1alpha1.VirtualRouterDegraded
+	}
+	externalIP := effectiveExternalIP(virtualRouter)
+	virtualRouterCopy.Status.ExternalIPs = nil
+	if externalIP != "" {
+		virtualRouterCopy.Status.ExternalIPs = []string{externalIP}
+	}
+	if ip, _, err := net.ParseCIDR(virtualRouter.Spec.ExternalIPv6CIDR); err == nil {
+		virtualRouterCopy.Status.ExternalIPs = append(virtualRouterCopy.Status.ExternalIPs, ip.String())
+	}
+
+	// The status is compared with the VirtualRouter last seen, as the given
+	// one carries status fields decided in this sync but not written yet.
+	original, err := c.virtualRoutersLister.VirtualRouters(virtualRouter.Namespace).Get(virtualRouter.Name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	// The timing differs on every sync, so it is only reported along with
+	// other changes, or the heartbeat.
+	timing := virtualRouterCopy.Status.ReconcileTiming
+	virtualRouterCopy.Status.ReconcileTiming = original.Status.ReconcileTiming
+	virtualRouterCopy.Status.LastReconcileTime = original.Status.LastReconcileTime
+	if last := original.Status.LastReconcileTime; last == nil || c.clock.Since(last.Time) >= STATUS_HEARTBEAT_INTERVAL || !reflect.DeepEqual(virtualRouterCopy.Status, original.Status) {
+		now := metav1.NewTime(c.clock.Now())
+		virtualRouterCopy.Status.LastReconcileTime = &now
+		if timing != nil {
+			virtualRouterCopy.Status.ReconcileTiming = timing
+		}
+	}
+
+	if c.batchStatus() {
+		if !holdsAllocations(original.Status, virtualRouterCopy.Status) {
+			c.statusWriter.queue(virtualRouter, original.Status, virtualRouterCopy.Status)
+			return nil
+		}
+		// the status written carries any pending changes along
+		c.statusWriter.forget(virtualRouter)
+	}
+	return c.writeStatus(virtualRouter, original.Status, virtualRouterCopy.Status)
+}
+
+// effectiveExternalIP returns the external IP assigned to the router: the
+// given or allocated one, or while approval is required the approved one.
+func effectiveExternalIP(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	externalIP := virtualRouter.Spec.ExternalIP
+	if allocation := virtualRouter.Status.IPAMAllocation; externalIP == "" && allocation != nil {
+		externalIP = strings.Split(allocation.Address, "/")[0]
+	}
+	if approval := virtualRouter.Status.ExternalIPApproval; approval != nil {
+		externalIP = approval.ApprovedIP
+	}
+	return externalIP
+}
+
+// virtualRouterPhase summarizes the state of the router Deployment into a
+// single phase for the VirtualRouter status.
+func virtualRouterPhase(virtualRouter *samplev1alpha1.VirtualRouter, deployment *appsv1.Deployment) samplev1alpha1.VirtualRouterPhase {
+	if !virtualRouter.DeletionTimestamp.IsZero() {
+		return samplev1alpha1.VirtualRouterTerminating
+	}
+	if deployment == nil {
+		return samplev1alpha1.VirtualRouterPending
+	}
+	available := deployment.Status.AvailableReplicas
+	if available == 0 {
+		return samplev1alpha1.VirtualRouterPending
+	}
+	// a rollout is in progress while the Deployment controller hasn't seen the
+	// latest template, or pods of an older template remain
+	if deployment.Generation > deployment.Status.ObservedGeneration || deployment.Status.Replicas > deployment.Status.UpdatedReplicas {
+		return samplev1alpha1.VirtualRouterUpgrading
+	}
+	if deployment.Spec.Replicas != nil && available < *deployment.Spec.Replicas {
+		return samplev1alpha1.VirtualRouterDegraded
+	}
+	return samplev1alpha1.VirtualRouterRunning
+}
+
+// activeNode returns the node of the longest running ready router pod, or of
+// the ready one of the lowest ordinal for a StatefulSet, or an empty string if
+// no router pod is ready.
+func activeNode(pods []*corev1.Pod) string {
+	var readyPods []*corev1.Pod
+	for _, pod := range pods {
+		if podutil.IsPodReady(pod) {
+			readyPods = append(readyPods, pod)
+		}
+	}
+	if len(readyPods) == 0 {
+		return ""
+	}
+	sort.Slice(readyPods, func(i, j int) bool {
+		ordinalI, okI := podOrdinal(readyPods[i])
+		ordinalJ, okJ := podOrdinal(readyPods[j])
+		if okI && okJ && ordinalI != ordinalJ {
+			return ordinalI < ordinalJ
+		}
+		if readyPods[i].CreationTimestamp.Equal(&readyPods[j].CreationTimestamp) {
+			return readyPods[i].Name < readyPods[j].Name
+		}
+		return readyPods[i].CreationTimestamp.Before(&readyPods[j].CreationTimestamp)
+	})
+	return readyPods[0].Spec.NodeName
+}
+
+// routerPods returns the pods of the router Deployment scheduled to a node
+// and not being deleted.
+func (c *Controller) routerPods(deployment *appsv1.Deployment) ([]*corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := c.podsLister.Pods(deployment.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []*corev1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp.IsZero() && pod.Spec.NodeName != "" {
+			scheduled = append(scheduled, pod)
+		}
+	}
+	return scheduled, nil
+}
+
+// isStatusOnlyUpdate reports whether the only difference between the two
+// VirtualRouters is in their status, which needs no reconciliation.
+func isStatusOnlyUpdate(old, new *samplev1alpha1.VirtualRouter) bool {
+	return old.ResourceVersion != new.ResourceVersion &&
+		old.Generation == new.Generation &&
+		old.DeletionTimestamp.Equal(new.DeletionTimestamp) &&
+		reflect.DeepEqual(old.Labels, new.Labels) &&
+		reflect.DeepEqual(old.Annotations, new.Annotations)
+}
+
+// enqueueVirtualRouter takes a VirtualRouter resource and converts it into a namespace/name
+// string which is then put onto the work queue. This method should *not* be
+// passed resources of any type other than VirtualRouter.
+func (c *Controller) enqueueVirtualRouter(obj interface{}) {
+	c.enqueue(obj, false)
+}
+
+// enqueueUrgentVirtualRouter enqueues the VirtualRouter ahead of the routine
+// syncs, to be deleted or failed over.
+func (c *Controller) enqueueUrgentVirtualRouter(obj interface{}) {
+	c.enqueue(obj, true)
+}
+
+func (c *Controller) enqueue(obj interface{}, urgent bool) {
+	var key string
+	var err error
+	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if namespace, _, _ := cache.SplitMetaNamespaceKey(key); !c.watches(namespace) {
+		return
+	}
+	if virtualRouter, ok := obj.(*samplev1alpha1.VirtualRouter); ok && !c.handlesClass(virtualRouter) {
+		return
+	}
+	if urgent {
+		c.workqueue.AddUrgent(key)
+	} else {
+		c.workqueue.Add(key)
+	}
+}
+
+// handleObject will take any resource implementing metav1.Object and attempt
+// to find the VirtualRouter resource that 'owns' it. It does this by looking at the
+// objects metadata.ownerReferences field for an appropriate OwnerReference.
+// It then enqueues that VirtualRouter resource to be processed. If the object does not
+// have an appropriate OwnerReference, it will simply be skipped.
+func (c *Controller) handleObject(obj interface{}) {
+	c.handleOwnedObject(obj, false)
+}
+
+// handleDeletedObject enqueues the VirtualRouter of the deleted object ahead
+// of the routine syncs.
+func (c *Controller) handleDeletedObject(obj interface{}) {
+	c.handleOwnedObject(obj, true)
+}
+
+func (c *Controller) handleOwnedObject(obj interface{}, urgent bool) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+		klog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+	}
+	klog.V(4).Infof("Processing object: %s", object.GetName())
+	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		// If this object is not owned by a VirtualRouter, we should not do anything more
+		// with it.
+		if ownerRef.Kind != "VirtualRouter" {
+			return
+		}
+
+		virtualRouter, err := c.virtualRouterOf(object.GetNamespace(), ownerRef)
+		if err != nil {
+			klog.V(4).Infof("ignoring orphaned object '%s' of virtualRouter '%s'", object.GetSelfLink(), ownerRef.Name)
+			return
+		}
+
+		c.enqueue(virtualRouter, urgent)
+		return
+	}
+}
+
+// handlePod enqueues the VirtualRouter owning the router pod. Router pods
+// carry the namespace/name of their VirtualRouter in their annotations, as
+// the ownership chain through the ReplicaSet is not visible from here.
+func (c *Controller) handlePod(obj interface{}) {
+	c.handleRouterPod(obj, false)
+}
+
+// handleFailedPod enqueues the VirtualRouter of the router pod deleted or
+// failed ahead of the routine syncs.
+func (c *Controller) handleFailedPod(obj interface{}) {
+	c.handleRouterPod(obj, true)
+}
+
+func (c *Controller) handleRouterPod(obj interface{}, urgent bool) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+	crName := object.GetAnnotations()["customresourceName"]
+	crNS := object.GetAnnotations()["customresourceNamespace"]
+	if crName == "" || crNS == "" {
+		return
+	}
+	virtualRouter, err := c.virtualRoutersLister.VirtualRouters(crNS).Get(crName)
+	if err != nil {
+		klog.V(4).Infof("ignoring orphaned pod '%s/%s' of virtualRouter '%s'", object.GetNamespace(), object.GetName(), crName)
+		return
+	}
+	c.enqueue(virtualRouter, urgent)
+}
+
+// newDeployment creates a new Deployment for a VirtualRouter resource. It also sets
+// the appropriate OwnerReferences on the resource so handleObject can discover
+// the VirtualRouter resource that 'owns' it.
+func newDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *appsv1.Deployment {
+	return newRouterDeployment(newNS, virtualRouter, nil)
+}
+
+// newRouterDeployment is newDeployment with the sidecars completed with the
+// sidecar profiles.
+func newRouterDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, sidecarProfiles []samplev1alpha1.RouterSidecar) *appsv1.Deployment {
+	// sidecars of profiles missing never get this far either
+	sidecars, err := sidecarContainers(virtualRouter, sidecarProfiles)
+	if err != nil {
+		klog.Errorf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	}
+	deployment := renderDeployment(newNS, virtualRouter, sidecars)
+	// overrides failing ValidatePodTemplateOverrides never get this far
+	if template, err := overridePodTemplate(&deployment.Spec.Template, virtualRouter); err != nil {
+		klog.Errorf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	} else {
+		deployment.Spec.Template = *template
+	}
+	setDeploymentSpecHash(deployment)
+	return deployment
+}
+
+// renderDeployment returns the Deployment of the router as the controller
+// renders it from the spec, before spec.overrides.podTemplate.
+func renderDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, sidecars []corev1.Container) *appsv1.Deployment {
+	labels := RouterPodLabels(virtualRouter)
+	nodeSelectorMap := make(map[string]string)
+	for _, nodeSelector := range virtualRouter.Spec.NodeSelector {
+		nodeSelectorMap[nodeSelector.Key] = nodeSelector.Value
+	}
+	// var uuid = uuid.Must(uuid.NewRandom())
+	replicas := virtualRouter.Spec.Replicas
+	if virtualRouter.Spec.Autoscaling != nil {
+		// routers are created with the fewest pods they scale down to
+		minReplicas := autoscalingMinReplicas(virtualRouter.Spec.Autoscaling)
+		replicas = &minReplicas
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      virtualRouter.Spec.DeploymentName,
+			Namespace: newNS,
+			// the controller only caches the Deployments of routers
+			Labels: RouterPodLabels(virtualRouter),
 
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: virtualRouter.Spec.Replicas,
+			Replicas: replicas,
+			Strategy: deploymentStrategy(virtualRouter.Spec.UpgradeStrategy),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -442,9 +1559,17 @@ func newDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *a
 					Finalizers: []string{VIRTUALROUTER_DAEMON_FINALIZER},
 				},
 				Spec: corev1.PodSpec{
-					Affinity:           &virtualRouter.Spec.Affinity,
-					ServiceAccountName: "virtualrouter-sa",
-					NodeSelector:       nodeSelectorMap,
+					Affinity:                  routerAffinity(virtualRouter),
+					Tolerations:               virtualRouter.Spec.Tolerations,
+					PriorityClassName:         virtualRouter.Spec.PriorityClassName,
+					TopologySpreadConstraints: virtualRouter.Spec.TopologySpreadConstraints,
+					ServiceAccountName:        routerResourceName(virtualRouter, SERVICE_ACCOUNT_NAME),
+					ImagePullSecrets:          virtualRouter.Spec.ImagePullSecrets,
+					NodeSelector:              nodeSelectorMap,
+					ReadinessGates: []corev1.PodReadinessGate{
+						{ConditionType: VIRTUALROUTER_READINESS_GATE},
+					},
+					Volumes: secretVolumes(virtualRouter),
 					Containers: []corev1.Container{
 						{
 							// Name:            "virtualrouter-" + uuid.String(),
@@ -458,42 +1583,281 @@ func newDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *a
 									Value: newNS,
 								},
 							},
-							SecurityContext: &corev1.SecurityContext{
-								Capabilities: &corev1.Capabilities{
-									Add: []corev1.Capability{
-										corev1.Capability("NET_RAW"),
-										corev1.Capability("NET_ADMIN"),
-										corev1.Capability("SYS_ADMIN"),
-									},
-								},
-								Privileged: func(b bool) *bool {
-									return &b
-								}(true),
-							},
+							EnvFrom:         secretEnvFrom(virtualRouter),
+							VolumeMounts:    secretVolumeMounts(virtualRouter),
+							SecurityContext: routerSecurityContext(virtualRouter),
 						},
 					},
 				},
 			},
 		},
 	}
+	if virtualRouter.Spec.Resources != nil {
+		deployment.Spec.Template.Spec.Containers[0].Resources = *virtualRouter.Spec.Resources.DeepCopy()
+	}
+	if usesConfigMap(virtualRouter) {
+		addRouterConfigVolume(deployment, virtualRouter)
+	}
+	if virtualRouter.Spec.DHCP != nil {
+		addRouterDHCPVolume(deployment, virtualRouter)
+	}
+	if virtualRouter.Spec.DNS != nil {
+		addRouterDNSVolume(deployment, virtualRouter)
+	}
+	if IsRestricted(virtualRouter.Spec) {
+		addRouterScratchVolumes(deployment)
+	}
+	if virtualRouter.Spec.ExternalSRIOV != nil {
+		addRouterSRIOVResources(deployment, virtualRouter)
+	}
+	if hasPersistence(virtualRouter) {
+		addRouterStateVolume(deployment, virtualRouter)
+	}
+	addRouterInitContainer(deployment, virtualRouter)
+	// after the router container, which the spec is rendered into
+	deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, sidecars...)
+	return deployment
+}
+
+// deploymentStrategy maps the upgrade strategy of a VirtualRouter to the
+// Deployment rollout. Neither strategy lets available routers drop below the
+// replicas, except DrainStandbyFirst which takes one pod down at a time.
+func deploymentStrategy(upgradeStrategy samplev1alpha1.VirtualRouterUpgradeStrategy) appsv1.DeploymentStrategy {
+	maxSurge := intstr.FromInt(1)
+	maxUnavailable := intstr.FromInt(0)
+	switch upgradeStrategy.Type {
+	case samplev1alpha1.DrainStandbyFirstUpgradeStrategyType:
+		// The ReplicaSet controller scales down the most recently ready pods
+		// first, so standby pods are replaced before the active one.
+		maxSurge = intstr.FromInt(0)
+		maxUnavailable = intstr.FromInt(1)
+	default:
+		if upgradeStrategy.MaxSurge != nil {
+			maxSurge = *upgradeStrategy.MaxSurge
+		}
+	}
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
 }
 
-func (c *Controller) ensureVirtualRouterSA(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
-	_, err := c.kubeclientset.CoreV1().ServiceAccounts(newNS).Get(context.TODO(), SERVICE_ACCOUNT_NAME, metav1.GetOptions{})
+// setDeploymentSpecHash stamps the Deployment with a hash of the spec the
+// controller renders from the VirtualRouter: replicas, rollout strategy and the
+// whole pod template, image, env, labels, security context and affinity included.
+func setDeploymentSpecHash(deployment *appsv1.Deployment) {
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] = specHash(deployment.Spec)
+}
+
+// podTemplateDrifted reports whether the live pod template was edited away
+// from the rendered one, which leaves the spec hash as it was. Only what the
+// API server keeps as given is compared: the labels and annotations of the
+// rendered template, others being left to whoever added them, and the
+// service account, node selector, images, commands and env of the pods.
+func podTemplateDrifted(live, desired *corev1.PodTemplateSpec) bool {
+	for key, value := range desired.Labels {
+		if live.Labels[key] != value {
+			return true
+		}
+	}
+	for key, value := range desired.Annotations {
+		if live.Annotations[key] != value {
+			return true
+		}
+	}
+	if live.Spec.ServiceAccountName != desired.Spec.ServiceAccountName {
+		return true
+	}
+	if len(live.Spec.NodeSelector) != len(desired.Spec.NodeSelector) {
+		return true
+	}
+	for key, value := range desired.Spec.NodeSelector {
+		if live.Spec.NodeSelector[key] != value {
+			return true
+		}
+	}
+	return containersDrifted(live.Spec.InitContainers, desired.Spec.InitContainers) ||
+		containersDrifted(live.Spec.Containers, desired.Spec.Containers)
+}
+
+func containersDrifted(live, desired []corev1.Container) bool {
+	if len(live) != len(desired) {
+		return true
+	}
+	for i := range desired {
+		if live[i].Name != desired[i].Name || live[i].Image != desired[i].Image ||
+			!equalStrings(live[i].Command, desired[i].Command) || !equalStrings(live[i].Args, desired[i].Args) ||
+			len(live[i].Env) != len(desired[i].Env) {
+			return true
+		}
+		// references of values are defaulted, so only the names and values
+		// are compared
+		for j := range desired[i].Env {
+			if live[i].Env[j].Name != desired[i].Env[j].Name || live[i].Env[j].Value != desired[i].Env[j].Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalReplicas compares replicas, the API server defaulting none to 1.
+func equalReplicas(a, b *int32) bool {
+	replicas := func(r *int32) int32 {
+		if r == nil {
+			return 1
+		}
+		return *r
+	}
+	return replicas(a) == replicas(b)
+}
+
+func specHash(spec interface{}) string {
+	hasher := fnv.New32a()
+	hashutil.DeepHashObject(hasher, spec)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// desiredDeployment is newDeployment completed with the controller defaults
+// and the checksums of the configuration mounted into router pods, by the
+// pod template annotation they are kept in. Empty checksums are left out.
+func (c *Controller) desiredDeployment(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, checksums map[string]string) *appsv1.Deployment {
+	virtualRouter = c.profiledRouter(virtualRouter)
+	deployment := newRouterDeployment(newNS, virtualRouter, c.sidecarProfiles())
+	for annotation, checksum := range checksums {
+		if checksum != "" {
+			deployment.Spec.Template.Annotations[annotation] = checksum
+		}
+	}
+	podSpec := &deployment.Spec.Template.Spec
+	for _, secretName := range c.defaultImagePullSecrets() {
+		if !hasImagePullSecret(virtualRouter.Spec.ImagePullSecrets, secretName) {
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: routerResourceName(virtualRouter, secretName)})
+		}
+	}
+	setPodSysctls(deployment, virtualRouter, c.allowedUnsafeSysctls())
+	setRouterInitImage(deployment, c.options.RouterInitImage)
+	c.pinImages(podSpec)
+	setDeploymentSpecHash(deployment)
+	return deployment
+}
+
+func hasImagePullSecret(secrets []corev1.LocalObjectReference, name string) bool {
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureImagePullSecrets mirrors the pull secrets of the router into its
+// namespace, as pods can only reference Secrets of their own namespace.
+func (c *Controller) ensureImagePullSecrets(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	for _, secret := range virtualRouter.Spec.ImagePullSecrets {
+		if err := c.ensureMirroredSecret(virtualRouter.Namespace, secret.Name, newNS, virtualRouter); err != nil {
+			c.recordImagePullSecretNotFound(err, virtualRouter.Namespace, secret.Name, virtualRouter)
+			return err
+		}
+	}
+	for _, secretName := range c.defaultImagePullSecrets() {
+		if hasImagePullSecret(virtualRouter.Spec.ImagePullSecrets, secretName) {
+			continue
+		}
+		if err := c.ensureMirroredSecret(c.options.ControllerNamespace, secretName, newNS, virtualRouter); err != nil {
+			c.recordImagePullSecretNotFound(err, c.options.ControllerNamespace, secretName, virtualRouter)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) recordImagePullSecretNotFound(err error, sourceNS string, secretName string, virtualRouter *samplev1alpha1.VirtualRouter) {
+	if errors.IsNotFound(err) {
+		msg := fmt.Sprintf(MessageImagePullSecretNotFound, sourceNS, secretName)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrImagePullSecretNotFound, msg)
+	}
+}
+
+func (c *Controller) ensureMirroredSecret(sourceNS string, secretName string, newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	if sourceNS == newNS {
+		return nil
+	}
+	source, err := c.kubeclientset.CoreV1().Secrets(sourceNS).Get(c.ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+
+	desired := newMirroredSecret(source, newNS, virtualRouter)
+	secret, err := c.kubeclientset.CoreV1().Secrets(newNS).Get(c.ctx, desired.Name, metav1.GetOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			klog.Error(err)
 			return err
 		}
-		_, err = c.kubeclientset.CoreV1().ServiceAccounts(newNS).Create(context.TODO(), &corev1.ServiceAccount{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      SERVICE_ACCOUNT_NAME,
-				Namespace: newNS,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
-				},
+		_, err = c.kubeclientset.CoreV1().Secrets(newNS).Create(c.ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+
+	if !metav1.IsControlledBy(secret, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, secret.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	// keep the mirror in step with credential rotations of the source
+	if secret.Type == desired.Type && reflect.DeepEqual(secret.Data, desired.Data) {
+		return nil
+	}
+	secretCopy := secret.DeepCopy()
+	secretCopy.Type = desired.Type
+	secretCopy.Data = desired.Data
+	_, err = c.kubeclientset.CoreV1().Secrets(newNS).Update(c.ctx, secretCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// newMirroredSecret copies a Secret into the namespace of a VirtualRouter.
+func newMirroredSecret(source *corev1.Secret, newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, source.Name),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
 			},
-		}, metav1.CreateOptions{})
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+}
+
+func (c *Controller) ensureVirtualRouterSA(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	_, err := c.kubeclientset.CoreV1().ServiceAccounts(newNS).Get(c.ctx, routerResourceName(virtualRouter, SERVICE_ACCOUNT_NAME), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Error(err)
+			return err
+		}
+		_, err = c.kubeclientset.CoreV1().ServiceAccounts(newNS).Create(c.ctx, newServiceAccount(newNS, virtualRouter), metav1.CreateOptions{})
 		if err != nil {
 			klog.Error(err)
 			return err
@@ -502,49 +1866,15 @@ func (c *Controller) ensureVirtualRouterSA(newNS string, virtualRouter *samplev1
 	return nil
 }
 
-// ToDo: modify magic string
 func (c *Controller) ensureVirtualRouterRole(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
-	_, err := c.kubeclientset.RbacV1().Roles(newNS).Get(context.TODO(), ROLE_NAME, metav1.GetOptions{})
+	_, err := c.kubeclientset.RbacV1().Roles(newNS).Get(c.ctx, routerResourceName(virtualRouter, ROLE_NAME), metav1.GetOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			klog.Error(err)
 			return err
 		}
 
-		_, err = c.kubeclientset.RbacV1().Roles(newNS).Create(context.TODO(), &rbac_v1.Role{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      ROLE_NAME,
-				Namespace: newNS,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
-				},
-			},
-			Rules: []rbac_v1.PolicyRule{
-				{
-					APIGroups: []string{
-						// samplev1alpha1.SchemeGroupVersion.Group,
-						virtualrouter.GroupName,
-					},
-					Resources: []string{
-						"natrules", "firewallrules", "loadbalancerrules",
-					},
-					Verbs: []string{
-						"get", "list", "watch", "create", "update", "patch", "delete",
-					},
-				},
-				{
-					APIGroups: []string{
-						networkGroupName,
-					},
-					Resources: []string{
-						"vpns",
-					},
-					Verbs: []string{
-						"get", "list", "watch",
-					},
-				},
-			},
-		}, metav1.CreateOptions{})
+		_, err = c.kubeclientset.RbacV1().Roles(newNS).Create(c.ctx, newRole(newNS, virtualRouter), metav1.CreateOptions{})
 		if err != nil {
 			klog.Error(err)
 			return err
@@ -553,35 +1883,15 @@ func (c *Controller) ensureVirtualRouterRole(newNS string, virtualRouter *sample
 	return nil
 }
 
-// ToDo: modify magic string
 func (c *Controller) ensureVirtualRouterRoleBinding(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
-	_, err := c.kubeclientset.RbacV1().RoleBindings(newNS).Get(context.TODO(), ROLE_BINDING_NAME, metav1.GetOptions{})
+	_, err := c.kubeclientset.RbacV1().RoleBindings(newNS).Get(c.ctx, routerResourceName(virtualRouter, ROLE_BINDING_NAME), metav1.GetOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			klog.Error(err)
 			return err
 		}
 
-		_, err = c.kubeclientset.RbacV1().RoleBindings(newNS).Create(context.TODO(), &rbac_v1.RoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      ROLE_BINDING_NAME,
-				Namespace: newNS,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
-				},
-			},
-			RoleRef: rbac_v1.RoleRef{
-				APIGroup: rbac_v1.SchemeGroupVersion.Group,
-				Kind:     "Role",
-				Name:     ROLE_NAME,
-			},
-			Subjects: []rbac_v1.Subject{
-				{
-					Kind: "ServiceAccount",
-					Name: SERVICE_ACCOUNT_NAME,
-				},
-			},
-		}, metav1.CreateOptions{})
+		_, err = c.kubeclientset.RbacV1().RoleBindings(newNS).Create(c.ctx, newRoleBinding(newNS, virtualRouter), metav1.CreateOptions{})
 		if err != nil {
 			klog.Error(err)
 			return err
@@ -591,25 +1901,126 @@ func (c *Controller) ensureVirtualRouterRoleBinding(newNS string, virtualRouter
 }
 
 func (c *Controller) ensureVirtualRouterNamespace(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
-	_, err := c.kubeclientset.CoreV1().Namespaces().Get(context.TODO(), newNS, metav1.GetOptions{})
-	// RbacV1().ClusterRoles().Get(context.TODO(), clusterRoleName, metav1.GetOptions{})
+	namespace, err := c.kubeclientset.CoreV1().Namespaces().Get(c.ctx, newNS, metav1.GetOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			klog.Error(err)
 			return err
 		}
-		_, err := c.kubeclientset.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: newNS,
-				OwnerReferences: []metav1.OwnerReference{
-					*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
-				},
-			},
-		}, metav1.CreateOptions{})
+		_, err := c.kubeclientset.CoreV1().Namespaces().Create(c.ctx, newNamespace(newNS, virtualRouter), metav1.CreateOptions{})
 		if err != nil {
 			klog.Error(err)
 			return err
 		}
+		return nil
+	}
+	// nothing can be created in the namespace of a deleted VirtualRouter
+	// of the same name until it is gone
+	if namespace.Status.Phase == corev1.NamespaceTerminating || !namespace.DeletionTimestamp.IsZero() {
+		return &namespaceTerminatingError{namespace: newNS}
+	}
+	if !metav1.IsControlledBy(namespace, virtualRouter) {
+		return &namespaceConflictError{namespace: newNS}
+	}
+	// the labels follow the security profile of the router
+	namespace = namespace.DeepCopy()
+	if setPodSecurityLabels(namespace, routerPodSecurityLevel(newNS, virtualRouter)) {
+		if _, err := c.kubeclientset.CoreV1().Namespaces().Update(c.ctx, namespace, metav1.UpdateOptions{}); err != nil {
+			klog.Error(err)
+			return err
+		}
 	}
 	return nil
 }
+
+// newNamespace creates the Namespace holding the objects of a VirtualRouter,
+// labelled with the Pod Security Standard its router pods need.
+func newNamespace(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *corev1.Namespace {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+	}
+	setPodSecurityLabels(namespace, routerPodSecurityLevel(newNS, virtualRouter))
+	return namespace
+}
+
+// newServiceAccount creates the ServiceAccount the router pods run as.
+func newServiceAccount(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, SERVICE_ACCOUNT_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+	}
+}
+
+// ToDo: modify magic string
+// newRole creates the Role granting router pods access to their NFV rules.
+func newRole(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *rbac_v1.Role {
+	return &rbac_v1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, ROLE_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Rules: []rbac_v1.PolicyRule{
+			{
+				APIGroups: []string{
+					// samplev1alpha1.SchemeGroupVersion.Group,
+					virtualrouter.GroupName,
+				},
+				Resources: []string{
+					"natrules", "firewallrules", "loadbalancerrules",
+				},
+				Verbs: []string{
+					"get", "list", "watch", "create", "update", "patch", "delete",
+				},
+			},
+			{
+				APIGroups: []string{
+					networkGroupName,
+				},
+				Resources: []string{
+					"vpns",
+				},
+				Verbs: []string{
+					"get", "list", "watch",
+				},
+			},
+		},
+	}
+}
+
+// ToDo: modify magic string
+// newRoleBinding binds the router Role to the router ServiceAccount.
+func newRoleBinding(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *rbac_v1.RoleBinding {
+	return &rbac_v1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, ROLE_BINDING_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		RoleRef: rbac_v1.RoleRef{
+			APIGroup: rbac_v1.SchemeGroupVersion.Group,
+			Kind:     "Role",
+			Name:     routerResourceName(virtualRouter, ROLE_NAME),
+		},
+		Subjects: []rbac_v1.Subject{
+			{
+				Kind: "ServiceAccount",
+				Name: routerResourceName(virtualRouter, SERVICE_ACCOUNT_NAME),
+			},
+		},
+	}
+}
@@ -17,30 +17,60 @@ limitations under the License.
 package virtualroutermanager
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/featuregate"
 
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
 	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
 	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/fake"
 	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
 )
 
 var (
 	alwaysReady        = func() bool { return true }
 	noResyncPeriodFunc = func() time.Duration { return 0 }
+	fakeNow            = time.Date(2021, time.November, 1, 0, 0, 0, 0, time.UTC)
 )
 
 type fixture struct {
@@ -48,15 +78,27 @@ type fixture struct {
 
 	client     *fake.Clientset
 	kubeclient *k8sfake.Clientset
+	nfvclient  *dynamicfake.FakeDynamicClient
+	options    Options
 	// Objects to put in the store.
 	virtualRouterLister []*networkcontroller.VirtualRouter
+	profileLister       []*networkcontroller.VirtualRouterProfile
+	freezeLister        []*networkcontroller.NetworkFreeze
 	deploymentLister    []*apps.Deployment
+	statefulSetLister   []*apps.StatefulSet
+	pdbLister           []*policy.PodDisruptionBudget
+	podLister           []*corev1.Pod
+	nodeLister          []*corev1.Node
+	serviceLister       []*corev1.Service
+	endpointSliceLister []*discoveryv1beta1.EndpointSlice
 	// Actions expected to happen on the client.
 	kubeactions []core.Action
 	actions     []core.Action
+	nfvactions  []core.Action
 	// Objects from here preloaded into NewSimpleFake.
 	kubeobjects []runtime.Object
 	objects     []runtime.Object
+	nfvobjects  []runtime.Object
 }
 
 func newFixture(t *testing.T) *fixture {
@@ -64,6 +106,7 @@ func newFixture(t *testing.T) *fixture {
 	f.t = t
 	f.objects = []runtime.Object{}
 	f.kubeobjects = []runtime.Object{}
+	f.nfvobjects = []runtime.Object{}
 	return f
 }
 
@@ -71,8 +114,9 @@ func newVirtualRouter(name string, replicas *int32) *networkcontroller.VirtualRo
 	return &networkcontroller.VirtualRouter{
 		TypeMeta: metav1.TypeMeta{APIVersion: networkcontroller.SchemeGroupVersion.String()},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: metav1.NamespaceDefault,
+			Name:        name,
+			Namespace:   metav1.NamespaceDefault,
+			Annotations: map[string]string{ROUTER_NAMESPACE_ANNOTATION: name},
 		},
 		Spec: networkcontroller.VirtualRouterSpec{
 			DeploymentName: fmt.Sprintf("%s-deployment", name),
@@ -84,25 +128,87 @@ func newVirtualRouter(name string, replicas *int32) *networkcontroller.VirtualRo
 func (f *fixture) newController() (*Controller, informers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
 	f.client = fake.NewSimpleClientset(f.objects...)
 	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+	f.nfvclient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), f.nfvobjects...)
 
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
 
-	c := NewController(f.kubeclient, f.client,
-		k8sI.Apps().V1().Deployments(), i.Tmax().V1().VirtualRouters())
+	c := NewController(f.kubeclient, f.client, f.nfvclient,
+		k8sI.Apps().V1().Deployments(), k8sI.Apps().V1().StatefulSets(), k8sI.Policy().V1beta1().PodDisruptionBudgets(),
+		k8sI.Autoscaling().V2beta2().HorizontalPodAutoscalers(), k8sI.Core().V1().Pods(),
+		k8sI.Core().V1().Nodes(), k8sI.Core().V1().Services(), k8sI.Discovery().V1beta1().EndpointSlices(),
+		i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterProfiles(), i.Tmax().V1().NetworkFreezes(), f.options)
 
 	c.virtualRoutersSynced = alwaysReady
+	c.virtualRouterProfilesSynced = alwaysReady
+	c.networkFreezesSynced = alwaysReady
 	c.deploymentsSynced = alwaysReady
+	c.statefulSetsSynced = alwaysReady
+	c.podDisruptionBudgetsSynced = alwaysReady
+	c.horizontalPodAutoscalersSynced = alwaysReady
+	c.podsSynced = alwaysReady
+	c.nodesSynced = alwaysReady
+	c.servicesSynced = alwaysReady
+	c.natRulesSynced = alwaysReady
+	c.firewallRulesSynced = alwaysReady
+	if c.loadBalancerRulesLister != nil {
+		c.endpointSlicesSynced = alwaysReady
+		c.loadBalancerRulesSynced = alwaysReady
+	}
+	for _, obj := range f.nfvobjects {
+		rule := obj.(*unstructured.Unstructured)
+		switch {
+		case rule.GetKind() == "NATRule":
+			c.ruleInformers.ForResource(natRuleResource).Informer().GetIndexer().Add(rule)
+		case rule.GetKind() == "FireWallRule":
+			c.ruleInformers.ForResource(firewallRuleResource).Informer().GetIndexer().Add(rule)
+		case rule.GetKind() == "LoadBalancerRule" && c.loadBalancerRulesLister != nil:
+			c.ruleInformers.ForResource(loadBalancerRuleResource).Informer().GetIndexer().Add(rule)
+		}
+	}
 	c.recorder = &record.FakeRecorder{}
+	c.clock = clock.NewFakeClock(fakeNow)
 
 	for _, f := range f.virtualRouterLister {
 		i.Tmax().V1().VirtualRouters().Informer().GetIndexer().Add(f)
 	}
 
+	for _, p := range f.profileLister {
+		i.Tmax().V1().VirtualRouterProfiles().Informer().GetIndexer().Add(p)
+	}
+
+	for _, freeze := range f.freezeLister {
+		i.Tmax().V1().NetworkFreezes().Informer().GetIndexer().Add(freeze)
+	}
+
 	for _, d := range f.deploymentLister {
 		k8sI.Apps().V1().Deployments().Informer().GetIndexer().Add(d)
 	}
 
+	for _, s := range f.statefulSetLister {
+		k8sI.Apps().V1().StatefulSets().Informer().GetIndexer().Add(s)
+	}
+
+	for _, p := range f.pdbLister {
+		k8sI.Policy().V1beta1().PodDisruptionBudgets().Informer().GetIndexer().Add(p)
+	}
+
+	for _, p := range f.podLister {
+		k8sI.Core().V1().Pods().Informer().GetIndexer().Add(p)
+	}
+
+	for _, n := range f.nodeLister {
+		k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(n)
+	}
+
+	for _, s := range f.serviceLister {
+		k8sI.Core().V1().Services().Informer().GetIndexer().Add(s)
+	}
+
+	for _, e := range f.endpointSliceLister {
+		k8sI.Discovery().V1beta1().EndpointSlices().Informer().GetIndexer().Add(e)
+	}
+
 	return c, i, k8sI
 }
 
@@ -130,34 +236,24 @@ func (f *fixture) runController(virtualRouterName string, startInformers bool, e
 		f.t.Error("expected error syncing virtualRouter, got nil")
 	}
 
-	actions := filterInformerActions(f.client.Actions())
-	for i, action := range actions {
-		if len(f.actions) < i+1 {
-			f.t.Errorf("%d unexpected actions: %+v", len(actions)-len(f.actions), actions[i:])
-			break
-		}
-
-		expectedAction := f.actions[i]
-		checkAction(expectedAction, action, f.t)
-	}
-
-	if len(f.actions) > len(actions) {
-		f.t.Errorf("%d additional expected actions:%+v", len(f.actions)-len(actions), f.actions[len(actions):])
-	}
+	checkActions(f.actions, filterInformerActions(f.client.Actions()), f.t)
+	checkActions(f.kubeactions, filterInformerActions(f.kubeclient.Actions()), f.t)
+	checkActions(f.nfvactions, f.nfvclient.Actions(), f.t)
+}
 
-	k8sActions := filterInformerActions(f.kubeclient.Actions())
-	for i, action := range k8sActions {
-		if len(f.kubeactions) < i+1 {
-			f.t.Errorf("%d unexpected actions: %+v", len(k8sActions)-len(f.kubeactions), k8sActions[i:])
+// checkActions verifies that the actual actions match the expected ones in order.
+func checkActions(expected, actual []core.Action, t *testing.T) {
+	for i, action := range actual {
+		if len(expected) < i+1 {
+			t.Errorf("%d unexpected actions: %+v", len(actual)-len(expected), actual[i:])
 			break
 		}
 
-		expectedAction := f.kubeactions[i]
-		checkAction(expectedAction, action, f.t)
+		checkAction(expected[i], action, t)
 	}
 
-	if len(f.kubeactions) > len(k8sActions) {
-		f.t.Errorf("%d additional expected actions:%+v", len(f.kubeactions)-len(k8sActions), f.kubeactions[len(k8sActions):])
+	if len(expected) > len(actual) {
+		t.Errorf("%d additional expected actions:%+v", len(expected)-len(actual), expected[len(actual):])
 	}
 }
 
@@ -175,6 +271,12 @@ func checkAction(expected, actual core.Action, t *testing.T) {
 	}
 
 	switch a := actual.(type) {
+	case core.GetActionImpl:
+		e, _ := expected.(core.GetActionImpl)
+		if e.GetName() != a.GetName() {
+			t.Errorf("Action %s %s has wrong name, expected %q got %q",
+				a.GetVerb(), a.GetResource().Resource, e.GetName(), a.GetName())
+		}
 	case core.CreateActionImpl:
 		e, _ := expected.(core.CreateActionImpl)
 		expObject := e.GetObject()
@@ -193,6 +295,12 @@ func checkAction(expected, actual core.Action, t *testing.T) {
 			t.Errorf("Action %s %s has wrong object\nDiff:\n %s",
 				a.GetVerb(), a.GetResource().Resource, diff.ObjectGoPrintSideBySide(expObject, object))
 		}
+	case core.DeleteActionImpl:
+		e, _ := expected.(core.DeleteActionImpl)
+		if e.GetName() != a.GetName() {
+			t.Errorf("Action %s %s has wrong name, expected %q got %q",
+				a.GetVerb(), a.GetResource().Resource, e.GetName(), a.GetName())
+		}
 	case core.PatchActionImpl:
 		e, _ := expected.(core.PatchActionImpl)
 		expPatch := e.GetPatch()
@@ -202,6 +310,11 @@ func checkAction(expected, actual core.Action, t *testing.T) {
 			t.Errorf("Action %s %s has wrong patch\nDiff:\n %s",
 				a.GetVerb(), a.GetResource().Resource, diff.ObjectGoPrintSideBySide(expPatch, patch))
 		}
+	case core.ListActionImpl:
+		if expected.GetNamespace() != a.GetNamespace() {
+			t.Errorf("Action %s %s has wrong namespace, expected %q got %q",
+				a.GetVerb(), a.GetResource().Resource, expected.GetNamespace(), a.GetNamespace())
+		}
 	default:
 		t.Errorf("Uncaptured Action %s %s, you should explicitly add a case to capture it",
 			actual.GetVerb(), actual.GetResource().Resource)
@@ -218,7 +331,21 @@ func filterInformerActions(actions []core.Action) []core.Action {
 			(action.Matches("list", "virtualRouters") ||
 				action.Matches("watch", "virtualRouters") ||
 				action.Matches("list", "deployments") ||
-				action.Matches("watch", "deployments")) {
+				action.Matches("watch", "deployments") ||
+				action.Matches("list", "statefulsets") ||
+				action.Matches("watch", "statefulsets") ||
+				action.Matches("list", "poddisruptionbudgets") ||
+				action.Matches("watch", "poddisruptionbudgets") ||
+				action.Matches("list", "horizontalpodautoscalers") ||
+				action.Matches("watch", "horizontalpodautoscalers") ||
+				action.Matches("list", "services") ||
+				action.Matches("watch", "services") ||
+				action.Matches("list", "endpointslices") ||
+				action.Matches("watch", "endpointslices") ||
+				action.Matches("list", "nodes") ||
+				action.Matches("watch", "nodes") ||
+				action.Matches("list", "pods") ||
+				action.Matches("watch", "pods")) {
 			continue
 		}
 		ret = append(ret, action)
@@ -227,6 +354,54 @@ func filterInformerActions(actions []core.Action) []core.Action {
 	return ret
 }
 
+// expectEnsureChildObjectsActions expects the lookups of the namespace,
+// service account, role and role binding of a VirtualRouter, followed by
+// their creation unless they are preloaded into kubeobjects.
+func (f *fixture) expectEnsureChildObjectsActions(newNS string, virtualRouter *networkcontroller.VirtualRouter, create bool) {
+	children := []struct {
+		resource string
+		object   metav1.Object
+	}{
+		{"namespaces", newNamespace(newNS, virtualRouter)},
+		{"serviceaccounts", newServiceAccount(newNS, virtualRouter)},
+		{"roles", newRole(newNS, virtualRouter)},
+		{"rolebindings", newRoleBinding(newNS, virtualRouter)},
+	}
+	for _, child := range children {
+		gvr := schema.GroupVersionResource{Resource: child.resource}
+		f.kubeactions = append(f.kubeactions, core.NewGetAction(gvr, child.object.GetNamespace(), child.object.GetName()))
+		if create {
+			f.kubeactions = append(f.kubeactions, core.NewCreateAction(gvr, child.object.GetNamespace(), child.object.(runtime.Object)))
+		}
+	}
+}
+
+// addChildObjects preloads the namespace, service account, role and role
+// binding of a VirtualRouter.
+func (f *fixture) addChildObjects(newNS string, virtualRouter *networkcontroller.VirtualRouter) {
+	f.kubeobjects = append(f.kubeobjects,
+		newNamespace(newNS, virtualRouter),
+		newServiceAccount(newNS, virtualRouter),
+		newRole(newNS, virtualRouter),
+		newRoleBinding(newNS, virtualRouter))
+}
+
+func (f *fixture) expectCreateFirewallRuleAction(fr *nfvv1.FireWallRule) {
+	f.nfvactions = append(f.nfvactions, core.NewCreateAction(firewallRuleResource, fr.Namespace, mustToUnstructured(fr, f.t)))
+}
+
+func (f *fixture) expectUpdateFirewallRuleAction(fr *nfvv1.FireWallRule) {
+	f.nfvactions = append(f.nfvactions, core.NewUpdateAction(firewallRuleResource, fr.Namespace, mustToUnstructured(fr, f.t)))
+}
+
+func mustToUnstructured(obj runtime.Object, t *testing.T) *unstructured.Unstructured {
+	u, err := toUnstructured(obj)
+	if err != nil {
+		t.Fatalf("error converting %T to unstructured: %v", obj, err)
+	}
+	return u
+}
+
 func (f *fixture) expectCreateDeploymentAction(d *apps.Deployment) {
 	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
 }
@@ -235,11 +410,58 @@ func (f *fixture) expectUpdateDeploymentAction(d *apps.Deployment) {
 	f.kubeactions = append(f.kubeactions, core.NewUpdateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
 }
 
-func (f *fixture) expectUpdateVirtualRouterStatusAction(virtualRouter *networkcontroller.VirtualRouter) {
-	action := core.NewUpdateAction(schema.GroupVersionResource{Resource: "virtualRouters"}, virtualRouter.Namespace, virtualRouter)
-	// TODO: Until #38113 is merged, we can't use Subresource
-	//action.Subresource = "status"
-	f.actions = append(f.actions, action)
+func (f *fixture) expectCreatePodDisruptionBudgetAction(pdb *policy.PodDisruptionBudget) {
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "poddisruptionbudgets"}, pdb.Namespace, pdb))
+}
+
+// expectPatchVirtualRouterStatusAction expects the status of the
+// VirtualRouter in the lister to be patched into the one given.
+func (f *fixture) expectPatchVirtualRouterStatusAction(virtualRouter *networkcontroller.VirtualRouter) {
+	var original networkcontroller.VirtualRouterStatus
+	for _, vr := range f.virtualRouterLister {
+		if vr.Namespace == virtualRouter.Namespace && vr.Name == virtualRouter.Name {
+			original = vr.Status
+		}
+	}
+	patch, err := statusPatch(original, virtualRouter.Status)
+	if err != nil {
+		f.t.Fatalf("error building status patch: %v", err)
+	}
+	f.actions = append(f.actions, core.NewPatchSubresourceAction(schema.GroupVersionResource{Resource: "virtualRouters"}, virtualRouter.Namespace, virtualRouter.Name, types.JSONPatchType, patch, "status"))
+}
+
+// withStatus returns a copy of the VirtualRouter carrying the given status,
+// stamped with the fake reconcile time and the timing of a reconcile taking
+// no time on the fake clock.
+func withStatus(virtualRouter *networkcontroller.VirtualRouter, status networkcontroller.VirtualRouterStatus) *networkcontroller.VirtualRouter {
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	now := metav1.NewTime(fakeNow)
+	status.LastReconcileTime = &now
+	if status.ReconcileTiming == nil {
+		status.ReconcileTiming = &networkcontroller.ReconcileTiming{}
+	}
+	virtualRouterCopy.Status = status
+	return virtualRouterCopy
+}
+
+func newRouterPod(name string, d *apps.Deployment, nodeName string, ready bool, created time.Time) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         d.Namespace,
+			Labels:            d.Spec.Template.Labels,
+			Annotations:       d.Spec.Template.Annotations,
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
 }
 
 func getKey(virtualRouter *networkcontroller.VirtualRouter, t *testing.T) string {
@@ -260,8 +482,32 @@ func TestCreatesDeployment(t *testing.T) {
 
 	newNS := virtualRouter.Name
 	expDeployment := newDeployment(newNS, virtualRouter)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
 	f.expectCreateDeploymentAction(expDeployment)
-	f.expectUpdateVirtualRouterStatusAction(virtualRouter)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestDualStackExternalIPs(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+	virtualRouter.Spec.InternalIPv6CIDR = "fd00:10::1/64"
+	virtualRouter.Spec.ExternalIPv6CIDR = "2001:db8::10/64"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	newNS := virtualRouter.Name
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10", "2001:db8::10"},
+	}))
 
 	f.run(getKey(virtualRouter, t))
 }
@@ -276,11 +522,330 @@ func TestDoNothing(t *testing.T) {
 	f.objects = append(f.objects, virtualRouter)
 	f.deploymentLister = append(f.deploymentLister, d)
 	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestStatusClient(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+	statusClient := fake.NewSimpleClientset(virtualRouter)
+	f.options.StatusClient = statusClient
+
+	// the status is patched by the status client alone
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.run(getKey(virtualRouter, t))
+
+	patch, err := statusPatch(virtualRouter.Status, withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}).Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkActions([]core.Action{core.NewPatchSubresourceAction(schema.GroupVersionResource{Resource: "virtualRouters"}, virtualRouter.Namespace, virtualRouter.Name, types.JSONPatchType, patch, "status")}, statusClient.Actions(), t)
+}
+
+func TestBatchedStatus(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+	f.options.StatusBatchInterval = time.Second
+
+	// the syncs in between write no status
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	c, _, _ := f.newController()
+	for i := 0; i < 2; i++ {
+		if err := c.syncHandler(getKey(virtualRouter, t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkActions(f.actions, filterInformerActions(f.client.Actions()), t)
+	checkActions(f.kubeactions, filterInformerActions(f.kubeclient.Actions()), t)
+
+	// and the status is written once
+	f.client.ClearActions()
+	f.actions = nil
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	c.flushStatus()
+	checkActions(f.actions, filterInformerActions(f.client.Actions()), t)
+	if pending := c.statusWriter.take(); len(pending) != 0 {
+		t.Errorf("expected no status left pending, got %v", pending)
+	}
+}
+
+func TestBatchedStatusAllocations(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.options.StatusBatchInterval = time.Second
+	c, _, _ := f.newController()
+
+	// the next sync reads the allocation back from the status, so it is
+	// written at once
+	allocated := virtualRouter.DeepCopy()
+	allocated.Status.IPAMAllocation = &networkcontroller.IPAMAllocation{Pool: "192.168.9.0/24", Address: "192.168.9.10/24", Reference: "42"}
+	if err := c.updateVirtualRouterStatus(allocated, d); err != nil {
+		t.Fatal(err)
+	}
+	patched := false
+	for _, action := range filterInformerActions(f.client.Actions()) {
+		if action.GetVerb() == "patch" && action.GetSubresource() == "status" {
+			patched = true
+		}
+	}
+	if !patched {
+		t.Errorf("expected the allocation written at once, got %v", f.client.Actions())
+	}
+	if pending := c.statusWriter.take(); len(pending) != 0 {
+		t.Errorf("expected no status left pending, got %v", pending)
+	}
+}
+
+func TestStatusWriter(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	pending := networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterPending}
+	running := networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning}
+	w := newStatusWriter()
+
+	// a later status replaces the one pending, patched against the status
+	// seen first
+	w.queue(virtualRouter, networkcontroller.VirtualRouterStatus{}, pending)
+	w.queue(virtualRouter, networkcontroller.VirtualRouterStatus{}, running)
+	statuses := w.take()
+	if len(statuses) != 1 || !reflect.DeepEqual(statuses["default/test"].original, networkcontroller.VirtualRouterStatus{}) || !reflect.DeepEqual(statuses["default/test"].status, running) {
+		t.Errorf("expected the running status pending, got %+v", statuses)
+	}
+
+	// a status back to the one seen first is dropped
+	w.queue(virtualRouter, pending, running)
+	w.queue(virtualRouter, running, pending)
+	if statuses := w.take(); len(statuses) != 0 {
+		t.Errorf("expected no status pending, got %+v", statuses)
+	}
+}
+
+func TestAdoptsUnlabelledDeployment(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	// created before router Deployments were labelled, so not cached
+	d.Labels = nil
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, d)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.kubeactions = append(f.kubeactions,
+		core.NewGetAction(schema.GroupVersionResource{Resource: "deployments"}, newNS, d.Name),
+		core.NewPatchAction(schema.GroupVersionResource{Resource: "deployments"}, newNS, d.Name, types.MergePatchType, []byte(`{"metadata":{"labels":{"app":"virtualrouterInstance"}}}`)))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
 
-	f.expectUpdateVirtualRouterStatusAction(virtualRouter)
 	f.run(getKey(virtualRouter, t))
 }
 
+func TestHandleObjectByOwnerUID(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.UID = "a3c1e6f0"
+	other := newVirtualRouter("test", int32Ptr(1))
+	other.Namespace = "tenant"
+	other.UID = "5d2b7e44"
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter, other)
+	c, _, _ := f.newController()
+
+	// the Deployment of the router namespace is matched with its owner by
+	// UID, whatever the namespace of the owner
+	c.handleObject(newDeployment(virtualRouter.Name, other))
+	if key, _ := c.workqueue.Get(); key != "tenant/test" || c.workqueue.Len() != 0 {
+		t.Errorf("expected only tenant/test to be queued, got %v", key)
+	}
+}
+
+func TestStrippedListWatch(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	router := newDeployment("test", virtualRouter)
+	router.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}}
+	router.Annotations[corev1.LastAppliedConfigAnnotation] = `{"kind":"Deployment"}`
+	other := newDeployment("test", virtualRouter)
+	other.Name = "web"
+	other.Labels = map[string]string{"app": "web"}
+	client := k8sfake.NewSimpleClientset(router, other)
+
+	informer := NewRouterDeploymentInformer(client, 0)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		t.Fatal("expected the cache to sync")
+	}
+	cached := informer.GetStore().List()
+	if len(cached) != 1 {
+		t.Fatalf("expected only the router Deployment to be cached, got %d", len(cached))
+	}
+	deployment := cached[0].(*apps.Deployment)
+	if deployment.Name != router.Name || deployment.ManagedFields != nil {
+		t.Errorf("expected the router Deployment without managed fields, got %+v", deployment.ObjectMeta)
+	}
+	if _, exist := deployment.Annotations[corev1.LastAppliedConfigAnnotation]; exist || deployment.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] != router.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] {
+		t.Errorf("expected only the last applied configuration to be dropped, got %v", deployment.Annotations)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := newPriorityQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0))
+	get := func() interface{} {
+		item, shutdown := q.Get()
+		if shutdown {
+			t.Fatal("unexpected shutdown")
+		}
+		return item
+	}
+
+	// urgent keys are handed out first, a routine key made urgent moving ahead
+	q.Add("default/a")
+	q.Add("default/b")
+	q.AddUrgent("default/c")
+	q.AddUrgent("default/b")
+	q.Add("default/c")
+	if q.Len() != 3 {
+		t.Fatalf("expected every key queued once, got %d", q.Len())
+	}
+	for _, expected := range []string{"default/c", "default/b", "default/a"} {
+		if item := get(); item != expected {
+			t.Fatalf("expected %s, got %v", expected, item)
+		}
+	}
+
+	// a key added while processed is handed out once done, still urgent
+	q.Add("default/d")
+	q.AddUrgent("default/a")
+	if q.Len() != 1 {
+		t.Fatalf("expected the processed key held back, got %d queued", q.Len())
+	}
+	q.Done("default/a")
+	if item := get(); item != "default/a" {
+		t.Fatalf("expected default/a, got %v", item)
+	}
+
+	// a failed urgent key is retried ahead of the routine keys until forgotten
+	q.AddRateLimited("default/a")
+	q.Done("default/a")
+	if item := get(); item != "default/a" {
+		t.Fatalf("expected default/a retried first, got %v", item)
+	}
+	q.Forget("default/a")
+	q.Done("default/a")
+	q.Add("default/a")
+	if item := get(); item != "default/d" {
+		t.Fatalf("expected default/d, got %v", item)
+	}
+
+	q.ShutDown()
+	if _, shutdown := q.Get(); shutdown {
+		t.Fatal("expected the queued key handed out before the shutdown")
+	}
+	if _, shutdown := q.Get(); !shutdown {
+		t.Fatal("expected the queue shut down")
+	}
+}
+
+func TestPriorityQueueAddAfter(t *testing.T) {
+	q := newPriorityQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0))
+	defer q.ShutDown()
+	waiting := func() int {
+		q.cond.L.Lock()
+		defer q.cond.L.Unlock()
+		return len(q.waiting)
+	}
+
+	// a key added again while waiting waits once, until the earliest time,
+	// urgent if added urgent any of the times
+	q.Add("default/c")
+	for i := 0; i < 10; i++ {
+		q.AddAfter("default/a", time.Hour)
+	}
+	q.AddAfter("default/a", 10*time.Millisecond)
+	q.AddUrgentAfter("default/a", time.Hour)
+	q.AddAfter("default/b", time.Hour)
+	if n := waiting(); n != 2 {
+		t.Fatalf("expected a waiting entry per key, got %d", n)
+	}
+	if err := wait.PollImmediate(5*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return q.Len() == 2, nil
+	}); err != nil {
+		t.Fatalf("expected default/a added once due, got %d queued", q.Len())
+	}
+	if item, _ := q.Get(); item != "default/a" {
+		t.Errorf("expected default/a added urgent, got %v", item)
+	}
+	if n := waiting(); n != 1 {
+		t.Errorf("expected default/b left waiting, got %d waiting", n)
+	}
+}
+
+func TestFailedRouterPodQueuedFirst(t *testing.T) {
+	f := newFixture(t)
+	routine := newVirtualRouter("routine", int32Ptr(1))
+	failed := newVirtualRouter("failed", int32Ptr(1))
+	f.virtualRouterLister = append(f.virtualRouterLister, routine, failed)
+	c, _, _ := f.newController()
+
+	ready := newRouterPod("router-0", newDeployment(failed.Name, failed), "node-a", true, fakeNow)
+	notReady := newRouterPod("router-0", newDeployment(failed.Name, failed), "node-a", false, fakeNow)
+	if !podFailed(ready, notReady) || podFailed(notReady, ready) {
+		t.Fatal("expected only the router pod going not ready to fail")
+	}
+	c.enqueueVirtualRouter(routine)
+	c.handleFailedPod(notReady)
+	if key, _ := c.workqueue.Get(); key != "default/failed" {
+		t.Errorf("expected the failed router first, got %v", key)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	cfg := &rest.Config{Host: "https://10.96.0.1", QPS: 5, Burst: 10}
+	statusCfg := ClientConfig(cfg, CLIENT_COMPONENT_STATUS, 50, 100)
+	if statusCfg.QPS != 50 || statusCfg.Burst != 100 || statusCfg.Host != cfg.Host {
+		t.Errorf("unexpected configuration %+v", statusCfg)
+	}
+	if !strings.HasPrefix(statusCfg.UserAgent, "virtualrouter-controller/status ") {
+		t.Errorf("expected the user agent to tell the status component, got %q", statusCfg.UserAgent)
+	}
+	if cfg.QPS != 5 || cfg.UserAgent != "" {
+		t.Errorf("expected the configuration given to be left as is, got %+v", cfg)
+	}
+}
+
 func TestUpdateDeployment(t *testing.T) {
 	f := newFixture(t)
 	virtualRouter := newVirtualRouter("test", int32Ptr(1))
@@ -295,12 +860,119 @@ func TestUpdateDeployment(t *testing.T) {
 	f.objects = append(f.objects, virtualRouter)
 	f.deploymentLister = append(f.deploymentLister, d)
 	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectUpdateDeploymentAction(expDeployment)
+	f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(expDeployment, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestUpdateDeploymentOnImageChange(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.1"
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	// Update image only, replicas stay the same
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.2"
+	expDeployment := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
 
-	f.expectUpdateVirtualRouterStatusAction(virtualRouter)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
 	f.expectUpdateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
 	f.run(getKey(virtualRouter, t))
 }
 
+func TestRevertDeploymentDrift(t *testing.T) {
+	drifts := map[string]func(*apps.Deployment){
+		"image": func(d *apps.Deployment) { d.Spec.Template.Spec.Containers[0].Image = "example.com/patched:latest" },
+		"env": func(d *apps.Deployment) {
+			d.Spec.Template.Spec.Containers[0].Env = append(d.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "DEBUG", Value: "1"})
+		},
+		"annotation": func(d *apps.Deployment) { d.Spec.Template.Annotations["customresourceName"] = "other" },
+		"replicas":   func(d *apps.Deployment) { d.Spec.Replicas = int32Ptr(1) },
+	}
+	for name, drift := range drifts {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			virtualRouter := newVirtualRouter("test", int32Ptr(2))
+			newNS := virtualRouter.Name
+			expDeployment := newDeployment(newNS, virtualRouter)
+			// edited by hand, the spec hash left as it was
+			d := expDeployment.DeepCopy()
+			drift(d)
+
+			f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+			f.objects = append(f.objects, virtualRouter)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			f.addChildObjects(newNS, virtualRouter)
+
+			f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+			f.expectUpdateDeploymentAction(expDeployment)
+			f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(expDeployment, virtualRouter))
+			f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+				Phase: networkcontroller.VirtualRouterPending,
+			}))
+			f.run(getKey(virtualRouter, t))
+		})
+	}
+
+	// what is added by others, or defaulted, is left alone
+	desired := newDeployment("test", newVirtualRouter("test", int32Ptr(1)))
+	live := desired.DeepCopy()
+	live.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2021-11-01T09:30:00Z"
+	live.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+	if podTemplateDrifted(&live.Spec.Template, &desired.Spec.Template) {
+		t.Errorf("expected no drift of a restarted and defaulted pod template")
+	}
+}
+
+func TestDeploymentSpecHash(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	specHash := func(virtualRouter *networkcontroller.VirtualRouter) string {
+		return newDeployment(virtualRouter.Name, virtualRouter).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION]
+	}
+	base := specHash(virtualRouter)
+	if base == "" || base != specHash(virtualRouter.DeepCopy()) {
+		t.Fatalf("expected a stable spec hash, got %q", base)
+	}
+
+	changes := map[string]func(*networkcontroller.VirtualRouter){
+		"image": func(vr *networkcontroller.VirtualRouter) { vr.Spec.Image = "tmaxcloudck/virtualrouter:0.0.2" },
+		"name":  func(vr *networkcontroller.VirtualRouter) { vr.Name = "renamed" },
+		"affinity": func(vr *networkcontroller.VirtualRouter) {
+			vr.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+		},
+		"annotations": func(vr *networkcontroller.VirtualRouter) {
+			vr.Spec.Overrides = &networkcontroller.VirtualRouterOverrides{PodTemplate: &runtime.RawExtension{
+				Raw: []byte(`{"metadata": {"annotations": {"example.com/team": "network"}}}`),
+			}}
+		},
+		"replicas": func(vr *networkcontroller.VirtualRouter) { vr.Spec.Replicas = int32Ptr(2) },
+	}
+	for name, change := range changes {
+		changed := virtualRouter.DeepCopy()
+		change(changed)
+		if specHash(changed) == base {
+			t.Errorf("%s: expected spec hash to change", name)
+		}
+	}
+}
+
 func TestNotControlledByUs(t *testing.T) {
 	f := newFixture(t)
 	virtualRouter := newVirtualRouter("test", int32Ptr(1))
@@ -313,8 +985,4637 @@ func TestNotControlledByUs(t *testing.T) {
 	f.objects = append(f.objects, virtualRouter)
 	f.deploymentLister = append(f.deploymentLister, d)
 	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
 
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
 	f.runExpectError(getKey(virtualRouter, t))
 }
 
+func TestStatusReportsActiveNode(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	virtualRouter.Generation = 3
+	virtualRouter.Spec.ExternalIP = "192.168.8.153"
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	d.Status.AvailableReplicas = 1
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.podLister = append(f.podLister,
+		newRouterPod("router-b", d, "node-b", true, fakeNow.Add(-time.Minute)),
+		newRouterPod("router-a", d, "node-a", true, fakeNow.Add(-time.Hour)),
+		newRouterPod("router-c", d, "node-c", false, fakeNow.Add(-2*time.Hour)))
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(d, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		AvailableReplicas:  1,
+		ObservedGeneration: 3,
+		Phase:              networkcontroller.VirtualRouterDegraded,
+		ExternalIPs:        []string{"192.168.8.153"},
+		ActiveNode:         "node-a",
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.ConfigAppliedCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: 3,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ConfigApplying,
+			Message:            "Waiting for generation 3 to be applied to router-a, router-b, router-c",
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestVirtualRouterPhase(t *testing.T) {
+	deletionTime := metav1.NewTime(fakeNow)
+	tests := []struct {
+		name      string
+		deleting  bool
+		replicas  int32
+		available int32
+		outdated  int32
+		expected  networkcontroller.VirtualRouterPhase
+	}{
+		{"pending", false, 2, 0, 0, networkcontroller.VirtualRouterPending},
+		{"degraded", false, 2, 1, 0, networkcontroller.VirtualRouterDegraded},
+		{"running", false, 2, 2, 0, networkcontroller.VirtualRouterRunning},
+		{"upgrading", false, 2, 2, 1, networkcontroller.VirtualRouterUpgrading},
+		{"terminating", true, 2, 2, 0, networkcontroller.VirtualRouterTerminating},
+	}
+	for _, test := range tests {
+		virtualRouter := newVirtualRouter("test", int32Ptr(test.replicas))
+		if test.deleting {
+			virtualRouter.DeletionTimestamp = &deletionTime
+		}
+		d := newDeployment(virtualRouter.Name, virtualRouter)
+		d.Status.AvailableReplicas = test.available
+		d.Status.Replicas = test.replicas + test.outdated
+		d.Status.UpdatedReplicas = test.replicas
+		if phase := virtualRouterPhase(virtualRouter, d); phase != test.expected {
+			t.Errorf("%s: expected phase %s, got %s", test.name, test.expected, phase)
+		}
+	}
+}
+
 func int32Ptr(i int32) *int32 { return &i }
+
+func TestDeploymentSchedulingOptions(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Tolerations = []corev1.Toleration{
+		{Key: "node-role.kubernetes.io/gateway", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	}
+	virtualRouter.Spec.PriorityClassName = "system-cluster-critical"
+	virtualRouter.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+		{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule},
+	}
+
+	podSpec := newDeployment(virtualRouter.Name, virtualRouter).Spec.Template.Spec
+	if !reflect.DeepEqual(podSpec.Tolerations, virtualRouter.Spec.Tolerations) {
+		t.Errorf("expected tolerations %v, got %v", virtualRouter.Spec.Tolerations, podSpec.Tolerations)
+	}
+	if podSpec.PriorityClassName != virtualRouter.Spec.PriorityClassName {
+		t.Errorf("expected priorityClassName %q, got %q", virtualRouter.Spec.PriorityClassName, podSpec.PriorityClassName)
+	}
+	if !reflect.DeepEqual(podSpec.TopologySpreadConstraints, virtualRouter.Spec.TopologySpreadConstraints) {
+		t.Errorf("expected topologySpreadConstraints %v, got %v", virtualRouter.Spec.TopologySpreadConstraints, podSpec.TopologySpreadConstraints)
+	}
+}
+
+func TestCreatesManagementFirewallRule(t *testing.T) {
+	f := newFixture(t)
+	f.options.ManagementCIDRs = []string{"10.0.0.0/24"}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	expFirewallRule := newManagementFirewallRule(newNS, virtualRouter, f.options.ManagementCIDRs)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectCreateFirewallRuleAction(expFirewallRule)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestPortForwards(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+	virtualRouter.Spec.PortForwards = []networkcontroller.PortForward{
+		{ExternalPort: 80, TargetIP: "10.0.0.10", TargetPort: 8080},
+		{ExternalPort: 53, Protocol: networkcontroller.PortForwardUDP, TargetIP: "10.0.0.11"},
+	}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	natRule := newPortForwardNATRule(newNS, virtualRouter, "192.168.9.10")
+	expected := []nfvv1.Rules{
+		{Match: nfvv1.Match{DstIP: "192.168.9.10", Protocol: "tcp --dport 80"}, Action: nfvv1.Action{DstIP: "10.0.0.10:8080"}},
+		{Match: nfvv1.Match{DstIP: "192.168.9.10", Protocol: "udp --dport 53"}, Action: nfvv1.Action{DstIP: "10.0.0.11:53"}},
+	}
+	if !reflect.DeepEqual(natRule.Spec.Rules, expected) {
+		t.Errorf("expected rules %+v, got %+v", expected, natRule.Spec.Rules)
+	}
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions, core.NewCreateAction(natRuleResource, newNS, mustToUnstructured(natRule, t)))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10"},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestDeletesPortForwards(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	forwarded := virtualRouter.DeepCopy()
+	forwarded.Spec.PortForwards = []networkcontroller.PortForward{{ExternalPort: 80, TargetIP: "10.0.0.10"}}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(newPortForwardNATRule(newNS, forwarded, "192.168.9.10"), t))
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions, core.NewDeleteAction(natRuleResource, newNS, PORT_FORWARD_NAT_RULE_NAME))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10"},
+	}))
+	f.run(getKey(virtualRouter, t))
+
+	if ValidateSpec(networkcontroller.VirtualRouterSpec{PortForwards: []networkcontroller.PortForward{
+		{ExternalPort: 80, TargetIP: "10.0.0.10"},
+		{ExternalPort: 80, Protocol: networkcontroller.PortForwardTCP, TargetIP: "10.0.0.11"},
+	}}) == nil {
+		t.Errorf("expected a port forwarded twice to be invalid")
+	}
+}
+
+func TestInterNetworkRules(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.VlanNumber = 100
+	virtualRouter.Spec.InternalIP = "10.0.0.1"
+	virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+	virtualRouter.Spec.InternalNetworks = []networkcontroller.InternalNetwork{
+		{Name: "tenant-a", VlanNumber: 101, CIDR: "10.0.1.1/24"},
+		{Name: "tenant-b", VlanNumber: 102, CIDR: "10.0.2.1/24"},
+	}
+	virtualRouter.Spec.InterNetworkPolicies = []networkcontroller.InterNetworkPolicy{
+		{From: "default", To: "tenant-a", Action: networkcontroller.InterNetworkAllow},
+		{From: "tenant-b", To: "default", Action: networkcontroller.InterNetworkNAT},
+		{From: "tenant-a", To: "tenant-b", Action: networkcontroller.InterNetworkDeny},
+	}
+	if err := ValidateSpec(virtualRouter.Spec); err != nil {
+		t.Fatalf("expected the spec to be valid, got %v", err)
+	}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	// the NATRule the NAT policies were once compiled into is deleted
+	stale := &nfvv1.NATRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            routerResourceName(virtualRouter, INTER_NETWORK_RULE_NAME),
+			Namespace:       newNS,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(virtualRouter, networkcontroller.SchemeGroupVersion.WithKind("VirtualRouter"))},
+		},
+	}
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(stale, t))
+
+	firewallRule, err := newInterNetworkRules(newNS, virtualRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the daemon drops the rest and translates the traffic of NAT policies,
+	// the networks are only accepted both ways for the replies
+	accept := func(src, dst string) nfvv1.Rules {
+		return nfvv1.Rules{Match: nfvv1.Match{SrcIP: src, DstIP: dst, Protocol: "all"}, Action: nfvv1.Action{Policy: "ACCEPT"}}
+	}
+	expected := []nfvv1.Rules{
+		accept("10.0.0.0/24", "10.0.1.0/24"),
+		accept("10.0.1.0/24", "10.0.0.0/24"),
+		accept("10.0.2.0/24", "10.0.0.0/24"),
+		accept("10.0.0.0/24", "10.0.2.0/24"),
+	}
+	if !reflect.DeepEqual(firewallRule.Spec.Rules, expected) {
+		t.Errorf("expected firewall rules %+v, got %+v", expected, firewallRule.Spec.Rules)
+	}
+	if err := validateFireWallRule(firewallRule); err != nil {
+		t.Errorf("expected the firewall rule admitted, got %v", err)
+	}
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions,
+		core.NewCreateAction(firewallRuleResource, newNS, mustToUnstructured(firewallRule, t)),
+		core.NewDeleteAction(natRuleResource, newNS, stale.Name))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestInterNetworkRulesVRFs(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.VlanNumber = 100
+	virtualRouter.Spec.InternalIP = "10.0.0.1"
+	virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+	// the tenants reuse the network of spec.internalIP in VRFs of their own
+	virtualRouter.Spec.InternalNetworks = []networkcontroller.InternalNetwork{
+		{Name: "a-web", VlanNumber: 101, CIDR: "10.0.0.1/24", VRFTable: 10},
+		{Name: "a-db", VlanNumber: 102, CIDR: "10.0.1.1/24", VRFTable: 10},
+		{Name: "b-web", VlanNumber: 103, CIDR: "10.0.0.1/24", VRFTable: 11},
+	}
+	virtualRouter.Spec.InterNetworkPolicies = []networkcontroller.InterNetworkPolicy{
+		{From: "a-web", To: "a-db", Action: networkcontroller.InterNetworkAllow},
+		{From: "a-db", To: "a-web", Action: networkcontroller.InterNetworkAllow},
+	}
+	if err := ValidateSpec(virtualRouter.Spec); err != nil {
+		t.Fatalf("expected the spec to be valid, got %v", err)
+	}
+
+	firewallRule, err := newInterNetworkRules(virtualRouter.Name, virtualRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// policies allowing both ways are accepted once
+	expected := []nfvv1.Rules{
+		{Match: nfvv1.Match{SrcIP: "10.0.0.0/24", DstIP: "10.0.1.0/24", Protocol: "all"}, Action: nfvv1.Action{Policy: "ACCEPT"}},
+		{Match: nfvv1.Match{SrcIP: "10.0.1.0/24", DstIP: "10.0.0.0/24", Protocol: "all"}, Action: nfvv1.Action{Policy: "ACCEPT"}},
+	}
+	if !reflect.DeepEqual(firewallRule.Spec.Rules, expected) {
+		t.Errorf("expected firewall rules %+v, got %+v", expected, firewallRule.Spec.Rules)
+	}
+
+	// without a policy allowing traffic there is no rule
+	virtualRouter.Spec.InterNetworkPolicies = nil
+	if firewallRule, err := newInterNetworkRules(virtualRouter.Name, virtualRouter); err != nil || firewallRule != nil {
+		t.Errorf("expected no firewall rule, got %+v, %v", firewallRule, err)
+	}
+}
+
+func TestValidateInternalNetworks(t *testing.T) {
+	spec := networkcontroller.VirtualRouterSpec{
+		VlanNumber:      100,
+		InternalIP:      "10.0.0.1",
+		InternalNetmask: "255.255.255.0",
+		PolicyRouting: []networkcontroller.RoutingTable{
+			{ID: 100, Routes: []networkcontroller.PolicyRoute{{Gateway: "10.0.0.254"}}},
+		},
+	}
+	for _, test := range []struct {
+		name     string
+		networks []networkcontroller.InternalNetwork
+		policies []networkcontroller.InterNetworkPolicy
+		expected string
+	}{
+		{"reserved name", []networkcontroller.InternalNetwork{{Name: "default", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, nil, "the name is that of the network of spec.internalIP"},
+		{"long name", []networkcontroller.InternalNetwork{{Name: "tenant-abcd", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, nil, "at most 10"},
+		{"vlan taken", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 100, CIDR: "10.0.1.1/24"}}, nil, "vlan 100 is that of default"},
+		{"overlapping", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.0.129/25"}}, nil, "10.0.0.128/25 overlaps 10.0.0.0/24 of default"},
+		{"IPv6", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "fd00::1/64"}}, nil, "invalid IPv4 CIDR"},
+		{"duplicate name", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24"}, {Name: "a", VlanNumber: 102, CIDR: "10.0.2.1/24"}}, nil, "given more than once"},
+		{"unknown network", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, []networkcontroller.InterNetworkPolicy{{From: "a", To: "b", Action: networkcontroller.InterNetworkAllow}}, "no internal network b"},
+		{"same network", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, []networkcontroller.InterNetworkPolicy{{From: "a", To: "a", Action: networkcontroller.InterNetworkAllow}}, "the networks are the same"},
+		{"unknown action", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, []networkcontroller.InterNetworkPolicy{{From: "a", To: "default", Action: "Forward"}}, "unknown action"},
+		{"valid", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24"}}, []networkcontroller.InterNetworkPolicy{{From: "a", To: "default", Action: networkcontroller.InterNetworkNAT}}, ""},
+		{"overlapping VRF", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.0.1/24", VRFTable: 10}}, nil, ""},
+		{"overlapping in a VRF", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.0.1/24", VRFTable: 10}, {Name: "b", VlanNumber: 102, CIDR: "10.0.0.129/25", VRFTable: 10}}, nil, "10.0.0.128/25 overlaps 10.0.0.0/24 of a"},
+		{"router table", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24", VRFTable: 200}}, nil, "used by the router itself"},
+		{"policy routing table", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24", VRFTable: 100}}, nil, "is a policy routing table"},
+		{"different VRFs", []networkcontroller.InternalNetwork{{Name: "a", VlanNumber: 101, CIDR: "10.0.1.1/24", VRFTable: 10}}, []networkcontroller.InterNetworkPolicy{{From: "a", To: "default", Action: networkcontroller.InterNetworkAllow}}, "the networks are in different VRFs"},
+	} {
+		spec.InternalNetworks, spec.InterNetworkPolicies = test.networks, test.policies
+		err := ValidateSpec(spec)
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("%s: expected the spec to be valid, got %v", test.name, err)
+		case test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("%s: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestRevertsManagementFirewallRule(t *testing.T) {
+	f := newFixture(t)
+	f.options.ManagementCIDRs = []string{"10.0.0.0/24"}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	// a tenant turns the management rule into a drop
+	tampered := newManagementFirewallRule(newNS, virtualRouter, f.options.ManagementCIDRs)
+	tampered.Spec.Rules[0].Action.Policy = "DROP"
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(tampered, t))
+	// even while frozen
+	f.freezeLister = append(f.freezeLister, &networkcontroller.NetworkFreeze{ObjectMeta: metav1.ObjectMeta{Name: "incident"}})
+
+	expFirewallRule := newManagementFirewallRule(newNS, virtualRouter, f.options.ManagementCIDRs)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectUpdateFirewallRuleAction(expFirewallRule)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestManagementFirewallRuleExemptsDNSResolvers(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.DNS = &networkcontroller.DNS{
+		Upstreams: []string{"8.8.8.8"},
+		ConditionalForwarders: []networkcontroller.DNSForwarder{
+			{Domain: "corp.example.com", Servers: []string{"10.0.0.53", "8.8.8.8"}},
+		},
+	}
+
+	rule := newManagementFirewallRule("test", virtualRouter, nil)
+	if rule == nil {
+		t.Fatal("expected a management rule for the DNS resolvers")
+	}
+	var matches []string
+	for _, r := range rule.Spec.Rules {
+		if r.Action.Policy != "ACCEPT" {
+			t.Errorf("expected every rule to accept, got %+v", r)
+		}
+		matches = append(matches, r.Match.SrcIP+">"+r.Match.DstIP+" "+r.Match.Protocol)
+	}
+	expected := []string{
+		">8.8.8.8 udp --dport 53", "8.8.8.8> udp --sport 53",
+		">8.8.8.8 tcp --dport 53", "8.8.8.8> tcp --sport 53",
+		">10.0.0.53 udp --dport 53", "10.0.0.53> udp --sport 53",
+		">10.0.0.53 tcp --dport 53", "10.0.0.53> tcp --sport 53",
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("expected rules %v, got %v", expected, matches)
+	}
+	for _, r := range rule.Spec.Rules {
+		if _, err := parseRuleProtocol(r.Match.Protocol); err != nil {
+			t.Errorf("expected the rules to pass validation, got %v", err)
+		}
+	}
+
+	if rule := newManagementFirewallRule("test", newVirtualRouter("test", int32Ptr(1)), nil); rule != nil {
+		t.Errorf("expected no management rule without anything to exempt, got %+v", rule)
+	}
+}
+
+func TestDeletesManagementFirewallRule(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	// the management CIDRs were reloaded away
+	previous := newManagementFirewallRule(newNS, virtualRouter, []string{"10.0.0.0/24"})
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(previous, t))
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions, core.NewDeleteAction(firewallRuleResource, newNS, previous.Name))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestMirrorsImagePullSecrets(t *testing.T) {
+	f := newFixture(t)
+	f.options.ControllerNamespace = "virtualrouter"
+	f.options.DefaultImagePullSecrets = []string{"default-registry"}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "tenant-registry"}}
+	newNS := virtualRouter.Name
+
+	tenantSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-registry", Namespace: virtualRouter.Namespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+	defaultSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-registry", Namespace: f.options.ControllerNamespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.local":{}}}`)},
+	}
+	// the tenant secret was mirrored before, and rotated since
+	staleMirror := newMirroredSecret(tenantSecret, newNS, virtualRouter)
+	staleMirror.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{}`)}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, tenantSecret, defaultSecret, staleMirror)
+	f.addChildObjects(newNS, virtualRouter)
+
+	secretsResource := schema.GroupVersionResource{Resource: "secrets"}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.kubeactions = append(f.kubeactions,
+		core.NewGetAction(secretsResource, tenantSecret.Namespace, tenantSecret.Name),
+		core.NewGetAction(secretsResource, newNS, tenantSecret.Name),
+		core.NewUpdateAction(secretsResource, newNS, newMirroredSecret(tenantSecret, newNS, virtualRouter)),
+		core.NewGetAction(secretsResource, defaultSecret.Namespace, defaultSecret.Name),
+		core.NewGetAction(secretsResource, newNS, defaultSecret.Name),
+		core.NewCreateAction(secretsResource, newNS, newMirroredSecret(defaultSecret, newNS, virtualRouter)))
+
+	expDeployment := newDeployment(newNS, virtualRouter)
+	expDeployment.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "tenant-registry"}, {Name: "default-registry"}}
+	setDeploymentSpecHash(expDeployment)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestRouterSecrets(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.EnvFrom = []networkcontroller.SecretEnvSource{{SecretName: "bgp", Prefix: "BGP_"}}
+	virtualRouter.Spec.SecretRefs = []networkcontroller.SecretMount{{SecretName: "bgp"}, {SecretName: "vpn", MountPath: "/etc/ipsec.d/secrets"}}
+	newNS := virtualRouter.Name
+
+	bgpSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bgp", Namespace: virtualRouter.Namespace},
+		Data:       map[string][]byte{"PASSWORD": []byte("secret")},
+	}
+	vpnSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vpn", Namespace: virtualRouter.Namespace},
+		Data:       map[string][]byte{"psk": []byte("secret")},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, bgpSecret, vpnSecret)
+	f.addChildObjects(newNS, virtualRouter)
+
+	secretsResource := schema.GroupVersionResource{Resource: "secrets"}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	// a Secret used both ways is mirrored once
+	for _, secret := range []*corev1.Secret{bgpSecret, vpnSecret} {
+		f.kubeactions = append(f.kubeactions,
+			core.NewGetAction(secretsResource, secret.Namespace, secret.Name),
+			core.NewGetAction(secretsResource, newNS, secret.Name),
+			core.NewCreateAction(secretsResource, newNS, newMirroredSecret(secret, newNS, virtualRouter)))
+	}
+
+	expDeployment := newDeployment(newNS, virtualRouter)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	container := expDeployment.Spec.Template.Spec.Containers[0]
+	if len(container.EnvFrom) != 1 || container.EnvFrom[0].Prefix != "BGP_" || container.EnvFrom[0].SecretRef.Name != "bgp" {
+		t.Errorf("unexpected envFrom %+v", container.EnvFrom)
+	}
+	expMounts := []corev1.VolumeMount{
+		{Name: "secret-0", MountPath: "/etc/virtualrouter/secrets/bgp", ReadOnly: true},
+		{Name: "secret-1", MountPath: "/etc/ipsec.d/secrets", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(container.VolumeMounts, expMounts) {
+		t.Errorf("expected volume mounts %+v, got %+v", expMounts, container.VolumeMounts)
+	}
+	volumes := expDeployment.Spec.Template.Spec.Volumes
+	if len(volumes) != 2 || volumes[1].Name != "secret-1" || volumes[1].Secret.SecretName != "vpn" {
+		t.Errorf("unexpected volumes %+v", volumes)
+	}
+}
+
+func TestMissingRouterSecretKeepsDeployment(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	// the Secret is added to a running router, but isn't created yet
+	virtualRouter.Spec.SecretRefs = []networkcontroller.SecretMount{{SecretName: "vpn"}}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "secrets"}, virtualRouter.Namespace, "vpn"))
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.DegradedCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ErrChildObjectsFailed,
+			Message:            `failed to ensure router Secrets: secrets "vpn" not found`,
+		}},
+	})
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+
+	f.runExpectError(getKey(virtualRouter, t))
+}
+
+func TestParallelChildObjects(t *testing.T) {
+	f := newFixture(t)
+	f.options.EnsureParallelism = DEFAULT_ENSURE_PARALLELISM
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	c, _, _ := f.newController()
+	f.kubeclient.PrependReactor("create", "roles", func(core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "roles"}, "test-role", fmt.Errorf("escalation"))
+	})
+	// the RoleBinding is retried within the sync while the API server is busy
+	var bindingAttempts int32
+	var bindingLock sync.Mutex
+	f.kubeclient.PrependReactor("create", "rolebindings", func(core.Action) (bool, runtime.Object, error) {
+		bindingLock.Lock()
+		defer bindingLock.Unlock()
+		if bindingAttempts++; bindingAttempts == 1 {
+			return true, nil, errors.NewTooManyRequests("busy", 0)
+		}
+		return false, nil, nil
+	})
+
+	if err := c.syncHandler(getKey(virtualRouter, t)); err == nil {
+		t.Fatal("expected the sync to fail on the Role")
+	}
+	// the objects not depending on the Role were created all the same
+	if _, err := f.kubeclient.CoreV1().ServiceAccounts(newNS).Get(context.TODO(), newServiceAccount(newNS, virtualRouter).Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the ServiceAccount created, got %v", err)
+	}
+	if _, err := f.kubeclient.RbacV1().RoleBindings(newNS).Get(context.TODO(), newRoleBinding(newNS, virtualRouter).Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the RoleBinding created once retried, got %v", err)
+	}
+	if _, err := f.kubeclient.AppsV1().Deployments(newNS).Get(context.TODO(), virtualRouter.Spec.DeploymentName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no Deployment before every object exists, got %v", err)
+	}
+
+	updated, err := f.client.TmaxV1().VirtualRouters(virtualRouter.Namespace).Get(context.TODO(), virtualRouter.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var degraded *metav1.Condition
+	for i := range updated.Status.Conditions {
+		if updated.Status.Conditions[i].Type == networkcontroller.DegradedCondition {
+			degraded = &updated.Status.Conditions[i]
+		}
+	}
+	if degraded == nil || degraded.Reason != ErrChildObjectsFailed || !strings.HasPrefix(degraded.Message, "failed to ensure Role: ") {
+		t.Errorf("expected the Role reported in the Degraded condition, got %+v", degraded)
+	}
+}
+
+func TestDeploymentUpgradeStrategy(t *testing.T) {
+	surge := intstr.FromString("50%")
+	tests := []struct {
+		name            string
+		upgradeStrategy networkcontroller.VirtualRouterUpgradeStrategy
+		maxSurge        intstr.IntOrString
+		maxUnavailable  intstr.IntOrString
+	}{
+		{"default", networkcontroller.VirtualRouterUpgradeStrategy{}, intstr.FromInt(1), intstr.FromInt(0)},
+		{"rolling update with surge", networkcontroller.VirtualRouterUpgradeStrategy{
+			Type:     networkcontroller.RollingUpdateUpgradeStrategyType,
+			MaxSurge: &surge,
+		}, surge, intstr.FromInt(0)},
+		{"drain standby first", networkcontroller.VirtualRouterUpgradeStrategy{
+			Type:     networkcontroller.DrainStandbyFirstUpgradeStrategyType,
+			MaxSurge: &surge,
+		}, intstr.FromInt(0), intstr.FromInt(1)},
+	}
+	for _, test := range tests {
+		virtualRouter := newVirtualRouter("test", int32Ptr(2))
+		virtualRouter.Spec.UpgradeStrategy = test.upgradeStrategy
+		rollingUpdate := newDeployment(virtualRouter.Name, virtualRouter).Spec.Strategy.RollingUpdate
+		if *rollingUpdate.MaxSurge != test.maxSurge || *rollingUpdate.MaxUnavailable != test.maxUnavailable {
+			t.Errorf("%s: expected maxSurge %s and maxUnavailable %s, got %s and %s", test.name,
+				test.maxSurge.String(), test.maxUnavailable.String(), rollingUpdate.MaxSurge.String(), rollingUpdate.MaxUnavailable.String())
+		}
+	}
+}
+
+// fakeExternalIPApprover answers every request with the same decision.
+type fakeExternalIPApprover struct {
+	decision networkcontroller.ExternalIPApprovalDecision
+	requests []ExternalIPApprovalRequest
+}
+
+func (a *fakeExternalIPApprover) Approve(request ExternalIPApprovalRequest) (ExternalIPApprovalResponse, error) {
+	a.requests = append(a.requests, request)
+	return ExternalIPApprovalResponse{Decision: a.decision, Reason: "by test"}, nil
+}
+
+func TestExternalIPAwaitsApproval(t *testing.T) {
+	f := newFixture(t)
+	approver := &fakeExternalIPApprover{decision: networkcontroller.ExternalIPPending}
+	f.options.ExternalIPApprover = approver
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	// no router is started before its external IP is approved
+	newNS := virtualRouter.Name
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		ExternalIPApproval: &networkcontroller.ExternalIPApproval{
+			ExternalIP:         "192.168.9.10",
+			Decision:           networkcontroller.ExternalIPPending,
+			Reason:             "by test",
+			LastTransitionTime: metav1.NewTime(fakeNow),
+		},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	if len(approver.requests) != 1 || approver.requests[0].ExternalIP != "192.168.9.10" {
+		t.Errorf("expected one approval request for 192.168.9.10, got %+v", approver.requests)
+	}
+}
+
+func TestExternalIPApproved(t *testing.T) {
+	f := newFixture(t)
+	f.options.ExternalIPApprover = &fakeExternalIPApprover{decision: networkcontroller.ExternalIPApproved}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	newNS := virtualRouter.Name
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10"},
+		ExternalIPApproval: &networkcontroller.ExternalIPApproval{
+			ExternalIP:         "192.168.9.10",
+			Decision:           networkcontroller.ExternalIPApproved,
+			Reason:             "by test",
+			ApprovedIP:         "192.168.9.10",
+			LastTransitionTime: metav1.NewTime(fakeNow),
+		},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestExternalIPChangeKeepsApprovedIP(t *testing.T) {
+	f := newFixture(t)
+	approver := &fakeExternalIPApprover{decision: networkcontroller.ExternalIPDenied}
+	f.options.ExternalIPApprover = approver
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	approvedAt := metav1.NewTime(fakeNow.Add(-time.Hour))
+	virtualRouter.Spec.ExternalIP = "192.168.9.11"
+	virtualRouter.Status.ExternalIPApproval = &networkcontroller.ExternalIPApproval{
+		ExternalIP:         "192.168.9.10",
+		Decision:           networkcontroller.ExternalIPApproved,
+		ApprovedIP:         "192.168.9.10",
+		LastTransitionTime: approvedAt,
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	// the running router keeps the approved IP while the new one is denied
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10"},
+		ExternalIPApproval: &networkcontroller.ExternalIPApproval{
+			ExternalIP:         "192.168.9.11",
+			Decision:           networkcontroller.ExternalIPDenied,
+			Reason:             "by test",
+			ApprovedIP:         "192.168.9.10",
+			LastTransitionTime: metav1.NewTime(fakeNow),
+		},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestWebhookExternalIPApprover(t *testing.T) {
+	var received ExternalIPApprovalRequest
+	decision := "Approved"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprintf(w, `{"decision": %q, "reason": "reserved in IPAM"}`, decision)
+	}))
+	defer server.Close()
+
+	approver := NewWebhookExternalIPApprover(server.URL, time.Second)
+	request := ExternalIPApprovalRequest{Namespace: "default", Name: "test", ExternalIP: "192.168.9.10", ExternalNetmask: "24"}
+	response, err := approver.Approve(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received != request {
+		t.Errorf("webhook received %+v, expected %+v", received, request)
+	}
+	if response.Decision != networkcontroller.ExternalIPApproved || response.Reason != "reserved in IPAM" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	decision = "Maybe"
+	if _, err := approver.Approve(request); err == nil {
+		t.Error("expected an error on an unknown decision")
+	}
+}
+
+// fakeIPAM hands out 10.0.0.10/24, 10.0.0.11/24 and so on from any pool, and
+// records releases.
+type fakeIPAM struct {
+	allocated    int
+	released     []ipam.Allocation
+	descriptions []string
+}
+
+func (p *fakeIPAM) AllocateAddress(pool string, description string) (ipam.Allocation, error) {
+	host := 10 + p.allocated
+	p.allocated++
+	p.descriptions = append(p.descriptions, description)
+	return ipam.Allocation{CIDR: fmt.Sprintf("10.0.0.%d/24", host), Reference: fmt.Sprintf("ip-addresses/%d", host)}, nil
+}
+
+func (p *fakeIPAM) AllocatePrefix(pool string, prefixLength int, description string) (ipam.Allocation, error) {
+	return ipam.Allocation{}, fmt.Errorf("not supported")
+}
+
+func (p *fakeIPAM) Release(allocation ipam.Allocation) error {
+	p.released = append(p.released, allocation)
+	return nil
+}
+
+func (f *fixture) expectUpdateVirtualRouterAction(virtualRouter *networkcontroller.VirtualRouter) {
+	f.actions = append(f.actions, core.NewUpdateAction(schema.GroupVersionResource{Resource: "virtualRouters"}, virtualRouter.Namespace, virtualRouter))
+}
+
+// expectPersistAllocationsAction expects the IPAM allocations of the
+// VirtualRouter written to its status as soon as they are made.
+func (f *fixture) expectPersistAllocationsAction(before, after *networkcontroller.VirtualRouter) {
+	patch, err := statusPatch(before.Status, after.Status)
+	if err != nil {
+		f.t.Fatalf("error building status patch: %v", err)
+	}
+	f.actions = append(f.actions, core.NewPatchSubresourceAction(schema.GroupVersionResource{Resource: "virtualRouters"}, after.Namespace, after.Name, types.JSONPatchType, patch, "status"))
+}
+
+func TestAllocatesExternalIPFromIPAM(t *testing.T) {
+	f := newFixture(t)
+	f.options.IPAM = &fakeIPAM{}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIPPool = "10.0.0.0/24"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	withFinalizer := virtualRouter.DeepCopy()
+	withFinalizer.Finalizers = []string{VIRTUALROUTER_IPAM_FINALIZER}
+	newNS := virtualRouter.Name
+	allocated := withFinalizer.DeepCopy()
+	allocated.Status.IPAMAllocation = &networkcontroller.IPAMAllocation{Pool: "10.0.0.0/24", Address: "10.0.0.10/24", Reference: "ip-addresses/10"}
+	f.expectUpdateVirtualRouterAction(withFinalizer)
+	// the address is recorded before anything else of the sync
+	f.expectPersistAllocationsAction(withFinalizer, allocated)
+	f.expectEnsureChildObjectsActions(newNS, withFinalizer, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, withFinalizer))
+	f.expectPatchVirtualRouterStatusAction(withStatus(withFinalizer, networkcontroller.VirtualRouterStatus{
+		Phase:          networkcontroller.VirtualRouterPending,
+		ExternalIPs:    []string{"10.0.0.10"},
+		IPAMAllocation: &networkcontroller.IPAMAllocation{Pool: "10.0.0.0/24", Address: "10.0.0.10/24", Reference: "ip-addresses/10"},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestReleasesExternalIPOnDeletion(t *testing.T) {
+	f := newFixture(t)
+	provider := &fakeIPAM{}
+	f.options.IPAM = provider
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIPPool = "10.0.0.0/24"
+	virtualRouter.Finalizers = []string{VIRTUALROUTER_IPAM_FINALIZER}
+	// the status patch decodes timestamps in the local zone
+	deletedAt := metav1.NewTime(fakeNow.Local())
+	virtualRouter.DeletionTimestamp = &deletedAt
+	virtualRouter.Status.IPAMAllocation = &networkcontroller.IPAMAllocation{Pool: "10.0.0.0/24", Address: "10.0.0.10/24", Reference: "ip-addresses/10"}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	released := virtualRouter.DeepCopy()
+	released.Status.IPAMAllocation = nil
+	f.expectPersistAllocationsAction(virtualRouter, released)
+	released.Finalizers = nil
+	f.expectUpdateVirtualRouterAction(released)
+
+	f.run(getKey(virtualRouter, t))
+
+	if len(provider.released) != 1 || provider.released[0].Reference != "ip-addresses/10" {
+		t.Errorf("expected ip-addresses/10 to be released, got %+v", provider.released)
+	}
+}
+
+func TestAllocatesSNATPool(t *testing.T) {
+	f := newFixture(t)
+	provider := &fakeIPAM{}
+	f.options.IPAM = provider
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.SNATPool = &networkcontroller.SNATPool{Pool: "10.0.0.0/24", Size: 3}
+	// shrunk from a bigger pool
+	virtualRouter.Finalizers = []string{VIRTUALROUTER_IPAM_FINALIZER}
+	virtualRouter.Status.SNATPoolAllocations = []networkcontroller.IPAMAllocation{
+		{Pool: "10.0.0.0/24", Address: "10.0.0.5/24", Reference: "ip-addresses/5"},
+		{Pool: "10.0.0.0/24", Address: "10.0.0.6/24", Reference: "ip-addresses/6"},
+		{Pool: "10.0.0.0/23", Address: "10.0.1.7/23", Reference: "ip-addresses/7"},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	newNS := virtualRouter.Name
+	// the release and the allocation are each recorded as they are made
+	released := virtualRouter.DeepCopy()
+	released.Status.SNATPoolAllocations = []networkcontroller.IPAMAllocation{
+		{Pool: "10.0.0.0/24", Address: "10.0.0.5/24", Reference: "ip-addresses/5"},
+		{Pool: "10.0.0.0/24", Address: "10.0.0.6/24", Reference: "ip-addresses/6"},
+	}
+	f.expectPersistAllocationsAction(virtualRouter, released)
+	allocated := released.DeepCopy()
+	allocated.Status.SNATPoolAllocations = append(allocated.Status.SNATPoolAllocations,
+		networkcontroller.IPAMAllocation{Pool: "10.0.0.0/24", Address: "10.0.0.10/24", Reference: "ip-addresses/10"})
+	f.expectPersistAllocationsAction(released, allocated)
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		SNATPoolAllocations: []networkcontroller.IPAMAllocation{
+			{Pool: "10.0.0.0/24", Address: "10.0.0.5/24", Reference: "ip-addresses/5"},
+			{Pool: "10.0.0.0/24", Address: "10.0.0.6/24", Reference: "ip-addresses/6"},
+			{Pool: "10.0.0.0/24", Address: "10.0.0.10/24", Reference: "ip-addresses/10"},
+		},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	if len(provider.released) != 1 || provider.released[0].Reference != "ip-addresses/7" {
+		t.Errorf("expected ip-addresses/7 to be released, got %+v", provider.released)
+	}
+	if len(provider.descriptions) != 1 || !strings.HasSuffix(provider.descriptions[0], " snat 2") {
+		t.Errorf("expected the third slot to be allocated, got %q", provider.descriptions)
+	}
+}
+
+func TestTenantPlacement(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Placement.Strategy = networkcontroller.TenantPlacementStrategy
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	// no namespace is created, and the resources are prefixed in the
+	// namespace of the VirtualRouter
+	newNS := RouterNamespace(virtualRouter)
+	if newNS != metav1.NamespaceDefault {
+		t.Errorf("expected router namespace %s, got %s", metav1.NamespaceDefault, newNS)
+	}
+	// the namespace is only looked up for its Pod Security Standard
+	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "namespaces"}, "", newNS))
+	children := []struct {
+		resource string
+		name     string
+		object   metav1.Object
+	}{
+		{"serviceaccounts", "test-virtualrouter-sa", newServiceAccount(newNS, virtualRouter)},
+		{"roles", "test-virtualrouter-role", newRole(newNS, virtualRouter)},
+		{"rolebindings", "test-virtualrouter-rb", newRoleBinding(newNS, virtualRouter)},
+	}
+	for _, child := range children {
+		if child.object.GetName() != child.name {
+			t.Errorf("expected %s %s, got %s", child.resource, child.name, child.object.GetName())
+		}
+		gvr := schema.GroupVersionResource{Resource: child.resource}
+		f.kubeactions = append(f.kubeactions, core.NewGetAction(gvr, newNS, child.object.GetName()))
+		f.kubeactions = append(f.kubeactions, core.NewCreateAction(gvr, newNS, child.object.(runtime.Object)))
+	}
+	expDeployment := newDeployment(newNS, virtualRouter)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	// routers sharing the namespace must not select each other's pods
+	if expDeployment.Spec.Selector.MatchLabels[VIRTUALROUTER_NAME_LABEL] != "test" {
+		t.Errorf("expected the selector to hold the VirtualRouter name, got %v", expDeployment.Spec.Selector.MatchLabels)
+	}
+	if expDeployment.Spec.Template.Spec.ServiceAccountName != "test-virtualrouter-sa" {
+		t.Errorf("unexpected service account %s", expDeployment.Spec.Template.Spec.ServiceAccountName)
+	}
+}
+
+func TestWatchNamespaces(t *testing.T) {
+	f := newFixture(t)
+	f.options.WatchNamespaces = []string{"tenant-a", "tenant-b"}
+	c, _, _ := f.newController()
+
+	c.enqueueVirtualRouter(newVirtualRouter("ignored", int32Ptr(1)))
+	watched := newVirtualRouter("watched", int32Ptr(1))
+	watched.Namespace = "tenant-b"
+	c.enqueueVirtualRouter(watched)
+
+	if c.workqueue.Len() != 1 {
+		t.Fatalf("expected only the VirtualRouter of a watched namespace to be queued, got %d", c.workqueue.Len())
+	}
+	if key, _ := c.workqueue.Get(); key != "tenant-b/watched" {
+		t.Errorf("expected tenant-b/watched to be queued, got %v", key)
+	}
+}
+
+func TestReload(t *testing.T) {
+	f := newFixture(t)
+	f.options.ManagementCIDRs = []string{"10.0.0.0/24"}
+	f.options.ControllerNamespace = "virtualrouter"
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	c, _, _ := f.newController()
+
+	c.Reload(Options{ManagementCIDRs: []string{"10.1.0.0/24"}, RuleExpiryWarning: time.Minute, NodeFailureGracePeriod: -1})
+
+	if cidrs := c.managementCIDRs(); len(cidrs) != 1 || cidrs[0] != "10.1.0.0/24" {
+		t.Errorf("expected the reloaded management CIDRs, got %v", cidrs)
+	}
+	if warning := c.ruleExpiryWarning(); warning != time.Minute {
+		t.Errorf("expected the reloaded rule expiry warning, got %s", warning)
+	}
+	if _, ok := c.nodeFailureGracePeriod(); ok {
+		t.Error("expected router pods to be left to the node controller after the reload")
+	}
+	if c.options.ControllerNamespace != "virtualrouter" {
+		t.Errorf("expected the other options to be kept, got controller namespace %q", c.options.ControllerNamespace)
+	}
+	if c.workqueue.Len() != 1 {
+		t.Errorf("expected every VirtualRouter to be resynced, got %d queued", c.workqueue.Len())
+	}
+}
+
+func TestPersistsPendingKeysOnShutdown(t *testing.T) {
+	f := newFixture(t)
+	f.options.ControllerNamespace = "virtualrouter"
+	f.options.DrainTimeout = 10 * time.Millisecond
+	c, _, _ := f.newController()
+
+	c.workqueue.Add("default/resynced")
+	c.workqueue.Add("default/synced")
+	key, _ := c.workqueue.Get()
+	version := c.workqueue.version(key)
+	// changed again while being synced
+	c.workqueue.Add(key)
+	c.workqueue.synced(key, version)
+	c.workqueue.Done(key)
+	key, _ = c.workqueue.Get()
+	c.workqueue.synced(key, c.workqueue.version(key))
+	c.workqueue.Done(key)
+
+	// a worker stuck in a sync
+	var workers sync.WaitGroup
+	workers.Add(1)
+	c.drain(&workers)
+	if c.ctx.Err() == nil {
+		t.Error("expected the syncs outlasting the drain timeout to be cancelled")
+	}
+	configMap, err := f.kubeclient.CoreV1().ConfigMaps("virtualrouter").Get(context.TODO(), PENDING_KEYS_CONFIGMAP_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := configMap.Data[PENDING_KEYS_KEY]; keys != "default/resynced" {
+		t.Errorf("expected the key changed during its sync to be persisted, got %q", keys)
+	}
+
+	f = newFixture(t)
+	f.options.ControllerNamespace = "virtualrouter"
+	f.kubeobjects = append(f.kubeobjects, configMap)
+	c, _, _ = f.newController()
+	c.restorePendingKeys(1, make(chan struct{}))
+	if _, err := f.kubeclient.CoreV1().ConfigMaps("virtualrouter").Get(context.TODO(), PENDING_KEYS_CONFIGMAP_NAME, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the pending keys to be taken once, got %v", err)
+	}
+}
+
+func newExpiringRule(obj runtime.Object, name string, annotations map[string]string, t *testing.T) *unstructured.Unstructured {
+	u := mustToUnstructured(obj, t)
+	u.SetName(name)
+	u.SetNamespace("test")
+	u.SetCreationTimestamp(metav1.NewTime(fakeNow.Add(-2 * time.Hour)))
+	u.SetAnnotations(annotations)
+	return u
+}
+
+// setFeatureGates sets the feature gates for the test.
+func setFeatureGates(t *testing.T, value string) {
+	gate := features.DefaultMutableFeatureGate
+	t.Cleanup(func() { features.DefaultMutableFeatureGate, features.DefaultFeatureGate = gate, gate })
+	features.DefaultMutableFeatureGate = gate.DeepCopy()
+	features.DefaultFeatureGate = features.DefaultMutableFeatureGate
+	if err := features.DefaultMutableFeatureGate.Set(value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiresRules(t *testing.T) {
+	// LoadBalancerRules are only watched, and expired, with the feature gate
+	setFeatureGates(t, "LoadBalancerBackendServices=true")
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	rules := []nfvv1.Rules{{Match: nfvv1.Match{DstIP: "10.0.0.10"}, Action: nfvv1.Action{DstIP: "192.168.0.10"}}}
+	natRule := &nfvv1.NATRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+		Spec:     nfvv1.NATRuleSpec{Rules: rules},
+	}
+	firewallRule := &nfvv1.FireWallRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "FireWallRule"},
+		Spec:     nfvv1.FireWallRuleSpec{Rules: rules},
+	}
+	loadBalancerRule := &nfvv1.LoadBalancerRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "LoadBalancerRule"},
+	}
+	deactivated := newExpiringRule(natRule, "deactivated", map[string]string{
+		RULE_EXPIRES_AT_ANNOTATION:    fakeNow.Add(-time.Minute).Format(time.RFC3339),
+		RULE_EXPIRY_ACTION_ANNOTATION: RULE_EXPIRY_ACTION_DEACTIVATE,
+	}, t)
+	deleted := newExpiringRule(firewallRule, "deleted", map[string]string{RULE_TTL_ANNOTATION: "1h"}, t)
+	permanent := newExpiringRule(firewallRule, "permanent", nil, t)
+	expiring := newExpiringRule(loadBalancerRule, "expiring", map[string]string{
+		RULE_EXPIRES_AT_ANNOTATION: fakeNow.Add(5 * time.Minute).Format(time.RFC3339),
+	}, t)
+	f.nfvobjects = append(f.nfvobjects, deactivated, deleted, permanent, expiring)
+
+	// the rules of a deactivated rule are kept aside and emptied
+	expDeactivated := deactivated.DeepCopy()
+	rulesJSON, err := json.Marshal(deactivated.Object["spec"].(map[string]interface{})["rules"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expDeactivated.SetAnnotations(map[string]string{
+		RULE_EXPIRES_AT_ANNOTATION:    fakeNow.Add(-time.Minute).Format(time.RFC3339),
+		RULE_EXPIRY_ACTION_ANNOTATION: RULE_EXPIRY_ACTION_DEACTIVATE,
+		RULE_EXPIRED_RULES_ANNOTATION: string(rulesJSON),
+	})
+	expDeactivated.Object["spec"].(map[string]interface{})["rules"] = []interface{}{}
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions,
+		core.NewUpdateAction(nfvv1.SchemeGroupVersion.WithResource("natrules"), newNS, expDeactivated),
+		core.NewDeleteAction(firewallRuleResource, newNS, "deleted"))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		RuleExpirations: []networkcontroller.RuleExpiration{
+			{Kind: "NATRule", Name: "deactivated", ExpiresAt: metav1.NewTime(fakeNow.Add(-time.Minute)), Expired: true},
+			{Kind: "LoadBalancerRule", Name: "expiring", ExpiresAt: metav1.NewTime(fakeNow.Add(5 * time.Minute)), Warned: true},
+		},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestWarnsOfInvalidRuleExpiryOnce(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	malformed := newExpiringRule(&nfvv1.NATRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+	}, "malformed", map[string]string{RULE_TTL_ANNOTATION: "1 hour"}, t)
+	f.nfvobjects = append(f.nfvobjects, malformed)
+	c, _, _ := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+	gate := &disruptionGate{}
+
+	warnings := func() int {
+		var count int
+		for {
+			select {
+			case event := <-recorder.Events:
+				if strings.Contains(event, ErrInvalidRuleExpiry) {
+					count++
+				}
+			default:
+				return count
+			}
+		}
+	}
+
+	_, invalids, err := c.expireRules("test", virtualRouter, gate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []networkcontroller.InvalidRuleExpiry{{Kind: "NATRule", Name: "malformed", Value: "1 hour"}}
+	if !reflect.DeepEqual(invalids, expected) {
+		t.Errorf("expected the invalid expiries %+v, got %+v", expected, invalids)
+	}
+	if count := warnings(); count != 1 {
+		t.Errorf("expected a warning of the invalid expiry, got %d", count)
+	}
+
+	// the next syncs find the value warned of in the status
+	virtualRouter.Status.InvalidRuleExpiries = invalids
+	if _, _, err := c.expireRules("test", virtualRouter, gate); err != nil {
+		t.Fatal(err)
+	}
+	if count := warnings(); count != 0 {
+		t.Errorf("expected no warning of an unchanged value, got %d", count)
+	}
+
+	// another malformed value is warned of again
+	malformed.SetAnnotations(map[string]string{RULE_TTL_ANNOTATION: "2 hours"})
+	if _, err := c.dynamicclient.Resource(natRuleResource).Namespace("test").Update(context.TODO(), malformed, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, invalids, err = c.expireRules("test", virtualRouter, gate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := warnings(); count != 1 {
+		t.Errorf("expected a warning of the changed value, got %d", count)
+	}
+	if len(invalids) != 1 || invalids[0].Value != "2 hours" {
+		t.Errorf("expected the changed value kept, got %+v", invalids)
+	}
+}
+
+func TestRestoresExtendedRule(t *testing.T) {
+	rule := mustToUnstructured(&nfvv1.FireWallRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "FireWallRule"},
+		Spec: nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{
+			{Match: nfvv1.Match{SrcIP: "10.0.0.1", Protocol: "tcp"}, Action: nfvv1.Action{Policy: "ACCEPT"}},
+		}},
+	}, t)
+	rule.SetAnnotations(map[string]string{})
+	original := rule.DeepCopy()
+
+	if err := setRulesActive(rule, false); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _, _ := unstructured.NestedSlice(rule.Object, "spec", "rules"); len(rules) != 0 {
+		t.Errorf("expected no rules once deactivated, got %v", rules)
+	}
+	if err := setRulesActive(rule, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rule.GetAnnotations()[RULE_EXPIRED_RULES_ANNOTATION]; ok {
+		t.Errorf("expected %s to be dropped once restored", RULE_EXPIRED_RULES_ANNOTATION)
+	}
+	restored, _ := json.Marshal(rule.Object["spec"])
+	expected, _ := json.Marshal(original.Object["spec"])
+	if string(restored) != string(expected) {
+		t.Errorf("expected rules %s to be restored, got %s", expected, restored)
+	}
+}
+
+func TestRouterConfigMap(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ConfigSource = networkcontroller.ConfigMapConfigSource
+	virtualRouter.Spec.InternalIP = "10.0.0.1"
+	virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+	newNS := virtualRouter.Name
+
+	firewallRule := &nfvv1.FireWallRule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "FireWallRule"},
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-web", Namespace: newNS},
+		Spec: nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{
+			{Match: nfvv1.Match{DstIP: "10.0.0.10", Protocol: "tcp"}, Action: nfvv1.Action{Policy: "ACCEPT"}},
+		}},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(firewallRule, t))
+	f.addChildObjects(newNS, virtualRouter)
+
+	data := map[string]string{
+		"router.yaml": `externalIP: ""
+externalNetmask: ""
+gatewayIP: ""
+internalIP: 10.0.0.1
+internalNetmask: 255.255.255.0
+vlanNumber: 0
+`,
+		"natrules.yaml": "[]\n",
+		"firewallrules.yaml": `- name: allow-web
+  rules:
+  - action:
+      dstIP: ""
+      policy: ACCEPT
+      srcIP: ""
+    args: null
+    match:
+      dstIP: 10.0.0.10
+      protocol: tcp
+      srcIP: ""
+`,
+		"loadbalancerrules.yaml": "[]\n",
+	}
+	configMapsResource := schema.GroupVersionResource{Resource: "configmaps"}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	// the rules are rendered as the API server has them
+	for _, r := range ruleResources {
+		f.nfvactions = append(f.nfvactions, core.NewListAction(r.resource, schema.GroupVersionKind{Kind: r.kind + "List"}, newNS, metav1.ListOptions{}))
+	}
+	f.kubeactions = append(f.kubeactions,
+		core.NewGetAction(configMapsResource, newNS, ROUTER_CONFIG_NAME),
+		core.NewCreateAction(configMapsResource, newNS, newRouterConfigMap(newNS, virtualRouter, ROUTER_CONFIG_NAME, data)))
+
+	expDeployment := newDeployment(newNS, virtualRouter)
+	expDeployment.Spec.Template.Annotations[CONFIG_CHECKSUM_ANNOTATION] = configChecksum(data)
+	setDeploymentSpecHash(expDeployment)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	podSpec := expDeployment.Spec.Template.Spec
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].ConfigMap.Name != ROUTER_CONFIG_NAME {
+		t.Errorf("expected the configuration volume, got %+v", podSpec.Volumes)
+	}
+	if mounts := podSpec.Containers[0].VolumeMounts; len(mounts) != 1 || mounts[0].MountPath != ROUTER_CONFIG_DIR {
+		t.Errorf("expected the configuration mounted at %s, got %+v", ROUTER_CONFIG_DIR, mounts)
+	}
+
+	// a rule change rolls out router pods through the checksum
+	changed := map[string]string{}
+	for key, value := range data {
+		changed[key] = value
+	}
+	changed["natrules.yaml"] = "- name: masquerade\n"
+	if configChecksum(changed) == configChecksum(data) {
+		t.Errorf("expected the checksum to change with the configuration")
+	}
+}
+
+func TestRouterDHCP(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.InternalIP = "10.0.0.1"
+	virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+	virtualRouter.Spec.DHCP = &networkcontroller.DHCP{
+		RangeStart: "10.0.0.100",
+		RangeEnd:   "10.0.0.199",
+		LeaseTime:  &metav1.Duration{Duration: time.Hour},
+		DNSServers: []string{"8.8.8.8", "8.8.4.4"},
+		Reservations: []networkcontroller.DHCPReservation{
+			{MAC: "52:54:00:12:34:56", IP: "10.0.0.10", Hostname: "db"},
+			{MAC: "52:54:00:12:34:57", IP: "10.0.0.11"},
+		},
+	}
+	newNS := virtualRouter.Name
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.addChildObjects(newNS, virtualRouter)
+
+	data := map[string]string{
+		"dnsmasq.conf": `interface=ethint
+bind-interfaces
+port=0
+dhcp-range=10.0.0.100,10.0.0.199,255.255.255.0,3600s
+dhcp-option=option:router,10.0.0.1
+dhcp-option=option:dns-server,8.8.8.8,8.8.4.4
+dhcp-hostsfile=/etc/virtualrouter/dhcp/dhcp-hosts
+`,
+		"dhcp-hosts": `52:54:00:12:34:56,10.0.0.10,db
+52:54:00:12:34:57,10.0.0.11
+`,
+	}
+	configMapsResource := schema.GroupVersionResource{Resource: "configmaps"}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.kubeactions = append(f.kubeactions,
+		core.NewGetAction(configMapsResource, newNS, ROUTER_DHCP_CONFIG_NAME),
+		core.NewCreateAction(configMapsResource, newNS, newRouterConfigMap(newNS, virtualRouter, ROUTER_DHCP_CONFIG_NAME, data)))
+
+	expDeployment := newDeployment(newNS, virtualRouter)
+	expDeployment.Spec.Template.Annotations[DHCP_CHECKSUM_ANNOTATION] = configChecksum(map[string]string{"dnsmasq.conf": data["dnsmasq.conf"]})
+	setDeploymentSpecHash(expDeployment)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+
+	if mounts := expDeployment.Spec.Template.Spec.Containers[0].VolumeMounts; len(mounts) != 1 || mounts[0].MountPath != ROUTER_DHCP_DIR {
+		t.Errorf("expected the DHCP configuration mounted at %s, got %+v", ROUTER_DHCP_DIR, mounts)
+	}
+
+	// reservations are reloaded by dnsmasq without rolling out router pods
+	changed := virtualRouter.DeepCopy()
+	changed.Spec.DHCP.Reservations = changed.Spec.DHCP.Reservations[:1]
+	if desired := newDeployment(newNS, changed); desired.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] != newDeployment(newNS, virtualRouter).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] {
+		t.Errorf("expected a reservation change to keep the router pods")
+	}
+	if renderDHCPConfig(changed)["dnsmasq.conf"] != data["dnsmasq.conf"] {
+		t.Errorf("expected a reservation change to keep dnsmasq.conf")
+	}
+
+	for name, dhcp := range map[string]networkcontroller.DHCP{
+		"range outside":         {RangeStart: "10.0.1.100", RangeEnd: "10.0.1.199"},
+		"range reversed":        {RangeStart: "10.0.0.199", RangeEnd: "10.0.0.100"},
+		"range holds router":    {RangeStart: "10.0.0.1", RangeEnd: "10.0.0.199"},
+		"invalid MAC":           {RangeStart: "10.0.0.100", RangeEnd: "10.0.0.199", Reservations: []networkcontroller.DHCPReservation{{MAC: "52:54:00", IP: "10.0.0.10"}}},
+		"reserved router":       {RangeStart: "10.0.0.100", RangeEnd: "10.0.0.199", Reservations: []networkcontroller.DHCPReservation{{MAC: "52:54:00:12:34:56", IP: "10.0.0.1"}}},
+		"duplicate reservation": {RangeStart: "10.0.0.100", RangeEnd: "10.0.0.199", Reservations: []networkcontroller.DHCPReservation{{MAC: "52:54:00:12:34:56", IP: "10.0.0.10"}, {MAC: "52:54:00:12:34:57", IP: "10.0.0.10"}}},
+	} {
+		spec := virtualRouter.Spec.DeepCopy()
+		spec.DHCP = &dhcp
+		if ValidateSpec(*spec) == nil {
+			t.Errorf("%s: expected an invalid spec", name)
+		}
+	}
+}
+
+func TestRouterDNS(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.InternalIP = "10.0.0.1"
+	virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+	virtualRouter.Spec.DNS = &networkcontroller.DNS{
+		Upstreams:             []string{"8.8.8.8", "1.1.1.1"},
+		ConditionalForwarders: []networkcontroller.DNSForwarder{{Domain: "corp.example", Servers: []string{"10.10.0.53"}}},
+		Records:               []networkcontroller.DNSRecord{{Name: "db.internal", IP: "10.0.0.10"}},
+	}
+
+	expected := map[string]string{
+		"dnsmasq.conf": `interface=ethint
+bind-interfaces
+no-resolv
+no-hosts
+server=8.8.8.8
+server=1.1.1.1
+server=/corp.example/10.10.0.53
+addn-hosts=/etc/virtualrouter/dns/hosts
+`,
+		"hosts": "10.0.0.10 db.internal\n",
+	}
+	if data := renderDNSConfig(virtualRouter); !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected dnsmasq configuration %v, got %v", expected, data)
+	}
+
+	// DHCP clients are given the router as resolver
+	virtualRouter.Spec.DHCP = &networkcontroller.DHCP{RangeStart: "10.0.0.100", RangeEnd: "10.0.0.199"}
+	if config := renderDHCPConfig(virtualRouter)["dnsmasq.conf"]; !strings.Contains(config, "dhcp-option=option:dns-server,10.0.0.1\n") {
+		t.Errorf("expected the router handed out as DNS server, got %s", config)
+	}
+	if err := ValidateSpec(virtualRouter.Spec); err != nil {
+		t.Errorf("expected a valid spec, got %v", err)
+	}
+
+	for name, dns := range map[string]networkcontroller.DNS{
+		"no upstream":         {},
+		"invalid upstream":    {Upstreams: []string{"dns.google"}},
+		"forwarder no server": {Upstreams: []string{"8.8.8.8"}, ConditionalForwarders: []networkcontroller.DNSForwarder{{Domain: "corp.example"}}},
+		"invalid domain":      {Upstreams: []string{"8.8.8.8"}, ConditionalForwarders: []networkcontroller.DNSForwarder{{Domain: "corp/example", Servers: []string{"10.10.0.53"}}}},
+		"invalid record":      {Upstreams: []string{"8.8.8.8"}, Records: []networkcontroller.DNSRecord{{Name: "db", IP: "db"}}},
+	} {
+		spec := virtualRouter.Spec.DeepCopy()
+		spec.DNS = &dns
+		if ValidateSpec(*spec) == nil {
+			t.Errorf("%s: expected an invalid spec", name)
+		}
+	}
+}
+
+func TestDNSHealthyCondition(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	virtualRouter.Spec.DNS = &networkcontroller.DNS{Upstreams: []string{"8.8.8.8"}}
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	routerPod := func(name string, health string) *corev1.Pod {
+		pod := newRouterPod(name, d, "node-"+name, true, fakeNow)
+		if health != "" {
+			pod.Annotations = map[string]string{DNS_HEALTH_ANNOTATION: health}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name    string
+		pods    []*corev1.Pod
+		status  metav1.ConditionStatus
+		reason  string
+		message string
+	}{
+		{"not probed", []*corev1.Pod{routerPod("a", "")}, "", "", ""},
+		{"healthy", []*corev1.Pod{routerPod("a", `{"healthy":true}`), routerPod("b", "")},
+			metav1.ConditionTrue, DNSHealthy, "example.com resolved by the forwarders of 1 router pods"},
+		{"unhealthy", []*corev1.Pod{routerPod("a", `{"healthy":true}`), routerPod("b", `{"healthy":false,"message":"i/o timeout"}`)},
+			metav1.ConditionFalse, ErrDNSUnhealthy, "b on node-b: i/o timeout"},
+	}
+	for _, test := range tests {
+		condition := dnsHealthyCondition(virtualRouter, test.pods, fakeNow)
+		if test.status == "" {
+			if condition != nil {
+				t.Errorf("%s: expected no condition, got %+v", test.name, condition)
+			}
+			continue
+		}
+		if condition == nil || condition.Status != test.status || condition.Reason != test.reason || condition.Message != test.message {
+			t.Errorf("%s: unexpected condition %+v", test.name, condition)
+		}
+	}
+}
+
+func TestDataPlaneHealthyCondition(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	routerPod := func(name string, health string, created time.Time) *corev1.Pod {
+		pod := newRouterPod(name, d, "node-"+name, true, created)
+		if health != "" {
+			pod.Annotations = map[string]string{DATA_PLANE_HEALTH_ANNOTATION: health}
+		}
+		return pod
+	}
+	unhealthy := `{"healthy":false,"message":"bridge extbr is down","consecutiveFailures":3}`
+
+	tests := []struct {
+		name            string
+		pods            []*corev1.Pod
+		status          metav1.ConditionStatus
+		message         string
+		activeUnhealthy bool
+	}{
+		{"not checked", []*corev1.Pod{routerPod("a", "", fakeNow)}, "", "", false},
+		{"healthy", []*corev1.Pod{routerPod("a", `{"healthy":true}`, fakeNow), routerPod("b", "", fakeNow)},
+			metav1.ConditionTrue, "Data plane of 1 router pods forwarding", false},
+		{"standby unhealthy", []*corev1.Pod{routerPod("a", `{"healthy":true}`, fakeNow.Add(-time.Hour)), routerPod("b", unhealthy, fakeNow)},
+			metav1.ConditionFalse, "b on node-b: bridge extbr is down", false},
+		{"active unhealthy", []*corev1.Pod{routerPod("a", unhealthy, fakeNow.Add(-time.Hour)), routerPod("b", `{"healthy":true}`, fakeNow)},
+			metav1.ConditionFalse, "a on node-a: bridge extbr is down", true},
+	}
+	for _, test := range tests {
+		condition := dataPlaneHealthyCondition(virtualRouter, test.pods, fakeNow)
+		if activeUnhealthy := activeDataPlaneUnhealthy(test.pods); activeUnhealthy != test.activeUnhealthy {
+			t.Errorf("%s: expected active pod unhealthy %t", test.name, test.activeUnhealthy)
+		}
+		if test.status == "" {
+			if condition != nil {
+				t.Errorf("%s: expected no condition, got %+v", test.name, condition)
+			}
+			continue
+		}
+		if condition == nil || condition.Status != test.status || condition.Message != test.message {
+			t.Errorf("%s: unexpected condition %+v", test.name, condition)
+		}
+	}
+}
+
+func TestDataPlaneFailover(t *testing.T) {
+	healthy, unhealthy := `{"healthy":true}`, `{"healthy":false,"message":"gateway 192.168.8.1 unreachable","consecutiveFailures":3}`
+	tests := []struct {
+		name            string
+		failover        bool
+		active, standby string
+		deleted         bool
+	}{
+		{"disabled", false, unhealthy, healthy, false},
+		{"active unhealthy", true, unhealthy, healthy, true},
+		{"active healthy", true, healthy, unhealthy, false},
+		{"no healthy standby", true, unhealthy, unhealthy, false},
+		{"standby not checked", true, unhealthy, "", false},
+	}
+	for _, test := range tests {
+		f := newFixture(t)
+		virtualRouter := newVirtualRouter("test", int32Ptr(2))
+		virtualRouter.Spec.DataPlaneFailover = test.failover
+		d := newDeployment(virtualRouter.Name, virtualRouter)
+		active := newRouterPod("router-a", d, "node-a", true, fakeNow.Add(-time.Hour))
+		active.Annotations = map[string]string{DATA_PLANE_HEALTH_ANNOTATION: test.active}
+		standby := newRouterPod("router-b", d, "node-b", true, fakeNow)
+		if test.standby != "" {
+			standby.Annotations = map[string]string{DATA_PLANE_HEALTH_ANNOTATION: test.standby}
+		}
+		f.kubeobjects = append(f.kubeobjects, active, standby)
+		c, _, _ := f.newController()
+
+		if err := c.failoverDataPlane(virtualRouter.Name, virtualRouter, []*corev1.Pod{standby, active}); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var deleted []string
+		for _, action := range f.kubeclient.Actions() {
+			if action.Matches("delete", "pods") {
+				deleted = append(deleted, action.(core.DeleteAction).GetName())
+			}
+		}
+		if test.deleted && (len(deleted) != 1 || deleted[0] != "router-a") || !test.deleted && len(deleted) > 0 {
+			t.Errorf("%s: unexpected deleted pods %v", test.name, deleted)
+		}
+	}
+}
+
+func TestStatusPatch(t *testing.T) {
+	reconciled := metav1.NewTime(fakeNow)
+	tests := map[string]struct {
+		old, new networkcontroller.VirtualRouterStatus
+		expected string
+	}{
+		"unchanged": {
+			old:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, AvailableReplicas: 1},
+			new:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, AvailableReplicas: 1},
+			expected: "",
+		},
+		"first status": {
+			new:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterPending},
+			expected: `[{"op":"add","path":"/status","value":{"availableReplicas":0,"phase":"Pending"}}]`,
+		},
+		"changed fields only": {
+			old:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterPending, ActiveNode: "node1"},
+			new:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, AvailableReplicas: 1, ActiveNode: "node1", LastReconcileTime: &reconciled},
+			expected: `[{"op":"add","path":"/status/availableReplicas","value":1},{"op":"add","path":"/status/lastReconcileTime","value":"2021-11-01T00:00:00Z"},{"op":"add","path":"/status/phase","value":"Running"}]`,
+		},
+		"cleared field": {
+			old:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, ExternalIPs: []string{"192.168.9.10"}},
+			new:      networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning},
+			expected: `[{"op":"remove","path":"/status/externalIPs"}]`,
+		},
+	}
+	for name, test := range tests {
+		patch, err := statusPatch(test.old, test.new)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(patch) != test.expected {
+			t.Errorf("%s: expected patch %s, got %s", name, test.expected, patch)
+		}
+	}
+}
+
+func TestSkipsUnchangedStatus(t *testing.T) {
+	for name, test := range map[string]struct {
+		lastReconcile time.Time
+		expectPatch   bool
+	}{
+		"recently reconciled": {lastReconcile: fakeNow.Add(-time.Minute)},
+		"heartbeat due":       {lastReconcile: fakeNow.Add(-STATUS_HEARTBEAT_INTERVAL), expectPatch: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			virtualRouter := newVirtualRouter("test", int32Ptr(1))
+			newNS := virtualRouter.Name
+			d := newDeployment(newNS, virtualRouter)
+			lastReconcile := metav1.NewTime(test.lastReconcile)
+			virtualRouter.Status = networkcontroller.VirtualRouterStatus{
+				Phase:             networkcontroller.VirtualRouterPending,
+				LastReconcileTime: &lastReconcile,
+			}
+
+			f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+			f.objects = append(f.objects, virtualRouter)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			f.addChildObjects(newNS, virtualRouter)
+
+			f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+			if test.expectPatch {
+				f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+					Phase: networkcontroller.VirtualRouterPending,
+				}))
+			}
+			f.run(getKey(virtualRouter, t))
+		})
+	}
+}
+
+func TestWaitsForTerminatingNamespace(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	// the namespace of a deleted VirtualRouter of the same name
+	namespace := newNamespace(newNS, virtualRouter)
+	namespace.Status.Phase = corev1.NamespaceTerminating
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, namespace)
+
+	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "namespaces"}, "", newNS))
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.NamespaceTerminatingCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             NamespaceTerminating,
+			Message:            fmt.Sprintf(MessageNamespaceTerminating, newNS),
+		}},
+	})
+	// the router is held back before any phase ran
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestClearsNamespaceTerminatingCondition(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	virtualRouter.Status = networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.NamespaceTerminatingCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow.Add(-time.Minute)),
+			Reason:             NamespaceTerminating,
+			Message:            fmt.Sprintf(MessageNamespaceTerminating, newNS),
+		}},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestConfigAppliedCondition(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	virtualRouter.Generation = 3
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	routerPod := func(name string, appliedGeneration string, failure string) *corev1.Pod {
+		pod := newRouterPod(name, d, "node-"+name, true, fakeNow)
+		pod.Annotations = map[string]string{APPLIED_GENERATION_ANNOTATION: appliedGeneration}
+		if failure != "" {
+			pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{
+				Type:    VIRTUALROUTER_READINESS_GATE,
+				Status:  corev1.ConditionFalse,
+				Reason:  "UnsupportedDataPlaneFeature",
+				Message: failure,
+			})
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name     string
+		pods     []*corev1.Pod
+		status   metav1.ConditionStatus
+		reason   string
+		message  string
+		revision int64
+	}{
+		{"applied", []*corev1.Pod{routerPod("a", "3", ""), routerPod("b", "4", "")},
+			metav1.ConditionTrue, ConfigApplied, "Generation 3 applied to 2 router pods", 3},
+		{"applying", []*corev1.Pod{routerPod("b", "2", ""), routerPod("a", "", "")},
+			metav1.ConditionFalse, ConfigApplying, "Waiting for generation 3 to be applied to a, b", 0},
+		{"failed", []*corev1.Pod{routerPod("a", "3", ""), routerPod("b", "2", "vlan is not supported")},
+			metav1.ConditionFalse, "UnsupportedDataPlaneFeature", "b on node-b: vlan is not supported", 2},
+	}
+	for _, test := range tests {
+		condition := configAppliedCondition(virtualRouter, test.pods, fakeNow)
+		if condition.Status != test.status || condition.Reason != test.reason || condition.Message != test.message || condition.ObservedGeneration != 3 {
+			t.Errorf("%s: unexpected condition %+v", test.name, condition)
+		}
+		if revision := appliedRevision(test.pods); revision != test.revision {
+			t.Errorf("%s: expected applied revision %d, got %d", test.name, test.revision, revision)
+		}
+	}
+}
+
+func TestTransitionEvents(t *testing.T) {
+	applied := func(generation int64, status metav1.ConditionStatus, reason string) []metav1.Condition {
+		return []metav1.Condition{{Type: networkcontroller.ConfigAppliedCondition, Status: status, ObservedGeneration: generation, Reason: reason, Message: "message"}}
+	}
+	tests := []struct {
+		name     string
+		old, new networkcontroller.VirtualRouterStatus
+		expected []string
+	}{
+		{"became ready",
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterPending},
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, ActiveNode: "node-a"},
+			[]string{"Normal BecameReady Router is active on node node-a"}},
+		{"still running",
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, AvailableReplicas: 1},
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning, AvailableReplicas: 2},
+			nil},
+		{"degraded",
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterRunning},
+			networkcontroller.VirtualRouterStatus{Phase: networkcontroller.VirtualRouterDegraded, AvailableReplicas: 1},
+			[]string{"Warning Degraded Only 1 router pods available"}},
+		{"config applied",
+			networkcontroller.VirtualRouterStatus{Conditions: applied(2, metav1.ConditionFalse, ConfigApplying)},
+			networkcontroller.VirtualRouterStatus{Conditions: applied(2, metav1.ConditionTrue, ConfigApplied)},
+			[]string{"Normal ConfigApplied message"}},
+		{"new generation applied",
+			networkcontroller.VirtualRouterStatus{Conditions: applied(2, metav1.ConditionTrue, ConfigApplied)},
+			networkcontroller.VirtualRouterStatus{Conditions: applied(3, metav1.ConditionTrue, ConfigApplied)},
+			[]string{"Normal ConfigApplied message"}},
+		{"still applying",
+			networkcontroller.VirtualRouterStatus{Conditions: applied(3, metav1.ConditionFalse, ConfigApplying)},
+			networkcontroller.VirtualRouterStatus{Conditions: applied(3, metav1.ConditionFalse, ConfigApplying)},
+			nil},
+		{"paused",
+			networkcontroller.VirtualRouterStatus{},
+			networkcontroller.VirtualRouterStatus{Conditions: []metav1.Condition{{Type: networkcontroller.PausedCondition, Status: metav1.ConditionTrue, Reason: Paused}}},
+			[]string{"Normal Paused " + MessagePaused}},
+		{"resumed",
+			networkcontroller.VirtualRouterStatus{Conditions: []metav1.Condition{{Type: networkcontroller.PausedCondition, Status: metav1.ConditionTrue, Reason: Paused}}},
+			networkcontroller.VirtualRouterStatus{Conditions: []metav1.Condition{{Type: networkcontroller.PausedCondition, Status: metav1.ConditionFalse, Reason: Resumed}}},
+			[]string{"Normal Resumed " + MessageResumed}},
+		{"apply failed",
+			networkcontroller.VirtualRouterStatus{Conditions: applied(3, metav1.ConditionFalse, ConfigApplying)},
+			networkcontroller.VirtualRouterStatus{Conditions: applied(3, metav1.ConditionFalse, "UnsupportedDataPlaneFeature")},
+			[]string{"Warning ErrConfigApplyFailed message"}},
+	}
+	for _, test := range tests {
+		recorder := record.NewFakeRecorder(10)
+		c := &Controller{recorder: recorder}
+		c.recordTransitionEvents(newVirtualRouter("test", int32Ptr(1)), test.old, test.new)
+		close(recorder.Events)
+		var events []string
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		if !reflect.DeepEqual(events, test.expected) {
+			t.Errorf("%s: expected events %v, got %v", test.name, test.expected, events)
+		}
+	}
+}
+
+func TestDeploymentNameConflict(t *testing.T) {
+	for name, test := range map[string]struct {
+		ownerDeployment bool
+	}{
+		// the oldest VirtualRouter wins
+		"oldest": {},
+		// the VirtualRouter running the Deployment keeps it, even if
+		// recreated after the other one
+		"deployment controller": {ownerDeployment: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			owner := newVirtualRouter("test", int32Ptr(1))
+			owner.UID = "uid-owner"
+			owner.CreationTimestamp = metav1.NewTime(fakeNow.Add(-time.Hour))
+			virtualRouter := newVirtualRouter("test", int32Ptr(1))
+			virtualRouter.Namespace = "other"
+			virtualRouter.UID = "uid-other"
+			virtualRouter.CreationTimestamp = metav1.NewTime(fakeNow.Add(-2 * time.Hour))
+			if !test.ownerDeployment {
+				owner.CreationTimestamp, virtualRouter.CreationTimestamp = virtualRouter.CreationTimestamp, owner.CreationTimestamp
+			} else {
+				d := newDeployment(owner.Name, owner)
+				f.deploymentLister = append(f.deploymentLister, d)
+				f.kubeobjects = append(f.kubeobjects, d)
+			}
+
+			f.virtualRouterLister = append(f.virtualRouterLister, owner, virtualRouter)
+			f.objects = append(f.objects, owner, virtualRouter)
+
+			expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+				Phase: networkcontroller.VirtualRouterPending,
+				Conditions: []metav1.Condition{{
+					Type:               networkcontroller.DeploymentNameConflictCondition,
+					Status:             metav1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(fakeNow),
+					Reason:             ErrDeploymentNameConflict,
+					Message:            "Deployment test/test-deployment is claimed by VirtualRouter default/test",
+				}},
+			})
+			// the router is held back before any phase ran
+			expected.Status.ReconcileTiming = nil
+			f.expectPatchVirtualRouterStatusAction(expected)
+			f.run(getKey(virtualRouter, t))
+		})
+	}
+}
+
+func TestInvalidSpec(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.PolicyRouting = []networkcontroller.RoutingTable{
+		{ID: 10, Routes: []networkcontroller.PolicyRoute{{Gateway: "192.168.9.1"}}},
+		{ID: 10, Routes: []networkcontroller.PolicyRoute{{Gateway: "192.168.8.1"}}},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.InvalidSpecCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ErrInvalidSpec,
+			Message:            "routing table 10: given more than once",
+		}},
+	})
+	// the router is held back before any phase ran
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestImageNotAllowed(t *testing.T) {
+	f := newFixture(t)
+	f.options.AllowedRegistries = []string{"registry.example.com/tmax"}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:v0.2.5"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.DegradedCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ErrImageNotAllowed,
+			Message:            "image tmaxcloudck/virtualrouter:v0.2.5 is of none of the allowed registries registry.example.com/tmax",
+		}},
+	})
+	// the router is held back before any phase ran
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+	f.run(getKey(virtualRouter, t))
+}
+
+type fakeImageVerifier struct {
+	digest   string
+	resolved []string
+	verified []string
+	err      error
+}
+
+func (v *fakeImageVerifier) Resolve(ctx context.Context, image string) (string, error) {
+	v.resolved = append(v.resolved, image)
+	return v.digest, nil
+}
+
+func (v *fakeImageVerifier) Verify(ctx context.Context, image string) error {
+	v.verified = append(v.verified, image)
+	return v.err
+}
+
+func TestImagePolicy(t *testing.T) {
+	for image, allowed := range map[string]bool{
+		"registry.example.com/tmax/virtualrouter:v0.2.5":        true,
+		"registry.example.com/tmax/virtualrouter@sha256:abcdef": true,
+		"registry.example.com/other/virtualrouter:v0.2.5":       false,
+		"registry.example.com:5000/virtualrouter":               true,
+		"tmaxcloudck/virtualrouter:v0.2.5":                      true,
+		"virtualrouter":                                         false,
+		"docker.io/library/virtualrouter":                       false,
+		"localhost/virtualrouter":                               false,
+	} {
+		if got := imageAllowed(image, []string{"registry.example.com/tmax/", "registry.example.com:5000", "docker.io/tmaxcloudck"}); got != allowed {
+			t.Errorf("%s: expected allowed %v, got %v", image, allowed, got)
+		}
+	}
+
+	f := newFixture(t)
+	verifier := &fakeImageVerifier{digest: "sha256:0123", err: fmt.Errorf("no matching signatures")}
+	f.options.ImageVerifier = verifier
+	c, _, _ := f.newController()
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:v0.2.5"
+
+	err := c.checkImagePolicy(virtualRouter)
+	if policyErr, ok := err.(*imagePolicyError); !ok || policyErr.reason != ErrImageNotVerified {
+		t.Fatalf("expected the image not verified, got %v", err)
+	}
+	if image := c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec.Containers[0].Image; image != virtualRouter.Spec.Image {
+		t.Errorf("expected an image not verified never pinned, got %s", image)
+	}
+	verifier.err = nil
+	for i := 0; i < 2; i++ {
+		if err := c.checkImagePolicy(virtualRouter); err != nil {
+			t.Fatalf("expected the image verified, got %v", err)
+		}
+	}
+	if len(verifier.verified) != 2 || verifier.verified[1] != "tmaxcloudck/virtualrouter:v0.2.5@sha256:0123" {
+		t.Errorf("expected the digest verified once, got %v", verifier.verified)
+	}
+	if image := c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec.Containers[0].Image; image != "tmaxcloudck/virtualrouter:v0.2.5@sha256:0123" {
+		t.Errorf("expected the router pinned to the verified digest, got %s", image)
+	}
+
+	// a tag moved to another digest is verified again once resolved again
+	verifier.digest = "sha256:4567"
+	c.clock = clock.NewFakeClock(fakeNow.Add(IMAGE_RESOLUTION_CACHE_TTL))
+	if err := c.checkImagePolicy(virtualRouter); err != nil {
+		t.Fatalf("expected the image verified, got %v", err)
+	}
+	if len(verifier.verified) != 3 || verifier.verified[2] != "tmaxcloudck/virtualrouter:v0.2.5@sha256:4567" {
+		t.Errorf("expected the new digest verified, got %v", verifier.verified)
+	}
+
+	// containers added by the overrides run under the same policy
+	c.options.AllowedRegistries = []string{"docker.io/tmaxcloudck"}
+	virtualRouter.Spec.Overrides = &networkcontroller.VirtualRouterOverrides{PodTemplate: &runtime.RawExtension{
+		Raw: []byte(`{"spec":{"containers":[{"name":"shell","image":"registry.example.com/other/shell"}]}}`),
+	}}
+	err = c.checkImagePolicy(virtualRouter)
+	if policyErr, ok := err.(*imagePolicyError); !ok || policyErr.reason != ErrImageNotAllowed || !strings.Contains(err.Error(), "registry.example.com/other/shell") {
+		t.Errorf("expected the image of the added container not allowed, got %v", err)
+	}
+}
+
+func TestRestrictedSecurityProfile(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	container := newDeployment("test", virtualRouter).Spec.Template.Spec.Containers[0]
+	if securityContext := container.SecurityContext; securityContext.Privileged == nil || !*securityContext.Privileged {
+		t.Errorf("expected the router privileged by default, got %+v", securityContext)
+	}
+
+	virtualRouter.Spec.SecurityProfile = networkcontroller.RestrictedSecurityProfile
+	deployment := newDeployment("test", virtualRouter)
+	container = deployment.Spec.Template.Spec.Containers[0]
+	securityContext := container.SecurityContext
+	if securityContext.Privileged == nil || *securityContext.Privileged || securityContext.AllowPrivilegeEscalation == nil || *securityContext.AllowPrivilegeEscalation {
+		t.Errorf("expected the router unprivileged, got %+v", securityContext)
+	}
+	if !reflect.DeepEqual(securityContext.Capabilities, &corev1.Capabilities{Add: []corev1.Capability{"NET_RAW", "NET_ADMIN"}, Drop: []corev1.Capability{"ALL"}}) {
+		t.Errorf("expected only NET_RAW and NET_ADMIN, got %+v", securityContext.Capabilities)
+	}
+	if securityContext.ReadOnlyRootFilesystem == nil || !*securityContext.ReadOnlyRootFilesystem || securityContext.SeccompProfile == nil || securityContext.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("expected a read-only root filesystem and the runtime default seccomp profile, got %+v", securityContext)
+	}
+	mounts := map[string]string{}
+	for _, mount := range container.VolumeMounts {
+		mounts[mount.MountPath] = mount.Name
+	}
+	if mounts["/run"] == "" || mounts["/tmp"] == "" || len(deployment.Spec.Template.Spec.Volumes) != 2 {
+		t.Errorf("expected /run and /tmp writable, got %+v", container.VolumeMounts)
+	}
+}
+
+func TestRouterSysctls(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	if initContainers := newDeployment("test", virtualRouter).Spec.Template.Spec.InitContainers; len(initContainers) != 0 {
+		t.Errorf("expected no init container without sysctls, got %+v", initContainers)
+	}
+
+	virtualRouter.Spec.SecurityProfile = networkcontroller.RestrictedSecurityProfile
+	virtualRouter.Spec.ExternalIPv6CIDR = "2001:db8::10/64"
+	virtualRouter.Spec.Sysctls = []networkcontroller.RouterSysctl{
+		{Name: "net.ipv4.conf.all.rp_filter", Value: "0"},
+		{Name: "net.ipv4.ip_forward", Value: "0"},
+	}
+	initContainers := newDeployment("test", virtualRouter).Spec.Template.Spec.InitContainers
+	// the router image of the spec never runs privileged
+	if len(initContainers) != 1 || initContainers[0].Name != ROUTER_INIT_CONTAINER_NAME || initContainers[0].Image != DEFAULT_ROUTER_INIT_IMAGE {
+		t.Fatalf("expected the router-init container, got %+v", initContainers)
+	}
+	// the spec overrides the forwarding of the Restricted profile
+	expected := []string{"sysctl", "-w", "net.ipv4.ip_forward=0", "net.ipv6.conf.all.forwarding=1", "net.ipv4.conf.all.rp_filter=0"}
+	if !reflect.DeepEqual(initContainers[0].Command, expected) {
+		t.Errorf("expected %v, got %v", expected, initContainers[0].Command)
+	}
+
+	for sysctls, valid := range map[string]bool{
+		"net.ipv4.conf.eth0.rp_filter=0":             true,
+		"net.ipv4.conf.eth0/1.rp_filter=0":           false,
+		"net.ipv4.conf.all..rp_filter=0":             false,
+		"net.core.//.//.//.//.//.//.//.etc.shadow=0": false,
+		"kernel.pid_max=4194304":                     false,
+		"net.ipv4.ip_forward=":                       false,
+	} {
+		parts := strings.SplitN(sysctls, "=", 2)
+		err := validateSysctls([]networkcontroller.RouterSysctl{{Name: parts[0], Value: parts[1]}})
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid %v, got %v", sysctls, valid, err)
+		}
+	}
+	if err := validateSysctls([]networkcontroller.RouterSysctl{{Name: "net.ipv4.ip_forward", Value: "1"}, {Name: "net.ipv4.ip_forward", Value: "0"}}); err == nil {
+		t.Error("expected a sysctl set twice to be invalid")
+	}
+
+	for sysctls, valid := range map[string]bool{
+		"net.core.rmem_max=26214400":         true,
+		"kernel.pid_max=4194304":             true,
+		"vm..swappiness=0":                   false,
+		"net.core.//.//.//.etc.x=0":          false,
+		"net.ipv4.conf.eth0/100.rp_filter=0": true,
+		"net.core.somaxconn=":                false,
+	} {
+		parts := strings.SplitN(sysctls, "=", 2)
+		err := validateNodeSysctls([]networkcontroller.RouterSysctl{{Name: parts[0], Value: parts[1]}})
+		if (err == nil) != valid {
+			t.Errorf("node %s: expected valid %v, got %v", sysctls, valid, err)
+		}
+	}
+}
+
+func TestPodSysctls(t *testing.T) {
+	f := newFixture(t)
+	c, _, _ := f.newController()
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Sysctls = []networkcontroller.RouterSysctl{
+		{Name: "net.ipv4.ip_local_port_range", Value: "1024 65000"},
+		{Name: "net.ipv4.conf.all.rp_filter", Value: "0"},
+	}
+	// safe sysctls are set by the kubelet, the others by the init container
+	podSpec := c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec
+	if podSpec.SecurityContext == nil || !reflect.DeepEqual(podSpec.SecurityContext.Sysctls, []corev1.Sysctl{{Name: "net.ipv4.ip_local_port_range", Value: "1024 65000"}}) {
+		t.Errorf("expected the safe sysctl in the pod security context, got %+v", podSpec.SecurityContext)
+	}
+	expected := []string{"sysctl", "-w", "net.ipv4.conf.all.rp_filter=0"}
+	if len(podSpec.InitContainers) != 1 || !reflect.DeepEqual(podSpec.InitContainers[0].Command, expected) {
+		t.Errorf("expected the init container to set %v, got %+v", expected, podSpec.InitContainers)
+	}
+
+	c.options.RouterInitImage = "registry.example.com/tools/sysctl:1.0"
+	podSpec = c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec
+	if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Image != "registry.example.com/tools/sysctl:1.0" {
+		t.Errorf("expected the init container to run the image of the controller, got %+v", podSpec.InitContainers)
+	}
+
+	c.Reload(Options{AllowedUnsafeSysctls: []string{"net.ipv4.conf.*"}})
+	podSpec = c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec
+	if len(podSpec.InitContainers) != 0 || len(podSpec.SecurityContext.Sysctls) != 2 {
+		t.Errorf("expected every sysctl in the pod security context, got %+v and %+v", podSpec.SecurityContext, podSpec.InitContainers)
+	}
+
+	if !SysctlAllowed("net.core.somaxconn", []string{"net.core.somaxconn"}) || SysctlAllowed("net.core.somaxconn", []string{"net.ipv4.*"}) {
+		t.Error("expected sysctls allowed by name or prefix only")
+	}
+}
+
+func TestPodTemplateOverrides(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	withOverrides := func(podTemplate string) *networkcontroller.VirtualRouter {
+		overridden := virtualRouter.DeepCopy()
+		overridden.Spec.Overrides = &networkcontroller.VirtualRouterOverrides{PodTemplate: &runtime.RawExtension{Raw: []byte(podTemplate)}}
+		return overridden
+	}
+
+	overridden := withOverrides(`{"metadata":{"labels":{"team":"net"}},"spec":{
+		"volumes":[{"name":"logs","emptyDir":{}}],
+		"containers":[
+			{"name":"test","env":[{"name":"LOG_LEVEL","value":"debug"}],"volumeMounts":[{"name":"logs","mountPath":"/var/log/router"}]},
+			{"name":"log-shipper","image":"fluent-bit","volumeMounts":[{"name":"logs","mountPath":"/logs"}]}]}}`)
+	if err := ValidatePodTemplateOverrides(overridden, nil); err != nil {
+		t.Fatalf("expected the overrides valid, got %v", err)
+	}
+	template := newDeployment("test", overridden).Spec.Template
+	if template.Labels["team"] != "net" || template.Labels["app"] != VIRTUALROUTER_LABEL {
+		t.Errorf("expected the label added to the router ones, got %v", template.Labels)
+	}
+	if len(template.Spec.Containers) != 2 || template.Spec.Containers[1].Name != "log-shipper" {
+		t.Fatalf("expected the sidecar added, got %+v", template.Spec.Containers)
+	}
+	router := template.Spec.Containers[0]
+	if router.Image != virtualRouter.Spec.Image || len(router.Env) != 2 || router.Env[0].Name != "LOG_LEVEL" || len(router.VolumeMounts) != 1 {
+		t.Errorf("expected the env and mount merged into the router container, got %+v", router)
+	}
+	if newDeployment("test", overridden).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] == newDeployment("test", virtualRouter).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] {
+		t.Error("expected the overrides to roll the router pods")
+	}
+
+	for podTemplate, expected := range map[string]string{
+		`{"spec":{"containers":[{"name":"test","image":"other"}]}}`:                                                             "image of router container test can't be overridden",
+		`{"spec":{"containers":[{"name":"test","$patch":"delete"}]}}`:                                                           "router container test can't be removed",
+		`{"metadata":{"labels":{"app":"other"}}}`:                                                                               "label app can't be changed",
+		`{"spec":{"nodeSelector":{"zone":"a"}}}`:                                                                                "spec.nodeSelector can't be overridden",
+		`{"spec":{"serviceAccountName":"default"}}`:                                                                             "spec.serviceAccountName can't be overridden",
+		`{"spec":{"containers":[{"name":"test","env":[{"name":"POD_NAMESPACE","value":"x"}]}]}}`:                                "env POD_NAMESPACE of router container test can't be changed",
+		`{"spec":{"containers":"none"}}`:                                                                                        "spec.overrides.podTemplate",
+		`{"spec":{"volumes":[{"name":"root","hostPath":{"path":"/"}}]}}`:                                                        "hostPath volume root can't be added",
+		`{"spec":{"containers":[{"name":"shell","image":"busybox","securityContext":{"privileged":true}}]}}`:                    "container shell can't be made privileged",
+		`{"spec":{"containers":[{"name":"shell","image":"busybox","securityContext":{"capabilities":{"add":["SYS_ADMIN"]}}}]}}`: "capability SYS_ADMIN of container shell can't be added",
+		`{"spec":{"containers":[{"name":"shell","image":"busybox","ports":[{"containerPort":22,"hostPort":22}]}]}}`:             "hostPort 22 of container shell can't be added",
+	} {
+		if err := ValidatePodTemplateOverrides(withOverrides(podTemplate), nil); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got %v", podTemplate, expected, err)
+		}
+	}
+
+	validator := NewQuotaValidator(informers.NewSharedInformerFactory(fake.NewSimpleClientset(), noResyncPeriodFunc()).Tmax().V1().VirtualRouters(),
+		informers.NewSharedInformerFactory(fake.NewSimpleClientset(), noResyncPeriodFunc()).Tmax().V1().VirtualRouterQuotas(),
+		informers.NewSharedInformerFactory(fake.NewSimpleClientset(), noResyncPeriodFunc()).Tmax().V1().VirtualRouterProfiles())
+	raw, err := json.Marshal(withOverrides(`{"spec":{"hostNetwork":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: "VirtualRouter"},
+		Namespace: virtualRouter.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+	if err := validator.Validate(request); err == nil || !strings.Contains(err.Error(), "spec.hostNetwork can't be overridden") {
+		t.Errorf("expected the webhook to reject the overrides, got %v", err)
+	}
+	// overrides admitted before are left to the InvalidSpec condition
+	request.Operation = admissionv1.Update
+	request.OldObject = runtime.RawExtension{Raw: raw}
+	if err := validator.Validate(request); err != nil {
+		t.Errorf("expected the unchanged overrides admitted, got %v", err)
+	}
+
+	// sidecars of profiles are overridden as the controller renders them
+	profiles := []networkcontroller.RouterSidecar{{Name: "bgp", Image: "registry.example.com/tmax/bird:2.0.8", NetAdmin: true}}
+	profiled := withOverrides(`{"spec":{"containers":[{"name":"speaker","securityContext":{"capabilities":{"add":["SYS_ADMIN"]}}}]}}`)
+	profiled.Spec.Sidecars = []networkcontroller.RouterSidecar{{Name: "speaker", Profile: "bgp"}}
+	if err := ValidatePodTemplateOverrides(profiled, profiles); err == nil || !strings.Contains(err.Error(), "capability SYS_ADMIN of container speaker can't be added") {
+		t.Errorf("expected the capability added to the sidecar of the profile rejected, got %v", err)
+	}
+	raw, err = json.Marshal(profiled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Operation = admissionv1.Create
+	request.Object = runtime.RawExtension{Raw: raw}
+	validator.Reload(Options{SidecarProfiles: profiles})
+	if err := validator.Validate(request); err == nil || !strings.Contains(err.Error(), "capability SYS_ADMIN of container speaker can't be added") {
+		t.Errorf("expected the webhook to check the overrides with the sidecar profiles, got %v", err)
+	}
+}
+
+func TestVirtualRouterProfiles(t *testing.T) {
+	f := newFixture(t)
+	profile := &networkcontroller.VirtualRouterProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+		Spec: networkcontroller.VirtualRouterProfileSpec{
+			Image:           "registry.example.com/tmax/virtualrouter:1.4",
+			Resources:       &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+			SecurityProfile: networkcontroller.RestrictedSecurityProfile,
+		},
+	}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ProfileRef = &networkcontroller.VirtualRouterProfileReference{Name: "edge"}
+	other := newVirtualRouter("other", int32Ptr(1))
+	f.profileLister = append(f.profileLister, profile)
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter, other)
+	c, _, _ := f.newController()
+
+	deployment := c.desiredDeployment("test", virtualRouter, nil)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if container.Image != profile.Spec.Image || container.Resources.Limits.Cpu().String() != "2" {
+		t.Errorf("expected the image and resources of the profile, got %s and %+v", container.Image, container.Resources)
+	}
+	if container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+		t.Error("expected the Restricted security profile of the profile")
+	}
+	if virtualRouter.Spec.Image != "" {
+		t.Error("expected the profile never written into the VirtualRouter")
+	}
+
+	// the fields of the VirtualRouter take precedence
+	own := virtualRouter.DeepCopy()
+	own.Spec.Image = "registry.example.com/tmax/virtualrouter:1.3"
+	own.Spec.SecurityProfile = networkcontroller.PrivilegedSecurityProfile
+	if profiled := ApplyProfile(own, profile); profiled.Spec.Image != own.Spec.Image || IsRestricted(profiled.Spec) || profiled.Spec.Resources == nil {
+		t.Errorf("expected the image and security profile of the VirtualRouter kept, got %+v", profiled.Spec)
+	}
+
+	// routers are rendered again when their profile changes
+	c.handleProfile(profile)
+	if key, _ := c.workqueue.Get(); key != "default/test" || c.workqueue.Len() != 0 {
+		t.Errorf("expected only the VirtualRouter referencing the profile queued, got %v", key)
+	}
+	updated := profile.DeepCopy()
+	updated.Spec.Image = "registry.example.com/tmax/virtualrouter:1.5"
+	if ApplyProfile(virtualRouter, updated).Spec.Image != updated.Spec.Image {
+		t.Error("expected the image of the updated profile")
+	}
+
+	missing := virtualRouter.DeepCopy()
+	missing.Spec.ProfileRef.Name = "missing"
+	if _, err := routerProfile(c.virtualRouterProfilesLister, missing); err == nil || !strings.Contains(err.Error(), "VirtualRouterProfile missing not found") {
+		t.Errorf("expected a missing profile reported, got %v", err)
+	}
+
+	noImage := profile.DeepCopy()
+	noImage.Name = "no-image"
+	noImage.Spec.Image = ""
+	i := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), noResyncPeriodFunc())
+	i.Tmax().V1().VirtualRouterProfiles().Informer().GetIndexer().Add(profile)
+	i.Tmax().V1().VirtualRouterProfiles().Informer().GetIndexer().Add(noImage)
+	validator := NewQuotaValidator(i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterQuotas(), i.Tmax().V1().VirtualRouterProfiles())
+	for _, test := range []struct {
+		profile  string
+		expected string
+	}{
+		{"edge", ""},
+		{"missing", "VirtualRouterProfile missing not found"},
+		{"no-image", "neither the VirtualRouter nor its VirtualRouterProfile no-image gives one"},
+	} {
+		admitted := virtualRouter.DeepCopy()
+		admitted.Spec.ProfileRef.Name = test.profile
+		err := validator.validateProfile(admitted)
+		if (test.expected == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %q, got %v", test.profile, test.expected, err)
+		}
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	f := newFixture(t)
+	f.options.SidecarProfiles = []networkcontroller.RouterSidecar{{
+		Name:     "bgp",
+		Image:    "registry.example.com/tmax/bird:2.0.8",
+		Env:      []corev1.EnvVar{{Name: "ASN", Value: "64512"}, {Name: "ROUTER_ID", Value: "auto"}},
+		NetAdmin: true,
+	}}
+	c, _, _ := f.newController()
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Sidecars = []networkcontroller.RouterSidecar{
+		{Name: "speaker", Profile: "bgp", Env: []corev1.EnvVar{{Name: "ASN", Value: "64600"}}},
+		{Name: "exporter", Image: "registry.example.com/tmax/exporter:1.2", Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9100}}},
+	}
+	if err := ValidateSpec(virtualRouter.Spec); err != nil {
+		t.Fatalf("expected the sidecars valid, got %v", err)
+	}
+
+	containers := c.desiredDeployment("test", virtualRouter, nil).Spec.Template.Spec.Containers
+	if len(containers) != 3 || containers[0].Name != virtualRouter.Name {
+		t.Fatalf("expected the sidecars after the router container, got %+v", containers)
+	}
+	speaker := containers[1]
+	expectedEnv := []corev1.EnvVar{{Name: "ASN", Value: "64600"}, {Name: "ROUTER_ID", Value: "auto"}}
+	if speaker.Name != "speaker" || speaker.Image != "registry.example.com/tmax/bird:2.0.8" || !reflect.DeepEqual(speaker.Env, expectedEnv) {
+		t.Errorf("expected the profile completed with the sidecar, got %+v", speaker)
+	}
+	if speaker.SecurityContext == nil || !reflect.DeepEqual(speaker.SecurityContext.Capabilities.Add, []corev1.Capability{"NET_ADMIN", "NET_RAW"}) {
+		t.Errorf("expected NET_ADMIN granted to the speaker, got %+v", speaker.SecurityContext)
+	}
+	if exporter := containers[2]; exporter.Image != "registry.example.com/tmax/exporter:1.2" || exporter.SecurityContext != nil || len(exporter.Ports) != 1 {
+		t.Errorf("expected the exporter as declared, got %+v", exporter)
+	}
+
+	// a new sidecar version rolls the router pods
+	updated := virtualRouter.DeepCopy()
+	updated.Spec.Sidecars[1].Image = "registry.example.com/tmax/exporter:1.3"
+	if c.desiredDeployment("test", updated, nil).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] == c.desiredDeployment("test", virtualRouter, nil).Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] {
+		t.Error("expected the sidecar image to change the Deployment spec hash")
+	}
+
+	// sidecar images are held to the image policy of the router image
+	c.options.AllowedRegistries = []string{"registry.example.com/tmax"}
+	virtualRouter.Spec.Image = "registry.example.com/tmax/virtualrouter:1.0"
+	updated.Spec.Image = virtualRouter.Spec.Image
+	if err := c.checkImagePolicy(virtualRouter); err != nil {
+		t.Errorf("expected the sidecar images allowed, got %v", err)
+	}
+	updated.Spec.Sidecars[1].Image = "docker.io/exporter:1.3"
+	if err := c.checkImagePolicy(updated); err == nil || !strings.Contains(err.Error(), "docker.io/exporter:1.3") {
+		t.Errorf("expected the sidecar image rejected, got %v", err)
+	}
+
+	updated.Spec.Sidecars[0].Profile = "ipsec"
+	if _, err := c.sidecarContainers(updated); err == nil || !strings.Contains(err.Error(), "no sidecar profile ipsec") {
+		t.Errorf("expected the missing profile reported, got %v", err)
+	}
+	for name, sidecars := range map[string][]networkcontroller.RouterSidecar{
+		"no image":    {{Name: "exporter"}},
+		"twice":       {{Name: "exporter", Image: "exporter"}, {Name: "exporter", Image: "exporter"}},
+		"router-init": {{Name: ROUTER_INIT_CONTAINER_NAME, Image: "exporter"}},
+		"invalid":     {{Name: "Exporter", Image: "exporter"}},
+	} {
+		if err := validateSidecars(sidecars); err == nil {
+			t.Errorf("%s: expected the sidecars invalid", name)
+		}
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.UID = "router"
+	size := resource.MustParse("2Gi")
+	virtualRouter.Spec.Persistence = &networkcontroller.RouterPersistence{Size: &size}
+	virtualRouter.Spec.DHCP = &networkcontroller.DHCP{RangeStart: "10.0.0.100", RangeEnd: "10.0.0.200"}
+	newNS := virtualRouter.Name
+	// restored ahead of the router, with the leases of the router it replaces
+	f.kubeobjects = append(f.kubeobjects, &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: ROUTER_STATE_CLAIM_NAME, Namespace: newNS},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	})
+	c, _, _ := f.newController()
+
+	if err := c.ensureStateClaim(newNS, virtualRouter); err != nil {
+		t.Fatal(err)
+	}
+	claim, err := f.kubeclient.CoreV1().PersistentVolumeClaims(newNS).Get(context.TODO(), ROUTER_STATE_CLAIM_NAME, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(claim, virtualRouter) {
+		t.Errorf("expected the claim adopted, got %+v", claim.OwnerReferences)
+	}
+	if quantity := claim.Spec.Resources.Requests[corev1.ResourceStorage]; quantity.Cmp(size) != 0 {
+		t.Errorf("expected the claim grown to %s, got %s", size.String(), quantity.String())
+	}
+
+	// claims of others are left alone
+	other := virtualRouter.DeepCopy()
+	other.UID = "other"
+	if err := c.ensureStateClaim(newNS, other); err == nil {
+		t.Error("expected the claim of another VirtualRouter refused")
+	}
+
+	deployment := newDeployment(newNS, virtualRouter)
+	if deployment.Spec.Strategy.Type != apps.RecreateDeploymentStrategyType {
+		t.Errorf("expected the old pod to release the claim first, got %+v", deployment.Spec.Strategy)
+	}
+	podSpec := deployment.Spec.Template.Spec
+	if !hasVolume(podSpec.Volumes, corev1.Volume{Name: routerStateVolumeName, VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: ROUTER_STATE_CLAIM_NAME},
+	}}) {
+		t.Errorf("expected the claim in the pod volumes, got %+v", podSpec.Volumes)
+	}
+	if !hasVolumeMount(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: routerStateVolumeName, MountPath: ROUTER_STATE_DIR}) ||
+		!hasEnv(podSpec.Containers[0].Env, corev1.EnvVar{Name: "ROUTER_STATE_DIR", Value: ROUTER_STATE_DIR}) {
+		t.Errorf("expected the state mounted into the router container, got %+v", podSpec.Containers[0])
+	}
+	if config := renderDHCPConfig(virtualRouter)[dnsmasqConfigFile]; !strings.Contains(config, "dhcp-leasefile="+ROUTER_STATE_DIR+"/") {
+		t.Errorf("expected the leases kept in the state, got %s", config)
+	}
+
+	virtualRouter.Spec.Replicas = int32Ptr(2)
+	if err := validatePersistence(virtualRouter.Spec); err == nil {
+		t.Error("expected a shared claim refused")
+	}
+}
+
+func TestStatefulSetMode(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	virtualRouter.UID = "router"
+	virtualRouter.Spec.WorkloadKind = networkcontroller.StatefulSetWorkloadKind
+	virtualRouter.Spec.Persistence = &networkcontroller.RouterPersistence{}
+	if err := validatePersistence(virtualRouter.Spec); err != nil {
+		t.Errorf("expected a claim per router pod, got %v", err)
+	}
+	newNS := virtualRouter.Name
+	// the router ran on a Deployment before
+	d := newDeployment(newNS, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	c, _, _ := f.newController()
+
+	workload, err := c.ensureStatefulSet(newNS, virtualRouter, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statefulSet, err := f.kubeclient.AppsV1().StatefulSets(newNS).Get(context.TODO(), virtualRouter.Spec.DeploymentName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workload.Name != statefulSet.Name || !reflect.DeepEqual(workload.Spec.Selector, d.Spec.Selector) {
+		t.Errorf("expected the StatefulSet read as the Deployment, got %+v", workload.ObjectMeta)
+	}
+	if statefulSet.Spec.PodManagementPolicy != apps.OrderedReadyPodManagement || statefulSet.Spec.ServiceName != statefulSet.Name {
+		t.Errorf("expected router-0 started first, got %+v", statefulSet.Spec)
+	}
+	podSpec := statefulSet.Spec.Template.Spec
+	if len(statefulSet.Spec.VolumeClaimTemplates) != 1 || statefulSet.Spec.VolumeClaimTemplates[0].Name != routerStateVolumeName {
+		t.Errorf("expected a state claim per pod, got %+v", statefulSet.Spec.VolumeClaimTemplates)
+	}
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == routerStateVolumeName {
+			t.Errorf("expected the shared claim left out, got %+v", volume)
+		}
+	}
+	if !hasVolumeMount(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: routerStateVolumeName, MountPath: ROUTER_STATE_DIR}) {
+		t.Errorf("expected the state mounted, got %+v", podSpec.Containers[0].VolumeMounts)
+	}
+	if !hasEnv(podSpec.Containers[0].Env, corev1.EnvVar{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}}) {
+		t.Errorf("expected the pod name given to the router, got %+v", podSpec.Containers[0].Env)
+	}
+
+	// the Deployment is only deleted once a pod of the StatefulSet takes over
+	if err := c.deleteReplacedWorkload(newNS, virtualRouter, workload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.kubeclient.AppsV1().Deployments(newNS).Get(context.TODO(), d.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the Deployment kept, got %v", err)
+	}
+	workload.Status.AvailableReplicas = 1
+	if err := c.deleteReplacedWorkload(newNS, virtualRouter, workload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.kubeclient.AppsV1().Deployments(newNS).Get(context.TODO(), d.Name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the Deployment deleted, got %v", err)
+	}
+
+	// router-0 is the active router whenever it is ready, however young
+	controllerRef := *metav1.NewControllerRef(statefulSet, apps.SchemeGroupVersion.WithKind("StatefulSet"))
+	standby := newRouterPod(statefulSet.Name+"-1", d, "node-b", true, fakeNow.Add(-time.Hour))
+	active := newRouterPod(statefulSet.Name+"-0", d, "node-a", true, fakeNow)
+	standby.OwnerReferences, active.OwnerReferences = []metav1.OwnerReference{controllerRef}, []metav1.OwnerReference{controllerRef}
+	if node := activeNode([]*corev1.Pod{standby, active}); node != "node-a" {
+		t.Errorf("expected router-0 active, got %s", node)
+	}
+	active.Status.Conditions[0].Status = corev1.ConditionFalse
+	if node := activeNode([]*corev1.Pod{standby, active}); node != "node-b" {
+		t.Errorf("expected router-1 to take over, got %s", node)
+	}
+
+	virtualRouter.Spec.Autoscaling = &networkcontroller.Autoscaling{}
+	if err := validateWorkloadKind(virtualRouter.Spec); err == nil {
+		t.Error("expected autoscaling refused with stable identities")
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	for name, test := range map[string]struct {
+		window networkcontroller.MaintenanceWindow
+		valid  bool
+		open   bool
+		opens  time.Time
+	}{
+		// fakeNow is 0:00 on Monday, November 1, 2021 in UTC
+		"saturdays": {
+			window: networkcontroller.MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+			valid:  true,
+			opens:  time.Date(2021, time.November, 6, 2, 0, 0, 0, time.UTC),
+		},
+		"open since sunday": {
+			window: networkcontroller.MaintenanceWindow{Schedule: "30 22 * * 7", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+			valid:  true,
+			open:   true,
+		},
+		"closed as it opens": {
+			window: networkcontroller.MaintenanceWindow{Schedule: "0 23 * * 0", Duration: metav1.Duration{Duration: time.Hour}},
+			valid:  true,
+			opens:  time.Date(2021, time.November, 7, 23, 0, 0, 0, time.UTC),
+		},
+		"day of month or week": {
+			window: networkcontroller.MaintenanceWindow{Schedule: "*/15 3-5 15 * 3", Duration: metav1.Duration{Duration: time.Minute}},
+			valid:  true,
+			opens:  time.Date(2021, time.November, 3, 3, 0, 0, 0, time.UTC),
+		},
+		"time zone": {
+			window: networkcontroller.MaintenanceWindow{Schedule: "0 9 1 11 *", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Asia/Seoul"},
+			valid:  true,
+			open:   true,
+		},
+		"too few fields":   {window: networkcontroller.MaintenanceWindow{Schedule: "0 2 * *", Duration: metav1.Duration{Duration: time.Hour}}},
+		"out of range":     {window: networkcontroller.MaintenanceWindow{Schedule: "0 24 * * *", Duration: metav1.Duration{Duration: time.Hour}}},
+		"never":            {window: networkcontroller.MaintenanceWindow{Schedule: "0 2 30 2 *", Duration: metav1.Duration{Duration: time.Hour}}},
+		"too long":         {window: networkcontroller.MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 8 * 24 * time.Hour}}},
+		"unknown timezone": {window: networkcontroller.MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Mars/Olympus"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{MaintenanceWindow: &test.window})
+			if test.valid != (err == nil) {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+			if !test.valid {
+				return
+			}
+			window, _ := parseMaintenanceWindow(&test.window)
+			open, opens := window.open(fakeNow)
+			if open != test.open || !opens.Equal(test.opens) {
+				t.Errorf("expected open %v, opening at %v, got %v, %v", test.open, test.opens, open, opens)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowDefersRollout(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.1"
+	virtualRouter.Spec.MaintenanceWindow = &networkcontroller.MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 4 * time.Hour}}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	// the new image waits for saturday
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.2"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.PendingChangesCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             AwaitingMaintenanceWindow,
+			Message:            "Deferred until the maintenance window opens at 2021-11-06T02:00:00Z: " + changeRollout,
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestMaintenanceWindowScalesAtOnce(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.MaintenanceWindow = &networkcontroller.MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 4 * time.Hour}}
+	virtualRouter.Status.Conditions = []metav1.Condition{{
+		Type:               networkcontroller.PendingChangesCondition,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(fakeNow.Add(-time.Hour)),
+		Reason:             AwaitingMaintenanceWindow,
+		Message:            "Deferred until the maintenance window opens at 2021-11-06T02:00:00Z: " + changeRollout,
+	}}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	// scaling rolls out no router pod
+	virtualRouter.Spec.Replicas = int32Ptr(2)
+	expDeployment := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectUpdateDeploymentAction(expDeployment)
+	f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(expDeployment, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestNetworkFreeze(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	// neither a rollout nor scaling is made while frozen
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.2"
+	virtualRouter.Spec.Replicas = int32Ptr(2)
+
+	f.freezeLister = append(f.freezeLister, &networkcontroller.NetworkFreeze{
+		ObjectMeta: metav1.ObjectMeta{Name: "incident"},
+		Spec:       networkcontroller.NetworkFreezeSpec{Reason: "INC-42"},
+	})
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.PendingChangesCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             NetworkFrozen,
+			Message:            "Deferred while NetworkFreeze incident is present (INC-42): " + changeRollout,
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+
+	gate := &disruptionGate{freeze: f.freezeLister[0]}
+	if !gate.holdsWorkload(true) || !gate.freezes(changeBackends) || len(gate.deferred) != 2 {
+		t.Errorf("expected scaling and backends to be held back, got %v", gate.deferred)
+	}
+}
+
+func TestNetworkFreezeCreatesMissingObjects(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+
+	f.freezeLister = append(f.freezeLister, &networkcontroller.NetworkFreeze{ObjectMeta: metav1.ObjectMeta{Name: "incident"}})
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	newNS := virtualRouter.Name
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestMACAddresses(t *testing.T) {
+	f := newFixture(t)
+	f.options.MACPools = []networkcontroller.MACPool{{Name: "lab", Prefix: "02:42:0a"}}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.UID = "router"
+	virtualRouter.Spec.MACAddresses = &networkcontroller.RouterMACAddresses{}
+	// the first address derived for the internal interface is taken
+	other := newVirtualRouter("other", int32Ptr(1))
+	other.UID = "other"
+	other.Status.MACAddresses = []networkcontroller.RouterMACAddress{{Internal: deriveMAC(nil, virtualRouter, 0, "internal", 0)}}
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter, other)
+	f.objects = append(f.objects, virtualRouter, other)
+	c, _, _ := f.newController()
+
+	allocated, err := c.ensureMACAddresses(virtualRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocated.Status.MACAddresses) != 1 {
+		t.Fatalf("expected the addresses of a router pod, got %+v", allocated.Status.MACAddresses)
+	}
+	addresses := allocated.Status.MACAddresses[0]
+	internal, _ := net.ParseMAC(addresses.Internal)
+	if addresses.Internal != deriveMAC(nil, virtualRouter, 0, "internal", 1) || internal[0]&0x03 != 0x02 {
+		t.Errorf("expected a locally administered address other than the one taken, got %s", addresses.Internal)
+	}
+	if again, _ := c.ensureMACAddresses(virtualRouter); !reflect.DeepEqual(again.Status.MACAddresses, allocated.Status.MACAddresses) {
+		t.Errorf("expected the same addresses derived again, got %+v", again.Status.MACAddresses)
+	}
+
+	// the addresses of a pool start with its prefix, and are kept once given
+	allocated.Spec.MACAddresses.Pool = "lab"
+	allocated.Spec.WorkloadKind = networkcontroller.StatefulSetWorkloadKind
+	allocated.Spec.Replicas = int32Ptr(2)
+	pooled, err := c.ensureMACAddresses(allocated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pooled.Status.MACAddresses) != 2 {
+		t.Fatalf("expected the addresses of two router pods, got %+v", pooled.Status.MACAddresses)
+	}
+	for _, addresses := range pooled.Status.MACAddresses {
+		if addresses.Pool != "lab" || !strings.HasPrefix(addresses.Internal, "02:42:0a:") || !strings.HasPrefix(addresses.External, "02:42:0a:") {
+			t.Errorf("expected addresses of the pool, got %+v", addresses)
+		}
+	}
+	pooled.Status.MACAddresses[1].External = "02:42:0a:00:00:01"
+	if kept, _ := c.ensureMACAddresses(pooled); kept.Status.MACAddresses[1].External != "02:42:0a:00:00:01" {
+		t.Errorf("expected the given addresses kept, got %+v", kept.Status.MACAddresses)
+	}
+
+	statefulSet := &apps.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "router", UID: "sts"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "router-1", OwnerReferences: []metav1.OwnerReference{
+		*metav1.NewControllerRef(statefulSet, apps.SchemeGroupVersion.WithKind("StatefulSet")),
+	}}}
+	if podAddresses, ok := PodMACAddresses(pooled, pod); !ok || podAddresses != pooled.Status.MACAddresses[1] {
+		t.Errorf("expected the addresses of router-1, got %+v", podAddresses)
+	}
+
+	pooled.Spec.MACAddresses.Pool = "missing"
+	if _, err := c.macPrefix(pooled); err == nil {
+		t.Error("expected an unknown pool refused")
+	}
+	pooled.Spec.MACAddresses = nil
+	if dropped, _ := c.ensureMACAddresses(pooled); dropped.Status.MACAddresses != nil {
+		t.Errorf("expected the addresses dropped, got %+v", dropped.Status.MACAddresses)
+	}
+
+	virtualRouter.Spec.Replicas = int32Ptr(2)
+	if err := validateMACAddresses(virtualRouter.Spec); err == nil {
+		t.Error("expected router pods of a Deployment refused to share addresses")
+	}
+}
+
+func TestMACAllocator(t *testing.T) {
+	allocator := newMACAllocator()
+	give := func(key string, internal string) func(taken func(string) bool) ([]networkcontroller.RouterMACAddress, error) {
+		return func(taken func(string) bool) ([]networkcontroller.RouterMACAddress, error) {
+			if taken(internal) {
+				return nil, fmt.Errorf("%s is taken", internal)
+			}
+			return []networkcontroller.RouterMACAddress{{Internal: internal, External: key}}, nil
+		}
+	}
+
+	// routers are held to the addresses in their status until allocated to
+	other := newVirtualRouter("other", int32Ptr(1))
+	other.Status.MACAddresses = []networkcontroller.RouterMACAddress{{Internal: "02:00:00:00:00:01"}}
+	if _, err := allocator.allocate("default/a", []*networkcontroller.VirtualRouter{other}, give("a", "02:00:00:00:00:01")); err == nil {
+		t.Error("expected the address in the status of another router refused")
+	}
+
+	// an address is reserved as it is allocated, before any status is written
+	if _, err := allocator.allocate("default/a", nil, give("a", "02:00:00:00:00:02")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocator.allocate("default/b", nil, give("b", "02:00:00:00:00:02")); err == nil {
+		t.Error("expected the address reserved by another router refused")
+	}
+	if _, err := allocator.allocate("default/a", nil, give("a", "02:00:00:00:00:02")); err != nil {
+		t.Errorf("expected a router given its own addresses again, got %v", err)
+	}
+
+	// addresses given back are free, but a router stays held to its own
+	// rather than its status once allocated to
+	allocator.release("default/a")
+	if _, err := allocator.allocate("default/b", nil, give("b", "02:00:00:00:00:02")); err != nil {
+		t.Errorf("expected the address given back free, got %v", err)
+	}
+	a := newVirtualRouter("a", int32Ptr(1))
+	a.Status.MACAddresses = []networkcontroller.RouterMACAddress{{Internal: "02:00:00:00:00:03"}}
+	if _, err := allocator.allocate("default/b", []*networkcontroller.VirtualRouter{a}, give("b", "02:00:00:00:00:03")); err != nil {
+		t.Errorf("expected the stale status of a router ignored, got %v", err)
+	}
+	allocator.forget("default/b")
+	if _, err := allocator.allocate("default/a", nil, give("a", "02:00:00:00:00:03")); err != nil {
+		t.Errorf("expected the addresses of a deleted router free, got %v", err)
+	}
+}
+
+func TestNodeQualification(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	if affinity := newDeployment("test", virtualRouter).Spec.Template.Spec.Affinity; affinity.NodeAffinity != nil {
+		t.Errorf("expected spec.affinity as it is with the NodeQualification feature gate off, got %+v", affinity)
+	}
+
+	defer func(gate featuregate.MutableFeatureGate) {
+		features.DefaultMutableFeatureGate, features.DefaultFeatureGate = gate, gate
+	}(features.DefaultMutableFeatureGate)
+	features.DefaultMutableFeatureGate = features.DefaultMutableFeatureGate.DeepCopy()
+	features.DefaultFeatureGate = features.DefaultMutableFeatureGate
+	if err := features.DefaultMutableFeatureGate.Set("NodeQualification=true"); err != nil {
+		t.Fatal(err)
+	}
+	ready := corev1.NodeSelectorRequirement{Key: ROUTER_READY_NODE_LABEL, Operator: corev1.NodeSelectorOpIn, Values: []string{"true"}}
+	affinity := newDeployment("test", virtualRouter).Spec.Template.Spec.Affinity
+	expected := &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{ready}}}}
+	if !reflect.DeepEqual(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution, expected) {
+		t.Errorf("expected nodes ready to run routers required, got %+v", affinity.NodeAffinity)
+	}
+
+	// every term of spec.affinity takes the requirement
+	zone := corev1.NodeSelectorRequirement{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}
+	gateway := corev1.NodeSelectorRequirement{Key: "node-role.kubernetes.io/gateway", Operator: corev1.NodeSelectorOpExists}
+	virtualRouter.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{zone}}, {MatchExpressions: []corev1.NodeSelectorRequirement{gateway}}},
+	}}
+	affinity = newDeployment("test", virtualRouter).Spec.Template.Spec.Affinity
+	expected = &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{zone, ready}},
+		{MatchExpressions: []corev1.NodeSelectorRequirement{gateway, ready}},
+	}}
+	if !reflect.DeepEqual(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution, expected) {
+		t.Errorf("expected the requirement added to every term, got %+v", affinity.NodeAffinity)
+	}
+	if terms := virtualRouter.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms; len(terms[0].MatchExpressions) != 1 {
+		t.Errorf("expected spec.affinity left alone, got %+v", terms)
+	}
+}
+
+func TestExternalSRIOV(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalSRIOV = &networkcontroller.SRIOV{ResourceName: "intel.com/sriov_netdevice"}
+	resources := newDeployment("test", virtualRouter).Spec.Template.Spec.Containers[0].Resources
+	expected := resource.MustParse("1")
+	if quantity := resources.Limits["intel.com/sriov_netdevice"]; quantity.Cmp(expected) != 0 {
+		t.Errorf("expected a VF limit, got %v", resources.Limits)
+	}
+	if quantity := resources.Requests["intel.com/sriov_netdevice"]; quantity.Cmp(expected) != 0 {
+		t.Errorf("expected a VF request, got %v", resources.Requests)
+	}
+
+	for name, test := range map[string]struct {
+		sriov networkcontroller.SRIOV
+		valid bool
+	}{
+		"valid":         {sriov: networkcontroller.SRIOV{ResourceName: "intel.com/sriov_netdevice", VFCount: 2, VLAN: 100, MAC: "52:54:00:12:34:56"}, valid: true},
+		"no vendor":     {sriov: networkcontroller.SRIOV{ResourceName: "sriov_netdevice"}},
+		"vlan too high": {sriov: networkcontroller.SRIOV{ResourceName: "intel.com/sriov_netdevice", VLAN: 4095}},
+		"invalid MAC":   {sriov: networkcontroller.SRIOV{ResourceName: "intel.com/sriov_netdevice", MAC: "52:54:00"}},
+	} {
+		if err := validateSRIOV(&test.sriov); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", name, test.valid, err)
+		}
+	}
+}
+
+func TestPodSecurity(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	namespace := newNamespace("test", virtualRouter)
+	if namespace.Labels[POD_SECURITY_ENFORCE_LABEL] != POD_SECURITY_PRIVILEGED {
+		t.Errorf("expected the namespace of a privileged router to enforce %s, got %v", POD_SECURITY_PRIVILEGED, namespace.Labels)
+	}
+	// baseline forbids NET_ADMIN and NET_RAW as well
+	virtualRouter.Spec.SecurityProfile = networkcontroller.RestrictedSecurityProfile
+	if level := routerPodSecurityLevel("test", virtualRouter); level != POD_SECURITY_PRIVILEGED {
+		t.Errorf("expected the Restricted router to need %s, got %s", POD_SECURITY_PRIVILEGED, level)
+	}
+	if setPodSecurityLabels(namespace, POD_SECURITY_PRIVILEGED) {
+		t.Error("expected the labels left as they are")
+	}
+
+	f := newFixture(t)
+	virtualRouter = newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Placement.Strategy = networkcontroller.TenantPlacementStrategy
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   metav1.NamespaceDefault,
+		Labels: map[string]string{POD_SECURITY_ENFORCE_LABEL: POD_SECURITY_BASELINE},
+	}})
+
+	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "namespaces"}, "", metav1.NamespaceDefault))
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.DegradedCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ErrPodSecurityViolation,
+			Message:            "namespace default enforces the baseline Pod Security Standard, router pods need privileged",
+		}},
+	})
+	// the router is held back before any phase ran
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestValidateSpec(t *testing.T) {
+	route := []networkcontroller.PolicyRoute{{Destination: "10.10.0.0/16", Gateway: "192.168.9.1"}}
+	for name, test := range map[string]struct {
+		tables []networkcontroller.RoutingTable
+		valid  bool
+	}{
+		"none": {valid: true},
+		"valid": {
+			tables: []networkcontroller.RoutingTable{
+				{ID: 10, Routes: route, Rules: []networkcontroller.PolicyRule{{Source: "10.0.1.0/24"}, {Mark: 10, Priority: 100}}},
+				{ID: 20, Routes: []networkcontroller.PolicyRoute{{Gateway: "192.168.8.1"}}},
+			},
+			valid: true,
+		},
+		"router table":        {tables: []networkcontroller.RoutingTable{{ID: ROUTER_ROUTING_TABLE, Routes: route}}},
+		"reserved table":      {tables: []networkcontroller.RoutingTable{{ID: 254, Routes: route}}},
+		"duplicate table":     {tables: []networkcontroller.RoutingTable{{ID: 10, Routes: route}, {ID: 10}}},
+		"invalid destination": {tables: []networkcontroller.RoutingTable{{ID: 10, Routes: []networkcontroller.PolicyRoute{{Destination: "10.10.0.0", Gateway: "192.168.9.1"}}}}},
+		"invalid gateway":     {tables: []networkcontroller.RoutingTable{{ID: 10, Routes: []networkcontroller.PolicyRoute{{Gateway: "gateway"}}}}},
+		"rule matching all":   {tables: []networkcontroller.RoutingTable{{ID: 10, Routes: route, Rules: []networkcontroller.PolicyRule{{Priority: 100}}}}},
+		"router mark":         {tables: []networkcontroller.RoutingTable{{ID: 10, Routes: route, Rules: []networkcontroller.PolicyRule{{Mark: ROUTER_ROUTING_MARK}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{PolicyRouting: test.tables})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestValidateDualStack(t *testing.T) {
+	for name, test := range map[string]struct {
+		internal, external, gateway string
+		valid                       bool
+	}{
+		"single stack":        {valid: true},
+		"dual-stack":          {internal: "fd00:10::1/64", external: "2001:db8::10/64", gateway: "2001:db8::1", valid: true},
+		"link-local gateway":  {internal: "fd00:10::1/64", external: "2001:db8::10/64", gateway: "fe80::1", valid: true},
+		"no gateway":          {internal: "fd00:10::1/64", external: "2001:db8::10/64", valid: true},
+		"internal only":       {internal: "fd00:10::1/64"},
+		"gateway only":        {gateway: "2001:db8::1"},
+		"IPv4 address":        {internal: "10.0.0.1/24", external: "2001:db8::10/64"},
+		"no prefix length":    {internal: "fd00:10::1", external: "2001:db8::10/64"},
+		"link-local address":  {internal: "fe80::1/64", external: "2001:db8::10/64"},
+		"anycast address":     {internal: "fd00:10::/64", external: "2001:db8::10/64"},
+		"overlapping":         {internal: "2001:db8::1/48", external: "2001:db8::10/64"},
+		"gateway off network": {internal: "fd00:10::1/64", external: "2001:db8::10/64", gateway: "2001:db9::1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{
+				InternalIP:       "10.0.0.1",
+				ExternalIP:       "192.168.9.10",
+				InternalIPv6CIDR: test.internal,
+				ExternalIPv6CIDR: test.external,
+				GatewayIPv6:      test.gateway,
+			})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+
+	if err := ValidateSpec(networkcontroller.VirtualRouterSpec{ExternalIP: "2001:db8::10"}); err == nil {
+		t.Errorf("expected an IPv6 external IP to be rejected")
+	}
+}
+
+func TestValidateQoS(t *testing.T) {
+	class := func(cidr, rate string) networkcontroller.QoSClass {
+		return networkcontroller.QoSClass{CIDR: cidr, Rate: resource.MustParse(rate)}
+	}
+	ceil := resource.MustParse("200M")
+	negative := resource.MustParse("-1")
+	for name, test := range map[string]struct {
+		limit networkcontroller.QoSLimit
+		valid bool
+	}{
+		"rate only":       {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M")}, valid: true},
+		"classes":         {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Classes: []networkcontroller.QoSClass{class("10.0.0.0/25", "60M"), class("10.0.0.128/25", "40M")}}, valid: true},
+		"no rate":         {limit: networkcontroller.QoSLimit{}},
+		"negative burst":  {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Burst: &negative}},
+		"IPv6 class":      {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Classes: []networkcontroller.QoSClass{class("fd00:10::/64", "10M")}}},
+		"overlapping":     {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Classes: []networkcontroller.QoSClass{class("10.0.0.0/24", "10M"), class("10.0.0.128/25", "10M")}}},
+		"oversubscribed":  {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Classes: []networkcontroller.QoSClass{class("10.0.0.0/25", "60M"), class("10.0.0.128/25", "60M")}}},
+		"ceil above rate": {limit: networkcontroller.QoSLimit{Rate: resource.MustParse("100M"), Classes: []networkcontroller.QoSClass{{CIDR: "10.0.0.0/25", Rate: resource.MustParse("10M"), Ceil: &ceil}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			limit := test.limit
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{QoS: &networkcontroller.QoS{Egress: &limit}})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestValidateFirewallHardening(t *testing.T) {
+	for name, test := range map[string]struct {
+		hardening networkcontroller.FirewallHardening
+		valid     bool
+	}{
+		"all set":              {hardening: networkcontroller.FirewallHardening{DropInvalid: true, MaxConnectionsPerSource: 100, SYNRate: 50, SYNBurst: 100}, valid: true},
+		"negative connections": {hardening: networkcontroller.FirewallHardening{MaxConnectionsPerSource: -1}},
+		"rate too high":        {hardening: networkcontroller.FirewallHardening{SYNRate: HARDENING_MAX_SYN_RATE + 1}},
+		"burst without rate":   {hardening: networkcontroller.FirewallHardening{SYNBurst: 100}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hardening := test.hardening
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{FirewallHardening: &hardening})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestValidateTunnels(t *testing.T) {
+	gre := networkcontroller.Tunnel{Name: "gre1", Mode: networkcontroller.TunnelGRE, Remote: "203.0.113.1", Key: 10, Address: "169.254.10.1/30", Routes: []string{"10.20.0.0/16"}}
+	for name, test := range map[string]struct {
+		tunnels []networkcontroller.Tunnel
+		valid   bool
+	}{
+		"GRE and IPIP":      {tunnels: []networkcontroller.Tunnel{gre, {Name: "ipip1", Mode: networkcontroller.TunnelIPIP, Local: "192.168.9.10", Remote: "203.0.113.2"}}, valid: true},
+		"duplicate name":    {tunnels: []networkcontroller.Tunnel{gre, gre}},
+		"reserved name":     {tunnels: []networkcontroller.Tunnel{{Name: "ethext", Mode: networkcontroller.TunnelIPIP, Remote: "203.0.113.2"}}},
+		"IPv6 remote":       {tunnels: []networkcontroller.Tunnel{{Name: "ipip1", Mode: networkcontroller.TunnelIPIP, Remote: "2001:db8::1"}}},
+		"IPIP with a key":   {tunnels: []networkcontroller.Tunnel{{Name: "ipip1", Mode: networkcontroller.TunnelIPIP, Remote: "203.0.113.2", Key: 1}}},
+		"invalid route":     {tunnels: []networkcontroller.Tunnel{{Name: "gre1", Mode: networkcontroller.TunnelGRE, Remote: "203.0.113.1", Routes: []string{"10.20.0.0"}}}},
+		"ttl out of range":  {tunnels: []networkcontroller.Tunnel{{Name: "gre1", Mode: networkcontroller.TunnelGRE, Remote: "203.0.113.1", TTL: 256}}},
+		"address no prefix": {tunnels: []networkcontroller.Tunnel{{Name: "gre1", Mode: networkcontroller.TunnelGRE, Remote: "203.0.113.1", Address: "169.254.10.1"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{Tunnels: test.tunnels})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestValidateWireGuard(t *testing.T) {
+	privateKey, publicKey, err := generateWireGuardKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validWireGuardKey(privateKey) || !validWireGuardKey(publicKey) || privateKey == publicKey {
+		t.Fatalf("unexpected key pair %q %q", privateKey, publicKey)
+	}
+	peer := networkcontroller.WireGuardPeer{PublicKey: publicKey, Endpoint: "203.0.113.1:51820", AllowedIPs: []string{"10.30.0.0/16"}}
+	for name, test := range map[string]struct {
+		wireGuard networkcontroller.WireGuard
+		valid     bool
+	}{
+		"peers":             {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{peer, {PublicKey: privateKey, AllowedIPs: []string{"fd00:30::/64"}}}}, valid: true},
+		"no address":        {wireGuard: networkcontroller.WireGuard{Peers: []networkcontroller.WireGuardPeer{peer}}},
+		"duplicate peer":    {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{peer, peer}}},
+		"invalid key":       {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{{PublicKey: "key", AllowedIPs: []string{"10.30.0.0/16"}}}}},
+		"endpoint no port":  {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{{PublicKey: publicKey, Endpoint: "203.0.113.1", AllowedIPs: []string{"10.30.0.0/16"}}}}},
+		"no allowed IPs":    {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{{PublicKey: publicKey}}}},
+		"port out of range": {wireGuard: networkcontroller.WireGuard{Address: "10.100.0.1/24", ListenPort: 65536}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(networkcontroller.VirtualRouterSpec{WireGuard: &test.wireGuard})
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+
+	t.Run("VPN feature gate off", func(t *testing.T) {
+		defer func(gate featuregate.MutableFeatureGate) {
+			features.DefaultMutableFeatureGate, features.DefaultFeatureGate = gate, gate
+		}(features.DefaultMutableFeatureGate)
+		features.DefaultMutableFeatureGate = features.DefaultMutableFeatureGate.DeepCopy()
+		features.DefaultFeatureGate = features.DefaultMutableFeatureGate
+		if err := features.DefaultMutableFeatureGate.Set("VPN=false"); err != nil {
+			t.Fatal(err)
+		}
+		wireGuard := networkcontroller.WireGuard{Address: "10.100.0.1/24", Peers: []networkcontroller.WireGuardPeer{peer}}
+		if err := ValidateSpec(networkcontroller.VirtualRouterSpec{WireGuard: &wireGuard}); err == nil {
+			t.Error("expected a VPN to be invalid with the VPN feature gate off")
+		}
+	})
+}
+
+func TestWireGuardStatus(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	virtualRouter.Spec.WireGuard = &networkcontroller.WireGuard{
+		Address: "10.100.0.1/24",
+		Peers: []networkcontroller.WireGuardPeer{
+			{PublicKey: "peer-b", AllowedIPs: []string{"10.31.0.0/16"}},
+			{PublicKey: "peer-a", AllowedIPs: []string{"10.30.0.0/16"}},
+		},
+	}
+	virtualRouter.Status.WireGuard = &networkcontroller.WireGuardStatus{PublicKey: "router"}
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	active := newRouterPod("a", d, "node-a", true, fakeNow.Add(-time.Hour))
+	active.Annotations = map[string]string{WIREGUARD_HANDSHAKES_ANNOTATION: fmt.Sprintf(`{"peer-a":%d}`, fakeNow.Unix())}
+	standby := newRouterPod("b", d, "node-b", true, fakeNow)
+	standby.Annotations = map[string]string{WIREGUARD_HANDSHAKES_ANNOTATION: fmt.Sprintf(`{"peer-b":%d}`, fakeNow.Unix())}
+
+	// only the handshakes of the active pod are reported
+	status := wireGuardStatus(virtualRouter, []*corev1.Pod{standby, active})
+	if status.PublicKey != "router" || len(status.Peers) != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if peer := status.Peers[0]; peer.PublicKey != "peer-a" || peer.LatestHandshakeTime == nil || !peer.LatestHandshakeTime.Time.Equal(time.Unix(fakeNow.Unix(), 0)) {
+		t.Errorf("unexpected peer status %+v", peer)
+	}
+	if peer := status.Peers[1]; peer.PublicKey != "peer-b" || peer.LatestHandshakeTime != nil {
+		t.Errorf("unexpected peer status %+v", peer)
+	}
+
+	virtualRouter.Spec.WireGuard = nil
+	virtualRouter.Status.WireGuard = nil
+	if status := wireGuardStatus(virtualRouter, []*corev1.Pod{active}); status != nil {
+		t.Errorf("expected no status, got %+v", status)
+	}
+}
+
+func TestValidateStaticNeighbors(t *testing.T) {
+	for name, test := range map[string]struct {
+		spec  networkcontroller.VirtualRouterSpec
+		valid bool
+	}{
+		"external": {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:12:34:56"}}}, valid: true},
+		"same ip on both networks": {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{
+			{IP: "192.168.9.1", MAC: "52:54:00:12:34:56"},
+			{IP: "192.168.9.1", MAC: "52:54:00:12:34:57", Interface: networkcontroller.NeighborInternal},
+		}}, valid: true},
+		"ipv6 dual-stack": {spec: networkcontroller.VirtualRouterSpec{InternalIPv6CIDR: "fd00:10::1/64", ExternalIPv6CIDR: "2001:db8::10/64", StaticNeighbors: []networkcontroller.StaticNeighbor{
+			{IP: "fd00:10::5", MAC: "52:54:00:12:34:56", Interface: networkcontroller.NeighborInternal},
+		}}, valid: true},
+		"ipv6 single-stack": {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "2001:db8::1", MAC: "52:54:00:12:34:56"}}}},
+		"duplicate":         {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:12:34:56"}, {IP: "192.168.9.1", MAC: "52:54:00:12:34:57"}}}},
+		"invalid ip":        {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9", MAC: "52:54:00:12:34:56"}}}},
+		"multicast mac":     {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9.1", MAC: "01:00:5e:00:00:01"}}}},
+		"long mac":          {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9.1", MAC: "02:00:5e:10:00:00:00:01"}}}},
+		"interface":         {spec: networkcontroller.VirtualRouterSpec{StaticNeighbors: []networkcontroller.StaticNeighbor{{IP: "192.168.9.1", MAC: "52:54:00:12:34:56", Interface: "Management"}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(test.spec)
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestValidateMTU(t *testing.T) {
+	for name, test := range map[string]struct {
+		spec  networkcontroller.VirtualRouterSpec
+		valid bool
+	}{
+		"given":              {spec: networkcontroller.VirtualRouterSpec{MTU: &networkcontroller.MTU{Internal: 9000, External: 1450}}, valid: true},
+		"auto":               {spec: networkcontroller.VirtualRouterSpec{GatewayIP: "192.168.9.1", MTU: &networkcontroller.MTU{Auto: true}}, valid: true},
+		"auto no gateway":    {spec: networkcontroller.VirtualRouterSpec{MTU: &networkcontroller.MTU{Auto: true}}},
+		"too small":          {spec: networkcontroller.VirtualRouterSpec{MTU: &networkcontroller.MTU{External: 500}}},
+		"too large":          {spec: networkcontroller.VirtualRouterSpec{MTU: &networkcontroller.MTU{Internal: 9217}}},
+		"ipv4 minimum":       {spec: networkcontroller.VirtualRouterSpec{MTU: &networkcontroller.MTU{External: 576}}, valid: true},
+		"dual-stack minimum": {spec: networkcontroller.VirtualRouterSpec{InternalIPv6CIDR: "fd00:10::1/64", ExternalIPv6CIDR: "2001:db8::10/64", MTU: &networkcontroller.MTU{External: 576}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateSpec(test.spec)
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestPodDisruptionBudget(t *testing.T) {
+	tests := map[string]struct {
+		replicas     *int32
+		minAvailable int32
+		budget       bool
+	}{
+		"single pod":         {int32Ptr(1), 0, false},
+		"active standby":     {int32Ptr(2), 1, true},
+		"three replicas":     {int32Ptr(3), 2, true},
+		"replicas not given": {nil, 0, false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			virtualRouter := newVirtualRouter("test", test.replicas)
+			pdb := newPodDisruptionBudget(newDeployment(virtualRouter.Name, virtualRouter), virtualRouter)
+			if (pdb != nil) != test.budget {
+				t.Fatalf("expected budget %v, got %v", test.budget, pdb)
+			}
+			if pdb != nil && pdb.Spec.MinAvailable.IntValue() != int(test.minAvailable) {
+				t.Errorf("expected minAvailable %d, got %s", test.minAvailable, pdb.Spec.MinAvailable.String())
+			}
+		})
+	}
+}
+
+func TestDeletesPodDisruptionBudgetOfSinglePod(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	pdb := newPodDisruptionBudget(d, virtualRouter)
+	virtualRouter.Spec.Replicas = int32Ptr(1)
+	d = newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.pdbLister = append(f.pdbLister, pdb)
+	f.kubeobjects = append(f.kubeobjects, d, pdb)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.kubeactions = append(f.kubeactions, core.NewDeleteAction(schema.GroupVersionResource{Resource: "poddisruptionbudgets"}, newNS, pdb.Name))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestValidateAutoscaling(t *testing.T) {
+	for name, test := range map[string]struct {
+		autoscaling networkcontroller.Autoscaling
+		dhcp        bool
+		valid       bool
+	}{
+		"packets per second": {autoscaling: networkcontroller.Autoscaling{MaxReplicas: 4, TargetPacketsPerSecond: 100000}, valid: true},
+		"sessions":           {autoscaling: networkcontroller.Autoscaling{MinReplicas: int32Ptr(2), MaxReplicas: 4, TargetSessions: 50000}, valid: true},
+		"no target":          {autoscaling: networkcontroller.Autoscaling{MaxReplicas: 4}},
+		"max below min":      {autoscaling: networkcontroller.Autoscaling{MinReplicas: int32Ptr(3), MaxReplicas: 2, TargetSessions: 50000}},
+		"dhcp":               {autoscaling: networkcontroller.Autoscaling{MaxReplicas: 4, TargetSessions: 50000}, dhcp: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			autoscaling := test.autoscaling
+			spec := networkcontroller.VirtualRouterSpec{Autoscaling: &autoscaling}
+			if test.dhcp {
+				spec.InternalIP = "10.0.0.1"
+				spec.InternalNetmask = "255.255.255.0"
+				spec.DHCP = &networkcontroller.DHCP{RangeStart: "10.0.0.100", RangeEnd: "10.0.0.200"}
+			}
+			err := ValidateSpec(spec)
+			if test.valid && err != nil {
+				t.Errorf("expected valid spec, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected invalid spec")
+			}
+		})
+	}
+}
+
+func TestCreatesHorizontalPodAutoscaler(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.Autoscaling = &networkcontroller.Autoscaling{MinReplicas: int32Ptr(2), MaxReplicas: 4, TargetPacketsPerSecond: 100000}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	// scaled out by the autoscaler
+	d.Spec.Replicas = int32Ptr(3)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	hpa := newHorizontalPodAutoscaler(d, virtualRouter)
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 4 || len(hpa.Spec.Metrics) != 1 || hpa.Spec.Metrics[0].Pods.Metric.Name != PACKETS_PER_SECOND_METRIC {
+		t.Fatalf("unexpected autoscaler spec %+v", hpa.Spec)
+	}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(d, virtualRouter))
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "horizontalpodautoscalers"}, newNS, hpa))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func newNode(name string, ready corev1.ConditionStatus, since time.Time) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             ready,
+				Reason:             "NodeStatusUnknown",
+				Message:            "Kubelet stopped posting node status.",
+				LastTransitionTime: metav1.NewTime(since),
+			}},
+		},
+	}
+}
+
+func TestNodeNotReady(t *testing.T) {
+	for name, test := range map[string]struct {
+		node     *corev1.Node
+		notReady bool
+	}{
+		"ready":        {newNode("node-a", corev1.ConditionTrue, fakeNow), false},
+		"not ready":    {newNode("node-a", corev1.ConditionFalse, fakeNow), true},
+		"unknown":      {newNode("node-a", corev1.ConditionUnknown, fakeNow), true},
+		"not reported": {&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			since, message, notReady := nodeNotReady(test.node)
+			if notReady != test.notReady {
+				t.Fatalf("expected not ready %v, got %v", test.notReady, notReady)
+			}
+			if notReady && (!since.Equal(fakeNow) || message != "Kubelet stopped posting node status.") {
+				t.Errorf("unexpected not ready since %s: %s", since, message)
+			}
+		})
+	}
+}
+
+func TestForceDeletesRouterPodOfFailedNode(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	pod := newRouterPod("router-a", d, "node-a", true, fakeNow.Add(-time.Hour))
+	pod.Finalizers = []string{VIRTUALROUTER_DAEMON_FINALIZER}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.podLister = append(f.podLister, pod)
+	f.nodeLister = append(f.nodeLister, newNode("node-a", corev1.ConditionUnknown, fakeNow.Add(-time.Minute)))
+	f.kubeobjects = append(f.kubeobjects, d, pod)
+	f.addChildObjects(newNS, virtualRouter)
+
+	podCopy := pod.DeepCopy()
+	podCopy.Finalizers = nil
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.kubeactions = append(f.kubeactions,
+		core.NewUpdateAction(schema.GroupVersionResource{Resource: "pods"}, newNS, podCopy),
+		core.NewDeleteAction(schema.GroupVersionResource{Resource: "pods"}, newNS, pod.Name))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:      networkcontroller.VirtualRouterPending,
+		ActiveNode: "node-a",
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.ConfigAppliedCondition,
+			Status:             metav1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ConfigApplying,
+			Message:            "Waiting for generation 0 to be applied to router-a",
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestKeepsRouterPodWithinNodeFailureGracePeriod(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	pod := newRouterPod("router-a", d, "node-a", true, fakeNow.Add(-time.Hour))
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.podLister = append(f.podLister, pod)
+	f.nodeLister = append(f.nodeLister, newNode("node-a", corev1.ConditionUnknown, fakeNow.Add(-time.Second)))
+	f.kubeobjects = append(f.kubeobjects, d, pod)
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:      networkcontroller.VirtualRouterPending,
+		ActiveNode: "node-a",
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.ConfigAppliedCondition,
+			Status:             metav1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ConfigApplying,
+			Message:            "Waiting for generation 0 to be applied to router-a",
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestRenderNamespaceTemplate(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	for name, test := range map[string]struct {
+		template  string
+		namespace string
+		name      string
+		expected  string
+	}{
+		"default":   {DEFAULT_NAMESPACE_TEMPLATE, "tenant-a", "router", "tenant-a-router"},
+		"name only": {"{name}", "tenant-a", "router", "router"},
+		"prefixed":  {"vr-{namespace}-{name}", "tenant-a", "router", "vr-tenant-a-router"},
+		"cut short": {DEFAULT_NAMESPACE_TEMPLATE, long, long, strings.Repeat("a", 40) + "-" + strings.Repeat("a", 13) + "-5d7c0a38"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			virtualRouter := newVirtualRouter(test.name, int32Ptr(1))
+			virtualRouter.Namespace = test.namespace
+			namespace := renderNamespaceTemplate(test.template, virtualRouter)
+			if namespace != test.expected {
+				t.Errorf("expected namespace %s, got %s", test.expected, namespace)
+			}
+			if len(namespace) > 63 {
+				t.Errorf("namespace %s is too long", namespace)
+			}
+		})
+	}
+}
+
+func TestValidateNamespaceTemplate(t *testing.T) {
+	for template, valid := range map[string]bool{
+		DEFAULT_NAMESPACE_TEMPLATE: true,
+		"{name}":                   true,
+		"vr-{name}":                true,
+		"{namespace}":              false,
+		"{namespace}_{name}":       false,
+	} {
+		if err := ValidateNamespaceTemplate(template); (err == nil) != valid {
+			t.Errorf("template %q: expected valid %v, got %v", template, valid, err)
+		}
+	}
+}
+
+func TestPinsRouterNamespace(t *testing.T) {
+	for name, test := range map[string]struct {
+		namespaceOwner types.UID
+		expected       string
+	}{
+		"new router":                         {"", "default-test"},
+		"router created before the template": {"router-uid", "test"},
+		"namespace of another router":        {"other-uid", "default-test"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			virtualRouter := newVirtualRouter("test", int32Ptr(1))
+			virtualRouter.UID = "router-uid"
+			virtualRouter.Annotations = nil
+			f.objects = append(f.objects, virtualRouter)
+			if test.namespaceOwner != "" {
+				owner := virtualRouter.DeepCopy()
+				owner.UID = test.namespaceOwner
+				f.kubeobjects = append(f.kubeobjects, newNamespace("test", owner))
+			}
+			c, _, _ := f.newController()
+
+			pinned, err := c.pinRouterNamespace(virtualRouter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if namespace := pinned.Annotations[ROUTER_NAMESPACE_ANNOTATION]; namespace != test.expected || RouterNamespace(pinned) != test.expected {
+				t.Errorf("expected namespace %s, got %s", test.expected, namespace)
+			}
+		})
+	}
+}
+
+func TestReportsNamespaceConflict(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	// a namespace of the same name created by someone else
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: newNS}}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, namespace)
+
+	f.kubeactions = append(f.kubeactions, core.NewGetAction(schema.GroupVersionResource{Resource: "namespaces"}, "", newNS))
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.NamespaceConflictCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ErrNamespaceConflict,
+			Message:            fmt.Sprintf(MessageNamespaceConflict, newNS),
+		}},
+	})
+	// the router is held back before any phase ran
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestBindsRouterClusterRole(t *testing.T) {
+	f := newFixture(t)
+	f.options.RouterClusterRole = true
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	finalized := virtualRouter.DeepCopy()
+	finalized.Finalizers = []string{VIRTUALROUTER_CLUSTER_ROLE_FINALIZER}
+	f.expectUpdateVirtualRouterAction(finalized)
+	for _, clusterRole := range newRouterClusterRoles() {
+		f.kubeactions = append(f.kubeactions,
+			core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterroles"}, clusterRole.Name),
+			core.NewRootCreateAction(schema.GroupVersionResource{Resource: "clusterroles"}, clusterRole))
+	}
+	binding := newRouterClusterRoleBinding(newNS, virtualRouter)
+	if !strings.HasPrefix(binding.Name, "virtualrouter-default-test-") || binding.Subjects[0].Namespace != newNS {
+		t.Fatalf("unexpected binding %+v", binding)
+	}
+	f.kubeactions = append(f.kubeactions,
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, binding.Name),
+		core.NewRootCreateAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, binding),
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, "virtualrouter-default-test"))
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectPatchVirtualRouterStatusAction(withStatus(finalized, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestDeletesRouterClusterRoleBinding(t *testing.T) {
+	f := newFixture(t)
+	f.options.RouterClusterRole = true
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	deletionTime := metav1.NewTime(fakeNow)
+	virtualRouter.DeletionTimestamp = &deletionTime
+	virtualRouter.Finalizers = []string{VIRTUALROUTER_CLUSTER_ROLE_FINALIZER}
+	newNS := virtualRouter.Name
+	binding := newRouterClusterRoleBinding(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, binding)
+
+	f.kubeactions = append(f.kubeactions,
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, binding.Name),
+		core.NewRootDeleteAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, binding.Name),
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, "virtualrouter-default-test"))
+	released := virtualRouter.DeepCopy()
+	released.Finalizers = nil
+	f.expectUpdateVirtualRouterAction(released)
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestRouterClusterRoleBindingsKeptApart(t *testing.T) {
+	// a-b/c and a/b-c once shared virtualrouter-a-b-c
+	first := newVirtualRouter("c", int32Ptr(1))
+	first.Namespace = "a-b"
+	second := newVirtualRouter("b-c", int32Ptr(1))
+	second.Namespace = "a"
+	if routerClusterRoleBindingName(first) == routerClusterRoleBindingName(second) {
+		t.Fatalf("expected the bindings of %s/%s and %s/%s apart, both are %s",
+			first.Namespace, first.Name, second.Namespace, second.Name, routerClusterRoleBindingName(first))
+	}
+
+	binding := newRouterClusterRoleBinding("c", first)
+	if !ownsRouterClusterRoleBinding(binding, "c", first) || ownsRouterClusterRoleBinding(binding, "b-c", second) {
+		t.Errorf("expected the binding owned by %s/%s only", first.Namespace, first.Name)
+	}
+	// created before the owner annotation, told apart by the ServiceAccount bound
+	legacy := binding.DeepCopy()
+	legacy.Name, legacy.Annotations = legacyRouterClusterRoleBindingName(first), nil
+	if !ownsRouterClusterRoleBinding(legacy, "c", first) || ownsRouterClusterRoleBinding(legacy, "b-c", second) {
+		t.Errorf("expected the legacy binding owned by %s/%s only", first.Namespace, first.Name)
+	}
+}
+
+func TestKeepsRouterClusterRoleBindingOfOtherRouter(t *testing.T) {
+	f := newFixture(t)
+	f.options.RouterClusterRole = true
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	deletionTime := metav1.NewTime(fakeNow)
+	virtualRouter.DeletionTimestamp = &deletionTime
+	virtualRouter.Finalizers = []string{VIRTUALROUTER_CLUSTER_ROLE_FINALIZER}
+	// bound for another router under the legacy name this router maps to too
+	other := newRouterClusterRoleBinding("other", virtualRouter)
+	other.Name, other.Annotations = legacyRouterClusterRoleBindingName(virtualRouter), nil
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, other)
+
+	f.kubeactions = append(f.kubeactions,
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, routerClusterRoleBindingName(virtualRouter)),
+		core.NewRootGetAction(schema.GroupVersionResource{Resource: "clusterrolebindings"}, other.Name))
+	released := virtualRouter.DeepCopy()
+	released.Finalizers = nil
+	f.expectUpdateVirtualRouterAction(released)
+	f.run(getKey(virtualRouter, t))
+}
+
+func newLoadBalancerService(name string, router string, clusterIP string, created time.Time, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         metav1.NamespaceDefault,
+			Annotations:       map[string]string{VIRTUALROUTER_SERVICE_ANNOTATION: router},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeLoadBalancer,
+			ClusterIP: clusterIP,
+			Ports:     ports,
+		},
+	}
+}
+
+func TestPublishesServiceLoadBalancers(t *testing.T) {
+	f := newFixture(t)
+	f.options.ServiceLoadBalancers = true
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.9.10"
+	virtualRouter.Spec.PortForwards = []networkcontroller.PortForward{{ExternalPort: 80, TargetIP: "10.0.0.10"}}
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	web := newLoadBalancerService("web", "test", "10.96.0.10", fakeNow.Add(-time.Hour), corev1.ServicePort{Port: 443})
+	dns := newLoadBalancerService("dns", "test", "10.96.0.53", fakeNow.Add(-time.Minute), corev1.ServicePort{Port: 53, Protocol: corev1.ProtocolUDP})
+	// port 80 is forwarded already
+	http := newLoadBalancerService("http", "test", "10.96.0.80", fakeNow, corev1.ServicePort{Port: 80})
+	headless := newLoadBalancerService("headless", "test", corev1.ClusterIPNone, fakeNow, corev1.ServicePort{Port: 8080})
+	other := newLoadBalancerService("other", "other", "10.96.0.11", fakeNow, corev1.ServicePort{Port: 25})
+	// published before, and since moved to a ClusterIP Service
+	moved := newLoadBalancerService("moved", "test", "10.96.0.12", fakeNow, corev1.ServicePort{Port: 22})
+	moved.Spec.Type = corev1.ServiceTypeClusterIP
+	moved.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.168.9.10"}}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.serviceLister = append(f.serviceLister, web, dns, http, headless, other, moved)
+	f.kubeobjects = append(f.kubeobjects, d, web, dns, http, headless, other, moved)
+	f.addChildObjects(newNS, virtualRouter)
+
+	natRule := newServiceNATRule(newNS, virtualRouter, "192.168.9.10", []*corev1.Service{web, dns})
+	expected := []nfvv1.Rules{
+		{Match: nfvv1.Match{DstIP: "192.168.9.10", Protocol: "tcp --dport 443"}, Action: nfvv1.Action{DstIP: "10.96.0.10:443"}},
+		{Match: nfvv1.Match{DstIP: "192.168.9.10", Protocol: "udp --dport 53"}, Action: nfvv1.Action{DstIP: "10.96.0.53:53"}},
+	}
+	if !reflect.DeepEqual(natRule.Spec.Rules, expected) {
+		t.Errorf("expected rules %+v, got %+v", expected, natRule.Spec.Rules)
+	}
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions,
+		core.NewCreateAction(natRuleResource, newNS, mustToUnstructured(newPortForwardNATRule(newNS, virtualRouter, "192.168.9.10"), t)),
+		core.NewCreateAction(natRuleResource, newNS, mustToUnstructured(natRule, t)))
+	for _, service := range []*corev1.Service{web, dns, moved} {
+		serviceCopy := service.DeepCopy()
+		serviceCopy.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.168.9.10"}}
+		if service == moved {
+			serviceCopy.Status.LoadBalancer.Ingress = nil
+		}
+		f.kubeactions = append(f.kubeactions, core.NewUpdateSubresourceAction(schema.GroupVersionResource{Resource: "services"}, "status", metav1.NamespaceDefault, serviceCopy))
+	}
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:       networkcontroller.VirtualRouterPending,
+		ExternalIPs: []string{"192.168.9.10"},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestParseBackendServices(t *testing.T) {
+	tests := map[string]struct {
+		value    string
+		expected map[string]types.NamespacedName
+		invalid  bool
+	}{
+		"namespace of the rule": {
+			value:    "192.168.9.20=web",
+			expected: map[string]types.NamespacedName{"192.168.9.20": {Namespace: "test", Name: "web"}},
+		},
+		"several services": {
+			value: "192.168.9.20=web, 192.168.9.21=test/api",
+			expected: map[string]types.NamespacedName{
+				"192.168.9.20": {Namespace: "test", Name: "web"},
+				"192.168.9.21": {Namespace: "test", Name: "api"},
+			},
+		},
+		// the endpoints of another tenant aren't to be reached
+		"another namespace": {value: "192.168.9.20=shop/api", invalid: true},
+		"empty":             {value: "", expected: map[string]types.NamespacedName{}},
+		"missing service":   {value: "192.168.9.20=", invalid: true},
+		"invalid address":   {value: "lb=web", invalid: true},
+		"no separator":      {value: "web", invalid: true},
+	}
+	for name, test := range tests {
+		services, err := parseBackendServices(test.value, "test")
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if !reflect.DeepEqual(services, test.expected) {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, services)
+		}
+	}
+}
+
+func newEndpointSlice(name string, namespace string, service string, endpoints ...discoveryv1beta1.Endpoint) *discoveryv1beta1.EndpointSlice {
+	return &discoveryv1beta1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{discoveryv1beta1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1beta1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+func TestFollowsBackendServiceEndpoints(t *testing.T) {
+	setFeatureGates(t, "LoadBalancerBackendServices=true")
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+	ready, notReady := true, false
+	lbRule := &nfvv1.LoadBalancerRule{
+		TypeMeta: metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "LoadBalancerRule"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   newNS,
+			Annotations: map[string]string{BACKEND_SERVICES_ANNOTATION: "192.168.9.20=web"},
+		},
+		Spec: nfvv1.LoadBalancerRuleSpec{Rules: []nfvv1.LBRules{
+			{LoadBalancerIP: "192.168.9.20", BackendIPs: []nfvv1.LBTarget{{BackendIP: "10.244.0.5", Weight: 3}}},
+			// rules of other addresses are left as they are
+			{LoadBalancerIP: "192.168.9.30", BackendIPs: []nfvv1.LBTarget{{BackendIP: "10.244.0.9", Weight: 1}}},
+		}},
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.endpointSliceLister = append(f.endpointSliceLister,
+		newEndpointSlice("web-a", newNS, "web",
+			discoveryv1beta1.Endpoint{Addresses: []string{"10.244.1.7"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready}},
+			discoveryv1beta1.Endpoint{Addresses: []string{"10.244.1.8"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady}}),
+		newEndpointSlice("web-b", newNS, "web",
+			discoveryv1beta1.Endpoint{Addresses: []string{"10.244.0.6"}}),
+		newEndpointSlice("api", newNS, "api",
+			discoveryv1beta1.Endpoint{Addresses: []string{"10.244.2.2"}}))
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(lbRule, t))
+	f.addChildObjects(newNS, virtualRouter)
+
+	expected := lbRule.DeepCopy()
+	expected.Spec.Rules[0].BackendIPs = []nfvv1.LBTarget{{BackendIP: "10.244.0.6", Weight: 1}, {BackendIP: "10.244.1.7", Weight: 1}}
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.nfvactions = append(f.nfvactions, core.NewUpdateAction(loadBalancerRuleResource, newNS, mustToUnstructured(expected, t)))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.UID = "old"
+	virtualRouter.Annotations[BACKUP_ANNOTATION] = "1"
+	virtualRouter.Spec.WireGuard = &networkcontroller.WireGuard{Address: "10.100.0.1/24"}
+	newNS := virtualRouter.Name
+	controllerRef := *metav1.NewControllerRef(virtualRouter, networkcontroller.SchemeGroupVersion.WithKind("VirtualRouter"))
+	userRule := &nfvv1.NATRule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: newNS, ResourceVersion: "7", OwnerReferences: []metav1.OwnerReference{controllerRef}},
+		Spec:       nfvv1.NATRuleSpec{Rules: []nfvv1.Rules{{Match: nfvv1.Match{DstIP: "192.168.9.10"}, Action: nfvv1.Action{DstIP: "10.0.0.5"}}}},
+	}
+	userRule.OwnerReferences[0].Controller = nil
+	// derived from the spec, so restored by the controller on its own
+	managedRule := &nfvv1.NATRule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+		ObjectMeta: metav1.ObjectMeta{Name: PORT_FORWARD_NAT_RULE_NAME, Namespace: newNS, OwnerReferences: []metav1.OwnerReference{controllerRef}},
+	}
+	f.objects = append(f.objects, virtualRouter)
+	f.kubeobjects = append(f.kubeobjects, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: WireGuardSecretName(virtualRouter), Namespace: newNS, OwnerReferences: []metav1.OwnerReference{controllerRef}},
+		Data:       map[string][]byte{WIREGUARD_PRIVATE_KEY: []byte("private"), WIREGUARD_PUBLIC_KEY: []byte("public")},
+	})
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(userRule, t), mustToUnstructured(managedRule, t))
+	c, _, _ := f.newController()
+
+	if err := c.ensureBackup(newNS, virtualRouter); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := f.kubeclient.CoreV1().Secrets(virtualRouter.Namespace).Get(context.TODO(), BackupSecretName(virtualRouter), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Annotations[BACKUP_ANNOTATION] != "1" || len(backup.OwnerReferences) != 0 {
+		t.Errorf("unexpected backup Secret %+v", backup.ObjectMeta)
+	}
+	objects, err := parseBundle(backup.Data[BACKUP_BUNDLE_KEY])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, obj := range objects {
+		kinds = append(kinds, obj.GetKind()+"/"+obj.GetName())
+	}
+	if expected := []string{"VirtualRouter/test", "NATRule/web", "Secret/" + WIREGUARD_SECRET_NAME}; !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected bundle %v, got %v", expected, kinds)
+	}
+	if annotations := objects[0].GetAnnotations(); annotations[RESTORE_ANNOTATION] != BackupSecretName(virtualRouter) || annotations[BACKUP_ANNOTATION] != "" {
+		t.Errorf("unexpected annotations of the bundled VirtualRouter %v", annotations)
+	}
+	if namespace, pinned := objects[0].GetAnnotations()[ROUTER_NAMESPACE_ANNOTATION]; pinned {
+		t.Errorf("expected the bundled VirtualRouter not to be pinned to namespace %s", namespace)
+	}
+	if objects[1].GetResourceVersion() != "" {
+		t.Errorf("expected no server populated metadata, got resourceVersion %s", objects[1].GetResourceVersion())
+	}
+
+	// the same request isn't backed up again
+	f.kubeclient.ClearActions()
+	if err := c.ensureBackup(newNS, virtualRouter); err != nil {
+		t.Fatal(err)
+	}
+	if actions := f.kubeclient.Actions(); len(actions) != 1 || actions[0].GetVerb() != "get" {
+		t.Errorf("expected the backup Secret to be left as it is, got %v", actions)
+	}
+
+	// the clone is created from the bundled VirtualRouter with another name
+	var clone networkcontroller.VirtualRouter
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objects[0].Object, &clone); err != nil {
+		t.Fatal(err)
+	}
+	clone.Name = "clone"
+	clone.UID = "new"
+	if _, err := f.client.TmaxV1().VirtualRouters(clone.Namespace).Create(context.TODO(), &clone, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// the clone is given a namespace of its own rather than that of the
+	// original
+	pinned, err := c.pinRouterNamespace(&clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloneNS := pinned.Annotations[ROUTER_NAMESPACE_ANNOTATION]
+	if expected := renderNamespaceTemplate(DEFAULT_NAMESPACE_TEMPLATE, &clone); cloneNS != expected {
+		t.Fatalf("expected the clone to be pinned to namespace %s, got %q", expected, cloneNS)
+	}
+	restored, err := c.restoreBackup(cloneNS, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Annotations[RESTORED_ANNOTATION] != BackupSecretName(virtualRouter) || restored.Annotations[RESTORE_ANNOTATION] != "" {
+		t.Errorf("unexpected annotations of the restored VirtualRouter %v", restored.Annotations)
+	}
+	rule, err := f.nfvclient.Resource(natRuleResource).Namespace(cloneNS).Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owners := rule.GetOwnerReferences(); len(owners) != 1 || owners[0].Name != "clone" || owners[0].UID != "new" {
+		t.Errorf("expected the rule to be owned by the clone, got %v", owners)
+	}
+	if !reflect.DeepEqual(rule.Object["spec"], mustToUnstructured(userRule, t).Object["spec"]) {
+		t.Errorf("expected the rule spec to be restored, got %v", rule.Object["spec"])
+	}
+	if _, err := f.nfvclient.Resource(natRuleResource).Namespace(cloneNS).Get(context.TODO(), PORT_FORWARD_NAT_RULE_NAME, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the managed rule not to be restored, got %v", err)
+	}
+	keys, err := f.kubeclient.CoreV1().Secrets(cloneNS).Get(context.TODO(), WireGuardSecretName(&clone), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(keys.Data[WIREGUARD_PRIVATE_KEY]) != "private" || keys.OwnerReferences[0].UID != "new" {
+		t.Errorf("unexpected WireGuard keys %+v", keys)
+	}
+}
+
+func TestClaimsSameDeployment(t *testing.T) {
+	router := func(namespace, name, deploymentName string, tenant bool) *networkcontroller.VirtualRouter {
+		virtualRouter := newVirtualRouter(name, int32Ptr(1))
+		virtualRouter.Namespace = namespace
+		virtualRouter.Spec.DeploymentName = deploymentName
+		if tenant {
+			virtualRouter.Spec.Placement.Strategy = networkcontroller.TenantPlacementStrategy
+		}
+		return virtualRouter
+	}
+	tests := []struct {
+		name     string
+		a, b     *networkcontroller.VirtualRouter
+		expected bool
+	}{
+		{"same name in different namespaces", router("a", "test", "router", false), router("b", "test", "other", false), true},
+		{"different names", router("a", "test", "router", false), router("a", "other", "router", false), false},
+		{"tenant namespace named after a router", router("a", "test", "router", false), router("test", "tenant", "other", true), true},
+		{"tenant routers sharing a deployment name", router("a", "one", "router", true), router("a", "two", "router", true), true},
+		{"tenant routers", router("a", "one", "router-one", true), router("a", "two", "router-two", true), false},
+	}
+	for _, test := range tests {
+		if claimsSameDeployment(test.a, test.b) != test.expected {
+			t.Errorf("%s: expected %t", test.name, test.expected)
+		}
+	}
+}
+
+func TestDryRunAnnotation(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Annotations = map[string]string{DRY_RUN_ANNOTATION: "true"}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	// the controller makes its writes only with the dry run clients
+	dryRunKubeClient := k8sfake.NewSimpleClientset()
+	f.options.DryRunClients = func(plan *DryRunPlan) (kubernetes.Interface, clientset.Interface, dynamic.Interface, error) {
+		return dryRunKubeClient, fake.NewSimpleClientset(virtualRouter), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil
+	}
+
+	f.run(getKey(virtualRouter, t))
+
+	var created []string
+	for _, action := range dryRunKubeClient.Actions() {
+		if action, ok := action.(core.CreateAction); ok {
+			created = append(created, action.GetResource().Resource)
+		}
+	}
+	expected := []string{"namespaces", "serviceaccounts", "roles", "rolebindings", "deployments"}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("expected dry run creates of %v, got %v", expected, created)
+	}
+}
+
+func TestDryRunConfig(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			io.Copy(w, r.Body)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`)
+	}))
+	defer server.Close()
+
+	plan := &DryRunPlan{}
+	kubeClient, err := kubernetes.NewForConfig(DryRunConfig(&rest.Config{Host: server.URL}, plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kubeClient.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the namespace isn't there to dry run creating objects in it
+	if _, err := kubeClient.CoreV1().ServiceAccounts("test").Create(context.TODO(), &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().ServiceAccounts("test").Get(context.TODO(), "test-sa", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected reads to reach the server, got %v", err)
+	}
+
+	expectedRequests := []string{"POST /api/v1/namespaces?dryRun=All", "GET /api/v1/namespaces/test/serviceaccounts/test-sa?"}
+	if !reflect.DeepEqual(requests, expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, requests)
+	}
+	expectedOperations := []string{"create /api/v1/namespaces/test", "create /api/v1/namespaces/test/serviceaccounts/test-sa"}
+	if operations := plan.Operations(); !reflect.DeepEqual(operations, expectedOperations) {
+		t.Errorf("expected operations %v, got %v", expectedOperations, operations)
+	}
+}
+
+func TestDryRunRecordsEvents(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+
+	// with --dry-run the objects are written as dry runs, and the Events
+	// with the client given for them
+	kubeClient, err := kubernetes.NewForConfig(DryRunConfig(&rest.Config{Host: server.URL}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventClient := k8sfake.NewSimpleClientset()
+	client := fake.NewSimpleClientset()
+	i := informers.NewSharedInformerFactory(client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(eventClient, noResyncPeriodFunc())
+	c := NewController(kubeClient, client, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		k8sI.Apps().V1().Deployments(), k8sI.Apps().V1().StatefulSets(), k8sI.Policy().V1beta1().PodDisruptionBudgets(),
+		k8sI.Autoscaling().V2beta2().HorizontalPodAutoscalers(), k8sI.Core().V1().Pods(),
+		k8sI.Core().V1().Nodes(), k8sI.Core().V1().Services(), k8sI.Discovery().V1beta1().EndpointSlices(),
+		i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterProfiles(), i.Tmax().V1().NetworkFreezes(),
+		Options{DryRun: true, EventClient: eventClient})
+	defer c.cancel()
+
+	c.recorder.Event(newVirtualRouter("test", int32Ptr(1)), corev1.EventTypeNormal, DryRun, "planned")
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		for _, action := range eventClient.Actions() {
+			if action.Matches("create", "events") {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Errorf("expected the Event to be recorded, got %v", eventClient.Actions())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, request := range requests {
+		if strings.Contains(request, "/events") {
+			t.Errorf("expected no Event written as a dry run, got %s", request)
+		}
+	}
+}
+
+func TestPodTiming(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	d := newDeployment(virtualRouter.Name, virtualRouter)
+	condition := func(conditionType corev1.PodConditionType, after time.Duration) corev1.PodCondition {
+		return corev1.PodCondition{Type: conditionType, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(fakeNow.Add(after))}
+	}
+	old := newRouterPod("old", d, "node-a", true, fakeNow.Add(-time.Hour))
+	old.Status.Conditions = []corev1.PodCondition{condition(corev1.PodScheduled, -time.Hour)}
+	// the newest pod waited 3s for the scheduler and 2s for the daemon
+	newest := newRouterPod("newest", d, "node-b", true, fakeNow)
+	newest.Status.Conditions = []corev1.PodCondition{
+		condition(corev1.PodScheduled, 3*time.Second),
+		condition(corev1.ContainersReady, 10*time.Second),
+		condition(VIRTUALROUTER_READINESS_GATE, 12*time.Second),
+	}
+
+	timing := &networkcontroller.ReconcileTiming{}
+	addPodTiming(timing, []*corev1.Pod{old, newest})
+	expected := &networkcontroller.ReconcileTiming{
+		Scheduling: &metav1.Duration{Duration: 3 * time.Second},
+		DataPlane:  &metav1.Duration{Duration: 2 * time.Second},
+	}
+	if !reflect.DeepEqual(timing, expected) {
+		t.Errorf("expected timing %+v, got %+v", expected, timing)
+	}
+
+	// the data plane of a pod still being set up took no time yet
+	newest.Status.Conditions = newest.Status.Conditions[:2]
+	timing = &networkcontroller.ReconcileTiming{}
+	addPodTiming(timing, []*corev1.Pod{old, newest})
+	if timing.DataPlane != nil {
+		t.Errorf("expected no data plane timing, got %v", timing.DataPlane)
+	}
+}
+
+func TestPausedVirtualRouter(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Annotations = map[string]string{PAUSED_ANNOTATION: "true"}
+	newNS := virtualRouter.Name
+	// a Deployment changed by hand isn't reverted
+	d := newDeployment(newNS, virtualRouter)
+	d.Spec.Replicas = int32Ptr(0)
+	d.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] = "edited"
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+
+	expected := withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.PausedCondition,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             Paused,
+			Message:            MessagePaused,
+		}},
+	})
+	// nothing is provisioned while paused
+	expected.Status.ReconcileTiming = nil
+	f.expectPatchVirtualRouterStatusAction(expected)
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestResumedVirtualRouter(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	pausedAt := metav1.NewTime(fakeNow.Add(-time.Hour))
+	virtualRouter.Status.Conditions = []metav1.Condition{{
+		Type:               networkcontroller.PausedCondition,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: pausedAt,
+		Reason:             Paused,
+		Message:            MessagePaused,
+	}}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+
+	newNS := virtualRouter.Name
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, true)
+	f.expectCreateDeploymentAction(newDeployment(newNS, virtualRouter))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.PausedCondition,
+			Status:             metav1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             Resumed,
+			Message:            MessageResumed,
+		}},
+	}))
+
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestFirewallRuleHits(t *testing.T) {
+	f := newFixture(t)
+	virtualRouter := newVirtualRouter("test", int32Ptr(2))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	firewallRule := &nfvv1.FireWallRule{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "FireWallRule"},
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-web", Namespace: newNS},
+		Spec: nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{
+			{Match: nfvv1.Match{DstIP: "10.0.0.10", Protocol: "TCP"}, Action: nfvv1.Action{Policy: "accept"}},
+			{Match: nfvv1.Match{SrcIP: "10.0.0.0/24"}, Action: nfvv1.Action{Policy: "DROP"}},
+		}},
+	}
+	routerPod := func(name string, node string, counters string) *corev1.Pod {
+		pod := newRouterPod(name, d, node, true, fakeNow.Add(-time.Hour))
+		pod.Annotations = map[string]string{FIREWALL_COUNTERS_ANNOTATION: counters}
+		return pod
+	}
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.nfvobjects = append(f.nfvobjects, mustToUnstructured(firewallRule, t))
+	f.podLister = append(f.podLister,
+		routerPod("router-a", "node-a", `{"|10.0.0.10/32|tcp|ACCEPT":{"packets":3,"bytes":180}}`),
+		routerPod("router-b", "node-b", `{"|10.0.0.10/32|tcp|ACCEPT":{"packets":2,"bytes":120},"|192.168.0.1/32||DROP":{"packets":9,"bytes":900}}`))
+	f.addChildObjects(newNS, virtualRouter)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	f.expectCreatePodDisruptionBudgetAction(newPodDisruptionBudget(d, virtualRouter))
+	f.nfvactions = append(f.nfvactions, core.NewPatchSubresourceAction(firewallRuleResource, newNS, firewallRule.Name, types.MergePatchType,
+		[]byte(`{"status":{"ruleHits":[{"packets":5,"bytes":300},{"packets":0,"bytes":0}]}}`), "status"))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase:      networkcontroller.VirtualRouterPending,
+		ActiveNode: "node-a",
+		Conditions: []metav1.Condition{{
+			Type:               networkcontroller.ConfigAppliedCondition,
+			Status:             metav1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(fakeNow),
+			Reason:             ConfigApplying,
+			Message:            "Waiting for generation 0 to be applied to router-a, router-b",
+		}},
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestFirewallRuleKey(t *testing.T) {
+	tests := []struct {
+		srcIP, dstIP, protocol, policy string
+		expected                       string
+	}{
+		{"", "10.0.0.10", "TCP", "accept", "|10.0.0.10/32|tcp|ACCEPT"},
+		{"10.0.0.7/24", "", "", "DROP", "10.0.0.0/24|||DROP"},
+		{"fd00::1", "", "udp", "DROP", "fd00::1/128||udp|DROP"},
+	}
+	for _, test := range tests {
+		if key := FirewallRuleKey(test.srcIP, test.dstIP, test.protocol, test.policy); key != test.expected {
+			t.Errorf("expected key %q, got %q", test.expected, key)
+		}
+	}
+}
+
+func TestControllerClass(t *testing.T) {
+	tests := []struct {
+		name            string
+		controllerClass string
+		handled         bool
+	}{
+		{"other class", "", false},
+		{"own class", "team-a", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.options.ControllerClass = test.controllerClass
+			virtualRouter := newVirtualRouter("test", int32Ptr(1))
+			virtualRouter.Spec.ControllerClass = "team-a"
+			newNS := virtualRouter.Name
+			d := newDeployment(newNS, virtualRouter)
+
+			f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+			f.objects = append(f.objects, virtualRouter)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			f.addChildObjects(newNS, virtualRouter)
+
+			if test.handled {
+				f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+				f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+					Phase: networkcontroller.VirtualRouterPending,
+				}))
+			}
+			f.run(getKey(virtualRouter, t))
+		})
+	}
+}
+
+func TestMonitoring(t *testing.T) {
+	f := newFixture(t)
+	f.options.Monitoring = true
+	f.options.MonitoringLabels = map[string]string{"release": "prometheus"}
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	d := newDeployment(newNS, virtualRouter)
+
+	f.virtualRouterLister = append(f.virtualRouterLister, virtualRouter)
+	f.objects = append(f.objects, virtualRouter)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+	f.addChildObjects(newNS, virtualRouter)
+
+	configMap, err := newDashboardConfigMap(newNS, virtualRouter, f.options.MonitoringLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Labels[GRAFANA_DASHBOARD_LABEL] != "1" || configMap.Labels["release"] != "prometheus" {
+		t.Errorf("expected the dashboard labelled for Grafana and with the monitoring labels, got %v", configMap.Labels)
+	}
+	dashboard := configMap.Data["virtualrouter-default-test.json"]
+	for _, expr := range []string{`virtualrouter_sessions{namespace=\"test\",pod=~\"test-deployment-.*\"}`, `virtualrouter_failovers_total{namespace=\"default\",virtualrouter=\"test\"}`} {
+		if !strings.Contains(dashboard, expr) {
+			t.Errorf("expected the dashboard to query %s, got %s", expr, dashboard)
+		}
+	}
+	autoscaled := virtualRouter.DeepCopy()
+	autoscaled.Spec.Autoscaling = &networkcontroller.Autoscaling{MaxReplicas: 4, TargetSessions: 50000}
+	groups, _, _ := unstructured.NestedSlice(newPrometheusRule(newNS, autoscaled, nil).Object, "spec", "groups")
+	if rules := groups[0].(map[string]interface{})["rules"].([]interface{}); len(rules) != 4 ||
+		rules[3].(map[string]interface{})["expr"] != `sum(virtualrouter_sessions{namespace="test",pod=~"test-deployment-.*"}) > 200000` {
+		t.Errorf("expected an alert on sessions above 4 pods of 50000, got %v", rules)
+	}
+	rule := newPrometheusRule(newNS, virtualRouter, f.options.MonitoringLabels)
+
+	f.expectEnsureChildObjectsActions(newNS, virtualRouter, false)
+	configMaps := schema.GroupVersionResource{Resource: "configmaps"}
+	f.kubeactions = append(f.kubeactions,
+		core.NewGetAction(configMaps, newNS, configMap.Name),
+		core.NewCreateAction(configMaps, newNS, configMap))
+	f.nfvactions = append(f.nfvactions,
+		core.NewGetAction(prometheusRuleResource, newNS, rule.GetName()),
+		core.NewCreateAction(prometheusRuleResource, newNS, rule))
+	f.expectPatchVirtualRouterStatusAction(withStatus(virtualRouter, networkcontroller.VirtualRouterStatus{
+		Phase: networkcontroller.VirtualRouterPending,
+	}))
+	f.run(getKey(virtualRouter, t))
+}
+
+func TestRuleValidation(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	newNS := virtualRouter.Name
+	natRule := func(name string, rules ...nfvv1.Rules) *nfvv1.NATRule {
+		return &nfvv1.NATRule{
+			TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: newNS},
+			Spec:       nfvv1.NATRuleSpec{Rules: rules},
+		}
+	}
+	dnat := func(protocol, target string) nfvv1.Rules {
+		return nfvv1.Rules{Match: nfvv1.Match{DstIP: "192.168.0.10", Protocol: protocol}, Action: nfvv1.Action{DstIP: target}}
+	}
+	portForwards := newPortForwardNATRule(newNS, virtualRouter, "192.168.0.10")
+	portForwards.Spec.Rules = []nfvv1.Rules{dnat("tcp --dport 8443", "10.0.0.10:443")}
+	services := natRule(routerResourceName(virtualRouter, SERVICE_NAT_RULE_NAME), dnat("tcp --dport 9443", "10.96.0.10:9443"))
+	services.OwnerReferences = portForwards.OwnerReferences
+	existing := natRule("web", dnat("tcp --dport 80", "10.0.0.10:8080"))
+
+	client := fake.NewSimpleClientset(virtualRouter)
+	i := informers.NewSharedInformerFactory(client, noResyncPeriodFunc())
+	i.Tmax().V1().VirtualRouters().Informer().GetIndexer().Add(virtualRouter)
+	validator := NewRuleValidator(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), mustToUnstructured(existing, t), mustToUnstructured(portForwards, t), mustToUnstructured(services, t)), i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterQuotas())
+	validator.virtualRoutersSynced = alwaysReady
+	validator.quotasSynced = alwaysReady
+
+	request := func(kind string, obj runtime.Object) *admissionv1.AdmissionRequest {
+		obj.GetObjectKind().SetGroupVersionKind(nfvv1.SchemeGroupVersion.WithKind(kind))
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Group: nfvv1.SchemeGroupVersion.Group, Version: nfvv1.SchemeGroupVersion.Version, Kind: kind},
+			Namespace: obj.(metav1.Object).GetNamespace(),
+			Name:      obj.(metav1.Object).GetName(),
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}
+	}
+	otherNS := natRule("web", dnat("tcp", "10.0.0.10"))
+	otherNS.Namespace = "other"
+	ownPortForwards := portForwards.DeepCopy()
+	ownPortForwards.Spec.Rules = append(ownPortForwards.Spec.Rules, dnat("tcp --dport 9443", "10.0.0.11:443"))
+	tests := []struct {
+		name     string
+		kind     string
+		obj      runtime.Object
+		expected string
+	}{
+		{"valid NAT", "NATRule", natRule("ssh", dnat("tcp --dport 22", "10.0.0.10"), nfvv1.Rules{Match: nfvv1.Match{SrcIP: "10.0.0.0/24"}, Action: nfvv1.Action{SrcIP: "0.0.0.0"}}), ""},
+		{"invalid CIDR", "NATRule", natRule("ssh", nfvv1.Rules{Match: nfvv1.Match{SrcIP: "10.0.0.0/33"}, Action: nfvv1.Action{SrcIP: "192.168.0.10"}}), `rules[0].match.srcIP: invalid address "10.0.0.0/33"`},
+		{"invalid port range", "NATRule", natRule("ssh", dnat("tcp --dport 3000:2000", "10.0.0.10")), `rules[0].match.protocol: protocol "tcp --dport 3000:2000": invalid port range "3000:2000"`},
+		{"ports of icmp", "NATRule", natRule("ssh", dnat("icmp --dport 22", "10.0.0.10")), "ports are only matched for tcp, udp and sctp"},
+		{"no translation", "NATRule", natRule("ssh", nfvv1.Rules{Match: nfvv1.Match{DstIP: "192.168.0.10"}}), "srcIP (SNAT) or dstIP (DNAT) is required"},
+		{"duplicate external port", "NATRule", natRule("http", dnat("tcp --dport 70:90", "10.0.0.11")), "DNAT of tcp/70:90 of 192.168.0.10/32 conflicts with NATRule web taking tcp/80 of 192.168.0.10/32"},
+		{"external port of the port forwards", "NATRule", natRule("https", dnat("tcp --dport 8443", "10.0.0.11")), "conflicts with NATRule virtualrouter-port-forwards"},
+		{"port taken twice", "NATRule", natRule("dns", dnat("udp --dport 53", "10.0.0.53"), dnat("udp --dport 53", "10.0.0.54")), "DNAT of udp/53 of 192.168.0.10/32 is given more than once"},
+		{"other protocol", "NATRule", natRule("dns", dnat("udp --dport 80", "10.0.0.53")), ""},
+		{"rules of the same router", "NATRule", ownPortForwards, ""},
+		{"no router", "NATRule", otherNS, "namespace other is the router namespace of no VirtualRouter"},
+		{"unknown protocol", "FireWallRule", &nfvv1.FireWallRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny", Namespace: newNS},
+			Spec:       nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{{Match: nfvv1.Match{Protocol: "tpc"}, Action: nfvv1.Action{Policy: "DROP"}}}},
+		}, `rules[0].match.protocol: unknown protocol "tpc"`},
+		// the inter-network rules of the daemon can't be got around
+		{"out interface", "FireWallRule", &nfvv1.FireWallRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenants", Namespace: newNS},
+			Spec:       nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{{Match: nfvv1.Match{Protocol: "all -o int-tenant-a"}, Action: nfvv1.Action{Policy: "ACCEPT"}}}},
+		}, "unsupported option -o, only --dport and --sport are"},
+		{"connection states", "FireWallRule", &nfvv1.FireWallRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "replies", Namespace: newNS},
+			Spec:       nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{{Match: nfvv1.Match{Protocol: "all -m conntrack --ctstate ESTABLISHED,RELATED"}, Action: nfvv1.Action{Policy: "ACCEPT"}}}},
+		}, "unsupported option -m, only --dport and --sport are"},
+		{"unknown policy", "FireWallRule", &nfvv1.FireWallRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny", Namespace: newNS},
+			Spec:       nfvv1.FireWallRuleSpec{Rules: []nfvv1.Rules{{Match: nfvv1.Match{Protocol: "TCP --dport 22"}, Action: nfvv1.Action{Policy: "deny"}}}},
+		}, `rules[0].action.policy: "deny" is none of ACCEPT, DROP and REJECT`},
+		{"load balancer weights", "LoadBalancerRule", &nfvv1.LoadBalancerRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: newNS},
+			Spec: nfvv1.LoadBalancerRuleSpec{Rules: []nfvv1.LBRules{{LoadBalancerIP: "192.168.0.20", BackendIPs: []nfvv1.LBTarget{
+				{BackendIP: "10.0.0.10:80", Weight: 60}, {BackendIP: "10.0.0.11:80", Weight: 60}}}}},
+		}, "rules[0].backendIPs: the weights add up to 120, more than 100"},
+		{"duplicate load balancer IP", "LoadBalancerRule", &nfvv1.LoadBalancerRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: newNS},
+			Spec:       nfvv1.LoadBalancerRuleSpec{Rules: []nfvv1.LBRules{{LoadBalancerIP: "192.168.0.20"}, {LoadBalancerIP: "192.168.0.20"}}},
+		}, "load balancer IP 192.168.0.20 is given more than once"},
+	}
+	for _, test := range tests {
+		err := validator.Validate(request(test.kind, test.obj))
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("%s: expected the rule admitted, got %v", test.name, err)
+		case test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("%s: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+
+	// updates leaving the spec as it is are admitted
+	invalid := natRule("legacy", dnat("tcp --dport 80", "10.0.0.12"))
+	update := request("NATRule", invalid)
+	update.Operation = admissionv1.Update
+	update.OldObject = update.Object
+	if err := validator.Validate(update); err != nil {
+		t.Errorf("expected an update of the metadata admitted, got %v", err)
+	}
+
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  request("NATRule", invalid),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	validator.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, RULE_VALIDATION_PATH, strings.NewReader(string(body))))
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(recorder.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || review.Response.UID != "uid" || review.Response.Allowed || review.Response.Result.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the NATRule rejected, got %+v", review.Response)
+	}
+}
+
+func TestQuotaValidation(t *testing.T) {
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	virtualRouter.Spec.ExternalIP = "192.168.0.10"
+	newNS := virtualRouter.Name
+	quota := &networkcontroller.VirtualRouterQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: metav1.NamespaceDefault},
+		Spec: networkcontroller.VirtualRouterQuotaSpec{
+			MaxVirtualRouters: int32Ptr(1),
+			MaxExternalIPs:    int32Ptr(2),
+			MaxNATRules:       int32Ptr(2),
+		},
+	}
+	rule := nfvv1.Rules{Match: nfvv1.Match{SrcIP: "10.0.0.0/24"}, Action: nfvv1.Action{SrcIP: "192.168.0.10"}}
+	natRule := func(name string, count int) *nfvv1.NATRule {
+		natRule := &nfvv1.NATRule{
+			TypeMeta:   metav1.TypeMeta{APIVersion: nfvv1.SchemeGroupVersion.String(), Kind: "NATRule"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: newNS},
+		}
+		for n := 0; n < count; n++ {
+			natRule.Spec.Rules = append(natRule.Spec.Rules, rule)
+		}
+		return natRule
+	}
+	// rules of the VirtualRouter are not counted
+	portForwards := newPortForwardNATRule(newNS, virtualRouter, "192.168.0.10")
+	portForwards.Spec.Rules = []nfvv1.Rules{rule, rule}
+
+	client := fake.NewSimpleClientset(virtualRouter, quota)
+	i := informers.NewSharedInformerFactory(client, noResyncPeriodFunc())
+	i.Tmax().V1().VirtualRouters().Informer().GetIndexer().Add(virtualRouter)
+	i.Tmax().V1().VirtualRouterQuotas().Informer().GetIndexer().Add(quota)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), mustToUnstructured(natRule("snat", 1), t), mustToUnstructured(portForwards, t))
+	ruleValidator := NewRuleValidator(dynamicClient, i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterQuotas())
+	quotaValidator := NewQuotaValidator(i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterQuotas(), i.Tmax().V1().VirtualRouterProfiles())
+
+	request := func(kind string, obj runtime.Object, old runtime.Object) *admissionv1.AdmissionRequest {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := &admissionv1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Kind: kind},
+			Namespace: obj.(metav1.Object).GetNamespace(),
+			Name:      obj.(metav1.Object).GetName(),
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}
+		if old != nil {
+			if request.OldObject.Raw, err = json.Marshal(old); err != nil {
+				t.Fatal(err)
+			}
+			request.Operation = admissionv1.Update
+		}
+		return request
+	}
+	withSNATPool := func(size int32) *networkcontroller.VirtualRouter {
+		updated := virtualRouter.DeepCopy()
+		updated.Spec.SNATPool = &networkcontroller.SNATPool{Pool: "external", Size: size}
+		return updated
+	}
+	overQuota := withSNATPool(2)
+	tests := []struct {
+		name     string
+		validate func(*admissionv1.AdmissionRequest) error
+		request  *admissionv1.AdmissionRequest
+		expected string
+	}{
+		{"rules within the quota", ruleValidator.Validate, request("NATRule", natRule("dnat", 1), nil), ""},
+		{"rules over the quota", ruleValidator.Validate, request("NATRule", natRule("dnat", 2), nil), "3 natrules exceed the 2 of VirtualRouterQuota default/tenant"},
+		{"rules replaced", ruleValidator.Validate, request("NATRule", natRule("snat", 2), natRule("snat", 1)), ""},
+		{"rules of the VirtualRouter", ruleValidator.Validate, request("NATRule", portForwards, nil), ""},
+		{"routers over the quota", quotaValidator.Validate, request("VirtualRouter", newVirtualRouter("second", int32Ptr(1)), nil), "2 VirtualRouters exceed the 1 of VirtualRouterQuota default/tenant"},
+		{"external IPs within the quota", quotaValidator.Validate, request("VirtualRouter", withSNATPool(1), virtualRouter), ""},
+		{"external IPs over the quota", quotaValidator.Validate, request("VirtualRouter", overQuota, virtualRouter), "3 external IPs exceed the 2 of VirtualRouterQuota default/tenant"},
+		{"external IPs over the quota already", quotaValidator.Validate, request("VirtualRouter", overQuota, overQuota), ""},
+	}
+	for _, test := range tests {
+		err := test.validate(test.request)
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("%s: expected admitted, got %v", test.name, err)
+		case test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("%s: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestFastPathPlan(t *testing.T) {
+	natRules := []nfvv1.NATRule{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: nfvv1.NATRuleSpec{Rules: []nfvv1.Rules{
+				{Match: nfvv1.Match{SrcIP: "192.168.0.5/32"}, Action: nfvv1.Action{SrcIP: "10.0.0.5"}},
+				{Match: nfvv1.Match{DstIP: "10.0.0.6"}, Action: nfvv1.Action{DstIP: "192.168.0.6"}},
+				{Match: nfvv1.Match{SrcIP: "192.168.0.0/24"}, Action: nfvv1.Action{SrcIP: "10.0.0.7"}},
+				{Match: nfvv1.Match{DstIP: "10.0.0.8", Protocol: "tcp --dport 80"}, Action: nfvv1.Action{DstIP: "192.168.0.8:8080"}},
+				{Match: nfvv1.Match{SrcIP: "192.168.0.9", DstIP: "8.8.8.8"}, Action: nfvv1.Action{SrcIP: "10.0.0.9"}},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Spec: nfvv1.NATRuleSpec{Rules: []nfvv1.Rules{
+				{Match: nfvv1.Match{SrcIP: "192.168.0.5"}, Action: nfvv1.Action{SrcIP: "10.0.0.10"}},
+				{Match: nfvv1.Match{DstIP: "10.0.0.5"}, Action: nfvv1.Action{DstIP: "192.168.0.11"}},
+			}},
+		},
+	}
+	plan := planFastPath(networkcontroller.VirtualRouterSpec{}, natRules)
+	expectedRules := []FastPathRule{
+		{SNAT: true, Match: net.ParseIP("192.168.0.5").To4(), Translate: net.ParseIP("10.0.0.5").To4(), Rule: "web[0]"},
+		{Match: net.ParseIP("10.0.0.6").To4(), Translate: net.ParseIP("192.168.0.6").To4(), Rule: "web[1]"},
+	}
+	if !reflect.DeepEqual(plan.Rules, expectedRules) {
+		t.Errorf("expected rules %v, got %v", expectedRules, plan.Rules)
+	}
+	expectedIneligible := map[string]string{
+		"web[2]":   "SNAT of no single IPv4 host",
+		"web[3]":   "matches a protocol or port",
+		"web[4]":   "SNAT matching a destination",
+		"other[0]": "overlaps web[0]",
+		"other[1]": "overlaps web[0]",
+	}
+	if !reflect.DeepEqual(plan.Ineligible, expectedIneligible) {
+		t.Errorf("expected ineligible rules %v, got %v", expectedIneligible, plan.Ineligible)
+	}
+
+	// packets the packet filter has to see keep the whole router off it
+	plan = planFastPath(networkcontroller.VirtualRouterSpec{QoS: &networkcontroller.QoS{}}, natRules)
+	if plan.Fallback == "" || len(plan.Rules) != 0 {
+		t.Errorf("expected the router to fall back, got %+v", plan)
+	}
+
+	// the outside addresses other traffic shares are never taken over
+	shared := []nfvv1.NATRule{{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec: nfvv1.NATRuleSpec{Rules: []nfvv1.Rules{
+			{Match: nfvv1.Match{SrcIP: "192.168.0.5"}, Action: nfvv1.Action{SrcIP: "10.0.0.1"}},
+			{Match: nfvv1.Match{DstIP: "10.0.0.8", Protocol: "tcp --dport 80"}, Action: nfvv1.Action{DstIP: "192.168.0.8:8080"}},
+			{Match: nfvv1.Match{DstIP: "10.0.0.8"}, Action: nfvv1.Action{DstIP: "192.168.0.9"}},
+		}},
+	}}
+	plan = planFastPath(networkcontroller.VirtualRouterSpec{ExternalIP: "10.0.0.1"}, shared)
+	expectedIneligible = map[string]string{
+		"shared[0]": "shares its address with the external IP of the router",
+		"shared[1]": "matches a protocol or port",
+		"shared[2]": "shares its address with the ports forwarded by shared[1]",
+	}
+	if len(plan.Rules) != 0 || !reflect.DeepEqual(plan.Ineligible, expectedIneligible) {
+		t.Errorf("expected ineligible rules %v, got %+v", expectedIneligible, plan)
+	}
+
+	listers := &FastPathListers{}
+	for _, lister := range []struct {
+		lister   *cache.GenericLister
+		resource string
+		objects  []interface{}
+	}{
+		{&listers.FireWallRules, "firewallrules", []interface{}{&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": nfvv1.SchemeGroupVersion.String(),
+			"kind":       "FireWallRule",
+			"metadata":   map[string]interface{}{"name": "deny", "namespace": "router"},
+		}}}},
+		{&listers.LoadBalancerRules, "loadbalancerrules", nil},
+		{&listers.NATRules, "natrules", nil},
+	} {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, object := range lister.objects {
+			indexer.Add(object)
+		}
+		*lister.lister = cache.NewGenericLister(indexer, nfvv1.SchemeGroupVersion.WithResource(lister.resource).GroupResource())
+	}
+	plan, err := PlanFastPath(listers, "router", networkcontroller.VirtualRouterSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Fallback != "FireWallRules filter or balance the traffic" {
+		t.Errorf("expected FireWallRules to keep the router off the fast path, got %+v", plan)
+	}
+
+	t.Run("feature gate off", func(t *testing.T) {
+		if err := ValidateSpec(networkcontroller.VirtualRouterSpec{FastPath: networkcontroller.XDPFastPath}); err == nil {
+			t.Error("expected spec.fastPath to be invalid with the XDPFastPath feature gate off")
+		}
+		defer func(gate featuregate.MutableFeatureGate) {
+			features.DefaultMutableFeatureGate, features.DefaultFeatureGate = gate, gate
+		}(features.DefaultMutableFeatureGate)
+		features.DefaultMutableFeatureGate = features.DefaultMutableFeatureGate.DeepCopy()
+		features.DefaultFeatureGate = features.DefaultMutableFeatureGate
+		if err := features.DefaultMutableFeatureGate.Set("XDPFastPath=true"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateSpec(networkcontroller.VirtualRouterSpec{FastPath: networkcontroller.XDPFastPath}); err != nil {
+			t.Errorf("expected a valid spec, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package virtualroutermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DATA_PLANE_HEALTH_ANNOTATION is where the daemons leave the
+	// DataPlaneHealth of a router pod, for the controller to report
+	DATA_PLANE_HEALTH_ANNOTATION string = "network.tmaxanc.com/data-plane-health"
+
+	// DataPlaneHealthy is the DataPlaneHealthy condition reason while every
+	// checked router pod can forward traffic
+	DataPlaneHealthy = "DataPlaneHealthy"
+	// ErrDataPlaneUnhealthy is the DataPlaneHealthy condition reason when the
+	// data plane of a router pod failed its checks
+	ErrDataPlaneUnhealthy = "ErrDataPlaneUnhealthy"
+
+	// DataPlaneFailover is used as part of the Event 'reason' when the active
+	// router pod is replaced for its data plane
+	DataPlaneFailover = "DataPlaneFailover"
+	// MessageDataPlaneFailover is the message used for Events when the active
+	// router pod is replaced for its data plane
+	MessageDataPlaneFailover = "Router pod %s on node %s replaced, its data plane is unhealthy: %s"
+)
+
+// DataPlaneHealth is the result of the last data plane checks of a router
+// pod: whether the host bridges are up, the external gateway answers and
+// conntrack can be read. A pod is unhealthy once its checks failed the
+// failure threshold of the daemon in a row.
+type DataPlaneHealth struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+	// ConsecutiveFailures is how many checks in a row failed
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+}
+
+// podDataPlaneHealth returns the DataPlaneHealth the daemon left on the
+// router pod, false if it wasn't checked yet.
+func podDataPlaneHealth(pod *corev1.Pod) (DataPlaneHealth, bool) {
+	var health DataPlaneHealth
+	content, exist := pod.Annotations[DATA_PLANE_HEALTH_ANNOTATION]
+	if !exist || json.Unmarshal([]byte(content), &health) != nil {
+		return health, false
+	}
+	return health, true
+}
+
+// dataPlaneHealthyCondition correlates the DataPlaneHealth the daemons left
+// on the router pods. It returns nil if no router pod was checked yet.
+func dataPlaneHealthyCondition(virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod, now time.Time) *metav1.Condition {
+	var checked int
+	var unhealthy []string
+	for _, pod := range pods {
+		health, exist := podDataPlaneHealth(pod)
+		if !exist {
+			continue
+		}
+		checked++
+		if !health.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s on %s: %s", pod.Name, pod.Spec.NodeName, health.Message))
+		}
+	}
+	if checked == 0 {
+		return nil
+	}
+	condition := &metav1.Condition{
+		Type:               samplev1alpha1.DataPlaneHealthyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             DataPlaneHealthy,
+		Message:            fmt.Sprintf("Data plane of %d router pods forwarding", checked),
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ErrDataPlaneUnhealthy
+		condition.Message = strings.Join(unhealthy, "; ")
+	}
+	return condition
+}
+
+// activeDataPlaneUnhealthy reports whether the data plane of the active router
+// pod failed its checks, which leaves the router degraded however many pods
+// are running.
+func activeDataPlaneUnhealthy(pods []*corev1.Pod) bool {
+	active := activePod(pods)
+	if active == nil {
+		return false
+	}
+	health, exist := podDataPlaneHealth(active)
+	return exist && !health.Healthy
+}
+
+// ActivePod returns the router pod holding the external address of the
+// router, nil if no router pod is ready.
+func ActivePod(pods []*corev1.Pod) *corev1.Pod {
+	return activePod(pods)
+}
+
+// activePod returns the longest running ready router pod, the one of
+// activeNode, or nil if no router pod is ready.
+func activePod(pods []*corev1.Pod) *corev1.Pod {
+	node := activeNode(pods)
+	if node == "" {
+		return nil
+	}
+	var active *corev1.Pod
+	for _, pod := range pods {
+		if pod.Spec.NodeName != node || !podutil.IsPodReady(pod) {
+			continue
+		}
+		if active == nil || pod.CreationTimestamp.Before(&active.CreationTimestamp) ||
+			pod.CreationTimestamp.Equal(&active.CreationTimestamp) && pod.Name < active.Name {
+			active = pod
+		}
+	}
+	return active
+}
+
+// failoverDataPlane deletes the active router pod of a VirtualRouter asking
+// for spec.dataPlaneFailover when its data plane is unhealthy, so a standby
+// router pod takes over. Nothing is done unless a ready standby router pod
+// was found healthy, as the replacement of the only router pod would just
+// cut the traffic it still forwards.
+func (c *Controller) failoverDataPlane(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod) error {
+	if !virtualRouter.Spec.DataPlaneFailover || !virtualRouter.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	active := activePod(pods)
+	if active == nil {
+		return nil
+	}
+	health, exist := podDataPlaneHealth(active)
+	if !exist || health.Healthy {
+		return nil
+	}
+	standby := false
+	for _, pod := range pods {
+		if pod.Name == active.Name || !podutil.IsPodReady(pod) {
+			continue
+		}
+		if health, exist := podDataPlaneHealth(pod); exist && health.Healthy {
+			standby = true
+			break
+		}
+	}
+	if !standby {
+		return nil
+	}
+
+	err := c.kubeclientset.CoreV1().Pods(newNS).Delete(c.ctx, active.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	routerFailovers.WithLabelValues(virtualRouter.Namespace, virtualRouter.Name, FAILOVER_DATA_PLANE).Inc()
+	c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, DataPlaneFailover, MessageDataPlaneFailover, active.Name, active.Spec.NodeName, health.Message)
+	return nil
+}
+
+// setDataPlaneHealthyCondition reports the data plane of the router pods in
+// the status, leaving the condition out until a router pod was checked.
+func setDataPlaneHealthyCondition(status *samplev1alpha1.VirtualRouterStatus, virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod, now time.Time) {
+	if condition := dataPlaneHealthyCondition(virtualRouter, pods, now); condition != nil {
+		meta.SetStatusCondition(&status.Conditions, *condition)
+		return
+	}
+	if meta.FindStatusCondition(status.Conditions, samplev1alpha1.DataPlaneHealthyCondition) != nil {
+		meta.RemoveStatusCondition(&status.Conditions, samplev1alpha1.DataPlaneHealthyCondition)
+	}
+}
@@ -0,0 +1,164 @@
+package virtualroutermanager
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_DHCP_CONFIG_NAME is the ConfigMap holding the dnsmasq
+	// configuration of a router serving DHCP
+	ROUTER_DHCP_CONFIG_NAME string = "virtualrouter-dhcp"
+	// ROUTER_DHCP_DIR is where the dnsmasq configuration is mounted in router
+	// pods, also given to them in the ROUTER_DHCP_DIR environment variable
+	ROUTER_DHCP_DIR string = "/etc/virtualrouter/dhcp"
+	// DHCP_CHECKSUM_ANNOTATION holds the checksum of the dnsmasq configuration
+	// in the pod template. Reservations are left out of it: dnsmasq reads
+	// them again on SIGHUP, so they change without rolling out router pods.
+	DHCP_CHECKSUM_ANNOTATION string = "network.tmaxanc.com/dhcp-checksum"
+
+	// ROUTER_INTERNAL_INTERFACE is the internal interface of router pods the
+	// daemons attach
+	ROUTER_INTERNAL_INTERFACE string = "ethint"
+
+	DEFAULT_DHCP_LEASE_TIME = 12 * time.Hour
+)
+
+const (
+	routerDHCPVolumeName = "dhcp"
+	dnsmasqConfigFile    = "dnsmasq.conf"
+	dhcpHostsFile        = "dhcp-hosts"
+)
+
+// InternalNetwork returns the internal network of the router, nil if it has
+// no valid internal address.
+func InternalNetwork(spec samplev1alpha1.VirtualRouterSpec) *net.IPNet {
+	ip := net.ParseIP(spec.InternalIP).To4()
+	mask := net.ParseIP(spec.InternalNetmask).To4()
+	if ip == nil || mask == nil {
+		return nil
+	}
+	return &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+}
+
+func validateDHCP(spec samplev1alpha1.VirtualRouterSpec) error {
+	dhcp := spec.DHCP
+	if dhcp == nil {
+		return nil
+	}
+	network := InternalNetwork(spec)
+	if network == nil {
+		return fmt.Errorf("dhcp: the router needs an internal address and netmask")
+	}
+	start, end := net.ParseIP(dhcp.RangeStart).To4(), net.ParseIP(dhcp.RangeEnd).To4()
+	switch {
+	case start == nil || !network.Contains(start):
+		return fmt.Errorf("dhcp: range start %q is not in the internal network %s", dhcp.RangeStart, network)
+	case end == nil || !network.Contains(end):
+		return fmt.Errorf("dhcp: range end %q is not in the internal network %s", dhcp.RangeEnd, network)
+	case bytes.Compare(start, end) > 0:
+		return fmt.Errorf("dhcp: range start %s is after its end %s", dhcp.RangeStart, dhcp.RangeEnd)
+	}
+	internalIP := net.ParseIP(spec.InternalIP).To4()
+	if bytes.Compare(start, internalIP) <= 0 && bytes.Compare(internalIP, end) <= 0 {
+		return fmt.Errorf("dhcp: the range holds the router address %s", spec.InternalIP)
+	}
+	if dhcp.LeaseTime != nil && dhcp.LeaseTime.Duration < 2*time.Minute {
+		return fmt.Errorf("dhcp: lease time %s is shorter than 2m", dhcp.LeaseTime.Duration)
+	}
+	for _, server := range dhcp.DNSServers {
+		if net.ParseIP(server).To4() == nil {
+			return fmt.Errorf("dhcp: invalid DNS server %q", server)
+		}
+	}
+	macs, ips := map[string]bool{}, map[string]bool{}
+	for _, reservation := range dhcp.Reservations {
+		mac, err := net.ParseMAC(reservation.MAC)
+		if err != nil {
+			return fmt.Errorf("dhcp: invalid reservation MAC %q", reservation.MAC)
+		}
+		ip := net.ParseIP(reservation.IP).To4()
+		if ip == nil || !network.Contains(ip) || ip.Equal(internalIP) {
+			return fmt.Errorf("dhcp: reservation address %q is not a client address of the internal network %s", reservation.IP, network)
+		}
+		if strings.ContainsAny(reservation.Hostname, ", \t\n") {
+			return fmt.Errorf("dhcp: invalid reservation hostname %q", reservation.Hostname)
+		}
+		if macs[mac.String()] || ips[ip.String()] {
+			return fmt.Errorf("dhcp: MAC %s or address %s reserved more than once", reservation.MAC, reservation.IP)
+		}
+		macs[mac.String()], ips[ip.String()] = true, true
+	}
+	return nil
+}
+
+// renderDHCPConfig renders the dnsmasq configuration of the router: DHCP only
+// on its internal interface, handing out the router as gateway, and a hosts
+// file of the reservations.
+func renderDHCPConfig(virtualRouter *samplev1alpha1.VirtualRouter) map[string]string {
+	dhcp := virtualRouter.Spec.DHCP
+	leaseTime := DEFAULT_DHCP_LEASE_TIME
+	if dhcp.LeaseTime != nil {
+		leaseTime = dhcp.LeaseTime.Duration
+	}
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "interface=%s\n", ROUTER_INTERNAL_INTERFACE)
+	config.WriteString("bind-interfaces\n")
+	// DNS is served by the forwarder of spec.dns, if any
+	config.WriteString("port=0\n")
+	fmt.Fprintf(&config, "dhcp-range=%s,%s,%s,%ds\n", dhcp.RangeStart, dhcp.RangeEnd, virtualRouter.Spec.InternalNetmask, int64(leaseTime/time.Second))
+	fmt.Fprintf(&config, "dhcp-option=option:router,%s\n", virtualRouter.Spec.InternalIP)
+	switch {
+	case len(dhcp.DNSServers) > 0:
+		fmt.Fprintf(&config, "dhcp-option=option:dns-server,%s\n", strings.Join(dhcp.DNSServers, ","))
+	case virtualRouter.Spec.DNS != nil:
+		// the router resolves names itself
+		fmt.Fprintf(&config, "dhcp-option=option:dns-server,%s\n", virtualRouter.Spec.InternalIP)
+	}
+	fmt.Fprintf(&config, "dhcp-hostsfile=%s/%s\n", ROUTER_DHCP_DIR, dhcpHostsFile)
+	if hasPersistence(virtualRouter) {
+		// leases handed out survive the router pod
+		fmt.Fprintf(&config, "dhcp-leasefile=%s/%s\n", ROUTER_STATE_DIR, dhcpLeaseFile)
+	}
+
+	var hosts strings.Builder
+	for _, reservation := range dhcp.Reservations {
+		hosts.WriteString(reservation.MAC + "," + reservation.IP)
+		if reservation.Hostname != "" {
+			hosts.WriteString("," + reservation.Hostname)
+		}
+		hosts.WriteString("\n")
+	}
+	return map[string]string{
+		dnsmasqConfigFile: config.String(),
+		dhcpHostsFile:     hosts.String(),
+	}
+}
+
+// ensureDHCPConfig writes the dnsmasq configuration of a router serving DHCP,
+// and returns the checksum of the part of it router pods are rolled out for.
+// It returns an empty checksum for routers not serving DHCP.
+func (c *Controller) ensureDHCPConfig(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) (string, error) {
+	if virtualRouter.Spec.DHCP == nil {
+		return "", nil
+	}
+	data := renderDHCPConfig(virtualRouter)
+	if err := c.ensureConfigMap(newRouterConfigMap(newNS, virtualRouter, ROUTER_DHCP_CONFIG_NAME, data), virtualRouter, gate); err != nil {
+		return "", err
+	}
+	return configChecksum(map[string]string{dnsmasqConfigFile: data[dnsmasqConfigFile]}), nil
+}
+
+// addRouterDHCPVolume mounts the dnsmasq configuration into the router
+// container.
+func addRouterDHCPVolume(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	mountRouterConfigMap(deployment, routerDHCPVolumeName, routerResourceName(virtualRouter, ROUTER_DHCP_CONFIG_NAME), ROUTER_DHCP_DIR, "ROUTER_DHCP_DIR")
+}
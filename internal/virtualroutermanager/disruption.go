@@ -0,0 +1,102 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const POD_DISRUPTION_BUDGET_NAME string = "virtualrouter-pdb"
+
+// podDisruptionBudgetMinAvailable returns the router pods evictions must
+// leave running, all but one so draining nodes takes down one router pod at
+// a time, and never both pods of an active/standby pair. Routers of a single
+// pod have no budget, as it would block draining its node for good.
+func podDisruptionBudgetMinAvailable(virtualRouter *samplev1alpha1.VirtualRouter) (int32, bool) {
+	replicas := int32(1)
+	if virtualRouter.Spec.Replicas != nil {
+		replicas = *virtualRouter.Spec.Replicas
+	}
+	if virtualRouter.Spec.Autoscaling != nil {
+		// the budget has to hold however far the router is scaled down
+		replicas = autoscalingMinReplicas(virtualRouter.Spec.Autoscaling)
+	}
+	if replicas < 2 {
+		return 0, false
+	}
+	return replicas - 1, true
+}
+
+// newPodDisruptionBudget returns the budget of the router pods of the
+// Deployment, nil if the router has none.
+func newPodDisruptionBudget(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) *policyv1beta1.PodDisruptionBudget {
+	minAvailable, ok := podDisruptionBudgetMinAvailable(virtualRouter)
+	if !ok {
+		return nil
+	}
+	min := intstr.FromInt(int(minAvailable))
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, POD_DISRUPTION_BUDGET_NAME),
+			Namespace: deployment.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &min,
+			Selector:     deployment.Spec.Selector.DeepCopy(),
+		},
+	}
+}
+
+// ensurePodDisruptionBudget creates, updates or deletes the budget of the
+// router pods of the Deployment to follow the replicas of the router.
+func (c *Controller) ensurePodDisruptionBudget(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	name := routerResourceName(virtualRouter, POD_DISRUPTION_BUDGET_NAME)
+	desired := newPodDisruptionBudget(deployment, virtualRouter)
+	pdb, err := c.podDisruptionBudgetsLister.PodDisruptionBudgets(deployment.Namespace).Get(name)
+	if errors.IsNotFound(err) {
+		if desired == nil {
+			return nil
+		}
+		_, err = c.kubeclientset.PolicyV1beta1().PodDisruptionBudgets(deployment.Namespace).Create(c.ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(pdb, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, pdb.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if desired == nil {
+		klog.Infof("Deleting PodDisruptionBudget %s of VirtualRouter %s/%s", pdb.Name, virtualRouter.Namespace, virtualRouter.Name)
+		err := c.kubeclientset.PolicyV1beta1().PodDisruptionBudgets(deployment.Namespace).Delete(c.ctx, pdb.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !reflect.DeepEqual(pdb.Spec.MinAvailable, desired.Spec.MinAvailable) || !reflect.DeepEqual(pdb.Spec.Selector, desired.Spec.Selector) || pdb.Spec.MaxUnavailable != nil {
+		klog.Infof("Updating PodDisruptionBudget %s of VirtualRouter %s/%s", pdb.Name, virtualRouter.Namespace, virtualRouter.Name)
+		pdbCopy := pdb.DeepCopy()
+		pdbCopy.Spec = desired.Spec
+		if _, err := c.kubeclientset.PolicyV1beta1().PodDisruptionBudgets(deployment.Namespace).Update(c.ctx, pdbCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
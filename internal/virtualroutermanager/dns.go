@@ -0,0 +1,198 @@
+package virtualroutermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_DNS_CONFIG_NAME is the ConfigMap holding the dnsmasq
+	// configuration of a router forwarding DNS
+	ROUTER_DNS_CONFIG_NAME string = "virtualrouter-dns"
+	// ROUTER_DNS_DIR is where the dnsmasq configuration is mounted in router
+	// pods, also given to them in the ROUTER_DNS_DIR environment variable
+	ROUTER_DNS_DIR string = "/etc/virtualrouter/dns"
+	// DNS_CHECKSUM_ANNOTATION holds the checksum of the dnsmasq configuration
+	// in the pod template. Local records are left out of it: dnsmasq reads
+	// them again on SIGHUP, so they change without rolling out router pods.
+	DNS_CHECKSUM_ANNOTATION string = "network.tmaxanc.com/dns-checksum"
+	// DNS_HEALTH_ANNOTATION is where the daemons leave the DNSHealth of the
+	// forwarder of a router pod, for the controller to report
+	DNS_HEALTH_ANNOTATION string = "network.tmaxanc.com/dns-health"
+
+	DEFAULT_DNS_HEALTH_CHECK_NAME string = "example.com"
+
+	// DNSHealthy is the DNSHealthy condition reason while every probed
+	// forwarder resolves names
+	DNSHealthy = "DNSHealthy"
+	// ErrDNSUnhealthy is the DNSHealthy condition reason when a forwarder
+	// failed to resolve the health check name
+	ErrDNSUnhealthy = "ErrDNSUnhealthy"
+)
+
+const (
+	routerDNSVolumeName = "dns"
+	dnsHostsFile        = "hosts"
+)
+
+// DNSHealth is the result of the last probe of the forwarder of a router pod.
+type DNSHealth struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+// DNSHealthCheckName returns the name resolved to probe the forwarder.
+func DNSHealthCheckName(dns *samplev1alpha1.DNS) string {
+	if dns.HealthCheckName != "" {
+		return dns.HealthCheckName
+	}
+	return DEFAULT_DNS_HEALTH_CHECK_NAME
+}
+
+func validateDNS(dns *samplev1alpha1.DNS) error {
+	if dns == nil {
+		return nil
+	}
+	if len(dns.Upstreams) == 0 {
+		return fmt.Errorf("dns: no upstream resolver")
+	}
+	for _, upstream := range dns.Upstreams {
+		if net.ParseIP(upstream) == nil {
+			return fmt.Errorf("dns: invalid upstream %q", upstream)
+		}
+	}
+	for _, forwarder := range dns.ConditionalForwarders {
+		if !validDNSName(forwarder.Domain) {
+			return fmt.Errorf("dns: invalid forwarded domain %q", forwarder.Domain)
+		}
+		if len(forwarder.Servers) == 0 {
+			return fmt.Errorf("dns: no resolver for domain %s", forwarder.Domain)
+		}
+		for _, server := range forwarder.Servers {
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("dns: invalid resolver %q for domain %s", server, forwarder.Domain)
+			}
+		}
+	}
+	for _, record := range dns.Records {
+		if !validDNSName(record.Name) {
+			return fmt.Errorf("dns: invalid record name %q", record.Name)
+		}
+		if net.ParseIP(record.IP) == nil {
+			return fmt.Errorf("dns: invalid address %q of record %s", record.IP, record.Name)
+		}
+	}
+	if dns.HealthCheckName != "" && !validDNSName(dns.HealthCheckName) {
+		return fmt.Errorf("dns: invalid health check name %q", dns.HealthCheckName)
+	}
+	return nil
+}
+
+// validDNSName tells apart names dnsmasq takes in its configuration.
+func validDNSName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/#, \t\n")
+}
+
+// renderDNSConfig renders the dnsmasq configuration of the router: a
+// forwarder on its internal interface ignoring the resolv.conf and hosts of
+// the pod, and a hosts file of the local records.
+func renderDNSConfig(virtualRouter *samplev1alpha1.VirtualRouter) map[string]string {
+	dns := virtualRouter.Spec.DNS
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "interface=%s\n", ROUTER_INTERNAL_INTERFACE)
+	config.WriteString("bind-interfaces\n")
+	config.WriteString("no-resolv\n")
+	config.WriteString("no-hosts\n")
+	for _, upstream := range dns.Upstreams {
+		fmt.Fprintf(&config, "server=%s\n", upstream)
+	}
+	for _, forwarder := range dns.ConditionalForwarders {
+		for _, server := range forwarder.Servers {
+			fmt.Fprintf(&config, "server=/%s/%s\n", forwarder.Domain, server)
+		}
+	}
+	fmt.Fprintf(&config, "addn-hosts=%s/%s\n", ROUTER_DNS_DIR, dnsHostsFile)
+
+	var hosts strings.Builder
+	for _, record := range dns.Records {
+		hosts.WriteString(record.IP + " " + record.Name + "\n")
+	}
+	return map[string]string{
+		dnsmasqConfigFile: config.String(),
+		dnsHostsFile:      hosts.String(),
+	}
+}
+
+// ensureDNSConfig writes the dnsmasq configuration of a router forwarding
+// DNS, and returns the checksum of the part of it router pods are rolled out
+// for. It returns an empty checksum for routers not forwarding DNS.
+func (c *Controller) ensureDNSConfig(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) (string, error) {
+	if virtualRouter.Spec.DNS == nil {
+		return "", nil
+	}
+	data := renderDNSConfig(virtualRouter)
+	if err := c.ensureConfigMap(newRouterConfigMap(newNS, virtualRouter, ROUTER_DNS_CONFIG_NAME, data), virtualRouter, gate); err != nil {
+		return "", err
+	}
+	return configChecksum(map[string]string{dnsmasqConfigFile: data[dnsmasqConfigFile]}), nil
+}
+
+// addRouterDNSVolume mounts the dnsmasq configuration into the router
+// container.
+func addRouterDNSVolume(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	mountRouterConfigMap(deployment, routerDNSVolumeName, routerResourceName(virtualRouter, ROUTER_DNS_CONFIG_NAME), ROUTER_DNS_DIR, "ROUTER_DNS_DIR")
+}
+
+// dnsHealthyCondition correlates the DNSHealth the daemons left on the router
+// pods. It returns nil if the router doesn't forward DNS, or no forwarder was
+// probed yet.
+func dnsHealthyCondition(virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod, now time.Time) *metav1.Condition {
+	if virtualRouter.Spec.DNS == nil {
+		return nil
+	}
+	var probed int
+	var unhealthy []string
+	for _, pod := range pods {
+		content, exist := pod.Annotations[DNS_HEALTH_ANNOTATION]
+		if !exist {
+			continue
+		}
+		var health DNSHealth
+		if err := json.Unmarshal([]byte(content), &health); err != nil {
+			continue
+		}
+		probed++
+		if !health.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s on %s: %s", pod.Name, pod.Spec.NodeName, health.Message))
+		}
+	}
+	if probed == 0 {
+		return nil
+	}
+	condition := &metav1.Condition{
+		Type:               samplev1alpha1.DNSHealthyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             DNSHealthy,
+		Message:            fmt.Sprintf("%s resolved by the forwarders of %d router pods", DNSHealthCheckName(virtualRouter.Spec.DNS), probed),
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ErrDNSUnhealthy
+		condition.Message = strings.Join(unhealthy, "; ")
+	}
+	return condition
+}
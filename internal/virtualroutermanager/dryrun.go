@@ -0,0 +1,251 @@
+package virtualroutermanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+)
+
+// DRY_RUN_ANNOTATION set to "true" on a VirtualRouter has the controller and
+// the daemons work out the changes they would make for it without making
+// them: writes are sent to the API server as server-side dry runs, the IPAM
+// and the approval webhook aren't called, and the daemons only report the
+// netlink operations they would perform.
+const DRY_RUN_ANNOTATION string = "network.tmaxanc.com/dry-run"
+
+const (
+	// DryRun is used as part of the Event 'reason' when the changes planned for
+	// a VirtualRouter in dry run change
+	DryRun = "DryRun"
+	// MessageDryRun is the message used for Events listing the planned changes
+	MessageDryRun = "Planned, not applied: %s"
+)
+
+// maxDryRunMessage keeps the planned changes recorded in an Event within the
+// size the API server accepts for its message.
+const maxDryRunMessage = 1000
+
+// IsDryRun reports whether the changes for the VirtualRouter are only planned.
+func IsDryRun(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.GetAnnotations()[DRY_RUN_ANNOTATION] == "true"
+}
+
+// DryRunPlan collects the writes left unapplied by a dry run.
+type DryRunPlan struct {
+	mu         sync.Mutex
+	operations []string
+	// namespaces are the namespaces planned to be created
+	namespaces map[string]bool
+}
+
+func (p *DryRunPlan) add(operation string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.operations = append(p.operations, operation)
+}
+
+func (p *DryRunPlan) addNamespace(namespace string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.namespaces == nil {
+		p.namespaces = map[string]bool{}
+	}
+	p.namespaces[namespace] = true
+}
+
+// inPlannedNamespace reports whether the request is made in a namespace
+// planned to be created, which the API server can't dry run.
+func (p *DryRunPlan) inPlannedNamespace(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parts := strings.Split(path, "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "namespaces" && p.namespaces[parts[i+1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// Operations returns the writes in the order they were made.
+func (p *DryRunPlan) Operations() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.operations...)
+}
+
+// DryRunClients returns the clients a dry run of a sync makes its writes
+// with, adding them to the plan.
+type DryRunClients func(plan *DryRunPlan) (kubernetes.Interface, clientset.Interface, dynamic.Interface, error)
+
+// NewDryRunClients builds the clients of dry runs from the configuration of
+// the controller.
+func NewDryRunClients(cfg *rest.Config) DryRunClients {
+	return func(plan *DryRunPlan) (kubernetes.Interface, clientset.Interface, dynamic.Interface, error) {
+		dryRunCfg := DryRunConfig(cfg, plan)
+		kubeClient, err := kubernetes.NewForConfig(dryRunCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sampleClient, err := clientset.NewForConfig(dryRunCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(dryRunCfg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return kubeClient, sampleClient, dynamicClient, nil
+	}
+}
+
+// DryRunConfig returns a copy of the configuration whose writes are sent as
+// server-side dry runs, so they are validated and defaulted by the API
+// server but not persisted. The writes are logged, and added to the plan if
+// one is given.
+func DryRunConfig(cfg *rest.Config, plan *DryRunPlan) *rest.Config {
+	dryRunCfg := rest.CopyConfig(cfg)
+	dryRunCfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &dryRunRoundTripper{delegate: rt, plan: plan}
+	})
+	return dryRunCfg
+}
+
+type dryRunRoundTripper struct {
+	delegate http.RoundTripper
+	plan     *DryRunPlan
+}
+
+var dryRunVerbs = map[string]string{
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "patch",
+	http.MethodDelete: "delete",
+}
+
+func (rt *dryRunRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	verb, ok := dryRunVerbs[req.Method]
+	if !ok {
+		return rt.delegate.RoundTrip(req)
+	}
+
+	operation := verb + " " + req.URL.Path
+	var body []byte
+	if req.Method == http.MethodPost && req.Body != nil {
+		// the name of a created object is only in the body
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		var object metav1.PartialObjectMetadata
+		if json.Unmarshal(body, &object) == nil && object.Name != "" {
+			operation += "/" + object.Name
+			if rt.plan != nil && req.URL.Path == "/api/v1/namespaces" {
+				rt.plan.addNamespace(object.Name)
+			}
+		}
+		klog.V(4).Infof("[dry-run] %s: %s", operation, body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	klog.Infof("[dry-run] %s", operation)
+	if rt.plan != nil {
+		rt.plan.add(operation)
+		if body != nil && rt.plan.inPlannedNamespace(req.URL.Path) {
+			// the object is created as given, as far as the dry run goes
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       ioutil.NopCloser(bytes.NewReader(body)),
+				Request:    req,
+			}, nil
+		}
+	}
+
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	query.Set("dryRun", metav1.DryRunAll)
+	req.URL.RawQuery = query.Encode()
+	return rt.delegate.RoundTrip(req)
+}
+
+// dryRunController returns a copy of the controller making its writes for
+// the sync of a single VirtualRouter with dry run clients.
+func (c *Controller) dryRunController(plan *DryRunPlan) (*Controller, error) {
+	if c.options.DryRunClients == nil {
+		return nil, fmt.Errorf("dry run requested but no dry run clients are configured")
+	}
+	kubeClient, sampleClient, dynamicClient, err := c.options.DryRunClients(plan)
+	if err != nil {
+		return nil, err
+	}
+	c.optionsLock.RLock()
+	dryRun := *c
+	c.optionsLock.RUnlock()
+	dryRun.kubeclientset = kubeClient
+	dryRun.sampleclientset = sampleClient
+	dryRun.statuskubeclientset = kubeClient
+	dryRun.statusclientset = sampleClient
+	dryRun.dynamicclient = dynamicClient
+	dryRun.dryRunPlan = plan
+	return &dryRun, nil
+}
+
+// syncDryRun syncs the VirtualRouter in dry run, and records the changes
+// planned whenever they differ from those last recorded.
+func (c *Controller) syncDryRun(key string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	plan := &DryRunPlan{}
+	dryRun, err := c.dryRunController(plan)
+	if err != nil {
+		return err
+	}
+	syncErr := dryRun.syncHandler(key)
+
+	operations := strings.Join(plan.Operations(), ", ")
+	if c.dryRunPlans.changed(key, operations) && operations != "" {
+		message := fmt.Sprintf(MessageDryRun, operations)
+		if len(message) > maxDryRunMessage {
+			message = message[:maxDryRunMessage-3] + "..."
+		}
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, DryRun, message)
+	}
+	return syncErr
+}
+
+// dryRunPlans remembers the changes last planned for every VirtualRouter in
+// dry run, whose status isn't written for later syncs to compare with.
+type dryRunPlans struct {
+	mu    sync.Mutex
+	plans map[string]string
+}
+
+func (p *dryRunPlans) changed(key string, operations string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if previous, ok := p.plans[key]; ok && previous == operations {
+		return false
+	}
+	p.plans[key] = operations
+	return true
+}
+
+func (p *dryRunPlans) forget(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.plans, key)
+}
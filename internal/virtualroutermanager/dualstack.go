@@ -0,0 +1,76 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// IsDualStack tells whether the router routes IPv6 along with IPv4.
+func IsDualStack(spec samplev1alpha1.VirtualRouterSpec) bool {
+	return spec.InternalIPv6CIDR != "" || spec.ExternalIPv6CIDR != ""
+}
+
+// validateDualStack keeps each family in its own fields: the IPv4 fields take
+// IPv4 addresses only, and the IPv6 ones come in pairs, as tenants of a
+// router without an IPv6 uplink have no route out.
+func validateDualStack(spec samplev1alpha1.VirtualRouterSpec) error {
+	for _, field := range []struct{ name, value string }{
+		{"internalIP", spec.InternalIP},
+		{"externalIP", spec.ExternalIP},
+		{"gatewayIP", spec.GatewayIP},
+	} {
+		if field.value != "" && net.ParseIP(field.value).To4() == nil {
+			return fmt.Errorf("%s %q: not an IPv4 address, IPv6 goes in the IPv6 fields", field.name, field.value)
+		}
+	}
+	if !IsDualStack(spec) {
+		if spec.GatewayIPv6 != "" {
+			return fmt.Errorf("gatewayIPv6: the router has no IPv6 addresses")
+		}
+		return nil
+	}
+	if spec.InternalIPv6CIDR == "" || spec.ExternalIPv6CIDR == "" {
+		return fmt.Errorf("dual-stack: internalIPv6CIDR and externalIPv6CIDR are given together")
+	}
+	internal, err := parseIPv6CIDR("internalIPv6CIDR", spec.InternalIPv6CIDR)
+	if err != nil {
+		return err
+	}
+	external, err := parseIPv6CIDR("externalIPv6CIDR", spec.ExternalIPv6CIDR)
+	if err != nil {
+		return err
+	}
+	if internal.Contains(external.IP) || external.Contains(internal.IP) {
+		return fmt.Errorf("dual-stack: internal network %s and external network %s overlap", internal, external)
+	}
+	if spec.GatewayIPv6 != "" {
+		gateway := net.ParseIP(spec.GatewayIPv6)
+		switch {
+		case gateway == nil || gateway.To4() != nil:
+			return fmt.Errorf("gatewayIPv6 %q: not an IPv6 address", spec.GatewayIPv6)
+		case !gateway.IsLinkLocalUnicast() && !external.Contains(gateway):
+			return fmt.Errorf("gatewayIPv6 %s: neither link-local nor in the external network %s", spec.GatewayIPv6, external)
+		}
+	}
+	return nil
+}
+
+// parseIPv6CIDR parses an IPv6 address of the router with its prefix length,
+// returning its network.
+func parseIPv6CIDR(field string, cidr string) (*net.IPNet, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	switch {
+	case err != nil || ip.To4() != nil:
+		return nil, fmt.Errorf("%s %q: not an IPv6 address with prefix length", field, cidr)
+	case ip.IsLinkLocalUnicast() || ip.IsMulticast():
+		return nil, fmt.Errorf("%s %s: not a unicast address out of link-local", field, cidr)
+	case ip.Equal(network.IP):
+		ones, _ := network.Mask.Size()
+		if ones < 127 {
+			return nil, fmt.Errorf("%s %s: the subnet-router anycast address", field, cidr)
+		}
+	}
+	return network, nil
+}
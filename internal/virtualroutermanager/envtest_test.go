@@ -0,0 +1,149 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+)
+
+// startEnvtestCluster starts a kube-apiserver and etcd with the CRDs of
+// deploy/integrated and the rule CRDs of tmax-cloud/virtualrouter, copied
+// to testdata/crd, installed, and runs the controller with the options
+// against them until the test ends. Only the API server runs, so other
+// controllers, such as that of Deployments, are played by the tests as
+// with startCluster. The test is skipped unless KUBEBUILDER_ASSETS tells
+// where the binaries are.
+func startEnvtestCluster(t *testing.T, options Options) *cluster {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, no kube-apiserver and etcd to run")
+	}
+	environment := &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "deploy", "integrated"),
+			// without them the controller waits on the rule informers forever
+			filepath.Join("testdata", "crd"),
+		},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := environment.Start()
+	if err != nil {
+		t.Fatalf("error starting envtest: %v", err)
+	}
+	// cleanups run last in first out, so after the controller stops
+	t.Cleanup(func() {
+		if err := environment.Stop(); err != nil {
+			t.Errorf("error stopping envtest: %v", err)
+		}
+	})
+
+	c := &cluster{
+		t:          t,
+		kubeclient: kubernetes.NewForConfigOrDie(config),
+		client:     clientset.NewForConfigOrDie(config),
+	}
+	c.run(dynamic.NewForConfigOrDie(config), options)
+	return c
+}
+
+func TestEnvtestReconcileVirtualRouter(t *testing.T) {
+	testReconcileVirtualRouter(startEnvtestCluster(t, Options{}))
+}
+
+// TestEnvtestCRDValidation checks the CRD rejects the VirtualRouters its
+// formats and CEL rules don't admit, which only an API server evaluates. It
+// is skipped by API servers older than the CEL rules.
+func TestEnvtestCRDValidation(t *testing.T) {
+	c := startEnvtestCluster(t, Options{})
+	version, err := c.kubeclient.Discovery().ServerVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minor, _ := strconv.Atoi(strings.TrimSuffix(version.Minor, "+")); version.Major == "1" && minor < 25 {
+		t.Skipf("kube-apiserver %s evaluates no CEL rules of CRDs, 1.25 and later do", version.GitVersion)
+	}
+	virtualRouters := c.client.TmaxV1().VirtualRouters(metav1.NamespaceDefault)
+	for i, test := range []struct {
+		name     string
+		mutate   func(spec *networkcontroller.VirtualRouterSpec)
+		expected string
+	}{
+		{"valid", func(spec *networkcontroller.VirtualRouterSpec) {}, ""},
+		{"internal IP", func(spec *networkcontroller.VirtualRouterSpec) { spec.InternalIP = "10.0.0.256" }, "not an IPv4 address"},
+		{"internal IP with a leading zero", func(spec *networkcontroller.VirtualRouterSpec) { spec.InternalIP = "10.0.0.01" }, "not an IPv4 address"},
+		{"internal netmask", func(spec *networkcontroller.VirtualRouterSpec) { spec.InternalNetmask = "255.255.255" }, "not an IPv4 netmask"},
+		{"external IP with a prefix", func(spec *networkcontroller.VirtualRouterSpec) { spec.ExternalIP = "192.168.9.10/24" }, "not an IPv4 address"},
+		{"external netmask", func(spec *networkcontroller.VirtualRouterSpec) { spec.ExternalNetmask = "ffff:ff00::" }, "not an IPv4 netmask"},
+		{"IPv6 gateway", func(spec *networkcontroller.VirtualRouterSpec) { spec.GatewayIP = "fd00::1" }, "not an IPv4 address"},
+		{"no image", func(spec *networkcontroller.VirtualRouterSpec) { spec.Image = "" }, "image is given unless a profile is referenced"},
+		{"profile", func(spec *networkcontroller.VirtualRouterSpec) {
+			spec.Image = ""
+			spec.ProfileRef = &networkcontroller.VirtualRouterProfileReference{Name: "edge"}
+		}, ""},
+		{"internal IPv6 alone", func(spec *networkcontroller.VirtualRouterSpec) { spec.InternalIPv6CIDR = "fd00:10::1/64" }, "internalIPv6CIDR and externalIPv6CIDR are given together"},
+		{"external IPv6 alone", func(spec *networkcontroller.VirtualRouterSpec) { spec.ExternalIPv6CIDR = "2001:db8::10/64" }, "internalIPv6CIDR and externalIPv6CIDR are given together"},
+		{"dual-stack", func(spec *networkcontroller.VirtualRouterSpec) {
+			spec.InternalIPv6CIDR, spec.ExternalIPv6CIDR = "fd00:10::1/64", "2001:db8::10/64"
+		}, ""},
+	} {
+		virtualRouter := newVirtualRouter("validation-"+strconv.Itoa(i), int32Ptr(1))
+		virtualRouter.Spec.InternalIP = "10.0.0.1"
+		virtualRouter.Spec.InternalNetmask = "255.255.255.0"
+		virtualRouter.Spec.ExternalIP = "192.168.9.10"
+		virtualRouter.Spec.ExternalNetmask = "255.255.255.0"
+		virtualRouter.Spec.GatewayIP = "192.168.9.1"
+		virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.1"
+		test.mutate(&virtualRouter.Spec)
+		_, err := virtualRouters.Create(context.TODO(), virtualRouter, metav1.CreateOptions{})
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("%s: expected the VirtualRouter admitted, got %v", test.name, err)
+		case test.expected != "" && (!errors.IsInvalid(err) || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("%s: expected the VirtualRouter rejected with %q, got %v", test.name, test.expected, err)
+		}
+	}
+
+	// the placement of a router is set once, the default being Namespace
+	virtualRouter := newVirtualRouter("placement", int32Ptr(1))
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.1"
+	if _, err := virtualRouters.Create(context.TODO(), virtualRouter, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("error creating VirtualRouter: %v", err)
+	}
+	for _, test := range []struct {
+		strategy networkcontroller.VirtualRouterPlacementStrategy
+		expected string
+	}{
+		{networkcontroller.NamespacePlacementStrategy, ""},
+		{networkcontroller.TenantPlacementStrategy, "placement.strategy can't be changed once the router is created"},
+		{"", ""},
+	} {
+		// the controller updates the status meanwhile
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			current, err := virtualRouters.Get(context.TODO(), virtualRouter.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			current.Spec.Placement.Strategy = test.strategy
+			_, err = virtualRouters.Update(context.TODO(), current, metav1.UpdateOptions{})
+			return err
+		})
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("strategy %q: expected the update admitted, got %v", test.strategy, err)
+		case test.expected != "" && (!errors.IsInvalid(err) || !strings.Contains(err.Error(), test.expected)):
+			t.Errorf("strategy %q: expected the update rejected with %q, got %v", test.strategy, test.expected, err)
+		}
+	}
+}
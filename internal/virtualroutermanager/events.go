@@ -0,0 +1,153 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// Events are only recorded when the status of a VirtualRouter changes, as
+// an event per sync would pile up in etcd on busy clusters. The status is
+// what was last written, so a transition is reported once even across
+// controller restarts.
+const (
+	// BecameReady is used as part of the Event 'reason' when a VirtualRouter becomes Running
+	BecameReady = "BecameReady"
+	// Degraded is used as part of the Event 'reason' when a VirtualRouter becomes Degraded
+	Degraded = "Degraded"
+	// ConfigApplied is used as part of the Event 'reason' and the ConfigApplied
+	// condition reason when the daemons applied the current spec to every router pod
+	ConfigApplied = "ConfigApplied"
+	// ConfigApplying is the ConfigApplied condition reason while the daemons
+	// haven't applied the current spec to every router pod yet
+	ConfigApplying = "Applying"
+	// ErrConfigApplyFailed is used as part of the Event 'reason' when a daemon
+	// failed to apply the spec to a router pod
+	ErrConfigApplyFailed = "ErrConfigApplyFailed"
+)
+
+// configAppliedCondition correlates the results the daemons left on the
+// router pods: the generation applied to the data plane, in
+// APPLIED_GENERATION_ANNOTATION, and a failure, in the readiness gate.
+func configAppliedCondition(virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod, now time.Time) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               samplev1alpha1.ConfigAppliedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             ConfigApplied,
+		Message:            fmt.Sprintf("Generation %d applied to %d router pods", virtualRouter.Generation, len(pods)),
+	}
+	var failed, pending []string
+	for _, pod := range pods {
+		for _, podCondition := range pod.Status.Conditions {
+			if podCondition.Type == VIRTUALROUTER_READINESS_GATE && podCondition.Status == corev1.ConditionFalse && podCondition.Reason != "" {
+				failed = append(failed, fmt.Sprintf("%s on %s: %s", pod.Name, pod.Spec.NodeName, podCondition.Message))
+				condition.Reason = podCondition.Reason
+			}
+		}
+		applied, err := strconv.ParseInt(pod.Annotations[APPLIED_GENERATION_ANNOTATION], 10, 64)
+		if err != nil || applied < virtualRouter.Generation {
+			pending = append(pending, pod.Name)
+		}
+	}
+	switch {
+	case len(failed) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Message = strings.Join(failed, "; ")
+	case len(pending) > 0:
+		sort.Strings(pending)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ConfigApplying
+		condition.Message = fmt.Sprintf("Waiting for generation %d to be applied to %s", virtualRouter.Generation, strings.Join(pending, ", "))
+	}
+	return condition
+}
+
+// appliedRevision returns the generation applied to every router pod, the
+// lowest one the daemons left in APPLIED_GENERATION_ANNOTATION, 0 while a pod
+// has none.
+func appliedRevision(pods []*corev1.Pod) int64 {
+	var revision int64
+	for i, pod := range pods {
+		applied, err := strconv.ParseInt(pod.Annotations[APPLIED_GENERATION_ANNOTATION], 10, 64)
+		if err != nil {
+			return 0
+		}
+		if i == 0 || applied < revision {
+			revision = applied
+		}
+	}
+	return revision
+}
+
+// recordTransitionEvents records the changes between the statuses worth an
+// event.
+func (c *Controller) recordTransitionEvents(virtualRouter *samplev1alpha1.VirtualRouter, old, new samplev1alpha1.VirtualRouterStatus) {
+	if c.dryRunPlan != nil {
+		// the status written in dry run isn't kept
+		return
+	}
+	if new.Phase != old.Phase {
+		switch new.Phase {
+		case samplev1alpha1.VirtualRouterRunning:
+			c.recorder.Eventf(virtualRouter, corev1.EventTypeNormal, BecameReady, "Router is active on node %s", new.ActiveNode)
+		case samplev1alpha1.VirtualRouterDegraded:
+			if condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.DataPlaneHealthyCondition); condition != nil && condition.Status == metav1.ConditionFalse {
+				c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, Degraded, "Data plane unhealthy: %s", condition.Message)
+				break
+			}
+			c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, Degraded, "Only %d router pods available", new.AvailableReplicas)
+		}
+	}
+
+	c.recordPauseEvents(virtualRouter, old, new)
+	c.recordPendingChangesEvents(virtualRouter, old, new)
+
+	if meta.IsStatusConditionTrue(new.Conditions, samplev1alpha1.DeploymentNameConflictCondition) && !meta.IsStatusConditionTrue(old.Conditions, samplev1alpha1.DeploymentNameConflictCondition) {
+		condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.DeploymentNameConflictCondition)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrDeploymentNameConflict, condition.Message)
+	}
+
+	if meta.IsStatusConditionTrue(new.Conditions, samplev1alpha1.NamespaceConflictCondition) && !meta.IsStatusConditionTrue(old.Conditions, samplev1alpha1.NamespaceConflictCondition) {
+		condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.NamespaceConflictCondition)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrNamespaceConflict, condition.Message)
+	}
+
+	if meta.IsStatusConditionTrue(new.Conditions, samplev1alpha1.InvalidSpecCondition) {
+		condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.InvalidSpecCondition)
+		if previous := meta.FindStatusCondition(old.Conditions, samplev1alpha1.InvalidSpecCondition); previous == nil || previous.Status != metav1.ConditionTrue || previous.Message != condition.Message {
+			c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrInvalidSpec, condition.Message)
+		}
+	}
+
+	if meta.IsStatusConditionTrue(new.Conditions, samplev1alpha1.DegradedCondition) {
+		condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.DegradedCondition)
+		if previous := meta.FindStatusCondition(old.Conditions, samplev1alpha1.DegradedCondition); previous == nil || previous.Status != metav1.ConditionTrue || previous.Message != condition.Message {
+			c.recorder.Event(virtualRouter, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		}
+	}
+
+	condition := meta.FindStatusCondition(new.Conditions, samplev1alpha1.ConfigAppliedCondition)
+	if condition == nil {
+		return
+	}
+	previous := meta.FindStatusCondition(old.Conditions, samplev1alpha1.ConfigAppliedCondition)
+	if previous != nil && previous.Status == condition.Status && previous.Reason == condition.Reason && previous.ObservedGeneration == condition.ObservedGeneration {
+		return
+	}
+	switch {
+	case condition.Status == metav1.ConditionTrue:
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, ConfigApplied, condition.Message)
+	case condition.Reason != ConfigApplying:
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrConfigApplyFailed, condition.Message)
+	}
+}
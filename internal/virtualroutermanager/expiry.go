@@ -0,0 +1,267 @@
+package virtualroutermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// The NAT, firewall and load balancer rule types belong to the router, so the
+// expiry of a temporary rule is given in its annotations:
+// RULE_EXPIRES_AT_ANNOTATION holds an RFC3339 time, and RULE_TTL_ANNOTATION a
+// duration counted from the creation of the rule. RULE_EXPIRY_ACTION_ANNOTATION
+// chooses whether an expired rule is deleted (the default) or deactivated.
+const (
+	RULE_EXPIRES_AT_ANNOTATION    string = "network.tmaxanc.com/expires-at"
+	RULE_TTL_ANNOTATION           string = "network.tmaxanc.com/ttl"
+	RULE_EXPIRY_ACTION_ANNOTATION string = "network.tmaxanc.com/expiry-action"
+	// RULE_EXPIRED_RULES_ANNOTATION keeps the rules of a deactivated rule, which
+	// are restored if its expiry is moved to the future.
+	RULE_EXPIRED_RULES_ANNOTATION string = "network.tmaxanc.com/expired-rules"
+)
+
+const (
+	RULE_EXPIRY_ACTION_DELETE     string = "Delete"
+	RULE_EXPIRY_ACTION_DEACTIVATE string = "Deactivate"
+)
+
+// DEFAULT_RULE_EXPIRY_WARNING is how long ahead of expiry a warning is emitted
+// unless set otherwise.
+const DEFAULT_RULE_EXPIRY_WARNING time.Duration = 15 * time.Minute
+
+const (
+	// RuleExpiring is used as part of the Event 'reason' ahead of the expiry of a rule
+	RuleExpiring = "RuleExpiring"
+	// RuleExpired is used as part of the Event 'reason' when a rule is deleted or deactivated
+	RuleExpired = "RuleExpired"
+	// ErrInvalidRuleExpiry is used as part of the Event 'reason' when the expiry annotations of a rule can't be parsed
+	ErrInvalidRuleExpiry = "ErrInvalidRuleExpiry"
+)
+
+// ruleResources are the rule types applied by router pods.
+var ruleResources = []struct {
+	resource schema.GroupVersionResource
+	kind     string
+}{
+	{natRuleResource, "NATRule"},
+	{firewallRuleResource, "FireWallRule"},
+	{loadBalancerRuleResource, "LoadBalancerRule"},
+}
+
+// expireRules deletes or deactivates the expired rules of the router
+// namespace, warns of the rules about to expire, and returns the expiry of
+// every temporary rule left along with the rules of invalid expiries. Rules
+// aren't expired or restored while the gate freezes changes.
+func (c *Controller) expireRules(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) ([]samplev1alpha1.RuleExpiration, []samplev1alpha1.InvalidRuleExpiry, error) {
+	now := c.clock.Now()
+	warning := c.ruleExpiryWarning()
+
+	var expirations []samplev1alpha1.RuleExpiration
+	var invalids []samplev1alpha1.InvalidRuleExpiry
+	for _, r := range ruleResources {
+		rules := c.dynamicclient.Resource(r.resource).Namespace(newNS)
+		list, err := c.listRules(r.resource, newNS)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rule := range list {
+			expiresAt, ok, err := ruleExpiry(rule)
+			if err != nil {
+				invalid := samplev1alpha1.InvalidRuleExpiry{Kind: r.kind, Name: rule.GetName(), Value: ruleExpiryAnnotation(rule)}
+				// the warning is emitted once per value, not on every sync
+				warned := false
+				for _, previous := range virtualRouter.Status.InvalidRuleExpiries {
+					if previous == invalid {
+						warned = true
+					}
+				}
+				if !warned {
+					c.recorder.Event(rule, corev1.EventTypeWarning, ErrInvalidRuleExpiry, err.Error())
+				}
+				invalids = append(invalids, invalid)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			expiration := samplev1alpha1.RuleExpiration{Kind: r.kind, Name: rule.GetName(), ExpiresAt: metav1.NewTime(expiresAt)}
+			// the warning is emitted once per expiry
+			for _, previous := range virtualRouter.Status.RuleExpirations {
+				if previous.Kind == expiration.Kind && previous.Name == expiration.Name && previous.ExpiresAt.Equal(&expiration.ExpiresAt) {
+					expiration.Warned = previous.Warned
+				}
+			}
+
+			if !now.Before(expiresAt) && gate.freezes(changeRuleExpiry) {
+				// expired once the freeze is lifted
+			} else if !now.Before(expiresAt) {
+				if rule.GetAnnotations()[RULE_EXPIRY_ACTION_ANNOTATION] != RULE_EXPIRY_ACTION_DEACTIVATE {
+					klog.Infof("Deleting %s %s/%s expired at %s", r.kind, newNS, rule.GetName(), expiresAt.Format(time.RFC3339))
+					if err := rules.Delete(c.ctx, rule.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+						return nil, nil, err
+					}
+					c.recorder.Eventf(rule, corev1.EventTypeNormal, RuleExpired, "Deleted, expired at %s", expiresAt.Format(time.RFC3339))
+					continue
+				}
+				if _, deactivated := rule.GetAnnotations()[RULE_EXPIRED_RULES_ANNOTATION]; !deactivated {
+					klog.Infof("Deactivating %s %s/%s expired at %s", r.kind, newNS, rule.GetName(), expiresAt.Format(time.RFC3339))
+					if err := setRulesActive(rule, false); err != nil {
+						return nil, nil, err
+					}
+					if _, err := rules.Update(c.ctx, rule, metav1.UpdateOptions{}); err != nil {
+						return nil, nil, err
+					}
+					c.recorder.Eventf(rule, corev1.EventTypeNormal, RuleExpired, "Deactivated, expired at %s", expiresAt.Format(time.RFC3339))
+				}
+				expiration.Expired = true
+			} else if _, deactivated := rule.GetAnnotations()[RULE_EXPIRED_RULES_ANNOTATION]; deactivated && !gate.freezes(changeRuleExpiry) {
+				klog.Infof("Restoring %s %s/%s extended to %s", r.kind, newNS, rule.GetName(), expiresAt.Format(time.RFC3339))
+				if err := setRulesActive(rule, true); err != nil {
+					return nil, nil, err
+				}
+				if _, err := rules.Update(c.ctx, rule, metav1.UpdateOptions{}); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			if !expiration.Expired && !expiration.Warned && !now.Before(expiresAt.Add(-warning)) {
+				c.recorder.Eventf(rule, corev1.EventTypeWarning, RuleExpiring, "Expires at %s", expiresAt.Format(time.RFC3339))
+				expiration.Warned = true
+			}
+			expirations = append(expirations, expiration)
+		}
+	}
+	return expirations, invalids, nil
+}
+
+// ruleLister returns the informer cache of the rule resource, or nil if it
+// isn't watched.
+func (c *Controller) ruleLister(resource schema.GroupVersionResource) cache.GenericLister {
+	switch resource {
+	case natRuleResource:
+		return c.natRulesLister
+	case firewallRuleResource:
+		return c.firewallRulesLister
+	case loadBalancerRuleResource:
+		return c.loadBalancerRulesLister
+	}
+	return nil
+}
+
+// listRules returns copies of the rules of the resource in the router
+// namespace from the informer cache, by name. LoadBalancerRules are only
+// watched with the LoadBalancerBackendServices feature gate on, and none
+// are returned without it.
+func (c *Controller) listRules(resource schema.GroupVersionResource, newNS string) ([]*unstructured.Unstructured, error) {
+	lister := c.ruleLister(resource)
+	if lister == nil {
+		return nil, nil
+	}
+	cached, err := lister.ByNamespace(newNS).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*unstructured.Unstructured, 0, len(cached))
+	for _, obj := range cached {
+		rules = append(rules, obj.(*unstructured.Unstructured).DeepCopy())
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].GetName() < rules[j].GetName() })
+	return rules, nil
+}
+
+// nextRuleExpiryCheck returns how long until the next warning or expiry of
+// the rules, or 0 if there is none.
+func (c *Controller) nextRuleExpiryCheck(expirations []samplev1alpha1.RuleExpiration) time.Duration {
+	warning := c.ruleExpiryWarning()
+	var next time.Duration
+	for _, expiration := range expirations {
+		if expiration.Expired {
+			continue
+		}
+		at := expiration.ExpiresAt.Time
+		if !expiration.Warned {
+			at = at.Add(-warning)
+		}
+		if d := at.Sub(c.clock.Now()); next == 0 || d < next {
+			next = d
+		}
+	}
+	if next < 0 {
+		// a check due now is retried right away
+		next = time.Second
+	}
+	return next
+}
+
+// ruleExpiryAnnotation returns the annotation value the expiry of a rule is
+// given by, RULE_EXPIRES_AT_ANNOTATION taking precedence.
+func ruleExpiryAnnotation(rule *unstructured.Unstructured) string {
+	annotations := rule.GetAnnotations()
+	if expiresAt, ok := annotations[RULE_EXPIRES_AT_ANNOTATION]; ok {
+		return expiresAt
+	}
+	return annotations[RULE_TTL_ANNOTATION]
+}
+
+// ruleExpiry returns the expiry of a rule given in its annotations, if any.
+func ruleExpiry(rule *unstructured.Unstructured) (time.Time, bool, error) {
+	annotations := rule.GetAnnotations()
+	if expiresAt, ok := annotations[RULE_EXPIRES_AT_ANNOTATION]; ok {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %s %q: %v", RULE_EXPIRES_AT_ANNOTATION, expiresAt, err)
+		}
+		return t, true, nil
+	}
+	if ttl, ok := annotations[RULE_TTL_ANNOTATION]; ok {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid %s %q: %v", RULE_TTL_ANNOTATION, ttl, err)
+		}
+		return rule.GetCreationTimestamp().Add(d), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// setRulesActive empties the rules of a deactivated rule into an annotation,
+// or restores them from it.
+func setRulesActive(rule *unstructured.Unstructured, active bool) error {
+	annotations := rule.GetAnnotations()
+	spec, _, err := unstructured.NestedMap(rule.Object, "spec")
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	if active {
+		var rules []interface{}
+		if err := json.Unmarshal([]byte(annotations[RULE_EXPIRED_RULES_ANNOTATION]), &rules); err != nil {
+			return fmt.Errorf("invalid %s: %v", RULE_EXPIRED_RULES_ANNOTATION, err)
+		}
+		spec["rules"] = rules
+		delete(annotations, RULE_EXPIRED_RULES_ANNOTATION)
+	} else {
+		rules, err := json.Marshal(spec["rules"])
+		if err != nil {
+			return err
+		}
+		annotations[RULE_EXPIRED_RULES_ANNOTATION] = string(rules)
+		spec["rules"] = []interface{}{}
+	}
+	rule.SetAnnotations(annotations)
+	return unstructured.SetNestedMap(rule.Object, spec, "spec")
+}
@@ -0,0 +1,245 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// FAST_PATH_PACKETS_METRIC is the metric of the packets the daemons forward
+// on the fast path or leave to the packet filter, by path
+const FAST_PATH_PACKETS_METRIC string = "virtualrouter_fast_path_packets"
+
+// FastPathRule is a 1:1 NAT rule the fast path translates: the source
+// address of an internal host for SNAT, the destination address for DNAT.
+// Replies are translated back by the same rule.
+type FastPathRule struct {
+	SNAT      bool
+	Match     net.IP
+	Translate net.IP
+	// Rule is the NATRule rule it was taken from, such as web[0]
+	Rule string
+}
+
+// FastPathPlan is what the fast path of a router takes.
+type FastPathPlan struct {
+	Rules []FastPathRule
+	// Ineligible are why the other NAT rules are left to the packet filter,
+	// by rule
+	Ineligible map[string]string
+	// Fallback is why the whole router is left to the packet filter, empty
+	// if it isn't
+	Fallback string
+}
+
+func validateFastPath(spec samplev1alpha1.VirtualRouterSpec) error {
+	switch spec.FastPath {
+	case "":
+		return nil
+	case samplev1alpha1.XDPFastPath:
+	default:
+		return fmt.Errorf("unknown fast path %q", spec.FastPath)
+	}
+	if !features.Enabled(features.XDPFastPath) {
+		return fmt.Errorf("spec.fastPath needs the %s feature gate", features.XDPFastPath)
+	}
+	return nil
+}
+
+// fastPathFallback returns why packets of the router can't skip the packet
+// filter and traffic control, which the fast path bypasses, empty if they
+// can.
+func fastPathFallback(spec samplev1alpha1.VirtualRouterSpec) string {
+	switch {
+	case spec.FirewallHardening != nil:
+		return "spec.firewallHardening filters every forwarded packet"
+	case spec.Logging != nil:
+		return "spec.logging logs forwarded packets"
+	case spec.FlowExport != nil:
+		return "spec.flowExport exports connections from conntrack"
+	case spec.QoS != nil:
+		return "spec.qos shapes traffic with tc"
+	case spec.SNATPool != nil:
+		return "spec.snatPool translates sources by connection"
+	case len(spec.Tunnels) > 0 || spec.WireGuard != nil:
+		return "tunnels aren't forwarded to by the fast path"
+	}
+	return ""
+}
+
+// singleHost returns the IPv4 address of a host address, given with or
+// without /32, nil for networks, ports and IPv6 addresses.
+func singleHost(address string) net.IP {
+	if strings.Contains(address, "/") {
+		ip, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil
+		}
+		if ones, bits := network.Mask.Size(); ones != bits {
+			return nil
+		}
+		address = ip.String()
+	}
+	return net.ParseIP(address).To4()
+}
+
+// fastPathRule returns the fast path rule of a NAT rule, or why it can't be
+// translated on the fast path: only single IPv4 hosts translated 1:1, with
+// no protocol, port or further match, are.
+func fastPathRule(rule nfvv1.Rules) (FastPathRule, string) {
+	switch {
+	case rule.Match.Protocol != "":
+		return FastPathRule{}, "matches a protocol or port"
+	case len(rule.Args) > 0:
+		return FastPathRule{}, "has further arguments"
+	case rule.Action.SrcIP != "" && rule.Action.DstIP != "":
+		return FastPathRule{}, "translates both addresses"
+	case rule.Action.SrcIP != "":
+		if rule.Match.DstIP != "" {
+			return FastPathRule{}, "SNAT matching a destination"
+		}
+		match, translate := singleHost(rule.Match.SrcIP), singleHost(rule.Action.SrcIP)
+		if match == nil || translate == nil {
+			return FastPathRule{}, "SNAT of no single IPv4 host"
+		}
+		return FastPathRule{SNAT: true, Match: match, Translate: translate}, ""
+	case rule.Action.DstIP != "":
+		if rule.Match.SrcIP != "" {
+			return FastPathRule{}, "DNAT matching a source"
+		}
+		match, translate := singleHost(rule.Match.DstIP), singleHost(rule.Action.DstIP)
+		if match == nil || translate == nil {
+			return FastPathRule{}, "DNAT of no single IPv4 host"
+		}
+		return FastPathRule{Match: match, Translate: translate}, ""
+	}
+	return FastPathRule{}, "translates no address"
+}
+
+// planFastPath picks the NAT rules of the NATRules the fast path translates.
+// A rule whose addresses are already translated by an earlier one is left
+// to the packet filter, which would only apply the earlier one. So is a rule
+// whose outside address, the one replies are translated back from, is the
+// external IP of the router or forwards ports to the packet filter: the fast
+// path would take every packet to the address for the rule.
+func planFastPath(spec samplev1alpha1.VirtualRouterSpec, natRules []nfvv1.NATRule) *FastPathPlan {
+	plan := &FastPathPlan{Ineligible: map[string]string{}}
+	if plan.Fallback = fastPathFallback(spec); plan.Fallback != "" {
+		return plan
+	}
+	shared := map[string]string{}
+	if externalIP := net.ParseIP(spec.ExternalIP).To4(); externalIP != nil {
+		shared[externalIP.String()] = "the external IP of the router"
+	}
+	for _, natRule := range natRules {
+		for index, rule := range natRule.Spec.Rules {
+			if rule.Action.DstIP == "" || rule.Match.Protocol == "" {
+				continue
+			}
+			if address := singleHost(rule.Match.DstIP); address != nil && shared[address.String()] == "" {
+				shared[address.String()] = fmt.Sprintf("the ports forwarded by %s[%d]", natRule.Name, index)
+			}
+		}
+	}
+	taken := map[string]string{}
+	for _, natRule := range natRules {
+		for index, rule := range natRule.Spec.Rules {
+			id := fmt.Sprintf("%s[%d]", natRule.Name, index)
+			fastRule, reason := fastPathRule(rule)
+			if reason != "" {
+				plan.Ineligible[id] = reason
+				continue
+			}
+			outside := fastRule.Match
+			if fastRule.SNAT {
+				outside = fastRule.Translate
+			}
+			if sharer, exist := shared[outside.String()]; exist {
+				plan.Ineligible[id] = "shares its address with " + sharer
+				continue
+			}
+			// either address of a 1:1 rule is translated back for replies
+			keys := []string{fmt.Sprintf("%t|%s", fastRule.SNAT, fastRule.Match), fmt.Sprintf("%t|%s", !fastRule.SNAT, fastRule.Translate)}
+			if earlier, exist := taken[keys[0]]; exist {
+				plan.Ineligible[id] = "overlaps " + earlier
+				continue
+			}
+			if earlier, exist := taken[keys[1]]; exist {
+				plan.Ineligible[id] = "overlaps " + earlier
+				continue
+			}
+			taken[keys[0]], taken[keys[1]] = id, id
+			fastRule.Rule = id
+			plan.Rules = append(plan.Rules, fastRule)
+		}
+	}
+	return plan
+}
+
+// FastPathListers look the rules of router namespaces up for PlanFastPath.
+type FastPathListers struct {
+	NATRules          cache.GenericLister
+	FireWallRules     cache.GenericLister
+	LoadBalancerRules cache.GenericLister
+}
+
+// NewFastPathListers returns the listers of the informers of the rules of
+// the factory, which is to be started.
+func NewFastPathListers(factory dynamicinformer.DynamicSharedInformerFactory) *FastPathListers {
+	return &FastPathListers{
+		NATRules:          factory.ForResource(natRuleResource).Lister(),
+		FireWallRules:     factory.ForResource(nfvv1.SchemeGroupVersion.WithResource("firewallrules")).Lister(),
+		LoadBalancerRules: factory.ForResource(nfvv1.SchemeGroupVersion.WithResource("loadbalancerrules")).Lister(),
+	}
+}
+
+// PlanFastPath looks the NATRules of the router namespace up and picks the
+// rules the fast path translates. The router falls back to the packet filter
+// altogether while it has FireWallRules or LoadBalancerRules, which the fast
+// path would skip.
+func PlanFastPath(listers *FastPathListers, namespace string, spec samplev1alpha1.VirtualRouterSpec) (*FastPathPlan, error) {
+	for _, resource := range []struct {
+		lister cache.GenericLister
+		kind   string
+	}{{listers.FireWallRules, "FireWallRules"}, {listers.LoadBalancerRules, "LoadBalancerRules"}} {
+		list, err := resource.lister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			return &FastPathPlan{Fallback: resource.kind + " filter or balance the traffic", Ineligible: map[string]string{}}, nil
+		}
+	}
+	list, err := listers.NATRules.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var natRules []nfvv1.NATRule
+	for _, object := range list {
+		item, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var natRule nfvv1.NATRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &natRule); err != nil {
+			klog.Warningf("Ignoring NATRule %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		natRules = append(natRules, natRule)
+	}
+	// informers list in no particular order, while earlier rules win
+	sort.Slice(natRules, func(i, j int) bool { return natRules[i].Name < natRules[j].Name })
+	return planFastPath(spec, natRules), nil
+}
@@ -0,0 +1,131 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// MANAGEMENT_FIREWALL_RULE_NAME is the FireWallRule pinned by the controller in
+// every router namespace. Tenant rules only populate the FORWARD chain of the
+// router, so the router's own traffic to the API server and its probes is never
+// filtered; what a tenant default-deny can cut is forwarded traffic between the
+// management networks or the DNS resolvers of the router and the tenant hosts,
+// which this rule keeps open.
+//
+// The router appends the rules of every FireWallRule to its forward_fwrule
+// chain in the order it receives them, and has no way to order them
+// otherwise. The controller reverts any change made to this rule, but a
+// tenant DROP rule the router received before it still matches first.
+const MANAGEMENT_FIREWALL_RULE_NAME string = "virtualrouter-management"
+
+// DNS_PORT is the port the DNS resolvers of a router are exempted on.
+const DNS_PORT = 53
+
+// The generated NFV clientset targets a stale API group, so FireWallRules are
+// handled with the dynamic client on the group the router pods watch.
+var firewallRuleResource = nfvv1.SchemeGroupVersion.WithResource("firewallrules")
+
+// ensureManagementFirewallRule creates the management FireWallRule of the
+// router, reverting any change made to it, and deletes it once there is
+// nothing left to exempt. It is reverted even while a NetworkFreeze is
+// present, an incident being when management must not be locked out.
+func (c *Controller) ensureManagementFirewallRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	var desiredObj *unstructured.Unstructured
+	if desired := newManagementFirewallRule(newNS, virtualRouter, c.managementCIDRs()); desired != nil {
+		var err error
+		if desiredObj, err = toUnstructured(desired); err != nil {
+			return err
+		}
+	}
+	return c.ensureManagedRule(firewallRuleResource, newNS, virtualRouter, routerResourceName(virtualRouter, MANAGEMENT_FIREWALL_RULE_NAME), desiredObj, "management firewall rule", nil)
+}
+
+// newManagementFirewallRule accepts all traffic from and to the management
+// networks, which the controller, the daemons, the health probes and the
+// metrics scrapers reach the tenant networks from, and DNS from and to the
+// resolvers of the router. It returns nil if there is neither.
+func newManagementFirewallRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, managementCIDRs []string) *nfvv1.FireWallRule {
+	var rules []nfvv1.Rules
+	for _, cidr := range managementCIDRs {
+		rules = append(rules,
+			nfvv1.Rules{
+				Match:  nfvv1.Match{SrcIP: cidr, Protocol: "all"},
+				Action: nfvv1.Action{Policy: "ACCEPT"},
+			},
+			nfvv1.Rules{
+				Match:  nfvv1.Match{DstIP: cidr, Protocol: "all"},
+				Action: nfvv1.Action{Policy: "ACCEPT"},
+			})
+	}
+	for _, resolver := range dnsResolvers(virtualRouter.Spec.DNS) {
+		for _, protocol := range []string{"udp", "tcp"} {
+			rules = append(rules,
+				nfvv1.Rules{
+					Match:  nfvv1.Match{DstIP: resolver, Protocol: fmt.Sprintf("%s --dport %d", protocol, DNS_PORT)},
+					Action: nfvv1.Action{Policy: "ACCEPT"},
+				},
+				nfvv1.Rules{
+					Match:  nfvv1.Match{SrcIP: resolver, Protocol: fmt.Sprintf("%s --sport %d", protocol, DNS_PORT)},
+					Action: nfvv1.Action{Policy: "ACCEPT"},
+				})
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &nfvv1.FireWallRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nfvv1.SchemeGroupVersion.String(),
+			Kind:       "FireWallRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, MANAGEMENT_FIREWALL_RULE_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: nfvv1.FireWallRuleSpec{
+			Rules: rules,
+		},
+	}
+}
+
+// dnsResolvers returns the upstreams and the conditional forwarders of the
+// DNS forwarder of a router, each once.
+func dnsResolvers(dns *samplev1alpha1.DNS) []string {
+	if dns == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var resolvers []string
+	add := func(server string) {
+		if !seen[server] {
+			seen[server] = true
+			resolvers = append(resolvers, server)
+		}
+	}
+	for _, upstream := range dns.Upstreams {
+		add(upstream)
+	}
+	for _, forwarder := range dns.ConditionalForwarders {
+		for _, server := range forwarder.Servers {
+			add(server)
+		}
+	}
+	return resolvers
+}
+
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
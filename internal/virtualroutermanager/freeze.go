@@ -0,0 +1,63 @@
+package virtualroutermanager
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// NetworkFrozen is used as part of the Event 'reason' and the
+	// PendingChanges condition reason while changes to the data plane of the
+	// router are held back by a NetworkFreeze
+	NetworkFrozen = "NetworkFrozen"
+	// MessageNetworkFrozen is the message used for Events and the
+	// PendingChanges condition while a NetworkFreeze holds changes back
+	MessageNetworkFrozen = "Deferred while NetworkFreeze %s is present"
+)
+
+// The changes to the data plane of a router held back by a freeze alone
+const (
+	changeScale         = "scaling router pods"
+	changeManagedRules  = "updating the rules compiled for the router"
+	changeBackends      = "updating load balancer backends"
+	changeRuleExpiry    = "expiring rules"
+	changeDnsmasqConfig = "updating the DHCP and DNS configuration"
+)
+
+// activeFreeze returns the NetworkFreeze present, the first by name if there
+// are several, nil if none.
+func (c *Controller) activeFreeze() *samplev1alpha1.NetworkFreeze {
+	freezes, err := c.networkFreezesLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return nil
+	}
+	if len(freezes) == 0 {
+		return nil
+	}
+	sort.Slice(freezes, func(i, j int) bool { return freezes[i].Name < freezes[j].Name })
+	return freezes[0]
+}
+
+// handleFreeze enqueues every VirtualRouter, to hold its changes back or
+// make them once the freeze is lifted.
+func (c *Controller) handleFreeze(obj interface{}) {
+	if _, ok := obj.(*samplev1alpha1.NetworkFreeze); !ok {
+		if _, ok := obj.(cache.DeletedFinalStateUnknown); !ok {
+			return
+		}
+	}
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, virtualRouter := range virtualRouters {
+		c.enqueueVirtualRouter(virtualRouter)
+	}
+}
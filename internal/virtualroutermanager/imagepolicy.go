@@ -0,0 +1,303 @@
+package virtualroutermanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ErrImageNotAllowed is used as part of the Event 'reason' and the
+	// Degraded condition reason when the image is of no allowed registry
+	ErrImageNotAllowed = "ErrImageNotAllowed"
+	// ErrImageNotVerified is used as part of the Event 'reason' and the
+	// Degraded condition reason when the signature of the image isn't verified
+	ErrImageNotVerified = "ErrImageNotVerified"
+
+	// DEFAULT_REGISTRY is the registry of the images naming none.
+	DEFAULT_REGISTRY string = "docker.io"
+	// IMAGE_VERIFICATION_CACHE_TTL is how long a verified image digest is
+	// trusted before its signature is verified again.
+	IMAGE_VERIFICATION_CACHE_TTL time.Duration = time.Hour
+	// IMAGE_RESOLUTION_CACHE_TTL is how long the digest a tag was resolved to
+	// is used before the tag is resolved again.
+	IMAGE_RESOLUTION_CACHE_TTL time.Duration = 5 * time.Minute
+)
+
+// ImageVerifier verifies the signature of a router image before routers are
+// rolled onto it. The image is resolved to the digest it refers to first, and
+// the digest verified is the one the router pods run, so that the tag can't
+// be moved to an image never verified.
+type ImageVerifier interface {
+	// Resolve returns the digest, such as sha256:..., the image refers to.
+	Resolve(ctx context.Context, image string) (string, error)
+	// Verify verifies the signature of the image, named with its digest.
+	Verify(ctx context.Context, image string) error
+}
+
+// CosignImageVerifier runs cosign with the public key. The cosign binary is
+// looked up in the PATH of the controller.
+type CosignImageVerifier struct {
+	PublicKey string
+	Timeout   time.Duration
+}
+
+func NewCosignImageVerifier(publicKey string, timeout time.Duration) *CosignImageVerifier {
+	return &CosignImageVerifier{
+		PublicKey: publicKey,
+		Timeout:   timeout,
+	}
+}
+
+// Resolve has cosign triangulate name the signature of the image, which is
+// tagged with the digest of the image as <algorithm>-<hex>.sig.
+func (v *CosignImageVerifier) Resolve(ctx context.Context, image string) (string, error) {
+	output, err := v.cosign(ctx, "triangulate", "--", image)
+	if err != nil {
+		return "", err
+	}
+	signature := strings.TrimSpace(string(output))
+	tag := signature[strings.LastIndex(signature, ":")+1:]
+	if !strings.HasSuffix(tag, ".sig") || !strings.Contains(tag, "-") {
+		return "", fmt.Errorf("cosign triangulate %s: unexpected signature %s", image, signature)
+	}
+	return strings.Replace(strings.TrimSuffix(tag, ".sig"), "-", ":", 1), nil
+}
+
+func (v *CosignImageVerifier) Verify(ctx context.Context, image string) error {
+	_, err := v.cosign(ctx, "verify", "--key", v.PublicKey, "--", image)
+	return err
+}
+
+// cosign runs cosign with the args, the image always last, after "--" so
+// that it is never taken for a flag.
+func (v *CosignImageVerifier) cosign(ctx context.Context, args ...string) ([]byte, error) {
+	if v.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	command := exec.CommandContext(ctx, "cosign", args...)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("cosign %s %s: %v: %s", args[0], args[len(args)-1], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// pinnedImage returns the image named with the digest, such as
+// tmaxcloudck/virtualrouter:v0.2.5@sha256:..., the image itself if it is
+// named with a digest already.
+func pinnedImage(image string, digest string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+	return image + "@" + digest
+}
+
+// imageRepository returns the repository of the image with its registry,
+// such as docker.io/tmaxcloudck/virtualrouter for tmaxcloudck/virtualrouter:v0.2.5.
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 || !(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		if len(parts) == 1 {
+			image = "library/" + image
+		}
+		image = DEFAULT_REGISTRY + "/" + image
+	}
+	return image
+}
+
+// imageAllowed tells if the image is of a registry, or a repository path of
+// a registry such as registry.example.com/tmax, of the allow-list. Every
+// image is allowed if the list is empty.
+func imageAllowed(image string, allowedRegistries []string) bool {
+	if len(allowedRegistries) == 0 {
+		return true
+	}
+	repository := imageRepository(image)
+	for _, allowed := range allowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if repository == allowed || strings.HasPrefix(repository, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// verifiedImages remembers when the image digests were last verified, so
+// that their signatures aren't verified on every sync, and the digests the
+// images were last resolved to, which the router pods are pinned to.
+type verifiedImages struct {
+	lock     sync.Mutex
+	verified map[string]time.Time
+	resolved map[string]resolvedImage
+}
+
+// resolvedImage is the image named with the digest it was resolved to.
+type resolvedImage struct {
+	pinned   string
+	resolved time.Time
+}
+
+func newVerifiedImages() *verifiedImages {
+	return &verifiedImages{verified: map[string]time.Time{}, resolved: map[string]resolvedImage{}}
+}
+
+func (v *verifiedImages) get(pinned string, now time.Time) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	verified, ok := v.verified[pinned]
+	return ok && now.Sub(verified) < IMAGE_VERIFICATION_CACHE_TTL
+}
+
+func (v *verifiedImages) set(pinned string, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.verified[pinned] = now
+}
+
+// pinned returns the image named with the digest it was last resolved to,
+// and if the resolution is still fresh at now.
+func (v *verifiedImages) pinned(image string, now time.Time) (string, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	resolved, ok := v.resolved[image]
+	return resolved.pinned, ok && now.Sub(resolved.resolved) < IMAGE_RESOLUTION_CACHE_TTL
+}
+
+func (v *verifiedImages) resolve(image string, pinned string, now time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.resolved[image] = resolvedImage{pinned: pinned, resolved: now}
+}
+
+// imagePolicyError is why the image of a router breaks the image policy.
+type imagePolicyError struct {
+	reason string
+	err    error
+}
+
+func (e *imagePolicyError) Error() string {
+	return e.err.Error()
+}
+
+// checkImagePolicy fails unless the images of every container the router
+// pods run, the router, its sidecars and those added by
+// spec.overrides.podTemplate, are of an allowed registry and, with an
+// ImageVerifier, their signatures are verified. The init container runs the
+// image the controller is configured with, and is left out.
+func (c *Controller) checkImagePolicy(virtualRouter *samplev1alpha1.VirtualRouter) error {
+	template, err := routerPodTemplate(virtualRouter, c.sidecarProfiles())
+	if err != nil {
+		return err
+	}
+	for _, container := range template.Spec.Containers {
+		if err := c.checkImage(container.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkImage fails unless the image is of an allowed registry and, with an
+// ImageVerifier, the signature of the digest it is resolved to is verified.
+func (c *Controller) checkImage(image string) error {
+	if allowedRegistries := c.allowedRegistries(); !imageAllowed(image, allowedRegistries) {
+		return &imagePolicyError{
+			reason: ErrImageNotAllowed,
+			err:    fmt.Errorf("image %s is of none of the allowed registries %s", image, strings.Join(allowedRegistries, ", ")),
+		}
+	}
+	if c.options.ImageVerifier == nil {
+		return nil
+	}
+	pinned, fresh := c.verifiedImages.pinned(image, c.clock.Now())
+	if !fresh {
+		digest, err := c.options.ImageVerifier.Resolve(c.ctx, image)
+		if err != nil {
+			return &imagePolicyError{
+				reason: ErrImageNotVerified,
+				err:    fmt.Errorf("digest of image %s isn't resolved: %v", image, err),
+			}
+		}
+		pinned = pinnedImage(image, digest)
+	}
+	if !c.verifiedImages.get(pinned, c.clock.Now()) {
+		if err := c.options.ImageVerifier.Verify(c.ctx, pinned); err != nil {
+			return &imagePolicyError{
+				reason: ErrImageNotVerified,
+				err:    fmt.Errorf("signature of image %s isn't verified: %v", pinned, err),
+			}
+		}
+		c.verifiedImages.set(pinned, c.clock.Now())
+	}
+	// only digests verified are ever pinned
+	if !fresh {
+		c.verifiedImages.resolve(image, pinned, c.clock.Now())
+	}
+	return nil
+}
+
+// pinImages names the images of the containers of the router pods with the
+// digests they were verified with, so that the pods run what was verified
+// whatever the tags refer to later. Images are left as they are without an
+// ImageVerifier.
+func (c *Controller) pinImages(podSpec *corev1.PodSpec) {
+	if c.options.ImageVerifier == nil {
+		return
+	}
+	for i := range podSpec.Containers {
+		if pinned, _ := c.verifiedImages.pinned(podSpec.Containers[i].Image, c.clock.Now()); pinned != "" {
+			podSpec.Containers[i].Image = pinned
+		}
+	}
+}
+
+// reportImagePolicy holds the router back while its image breaks the image
+// policy. Images of registries not allowed are synced again when the spec or
+// the allow-list changes, while failed verifications are retried as they may
+// only have failed to reach the registry.
+func (c *Controller) reportImagePolicy(virtualRouter *samplev1alpha1.VirtualRouter, err *imagePolicyError) error {
+	klog.Warningf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	if updateErr := c.reportDegraded(virtualRouter, err.reason, err.Error()); updateErr != nil {
+		return updateErr
+	}
+	if err.reason == ErrImageNotVerified {
+		return err
+	}
+	return nil
+}
+
+// reportDegraded sets the Degraded condition of the VirtualRouter held back
+// for the reason.
+func (c *Controller) reportDegraded(virtualRouter *samplev1alpha1.VirtualRouter, reason string, message string) error {
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.DegradedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             reason,
+		Message:            message,
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, nil)
+}
@@ -0,0 +1,157 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// VIRTUALROUTER_UID_INDEX indexes the VirtualRouters by UID, which the
+// objects of routers name their owner by
+const VIRTUALROUTER_UID_INDEX string = "uid"
+
+// routerSelector selects the Deployments and the pods of routers, the only
+// ones the controller caches.
+var routerSelector = labels.Set{"app": VIRTUALROUTER_LABEL}.String()
+
+// stripObject drops what the controller never reads from an object before it
+// is cached: the managed fields, and the configuration last applied by
+// kubectl, which hold as much again as the object.
+func stripObject(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	accessor.SetManagedFields(nil)
+	if annotations := accessor.GetAnnotations(); annotations != nil {
+		if _, exist := annotations[corev1.LastAppliedConfigAnnotation]; exist {
+			delete(annotations, corev1.LastAppliedConfigAnnotation)
+			accessor.SetAnnotations(annotations)
+		}
+	}
+}
+
+// strippedListWatch lists and watches the routers' objects of a resource,
+// stripped before they are cached.
+func strippedListWatch(list func(metav1.ListOptions) (runtime.Object, error), watchFunc func(metav1.ListOptions) (watch.Interface, error)) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = routerSelector
+			objects, err := list(options)
+			if err != nil {
+				return nil, err
+			}
+			err = meta.EachListItem(objects, func(obj runtime.Object) error {
+				stripObject(obj)
+				return nil
+			})
+			return objects, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = routerSelector
+			w, err := watchFunc(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				stripObject(event.Object)
+				return event, true
+			}), nil
+		},
+	}
+}
+
+// NewRouterDeploymentInformer returns the informer of the router Deployments
+// of every namespace, caching them stripped. It is registered with
+// SharedInformerFactory.InformerFor before the Deployment informer of the
+// factory is requested, which it then stands in for.
+func NewRouterDeploymentInformer(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(strippedListWatch(
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().Deployments(metav1.NamespaceAll).List(context.TODO(), options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().Deployments(metav1.NamespaceAll).Watch(context.TODO(), options)
+		},
+	), &appsv1.Deployment{}, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
+// NewRouterStatefulSetInformer returns the informer of the router
+// StatefulSets of every namespace, caching them stripped, as
+// NewRouterDeploymentInformer.
+func NewRouterStatefulSetInformer(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(strippedListWatch(
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().StatefulSets(metav1.NamespaceAll).List(context.TODO(), options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().StatefulSets(metav1.NamespaceAll).Watch(context.TODO(), options)
+		},
+	), &appsv1.StatefulSet{}, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
+// NewRouterPodInformer returns the informer of the router pods of every
+// namespace, caching them stripped, as NewRouterDeploymentInformer.
+func NewRouterPodInformer(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(strippedListWatch(
+		func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), options)
+		},
+		func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Pods(metav1.NamespaceAll).Watch(context.TODO(), options)
+		},
+	), &corev1.Pod{}, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
+func virtualRouterUIDIndexFunc(obj interface{}) ([]string, error) {
+	virtualRouter, ok := obj.(*samplev1alpha1.VirtualRouter)
+	if !ok {
+		return nil, fmt.Errorf("expected a VirtualRouter, got %T", obj)
+	}
+	return []string{string(virtualRouter.UID)}, nil
+}
+
+// virtualRouterOf returns the VirtualRouter an object of a router is
+// controlled by, looked up by UID, or by name in the namespace of the object
+// if the VirtualRouters aren't indexed by UID.
+func (c *Controller) virtualRouterOf(namespace string, ownerRef *metav1.OwnerReference) (*samplev1alpha1.VirtualRouter, error) {
+	owners, err := c.virtualRoutersIndexer.ByIndex(VIRTUALROUTER_UID_INDEX, string(ownerRef.UID))
+	if err != nil {
+		return c.virtualRoutersLister.VirtualRouters(namespace).Get(ownerRef.Name)
+	}
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("no VirtualRouter of UID %s", ownerRef.UID)
+	}
+	return owners[0].(*samplev1alpha1.VirtualRouter), nil
+}
+
+// adoptDeployment labels the Deployment of the VirtualRouter if it was
+// created before router Deployments were labelled, which leaves it out of
+// the cache. Deployments of others are returned as they are.
+func (c *Controller) adoptDeployment(namespace, name string, virtualRouter *samplev1alpha1.VirtualRouter) (*appsv1.Deployment, error) {
+	deployment, err := c.kubeclientset.AppsV1().Deployments(namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if err != nil || !metav1.IsControlledBy(deployment, virtualRouter) || deployment.Labels["app"] == VIRTUALROUTER_LABEL {
+		return deployment, err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": RouterPodLabels(virtualRouter)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.kubeclientset.AppsV1().Deployments(namespace).Patch(c.ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
@@ -0,0 +1,260 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/fake"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions"
+)
+
+// cluster runs the controller against the clientsets of a cluster: the
+// informers list and watch the clientsets, so objects created with them
+// reach the controller through the watches, and what the controller writes
+// is read back from them. Unlike the fixture, no action is expected; tests
+// wait for the objects to converge.
+type cluster struct {
+	t          *testing.T
+	kubeclient kubernetes.Interface
+	client     clientset.Interface
+}
+
+// startCluster starts the controller with the options on fake clientsets
+// holding the objects until the test ends.
+func startCluster(t *testing.T, options Options, objects ...runtime.Object) *cluster {
+	var kubeobjects, nfvobjects []runtime.Object
+	for _, object := range objects {
+		if _, ok := object.(*networkcontroller.VirtualRouter); ok {
+			nfvobjects = append(nfvobjects, object)
+			continue
+		}
+		kubeobjects = append(kubeobjects, object)
+	}
+	kubeclient, client := k8sfake.NewSimpleClientset(kubeobjects...), fake.NewSimpleClientset(nfvobjects...)
+	c := &cluster{t: t, kubeclient: kubeclient, client: client}
+
+	// the handlers drop updates keeping the resource version or generation,
+	// which the object trackers of the fake clientsets leave as written, and
+	// updates of the objects keep their status as on an API server
+	var resourceVersion uint64
+	bumpVersions := func(tracker core.ObjectTracker) core.ReactionFunc {
+		return func(action core.Action) (bool, runtime.Object, error) {
+			var object runtime.Object
+			switch action := action.(type) {
+			case core.CreateAction:
+				object = action.GetObject()
+			case core.UpdateAction:
+				object = action.GetObject()
+			}
+			accessor, err := meta.Accessor(object)
+			if err != nil {
+				return false, nil, nil
+			}
+			accessor.SetResourceVersion(strconv.FormatUint(atomic.AddUint64(&resourceVersion, 1), 10))
+			if action.GetVerb() != "update" || action.GetSubresource() != "" {
+				return false, nil, nil
+			}
+			stored, err := tracker.Get(action.GetResource(), action.GetNamespace(), accessor.GetName())
+			if err != nil {
+				return false, nil, nil
+			}
+			storedAccessor, _ := meta.Accessor(stored)
+			accessor.SetGeneration(storedAccessor.GetGeneration())
+			if !equality.Semantic.DeepEqual(field(stored, "spec"), field(object, "spec")) {
+				accessor.SetGeneration(storedAccessor.GetGeneration() + 1)
+			}
+			if status := field(stored, "status"); status != nil {
+				updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+				if err != nil {
+					return true, nil, err
+				}
+				updated["status"] = status
+				if err := runtime.DefaultUnstructuredConverter.FromUnstructured(updated, object); err != nil {
+					return true, nil, err
+				}
+			}
+			return false, nil, nil
+		}
+	}
+	kubeclient.PrependReactor("*", "*", bumpVersions(kubeclient.Tracker()))
+	client.PrependReactor("*", "*", bumpVersions(client.Tracker()))
+
+	c.run(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), options)
+	return c
+}
+
+// run runs the controller with the options on the clientsets of the cluster
+// until the test ends.
+func (c *cluster) run(dynamicclient dynamic.Interface, options Options) {
+	t := c.t
+	i := informers.NewSharedInformerFactory(c.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(c.kubeclient, noResyncPeriodFunc())
+	controller := NewController(c.kubeclient, c.client, dynamicclient,
+		k8sI.Apps().V1().Deployments(), k8sI.Apps().V1().StatefulSets(), k8sI.Policy().V1beta1().PodDisruptionBudgets(),
+		k8sI.Autoscaling().V2beta2().HorizontalPodAutoscalers(), k8sI.Core().V1().Pods(),
+		k8sI.Core().V1().Nodes(), k8sI.Core().V1().Services(), k8sI.Discovery().V1beta1().EndpointSlices(),
+		i.Tmax().V1().VirtualRouters(), i.Tmax().V1().VirtualRouterProfiles(), i.Tmax().V1().NetworkFreezes(), options)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+	go func() {
+		defer close(done)
+		if err := controller.Run(1, stopCh); err != nil {
+			t.Errorf("error running controller: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		close(stopCh)
+		<-done
+	})
+}
+
+// field returns the top-level field of the object, nil if it has none.
+func field(object runtime.Object, name string) interface{} {
+	unstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil
+	}
+	return unstructured[name]
+}
+
+// eventually waits for the condition to hold, failing the test if it
+// doesn't in time.
+func (c *cluster) eventually(what string, condition func() (bool, error)) {
+	c.t.Helper()
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, condition); err != nil {
+		c.t.Fatalf("waiting for %s: %v", what, err)
+	}
+}
+
+// exists returns a condition holding once the object can be got.
+func exists(get func() error) func() (bool, error) {
+	return func() (bool, error) {
+		if err := get(); errors.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// virtualRouter returns the VirtualRouter as stored.
+func (c *cluster) virtualRouter(namespace, name string) *networkcontroller.VirtualRouter {
+	c.t.Helper()
+	virtualRouter, err := c.client.TmaxV1().VirtualRouters(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.t.Fatalf("error getting VirtualRouter %s/%s: %v", namespace, name, err)
+	}
+	return virtualRouter
+}
+
+// rollOut plays the Deployment controller, reporting every replica of the
+// Deployment available.
+func (c *cluster) rollOut(deployment *apps.Deployment) {
+	c.t.Helper()
+	deployment = deployment.DeepCopy()
+	deployment.Status.ObservedGeneration = deployment.Generation
+	deployment.Status.Replicas = *deployment.Spec.Replicas
+	deployment.Status.UpdatedReplicas = *deployment.Spec.Replicas
+	deployment.Status.ReadyReplicas = *deployment.Spec.Replicas
+	deployment.Status.AvailableReplicas = *deployment.Spec.Replicas
+	if _, err := c.kubeclient.AppsV1().Deployments(deployment.Namespace).UpdateStatus(context.TODO(), deployment, metav1.UpdateOptions{}); err != nil {
+		c.t.Fatalf("error updating the status of Deployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+	}
+}
+
+func TestReconcileVirtualRouter(t *testing.T) {
+	testReconcileVirtualRouter(startCluster(t, Options{}))
+}
+
+// testReconcileVirtualRouter has the cluster go through the full
+// reconciliation of a VirtualRouter, from its creation to its status
+// following its Deployment.
+func testReconcileVirtualRouter(c *cluster) {
+	t := c.t
+	virtualRouter := newVirtualRouter("test", int32Ptr(1))
+	// the CRD admits no router without an image or a profile
+	virtualRouter.Spec.Image = "tmaxcloudck/virtualrouter:0.0.1"
+	if _, err := c.client.TmaxV1().VirtualRouters(virtualRouter.Namespace).Create(context.TODO(), virtualRouter, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("error creating VirtualRouter: %v", err)
+	}
+
+	newNS := virtualRouter.Name
+	kube := c.kubeclient
+	c.eventually("the router namespace", exists(func() error {
+		_, err := kube.CoreV1().Namespaces().Get(context.TODO(), newNS, metav1.GetOptions{})
+		return err
+	}))
+	c.eventually("the router service account", exists(func() error {
+		_, err := kube.CoreV1().ServiceAccounts(newNS).Get(context.TODO(), newServiceAccount(newNS, virtualRouter).Name, metav1.GetOptions{})
+		return err
+	}))
+	c.eventually("the router role", exists(func() error {
+		_, err := kube.RbacV1().Roles(newNS).Get(context.TODO(), newRole(newNS, virtualRouter).Name, metav1.GetOptions{})
+		return err
+	}))
+	c.eventually("the router role binding", exists(func() error {
+		_, err := kube.RbacV1().RoleBindings(newNS).Get(context.TODO(), newRoleBinding(newNS, virtualRouter).Name, metav1.GetOptions{})
+		return err
+	}))
+	var deployment *apps.Deployment
+	c.eventually("the router deployment", exists(func() (err error) {
+		deployment, err = kube.AppsV1().Deployments(newNS).Get(context.TODO(), virtualRouter.Spec.DeploymentName, metav1.GetOptions{})
+		return err
+	}))
+	if !metav1.IsControlledBy(deployment, c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name)) {
+		t.Errorf("expected the deployment to be controlled by the VirtualRouter, got owners %v", deployment.OwnerReferences)
+	}
+	c.eventually("the VirtualRouter to be pending", func() (bool, error) {
+		return c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name).Status.Phase == networkcontroller.VirtualRouterPending, nil
+	})
+
+	// the status follows the deployment through its watch
+	c.rollOut(deployment)
+	c.eventually("the VirtualRouter to be running", func() (bool, error) {
+		status := c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name).Status
+		return status.Phase == networkcontroller.VirtualRouterRunning && status.AvailableReplicas == 1, nil
+	})
+
+	// and the deployment follows the spec
+	stored := c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name)
+	stored.Spec.Replicas = int32Ptr(2)
+	if _, err := c.client.TmaxV1().VirtualRouters(stored.Namespace).Update(context.TODO(), stored, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("error updating VirtualRouter: %v", err)
+	}
+	c.eventually("the deployment to be scaled", func() (bool, error) {
+		var err error
+		deployment, err = kube.AppsV1().Deployments(newNS).Get(context.TODO(), virtualRouter.Spec.DeploymentName, metav1.GetOptions{})
+		return err == nil && *deployment.Spec.Replicas == 2, err
+	})
+	c.eventually("the VirtualRouter to be upgrading", func() (bool, error) {
+		return c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name).Status.Phase == networkcontroller.VirtualRouterUpgrading, nil
+	})
+	c.rollOut(deployment)
+	c.eventually("the VirtualRouter to be running both replicas", func() (bool, error) {
+		status := c.virtualRouter(virtualRouter.Namespace, virtualRouter.Name).Status
+		return status.Phase == networkcontroller.VirtualRouterRunning && status.AvailableReplicas == 2, nil
+	})
+}
@@ -0,0 +1,216 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// INTER_NETWORK_RULE_NAME is the FireWallRule the controller compiles the
+// inter-network policies of a router into
+const INTER_NETWORK_RULE_NAME string = "virtualrouter-inter-network"
+
+// ROUTER_INTERNAL_NETWORK_INTERFACE_PREFIX is what the interfaces of the
+// internal networks of router pods are named with, followed by the name
+const ROUTER_INTERNAL_NETWORK_INTERFACE_PREFIX string = "int-"
+
+// internalNetworkName is a name of an internal network, its interface in
+// router pods taking int- and the name within the 15 characters of a link
+var internalNetworkName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,8}[a-z0-9])?$`)
+
+// internalNetwork is an internal network of the router, the network matched
+// by the rules and the table of its VRF, 0 for the router table
+type internalNetwork struct {
+	network  *net.IPNet
+	vrfTable int32
+}
+
+// internalNetworks returns the internal networks of the spec by name, that of
+// spec.internalIP as default if it has one.
+func internalNetworks(spec samplev1alpha1.VirtualRouterSpec) (map[string]internalNetwork, []string, error) {
+	networks := map[string]internalNetwork{}
+	var names []string
+	if ip := net.ParseIP(spec.InternalIP).To4(); ip != nil {
+		mask := net.IPMask(net.ParseIP(spec.InternalNetmask).To4())
+		if ones, bits := mask.Size(); bits == 0 || ones == 0 {
+			return nil, nil, fmt.Errorf("internal network: invalid netmask %q", spec.InternalNetmask)
+		}
+		networks[samplev1alpha1.DEFAULT_INTERNAL_NETWORK_NAME] = internalNetwork{
+			network: &net.IPNet{IP: ip.Mask(mask), Mask: mask},
+		}
+		names = append(names, samplev1alpha1.DEFAULT_INTERNAL_NETWORK_NAME)
+	}
+	for _, network := range spec.InternalNetworks {
+		ip, ipNet, err := net.ParseCIDR(network.CIDR)
+		if err != nil || ip.To4() == nil {
+			return nil, nil, fmt.Errorf("internal network %s: invalid IPv4 CIDR %q", network.Name, network.CIDR)
+		}
+		networks[network.Name] = internalNetwork{
+			network:  ipNet,
+			vrfTable: network.VRFTable,
+		}
+		names = append(names, network.Name)
+	}
+	return networks, names, nil
+}
+
+func validateInternalNetworks(spec samplev1alpha1.VirtualRouterSpec) error {
+	if len(spec.InternalNetworks) == 0 && len(spec.InterNetworkPolicies) == 0 {
+		return nil
+	}
+	vlans := map[int32]string{}
+	if spec.VlanNumber != 0 {
+		vlans[spec.VlanNumber] = samplev1alpha1.DEFAULT_INTERNAL_NETWORK_NAME
+	}
+	tables := map[int32]bool{}
+	for _, table := range spec.PolicyRouting {
+		tables[table.ID] = true
+	}
+	for _, network := range spec.InternalNetworks {
+		switch {
+		case !internalNetworkName.MatchString(network.Name):
+			return fmt.Errorf("internal network %q: the name is at most 10 lowercase letters, digits and '-'", network.Name)
+		case network.Name == samplev1alpha1.DEFAULT_INTERNAL_NETWORK_NAME:
+			return fmt.Errorf("internal network %s: the name is that of the network of spec.internalIP", network.Name)
+		case network.VlanNumber < 1 || network.VlanNumber > 4094:
+			return fmt.Errorf("internal network %s: vlan %d is not 1 to 4094", network.Name, network.VlanNumber)
+		case network.VRFTable < 0 || network.VRFTable > 252:
+			return fmt.Errorf("internal network %s: VRF table %d is not 1 to 252", network.Name, network.VRFTable)
+		case network.VRFTable == ROUTER_ROUTING_TABLE:
+			return fmt.Errorf("internal network %s: VRF table %d is used by the router itself", network.Name, network.VRFTable)
+		case tables[network.VRFTable]:
+			return fmt.Errorf("internal network %s: VRF table %d is a policy routing table", network.Name, network.VRFTable)
+		}
+		if other, taken := vlans[network.VlanNumber]; taken {
+			return fmt.Errorf("internal network %s: vlan %d is that of %s", network.Name, network.VlanNumber, other)
+		}
+		vlans[network.VlanNumber] = network.Name
+	}
+	networks, names, err := internalNetworks(spec)
+	if err != nil {
+		return err
+	}
+	if len(names) != len(networks) {
+		return fmt.Errorf("internal networks: a name is given more than once")
+	}
+	// networks of different VRFs are routed apart, and may overlap
+	for i, name := range names {
+		for _, other := range names[:i] {
+			if networks[name].vrfTable != networks[other].vrfTable {
+				continue
+			}
+			if networks[name].network.Contains(networks[other].network.IP) || networks[other].network.Contains(networks[name].network.IP) {
+				return fmt.Errorf("internal network %s: %s overlaps %s of %s", name, networks[name].network, networks[other].network, other)
+			}
+		}
+	}
+
+	given := map[[2]string]bool{}
+	for _, policy := range spec.InterNetworkPolicies {
+		pair := [2]string{policy.From, policy.To}
+		for _, name := range pair {
+			if _, exist := networks[name]; !exist {
+				return fmt.Errorf("inter-network policy %s to %s: no internal network %s", policy.From, policy.To, name)
+			}
+		}
+		switch {
+		case policy.From == policy.To:
+			return fmt.Errorf("inter-network policy %s to %s: the networks are the same", policy.From, policy.To)
+		case networks[policy.From].vrfTable != networks[policy.To].vrfTable:
+			return fmt.Errorf("inter-network policy %s to %s: the networks are in different VRFs", policy.From, policy.To)
+		case given[pair]:
+			return fmt.Errorf("inter-network policy %s to %s: given more than once", policy.From, policy.To)
+		}
+		switch policy.Action {
+		case samplev1alpha1.InterNetworkAllow, samplev1alpha1.InterNetworkDeny, samplev1alpha1.InterNetworkNAT:
+		default:
+			return fmt.Errorf("inter-network policy %s to %s: unknown action %q", policy.From, policy.To, policy.Action)
+		}
+		given[pair] = true
+	}
+	return nil
+}
+
+// newInterNetworkRules compiles the inter-network policies of the router into
+// a FireWallRule accepting the traffic between the networks of every policy
+// allowing it, both ways for the replies, or nil without any. The daemon
+// drops the new connections between every other pair of networks of a VRF,
+// by the interfaces they come in on and go out of, and translates those of
+// NAT policies, so FireWallRules of tenants can't get around the policies.
+func newInterNetworkRules(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (*nfvv1.FireWallRule, error) {
+	networks, _, err := internalNetworks(virtualRouter.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var accepted []nfvv1.Rules
+	seen := map[[2]string]bool{}
+	for _, policy := range virtualRouter.Spec.InterNetworkPolicies {
+		from, fromExist := networks[policy.From]
+		to, toExist := networks[policy.To]
+		if policy.Action == samplev1alpha1.InterNetworkDeny || !fromExist || !toExist {
+			continue
+		}
+		src, dst := from.network.String(), to.network.String()
+		for _, pair := range [][2]string{{src, dst}, {dst, src}} {
+			if seen[pair] {
+				continue
+			}
+			seen[pair] = true
+			accepted = append(accepted, nfvv1.Rules{
+				Match:  nfvv1.Match{SrcIP: pair[0], DstIP: pair[1], Protocol: "all"},
+				Action: nfvv1.Action{Policy: "ACCEPT"},
+			})
+		}
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+
+	return &nfvv1.FireWallRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nfvv1.SchemeGroupVersion.String(),
+			Kind:       "FireWallRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, INTER_NETWORK_RULE_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: nfvv1.FireWallRuleSpec{
+			Rules: accepted,
+		},
+	}, nil
+}
+
+// ensureInterNetworkRules creates, updates or deletes the inter-network
+// FireWallRule of the router as its spec says, and reverts any change made to
+// it. The NATRule of the same name the NAT policies were once compiled into
+// is deleted, the daemon translating their traffic instead.
+func (c *Controller) ensureInterNetworkRules(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) error {
+	var firewallRule *unstructured.Unstructured
+	if len(virtualRouter.Spec.InternalNetworks) > 0 {
+		desired, err := newInterNetworkRules(newNS, virtualRouter)
+		if err != nil {
+			return err
+		}
+		if desired != nil {
+			if firewallRule, err = toUnstructured(desired); err != nil {
+				return err
+			}
+		}
+	}
+	name := routerResourceName(virtualRouter, INTER_NETWORK_RULE_NAME)
+	if err := c.ensureManagedRule(firewallRuleResource, newNS, virtualRouter, name, firewallRule, "inter-network firewall", gate); err != nil {
+		return err
+	}
+	return c.ensureManagedNATRule(newNS, virtualRouter, name, nil, "inter-network NAT", gate)
+}
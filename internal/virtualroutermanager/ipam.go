@@ -0,0 +1,139 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// VIRTUALROUTER_IPAM_FINALIZER keeps a VirtualRouter until its external IP
+// is released in the IPAM.
+const VIRTUALROUTER_IPAM_FINALIZER string = "virtualrouter/ipam-finalizer"
+
+// ensureIPAMAllocation allocates the external IP of the router from
+// spec.externalIPPool, and releases it once the pool is dropped, an
+// external IP is given, or the VirtualRouter is deleted. The addresses of
+// spec.snatPool are handled along with it. Every allocation and release is
+// written to the status as soon as it is made. It returns the VirtualRouter
+// to go on with, carrying the allocations in its status.
+func (c *Controller) ensureIPAMAllocation(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	if c.options.IPAM == nil {
+		return virtualRouter, nil
+	}
+
+	allocation := virtualRouter.Status.IPAMAllocation
+	pool := virtualRouter.Spec.ExternalIPPool
+	wanted := pool != "" && virtualRouter.Spec.ExternalIP == "" && virtualRouter.DeletionTimestamp.IsZero()
+	if c.dryRunPlan != nil {
+		// the IPAM has no dry run
+		if allocation != nil && (!wanted || allocation.Pool != pool) {
+			c.dryRunPlan.add(fmt.Sprintf("release external IP %s", allocation.Address))
+		}
+		if wanted && (allocation == nil || allocation.Pool != pool) {
+			c.dryRunPlan.add(fmt.Sprintf("allocate external IP from %s", pool))
+		}
+		return c.allocateSNATPool(virtualRouter)
+	}
+	if allocation != nil && (!wanted || allocation.Pool != pool) {
+		klog.Infof("Releasing external IP %s of VirtualRouter %s/%s", allocation.Address, virtualRouter.Namespace, virtualRouter.Name)
+		if err := c.options.IPAM.Release(ipam.Allocation{CIDR: allocation.Address, Reference: allocation.Reference}); err != nil {
+			return nil, fmt.Errorf("releasing external IP %s: %v", allocation.Address, err)
+		}
+		allocation = nil
+		var err error
+		if virtualRouter, err = c.persistAllocations(virtualRouter, nil, virtualRouter.Status.SNATPoolAllocations); err != nil {
+			return nil, err
+		}
+	}
+
+	// the finalizer is set before anything is allocated, and dropped once
+	// nothing allocated is left, so that the VirtualRouter is never gone
+	// while it holds addresses of the IPAM
+	wantsSNATPool := virtualRouter.Spec.SNATPool != nil && virtualRouter.DeletionTimestamp.IsZero()
+	if (wanted || wantsSNATPool) && !hasFinalizer(virtualRouter, VIRTUALROUTER_IPAM_FINALIZER) {
+		virtualRouterCopy := virtualRouter.DeepCopy()
+		virtualRouterCopy.Finalizers = append(virtualRouterCopy.Finalizers, VIRTUALROUTER_IPAM_FINALIZER)
+		updated, err := c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		virtualRouter = updated
+	}
+	if wanted && allocation == nil {
+		allocated, err := c.options.IPAM.AllocateAddress(pool, ipamDescription(virtualRouter))
+		if err != nil {
+			return nil, fmt.Errorf("allocating external IP from %s: %v", pool, err)
+		}
+		klog.Infof("Allocated external IP %s to VirtualRouter %s/%s", allocated.CIDR, virtualRouter.Namespace, virtualRouter.Name)
+		allocation = &samplev1alpha1.IPAMAllocation{Pool: pool, Address: allocated.CIDR, Reference: allocated.Reference}
+		// should the status not be written, the IPAM returns the address
+		// to the same description again
+		if virtualRouter, err = c.persistAllocations(virtualRouter, allocation, virtualRouter.Status.SNATPoolAllocations); err != nil {
+			return nil, err
+		}
+	}
+	virtualRouter, err := c.allocateSNATPool(virtualRouter)
+	if err != nil {
+		return nil, err
+	}
+
+	if allocation == nil && len(virtualRouter.Status.SNATPoolAllocations) == 0 && hasFinalizer(virtualRouter, VIRTUALROUTER_IPAM_FINALIZER) {
+		virtualRouterCopy := virtualRouter.DeepCopy()
+		virtualRouterCopy.Finalizers = removeFinalizer(virtualRouterCopy.Finalizers, VIRTUALROUTER_IPAM_FINALIZER)
+		updated, err := c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		virtualRouter = updated
+	}
+	return virtualRouter, nil
+}
+
+// persistAllocations writes the allocations to the status of the
+// VirtualRouter at once, rather than along with the rest of the status at the
+// end of the sync, so that no later failure of the sync loses track of what
+// the IPAM holds for the router. It returns the VirtualRouter written.
+func (c *Controller) persistAllocations(virtualRouter *samplev1alpha1.VirtualRouter, allocation *samplev1alpha1.IPAMAllocation, snatPoolAllocations []samplev1alpha1.IPAMAllocation) (*samplev1alpha1.VirtualRouter, error) {
+	status := virtualRouter.Status.DeepCopy()
+	status.IPAMAllocation = allocation
+	status.SNATPoolAllocations = snatPoolAllocations
+	patch, err := statusPatch(virtualRouter.Status, *status)
+	if err != nil || patch == nil {
+		return virtualRouter, err
+	}
+	updated, err := c.statusclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Patch(c.ctx, virtualRouter.Name, types.JSONPatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return nil, fmt.Errorf("recording the IPAM allocations: %v", err)
+	}
+	return updated, nil
+}
+
+// ipamDescription identifies the VirtualRouter owning an allocation in the
+// IPAM. The UID tells apart a VirtualRouter recreated with the same name.
+func ipamDescription(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return fmt.Sprintf("virtualrouter %s/%s (%s)", virtualRouter.Namespace, virtualRouter.Name, virtualRouter.UID)
+}
+
+func hasFinalizer(object metav1.Object, finalizer string) bool {
+	for _, f := range object.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	var kept []string
+	for _, f := range finalizers {
+		if f != finalizer {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,241 @@
+package virtualroutermanager
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/macaddress"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// macAllocationAttempts is how many addresses are derived for an interface
+// before giving up on finding one no other router has
+const macAllocationAttempts = 16
+
+// macAllocator holds the MAC addresses given to the router pods of every
+// VirtualRouter, reserved as they are allocated. Workers syncing routers side
+// by side, whose statuses may not be written yet, can't give out the same
+// address.
+type macAllocator struct {
+	mu sync.Mutex
+	// owners are the keys of the VirtualRouters by address
+	owners map[string]string
+	// routers are the addresses of the VirtualRouters by key
+	routers map[string][]string
+}
+
+func newMACAllocator() *macAllocator {
+	return &macAllocator{owners: map[string]string{}, routers: map[string][]string{}}
+}
+
+// allocate reserves for the VirtualRouter of the key the addresses allocate
+// returns, given whether an address is taken by another router. The
+// addresses in the status of routers not allocated to since the controller
+// started are taken as theirs.
+func (a *macAllocator) allocate(key string, virtualRouters []*samplev1alpha1.VirtualRouter, allocate func(taken func(mac string) bool) ([]samplev1alpha1.RouterMACAddress, error)) ([]samplev1alpha1.RouterMACAddress, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, virtualRouter := range virtualRouters {
+		otherKey := virtualRouter.Namespace + "/" + virtualRouter.Name
+		if _, known := a.routers[otherKey]; known || otherKey == key {
+			continue
+		}
+		var reserved []string
+		for _, addresses := range virtualRouter.Status.MACAddresses {
+			reserved = append(reserved, addresses.Internal, addresses.External)
+		}
+		a.set(otherKey, reserved)
+	}
+	macAddresses, err := allocate(func(mac string) bool {
+		owner, ok := a.owners[mac]
+		return ok && owner != key
+	})
+	if err != nil {
+		return nil, err
+	}
+	var reserved []string
+	for _, addresses := range macAddresses {
+		reserved = append(reserved, addresses.Internal, addresses.External)
+	}
+	a.set(key, reserved)
+	return macAddresses, nil
+}
+
+// release gives back the addresses of the VirtualRouter of the key.
+func (a *macAllocator) release(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.set(key, nil)
+}
+
+// forget gives back the addresses of the deleted VirtualRouter of the key.
+func (a *macAllocator) forget(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.set(key, nil)
+	delete(a.routers, key)
+}
+
+// set replaces the addresses of the VirtualRouter of the key, leaving those
+// another router has to it.
+func (a *macAllocator) set(key string, macs []string) {
+	for _, mac := range a.routers[key] {
+		if a.owners[mac] == key {
+			delete(a.owners, mac)
+		}
+	}
+	var owned []string
+	for _, mac := range macs {
+		if owner, ok := a.owners[mac]; mac == "" || ok && owner != key {
+			continue
+		}
+		a.owners[mac] = key
+		owned = append(owned, mac)
+	}
+	a.routers[key] = owned
+}
+
+// validateMACAddresses checks every router pod can be given addresses of its
+// own. Pods of a Deployment have no identity to keep addresses by, so stable
+// MACs take a single one, or the ordinals of a StatefulSet.
+func validateMACAddresses(spec samplev1alpha1.VirtualRouterSpec) error {
+	if spec.MACAddresses == nil || spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind {
+		return nil
+	}
+	if spec.Autoscaling != nil {
+		return fmt.Errorf("macAddresses: autoscaled router pods have no identity to keep MAC addresses by")
+	}
+	if spec.Replicas != nil && *spec.Replicas > 1 {
+		return fmt.Errorf("macAddresses: router pods of a Deployment would share the MAC addresses, got %d replicas without workloadKind StatefulSet", *spec.Replicas)
+	}
+	return nil
+}
+
+// macPrefix returns the prefix of the MAC pool of the router, none for
+// addresses derived from its UID alone.
+func (c *Controller) macPrefix(virtualRouter *samplev1alpha1.VirtualRouter) ([]byte, error) {
+	if virtualRouter.Spec.MACAddresses == nil || virtualRouter.Spec.MACAddresses.Pool == "" {
+		return nil, nil
+	}
+	name := virtualRouter.Spec.MACAddresses.Pool
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	for _, pool := range c.options.MACPools {
+		if pool.Name == name {
+			return macaddress.ParsePrefix(pool.Prefix)
+		}
+	}
+	return nil, fmt.Errorf("macAddresses: no MAC pool %s is configured", name)
+}
+
+// deriveMAC returns the address of the interface of the router pod of the
+// ordinal, the prefix followed by the hash of the UID of the router. Without
+// a prefix the address is a locally administered one. Another attempt gives
+// another address.
+func deriveMAC(prefix []byte, virtualRouter *samplev1alpha1.VirtualRouter, ordinal int32, interfaceName string, attempt int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s/%d", virtualRouter.UID, ordinal, interfaceName, attempt)))
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, prefix)
+	copy(mac[len(prefix):], sum[:])
+	if len(prefix) == 0 {
+		mac[0] = mac[0]&^0x01 | 0x02
+	}
+	return mac.String()
+}
+
+// ensureMACAddresses gives the router pods of a router with
+// spec.macAddresses the MAC addresses of their interfaces, those they were
+// given before being kept. An address another router has is skipped. It
+// returns the VirtualRouter to go on with, carrying the addresses in its
+// status for the daemons to program.
+func (c *Controller) ensureMACAddresses(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	key := virtualRouter.Namespace + "/" + virtualRouter.Name
+	if virtualRouter.Spec.MACAddresses == nil {
+		c.macAddresses.release(key)
+		if len(virtualRouter.Status.MACAddresses) == 0 {
+			return virtualRouter, nil
+		}
+		virtualRouterCopy := virtualRouter.DeepCopy()
+		virtualRouterCopy.Status.MACAddresses = nil
+		return virtualRouterCopy, nil
+	}
+	prefix, err := c.macPrefix(virtualRouter)
+	if err != nil {
+		return nil, err
+	}
+	pool := virtualRouter.Spec.MACAddresses.Pool
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := int32(1)
+	if usesStatefulSet(virtualRouter) && virtualRouter.Spec.Replicas != nil {
+		replicas = *virtualRouter.Spec.Replicas
+	}
+	macAddresses, err := c.macAddresses.allocate(key, virtualRouters, func(taken func(mac string) bool) ([]samplev1alpha1.RouterMACAddress, error) {
+		used := map[string]bool{}
+		next := func(ordinal int32, interfaceName string) (string, error) {
+			for attempt := 0; attempt < macAllocationAttempts; attempt++ {
+				if mac := deriveMAC(prefix, virtualRouter, ordinal, interfaceName, attempt); !used[mac] && !taken(mac) {
+					used[mac] = true
+					return mac, nil
+				}
+			}
+			return "", fmt.Errorf("no free MAC address for the %s interface of router %d", interfaceName, ordinal)
+		}
+		var macAddresses []samplev1alpha1.RouterMACAddress
+		for ordinal := int32(0); ordinal < replicas; ordinal++ {
+			kept := false
+			for _, addresses := range virtualRouter.Status.MACAddresses {
+				if addresses.Ordinal == ordinal && addresses.Pool == pool {
+					macAddresses = append(macAddresses, addresses)
+					used[addresses.Internal], used[addresses.External] = true, true
+					kept = true
+					break
+				}
+			}
+			if kept {
+				continue
+			}
+			internal, err := next(ordinal, "internal")
+			if err != nil {
+				return nil, err
+			}
+			external, err := next(ordinal, "external")
+			if err != nil {
+				return nil, err
+			}
+			klog.Infof("Allocated MAC addresses %s and %s to router %d of VirtualRouter %s/%s", internal, external, ordinal, virtualRouter.Namespace, virtualRouter.Name)
+			macAddresses = append(macAddresses, samplev1alpha1.RouterMACAddress{Ordinal: ordinal, Pool: pool, Internal: internal, External: external})
+		}
+		return macAddresses, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	virtualRouterCopy.Status.MACAddresses = macAddresses
+	return virtualRouterCopy, nil
+}
+
+// PodMACAddresses returns the MAC addresses status.macAddresses gives the
+// interfaces of the router pod, and false if it is given none.
+func PodMACAddresses(virtualRouter *samplev1alpha1.VirtualRouter, pod *corev1.Pod) (samplev1alpha1.RouterMACAddress, bool) {
+	if virtualRouter.Spec.MACAddresses == nil {
+		return samplev1alpha1.RouterMACAddress{}, false
+	}
+	ordinal, _ := podOrdinal(pod)
+	for _, addresses := range virtualRouter.Status.MACAddresses {
+		if addresses.Ordinal == int32(ordinal) {
+			return addresses, true
+		}
+	}
+	return samplev1alpha1.RouterMACAddress{}, false
+}
@@ -0,0 +1,368 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// MAINTENANCE_WINDOW_MAX_DURATION is the longest a maintenance window stays
+// open.
+const MAINTENANCE_WINDOW_MAX_DURATION time.Duration = 7 * 24 * time.Hour
+
+// maintenanceWindowSearch is how far ahead the opening of a maintenance window
+// is looked for, long enough for a schedule of February 29.
+const maintenanceWindowSearch time.Duration = 5 * 366 * 24 * time.Hour
+
+const (
+	// AwaitingMaintenanceWindow is used as part of the Event 'reason' and the
+	// PendingChanges condition reason while changes disrupting the router
+	// are deferred until its maintenance window
+	AwaitingMaintenanceWindow = "AwaitingMaintenanceWindow"
+	// PendingChangesApplied is used as part of the Event 'reason' once the
+	// deferred changes are made
+	PendingChangesApplied = "PendingChangesApplied"
+	// MessagePendingChangesApplied is the message used for Events once the
+	// deferred changes are made
+	MessagePendingChangesApplied = "The deferred changes are made"
+)
+
+// The changes disrupting a router, as reported by the PendingChanges
+// condition
+const (
+	changeRollout = "rolling out router pods"
+	changeRuleset = "rewriting the router configuration"
+)
+
+// cronField is the set of values a field of a cron expression matches, by bit.
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+// cronSchedule is a cron expression of five fields. As in cron, a day matches
+// either of the day of month and the day of week when both are restricted.
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	dayOfMonthAny, dayOfWeekAny                bool
+}
+
+// parseCronSchedule parses a cron expression of five fields, each a list of
+// *, values or ranges, with an optional /step. The day of week is 0 to 7,
+// Sunday being both 0 and 7.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: want 5 fields, minute, hour, day of month, month and day of week, got %d", expr, len(fields))
+	}
+	schedule := &cronSchedule{
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}
+	for i, field := range []struct {
+		into     *cronField
+		name     string
+		min, max int
+	}{
+		{&schedule.minute, "minute", 0, 59},
+		{&schedule.hour, "hour", 0, 23},
+		{&schedule.dayOfMonth, "day of month", 1, 31},
+		{&schedule.month, "month", 1, 12},
+		{&schedule.dayOfWeek, "day of week", 0, 7},
+	} {
+		values, err := parseCronField(fields[i], field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s: %v", expr, field.name, err)
+		}
+		*field.into = values
+	}
+	if schedule.dayOfWeek.has(7) {
+		schedule.dayOfWeek |= 1
+	}
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int) (cronField, error) {
+	var values cronField
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], min, max); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(bounds[1], min, max); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("range %q ends before it starts", part)
+			}
+		default:
+			var err error
+			if low, err = cronValue(part, min, max); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				// a single value, unless stepped from it as in 5/15
+				high = low
+			}
+		}
+		for value := low; value <= high; value += step {
+			values |= 1 << uint(value)
+		}
+	}
+	return values, nil
+}
+
+func cronValue(s string, min, max int) (int, error) {
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("%d out of range %d-%d", value, min, max)
+	}
+	return value, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dayOfMonth, dayOfWeek := s.dayOfMonth.has(t.Day()), s.dayOfWeek.has(int(t.Weekday()))
+	switch {
+	case s.dayOfMonthAny:
+		return dayOfWeek
+	case s.dayOfWeekAny:
+		return dayOfMonth
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first minute the schedule matches at or after t, false if
+// it matches none within maintenanceWindowSearch.
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	if truncated := t.Truncate(time.Minute); truncated.Before(t) {
+		t = truncated.Add(time.Minute)
+	}
+	end := t.Add(maintenanceWindowSearch)
+	for t.Before(end) {
+		switch {
+		case !s.month.has(int(t.Month())) || !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// maintenanceWindow is a parsed spec.maintenanceWindow.
+type maintenanceWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+	location *time.Location
+}
+
+func parseMaintenanceWindow(window *samplev1alpha1.MaintenanceWindow) (*maintenanceWindow, error) {
+	schedule, err := parseCronSchedule(window.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("maintenanceWindow.schedule: %v", err)
+	}
+	duration := window.Duration.Duration
+	if duration < time.Minute || duration > MAINTENANCE_WINDOW_MAX_DURATION {
+		return nil, fmt.Errorf("maintenanceWindow.duration: %s is not between 1m and %s", duration, MAINTENANCE_WINDOW_MAX_DURATION)
+	}
+	location, err := time.LoadLocation(window.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("maintenanceWindow.timeZone: %v", err)
+	}
+	return &maintenanceWindow{schedule: schedule, duration: duration, location: location}, nil
+}
+
+func validateMaintenanceWindow(window *samplev1alpha1.MaintenanceWindow) error {
+	if window == nil {
+		return nil
+	}
+	parsed, err := parseMaintenanceWindow(window)
+	if err != nil {
+		return err
+	}
+	if _, ok := parsed.schedule.next(time.Now().In(parsed.location)); !ok {
+		return fmt.Errorf("maintenanceWindow.schedule: %q never matches", window.Schedule)
+	}
+	return nil
+}
+
+// open reports whether the window is open at the time, and otherwise when it
+// opens next, the zero time if never.
+func (w *maintenanceWindow) open(now time.Time) (bool, time.Time) {
+	// the last window opening within the duration before now, if any
+	start, ok := w.schedule.next(now.In(w.location).Add(-w.duration).Add(time.Nanosecond))
+	if !ok {
+		return false, time.Time{}
+	}
+	if !start.After(now) {
+		return true, time.Time{}
+	}
+	return false, start
+}
+
+// disruptionGate holds back the changes disrupting a router while its
+// maintenance window is closed, and every change to its data plane while a
+// NetworkFreeze is present, and keeps those it held back. A nil gate lets
+// every change through.
+type disruptionGate struct {
+	closed bool
+	// opens is when the window opens next, the zero time if never
+	opens time.Time
+	// freeze is the NetworkFreeze present, if any
+	freeze   *samplev1alpha1.NetworkFreeze
+	deferred []string
+}
+
+// disruptionGate returns the gate of the router at the current time. Deleted
+// routers are never held back.
+func (c *Controller) disruptionGate(virtualRouter *samplev1alpha1.VirtualRouter) *disruptionGate {
+	if !virtualRouter.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	gate := &disruptionGate{freeze: c.activeFreeze()}
+	if virtualRouter.Spec.MaintenanceWindow != nil {
+		// an invalid window is reported by the validation of the spec
+		if window, err := parseMaintenanceWindow(virtualRouter.Spec.MaintenanceWindow); err == nil {
+			open, opens := window.open(c.clock.Now())
+			gate.closed, gate.opens = !open, opens
+		}
+	}
+	if !gate.closed && gate.freeze == nil {
+		return nil
+	}
+	return gate
+}
+
+// defers reports whether the change disrupting the router is held back,
+// keeping it as deferred if so.
+func (g *disruptionGate) defers(change string) bool {
+	if !g.isClosed() {
+		return false
+	}
+	g.keep(change)
+	return true
+}
+
+// freezes reports whether the change to the data plane, not disrupting the
+// router, is held back by a freeze, keeping it as deferred if so.
+func (g *disruptionGate) freezes(change string) bool {
+	if !g.isFrozen() {
+		return false
+	}
+	g.keep(change)
+	return true
+}
+
+func (g *disruptionGate) keep(change string) {
+	for _, deferred := range g.deferred {
+		if deferred == change {
+			return
+		}
+	}
+	g.deferred = append(g.deferred, change)
+}
+
+// isClosed reports whether changes disrupting the router are held back.
+func (g *disruptionGate) isClosed() bool {
+	return g != nil && (g.closed || g.freeze != nil)
+}
+
+// isFrozen reports whether every change to the data plane is held back.
+func (g *disruptionGate) isFrozen() bool {
+	return g != nil && g.freeze != nil
+}
+
+// holdsWorkload reports whether the update of the router workload is held
+// back, keeping it as deferred if so. Scaling it alone doesn't disrupt the
+// router, and is only held back by a freeze.
+func (g *disruptionGate) holdsWorkload(scalesOnly bool) bool {
+	if scalesOnly {
+		return g.freezes(changeScale)
+	}
+	return g.defers(changeRollout)
+}
+
+// scalesOnly reports whether the rendered Deployment spec differs from the
+// spec of the hash in the replicas alone, which are changed without rolling
+// out router pods.
+func scalesOnly(hash string, replicas *int32, desired appsv1.DeploymentSpec) bool {
+	desired.Replicas = replicas
+	return specHash(desired) == hash
+}
+
+// statefulSetScalesOnly is scalesOnly of a StatefulSet.
+func statefulSetScalesOnly(hash string, replicas *int32, desired appsv1.StatefulSetSpec) bool {
+	desired.Replicas = replicas
+	return specHash(desired) == hash
+}
+
+// setPendingChanges reports the changes the gate deferred in the
+// PendingChanges condition, and requeues the router for when the window
+// opens. A lifted freeze requeues the routers by itself.
+func (c *Controller) setPendingChanges(key string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) {
+	if gate == nil || len(gate.deferred) == 0 {
+		if meta.FindStatusCondition(virtualRouter.Status.Conditions, samplev1alpha1.PendingChangesCondition) != nil {
+			meta.RemoveStatusCondition(&virtualRouter.Status.Conditions, samplev1alpha1.PendingChangesCondition)
+		}
+		return
+	}
+	reason, message := AwaitingMaintenanceWindow, "Deferred until the maintenance window opens"
+	if gate.freeze != nil {
+		reason, message = NetworkFrozen, fmt.Sprintf(MessageNetworkFrozen, gate.freeze.Name)
+		if gate.freeze.Spec.Reason != "" {
+			message += " (" + gate.freeze.Spec.Reason + ")"
+		}
+	} else if !gate.opens.IsZero() {
+		message += " at " + gate.opens.Format(time.RFC3339)
+		c.workqueue.AddAfter(key, gate.opens.Sub(c.clock.Now()))
+	}
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.PendingChangesCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             reason,
+		Message:            message + ": " + strings.Join(gate.deferred, ", "),
+	})
+}
+
+// recordPendingChangesEvents records the deferring of changes, and the making
+// of them.
+func (c *Controller) recordPendingChangesEvents(virtualRouter *samplev1alpha1.VirtualRouter, old, new samplev1alpha1.VirtualRouterStatus) {
+	pending, wasPending := meta.FindStatusCondition(new.Conditions, samplev1alpha1.PendingChangesCondition), meta.FindStatusCondition(old.Conditions, samplev1alpha1.PendingChangesCondition)
+	switch {
+	case pending != nil && (wasPending == nil || wasPending.Message != pending.Message):
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, pending.Reason, pending.Message)
+	case pending == nil && wasPending != nil:
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, PendingChangesApplied, MessagePendingChangesApplied)
+	}
+}
@@ -0,0 +1,270 @@
+package virtualroutermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DASHBOARD_CONFIGMAP_NAME is the ConfigMap holding the Grafana dashboard
+	// of a router, picked up by the Grafana sidecar by GRAFANA_DASHBOARD_LABEL
+	DASHBOARD_CONFIGMAP_NAME string = "virtualrouter-dashboard"
+	GRAFANA_DASHBOARD_LABEL  string = "grafana_dashboard"
+	// PROMETHEUS_RULE_NAME is the PrometheusRule alerting on a router
+	PROMETHEUS_RULE_NAME string = "virtualrouter-alerts"
+
+	// The reasons router pods are failed over for.
+	FAILOVER_NODE       string = "node"
+	FAILOVER_DATA_PLANE string = "dataPlane"
+
+	// Metrics the daemons export, the dashboards and alerts are built on
+	HARDENING_DROPPED_METRIC string = "virtualrouter_hardening_dropped_packets"
+	INTERFACE_ERRORS_METRIC  string = "virtualrouter_interface_errors"
+	FAILOVERS_METRIC         string = "virtualrouter_failovers_total"
+)
+
+var prometheusRuleResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "prometheusrules"}
+
+var routerFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: FAILOVERS_METRIC,
+	Help: "Router pods of a VirtualRouter failed over, by reason.",
+}, []string{"namespace", "virtualrouter", "reason"})
+
+// forgetFailovers stops exporting the failovers of a deleted VirtualRouter.
+func forgetFailovers(namespace, name string) {
+	for _, reason := range []string{FAILOVER_NODE, FAILOVER_DATA_PLANE} {
+		routerFailovers.DeleteLabelValues(namespace, name, reason)
+	}
+}
+
+// PrometheusOperatorInstalled reports whether the cluster serves the
+// PrometheusRule CRD of the Prometheus Operator.
+func PrometheusOperatorInstalled(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(prometheusRuleResource.GroupVersion().String())
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == prometheusRuleResource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// routerSelectors are the label matchers selecting the series of a router:
+// those of its pods, exported by the daemons, and those of the VirtualRouter.
+func routerSelectors(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (pods string, router string) {
+	pods = fmt.Sprintf(`namespace=%q,pod=~%q`, newNS, virtualRouter.Spec.DeploymentName+"-.*")
+	router = fmt.Sprintf(`namespace=%q,virtualrouter=%q`, virtualRouter.Namespace, virtualRouter.Name)
+	return pods, router
+}
+
+type dashboardPanel struct {
+	title, unit, legend, expr string
+}
+
+// newDashboardConfigMap returns the ConfigMap of the Grafana dashboard of the
+// router: its sessions, packet rate, dropped packets, interface errors and
+// failovers.
+func newDashboardConfigMap(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, labels map[string]string) (*corev1.ConfigMap, error) {
+	pods, router := routerSelectors(newNS, virtualRouter)
+	panels := []dashboardPanel{
+		{"Sessions", "short", "{{pod}}", fmt.Sprintf(`sum by (pod) (%s{%s})`, SESSIONS_METRIC, pods)},
+		{"Packets received", "pps", "{{pod}}", fmt.Sprintf(`sum by (pod) (%s{%s})`, PACKETS_PER_SECOND_METRIC, pods)},
+		{"Dropped packets", "pps", "{{reason}}", fmt.Sprintf(`sum by (reason) (rate(%s{%s}[5m]))`, HARDENING_DROPPED_METRIC, router)},
+		{"Interface errors", "pps", "{{pod}} {{interface}}", fmt.Sprintf(`sum by (pod, interface) (rate(%s{%s}[5m]))`, INTERFACE_ERRORS_METRIC, pods)},
+		{"Failovers", "short", "{{reason}}", fmt.Sprintf(`sum by (reason) (increase(%s{%s}[1h]))`, FAILOVERS_METRIC, router)},
+	}
+	var rendered []interface{}
+	for i, panel := range panels {
+		rendered = append(rendered, map[string]interface{}{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      panel.title,
+			"datasource": "${datasource}",
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]interface{}{
+				"defaults": map[string]interface{}{"unit": panel.unit},
+			},
+			"targets": []interface{}{
+				map[string]interface{}{"expr": panel.expr, "legendFormat": panel.legend, "refId": "A"},
+			},
+		})
+	}
+	dashboard, err := json.MarshalIndent(map[string]interface{}{
+		"uid":           fmt.Sprintf("vr-%s-%s", virtualRouter.Namespace, virtualRouter.Name),
+		"title":         fmt.Sprintf("VirtualRouter %s/%s", virtualRouter.Namespace, virtualRouter.Name),
+		"tags":          []string{"virtualrouter"},
+		"schemaVersion": 30,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []interface{}{map[string]interface{}{"name": "datasource", "type": "datasource", "query": "prometheus"}},
+		},
+		"panels": rendered,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	configMapLabels := map[string]string{GRAFANA_DASHBOARD_LABEL: "1"}
+	for key, value := range labels {
+		configMapLabels[key] = value
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, DASHBOARD_CONFIGMAP_NAME),
+			Namespace: newNS,
+			Labels:    configMapLabels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Data: map[string]string{fmt.Sprintf("virtualrouter-%s-%s.json", virtualRouter.Namespace, virtualRouter.Name): string(dashboard)},
+	}, nil
+}
+
+// newPrometheusRule returns the PrometheusRule alerting on failovers, dropped
+// packets and interface errors of the router, and on its sessions staying
+// above what its autoscaler can take.
+func newPrometheusRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, labels map[string]string) *unstructured.Unstructured {
+	pods, router := routerSelectors(newNS, virtualRouter)
+	annotations := func(summary string) map[string]interface{} {
+		return map[string]interface{}{"summary": fmt.Sprintf("VirtualRouter %s/%s: %s", virtualRouter.Namespace, virtualRouter.Name, summary)}
+	}
+	ruleLabels := func(severity string) map[string]interface{} {
+		return map[string]interface{}{"severity": severity, "namespace": virtualRouter.Namespace, "virtualrouter": virtualRouter.Name}
+	}
+	rules := []interface{}{
+		map[string]interface{}{
+			"alert":       "VirtualRouterFailover",
+			"expr":        fmt.Sprintf(`sum(increase(%s{%s}[15m])) > 0`, FAILOVERS_METRIC, router),
+			"labels":      ruleLabels("warning"),
+			"annotations": annotations("router pods were failed over in the last 15 minutes"),
+		},
+		map[string]interface{}{
+			"alert":       "VirtualRouterDroppingPackets",
+			"expr":        fmt.Sprintf(`sum(rate(%s{%s}[5m])) > 100`, HARDENING_DROPPED_METRIC, router),
+			"for":         "10m",
+			"labels":      ruleLabels("warning"),
+			"annotations": annotations("the firewall hardening drops over 100 packets per second"),
+		},
+		map[string]interface{}{
+			"alert":       "VirtualRouterInterfaceErrors",
+			"expr":        fmt.Sprintf(`sum by (pod, interface) (rate(%s{%s}[5m])) > 0`, INTERFACE_ERRORS_METRIC, pods),
+			"for":         "10m",
+			"labels":      ruleLabels("warning"),
+			"annotations": annotations("interface {{ $labels.interface }} of router pod {{ $labels.pod }} has errors"),
+		},
+	}
+	if autoscaling := virtualRouter.Spec.Autoscaling; autoscaling != nil && autoscaling.TargetSessions > 0 {
+		rules = append(rules, map[string]interface{}{
+			"alert":       "VirtualRouterSessionsSaturated",
+			"expr":        fmt.Sprintf(`sum(%s{%s}) > %d`, SESSIONS_METRIC, pods, autoscaling.TargetSessions*int64(autoscaling.MaxReplicas)),
+			"for":         "15m",
+			"labels":      ruleLabels("warning"),
+			"annotations": annotations("sessions are above what maxReplicas router pods take"),
+		})
+	}
+
+	rule := &unstructured.Unstructured{}
+	rule.SetAPIVersion(prometheusRuleResource.GroupVersion().String())
+	rule.SetKind("PrometheusRule")
+	rule.SetName(routerResourceName(virtualRouter, PROMETHEUS_RULE_NAME))
+	rule.SetNamespace(newNS)
+	rule.SetLabels(labels)
+	rule.SetOwnerReferences([]metav1.OwnerReference{
+		*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+	})
+	rule.Object["spec"] = map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name":  fmt.Sprintf("virtualrouter.%s.%s", virtualRouter.Namespace, virtualRouter.Name),
+				"rules": rules,
+			},
+		},
+	}
+	return rule
+}
+
+// ensureMonitoring creates or updates the Grafana dashboard ConfigMap and the
+// PrometheusRule of the router. Both go away with the router namespace, or
+// with the VirtualRouter they are owned by.
+func (c *Controller) ensureMonitoring(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	if !c.options.Monitoring {
+		return nil
+	}
+	desired, err := newDashboardConfigMap(newNS, virtualRouter, c.options.MonitoringLabels)
+	if err != nil {
+		return err
+	}
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(newNS)
+	configMap, err := configMaps.Get(c.ctx, desired.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		if _, err := configMaps.Create(c.ctx, desired, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !metav1.IsControlledBy(configMap, virtualRouter):
+		msg := fmt.Sprintf(MessageResourceExists, configMap.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	case !reflect.DeepEqual(configMap.Data, desired.Data) || !reflect.DeepEqual(configMap.Labels, desired.Labels):
+		klog.Infof("Updating dashboard ConfigMap %s of VirtualRouter %s/%s", configMap.Name, virtualRouter.Namespace, virtualRouter.Name)
+		configMapCopy := configMap.DeepCopy()
+		configMapCopy.Data, configMapCopy.Labels = desired.Data, desired.Labels
+		if _, err := configMaps.Update(c.ctx, configMapCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	desiredRule := newPrometheusRule(newNS, virtualRouter, c.options.MonitoringLabels)
+	rules := c.dynamicclient.Resource(prometheusRuleResource).Namespace(newNS)
+	rule, err := rules.Get(c.ctx, desiredRule.GetName(), metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		_, err = rules.Create(c.ctx, desiredRule, metav1.CreateOptions{})
+		return err
+	case err != nil:
+		return err
+	case !metav1.IsControlledBy(rule, virtualRouter):
+		msg := fmt.Sprintf(MessageResourceExists, rule.GetName())
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+	// the spec is compared as JSON would have it, the way it is read back
+	desiredSpec, err := json.Marshal(desiredRule.Object["spec"])
+	if err != nil {
+		return err
+	}
+	spec, err := json.Marshal(rule.Object["spec"])
+	if err != nil {
+		return err
+	}
+	if string(spec) == string(desiredSpec) && reflect.DeepEqual(rule.GetLabels(), desiredRule.GetLabels()) {
+		return nil
+	}
+	klog.Infof("Updating PrometheusRule %s of VirtualRouter %s/%s", rule.GetName(), virtualRouter.Namespace, virtualRouter.Name)
+	ruleCopy := rule.DeepCopy()
+	ruleCopy.SetLabels(desiredRule.GetLabels())
+	ruleCopy.Object["spec"] = desiredRule.Object["spec"]
+	_, err = rules.Update(c.ctx, ruleCopy, metav1.UpdateOptions{})
+	return err
+}
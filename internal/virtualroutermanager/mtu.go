@@ -0,0 +1,40 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// MIN_MTU is the smallest MTU an IPv4 link may have, and MIN_IPV6_MTU an
+	// IPv6 one
+	MIN_MTU      int32 = 576
+	MIN_IPV6_MTU int32 = 1280
+	MAX_MTU      int32 = 9216
+)
+
+// validateMTU takes the MTUs IPv4, and IPv6 for dual-stack routers, can use.
+// Probing needs the gateway the path is probed towards.
+func validateMTU(spec samplev1alpha1.VirtualRouterSpec) error {
+	mtu := spec.MTU
+	if mtu == nil {
+		return nil
+	}
+	min := MIN_MTU
+	if IsDualStack(spec) {
+		min = MIN_IPV6_MTU
+	}
+	for _, field := range []struct {
+		name  string
+		value int32
+	}{{"internal", mtu.Internal}, {"external", mtu.External}} {
+		if field.value != 0 && (field.value < min || field.value > MAX_MTU) {
+			return fmt.Errorf("mtu: %s is %d to %d", field.name, min, MAX_MTU)
+		}
+	}
+	if mtu.Auto && spec.GatewayIP == "" {
+		return fmt.Errorf("mtu: probing the path MTU needs a gatewayIP")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// A VirtualRouter recreated under the name of one just deleted finds the old
+// router namespace still terminating. Its retries are spaced out from
+// NAMESPACE_TERMINATING_BASE_DELAY up to NAMESPACE_TERMINATING_MAX_DELAY.
+const (
+	NAMESPACE_TERMINATING_BASE_DELAY time.Duration = 2 * time.Second
+	NAMESPACE_TERMINATING_MAX_DELAY  time.Duration = time.Minute
+)
+
+const (
+	// NamespaceTerminating is used as part of the Event 'reason' when the
+	// router namespace is waited for
+	NamespaceTerminating = "NamespaceTerminating"
+	// MessageNamespaceTerminating is the message used for Events and the
+	// NamespaceTerminating condition while the router namespace is waited for
+	MessageNamespaceTerminating = "Waiting for namespace %s, left by a deleted VirtualRouter of the same name, to finish terminating"
+)
+
+const (
+	// ROUTER_NAMESPACE_ANNOTATION pins the dedicated namespace of a
+	// VirtualRouter. It is set on the first sync, or may be given on creation
+	// to choose the namespace, and must not be changed afterwards.
+	ROUTER_NAMESPACE_ANNOTATION string = "network.tmaxanc.com/router-namespace"
+	// DEFAULT_NAMESPACE_TEMPLATE names dedicated namespaces after both the
+	// namespace and the name of the VirtualRouter, so routers of the same name
+	// in different namespaces don't collide
+	DEFAULT_NAMESPACE_TEMPLATE string = "{namespace}-{name}"
+)
+
+const (
+	// ErrNamespaceConflict is used as part of the Event 'reason' and the
+	// NamespaceConflict condition reason when the router namespace exists
+	// but isn't owned by the VirtualRouter
+	ErrNamespaceConflict = "ErrNamespaceConflict"
+	// MessageNamespaceConflict is the message used for Events and the
+	// NamespaceConflict condition when the router namespace isn't owned by
+	// the VirtualRouter
+	MessageNamespaceConflict = "Namespace %s already exists and is not owned by the VirtualRouter"
+)
+
+// ValidateNamespaceTemplate takes templates naming every VirtualRouter a
+// namespace of its own, which requires {name}.
+func ValidateNamespaceTemplate(template string) error {
+	if !strings.Contains(template, "{name}") {
+		return fmt.Errorf("namespace template %q doesn't contain {name}", template)
+	}
+	sample := strings.NewReplacer("{namespace}", "default", "{name}", "router").Replace(template)
+	if errs := validation.IsDNS1123Label(sample); len(errs) > 0 {
+		return fmt.Errorf("namespace template %q doesn't give namespace names: %s", template, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// renderNamespaceTemplate returns the dedicated namespace the template gives
+// the VirtualRouter. Names too long for a namespace are cut short and end
+// with a hash of the whole name, which keeps them apart.
+func renderNamespaceTemplate(template string, virtualRouter *samplev1alpha1.VirtualRouter) string {
+	name := strings.NewReplacer("{namespace}", virtualRouter.Namespace, "{name}", virtualRouter.Name).Replace(template)
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+	return strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)], "-") + suffix
+}
+
+func (c *Controller) namespaceTemplate() string {
+	if c.options.NamespaceTemplate == "" {
+		return DEFAULT_NAMESPACE_TEMPLATE
+	}
+	return c.options.NamespaceTemplate
+}
+
+// pinRouterNamespace records the dedicated namespace of the VirtualRouter in
+// ROUTER_NAMESPACE_ANNOTATION, where the daemons and the exporter find it
+// too, so it stays put when the template changes. Routers created before the
+// template keep the namespace named after them they already own.
+func (c *Controller) pinRouterNamespace(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	if isTenantPlacement(virtualRouter) || !virtualRouter.DeletionTimestamp.IsZero() {
+		return virtualRouter, nil
+	}
+	if _, pinned := virtualRouter.Annotations[ROUTER_NAMESPACE_ANNOTATION]; pinned {
+		return virtualRouter, nil
+	}
+	newNS := renderNamespaceTemplate(c.namespaceTemplate(), virtualRouter)
+	namespace, err := c.kubeclientset.CoreV1().Namespaces().Get(c.ctx, virtualRouter.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && metav1.IsControlledBy(namespace, virtualRouter) {
+		newNS = virtualRouter.Name
+	}
+
+	klog.Infof("Pinning namespace %s of VirtualRouter %s/%s", newNS, virtualRouter.Namespace, virtualRouter.Name)
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	if virtualRouterCopy.Annotations == nil {
+		virtualRouterCopy.Annotations = map[string]string{}
+	}
+	virtualRouterCopy.Annotations[ROUTER_NAMESPACE_ANNOTATION] = newNS
+	return c.sampleclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Update(c.ctx, virtualRouterCopy, metav1.UpdateOptions{})
+}
+
+type namespaceConflictError struct {
+	namespace string
+}
+
+func (e *namespaceConflictError) Error() string {
+	return fmt.Sprintf(MessageNamespaceConflict, e.namespace)
+}
+
+// reportNamespaceConflict holds the router back while its namespace belongs
+// to something else, as objects of others would be taken over. The periodic
+// resync of the VirtualRouter retries it.
+func (c *Controller) reportNamespaceConflict(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	message := fmt.Sprintf(MessageNamespaceConflict, newNS)
+	klog.Warningf("VirtualRouter %s/%s: %s", virtualRouter.Namespace, virtualRouter.Name, message)
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.NamespaceConflictCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             ErrNamespaceConflict,
+		Message:            message,
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, nil)
+}
+
+type namespaceTerminatingError struct {
+	namespace string
+}
+
+func (e *namespaceTerminatingError) Error() string {
+	return fmt.Sprintf("namespace %s is terminating", e.namespace)
+}
+
+// isNamespaceTerminating tells whether the router namespace was found
+// terminating, or an object couldn't be created in it because it is.
+func isNamespaceTerminating(err error) bool {
+	if _, ok := err.(*namespaceTerminatingError); ok {
+		return true
+	}
+	return errors.HasStatusCause(err, corev1.NamespaceTerminatingCause)
+}
+
+// waitForNamespace explains the delay in the NamespaceTerminating condition
+// and retries the VirtualRouter once its backoff has passed.
+func (c *Controller) waitForNamespace(key string, newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	delay := c.namespaceBackoff.When(key)
+	klog.Infof("Namespace %s of VirtualRouter %s is terminating, retrying in %s", newNS, key, delay)
+	if c.namespaceBackoff.NumRequeues(key) == 1 {
+		c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, NamespaceTerminating, MessageNamespaceTerminating, newNS)
+	}
+	c.workqueue.AddAfter(key, delay)
+
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.NamespaceTerminatingCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             NamespaceTerminating,
+		Message:            fmt.Sprintf(MessageNamespaceTerminating, newNS),
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, nil)
+}
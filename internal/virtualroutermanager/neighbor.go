@@ -0,0 +1,51 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// NeighborInterface returns the network of the router the static neighbor
+// is on.
+func NeighborInterface(neighbor samplev1alpha1.StaticNeighbor) samplev1alpha1.NeighborInterface {
+	if neighbor.Interface != "" {
+		return neighbor.Interface
+	}
+	return samplev1alpha1.NeighborExternal
+}
+
+// validateStaticNeighbors takes IPv6 neighbors only for dual-stack routers,
+// as NDP entries are useless without IPv6 addresses.
+func validateStaticNeighbors(spec samplev1alpha1.VirtualRouterSpec) error {
+	neighbors := map[string]bool{}
+	for _, neighbor := range spec.StaticNeighbors {
+		ip := net.ParseIP(neighbor.IP)
+		if ip == nil || ip.IsUnspecified() || ip.IsMulticast() {
+			return fmt.Errorf("static neighbor %q: not a unicast IP address", neighbor.IP)
+		}
+		mac, err := net.ParseMAC(neighbor.MAC)
+		if err != nil || len(mac) != 6 {
+			return fmt.Errorf("static neighbor %s: mac %q is not an Ethernet address", neighbor.IP, neighbor.MAC)
+		}
+		if mac[0]&1 != 0 {
+			return fmt.Errorf("static neighbor %s: mac %s is not a unicast address", neighbor.IP, neighbor.MAC)
+		}
+		networkInterface := NeighborInterface(neighbor)
+		switch networkInterface {
+		case samplev1alpha1.NeighborExternal, samplev1alpha1.NeighborInternal:
+		default:
+			return fmt.Errorf("static neighbor %s: interface is %s or %s", neighbor.IP, samplev1alpha1.NeighborExternal, samplev1alpha1.NeighborInternal)
+		}
+		if ip.To4() == nil && !IsDualStack(spec) {
+			return fmt.Errorf("static neighbor %s: IPv6 neighbors need a dual-stack router", neighbor.IP)
+		}
+		key := string(networkInterface) + "/" + ip.String()
+		if neighbors[key] {
+			return fmt.Errorf("static neighbor %s: given more than once on the %s network", neighbor.IP, networkInterface)
+		}
+		neighbors[key] = true
+	}
+	return nil
+}
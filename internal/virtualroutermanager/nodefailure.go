@@ -0,0 +1,134 @@
+package virtualroutermanager
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DEFAULT_NODE_FAILURE_GRACE_PERIOD is how long a node runs router pods
+	// while not ready before they are force deleted, unless configured
+	DEFAULT_NODE_FAILURE_GRACE_PERIOD = 10 * time.Second
+
+	// NodeFailover is used as part of the Event 'reason' when a router pod
+	// of a failed node is force deleted
+	NodeFailover = "NodeFailover"
+	// MessageNodeFailover is the message used for Events when a router pod
+	// of a failed node is force deleted
+	MessageNodeFailover = "Router pod %s force deleted, its node %s is not ready since %s: %s"
+)
+
+// nodeNotReady returns since when the node isn't ready and why, false while
+// it is ready or hasn't reported yet.
+func nodeNotReady(node *corev1.Node) (time.Time, string, bool) {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != corev1.NodeReady {
+			continue
+		}
+		if condition.Status == corev1.ConditionTrue {
+			return time.Time{}, "", false
+		}
+		message := condition.Message
+		if message == "" {
+			message = condition.Reason
+		}
+		return condition.LastTransitionTime.Time, message, true
+	}
+	return time.Time{}, "", false
+}
+
+// nodeFailureGracePeriod returns how long router pods are left on a node not
+// ready, false if they are left to the eviction of the node controller.
+func (c *Controller) nodeFailureGracePeriod() (time.Duration, bool) {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	switch {
+	case c.options.NodeFailureGracePeriod < 0:
+		return 0, false
+	case c.options.NodeFailureGracePeriod == 0:
+		return DEFAULT_NODE_FAILURE_GRACE_PERIOD, true
+	}
+	return c.options.NodeFailureGracePeriod, true
+}
+
+// handleNode enqueues the VirtualRouters with router pods on a node whose
+// readiness changed.
+func (c *Controller) handleNode(old, new interface{}) {
+	oldNode, newNode := old.(*corev1.Node), new.(*corev1.Node)
+	_, _, wasNotReady := nodeNotReady(oldNode)
+	_, _, notReady := nodeNotReady(newNode)
+	if wasNotReady == notReady {
+		return
+	}
+	pods, err := c.podsLister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != newNode.Name {
+			continue
+		}
+		if notReady {
+			c.handleFailedPod(pod)
+		} else {
+			c.handlePod(pod)
+		}
+	}
+}
+
+// failoverFailedNodes force deletes the router pods of nodes not ready for
+// the grace period, so their Deployment replaces them right away and a
+// standby router pod takes over as the active one, instead of waiting for the
+// node controller to evict them minutes later. The daemon finalizer is
+// removed with them, as the daemon of a failed node can't. VirtualRouters
+// with router pods on nodes within the grace period are synced again when it
+// runs out.
+func (c *Controller) failoverFailedNodes(key string, newNS string, virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod) error {
+	gracePeriod, enabled := c.nodeFailureGracePeriod()
+	if !enabled || !virtualRouter.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	now := c.clock.Now()
+	for _, pod := range pods {
+		node, err := c.nodesLister.Get(pod.Spec.NodeName)
+		if err != nil {
+			// pods of deleted nodes are collected by the pod garbage collector
+			continue
+		}
+		since, message, notReady := nodeNotReady(node)
+		if !notReady {
+			continue
+		}
+		if remaining := since.Add(gracePeriod).Sub(now); remaining > 0 {
+			c.workqueue.AddUrgentAfter(key, remaining)
+			continue
+		}
+
+		klog.Infof("Force deleting router pod %s/%s of node %s not ready since %s", newNS, pod.Name, node.Name, since)
+		if hasFinalizer(pod, VIRTUALROUTER_DAEMON_FINALIZER) {
+			podCopy := pod.DeepCopy()
+			podCopy.Finalizers = removeFinalizer(podCopy.Finalizers, VIRTUALROUTER_DAEMON_FINALIZER)
+			if _, err := c.kubeclientset.CoreV1().Pods(newNS).Update(c.ctx, podCopy, metav1.UpdateOptions{}); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+		}
+		gracePeriodSeconds := int64(0)
+		err = c.kubeclientset.CoreV1().Pods(newNS).Delete(c.ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriodSeconds})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		routerFailovers.WithLabelValues(virtualRouter.Namespace, virtualRouter.Name, FAILOVER_NODE).Inc()
+		c.recorder.Eventf(virtualRouter, corev1.EventTypeWarning, NodeFailover, MessageNodeFailover, pod.Name, node.Name, since.UTC().Format(time.RFC3339), message)
+	}
+	return nil
+}
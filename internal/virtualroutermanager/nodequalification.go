@@ -0,0 +1,48 @@
+package virtualroutermanager
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_READY_NODE_LABEL is set to true by the daemon of a node meeting
+	// the requirements of routers, such as their kernel modules, sysctls
+	// and uplinks, and to false otherwise
+	ROUTER_READY_NODE_LABEL string = "network.tmaxanc.com/router-ready"
+	// ROUTER_READY_REASON_ANNOTATION lists the requirements a node labeled
+	// not ready doesn't meet
+	ROUTER_READY_REASON_ANNOTATION string = "network.tmaxanc.com/router-ready-reason"
+)
+
+// routerAffinity returns the affinity of the router pods: spec.affinity,
+// with the NodeQualification feature gate requiring nodes labeled ready to
+// run routers as well, in every node selector term.
+func routerAffinity(virtualRouter *samplev1alpha1.VirtualRouter) *corev1.Affinity {
+	if !features.Enabled(features.NodeQualification) {
+		return &virtualRouter.Spec.Affinity
+	}
+	affinity := virtualRouter.Spec.Affinity.DeepCopy()
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      ROUTER_READY_NODE_LABEL,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"true"},
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	selector := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	// terms are ORed, so each one takes the requirement
+	for i := range selector.NodeSelectorTerms {
+		selector.NodeSelectorTerms[i].MatchExpressions = append(selector.NodeSelectorTerms[i].MatchExpressions, requirement)
+	}
+	return affinity
+}
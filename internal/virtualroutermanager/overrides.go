@@ -0,0 +1,280 @@
+package virtualroutermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// podTemplateOverrides returns spec.overrides.podTemplate of the
+// VirtualRouter, nil if it has none.
+func podTemplateOverrides(virtualRouter *samplev1alpha1.VirtualRouter) []byte {
+	overrides := virtualRouter.Spec.Overrides
+	if overrides == nil || overrides.PodTemplate == nil || len(overrides.PodTemplate.Raw) == 0 {
+		return nil
+	}
+	return overrides.PodTemplate.Raw
+}
+
+// overridePodTemplate returns the pod template rendered for the VirtualRouter
+// patched with spec.overrides.podTemplate, as kubectl patch --type strategic
+// would: containers, volumes and env are merged by name, and $patch
+// directives are honoured. The fields the controller renders from the spec,
+// or needs the router to run, can't be overridden.
+func overridePodTemplate(template *corev1.PodTemplateSpec, virtualRouter *samplev1alpha1.VirtualRouter) (*corev1.PodTemplateSpec, error) {
+	overrides := podTemplateOverrides(virtualRouter)
+	if overrides == nil {
+		return template, nil
+	}
+	original, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, overrides, corev1.PodTemplateSpec{})
+	if err != nil {
+		return nil, fmt.Errorf("spec.overrides.podTemplate: %v", err)
+	}
+	overridden := &corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(patched, overridden); err != nil {
+		return nil, fmt.Errorf("spec.overrides.podTemplate: %v", err)
+	}
+	// compared as read back, empty fields being left out of both
+	rendered := &corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(original, rendered); err != nil {
+		return nil, err
+	}
+	if err := checkPodTemplateOverrides(rendered, overridden, virtualRouter.Name); err != nil {
+		return nil, fmt.Errorf("spec.overrides.podTemplate: %v", err)
+	}
+	return overridden, nil
+}
+
+// checkPodTemplateOverrides fails if the overridden template changes what the
+// controller owns in the rendered one, or gives the pod more privileges than
+// it was rendered with. Labels, annotations, volumes and containers can only
+// be added, and the router container only given more env, mounts and
+// resources.
+func checkPodTemplateOverrides(rendered, overridden *corev1.PodTemplateSpec, routerContainer string) error {
+	for key, value := range rendered.Labels {
+		if overridden.Labels[key] != value {
+			return fmt.Errorf("label %s can't be changed", key)
+		}
+	}
+	for key, value := range rendered.Annotations {
+		if overridden.Annotations[key] != value {
+			return fmt.Errorf("annotation %s can't be changed", key)
+		}
+	}
+	if !reflect.DeepEqual(rendered.Finalizers, overridden.Finalizers) {
+		return fmt.Errorf("finalizers can't be changed")
+	}
+
+	spec, overriddenSpec := rendered.Spec, overridden.Spec
+	// the fields of the pod rendered from the spec of the VirtualRouter are
+	// changed there
+	for _, field := range []struct {
+		name      string
+		unchanged bool
+	}{
+		{"spec.affinity", reflect.DeepEqual(spec.Affinity, overriddenSpec.Affinity)},
+		{"spec.tolerations", reflect.DeepEqual(spec.Tolerations, overriddenSpec.Tolerations)},
+		{"spec.nodeSelector", reflect.DeepEqual(spec.NodeSelector, overriddenSpec.NodeSelector)},
+		{"spec.priorityClassName", spec.PriorityClassName == overriddenSpec.PriorityClassName},
+		{"spec.topologySpreadConstraints", reflect.DeepEqual(spec.TopologySpreadConstraints, overriddenSpec.TopologySpreadConstraints)},
+		{"spec.imagePullSecrets", reflect.DeepEqual(spec.ImagePullSecrets, overriddenSpec.ImagePullSecrets)},
+		{"spec.serviceAccountName", spec.ServiceAccountName == overriddenSpec.ServiceAccountName},
+		{"spec.automountServiceAccountToken", reflect.DeepEqual(spec.AutomountServiceAccountToken, overriddenSpec.AutomountServiceAccountToken)},
+		{"spec.readinessGates", reflect.DeepEqual(spec.ReadinessGates, overriddenSpec.ReadinessGates)},
+		{"spec.hostNetwork", spec.HostNetwork == overriddenSpec.HostNetwork},
+		{"spec.hostPID", spec.HostPID == overriddenSpec.HostPID},
+		{"spec.hostIPC", spec.HostIPC == overriddenSpec.HostIPC},
+		{"spec.securityContext", reflect.DeepEqual(spec.SecurityContext, overriddenSpec.SecurityContext)},
+		{"spec.initContainers", reflect.DeepEqual(spec.InitContainers, overriddenSpec.InitContainers)},
+	} {
+		if !field.unchanged {
+			return fmt.Errorf("%s can't be overridden", field.name)
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if !hasVolume(overriddenSpec.Volumes, volume) {
+			return fmt.Errorf("volume %s can't be changed", volume.Name)
+		}
+	}
+	if err := checkOverriddenPrivileges(&spec, &overriddenSpec); err != nil {
+		return err
+	}
+
+	var router, overriddenRouter *corev1.Container
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == routerContainer {
+			router = &spec.Containers[i]
+		}
+	}
+	for i := range overriddenSpec.Containers {
+		if overriddenSpec.Containers[i].Name == routerContainer {
+			overriddenRouter = &overriddenSpec.Containers[i]
+		}
+	}
+	if router == nil {
+		return nil
+	}
+	if overriddenRouter == nil {
+		return fmt.Errorf("router container %s can't be removed", routerContainer)
+	}
+	for _, field := range []struct {
+		name      string
+		unchanged bool
+	}{
+		{"image", router.Image == overriddenRouter.Image},
+		{"imagePullPolicy", router.ImagePullPolicy == overriddenRouter.ImagePullPolicy},
+		{"command", reflect.DeepEqual(router.Command, overriddenRouter.Command)},
+		{"args", reflect.DeepEqual(router.Args, overriddenRouter.Args)},
+		{"securityContext", reflect.DeepEqual(router.SecurityContext, overriddenRouter.SecurityContext)},
+		{"envFrom", reflect.DeepEqual(router.EnvFrom, overriddenRouter.EnvFrom)},
+	} {
+		if !field.unchanged {
+			return fmt.Errorf("%s of router container %s can't be overridden", field.name, routerContainer)
+		}
+	}
+	for _, env := range router.Env {
+		if !hasEnv(overriddenRouter.Env, env) {
+			return fmt.Errorf("env %s of router container %s can't be changed", env.Name, routerContainer)
+		}
+	}
+	for _, mount := range router.VolumeMounts {
+		if !hasVolumeMount(overriddenRouter.VolumeMounts, mount) {
+			return fmt.Errorf("volume mount %s of router container %s can't be changed", mount.MountPath, routerContainer)
+		}
+	}
+	for name, quantity := range router.Resources.Limits {
+		if overridden, exist := overriddenRouter.Resources.Limits[name]; !exist || !overridden.Equal(quantity) {
+			return fmt.Errorf("%s limit of router container %s can't be changed", name, routerContainer)
+		}
+	}
+	for name, quantity := range router.Resources.Requests {
+		if overridden, exist := overriddenRouter.Resources.Requests[name]; !exist || !overridden.Equal(quantity) {
+			return fmt.Errorf("%s request of router container %s can't be changed", name, routerContainer)
+		}
+	}
+	return nil
+}
+
+// checkOverriddenPrivileges fails if the overridden pod spec reaches into the
+// node more than the rendered one: hostPath volumes, host ports, privileged
+// containers and capabilities can't be added, not even to new containers.
+func checkOverriddenPrivileges(spec, overriddenSpec *corev1.PodSpec) error {
+	for _, volume := range overriddenSpec.Volumes {
+		if volume.HostPath != nil && !hasVolume(spec.Volumes, volume) {
+			return fmt.Errorf("hostPath volume %s can't be added", volume.Name)
+		}
+	}
+	for i := range overriddenSpec.Containers {
+		overridden := &overriddenSpec.Containers[i]
+		var rendered *corev1.Container
+		for j := range spec.Containers {
+			if spec.Containers[j].Name == overridden.Name {
+				rendered = &spec.Containers[j]
+			}
+		}
+		for _, port := range overridden.Ports {
+			if port.HostPort != 0 && (rendered == nil || !hasContainerPort(rendered.Ports, port)) {
+				return fmt.Errorf("hostPort %d of container %s can't be added", port.HostPort, overridden.Name)
+			}
+		}
+		securityContext := overridden.SecurityContext
+		if securityContext == nil || rendered != nil && reflect.DeepEqual(rendered.SecurityContext, securityContext) {
+			continue
+		}
+		renderedContext := &corev1.SecurityContext{}
+		if rendered != nil && rendered.SecurityContext != nil {
+			renderedContext = rendered.SecurityContext
+		}
+		if securityContext.Privileged != nil && *securityContext.Privileged && (renderedContext.Privileged == nil || !*renderedContext.Privileged) {
+			return fmt.Errorf("container %s can't be made privileged", overridden.Name)
+		}
+		if securityContext.Capabilities == nil {
+			continue
+		}
+		for _, capability := range securityContext.Capabilities.Add {
+			if renderedContext.Capabilities == nil || !hasCapability(renderedContext.Capabilities.Add, capability) {
+				return fmt.Errorf("capability %s of container %s can't be added", capability, overridden.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func hasContainerPort(ports []corev1.ContainerPort, port corev1.ContainerPort) bool {
+	for _, other := range ports {
+		if reflect.DeepEqual(other, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCapability(capabilities []corev1.Capability, capability corev1.Capability) bool {
+	for _, other := range capabilities {
+		if other == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolume(volumes []corev1.Volume, volume corev1.Volume) bool {
+	for _, other := range volumes {
+		if reflect.DeepEqual(other, volume) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnv(envs []corev1.EnvVar, env corev1.EnvVar) bool {
+	for _, other := range envs {
+		if reflect.DeepEqual(other, env) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolumeMount(mounts []corev1.VolumeMount, mount corev1.VolumeMount) bool {
+	for _, other := range mounts {
+		if reflect.DeepEqual(other, mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// routerPodTemplate returns the pod template of the router pods of the
+// VirtualRouter as they run, its sidecars completed with the sidecar profiles
+// and spec.overrides.podTemplate applied.
+func routerPodTemplate(virtualRouter *samplev1alpha1.VirtualRouter, sidecarProfiles []samplev1alpha1.RouterSidecar) (*corev1.PodTemplateSpec, error) {
+	sidecars, err := sidecarContainers(virtualRouter, sidecarProfiles)
+	if err != nil {
+		return nil, err
+	}
+	template := renderDeployment(RouterNamespace(virtualRouter), virtualRouter, sidecars).Spec.Template
+	return overridePodTemplate(&template, virtualRouter)
+}
+
+// ValidatePodTemplateOverrides returns why spec.overrides.podTemplate of the
+// VirtualRouter can't be applied to its router pods, nil if it can. The
+// overrides are checked against the template as it is rendered, its sidecars
+// completed with the sidecar profiles. It is checked by the VirtualRouter
+// webhook, and before every sync.
+func ValidatePodTemplateOverrides(virtualRouter *samplev1alpha1.VirtualRouter, sidecarProfiles []samplev1alpha1.RouterSidecar) error {
+	if podTemplateOverrides(virtualRouter) == nil {
+		return nil
+	}
+	_, err := routerPodTemplate(virtualRouter, sidecarProfiles)
+	return err
+}
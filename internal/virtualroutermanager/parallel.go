@@ -0,0 +1,134 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DEFAULT_ENSURE_PARALLELISM is how many of the objects of a router not
+	// depending on each other are created or updated at once within a sync
+	DEFAULT_ENSURE_PARALLELISM int = 5
+
+	// ErrChildObjectsFailed is used as part of the Degraded condition reason
+	// when some of the objects of a router could not be created or updated
+	ErrChildObjectsFailed = "ChildObjectsFailed"
+)
+
+// ensureBackoff is how an object of a router failing for a reason that may
+// pass, such as a conflicting write or the API server being busy, is retried
+// within the sync before the failure is reported.
+var ensureBackoff = retry.DefaultBackoff
+
+// ensureStep creates or updates an object of a router, or a set of them.
+type ensureStep struct {
+	// object names the object in the Degraded condition
+	object string
+	// name names the span of the step
+	name   string
+	ensure func() error
+}
+
+// childObjectsError holds the objects of a router that could not be created
+// or updated, by the error of each.
+type childObjectsError struct {
+	failed []string
+	errs   []error
+}
+
+func (e *childObjectsError) Error() string {
+	messages := make([]string, len(e.failed))
+	for i, object := range e.failed {
+		messages[i] = fmt.Sprintf("%s: %v", object, e.errs[i])
+	}
+	return "failed to ensure " + strings.Join(messages, "; ")
+}
+
+// retriable reports whether the error may pass if the step is retried.
+func retriable(err error) bool {
+	return errors.IsConflict(err) || errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) || errors.IsInternalError(err)
+}
+
+// ensureParallelism returns how many steps of a sync are run at once. Dry
+// runs run them one after another, for the plan to list the operations in
+// order.
+func (c *Controller) ensureParallelism() int {
+	if c.options.EnsureParallelism < 1 || c.dryRunPlan != nil {
+		return 1
+	}
+	return c.options.EnsureParallelism
+}
+
+// ensureAll runs the steps, which must not depend on each other, up to
+// EnsureParallelism at once, retrying each as ensureBackoff. Unlike the
+// steps run one after another, which stop at the first failure, the steps
+// run at once all run, and every failure is returned.
+func (c *Controller) ensureAll(ctx context.Context, steps []ensureStep) *childObjectsError {
+	parallelism := c.ensureParallelism()
+	errs := make([]error, len(steps))
+	run := func(i int) {
+		errs[i] = tracing.Trace(ctx, steps[i].name, func() error {
+			return retry.OnError(ensureBackoff, retriable, steps[i].ensure)
+		})
+	}
+
+	if parallelism == 1 {
+		for i := range steps {
+			run(i)
+			if errs[i] != nil {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, parallelism)
+		for i := range steps {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	var failed *childObjectsError
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed == nil {
+			failed = &childObjectsError{}
+		}
+		failed.failed = append(failed.failed, steps[i].object)
+		failed.errs = append(failed.errs, err)
+	}
+	return failed
+}
+
+// reportChildObjects sets the Degraded condition of the VirtualRouter to the
+// objects of its router that could not be created or updated. The error is
+// returned for the VirtualRouter to be synced again, the objects created
+// meanwhile being kept.
+func (c *Controller) reportChildObjects(virtualRouter *samplev1alpha1.VirtualRouter, err *childObjectsError) error {
+	klog.Errorf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	if updateErr := c.reportDegraded(virtualRouter, ErrChildObjectsFailed, err.Error()); updateErr != nil {
+		klog.Error(updateErr)
+	}
+	return utilerrors.NewAggregate(err.errs)
+}
@@ -0,0 +1,89 @@
+package virtualroutermanager
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// PAUSED_ANNOTATION set to "true" on a VirtualRouter stops the controller and
+// the daemons from reconciling it, so its router pods can be maintained by
+// hand. Only the status keeps being reported.
+const PAUSED_ANNOTATION string = "network.tmaxanc.com/paused"
+
+const (
+	// Paused is used as part of the Event 'reason' and the Paused condition
+	// reason when the reconciliation of a VirtualRouter is paused
+	Paused = "Paused"
+	// Resumed is used as part of the Event 'reason' and the Paused condition
+	// reason when the reconciliation of a VirtualRouter is resumed
+	Resumed = "Resumed"
+	// MessagePaused is the message used for Events and the Paused condition
+	// while the reconciliation is paused
+	MessagePaused = "Reconciliation is paused by the " + PAUSED_ANNOTATION + " annotation"
+	// MessageResumed is the message used for Events and the Paused condition
+	// once the reconciliation is resumed
+	MessageResumed = "Reconciliation is resumed"
+)
+
+// IsPaused reports whether the reconciliation of the VirtualRouter is paused.
+func IsPaused(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.GetAnnotations()[PAUSED_ANNOTATION] == "true"
+}
+
+// syncPaused leaves the router resources of a paused VirtualRouter as they
+// are, and only reports their status.
+func (c *Controller) syncPaused(virtualRouter *samplev1alpha1.VirtualRouter) error {
+	klog.Infof("VirtualRouter %s/%s is paused, skipping reconciliation", virtualRouter.Namespace, virtualRouter.Name)
+	deployment, err := c.routerWorkload(RouterNamespace(virtualRouter), virtualRouter)
+	if errors.IsNotFound(err) {
+		deployment = nil
+	} else if err != nil {
+		return err
+	}
+	if deployment != nil && !metav1.IsControlledBy(deployment, virtualRouter) {
+		deployment = nil
+	}
+
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.PausedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             Paused,
+		Message:            MessagePaused,
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, deployment)
+}
+
+// setResumed marks a VirtualRouter paused before as resumed.
+func (c *Controller) setResumed(virtualRouter *samplev1alpha1.VirtualRouter) {
+	if !meta.IsStatusConditionTrue(virtualRouter.Status.Conditions, samplev1alpha1.PausedCondition) {
+		return
+	}
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.PausedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             Resumed,
+		Message:            MessageResumed,
+	})
+}
+
+// recordPauseEvents records the pausing and the resuming of the
+// reconciliation.
+func (c *Controller) recordPauseEvents(virtualRouter *samplev1alpha1.VirtualRouter, old, new samplev1alpha1.VirtualRouterStatus) {
+	paused, wasPaused := meta.IsStatusConditionTrue(new.Conditions, samplev1alpha1.PausedCondition), meta.IsStatusConditionTrue(old.Conditions, samplev1alpha1.PausedCondition)
+	switch {
+	case paused && !wasPaused:
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, Paused, MessagePaused)
+	case !paused && wasPaused:
+		c.recorder.Event(virtualRouter, corev1.EventTypeNormal, Resumed, MessageResumed)
+	}
+}
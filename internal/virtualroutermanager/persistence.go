@@ -0,0 +1,194 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_STATE_CLAIM_NAME is the PersistentVolumeClaim of the state of a
+	// router with spec.persistence
+	ROUTER_STATE_CLAIM_NAME string = "virtualrouter-state"
+	// ROUTER_STATE_DIR is where the state of the router is mounted in router
+	// pods, also given to them in the ROUTER_STATE_DIR environment variable.
+	// The router keeps the DHCP leases, the conntrack checkpoints and the
+	// WireGuard keys there.
+	ROUTER_STATE_DIR string = "/var/lib/virtualrouter"
+
+	DEFAULT_ROUTER_STATE_SIZE string = "1Gi"
+)
+
+const (
+	routerStateVolumeName = "state"
+	dhcpLeaseFile         = "dnsmasq.leases"
+)
+
+func hasPersistence(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.Spec.Persistence != nil
+}
+
+// stateClaimSize returns the size of the state claim of the router.
+func stateClaimSize(persistence *samplev1alpha1.RouterPersistence) resource.Quantity {
+	if persistence.Size != nil {
+		return *persistence.Size
+	}
+	return resource.MustParse(DEFAULT_ROUTER_STATE_SIZE)
+}
+
+// validatePersistence checks the state claim fits the router pods. The claim
+// is mounted by every pod of the Deployment, and ReadWriteOnce volumes are
+// only attached to one node at a time, so persistent routers run one pod,
+// unless the pods of a StatefulSet have a claim each.
+func validatePersistence(spec samplev1alpha1.VirtualRouterSpec) error {
+	persistence := spec.Persistence
+	if persistence == nil {
+		return nil
+	}
+	if persistence.Size != nil && persistence.Size.Sign() <= 0 {
+		return fmt.Errorf("persistence: size %s is not positive", persistence.Size)
+	}
+	if spec.Autoscaling != nil {
+		return fmt.Errorf("persistence: routers keeping state can't be autoscaled")
+	}
+	if spec.Replicas != nil && *spec.Replicas > 1 && spec.WorkloadKind != samplev1alpha1.StatefulSetWorkloadKind {
+		return fmt.Errorf("persistence: the state claim is mounted by a single router pod, got %d replicas without workloadKind StatefulSet", *spec.Replicas)
+	}
+	return nil
+}
+
+func newStateClaim(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) *corev1.PersistentVolumeClaim {
+	persistence := virtualRouter.Spec.Persistence
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, ROUTER_STATE_CLAIM_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: persistence.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: stateClaimSize(persistence)},
+			},
+		},
+	}
+}
+
+// ensureStateClaim creates the state claim of a router with spec.persistence.
+// A claim of the name controlled by no one, such as one restored ahead of the
+// router, is adopted, and a claim smaller than the spec is grown. The claim
+// is kept when spec.persistence is dropped, and deleted with the
+// VirtualRouter. In StatefulSet mode the claims of the pods are created by
+// the StatefulSet, and only grown here.
+func (c *Controller) ensureStateClaim(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	if !hasPersistence(virtualRouter) {
+		return nil
+	}
+	if usesStatefulSet(virtualRouter) {
+		return c.growStateClaims(newNS, virtualRouter)
+	}
+	desired := newStateClaim(newNS, virtualRouter)
+	claim, err := c.kubeclientset.CoreV1().PersistentVolumeClaims(newNS).Get(c.ctx, desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(newNS).Create(c.ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if owner := metav1.GetControllerOf(claim); owner != nil && owner.UID != virtualRouter.UID {
+		msg := fmt.Sprintf(MessageResourceExists, claim.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	claimCopy := claim.DeepCopy()
+	update := growStateClaim(claimCopy, virtualRouter)
+	if metav1.GetControllerOf(claim) == nil {
+		klog.Infof("Adopting PersistentVolumeClaim %s/%s for VirtualRouter %s/%s", newNS, claim.Name, virtualRouter.Namespace, virtualRouter.Name)
+		claimCopy.OwnerReferences = append(claimCopy.OwnerReferences, desired.OwnerReferences...)
+		update = true
+	}
+	if update {
+		_, err = c.kubeclientset.CoreV1().PersistentVolumeClaims(newNS).Update(c.ctx, claimCopy, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// growStateClaims grows the state claims the StatefulSet created for the
+// router pods to spec.persistence.
+func (c *Controller) growStateClaims(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	replicas := int32(1)
+	if virtualRouter.Spec.Replicas != nil {
+		replicas = *virtualRouter.Spec.Replicas
+	}
+	for ordinal := int32(0); ordinal < replicas; ordinal++ {
+		claim, err := c.kubeclientset.CoreV1().PersistentVolumeClaims(newNS).Get(c.ctx, stateClaimName(virtualRouter, ordinal), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		claimCopy := claim.DeepCopy()
+		if !growStateClaim(claimCopy, virtualRouter) {
+			continue
+		}
+		if _, err := c.kubeclientset.CoreV1().PersistentVolumeClaims(newNS).Update(c.ctx, claimCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// growStateClaim sets the size of the claim to spec.persistence if it is
+// smaller, and reports whether it did. Claims are never shrunk.
+func growStateClaim(claim *corev1.PersistentVolumeClaim, virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	size := stateClaimSize(virtualRouter.Spec.Persistence)
+	current := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	switch current.Cmp(size) {
+	case -1:
+		klog.Infof("Growing PersistentVolumeClaim %s/%s of VirtualRouter %s/%s to %s", claim.Namespace, claim.Name, virtualRouter.Namespace, virtualRouter.Name, size.String())
+		if claim.Spec.Resources.Requests == nil {
+			claim.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		claim.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		return true
+	case 1:
+		klog.Warningf("PersistentVolumeClaim %s/%s of VirtualRouter %s/%s can't be shrunk to %s", claim.Namespace, claim.Name, virtualRouter.Namespace, virtualRouter.Name, size.String())
+	}
+	return false
+}
+
+// addRouterStateVolume mounts the state claim into the router container. The
+// old pod releases the claim before the new one starts, as a ReadWriteOnce
+// volume may not be attached to the node the new pod is scheduled to.
+func addRouterStateVolume(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: routerStateVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: routerResourceName(virtualRouter, ROUTER_STATE_CLAIM_NAME),
+			},
+		},
+	})
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      routerStateVolumeName,
+		MountPath: ROUTER_STATE_DIR,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: "ROUTER_STATE_DIR", Value: ROUTER_STATE_DIR})
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+}
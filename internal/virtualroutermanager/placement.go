@@ -0,0 +1,67 @@
+package virtualroutermanager
+
+import (
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// VIRTUALROUTER_NAME_LABEL tells apart the pods of routers sharing a tenant
+// namespace. Routers in a dedicated namespace go without it, as the selector
+// of their Deployment can't be changed.
+const VIRTUALROUTER_NAME_LABEL string = "virtualrouterName"
+
+func isTenantPlacement(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.Spec.Placement.Strategy == samplev1alpha1.TenantPlacementStrategy
+}
+
+// RouterNamespace returns the namespace the resources of the router, and the
+// rules it applies, live in: the namespace pinned for a dedicated router, or
+// the one named after it until it is pinned.
+func RouterNamespace(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	if isTenantPlacement(virtualRouter) {
+		return virtualRouter.Namespace
+	}
+	if namespace := virtualRouter.Annotations[ROUTER_NAMESPACE_ANNOTATION]; namespace != "" {
+		return namespace
+	}
+	return virtualRouter.Name
+}
+
+// RouterPodLabels returns the labels of the router pods of the VirtualRouter,
+// selecting them in the router namespace.
+func RouterPodLabels(virtualRouter *samplev1alpha1.VirtualRouter) map[string]string {
+	labels := map[string]string{
+		"app": VIRTUALROUTER_LABEL,
+	}
+	if isTenantPlacement(virtualRouter) {
+		labels[VIRTUALROUTER_NAME_LABEL] = virtualRouter.Name
+	}
+	return labels
+}
+
+// routerResourceName returns the name of a resource of the router, prefixed
+// with the VirtualRouter name in a tenant namespace other routers may share.
+func routerResourceName(virtualRouter *samplev1alpha1.VirtualRouter, name string) string {
+	if isTenantPlacement(virtualRouter) {
+		return virtualRouter.Name + "-" + name
+	}
+	return name
+}
+
+// watches reports whether VirtualRouters of the namespace are handled.
+func (c *Controller) watches(namespace string) bool {
+	if len(c.options.WatchNamespaces) == 0 {
+		return true
+	}
+	for _, watched := range c.options.WatchNamespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// handlesClass reports whether the VirtualRouter is of the class of the
+// controller. Controllers of other classes may run in the same cluster.
+func (c *Controller) handlesClass(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.Spec.ControllerClass == c.options.ControllerClass
+}
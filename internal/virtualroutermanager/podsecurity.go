@@ -0,0 +1,142 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ErrPodSecurityViolation is used as part of the Event 'reason' and the
+	// Degraded condition reason when Pod Security Admission would reject the
+	// router pods
+	ErrPodSecurityViolation = "ErrPodSecurityViolation"
+
+	// the labels Pod Security Admission takes the levels of a namespace from
+	POD_SECURITY_ENFORCE_LABEL string = "pod-security.kubernetes.io/enforce"
+	POD_SECURITY_AUDIT_LABEL   string = "pod-security.kubernetes.io/audit"
+	POD_SECURITY_WARN_LABEL    string = "pod-security.kubernetes.io/warn"
+
+	// the Pod Security Standards, from the least to the most restrictive
+	POD_SECURITY_PRIVILEGED string = "privileged"
+	POD_SECURITY_BASELINE   string = "baseline"
+	POD_SECURITY_RESTRICTED string = "restricted"
+)
+
+// podSecurityLevels orders the Pod Security Standards.
+var podSecurityLevels = map[string]int{
+	POD_SECURITY_PRIVILEGED: 0,
+	POD_SECURITY_BASELINE:   1,
+	POD_SECURITY_RESTRICTED: 2,
+}
+
+// baselineCapabilities are the capabilities the baseline standard lets
+// containers add.
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE":      true,
+	"CHOWN":            true,
+	"DAC_OVERRIDE":     true,
+	"FOWNER":           true,
+	"FSETID":           true,
+	"KILL":             true,
+	"MKNOD":            true,
+	"NET_BIND_SERVICE": true,
+	"SETFCAP":          true,
+	"SETGID":           true,
+	"SETPCAP":          true,
+	"SETUID":           true,
+	"SYS_CHROOT":       true,
+}
+
+// podSecurityLevel returns the least restrictive standard the pod needs. Pods
+// adding capabilities beyond the baseline ones, as NET_ADMIN and NET_RAW of
+// even the Restricted profile, need the privileged standard.
+func podSecurityLevel(podSpec *corev1.PodSpec) string {
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, container := range containers {
+		securityContext := container.SecurityContext
+		if securityContext == nil {
+			continue
+		}
+		if securityContext.Privileged != nil && *securityContext.Privileged {
+			return POD_SECURITY_PRIVILEGED
+		}
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if !baselineCapabilities[capability] {
+					return POD_SECURITY_PRIVILEGED
+				}
+			}
+		}
+	}
+	return POD_SECURITY_BASELINE
+}
+
+// routerPodSecurityLevel returns the standard the router pods of the
+// VirtualRouter need.
+func routerPodSecurityLevel(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return podSecurityLevel(&newDeployment(newNS, virtualRouter).Spec.Template.Spec)
+}
+
+// setPodSecurityLabels labels the dedicated namespace with the standard its
+// router pods need, for Pod Security Admission to enforce, audit and warn
+// at. It tells if the labels changed.
+func setPodSecurityLabels(namespace *corev1.Namespace, level string) bool {
+	changed := false
+	for _, label := range []string{POD_SECURITY_ENFORCE_LABEL, POD_SECURITY_AUDIT_LABEL, POD_SECURITY_WARN_LABEL} {
+		if namespace.Labels[label] == level {
+			continue
+		}
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels[label] = level
+		changed = true
+	}
+	return changed
+}
+
+// checkPodSecurity fails if the namespace of a tenant router, which is left
+// as its owner labelled it, enforces a standard the router pods break.
+// Namespaces without the label take the default of the cluster, which the
+// controller can't see.
+func (c *Controller) checkPodSecurity(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	namespace, err := c.kubeclientset.CoreV1().Namespaces().Get(c.ctx, newNS, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// the namespace of the VirtualRouter is being deleted with it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	enforced, ok := namespace.Labels[POD_SECURITY_ENFORCE_LABEL]
+	if !ok {
+		return nil
+	}
+	needed := routerPodSecurityLevel(newNS, virtualRouter)
+	if rank, known := podSecurityLevels[enforced]; !known || rank > podSecurityLevels[needed] {
+		return &podSecurityError{namespace: newNS, enforced: enforced, needed: needed}
+	}
+	return nil
+}
+
+type podSecurityError struct {
+	namespace, enforced, needed string
+}
+
+func (e *podSecurityError) Error() string {
+	return fmt.Sprintf("namespace %s enforces the %s Pod Security Standard, router pods need %s", e.namespace, e.enforced, e.needed)
+}
+
+// reportPodSecurity holds the router back while Pod Security Admission
+// would reject its pods, rather than leaving the ReplicaSet failing to
+// create them. The periodic resync of the VirtualRouter retries it.
+func (c *Controller) reportPodSecurity(virtualRouter *samplev1alpha1.VirtualRouter, err *podSecurityError) error {
+	klog.Warningf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	return c.reportDegraded(virtualRouter, ErrPodSecurityViolation, err.Error())
+}
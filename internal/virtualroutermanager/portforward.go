@@ -0,0 +1,165 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// PORT_FORWARD_NAT_RULE_NAME is the NATRule the controller compiles the port
+// forwards of a router into
+const PORT_FORWARD_NAT_RULE_NAME string = "virtualrouter-port-forwards"
+
+var natRuleResource = nfvv1.SchemeGroupVersion.WithResource("natrules")
+
+func validatePortForwards(portForwards []samplev1alpha1.PortForward) error {
+	forwarded := map[string]bool{}
+	for _, portForward := range portForwards {
+		if portForward.ExternalPort < 1 || portForward.ExternalPort > 65535 {
+			return fmt.Errorf("port forward: invalid external port %d", portForward.ExternalPort)
+		}
+		if portForward.TargetPort < 0 || portForward.TargetPort > 65535 {
+			return fmt.Errorf("port forward %d: invalid target port %d", portForward.ExternalPort, portForward.TargetPort)
+		}
+		if net.ParseIP(portForward.TargetIP).To4() == nil {
+			return fmt.Errorf("port forward %d: invalid target %q", portForward.ExternalPort, portForward.TargetIP)
+		}
+		key := fmt.Sprintf("%s/%d", portForwardProtocol(portForward), portForward.ExternalPort)
+		if forwarded[key] {
+			return fmt.Errorf("port forward %s: given more than once", key)
+		}
+		forwarded[key] = true
+	}
+	return nil
+}
+
+func portForwardProtocol(portForward samplev1alpha1.PortForward) string {
+	if portForward.Protocol == "" {
+		return strings.ToLower(string(samplev1alpha1.PortForwardTCP))
+	}
+	return strings.ToLower(string(portForward.Protocol))
+}
+
+// newPortForwardNATRule compiles the port forwards of the router into DNAT
+// rules of its external address. The NATRule API has no port fields, so the
+// port is matched through the protocol, which router pods render verbatim
+// after -p, and the target port given along with the target address.
+func newPortForwardNATRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, externalIP string) *nfvv1.NATRule {
+	rules := make([]nfvv1.Rules, 0, len(virtualRouter.Spec.PortForwards))
+	for _, portForward := range virtualRouter.Spec.PortForwards {
+		targetPort := portForward.TargetPort
+		if targetPort == 0 {
+			targetPort = portForward.ExternalPort
+		}
+		rules = append(rules, nfvv1.Rules{
+			Match: nfvv1.Match{
+				DstIP:    externalIP,
+				Protocol: portForwardProtocol(portForward) + " --dport " + strconv.Itoa(int(portForward.ExternalPort)),
+			},
+			Action: nfvv1.Action{
+				DstIP: net.JoinHostPort(portForward.TargetIP, strconv.Itoa(int(targetPort))),
+			},
+		})
+	}
+	return &nfvv1.NATRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nfvv1.SchemeGroupVersion.String(),
+			Kind:       "NATRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, PORT_FORWARD_NAT_RULE_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: nfvv1.NATRuleSpec{
+			Rules: rules,
+		},
+	}
+}
+
+// ensurePortForwards creates, updates or deletes the port forward NATRule of
+// the router as its spec says, and reverts any change made to it. The rule
+// waits for the router to have an external address.
+func (c *Controller) ensurePortForwards(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) error {
+	var desired *nfvv1.NATRule
+	if externalIP := effectiveExternalIP(virtualRouter); len(virtualRouter.Spec.PortForwards) > 0 && externalIP != "" {
+		desired = newPortForwardNATRule(newNS, virtualRouter, externalIP)
+	}
+	return c.ensureManagedNATRule(newNS, virtualRouter, routerResourceName(virtualRouter, PORT_FORWARD_NAT_RULE_NAME), desired, "port forwards", gate)
+}
+
+// ensureManagedNATRule creates or updates a NATRule the controller compiles
+// for the router, reverting any change made to it, or deletes it if desired
+// is nil. Only a missing rule is created while the gate freezes changes.
+func (c *Controller) ensureManagedNATRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, name string, desired *nfvv1.NATRule, what string, gate *disruptionGate) error {
+	var desiredObj *unstructured.Unstructured
+	if desired != nil {
+		var err error
+		if desiredObj, err = toUnstructured(desired); err != nil {
+			return err
+		}
+	}
+	return c.ensureManagedRule(natRuleResource, newNS, virtualRouter, name, desiredObj, what, gate)
+}
+
+// ensureManagedRule creates or updates a rule of the resource the controller
+// compiles for the router, reverting any change made to it, or deletes it if
+// desired is nil. Only a missing rule is created while the gate freezes
+// changes.
+func (c *Controller) ensureManagedRule(resource schema.GroupVersionResource, newNS string, virtualRouter *samplev1alpha1.VirtualRouter, name string, desiredObj *unstructured.Unstructured, what string, gate *disruptionGate) error {
+	rules := c.dynamicclient.Resource(resource).Namespace(newNS)
+
+	// the rule is looked up in the informer cache, so a sync costs no
+	// request unless the rule has to change
+	var obj *unstructured.Unstructured
+	cached, err := c.ruleLister(resource).ByNamespace(newNS).Get(name)
+	if err == nil {
+		obj = cached.(*unstructured.Unstructured)
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	if desiredObj == nil {
+		if obj == nil || !metav1.IsControlledBy(obj, virtualRouter) || gate.freezes(changeManagedRules) {
+			return nil
+		}
+		klog.Infof("Deleting the %s of %s/%s", what, virtualRouter.Namespace, virtualRouter.Name)
+		err := rules.Delete(c.ctx, name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if obj == nil {
+		_, err = rules.Create(c.ctx, desiredObj, metav1.CreateOptions{})
+		return err
+	}
+	if !metav1.IsControlledBy(obj, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, obj.GetName())
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+	if reflect.DeepEqual(obj.Object["spec"], desiredObj.Object["spec"]) || gate.freezes(changeManagedRules) {
+		return nil
+	}
+	klog.Infof("Updating the %s of %s/%s", what, virtualRouter.Namespace, virtualRouter.Name)
+	objCopy := obj.DeepCopy()
+	objCopy.Object["spec"] = desiredObj.Object["spec"]
+	_, err = rules.Update(c.ctx, objCopy, metav1.UpdateOptions{})
+	return err
+}
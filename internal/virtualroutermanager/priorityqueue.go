@@ -0,0 +1,236 @@
+package virtualroutermanager
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// priorityQueue is a rate limited work queue handing out urgent keys, those
+// of VirtualRouters being deleted or failing over, before the routine ones,
+// however deep the queue is. As with the client-go queues, a key is queued
+// once however often it is added, and never handed to two workers at once.
+type priorityQueue struct {
+	rateLimiter workqueue.RateLimiter
+
+	cond    *sync.Cond
+	urgent  []interface{}
+	routine []interface{}
+	// dirty holds whether the keys to be handed out are urgent, including
+	// those added again while being processed
+	dirty      map[interface{}]bool
+	processing map[interface{}]bool
+	// retryUrgent holds the urgent keys handed out, retried as urgent until
+	// they are forgotten
+	retryUrgent map[interface{}]bool
+	// waiting holds the keys waiting to be added, each once with the
+	// earliest time it is due
+	waiting      map[interface{}]*waitingItem
+	shuttingDown bool
+}
+
+// waitingItem is a key waiting to be added until readyAt.
+type waitingItem struct {
+	readyAt time.Time
+	urgent  bool
+	timer   *time.Timer
+}
+
+var _ workqueue.RateLimitingInterface = &priorityQueue{}
+
+// deleting reports whether the VirtualRouter was just marked for deletion.
+func deleting(old, new *samplev1alpha1.VirtualRouter) bool {
+	return old.DeletionTimestamp.IsZero() && !new.DeletionTimestamp.IsZero()
+}
+
+// podFailed reports whether the router pod just stopped being ready, or was
+// just marked for deletion, either of which fails its router over if it was
+// the active one.
+func podFailed(old, new *corev1.Pod) bool {
+	return podutil.IsPodReady(old) && !podutil.IsPodReady(new) || old.DeletionTimestamp.IsZero() && !new.DeletionTimestamp.IsZero()
+}
+
+func newPriorityQueue(rateLimiter workqueue.RateLimiter) *priorityQueue {
+	return &priorityQueue{
+		rateLimiter: rateLimiter,
+		cond:        sync.NewCond(&sync.Mutex{}),
+		dirty:       map[interface{}]bool{},
+		processing:  map[interface{}]bool{},
+		retryUrgent: map[interface{}]bool{},
+		waiting:     map[interface{}]*waitingItem{},
+	}
+}
+
+func (q *priorityQueue) add(item interface{}, urgent bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if wasUrgent, queued := q.dirty[item]; queued {
+		if !urgent || wasUrgent {
+			return
+		}
+		q.dirty[item] = true
+		if q.processing[item] {
+			return
+		}
+		// moved ahead of the routine keys
+		for i, routine := range q.routine {
+			if routine == item {
+				q.routine = append(q.routine[:i], q.routine[i+1:]...)
+				break
+			}
+		}
+		q.urgent = append(q.urgent, item)
+		return
+	}
+	q.dirty[item] = urgent
+	if q.processing[item] {
+		// queued again once Done
+		return
+	}
+	q.push(item, urgent)
+}
+
+func (q *priorityQueue) push(item interface{}, urgent bool) {
+	if urgent {
+		q.urgent = append(q.urgent, item)
+	} else {
+		q.routine = append(q.routine, item)
+	}
+	q.cond.Signal()
+}
+
+func (q *priorityQueue) Add(item interface{}) {
+	q.add(item, false)
+}
+
+// AddUrgent queues the item ahead of the routine ones, or moves it there if
+// it is queued already.
+func (q *priorityQueue) AddUrgent(item interface{}) {
+	q.add(item, true)
+}
+
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.urgent) + len(q.routine)
+}
+
+func (q *priorityQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.urgent) == 0 && len(q.routine) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	var item interface{}
+	switch {
+	case len(q.urgent) > 0:
+		item, q.urgent = q.urgent[0], q.urgent[1:]
+		q.retryUrgent[item] = true
+	case len(q.routine) > 0:
+		item, q.routine = q.routine[0], q.routine[1:]
+	default:
+		return nil, true
+	}
+	q.processing[item] = true
+	delete(q.dirty, item)
+	return item, false
+}
+
+func (q *priorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if urgent, queued := q.dirty[item]; queued {
+		q.push(item, urgent)
+	}
+}
+
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	for item, waiting := range q.waiting {
+		waiting.timer.Stop()
+		delete(q.waiting, item)
+	}
+	q.cond.Broadcast()
+}
+
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// addAfter adds the item once the duration has passed. Like the client-go
+// delaying queue, an item waits once: adding it again before it is due only
+// brings it forward.
+func (q *priorityQueue) addAfter(item interface{}, duration time.Duration, urgent bool) {
+	if duration <= 0 {
+		q.add(item, urgent)
+		return
+	}
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	readyAt := time.Now().Add(duration)
+	if waiting, ok := q.waiting[item]; ok {
+		urgent = urgent || waiting.urgent
+		if !readyAt.Before(waiting.readyAt) {
+			waiting.urgent = urgent
+			return
+		}
+		waiting.timer.Stop()
+	}
+	waiting := &waitingItem{readyAt: readyAt, urgent: urgent}
+	waiting.timer = time.AfterFunc(duration, func() {
+		q.cond.L.Lock()
+		if q.waiting[item] != waiting {
+			// brought forward since
+			q.cond.L.Unlock()
+			return
+		}
+		delete(q.waiting, item)
+		due := waiting.urgent
+		q.cond.L.Unlock()
+		q.add(item, due)
+	})
+	q.waiting[item] = waiting
+}
+
+func (q *priorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.addAfter(item, duration, false)
+}
+
+// AddUrgentAfter queues the item as AddUrgent once the duration has passed.
+func (q *priorityQueue) AddUrgentAfter(item interface{}, duration time.Duration) {
+	q.addAfter(item, duration, true)
+}
+
+func (q *priorityQueue) AddRateLimited(item interface{}) {
+	q.cond.L.Lock()
+	urgent := q.retryUrgent[item]
+	q.cond.L.Unlock()
+	q.addAfter(item, q.rateLimiter.When(item), urgent)
+}
+
+func (q *priorityQueue) Forget(item interface{}) {
+	q.cond.L.Lock()
+	delete(q.retryUrgent, item)
+	q.cond.L.Unlock()
+	q.rateLimiter.Forget(item)
+}
+
+func (q *priorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
@@ -0,0 +1,83 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+)
+
+// routerProfile returns the VirtualRouterProfile of spec.profileRef of the
+// router, nil if it references none.
+func routerProfile(profilesLister listers.VirtualRouterProfileLister, virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouterProfile, error) {
+	if virtualRouter.Spec.ProfileRef == nil {
+		return nil, nil
+	}
+	name := virtualRouter.Spec.ProfileRef.Name
+	profile, err := profilesLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("profileRef: VirtualRouterProfile %s not found", name)
+	}
+	return profile, err
+}
+
+// ApplyProfile returns the VirtualRouter with the fields it leaves out taken
+// from the profile, as the router is rendered. The profile is never written
+// into the VirtualRouter, so that a change of the profile reaches it.
+func ApplyProfile(virtualRouter *samplev1alpha1.VirtualRouter, profile *samplev1alpha1.VirtualRouterProfile) *samplev1alpha1.VirtualRouter {
+	if profile == nil {
+		return virtualRouter
+	}
+	virtualRouterCopy := virtualRouter.DeepCopy()
+	spec := &virtualRouterCopy.Spec
+	if spec.Image == "" {
+		spec.Image = profile.Spec.Image
+	}
+	if spec.Resources == nil && profile.Spec.Resources != nil {
+		spec.Resources = profile.Spec.Resources.DeepCopy()
+	}
+	if spec.SecurityProfile == "" {
+		spec.SecurityProfile = profile.Spec.SecurityProfile
+	}
+	return virtualRouterCopy
+}
+
+// profiledRouter is ApplyProfile with the profile of the router, which is
+// rendered as it is if its profile is missing, as reported by the sync.
+func (c *Controller) profiledRouter(virtualRouter *samplev1alpha1.VirtualRouter) *samplev1alpha1.VirtualRouter {
+	profile, err := routerProfile(c.virtualRouterProfilesLister, virtualRouter)
+	if err != nil {
+		klog.Errorf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	}
+	return ApplyProfile(virtualRouter, profile)
+}
+
+// handleProfile enqueues the VirtualRouters referencing the
+// VirtualRouterProfile, to render them again with it.
+func (c *Controller) handleProfile(obj interface{}) {
+	profile, ok := obj.(*samplev1alpha1.VirtualRouterProfile)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if profile, ok = tombstone.Obj.(*samplev1alpha1.VirtualRouterProfile); !ok {
+			return
+		}
+	}
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, virtualRouter := range virtualRouters {
+		if ref := virtualRouter.Spec.ProfileRef; ref != nil && ref.Name == profile.Name {
+			c.enqueueVirtualRouter(virtualRouter)
+		}
+	}
+}
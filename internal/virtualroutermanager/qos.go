@@ -0,0 +1,63 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// QOS_MAX_CLASSES is the most classes a direction of spec.qos may have
+const QOS_MAX_CLASSES = 64
+
+func validateQoS(qos *samplev1alpha1.QoS) error {
+	if qos == nil {
+		return nil
+	}
+	if err := validateQoSLimit("ingress", qos.Ingress); err != nil {
+		return err
+	}
+	return validateQoSLimit("egress", qos.Egress)
+}
+
+func validateQoSLimit(direction string, limit *samplev1alpha1.QoSLimit) error {
+	if limit == nil {
+		return nil
+	}
+	if limit.Rate.Sign() <= 0 {
+		return fmt.Errorf("qos %s: rate must be positive", direction)
+	}
+	if limit.Burst != nil && limit.Burst.Sign() < 0 {
+		return fmt.Errorf("qos %s: burst can't be negative", direction)
+	}
+	if len(limit.Classes) > QOS_MAX_CLASSES {
+		return fmt.Errorf("qos %s: at most %d classes", direction, QOS_MAX_CLASSES)
+	}
+
+	var networks []*net.IPNet
+	var guaranteed int64
+	for _, class := range limit.Classes {
+		_, network, err := net.ParseCIDR(class.CIDR)
+		if err != nil || network.IP.To4() == nil {
+			return fmt.Errorf("qos %s: class %q is not an IPv4 network", direction, class.CIDR)
+		}
+		for _, other := range networks {
+			if other.Contains(network.IP) || network.Contains(other.IP) {
+				return fmt.Errorf("qos %s: class %s overlaps %s", direction, class.CIDR, other)
+			}
+		}
+		networks = append(networks, network)
+
+		if class.Rate.Sign() <= 0 {
+			return fmt.Errorf("qos %s: class %s: rate must be positive", direction, class.CIDR)
+		}
+		if class.Ceil != nil && (class.Ceil.Cmp(class.Rate) < 0 || class.Ceil.Cmp(limit.Rate) > 0) {
+			return fmt.Errorf("qos %s: class %s: ceil must be between its rate and %s", direction, class.CIDR, limit.Rate.String())
+		}
+		guaranteed += class.Rate.Value()
+	}
+	if guaranteed > limit.Rate.Value() {
+		return fmt.Errorf("qos %s: classes are guaranteed more than the rate %s", direction, limit.Rate.String())
+	}
+	return nil
+}
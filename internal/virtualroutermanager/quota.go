@@ -0,0 +1,313 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	informers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/informers/externalversions/networkcontroller/v1"
+	listers "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/listers/networkcontroller/v1"
+)
+
+// VIRTUALROUTER_VALIDATION_PATH is where the validating webhook enforcing the
+// VirtualRouterQuotas and the pod template overrides of VirtualRouters is
+// served.
+const VIRTUALROUTER_VALIDATION_PATH string = "/validate-virtualrouters"
+
+// quotaLimit returns the least limit the quotas of the namespace set with
+// field, nil if none of them sets it.
+func quotaLimit(quotasLister listers.VirtualRouterQuotaLister, namespace string, field func(*samplev1alpha1.VirtualRouterQuotaSpec) *int32) (*samplev1alpha1.VirtualRouterQuota, error) {
+	quotas, err := quotasLister.VirtualRouterQuotas(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var least *samplev1alpha1.VirtualRouterQuota
+	for _, quota := range quotas {
+		limit := field(&quota.Spec)
+		if limit == nil {
+			continue
+		}
+		if least == nil || *limit < *field(&least.Spec) {
+			least = quota
+		}
+	}
+	return least, nil
+}
+
+// ruleQuotaLimits are the limits of the rules of a resource in a
+// VirtualRouterQuota.
+var ruleQuotaLimits = map[schema.GroupVersionResource]func(*samplev1alpha1.VirtualRouterQuotaSpec) *int32{
+	natRuleResource:          func(spec *samplev1alpha1.VirtualRouterQuotaSpec) *int32 { return spec.MaxNATRules },
+	firewallRuleResource:     func(spec *samplev1alpha1.VirtualRouterQuotaSpec) *int32 { return spec.MaxFireWallRules },
+	loadBalancerRuleResource: func(spec *samplev1alpha1.VirtualRouterQuotaSpec) *int32 { return spec.MaxLoadBalancerRules },
+}
+
+// isVirtualRouterRule tells if the rule is compiled by a VirtualRouter, such
+// as the port forwards and the management firewall, which no quota limits.
+func isVirtualRouterRule(obj metav1.Object) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.Kind == "VirtualRouter" && owner.APIVersion == samplev1alpha1.SchemeGroupVersion.String()
+}
+
+// countRules returns the number of rules in the spec of the rule.
+func countRules(obj *unstructured.Unstructured) int {
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	return len(rules)
+}
+
+// validateRuleQuota fails if the rules of the router namespace would outnumber
+// what the VirtualRouterQuotas of the namespaces of its VirtualRouters allow.
+// Updates taking no more rules than before are admitted, so a lowered quota
+// doesn't lock the rules already over it.
+func (v *RuleValidator) validateRuleQuota(request *admissionv1.AdmissionRequest, obj *unstructured.Unstructured, resource schema.GroupVersionResource, count int) error {
+	if isVirtualRouterRule(obj) {
+		return nil
+	}
+	if request.Operation == admissionv1.Update {
+		old := &unstructured.Unstructured{}
+		if err := old.UnmarshalJSON(request.OldObject.Raw); err != nil {
+			return err
+		}
+		if count <= countRules(old) {
+			return nil
+		}
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = request.Namespace
+	}
+	virtualRouters, err := v.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var least *samplev1alpha1.VirtualRouterQuota
+	field := ruleQuotaLimits[resource]
+	for _, virtualRouter := range virtualRouters {
+		if RouterNamespace(virtualRouter) != namespace {
+			continue
+		}
+		quota, err := quotaLimit(v.quotasLister, virtualRouter.Namespace, field)
+		if err != nil {
+			return err
+		}
+		if quota != nil && (least == nil || *field(&quota.Spec) < *field(&least.Spec)) {
+			least = quota
+		}
+	}
+	if least == nil {
+		return nil
+	}
+
+	list, err := v.dynamicclient.Resource(resource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	used := count
+	for i := range list.Items {
+		item := &list.Items[i]
+		if item.GetName() == obj.GetName() || isVirtualRouterRule(item) {
+			continue
+		}
+		used += countRules(item)
+	}
+	if limit := *field(&least.Spec); used > int(limit) {
+		return fmt.Errorf("%d %s exceed the %d of VirtualRouterQuota %s/%s", used, resource.Resource, limit, least.Namespace, least.Name)
+	}
+	return nil
+}
+
+// externalIPCount returns the number of external addresses the VirtualRouter
+// asks for: the external IP, the IPv6 address and the SNAT pool.
+func externalIPCount(virtualRouter *samplev1alpha1.VirtualRouter) int {
+	count := 0
+	if virtualRouter.Spec.ExternalIP != "" || virtualRouter.Spec.ExternalIPPool != "" {
+		count++
+	}
+	if virtualRouter.Spec.ExternalIPv6CIDR != "" {
+		count++
+	}
+	if virtualRouter.Spec.SNATPool != nil {
+		count += int(virtualRouter.Spec.SNATPool.Size)
+	}
+	return count
+}
+
+// QuotaValidator is the validating admission webhook of the VirtualRouters.
+// It rejects the VirtualRouters outnumbering the maxVirtualRouters, or asking
+// for more external addresses than the maxExternalIPs, of the
+// VirtualRouterQuotas of their namespace, those whose
+// spec.overrides.podTemplate overrides what the controller owns, and those
+// referencing a VirtualRouterProfile missing or giving them no image.
+type QuotaValidator struct {
+	virtualRoutersLister listers.VirtualRouterLister
+	virtualRoutersSynced cache.InformerSynced
+	quotasLister         listers.VirtualRouterQuotaLister
+	quotasSynced         cache.InformerSynced
+	profilesLister       listers.VirtualRouterProfileLister
+	profilesSynced       cache.InformerSynced
+
+	// the overrides are checked against the sidecars the controller renders
+	optionsLock sync.RWMutex
+	options     Options
+}
+
+// NewQuotaValidator returns the quota validator looking VirtualRouters, their
+// quotas and their profiles up in the informers, which are to be started.
+func NewQuotaValidator(virtualRouterInformer informers.VirtualRouterInformer, quotaInformer informers.VirtualRouterQuotaInformer, profileInformer informers.VirtualRouterProfileInformer) *QuotaValidator {
+	return &QuotaValidator{
+		virtualRoutersLister: virtualRouterInformer.Lister(),
+		virtualRoutersSynced: virtualRouterInformer.Informer().HasSynced,
+		quotasLister:         quotaInformer.Lister(),
+		quotasSynced:         quotaInformer.Informer().HasSynced,
+		profilesLister:       profileInformer.Lister(),
+		profilesSynced:       profileInformer.Informer().HasSynced,
+	}
+}
+
+// Reload takes the SidecarProfiles of options, which the pod template
+// overrides are validated with, as the controller does.
+func (v *QuotaValidator) Reload(options Options) {
+	v.optionsLock.Lock()
+	defer v.optionsLock.Unlock()
+	v.options.SidecarProfiles = options.SidecarProfiles
+}
+
+func (v *QuotaValidator) sidecarProfiles() []samplev1alpha1.RouterSidecar {
+	v.optionsLock.RLock()
+	defer v.optionsLock.RUnlock()
+	return v.options.SidecarProfiles
+}
+
+// ServeHTTP answers an AdmissionReview of a VirtualRouter, with an error until
+// the VirtualRouters, their quotas and their profiles are synced.
+func (v *QuotaValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !v.virtualRoutersSynced() || !v.quotasSynced() || !v.profilesSynced() {
+		http.Error(w, "VirtualRouters are not synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	serveAdmissionReview(w, r, v.Validate)
+}
+
+// Validate returns why the VirtualRouter of the request is rejected, nil if
+// it is admitted. Updates leaving the spec as it is are admitted, as are
+// updates asking for no more external addresses than before and leaving the
+// overrides as they are.
+func (v *QuotaValidator) Validate(request *admissionv1.AdmissionRequest) error {
+	if request.Kind.Kind != "VirtualRouter" {
+		return fmt.Errorf("%s is not a VirtualRouter", request.Kind.Kind)
+	}
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return nil
+	}
+	virtualRouter := &samplev1alpha1.VirtualRouter{}
+	if err := json.Unmarshal(request.Object.Raw, virtualRouter); err != nil {
+		return err
+	}
+	if !virtualRouter.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if virtualRouter.Namespace == "" {
+		virtualRouter.Namespace = request.Namespace
+	}
+	if err := v.validateOverrides(request, virtualRouter); err != nil {
+		return err
+	}
+	if err := v.validateProfile(virtualRouter); err != nil {
+		return err
+	}
+	others, err := v.otherVirtualRouters(virtualRouter)
+	if err != nil {
+		return err
+	}
+
+	if request.Operation == admissionv1.Create {
+		quota, err := quotaLimit(v.quotasLister, virtualRouter.Namespace, func(spec *samplev1alpha1.VirtualRouterQuotaSpec) *int32 { return spec.MaxVirtualRouters })
+		if err != nil {
+			return err
+		}
+		if quota != nil && len(others)+1 > int(*quota.Spec.MaxVirtualRouters) {
+			return fmt.Errorf("%d VirtualRouters exceed the %d of VirtualRouterQuota %s/%s", len(others)+1, *quota.Spec.MaxVirtualRouters, quota.Namespace, quota.Name)
+		}
+	}
+
+	count := externalIPCount(virtualRouter)
+	if request.Operation == admissionv1.Update {
+		old := &samplev1alpha1.VirtualRouter{}
+		if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(old.Spec, virtualRouter.Spec) || count <= externalIPCount(old) {
+			return nil
+		}
+	}
+	quota, err := quotaLimit(v.quotasLister, virtualRouter.Namespace, func(spec *samplev1alpha1.VirtualRouterQuotaSpec) *int32 { return spec.MaxExternalIPs })
+	if err != nil || quota == nil {
+		return err
+	}
+	used := count
+	for _, other := range others {
+		used += externalIPCount(other)
+	}
+	if used > int(*quota.Spec.MaxExternalIPs) {
+		return fmt.Errorf("%d external IPs exceed the %d of VirtualRouterQuota %s/%s", used, *quota.Spec.MaxExternalIPs, quota.Namespace, quota.Name)
+	}
+	return nil
+}
+
+// validateOverrides rejects the spec.overrides.podTemplate of the
+// VirtualRouter if it overrides what the controller owns, unless it is the
+// one already admitted.
+func (v *QuotaValidator) validateOverrides(request *admissionv1.AdmissionRequest, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	if request.Operation == admissionv1.Update {
+		old := &samplev1alpha1.VirtualRouter{}
+		if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(old.Spec.Overrides, virtualRouter.Spec.Overrides) {
+			return nil
+		}
+	}
+	return ValidatePodTemplateOverrides(virtualRouter, v.sidecarProfiles())
+}
+
+// validateProfile rejects the VirtualRouter if spec.profileRef references a
+// VirtualRouterProfile missing, or giving it no image when it has none of its
+// own.
+func (v *QuotaValidator) validateProfile(virtualRouter *samplev1alpha1.VirtualRouter) error {
+	profile, err := routerProfile(v.profilesLister, virtualRouter)
+	if err != nil || profile == nil {
+		return err
+	}
+	if ApplyProfile(virtualRouter, profile).Spec.Image == "" {
+		return fmt.Errorf("image: neither the VirtualRouter nor its VirtualRouterProfile %s gives one", profile.Name)
+	}
+	return nil
+}
+
+// otherVirtualRouters lists the VirtualRouters of the namespace of the
+// VirtualRouter but itself.
+func (v *QuotaValidator) otherVirtualRouters(virtualRouter *samplev1alpha1.VirtualRouter) ([]*samplev1alpha1.VirtualRouter, error) {
+	virtualRouters, err := v.virtualRoutersLister.VirtualRouters(virtualRouter.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var others []*samplev1alpha1.VirtualRouter
+	for _, other := range virtualRouters {
+		if other.Name != virtualRouter.Name {
+			others = append(others, other)
+		}
+	}
+	return others, nil
+}
@@ -0,0 +1,78 @@
+package virtualroutermanager
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// Reload takes the ManagementCIDRs, DefaultImagePullSecrets,
+// RuleExpiryWarning, NodeFailureGracePeriod, AllowedRegistries,
+// AllowedUnsafeSysctls, SidecarProfiles and MACPools of options while
+// running, and resyncs every VirtualRouter with them. The other options are
+// only taken by NewController.
+func (c *Controller) Reload(options Options) {
+	c.optionsLock.Lock()
+	c.options.ManagementCIDRs = options.ManagementCIDRs
+	c.options.DefaultImagePullSecrets = options.DefaultImagePullSecrets
+	c.options.RuleExpiryWarning = options.RuleExpiryWarning
+	c.options.NodeFailureGracePeriod = options.NodeFailureGracePeriod
+	c.options.AllowedRegistries = options.AllowedRegistries
+	c.options.AllowedUnsafeSysctls = options.AllowedUnsafeSysctls
+	c.options.SidecarProfiles = options.SidecarProfiles
+	c.options.MACPools = options.MACPools
+	c.optionsLock.Unlock()
+
+	virtualRouters, err := c.virtualRoutersLister.List(labels.Everything())
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	for _, virtualRouter := range virtualRouters {
+		c.enqueueVirtualRouter(virtualRouter)
+	}
+}
+
+func (c *Controller) managementCIDRs() []string {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	return c.options.ManagementCIDRs
+}
+
+func (c *Controller) defaultImagePullSecrets() []string {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	return c.options.DefaultImagePullSecrets
+}
+
+func (c *Controller) sidecarProfiles() []samplev1alpha1.RouterSidecar {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	return c.options.SidecarProfiles
+}
+
+func (c *Controller) allowedRegistries() []string {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	return c.options.AllowedRegistries
+}
+
+func (c *Controller) allowedUnsafeSysctls() []string {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	return c.options.AllowedUnsafeSysctls
+}
+
+// ruleExpiryWarning returns how long ahead of the expiry of a temporary rule
+// a warning is emitted.
+func (c *Controller) ruleExpiryWarning() time.Duration {
+	c.optionsLock.RLock()
+	defer c.optionsLock.RUnlock()
+	if c.options.RuleExpiryWarning == 0 {
+		return DEFAULT_RULE_EXPIRY_WARNING
+	}
+	return c.options.RuleExpiryWarning
+}
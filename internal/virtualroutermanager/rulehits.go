@@ -0,0 +1,234 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+// FIREWALL_COUNTERS_ANNOTATION holds the packet and byte counters of the
+// firewall rules in the data plane of a router pod, exported by the daemon as
+// a JSON object keyed by FirewallRuleKey.
+const FIREWALL_COUNTERS_ANNOTATION string = "network.tmaxanc.com/firewall-counters"
+
+// RuleCounters are the packets and bytes matched by a rule.
+type RuleCounters struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// FirewallRuleKey identifies a firewall rule by what it matches and does,
+// the only things the router puts in the data plane for it. Addresses are
+// compared as networks, so 10.0.0.1 and 10.0.0.1/32 are the same.
+func FirewallRuleKey(srcIP, dstIP, protocol, policy string) string {
+	return strings.Join([]string{normalizeRuleAddress(srcIP), normalizeRuleAddress(dstIP), strings.ToLower(protocol), strings.ToUpper(policy)}, "|")
+}
+
+func normalizeRuleAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			address += "/128"
+		} else {
+			address += "/32"
+		}
+	}
+	if _, network, err := net.ParseCIDR(address); err == nil {
+		return network.String()
+	}
+	return address
+}
+
+// firewallRuleHit is the traffic matched by a rule of a FireWallRule, in the
+// order of spec.rules.
+type firewallRuleHit struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// podFirewallCounters adds up the counters the daemons exported for the
+// router pods. It returns nil if none did.
+func podFirewallCounters(pods []*corev1.Pod) map[string]RuleCounters {
+	var total map[string]RuleCounters
+	for _, pod := range pods {
+		value, ok := pod.GetAnnotations()[FIREWALL_COUNTERS_ANNOTATION]
+		if !ok {
+			continue
+		}
+		counters := map[string]RuleCounters{}
+		if err := json.Unmarshal([]byte(value), &counters); err != nil {
+			klog.Warningf("Ignoring the firewall counters of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		if total == nil {
+			total = map[string]RuleCounters{}
+		}
+		for key, c := range counters {
+			sum := total[key]
+			sum.Packets += c.Packets
+			sum.Bytes += c.Bytes
+			total[key] = sum
+		}
+	}
+	return total
+}
+
+// reportFirewallRuleHits writes the traffic matched by every FireWallRule of
+// the router namespace, summed over the router pods, into status.ruleHits and
+// the firewall rule metrics. Rules matching the same traffic share counters.
+func (c *Controller) reportFirewallRuleHits(key string, newNS string, virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod) error {
+	// routers sharing a tenant namespace share its FireWallRules, so the
+	// counters of one of them are not the hits of the rules
+	if isTenantPlacement(virtualRouter) {
+		return nil
+	}
+	counters := podFirewallCounters(pods)
+	if counters == nil {
+		return nil
+	}
+	rules := c.dynamicclient.Resource(firewallRuleResource).Namespace(newNS)
+	list, err := c.listRules(firewallRuleResource, newNS)
+	if err != nil {
+		return err
+	}
+
+	var samples []firewallRuleHitSample
+	for _, item := range list {
+		var firewallRule nfvv1.FireWallRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &firewallRule); err != nil {
+			klog.Warningf("Ignoring FireWallRule %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		hits := []firewallRuleHit{}
+		for index, rule := range firewallRule.Spec.Rules {
+			hit := counters[FirewallRuleKey(rule.Match.SrcIP, rule.Match.DstIP, rule.Match.Protocol, rule.Action.Policy)]
+			hits = append(hits, firewallRuleHit(hit))
+			samples = append(samples, firewallRuleHitSample{namespace: newNS, firewallRule: item.GetName(), rule: index, hit: firewallRuleHit(hit)})
+		}
+		if err := patchRuleHits(rules, item, hits); err != nil {
+			return err
+		}
+	}
+	firewallRuleHits.set(key, samples)
+	return nil
+}
+
+// FirewallRuleIDs returns the rules of the FireWallRules of the namespace by
+// FirewallRuleKey, each as the name of its FireWallRule and its index in
+// spec.rules, such as allow-web[0].
+func FirewallRuleIDs(dynamicclient dynamic.Interface, namespace string) (map[string][]string, error) {
+	list, err := dynamicclient.Resource(firewallRuleResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := map[string][]string{}
+	for i := range list.Items {
+		item := &list.Items[i]
+		var firewallRule nfvv1.FireWallRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &firewallRule); err != nil {
+			klog.Warningf("Ignoring FireWallRule %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		for index, rule := range firewallRule.Spec.Rules {
+			key := FirewallRuleKey(rule.Match.SrcIP, rule.Match.DstIP, rule.Match.Protocol, rule.Action.Policy)
+			ids[key] = append(ids[key], fmt.Sprintf("%s[%d]", item.GetName(), index))
+		}
+	}
+	return ids, nil
+}
+
+// patchRuleHits writes status.ruleHits of the FireWallRule if it changed.
+// The FireWallRule CRD keeps fields of its status only if it preserves
+// unknown fields there.
+func patchRuleHits(rules dynamic.ResourceInterface, firewallRule *unstructured.Unstructured, hits []firewallRuleHit) error {
+	if current, ok, _ := unstructured.NestedSlice(firewallRule.Object, "status", "ruleHits"); ok {
+		var currentHits []firewallRuleHit
+		if content, err := json.Marshal(current); err == nil && json.Unmarshal(content, &currentHits) == nil && reflect.DeepEqual(currentHits, hits) {
+			return nil
+		}
+	}
+	content, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+	patch := fmt.Sprintf(`{"status":{"ruleHits":%s}}`, content)
+	_, err = rules.Patch(context.TODO(), firewallRule.GetName(), types.MergePatchType, []byte(patch), metav1.PatchOptions{}, "status")
+	return err
+}
+
+type firewallRuleHitSample struct {
+	namespace    string
+	firewallRule string
+	rule         int
+	hit          firewallRuleHit
+}
+
+// firewallRuleHitCollector exports the last reported counters of every
+// firewall rule. The counters are read from the data plane, so they are
+// reported as they are rather than counted here.
+type firewallRuleHitCollector struct {
+	mu      sync.Mutex
+	samples map[string][]firewallRuleHitSample
+	packets *prometheus.Desc
+	bytes   *prometheus.Desc
+}
+
+var firewallRuleHits = &firewallRuleHitCollector{
+	samples: map[string][]firewallRuleHitSample{},
+	packets: prometheus.NewDesc("virtualrouter_firewall_rule_hit_packets_total",
+		"Packets matched by a rule of a FireWallRule in the data plane of its router pods.",
+		[]string{"namespace", "firewallrule", "rule"}, nil),
+	bytes: prometheus.NewDesc("virtualrouter_firewall_rule_hit_bytes_total",
+		"Bytes matched by a rule of a FireWallRule in the data plane of its router pods.",
+		[]string{"namespace", "firewallrule", "rule"}, nil),
+}
+
+// set replaces the counters of the rules of the VirtualRouter with the key.
+func (c *firewallRuleHitCollector) set(key string, samples []firewallRuleHitSample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if samples == nil {
+		delete(c.samples, key)
+		return
+	}
+	c.samples[key] = samples
+}
+
+func (c *firewallRuleHitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.packets
+	ch <- c.bytes
+}
+
+func (c *firewallRuleHitCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, samples := range c.samples {
+		for _, sample := range samples {
+			rule := fmt.Sprintf("%d", sample.rule)
+			ch <- prometheus.MustNewConstMetric(c.packets, prometheus.CounterValue, float64(sample.hit.Packets), sample.namespace, sample.firewallRule, rule)
+			ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(sample.hit.Bytes), sample.namespace, sample.firewallRule, rule)
+		}
+	}
+}
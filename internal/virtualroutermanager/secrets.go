@@ -0,0 +1,114 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// SECRET_MOUNT_DIR is where spec.secretRefs are mounted unless a mount path
+// is given.
+const SECRET_MOUNT_DIR string = "/etc/virtualrouter/secrets"
+
+const (
+	// ErrSecretNotFound is used as part of the Event 'reason' when a Secret
+	// given in spec.envFrom or spec.secretRefs doesn't exist
+	ErrSecretNotFound = "ErrSecretNotFound"
+	// MessageSecretNotFound is the message used for Events when a Secret of
+	// the router doesn't exist
+	MessageSecretNotFound = "Secret %s/%s of the router not found, router pods are not rolled out"
+)
+
+// routerSecretNames returns the Secrets given in spec.envFrom and
+// spec.secretRefs, each once.
+func routerSecretNames(virtualRouter *samplev1alpha1.VirtualRouter) []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, envFrom := range virtualRouter.Spec.EnvFrom {
+		add(envFrom.SecretName)
+	}
+	for _, secretRef := range virtualRouter.Spec.SecretRefs {
+		add(secretRef.SecretName)
+	}
+	return names
+}
+
+// ensureRouterSecrets mirrors the Secrets of spec.envFrom and spec.secretRefs
+// into the router namespace. A missing Secret fails the sync before the
+// Deployment is touched, so the running router pods are kept.
+func (c *Controller) ensureRouterSecrets(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) error {
+	for _, secretName := range routerSecretNames(virtualRouter) {
+		var err error
+		if newNS == virtualRouter.Namespace {
+			// nothing to mirror, the Secret is only checked for
+			_, err = c.kubeclientset.CoreV1().Secrets(newNS).Get(c.ctx, secretName, metav1.GetOptions{})
+		} else {
+			err = c.ensureMirroredSecret(virtualRouter.Namespace, secretName, newNS, virtualRouter)
+		}
+		if err != nil {
+			if errors.IsNotFound(err) {
+				msg := fmt.Sprintf(MessageSecretNotFound, virtualRouter.Namespace, secretName)
+				c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrSecretNotFound, msg)
+			}
+			klog.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func secretEnvFrom(virtualRouter *samplev1alpha1.VirtualRouter) []corev1.EnvFromSource {
+	var envFrom []corev1.EnvFromSource
+	for _, source := range virtualRouter.Spec.EnvFrom {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			Prefix: source.Prefix,
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: source.SecretName},
+			},
+		})
+	}
+	return envFrom
+}
+
+// secretVolumes returns a volume per spec.secretRefs. Volumes are named after
+// their index, as Secret names aren't all valid volume names.
+func secretVolumes(virtualRouter *samplev1alpha1.VirtualRouter) []corev1.Volume {
+	var volumes []corev1.Volume
+	for i, secretRef := range virtualRouter.Spec.SecretRefs {
+		volumes = append(volumes, corev1.Volume{
+			Name: fmt.Sprintf("secret-%d", i),
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: secretRef.SecretName},
+			},
+		})
+	}
+	return volumes
+}
+
+func secretVolumeMounts(virtualRouter *samplev1alpha1.VirtualRouter) []corev1.VolumeMount {
+	var mounts []corev1.VolumeMount
+	for i, secretRef := range virtualRouter.Spec.SecretRefs {
+		mountPath := secretRef.MountPath
+		if mountPath == "" {
+			mountPath = path.Join(SECRET_MOUNT_DIR, secretRef.SecretName)
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      fmt.Sprintf("secret-%d", i),
+			MountPath: mountPath,
+			ReadOnly:  true,
+		})
+	}
+	return mounts
+}
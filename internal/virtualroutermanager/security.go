@@ -0,0 +1,71 @@
+package virtualroutermanager
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// routerScratchDirs are the directories router containers of the Restricted
+// profile write to, such as the xtables lock in /run, given emptyDirs as
+// their root filesystem is read-only.
+var routerScratchDirs = []struct{ volume, dir string }{
+	{"router-run", "/run"},
+	{"router-tmp", "/tmp"},
+}
+
+// IsRestricted tells if the router containers run with the Restricted
+// security profile.
+func IsRestricted(spec samplev1alpha1.VirtualRouterSpec) bool {
+	return spec.SecurityProfile == samplev1alpha1.RestrictedSecurityProfile
+}
+
+// routerSecurityContext returns the security context of the router container
+// for the security profile of the VirtualRouter.
+func routerSecurityContext(virtualRouter *samplev1alpha1.VirtualRouter) *corev1.SecurityContext {
+	if !IsRestricted(virtualRouter.Spec) {
+		privileged := true
+		return &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{
+					corev1.Capability("NET_RAW"),
+					corev1.Capability("NET_ADMIN"),
+					corev1.Capability("SYS_ADMIN"),
+				},
+			},
+			Privileged: &privileged,
+		}
+	}
+	privileged, allowPrivilegeEscalation, readOnlyRootFilesystem := false, false, true
+	return &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{
+			Add: []corev1.Capability{
+				corev1.Capability("NET_RAW"),
+				corev1.Capability("NET_ADMIN"),
+			},
+			Drop: []corev1.Capability{"ALL"},
+		},
+		Privileged:               &privileged,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
+
+// addRouterScratchVolumes gives the router container of the Restricted
+// profile the writable directories it needs.
+func addRouterScratchVolumes(deployment *appsv1.Deployment) {
+	podSpec := &deployment.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	for _, scratch := range routerScratchDirs {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         scratch.volume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      scratch.volume,
+			MountPath: scratch.dir,
+		})
+	}
+}
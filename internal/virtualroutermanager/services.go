@@ -0,0 +1,241 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	nfvv1 "github.com/tmax-cloud/virtualrouter/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// VIRTUALROUTER_SERVICE_ANNOTATION names the VirtualRouter, in the
+	// namespace of the Service, publishing a Service of type LoadBalancer
+	VIRTUALROUTER_SERVICE_ANNOTATION string = "network.tmaxanc.com/virtualrouter"
+	// SERVICE_NAT_RULE_NAME is the NATRule the controller compiles the
+	// Services published by a router into
+	SERVICE_NAT_RULE_NAME string = "virtualrouter-services"
+
+	// ServiceNotPublished is used as part of the Event 'reason' when a
+	// Service can't be published by its router
+	ServiceNotPublished = "ServiceNotPublished"
+)
+
+// serviceRouter returns the name of the VirtualRouter publishing the Service,
+// false if it isn't a LoadBalancer Service annotated with one.
+func serviceRouter(service *corev1.Service) (string, bool) {
+	name := service.Annotations[VIRTUALROUTER_SERVICE_ANNOTATION]
+	return name, name != "" && service.Spec.Type == corev1.ServiceTypeLoadBalancer
+}
+
+// handleService enqueues the VirtualRouter publishing the Service, before
+// and after a change, as an update may move it to another router or off it.
+func (c *Controller) handleService(obj interface{}) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if service, ok = tombstone.Obj.(*corev1.Service); !ok {
+			return
+		}
+	}
+	name := service.Annotations[VIRTUALROUTER_SERVICE_ANNOTATION]
+	if name == "" {
+		return
+	}
+	virtualRouter, err := c.virtualRoutersLister.VirtualRouters(service.Namespace).Get(name)
+	if err != nil {
+		return
+	}
+	c.enqueueVirtualRouter(virtualRouter)
+}
+
+// publishedServices returns the Services the router publishes on its
+// external address, oldest first, and why the others it is asked to publish
+// aren't. A port goes to the port forward or the oldest Service taking it.
+func (c *Controller) publishedServices(virtualRouter *samplev1alpha1.VirtualRouter, externalIP string) ([]*corev1.Service, map[*corev1.Service]string, error) {
+	services, err := c.servicesLister.Services(virtualRouter.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if !services[i].CreationTimestamp.Equal(&services[j].CreationTimestamp) {
+			return services[i].CreationTimestamp.Before(&services[j].CreationTimestamp)
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	taken := map[string]string{}
+	for _, portForward := range virtualRouter.Spec.PortForwards {
+		taken[fmt.Sprintf("%s/%d", portForwardProtocol(portForward), portForward.ExternalPort)] = "a port forward"
+	}
+	var published []*corev1.Service
+	rejected := map[*corev1.Service]string{}
+	for _, service := range services {
+		if name, ok := serviceRouter(service); !ok || name != virtualRouter.Name || !service.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+			rejected[service] = "it has no cluster IP to forward to"
+			continue
+		}
+		if service.Spec.LoadBalancerIP != "" && service.Spec.LoadBalancerIP != externalIP {
+			rejected[service] = fmt.Sprintf("loadBalancerIP %s is not the external IP %s of the router", service.Spec.LoadBalancerIP, externalIP)
+			continue
+		}
+		var conflict string
+		for _, port := range service.Spec.Ports {
+			key := fmt.Sprintf("%s/%d", servicePortProtocol(port), port.Port)
+			if owner, ok := taken[key]; ok {
+				conflict = fmt.Sprintf("port %s is taken by %s", key, owner)
+				break
+			}
+		}
+		if conflict != "" {
+			rejected[service] = conflict
+			continue
+		}
+		for _, port := range service.Spec.Ports {
+			taken[fmt.Sprintf("%s/%d", servicePortProtocol(port), port.Port)] = "Service " + service.Name
+		}
+		published = append(published, service)
+	}
+	return published, rejected, nil
+}
+
+func servicePortProtocol(port corev1.ServicePort) string {
+	if port.Protocol == "" {
+		return "tcp"
+	}
+	return strings.ToLower(string(port.Protocol))
+}
+
+// newServiceNATRule compiles the ports of the published Services into DNAT
+// rules from the external address of the router to their cluster IPs, the
+// same way as port forwards.
+func newServiceNATRule(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, externalIP string, services []*corev1.Service) *nfvv1.NATRule {
+	var rules []nfvv1.Rules
+	for _, service := range services {
+		for _, port := range service.Spec.Ports {
+			rules = append(rules, nfvv1.Rules{
+				Match: nfvv1.Match{
+					DstIP:    externalIP,
+					Protocol: servicePortProtocol(port) + " --dport " + strconv.Itoa(int(port.Port)),
+				},
+				Action: nfvv1.Action{
+					DstIP: net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port))),
+				},
+			})
+		}
+	}
+	return &nfvv1.NATRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nfvv1.SchemeGroupVersion.String(),
+			Kind:       "NATRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      routerResourceName(virtualRouter, SERVICE_NAT_RULE_NAME),
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Spec: nfvv1.NATRuleSpec{
+			Rules: rules,
+		},
+	}
+}
+
+// ensureServiceLoadBalancers publishes the LoadBalancer Services annotated
+// with the router on its external address with Options.ServiceLoadBalancers:
+// their ports are compiled into a managed NATRule, and the external address
+// is written to their status as their load balancer ingress, like MetalLB
+// does. Services the router stops publishing lose the ingress again.
+func (c *Controller) ensureServiceLoadBalancers(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, gate *disruptionGate) error {
+	externalIP := effectiveExternalIP(virtualRouter)
+	var published []*corev1.Service
+	var rejected map[*corev1.Service]string
+	if c.options.ServiceLoadBalancers && externalIP != "" && virtualRouter.DeletionTimestamp.IsZero() {
+		var err error
+		published, rejected, err = c.publishedServices(virtualRouter, externalIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	var desired *nfvv1.NATRule
+	if len(published) > 0 {
+		desired = newServiceNATRule(newNS, virtualRouter, externalIP, published)
+	}
+	if err := c.ensureManagedNATRule(newNS, virtualRouter, routerResourceName(virtualRouter, SERVICE_NAT_RULE_NAME), desired, "Service load balancers", gate); err != nil {
+		return err
+	}
+
+	for service, reason := range rejected {
+		c.recorder.Eventf(service, corev1.EventTypeWarning, ServiceNotPublished, "VirtualRouter %s can't publish the Service: %s", virtualRouter.Name, reason)
+	}
+	if !c.options.ServiceLoadBalancers {
+		return nil
+	}
+	ingress := []corev1.LoadBalancerIngress{{IP: externalIP}}
+	for _, service := range published {
+		if reflect.DeepEqual(service.Status.LoadBalancer.Ingress, ingress) {
+			continue
+		}
+		klog.Infof("Publishing Service %s/%s on %s", service.Namespace, service.Name, externalIP)
+		if err := c.setServiceIngress(service, ingress); err != nil {
+			return err
+		}
+	}
+	return c.unpublishServices(virtualRouter, published)
+}
+
+// unpublishServices clears the load balancer ingress the router wrote to
+// Services it no longer publishes, which are left annotated with the router
+// or not annotated at all.
+func (c *Controller) unpublishServices(virtualRouter *samplev1alpha1.VirtualRouter, published []*corev1.Service) error {
+	externalIP := effectiveExternalIP(virtualRouter)
+	if externalIP == "" {
+		return nil
+	}
+	services, err := c.servicesLister.Services(virtualRouter.Namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	isPublished := map[string]bool{}
+	for _, service := range published {
+		isPublished[service.Name] = true
+	}
+	for _, service := range services {
+		if isPublished[service.Name] || !reflect.DeepEqual(service.Status.LoadBalancer.Ingress, []corev1.LoadBalancerIngress{{IP: externalIP}}) {
+			continue
+		}
+		if name := service.Annotations[VIRTUALROUTER_SERVICE_ANNOTATION]; name != "" && name != virtualRouter.Name {
+			continue
+		}
+		klog.Infof("Unpublishing Service %s/%s from %s", service.Namespace, service.Name, externalIP)
+		if err := c.setServiceIngress(service, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) setServiceIngress(service *corev1.Service, ingress []corev1.LoadBalancerIngress) error {
+	serviceCopy := service.DeepCopy()
+	serviceCopy.Status.LoadBalancer.Ingress = ingress
+	_, err := c.statuskubeclientset.CoreV1().Services(service.Namespace).UpdateStatus(c.ctx, serviceCopy, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,224 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DEFAULT_DRAIN_TIMEOUT is how long the syncs running on shutdown are
+	// waited for, unless configured. It leaves the pending keys time to be
+	// persisted within the default termination grace period of 30s.
+	DEFAULT_DRAIN_TIMEOUT = 20 * time.Second
+
+	// PENDING_KEYS_CONFIGMAP_NAME is the ConfigMap in the controller
+	// namespace the keys not synced on shutdown are persisted in, suffixed
+	// with -<controller class> for a controller class.
+	PENDING_KEYS_CONFIGMAP_NAME string = "virtualrouter-controller-pending"
+	// PENDING_KEYS_KEY holds the keys, one per line.
+	PENDING_KEYS_KEY string = "keys"
+
+	// persistTimeout bounds the API calls persisting the pending keys, made
+	// once the syncs are cancelled.
+	persistTimeout = 5 * time.Second
+)
+
+// pendingKeyQueue is a work queue keeping the keys added and not synced
+// since, whether queued, waiting to be added or being synced.
+type pendingKeyQueue struct {
+	*priorityQueue
+
+	lock sync.Mutex
+	// pending holds the version of the last add of every pending key
+	pending map[interface{}]uint64
+	last    uint64
+}
+
+func newPendingKeyQueue(queue *priorityQueue) *pendingKeyQueue {
+	return &pendingKeyQueue{priorityQueue: queue, pending: map[interface{}]uint64{}}
+}
+
+func (q *pendingKeyQueue) addPending(item interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.last++
+	q.pending[item] = q.last
+}
+
+func (q *pendingKeyQueue) Add(item interface{}) {
+	q.addPending(item)
+	q.priorityQueue.Add(item)
+}
+
+func (q *pendingKeyQueue) AddUrgent(item interface{}) {
+	q.addPending(item)
+	q.priorityQueue.AddUrgent(item)
+}
+
+func (q *pendingKeyQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.addPending(item)
+	q.priorityQueue.AddAfter(item, duration)
+}
+
+func (q *pendingKeyQueue) AddUrgentAfter(item interface{}, duration time.Duration) {
+	q.addPending(item)
+	q.priorityQueue.AddUrgentAfter(item, duration)
+}
+
+func (q *pendingKeyQueue) AddRateLimited(item interface{}) {
+	q.addPending(item)
+	q.priorityQueue.AddRateLimited(item)
+}
+
+// version returns the version of the last add of the item, to be given to
+// synced.
+func (q *pendingKeyQueue) version(item interface{}) uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.pending[item]
+}
+
+// synced drops the item from the pending keys, unless it was added again
+// since version.
+func (q *pendingKeyQueue) synced(item interface{}, version uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.pending[item] == version {
+		delete(q.pending, item)
+	}
+}
+
+// pendingKeys returns the pending keys, sorted.
+func (q *pendingKeyQueue) pendingKeys() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	keys := make([]string, 0, len(q.pending))
+	for item := range q.pending {
+		if key, ok := item.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pendingKeysConfigMapName returns the ConfigMap the pending keys of the
+// controller are persisted in.
+func (c *Controller) pendingKeysConfigMapName() string {
+	if c.options.ControllerClass == "" {
+		return PENDING_KEYS_CONFIGMAP_NAME
+	}
+	return PENDING_KEYS_CONFIGMAP_NAME + "-" + c.options.ControllerClass
+}
+
+// drain shuts the work queue down and waits for the workers to finish the
+// syncs they are running. The API calls of those outlasting the drain
+// timeout are cancelled. The keys left pending are then persisted.
+func (c *Controller) drain(workers *sync.WaitGroup) {
+	c.workqueue.ShutDown()
+
+	timeout := c.options.DrainTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_DRAIN_TIMEOUT
+	}
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		klog.Info("Drained the workers")
+	case <-time.After(timeout):
+		klog.Warningf("Syncs still running after %s, cancelling them", timeout)
+	}
+	c.flushStatus()
+	c.cancel()
+
+	c.persistPendingKeys()
+}
+
+// persistPendingKeys saves the keys not synced yet, so the next start syncs
+// them first instead of after every VirtualRouter the informers queue.
+func (c *Controller) persistPendingKeys() {
+	keys := c.workqueue.pendingKeys()
+	if len(keys) == 0 || c.options.ControllerNamespace == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(c.options.ControllerNamespace)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: c.pendingKeysConfigMapName(), Namespace: c.options.ControllerNamespace},
+		Data:       map[string]string{PENDING_KEYS_KEY: strings.Join(keys, "\n")},
+	}
+	_, err := configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	}
+	if err != nil {
+		klog.Errorf("Error persisting %d pending keys: %s", len(keys), err.Error())
+		return
+	}
+	klog.Infof("Persisted %d pending keys", len(keys))
+}
+
+// restorePendingKeys syncs the keys persisted on the last shutdown ahead of
+// the VirtualRouters the informers queued on start, with threadiness
+// workers. Those failing are requeued.
+func (c *Controller) restorePendingKeys(threadiness int, stopCh <-chan struct{}) {
+	if c.options.ControllerNamespace == "" {
+		return
+	}
+	configMaps := c.kubeclientset.CoreV1().ConfigMaps(c.options.ControllerNamespace)
+	configMap, err := configMaps.Get(c.ctx, c.pendingKeysConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Error reading the pending keys: %s", err.Error())
+		}
+		return
+	}
+	// the keys are taken once, the informers having queued them anyway
+	if err := configMaps.Delete(c.ctx, configMap.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Error deleting the pending keys: %s", err.Error())
+	}
+
+	keys := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < threadiness; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for key := range keys {
+				if err := c.syncHandler(key); err != nil {
+					c.workqueue.AddRateLimited(key)
+					utilruntime.HandleError(err)
+				}
+			}
+		}()
+	}
+	restored := 0
+	for _, key := range strings.Split(configMap.Data[PENDING_KEYS_KEY], "\n") {
+		if key == "" {
+			continue
+		}
+		select {
+		case keys <- key:
+			restored++
+		case <-stopCh:
+		}
+	}
+	close(keys)
+	workers.Wait()
+	klog.Infof("Synced %d keys pending since the last shutdown", restored)
+}
@@ -0,0 +1,123 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// validateSidecars checks the sidecars are named apart, and have an image or
+// a profile to take it from.
+func validateSidecars(sidecars []samplev1alpha1.RouterSidecar) error {
+	names := map[string]bool{}
+	for _, sidecar := range sidecars {
+		if errs := validation.IsDNS1123Label(sidecar.Name); len(errs) > 0 {
+			return fmt.Errorf("sidecar %q: invalid name: %s", sidecar.Name, errs[0])
+		}
+		if sidecar.Name == ROUTER_INIT_CONTAINER_NAME {
+			return fmt.Errorf("sidecar %s: the name is used by the router", sidecar.Name)
+		}
+		if names[sidecar.Name] {
+			return fmt.Errorf("sidecar %s is declared twice", sidecar.Name)
+		}
+		names[sidecar.Name] = true
+		if sidecar.Image == "" && sidecar.Profile == "" {
+			return fmt.Errorf("sidecar %s: an image or a profile is required", sidecar.Name)
+		}
+	}
+	return nil
+}
+
+// mergeSidecar returns the sidecar completed with the profile, the fields it
+// sets taking precedence.
+func mergeSidecar(profile, sidecar samplev1alpha1.RouterSidecar) samplev1alpha1.RouterSidecar {
+	merged := *profile.DeepCopy()
+	merged.Name = sidecar.Name
+	merged.Profile = sidecar.Profile
+	if sidecar.Image != "" {
+		merged.Image = sidecar.Image
+	}
+	if sidecar.Command != nil {
+		merged.Command = sidecar.Command
+	}
+	if sidecar.Args != nil {
+		merged.Args = sidecar.Args
+	}
+	for _, env := range sidecar.Env {
+		replaced := false
+		for i := range merged.Env {
+			if merged.Env[i].Name == env.Name {
+				merged.Env[i] = env
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged.Env = append(merged.Env, env)
+		}
+	}
+	if sidecar.Ports != nil {
+		merged.Ports = sidecar.Ports
+	}
+	if sidecar.Resources != nil {
+		merged.Resources = sidecar.Resources
+	}
+	merged.NetAdmin = merged.NetAdmin || sidecar.NetAdmin
+	return merged
+}
+
+// sidecarContainer returns the container of the sidecar in the router pods.
+func sidecarContainer(sidecar samplev1alpha1.RouterSidecar) corev1.Container {
+	container := corev1.Container{
+		Name:    sidecar.Name,
+		Image:   sidecar.Image,
+		Command: sidecar.Command,
+		Args:    sidecar.Args,
+		Env:     sidecar.Env,
+		Ports:   sidecar.Ports,
+	}
+	if sidecar.Resources != nil {
+		container.Resources = *sidecar.Resources
+	}
+	if sidecar.NetAdmin {
+		container.SecurityContext = &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"}},
+		}
+	}
+	return container
+}
+
+// sidecarContainers returns the containers of the sidecars of the
+// VirtualRouter, completed with the sidecar profiles. It fails if a sidecar
+// names a profile the controller has none of, or is named as the router.
+func sidecarContainers(virtualRouter *samplev1alpha1.VirtualRouter, profiles []samplev1alpha1.RouterSidecar) ([]corev1.Container, error) {
+	var containers []corev1.Container
+	for _, sidecar := range virtualRouter.Spec.Sidecars {
+		if sidecar.Name == virtualRouter.Name {
+			return nil, fmt.Errorf("sidecar %s: the name is used by the router", sidecar.Name)
+		}
+		if sidecar.Profile != "" {
+			found := false
+			for _, profile := range profiles {
+				if profile.Name == sidecar.Profile {
+					sidecar = mergeSidecar(profile, sidecar)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("sidecar %s: no sidecar profile %s", sidecar.Name, sidecar.Profile)
+			}
+		}
+		containers = append(containers, sidecarContainer(sidecar))
+	}
+	return containers, nil
+}
+
+// sidecarContainers returns the containers of the sidecars of the
+// VirtualRouter with the sidecar profiles of the controller.
+func (c *Controller) sidecarContainers(virtualRouter *samplev1alpha1.VirtualRouter) ([]corev1.Container, error) {
+	return sidecarContainers(virtualRouter, c.sidecarProfiles())
+}
@@ -0,0 +1,91 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/ipam"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func validateSNATPool(snatPool *samplev1alpha1.SNATPool) error {
+	if snatPool == nil {
+		return nil
+	}
+	if snatPool.Pool == "" {
+		return fmt.Errorf("snat pool: no IPAM pool")
+	}
+	if snatPool.Size < 1 || snatPool.Size > 16 {
+		return fmt.Errorf("snat pool: size %d is not 1 to 16", snatPool.Size)
+	}
+	for _, source := range snatPool.Sources {
+		if _, _, err := net.ParseCIDR(source); err != nil {
+			return fmt.Errorf("snat pool: invalid source %q", source)
+		}
+	}
+	return nil
+}
+
+// allocateSNATPool allocates and releases addresses of spec.snatPool until
+// the router has as many as the pool size, releasing them all once the pool
+// is changed or dropped, or the VirtualRouter is deleted. The allocations are
+// written to the status as they are made, and the VirtualRouter written is
+// returned.
+func (c *Controller) allocateSNATPool(virtualRouter *samplev1alpha1.VirtualRouter) (*samplev1alpha1.VirtualRouter, error) {
+	allocations := virtualRouter.Status.SNATPoolAllocations
+	snatPool := virtualRouter.Spec.SNATPool
+	var pool string
+	var size int
+	if snatPool != nil && virtualRouter.DeletionTimestamp.IsZero() {
+		pool, size = snatPool.Pool, int(snatPool.Size)
+	}
+
+	var kept, released []samplev1alpha1.IPAMAllocation
+	for _, allocation := range allocations {
+		if allocation.Pool == pool && len(kept) < size {
+			kept = append(kept, allocation)
+		} else {
+			released = append(released, allocation)
+		}
+	}
+	if c.dryRunPlan != nil {
+		// the IPAM has no dry run
+		for _, allocation := range released {
+			c.dryRunPlan.add(fmt.Sprintf("release SNAT pool address %s", allocation.Address))
+		}
+		if len(kept) < size {
+			c.dryRunPlan.add(fmt.Sprintf("allocate %d SNAT pool addresses from %s", size-len(kept), pool))
+		}
+		return virtualRouter, nil
+	}
+
+	if len(released) > 0 {
+		for _, allocation := range released {
+			klog.Infof("Releasing SNAT pool address %s of VirtualRouter %s/%s", allocation.Address, virtualRouter.Namespace, virtualRouter.Name)
+			if err := c.options.IPAM.Release(ipam.Allocation{CIDR: allocation.Address, Reference: allocation.Reference}); err != nil {
+				return nil, fmt.Errorf("releasing SNAT pool address %s: %v", allocation.Address, err)
+			}
+		}
+		var err error
+		if virtualRouter, err = c.persistAllocations(virtualRouter, virtualRouter.Status.IPAMAllocation, kept); err != nil {
+			return nil, err
+		}
+	}
+	for len(kept) < size {
+		// allocations are told apart by description, and kept ones are
+		// always the first of the list
+		description := fmt.Sprintf("%s snat %d", ipamDescription(virtualRouter), len(kept))
+		allocated, err := c.options.IPAM.AllocateAddress(pool, description)
+		if err != nil {
+			return nil, fmt.Errorf("allocating SNAT pool address from %s: %v", pool, err)
+		}
+		klog.Infof("Allocated SNAT pool address %s to VirtualRouter %s/%s", allocated.CIDR, virtualRouter.Namespace, virtualRouter.Name)
+		kept = append(kept, samplev1alpha1.IPAMAllocation{Pool: pool, Address: allocated.CIDR, Reference: allocated.Reference})
+		if virtualRouter, err = c.persistAllocations(virtualRouter, virtualRouter.Status.IPAMAllocation, kept); err != nil {
+			return nil, err
+		}
+	}
+	return virtualRouter, nil
+}
@@ -0,0 +1,61 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// SRIOVVFCount returns the number of virtual functions the router pods
+// request.
+func SRIOVVFCount(sriov *samplev1alpha1.SRIOV) int32 {
+	if sriov.VFCount == 0 {
+		return 1
+	}
+	return sriov.VFCount
+}
+
+func validateSRIOV(sriov *samplev1alpha1.SRIOV) error {
+	if sriov == nil {
+		return nil
+	}
+	if parts := strings.Split(sriov.ResourceName, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("external SR-IOV: resource name %q is not <vendor>/<resource>", sriov.ResourceName)
+	}
+	if sriov.VFCount < 0 {
+		return fmt.Errorf("external SR-IOV: vfCount can't be negative")
+	}
+	if sriov.VLAN < 0 || sriov.VLAN > 4094 {
+		return fmt.Errorf("external SR-IOV: vlan is 0 to 4094")
+	}
+	if sriov.MAC != "" {
+		if _, err := net.ParseMAC(sriov.MAC); err != nil {
+			return fmt.Errorf("external SR-IOV: invalid MAC %q", sriov.MAC)
+		}
+	}
+	return nil
+}
+
+// addRouterSRIOVResources has the router container request the virtual
+// functions of the device plugin, which tells the daemons their PCI
+// addresses in its environment.
+func addRouterSRIOVResources(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	sriov := virtualRouter.Spec.ExternalSRIOV
+	container := &deployment.Spec.Template.Spec.Containers[0]
+	quantity := *resource.NewQuantity(int64(SRIOVVFCount(sriov)), resource.DecimalSI)
+	name := corev1.ResourceName(sriov.ResourceName)
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	if container.Resources.Limits == nil {
+		container.Resources.Limits = corev1.ResourceList{}
+	}
+	container.Resources.Requests[name] = quantity
+	container.Resources.Limits[name] = quantity
+}
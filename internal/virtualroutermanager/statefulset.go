@@ -0,0 +1,226 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+func usesStatefulSet(virtualRouter *samplev1alpha1.VirtualRouter) bool {
+	return virtualRouter.Spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind
+}
+
+func validateWorkloadKind(spec samplev1alpha1.VirtualRouterSpec) error {
+	if spec.WorkloadKind == samplev1alpha1.StatefulSetWorkloadKind && spec.Autoscaling != nil {
+		return fmt.Errorf("workloadKind: routers of stable identities can't be autoscaled")
+	}
+	return nil
+}
+
+// podOrdinal returns the ordinal of a router pod of a StatefulSet, the number
+// its name ends with.
+func podOrdinal(pod *corev1.Pod) (int, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "StatefulSet" || !strings.HasPrefix(pod.Name, owner.Name+"-") {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, owner.Name+"-"))
+	if err != nil {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// stateClaimName returns the state claim of the router pod of the ordinal, as
+// the StatefulSet controller names the claims it creates.
+func stateClaimName(virtualRouter *samplev1alpha1.VirtualRouter, ordinal int32) string {
+	return fmt.Sprintf("%s-%s-%d", routerStateVolumeName, virtualRouter.Spec.DeploymentName, ordinal)
+}
+
+// renderStatefulSet returns the StatefulSet running the router pods of the
+// Deployment in StatefulSet mode. The pods are started and replaced one at a
+// time, the highest ordinal first, so standby routers are replaced before the
+// active one. Each pod is given its name, which the router keys its VRRP
+// priority off, and with spec.persistence a state claim of its own.
+func renderStatefulSet(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) *appsv1.StatefulSet {
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: *deployment.ObjectMeta.DeepCopy(),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            deployment.Spec.Replicas,
+			Selector:            deployment.Spec.Selector,
+			Template:            *deployment.Spec.Template.DeepCopy(),
+			ServiceName:         deployment.Name,
+			PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
+		},
+	}
+	podSpec := &statefulSet.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:      "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+	})
+	if hasPersistence(virtualRouter) {
+		// the claim mounted by the router container is the pod's own
+		for i, volume := range podSpec.Volumes {
+			if volume.Name == routerStateVolumeName {
+				podSpec.Volumes = append(podSpec.Volumes[:i:i], podSpec.Volumes[i+1:]...)
+				break
+			}
+		}
+		claim := newStateClaim(deployment.Namespace, virtualRouter)
+		statefulSet.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{
+			ObjectMeta: metav1.ObjectMeta{Name: routerStateVolumeName},
+			Spec:       claim.Spec,
+		}}
+	}
+	statefulSet.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] = specHash(statefulSet.Spec)
+	return statefulSet
+}
+
+// desiredStatefulSet is desiredDeployment run by a StatefulSet.
+func (c *Controller) desiredStatefulSet(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, checksums map[string]string) *appsv1.StatefulSet {
+	return renderStatefulSet(c.desiredDeployment(newNS, virtualRouter, checksums), virtualRouter)
+}
+
+// statefulSetView returns the StatefulSet as the Deployment the rest of the
+// sync reads: its selector, replicas and rollout status, a pod being
+// available once it is ready.
+func statefulSetView(statefulSet *appsv1.StatefulSet) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: statefulSet.ObjectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: statefulSet.Spec.Replicas,
+			Selector: statefulSet.Spec.Selector,
+			Template: statefulSet.Spec.Template,
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: statefulSet.Status.ObservedGeneration,
+			Replicas:           statefulSet.Status.Replicas,
+			UpdatedReplicas:    statefulSet.Status.UpdatedReplicas,
+			ReadyReplicas:      statefulSet.Status.ReadyReplicas,
+			AvailableReplicas:  statefulSet.Status.ReadyReplicas,
+		},
+	}
+}
+
+// routerWorkload returns the cached workload of the router, its StatefulSet
+// as statefulSetView in StatefulSet mode.
+func (c *Controller) routerWorkload(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (*appsv1.Deployment, error) {
+	if !usesStatefulSet(virtualRouter) {
+		return c.deploymentsLister.Deployments(newNS).Get(virtualRouter.Spec.DeploymentName)
+	}
+	statefulSet, err := c.statefulSetsLister.StatefulSets(newNS).Get(virtualRouter.Spec.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	return statefulSetView(statefulSet), nil
+}
+
+func claimTemplateNames(statefulSet *appsv1.StatefulSet) []string {
+	var names []string
+	for _, claim := range statefulSet.Spec.VolumeClaimTemplates {
+		names = append(names, claim.Name)
+	}
+	return names
+}
+
+// ensureStatefulSet creates or updates the StatefulSet of a router in
+// StatefulSet mode, and returns it as statefulSetView. A new router isn't
+// started before its external IP is approved, nil being returned meanwhile.
+func (c *Controller) ensureStatefulSet(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, checksums map[string]string, gate *disruptionGate) (*appsv1.Deployment, error) {
+	statefulSet, err := c.statefulSetsLister.StatefulSets(newNS).Get(virtualRouter.Spec.DeploymentName)
+	if errors.IsNotFound(err) {
+		if !isExternalIPApproved(virtualRouter) {
+			return nil, nil
+		}
+		statefulSet, err = c.kubeclientset.AppsV1().StatefulSets(newNS).Create(c.ctx, c.desiredStatefulSet(newNS, virtualRouter, checksums), metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(statefulSet, virtualRouter) {
+		msg := fmt.Sprintf(MessageResourceExists, statefulSet.Name)
+		c.recorder.Event(virtualRouter, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, fmt.Errorf(msg)
+	}
+
+	// compared by the hash of the rendered spec, as the Deployment is
+	desired := c.desiredStatefulSet(newNS, virtualRouter, checksums)
+	if statefulSet.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] == desired.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION] {
+		return statefulSetView(statefulSet), nil
+	}
+	hash := statefulSet.Annotations[DEPLOYMENT_SPEC_HASH_ANNOTATION]
+	if gate.holdsWorkload(statefulSetScalesOnly(hash, statefulSet.Spec.Replicas, desired.Spec)) {
+		klog.V(4).Infof("VirtualRouter %s spec hash differs from StatefulSet %s, deferred", virtualRouter.Name, statefulSet.Name)
+		return statefulSetView(statefulSet), nil
+	}
+	if !reflect.DeepEqual(claimTemplateNames(statefulSet), claimTemplateNames(desired)) {
+		// The claim templates of a StatefulSet can't be changed, so it is
+		// deleted and created anew by the next sync, its pods being kept
+		// until the new one replaces them.
+		klog.Infof("Replacing StatefulSet %s/%s of VirtualRouter %s/%s for its state claims", newNS, statefulSet.Name, virtualRouter.Namespace, virtualRouter.Name)
+		orphan := metav1.DeletePropagationOrphan
+		err := c.kubeclientset.AppsV1().StatefulSets(newNS).Delete(c.ctx, statefulSet.Name, metav1.DeleteOptions{PropagationPolicy: &orphan})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return statefulSetView(statefulSet), nil
+	}
+	klog.V(4).Infof("VirtualRouter %s spec hash differs from StatefulSet %s, updating", virtualRouter.Name, statefulSet.Name)
+	// the claims are grown by ensureStateClaim instead
+	desired.Spec.VolumeClaimTemplates = statefulSet.Spec.VolumeClaimTemplates
+	statefulSet, err = c.kubeclientset.AppsV1().StatefulSets(newNS).Update(c.ctx, desired, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return statefulSetView(statefulSet), nil
+}
+
+// deleteReplacedWorkload deletes the workload of the other kind the router
+// ran on before spec.workloadKind was changed, along with its pods, once a
+// pod of the new workload is available to take over.
+func (c *Controller) deleteReplacedWorkload(newNS string, virtualRouter *samplev1alpha1.VirtualRouter, workload *appsv1.Deployment) error {
+	if workload.Status.AvailableReplicas == 0 {
+		return nil
+	}
+	name := virtualRouter.Spec.DeploymentName
+	var replaced metav1.Object
+	var err error
+	if usesStatefulSet(virtualRouter) {
+		replaced, err = c.deploymentsLister.Deployments(newNS).Get(name)
+	} else {
+		replaced, err = c.statefulSetsLister.StatefulSets(newNS).Get(name)
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(replaced, virtualRouter) {
+		return nil
+	}
+	klog.Infof("Deleting the replaced workload %s/%s of VirtualRouter %s/%s", newNS, name, virtualRouter.Namespace, virtualRouter.Name)
+	if usesStatefulSet(virtualRouter) {
+		err = c.kubeclientset.AppsV1().Deployments(newNS).Delete(c.ctx, name, metav1.DeleteOptions{})
+	} else {
+		err = c.kubeclientset.AppsV1().StatefulSets(newNS).Delete(c.ctx, name, metav1.DeleteOptions{})
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package virtualroutermanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// STATUS_HEARTBEAT_INTERVAL is how often status.lastReconcileTime is
+// refreshed while nothing else in the status changes. Refreshing it on every
+// sync would send every watcher of VirtualRouters an update per resync.
+const STATUS_HEARTBEAT_INTERVAL time.Duration = 5 * time.Minute
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// statusPatch returns the JSON Patch turning the old status into the new one,
+// with an operation per changed field, or nil if nothing changed. Fields are
+// added rather than replaced, which sets them whether or not the old status
+// held them.
+func statusPatch(old, new samplev1alpha1.VirtualRouterStatus) ([]byte, error) {
+	if reflect.DeepEqual(old, new) {
+		return nil, nil
+	}
+	newFields, err := statusFields(new)
+	if err != nil {
+		return nil, err
+	}
+	var operations []jsonPatchOperation
+	if reflect.DeepEqual(old, samplev1alpha1.VirtualRouterStatus{}) {
+		// a VirtualRouter never given a status may have no status to patch
+		value, err := json.Marshal(new)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: "/status", Value: value})
+		return json.Marshal(operations)
+	}
+
+	oldFields, err := statusFields(old)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range sortedKeys(newFields) {
+		if !bytes.Equal(oldFields[field], newFields[field]) {
+			operations = append(operations, jsonPatchOperation{Op: "add", Path: "/status/" + field, Value: newFields[field]})
+		}
+	}
+	for _, field := range sortedKeys(oldFields) {
+		if _, ok := newFields[field]; !ok {
+			operations = append(operations, jsonPatchOperation{Op: "remove", Path: "/status/" + field})
+		}
+	}
+	if len(operations) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(operations)
+}
+
+// statusFields returns the serialized value of every field the status holds.
+func statusFields(status samplev1alpha1.VirtualRouterStatus) (map[string]json.RawMessage, error) {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func sortedKeys(fields map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+package virtualroutermanager
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// DEFAULT_STATUS_BATCH_INTERVAL is how long the status of VirtualRouters
+	// is held before it is written, so the statuses of syncs in the meantime
+	// are written once. Statuses are written at the end of every sync by
+	// default.
+	DEFAULT_STATUS_BATCH_INTERVAL time.Duration = 0
+
+	// STATUS_UPDATES_METRIC is the metric of the statuses of VirtualRouters
+	// synced, by whether they were written or replaced by a later sync first
+	STATUS_UPDATES_METRIC string = "virtualrouter_status_updates_total"
+	STATUS_WRITTEN        string = "written"
+	STATUS_COALESCED      string = "coalesced"
+)
+
+var statusUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: STATUS_UPDATES_METRIC,
+	Help: "Statuses of VirtualRouters synced, by whether they were written or coalesced with a later sync.",
+}, []string{"outcome"})
+
+// pendingStatus is the status of a VirtualRouter not written yet.
+type pendingStatus struct {
+	virtualRouter *samplev1alpha1.VirtualRouter
+	// original is the status last seen when the first of the coalesced
+	// statuses was queued, which the patch is made against
+	original samplev1alpha1.VirtualRouterStatus
+	status   samplev1alpha1.VirtualRouterStatus
+}
+
+// statusWriter holds the statuses the workers sync until they are written
+// together, keeping only the last status of a VirtualRouter synced several
+// times in between.
+type statusWriter struct {
+	lock    sync.Mutex
+	pending map[string]*pendingStatus
+}
+
+func newStatusWriter() *statusWriter {
+	return &statusWriter{pending: map[string]*pendingStatus{}}
+}
+
+// queue holds the status synced for the VirtualRouter in place of the one
+// still pending, if any. A status back to the one last written is dropped.
+func (w *statusWriter) queue(virtualRouter *samplev1alpha1.VirtualRouter, original, status samplev1alpha1.VirtualRouterStatus) {
+	key, err := cache.MetaNamespaceKeyFunc(virtualRouter)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if pending, exist := w.pending[key]; exist {
+		original = pending.original
+		statusUpdates.WithLabelValues(STATUS_COALESCED).Inc()
+	}
+	if reflect.DeepEqual(original, status) {
+		delete(w.pending, key)
+		return
+	}
+	w.pending[key] = &pendingStatus{virtualRouter: virtualRouter, original: original, status: status}
+}
+
+// forget drops the pending status of the VirtualRouter, written since.
+func (w *statusWriter) forget(virtualRouter *samplev1alpha1.VirtualRouter) {
+	key, err := cache.MetaNamespaceKeyFunc(virtualRouter)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.pending, key)
+}
+
+// take returns the pending statuses, leaving none.
+func (w *statusWriter) take() map[string]*pendingStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	pending := w.pending
+	w.pending = map[string]*pendingStatus{}
+	return pending
+}
+
+// batchStatus reports whether the status of the VirtualRouters synced is
+// held to be written with the others, rather than at the end of the sync.
+// Dry runs write theirs at once, for the plan to hold it.
+func (c *Controller) batchStatus() bool {
+	return c.options.StatusBatchInterval > 0 && c.dryRunPlan == nil
+}
+
+// holdsAllocations reports whether the status records addresses allocated to
+// the router that the original doesn't. The next sync reads them back from
+// the status to not allocate them again, so they are never held.
+func holdsAllocations(original, status samplev1alpha1.VirtualRouterStatus) bool {
+	return !reflect.DeepEqual(original.IPAMAllocation, status.IPAMAllocation) ||
+		!reflect.DeepEqual(original.SNATPoolAllocations, status.SNATPoolAllocations) ||
+		!reflect.DeepEqual(original.MACAddresses, status.MACAddresses)
+}
+
+// writeStatus patches the VirtualRouter with the status changes, and records
+// the Events of the transitions.
+func (c *Controller) writeStatus(virtualRouter *samplev1alpha1.VirtualRouter, original, status samplev1alpha1.VirtualRouterStatus) error {
+	// The VirtualRouter CRD enables the status subresource, so the status
+	// block can only be written through it. Only the changed fields are
+	// patched, and nothing is written when nothing changed, so watchers such
+	// as UIs get small updates and only on changes.
+	patch, err := statusPatch(original, status)
+	if err != nil || patch == nil {
+		return err
+	}
+	if _, err := c.statusclientset.TmaxV1().VirtualRouters(virtualRouter.Namespace).Patch(c.ctx, virtualRouter.Name, types.JSONPatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		return err
+	}
+	statusUpdates.WithLabelValues(STATUS_WRITTEN).Inc()
+	c.recordTransitionEvents(virtualRouter, original, status)
+	if c.dryRunPlan == nil {
+		recordProvisioningTiming(virtualRouter, status.ReconcileTiming)
+	}
+	return nil
+}
+
+// flushStatus writes the pending statuses. A VirtualRouter whose status
+// can't be written is synced again.
+func (c *Controller) flushStatus() {
+	for key, pending := range c.statusWriter.take() {
+		err := c.writeStatus(pending.virtualRouter, pending.original, pending.status)
+		switch {
+		case err == nil:
+		case errors.IsNotFound(err):
+			// deleted since
+		default:
+			utilruntime.HandleError(err)
+			c.workqueue.AddRateLimited(key)
+		}
+	}
+}
+
+// runStatusWriter writes the pending statuses every StatusBatchInterval
+// until stopped. The last ones are written once the workers are drained.
+func (c *Controller) runStatusWriter(stopCh <-chan struct{}) {
+	if c.options.StatusBatchInterval <= 0 {
+		return
+	}
+	klog.Infof("Writing VirtualRouter statuses every %s", c.options.StatusBatchInterval)
+	wait.Until(c.flushStatus, c.options.StatusBatchInterval, stopCh)
+}
@@ -0,0 +1,213 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ROUTER_INIT_CONTAINER_NAME is the init container setting the sysctls
+	// of router pods.
+	ROUTER_INIT_CONTAINER_NAME string = "router-init"
+	// DEFAULT_ROUTER_INIT_IMAGE is the image of the init container unless
+	// the controller is given another one. It only needs sysctl.
+	DEFAULT_ROUTER_INIT_IMAGE string = "busybox:1.36.1"
+)
+
+// sysctlNamePattern only takes sysctls of the net tree, which are of the
+// network namespace of the pod. Others would be set on the node by the
+// privileged init container. Components are never empty nor hold a "/",
+// which sysctl turns into the "." of a path.
+var sysctlNamePattern = regexp.MustCompile(`^net(\.[a-z0-9_\-]+)+$`)
+
+// nodeSysctlNamePattern takes sysctls of any tree, set on the node by the
+// daemons. Slashes are the dots of interface names, as in
+// net.ipv4.conf.eth0/100.rp_filter, see NodeSysctlPath.
+var nodeSysctlNamePattern = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_\-/]+)+$`)
+
+// safeSysctls are the sysctls kubelets let pods set without being allowed
+// them by --allowed-unsafe-sysctls.
+var safeSysctls = map[string]bool{
+	"kernel.shm_rmid_forced":              true,
+	"net.ipv4.ip_local_port_range":        true,
+	"net.ipv4.tcp_syncookies":             true,
+	"net.ipv4.ping_group_range":           true,
+	"net.ipv4.ip_unprivileged_port_start": true,
+}
+
+// routerSysctls returns the sysctls the init container sets for the router:
+// the forwarding of the Restricted profile, whose router container can't set
+// them itself as its /proc/sys is read-only, and the sysctls of the spec,
+// which override them.
+func routerSysctls(spec samplev1alpha1.VirtualRouterSpec) []samplev1alpha1.RouterSysctl {
+	var sysctls []samplev1alpha1.RouterSysctl
+	if IsRestricted(spec) {
+		sysctls = append(sysctls, samplev1alpha1.RouterSysctl{Name: "net.ipv4.ip_forward", Value: "1"})
+		if IsDualStack(spec) {
+			sysctls = append(sysctls, samplev1alpha1.RouterSysctl{Name: "net.ipv6.conf.all.forwarding", Value: "1"})
+		}
+	}
+	for _, sysctl := range spec.Sysctls {
+		overridden := false
+		for i := range sysctls {
+			if sysctls[i].Name == sysctl.Name {
+				sysctls[i].Value = sysctl.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			sysctls = append(sysctls, sysctl)
+		}
+	}
+	return sysctls
+}
+
+// NodeSysctlPath returns the path of the sysctl relative to /proc/sys. As
+// kubelets do, dots and slashes are swapped in a name with slashes, so that
+// net.ipv4.conf.eth0/100.rp_filter is of the interface eth0.100. A name
+// whose path would have an empty, . or .. component is refused, so it stays
+// under /proc/sys.
+func NodeSysctlPath(name string) (string, error) {
+	path := name
+	if strings.Contains(name, "/") {
+		path = strings.NewReplacer(".", "/", "/", ".").Replace(name)
+	} else {
+		path = strings.Replace(name, ".", "/", -1)
+	}
+	for _, component := range strings.Split(path, "/") {
+		if component == "" || component == "." || component == ".." {
+			return "", fmt.Errorf("sysctl %q is not a file under /proc/sys", name)
+		}
+	}
+	return path, nil
+}
+
+// SysctlAllowed reports whether the sysctl is of the patterns, names or
+// prefixes ending with *, as kubelets take --allowed-unsafe-sysctls.
+func SysctlAllowed(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSysctls(sysctls []samplev1alpha1.RouterSysctl) error {
+	names := map[string]bool{}
+	for _, sysctl := range sysctls {
+		if !sysctlNamePattern.MatchString(sysctl.Name) {
+			return fmt.Errorf("sysctl %q: only sysctls of the net tree can be set", sysctl.Name)
+		}
+		if names[sysctl.Name] {
+			return fmt.Errorf("sysctl %s is set more than once", sysctl.Name)
+		}
+		names[sysctl.Name] = true
+		if sysctl.Value == "" {
+			return fmt.Errorf("sysctl %s has no value", sysctl.Name)
+		}
+	}
+	return nil
+}
+
+// validateNodeSysctls checks spec.nodeSysctls. Whether a node takes them is
+// up to its daemon.
+func validateNodeSysctls(sysctls []samplev1alpha1.RouterSysctl) error {
+	names := map[string]bool{}
+	for _, sysctl := range sysctls {
+		if !nodeSysctlNamePattern.MatchString(sysctl.Name) {
+			return fmt.Errorf("nodeSysctls: invalid sysctl name %q", sysctl.Name)
+		}
+		if _, err := NodeSysctlPath(sysctl.Name); err != nil {
+			return fmt.Errorf("nodeSysctls: %v", err)
+		}
+		if names[sysctl.Name] {
+			return fmt.Errorf("nodeSysctls: sysctl %s is set more than once", sysctl.Name)
+		}
+		names[sysctl.Name] = true
+		if sysctl.Value == "" {
+			return fmt.Errorf("nodeSysctls: sysctl %s has no value", sysctl.Name)
+		}
+	}
+	return nil
+}
+
+// addRouterInitContainer has an init container set the sysctls of the router
+// in the network namespace of the pod, so that the router container needs no
+// write access to /proc/sys. Container runtimes only mount /proc/sys writable
+// in privileged containers, so the init container is privileged; it runs the
+// image of the controller rather than the one of the spec, and exits before
+// the router starts.
+func addRouterInitContainer(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter) {
+	sysctls := routerSysctls(virtualRouter.Spec)
+	if len(sysctls) == 0 {
+		return
+	}
+	command := []string{"sysctl", "-w"}
+	for _, sysctl := range sysctls {
+		command = append(command, sysctl.Name+"="+sysctl.Value)
+	}
+	privileged := true
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:            ROUTER_INIT_CONTAINER_NAME,
+		Image:           DEFAULT_ROUTER_INIT_IMAGE,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         command,
+		SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+	})
+}
+
+// setRouterInitImage has the init container run the image, if given.
+func setRouterInitImage(deployment *appsv1.Deployment, image string) {
+	if image == "" {
+		return
+	}
+	podSpec := &deployment.Spec.Template.Spec
+	for i := range podSpec.InitContainers {
+		if podSpec.InitContainers[i].Name == ROUTER_INIT_CONTAINER_NAME {
+			podSpec.InitContainers[i].Image = image
+		}
+	}
+}
+
+// setPodSysctls has the kubelet set the sysctls of the router it takes, the
+// safe ones and those of allowedUnsafeSysctls, in the security context of the
+// pod. Only the others are left to the init container, which is dropped once
+// it has none.
+func setPodSysctls(deployment *appsv1.Deployment, virtualRouter *samplev1alpha1.VirtualRouter, allowedUnsafeSysctls []string) {
+	var podSysctls []corev1.Sysctl
+	command := []string{"sysctl", "-w"}
+	for _, sysctl := range routerSysctls(virtualRouter.Spec) {
+		if safeSysctls[sysctl.Name] || SysctlAllowed(sysctl.Name, allowedUnsafeSysctls) {
+			podSysctls = append(podSysctls, corev1.Sysctl{Name: sysctl.Name, Value: sysctl.Value})
+		} else {
+			command = append(command, sysctl.Name+"="+sysctl.Value)
+		}
+	}
+	if len(podSysctls) == 0 {
+		return
+	}
+	podSpec := &deployment.Spec.Template.Spec
+	if podSpec.SecurityContext == nil {
+		podSpec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	podSpec.SecurityContext.Sysctls = append(podSpec.SecurityContext.Sysctls, podSysctls...)
+	for i, container := range podSpec.InitContainers {
+		if container.Name != ROUTER_INIT_CONTAINER_NAME {
+			continue
+		}
+		if len(command) == 2 {
+			podSpec.InitContainers = append(podSpec.InitContainers[:i:i], podSpec.InitContainers[i+1:]...)
+		} else {
+			podSpec.InitContainers[i].Command = command
+		}
+		break
+	}
+}
@@ -0,0 +1,132 @@
+package virtualroutermanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/tracing"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// The phases of provisioning a router.
+const (
+	PHASE_EXTERNAL_IP string = "externalIP"
+	PHASE_NAMESPACE   string = "namespace"
+	PHASE_RBAC        string = "rbac"
+	PHASE_RULES       string = "rules"
+	PHASE_DEPLOYMENT  string = "deployment"
+	PHASE_STATUS      string = "status"
+	PHASE_SCHEDULING  string = "scheduling"
+	PHASE_DATA_PLANE  string = "dataPlane"
+)
+
+var (
+	reconcilePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "virtualrouter_reconcile_phase_duration_seconds",
+		Help:    "Time a phase of a VirtualRouter reconcile took.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"phase"})
+	provisioningPhaseDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "virtualrouter_provisioning_phase_duration_seconds",
+		Help: "Time a phase of provisioning a VirtualRouter took, as reported in status.reconcileTiming.",
+	}, []string{"namespace", "virtualrouter", "phase"})
+)
+
+// RegisterMetrics registers the metrics of the controller.
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(reconcilePhaseDuration, provisioningPhaseDuration, firewallRuleHits, routerFailovers, statusUpdates)
+}
+
+// reconcileTimer adds up the time the phases of a reconcile take.
+type reconcileTimer struct {
+	clock  clock.Clock
+	phases map[string]time.Duration
+}
+
+func newReconcileTimer(clock clock.Clock) *reconcileTimer {
+	return &reconcileTimer{clock: clock, phases: map[string]time.Duration{}}
+}
+
+// trace runs a step of the phase in a span, adding the time it takes to the
+// phase.
+func (t *reconcileTimer) trace(ctx context.Context, phase string, name string, fn func() error) error {
+	start := t.clock.Now()
+	defer func() {
+		elapsed := t.clock.Since(start)
+		t.phases[phase] += elapsed
+		reconcilePhaseDuration.WithLabelValues(phase).Observe(elapsed.Seconds())
+	}()
+	return tracing.Trace(ctx, name, fn)
+}
+
+// timing returns the controller phases of the reconcile so far.
+func (t *reconcileTimer) timing() *samplev1alpha1.ReconcileTiming {
+	return &samplev1alpha1.ReconcileTiming{
+		ExternalIP: metav1.Duration{Duration: t.phases[PHASE_EXTERNAL_IP]},
+		Namespace:  metav1.Duration{Duration: t.phases[PHASE_NAMESPACE]},
+		RBAC:       metav1.Duration{Duration: t.phases[PHASE_RBAC]},
+		Rules:      metav1.Duration{Duration: t.phases[PHASE_RULES]},
+		Deployment: metav1.Duration{Duration: t.phases[PHASE_DEPLOYMENT]},
+	}
+}
+
+// addPodTiming adds how long the newest router pod waited for the scheduler
+// and for the daemon, as far as it got.
+func addPodTiming(timing *samplev1alpha1.ReconcileTiming, pods []*corev1.Pod) {
+	var newest *corev1.Pod
+	for _, pod := range pods {
+		if newest == nil || newest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			newest = pod
+		}
+	}
+	if newest == nil {
+		return
+	}
+	_, scheduled := podutil.GetPodCondition(&newest.Status, corev1.PodScheduled)
+	if scheduled != nil && scheduled.Status == corev1.ConditionTrue {
+		timing.Scheduling = &metav1.Duration{Duration: scheduled.LastTransitionTime.Sub(newest.CreationTimestamp.Time)}
+	}
+	_, containersReady := podutil.GetPodCondition(&newest.Status, corev1.ContainersReady)
+	_, dataPlaneReady := podutil.GetPodCondition(&newest.Status, VIRTUALROUTER_READINESS_GATE)
+	if containersReady != nil && containersReady.Status == corev1.ConditionTrue && dataPlaneReady != nil && dataPlaneReady.Status == corev1.ConditionTrue {
+		timing.DataPlane = &metav1.Duration{Duration: dataPlaneReady.LastTransitionTime.Sub(containersReady.LastTransitionTime.Time)}
+	}
+}
+
+// recordProvisioningTiming exports the timing reported in the status of the
+// VirtualRouter.
+func recordProvisioningTiming(virtualRouter *samplev1alpha1.VirtualRouter, timing *samplev1alpha1.ReconcileTiming) {
+	if timing == nil {
+		return
+	}
+	phases := map[string]*metav1.Duration{
+		PHASE_EXTERNAL_IP: &timing.ExternalIP,
+		PHASE_NAMESPACE:   &timing.Namespace,
+		PHASE_RBAC:        &timing.RBAC,
+		PHASE_RULES:       &timing.Rules,
+		PHASE_DEPLOYMENT:  &timing.Deployment,
+		PHASE_SCHEDULING:  timing.Scheduling,
+		PHASE_DATA_PLANE:  timing.DataPlane,
+	}
+	for phase, duration := range phases {
+		if duration == nil {
+			provisioningPhaseDuration.DeleteLabelValues(virtualRouter.Namespace, virtualRouter.Name, phase)
+			continue
+		}
+		provisioningPhaseDuration.WithLabelValues(virtualRouter.Namespace, virtualRouter.Name, phase).Set(duration.Seconds())
+	}
+}
+
+// forgetProvisioningTiming stops exporting the timing of a deleted
+// VirtualRouter.
+func forgetProvisioningTiming(namespace, name string) {
+	for _, phase := range []string{PHASE_EXTERNAL_IP, PHASE_NAMESPACE, PHASE_RBAC, PHASE_RULES, PHASE_DEPLOYMENT, PHASE_SCHEDULING, PHASE_DATA_PLANE} {
+		provisioningPhaseDuration.DeleteLabelValues(namespace, name, phase)
+	}
+}
@@ -0,0 +1,54 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+// TUNNEL_MAX_KEY is the largest GRE key
+const TUNNEL_MAX_KEY int64 = 1<<32 - 1
+
+// tunnelReservedNames are the interfaces of router pods tunnels can't take
+var tunnelReservedNames = map[string]bool{"lo": true, "eth0": true, "ethint": true, "ethext": true, "ifbint": true}
+
+// validateTunnels takes IPv4 endpoints only, the GRE and IPIP tunnels of the
+// daemons are IPv4 ones.
+func validateTunnels(tunnels []samplev1alpha1.Tunnel) error {
+	names := map[string]bool{}
+	for _, tunnel := range tunnels {
+		switch {
+		case tunnel.Name == "" || len(tunnel.Name) > 15:
+			return fmt.Errorf("tunnel %q: names are 1 to 15 characters", tunnel.Name)
+		case tunnelReservedNames[tunnel.Name]:
+			return fmt.Errorf("tunnel %s: the name is used by the router itself", tunnel.Name)
+		case names[tunnel.Name]:
+			return fmt.Errorf("tunnel %s: given more than once", tunnel.Name)
+		case tunnel.Mode != samplev1alpha1.TunnelGRE && tunnel.Mode != samplev1alpha1.TunnelIPIP:
+			return fmt.Errorf("tunnel %s: mode is %s or %s", tunnel.Name, samplev1alpha1.TunnelGRE, samplev1alpha1.TunnelIPIP)
+		case net.ParseIP(tunnel.Remote).To4() == nil:
+			return fmt.Errorf("tunnel %s: remote %q is not an IPv4 address", tunnel.Name, tunnel.Remote)
+		case tunnel.Local != "" && net.ParseIP(tunnel.Local).To4() == nil:
+			return fmt.Errorf("tunnel %s: local %q is not an IPv4 address", tunnel.Name, tunnel.Local)
+		case tunnel.Key < 0 || tunnel.Key > TUNNEL_MAX_KEY:
+			return fmt.Errorf("tunnel %s: key is 0 to %d", tunnel.Name, TUNNEL_MAX_KEY)
+		case tunnel.Key != 0 && tunnel.Mode != samplev1alpha1.TunnelGRE:
+			return fmt.Errorf("tunnel %s: only GRE tunnels have a key", tunnel.Name)
+		case tunnel.TTL < 0 || tunnel.TTL > 255:
+			return fmt.Errorf("tunnel %s: ttl is 0 to 255", tunnel.Name)
+		}
+		names[tunnel.Name] = true
+		if tunnel.Address != "" {
+			if ip, _, err := net.ParseCIDR(tunnel.Address); err != nil || ip.To4() == nil {
+				return fmt.Errorf("tunnel %s: address %q is not an IPv4 address with its prefix length", tunnel.Name, tunnel.Address)
+			}
+		}
+		for _, route := range tunnel.Routes {
+			if _, network, err := net.ParseCIDR(route); err != nil || network.IP.To4() == nil {
+				return fmt.Errorf("tunnel %s: invalid IPv4 route %q", tunnel.Name, route)
+			}
+		}
+	}
+	return nil
+}
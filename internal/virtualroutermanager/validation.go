@@ -0,0 +1,229 @@
+package virtualroutermanager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/tmax-cloud/virtualrouter-controller/internal/features"
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// ErrInvalidSpec is used as part of the Event 'reason' and the
+	// InvalidSpec condition reason when the spec can't be applied
+	ErrInvalidSpec = "ErrInvalidSpec"
+
+	// ROUTER_ROUTING_TABLE is the routing table, and ROUTER_ROUTING_MARK the
+	// firewall mark selecting it, the router routes external traffic with
+	ROUTER_ROUTING_TABLE int32 = 200
+	ROUTER_ROUTING_MARK  int32 = 200
+)
+
+// ValidateSpec returns why the spec can't be applied, nil if it can. The
+// daemons leave routers with an invalid spec as they are, too.
+func ValidateSpec(spec samplev1alpha1.VirtualRouterSpec) error {
+	if err := validateDualStack(spec); err != nil {
+		return err
+	}
+	if err := validatePolicyRouting(spec.PolicyRouting); err != nil {
+		return err
+	}
+	if err := validateDHCP(spec); err != nil {
+		return err
+	}
+	if err := validateDNS(spec.DNS); err != nil {
+		return err
+	}
+	if err := validatePortForwards(spec.PortForwards); err != nil {
+		return err
+	}
+	if err := validateInternalNetworks(spec); err != nil {
+		return err
+	}
+	if err := validateSidecars(spec.Sidecars); err != nil {
+		return err
+	}
+	if err := validatePersistence(spec); err != nil {
+		return err
+	}
+	if err := validateWorkloadKind(spec); err != nil {
+		return err
+	}
+	if err := validateMACAddresses(spec); err != nil {
+		return err
+	}
+	if err := validateSNATPool(spec.SNATPool); err != nil {
+		return err
+	}
+	if err := validateQoS(spec.QoS); err != nil {
+		return err
+	}
+	if err := validateFirewallHardening(spec.FirewallHardening); err != nil {
+		return err
+	}
+	if err := validateTunnels(spec.Tunnels); err != nil {
+		return err
+	}
+	if spec.WireGuard != nil && !features.Enabled(features.VPN) {
+		return fmt.Errorf("spec.wireGuard needs the %s feature gate", features.VPN)
+	}
+	if err := validateWireGuard(spec.WireGuard); err != nil {
+		return err
+	}
+	if err := validateStaticNeighbors(spec); err != nil {
+		return err
+	}
+	if err := validateMTU(spec); err != nil {
+		return err
+	}
+	if err := validateAutoscaling(spec); err != nil {
+		return err
+	}
+	if err := validateFlowExport(spec.FlowExport); err != nil {
+		return err
+	}
+	if err := validateSysctls(spec.Sysctls); err != nil {
+		return err
+	}
+	if err := validateNodeSysctls(spec.NodeSysctls); err != nil {
+		return err
+	}
+	if err := validateSRIOV(spec.ExternalSRIOV); err != nil {
+		return err
+	}
+	if err := validateFastPath(spec); err != nil {
+		return err
+	}
+	if err := validateMaintenanceWindow(spec.MaintenanceWindow); err != nil {
+		return err
+	}
+	return validateLogging(spec.Logging)
+}
+
+// HARDENING_MAX_SYN_RATE is the most packets per second, and at once, the
+// packet filters rate limit with
+const HARDENING_MAX_SYN_RATE int32 = 10000
+
+func validateFirewallHardening(hardening *samplev1alpha1.FirewallHardening) error {
+	if hardening == nil {
+		return nil
+	}
+	switch {
+	case hardening.MaxConnectionsPerSource < 0:
+		return fmt.Errorf("firewall hardening: maxConnectionsPerSource can't be negative")
+	case hardening.SYNRate < 0 || hardening.SYNRate > HARDENING_MAX_SYN_RATE:
+		return fmt.Errorf("firewall hardening: synRate is 0 to %d", HARDENING_MAX_SYN_RATE)
+	case hardening.SYNBurst < 0 || hardening.SYNBurst > HARDENING_MAX_SYN_RATE:
+		return fmt.Errorf("firewall hardening: synBurst is 0 to %d", HARDENING_MAX_SYN_RATE)
+	case hardening.SYNBurst != 0 && hardening.SYNRate == 0:
+		return fmt.Errorf("firewall hardening: synBurst needs a synRate")
+	}
+	return nil
+}
+
+func validateFlowExport(flowExport *samplev1alpha1.FlowExport) error {
+	if flowExport == nil {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(flowExport.Collector); err != nil || port == "" {
+		return fmt.Errorf("flow export: invalid collector %q, it is host:port", flowExport.Collector)
+	}
+	switch flowExport.Protocol {
+	case "", samplev1alpha1.FlowExportIPFIX, samplev1alpha1.FlowExportNetFlowV9:
+	default:
+		return fmt.Errorf("flow export: unknown protocol %q", flowExport.Protocol)
+	}
+	if flowExport.SamplingRate < 0 || flowExport.SamplingRate > 65535 {
+		return fmt.Errorf("flow export: samplingRate is 0 to 65535")
+	}
+	return nil
+}
+
+// LOGGING_MAX_RATE is the most packets per second of a rule the daemons log
+const LOGGING_MAX_RATE int32 = 10000
+
+func validateLogging(logging *samplev1alpha1.FirewallLogging) error {
+	if logging == nil {
+		return nil
+	}
+	endpoint := strings.SplitN(logging.Endpoint, "://", 2)
+	if len(endpoint) != 2 || (endpoint[0] != "udp" && endpoint[0] != "tcp") {
+		return fmt.Errorf("logging: invalid endpoint %q, it is udp://host:port or tcp://host:port", logging.Endpoint)
+	}
+	if _, port, err := net.SplitHostPort(endpoint[1]); err != nil || port == "" {
+		return fmt.Errorf("logging: invalid endpoint %q, it is udp://host:port or tcp://host:port", logging.Endpoint)
+	}
+	for _, policy := range logging.Policies {
+		switch policy {
+		case samplev1alpha1.FirewallAccept, samplev1alpha1.FirewallDrop, samplev1alpha1.FirewallReject:
+		default:
+			return fmt.Errorf("logging: unknown policy %q", policy)
+		}
+	}
+	if logging.RateLimit < 0 || logging.RateLimit > LOGGING_MAX_RATE {
+		return fmt.Errorf("logging: rateLimit is 0 to %d", LOGGING_MAX_RATE)
+	}
+	return nil
+}
+
+func validatePolicyRouting(tables []samplev1alpha1.RoutingTable) error {
+	ids := map[int32]bool{}
+	for _, table := range tables {
+		switch {
+		case table.ID < 1 || table.ID > 252:
+			return fmt.Errorf("routing table %d: ids are 1 to 252", table.ID)
+		case table.ID == ROUTER_ROUTING_TABLE:
+			return fmt.Errorf("routing table %d: used by the router itself", table.ID)
+		case ids[table.ID]:
+			return fmt.Errorf("routing table %d: given more than once", table.ID)
+		}
+		ids[table.ID] = true
+		for _, route := range table.Routes {
+			if route.Destination != "" {
+				if _, _, err := net.ParseCIDR(route.Destination); err != nil {
+					return fmt.Errorf("routing table %d: invalid destination %q", table.ID, route.Destination)
+				}
+			}
+			if net.ParseIP(route.Gateway) == nil {
+				return fmt.Errorf("routing table %d: invalid gateway %q", table.ID, route.Gateway)
+			}
+		}
+		for _, rule := range table.Rules {
+			if rule.Source == "" && rule.Mark == 0 {
+				return fmt.Errorf("routing table %d: a rule selects no traffic, it needs a source or a mark", table.ID)
+			}
+			if rule.Source != "" {
+				if _, _, err := net.ParseCIDR(rule.Source); err != nil {
+					return fmt.Errorf("routing table %d: invalid rule source %q", table.ID, rule.Source)
+				}
+			}
+			if rule.Mark == ROUTER_ROUTING_MARK {
+				return fmt.Errorf("routing table %d: mark %d is used by the router itself", table.ID, rule.Mark)
+			}
+		}
+	}
+	return nil
+}
+
+// reportInvalidSpec records why the spec of the router can't be applied in
+// its InvalidSpec condition, leaving its resources as they are. The sync
+// succeeds once the condition is written, as only a change of the spec can
+// fix it.
+func (c *Controller) reportInvalidSpec(virtualRouter *samplev1alpha1.VirtualRouter, err error) error {
+	klog.Warningf("VirtualRouter %s/%s: %v", virtualRouter.Namespace, virtualRouter.Name, err)
+	virtualRouter = virtualRouter.DeepCopy()
+	meta.SetStatusCondition(&virtualRouter.Status.Conditions, metav1.Condition{
+		Type:               samplev1alpha1.InvalidSpecCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: virtualRouter.Generation,
+		LastTransitionTime: metav1.NewTime(c.clock.Now()),
+		Reason:             ErrInvalidSpec,
+		Message:            err.Error(),
+	})
+	return c.updateVirtualRouterStatus(virtualRouter, nil)
+}
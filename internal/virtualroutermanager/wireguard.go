@@ -0,0 +1,187 @@
+package virtualroutermanager
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+)
+
+const (
+	// WIREGUARD_SECRET_NAME is the Secret of the router namespace holding the
+	// key pair of the VPN of a router
+	WIREGUARD_SECRET_NAME string = "virtualrouter-wireguard"
+	// WIREGUARD_PRIVATE_KEY and WIREGUARD_PUBLIC_KEY are the keys of the
+	// Secret, holding the keys base64 encoded as wg does
+	WIREGUARD_PRIVATE_KEY string = "privateKey"
+	WIREGUARD_PUBLIC_KEY  string = "publicKey"
+	// WIREGUARD_HANDSHAKES_ANNOTATION is where the daemons leave the
+	// WireGuardHandshakes of a router pod, for the controller to report
+	WIREGUARD_HANDSHAKES_ANNOTATION string = "network.tmaxanc.com/wireguard-handshakes"
+
+	DEFAULT_WIREGUARD_LISTEN_PORT int32 = 51820
+)
+
+// WireGuardHandshakes are the Unix times of the latest handshakes of a router
+// pod, by public key of the peer. Peers never shaken hands with are left out.
+type WireGuardHandshakes map[string]int64
+
+// WireGuardSecretName returns the Secret holding the key pair of the router.
+func WireGuardSecretName(virtualRouter *samplev1alpha1.VirtualRouter) string {
+	return routerResourceName(virtualRouter, WIREGUARD_SECRET_NAME)
+}
+
+// WireGuardListenPort returns the UDP port the VPN of the router listens on.
+func WireGuardListenPort(wireGuard *samplev1alpha1.WireGuard) int32 {
+	if wireGuard.ListenPort != 0 {
+		return wireGuard.ListenPort
+	}
+	return DEFAULT_WIREGUARD_LISTEN_PORT
+}
+
+func validateWireGuard(wireGuard *samplev1alpha1.WireGuard) error {
+	if wireGuard == nil {
+		return nil
+	}
+	if wireGuard.ListenPort < 0 || wireGuard.ListenPort > 65535 {
+		return fmt.Errorf("wireGuard: listen port is 0 to 65535")
+	}
+	if _, _, err := net.ParseCIDR(wireGuard.Address); err != nil {
+		return fmt.Errorf("wireGuard: address %q is not an address with its prefix length", wireGuard.Address)
+	}
+	keys := map[string]bool{}
+	for _, peer := range wireGuard.Peers {
+		if !validWireGuardKey(peer.PublicKey) {
+			return fmt.Errorf("wireGuard: peer public key %q is not a base64 encoded 32 byte key", peer.PublicKey)
+		}
+		if keys[peer.PublicKey] {
+			return fmt.Errorf("wireGuard: peer %s given more than once", peer.PublicKey)
+		}
+		keys[peer.PublicKey] = true
+		if peer.Endpoint != "" {
+			if _, port, err := net.SplitHostPort(peer.Endpoint); err != nil || port == "" {
+				return fmt.Errorf("wireGuard: peer %s: endpoint %q is not a host:port", peer.PublicKey, peer.Endpoint)
+			}
+		}
+		if len(peer.AllowedIPs) == 0 {
+			return fmt.Errorf("wireGuard: peer %s: no allowed IPs", peer.PublicKey)
+		}
+		for _, allowedIP := range peer.AllowedIPs {
+			if _, _, err := net.ParseCIDR(allowedIP); err != nil {
+				return fmt.Errorf("wireGuard: peer %s: invalid allowed IPs %q", peer.PublicKey, allowedIP)
+			}
+		}
+		if peer.PersistentKeepalive < 0 || peer.PersistentKeepalive > 65535 {
+			return fmt.Errorf("wireGuard: peer %s: persistent keepalive is 0 to 65535", peer.PublicKey)
+		}
+	}
+	return nil
+}
+
+func validWireGuardKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == curve25519.ScalarSize
+}
+
+// generateWireGuardKeys returns a new private key and its public key, base64
+// encoded. The private key is clamped the way wg genkey does.
+func generateWireGuardKeys() (string, string, error) {
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		return "", "", err
+	}
+	privateKey[0] &= 248
+	privateKey[31] = privateKey[31]&127 | 64
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(privateKey), base64.StdEncoding.EncodeToString(publicKey), nil
+}
+
+// ensureWireGuardKeys generates the key pair of the router in the router
+// namespace once, and returns its public key. The Secret goes with the
+// VirtualRouter, and keys put in it by hand are kept.
+func (c *Controller) ensureWireGuardKeys(newNS string, virtualRouter *samplev1alpha1.VirtualRouter) (string, error) {
+	if virtualRouter.Spec.WireGuard == nil {
+		return "", nil
+	}
+	secretName := WireGuardSecretName(virtualRouter)
+	secret, err := c.kubeclientset.CoreV1().Secrets(newNS).Get(c.ctx, secretName, metav1.GetOptions{})
+	if err == nil {
+		publicKey := string(secret.Data[WIREGUARD_PUBLIC_KEY])
+		if !validWireGuardKey(string(secret.Data[WIREGUARD_PRIVATE_KEY])) || !validWireGuardKey(publicKey) {
+			return "", fmt.Errorf("secret %s/%s holds no WireGuard key pair", newNS, secretName)
+		}
+		return publicKey, nil
+	}
+	if !errors.IsNotFound(err) {
+		klog.Error(err)
+		return "", err
+	}
+
+	privateKey, publicKey, err := generateWireGuardKeys()
+	if err != nil {
+		return "", err
+	}
+	_, err = c.kubeclientset.CoreV1().Secrets(newNS).Create(c.ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: newNS,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(virtualRouter, samplev1alpha1.SchemeGroupVersion.WithKind("VirtualRouter")),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			WIREGUARD_PRIVATE_KEY: []byte(privateKey),
+			WIREGUARD_PUBLIC_KEY:  []byte(publicKey),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	klog.Infof("WireGuard key pair of VirtualRouter %s/%s generated", virtualRouter.Namespace, virtualRouter.Name)
+	return publicKey, nil
+}
+
+// wireGuardStatus reports the latest handshakes the daemons left on the
+// active router pod, the standby ones not being reached by the peers. Every
+// peer of the spec is listed, in order of public key.
+func wireGuardStatus(virtualRouter *samplev1alpha1.VirtualRouter, pods []*corev1.Pod) *samplev1alpha1.WireGuardStatus {
+	current := virtualRouter.Status.WireGuard
+	if virtualRouter.Spec.WireGuard == nil || current == nil {
+		return current
+	}
+	handshakes := WireGuardHandshakes{}
+	if pod := activePod(pods); pod != nil {
+		if content, exist := pod.Annotations[WIREGUARD_HANDSHAKES_ANNOTATION]; exist {
+			if err := json.Unmarshal([]byte(content), &handshakes); err != nil {
+				klog.Warningf("Ignoring WireGuard handshakes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+
+	status := &samplev1alpha1.WireGuardStatus{PublicKey: current.PublicKey}
+	for _, peer := range virtualRouter.Spec.WireGuard.Peers {
+		peerStatus := samplev1alpha1.WireGuardPeerStatus{PublicKey: peer.PublicKey}
+		if handshake := handshakes[peer.PublicKey]; handshake > 0 {
+			latest := metav1.NewTime(time.Unix(handshake, 0))
+			peerStatus.LatestHandshakeTime = &latest
+		}
+		status.Peers = append(status.Peers, peerStatus)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool { return status.Peers[i].PublicKey < status.Peers[j].PublicKey })
+	return status
+}
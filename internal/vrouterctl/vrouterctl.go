@@ -0,0 +1,270 @@
+// Package vrouterctl implements the subcommands of the kubectl vrouter
+// plugin, operating VirtualRouters with the clientsets.
+package vrouterctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+
+	samplev1alpha1 "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	clientset "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+const (
+	DEFAULT_DAEMON_NAMESPACE = "virtualrouter"
+	DEFAULT_DAEMON_SELECTOR  = "app=virtualrouter-daemon"
+	// DEFAULT_DAEMON_PORT is the port of --debug-bind-address of the daemon
+	// the plugin expects
+	DEFAULT_DAEMON_PORT = "8091"
+	// DAEMON_TOKEN_HEADER carries the bearer token to the debug endpoints of
+	// the daemon, as the proxy of the API server doesn't pass the
+	// Authorization header on
+	DAEMON_TOKEN_HEADER = "X-Virtualrouter-Token"
+)
+
+// Plugin runs the subcommands against a cluster.
+type Plugin struct {
+	KubeClient          kubernetes.Interface
+	VirtualRouterClient clientset.Interface
+	Out                 io.Writer
+	// DaemonNamespace and DaemonSelector find the daemon pods, whose debug
+	// endpoints are reached at DaemonPort through the API server
+	DaemonNamespace string
+	DaemonSelector  string
+	DaemonPort      string
+	// Token authenticates the plugin to the debug endpoints of the daemon,
+	// which check that its user may get the ruleset or pcap subresource of
+	// the VirtualRouter
+	Token string
+}
+
+// routerPods returns the VirtualRouter and its router pods not being deleted.
+func (p *Plugin) routerPods(ctx context.Context, namespace, name string) (*samplev1alpha1.VirtualRouter, []*corev1.Pod, error) {
+	virtualRouter, err := p.VirtualRouterClient.TmaxV1().VirtualRouters(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	list, err := p.KubeClient.CoreV1().Pods(virtualroutermanager.RouterNamespace(virtualRouter)).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(virtualroutermanager.RouterPodLabels(virtualRouter)).String(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var pods []*corev1.Pod
+	for i := range list.Items {
+		if list.Items[i].DeletionTimestamp.IsZero() {
+			pods = append(pods, &list.Items[i])
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return virtualRouter, pods, nil
+}
+
+// routerPod returns the router pod of the name, or the active one if no
+// name is given.
+func routerPod(pods []*corev1.Pod, podName string) (*corev1.Pod, error) {
+	if podName == "" {
+		if active := virtualroutermanager.ActivePod(pods); active != nil {
+			return active, nil
+		}
+		return nil, fmt.Errorf("no router pod is ready")
+	}
+	for _, pod := range pods {
+		if pod.Name == podName {
+			return pod, nil
+		}
+	}
+	return nil, fmt.Errorf("router pod %s not found", podName)
+}
+
+// Status prints the phase, the revisions, the VIP holder, the router pods
+// and the conditions of the VirtualRouter.
+func (p *Plugin) Status(ctx context.Context, namespace, name string) error {
+	virtualRouter, pods, err := p.routerPods(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	status := virtualRouter.Status
+	w := tabwriter.NewWriter(p.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s/%s\n", virtualRouter.Namespace, virtualRouter.Name)
+	fmt.Fprintf(w, "Phase:\t%s\n", orNone(string(status.Phase)))
+	fmt.Fprintf(w, "Revision:\t%d (applied %d)\n", virtualRouter.Generation, status.AppliedRevision)
+	fmt.Fprintf(w, "External IPs:\t%s\n", orNone(strings.Join(status.ExternalIPs, ", ")))
+	vipHolder := "<none>"
+	if active := virtualroutermanager.ActivePod(pods); active != nil {
+		vipHolder = fmt.Sprintf("%s on %s", active.Name, active.Spec.NodeName)
+	}
+	fmt.Fprintf(w, "VIP holder:\t%s\n", vipHolder)
+	fmt.Fprintf(w, "Replicas:\t%d available, %d updated\n", status.AvailableReplicas, status.UpdatedReplicas)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.Out, "\nPods:")
+	w = tabwriter.NewWriter(p.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tNODE\tREADY\tSTATUS")
+	for _, pod := range pods {
+		fmt.Fprintf(w, "  %s\t%s\t%t\t%s\n", pod.Name, orNone(pod.Spec.NodeName), podutil.IsPodReady(pod), pod.Status.Phase)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.Out, "\nConditions:")
+	w = tabwriter.NewWriter(p.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tSINCE\tMESSAGE")
+	for _, condition := range status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason,
+			condition.LastTransitionTime.UTC().Format(time.RFC3339), condition.Message)
+	}
+	return w.Flush()
+}
+
+// Rules prints the live ruleset of the router pod, the active one unless
+// named, as dumped by the daemon of its node.
+func (p *Plugin) Rules(ctx context.Context, namespace, name, podName string) error {
+	virtualRouter, pods, err := p.routerPods(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	pod, err := routerPod(pods, podName)
+	if err != nil {
+		return err
+	}
+	daemon, err := p.daemonPod(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	output, err := p.daemonRequest(daemon, "/debug/ruleset",
+		map[string]string{"namespace": virtualRouter.Namespace, "virtualrouter": virtualRouter.Name}).DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("dumping the ruleset of %s with daemon %s: %v", pod.Name, daemon.Name, err)
+	}
+	_, err = p.Out.Write(output)
+	return err
+}
+
+// Failover deletes the active router pod, for a standby one to take over
+// the external address. Unless forced, a ready standby router pod must be
+// there, as the replacement of the only router pod cuts the traffic.
+func (p *Plugin) Failover(ctx context.Context, namespace, name string, force bool) error {
+	virtualRouter, pods, err := p.routerPods(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	active, err := routerPod(pods, "")
+	if err != nil {
+		return err
+	}
+	standby := false
+	for _, pod := range pods {
+		if pod.Name != active.Name && podutil.IsPodReady(pod) {
+			standby = true
+		}
+	}
+	if !standby && !force {
+		return fmt.Errorf("no ready standby router pod to take over from %s, use --force to replace it anyway", active.Name)
+	}
+	if err := p.KubeClient.CoreV1().Pods(active.Namespace).Delete(ctx, active.Name, metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.Out, "Router pod %s on node %s of VirtualRouter %s/%s deleted\n", active.Name, active.Spec.NodeName, virtualRouter.Namespace, virtualRouter.Name)
+	return nil
+}
+
+// CaptureOptions select the packets of a capture.
+type CaptureOptions struct {
+	// Pod is the router pod captured, the active one if empty
+	Pod       string
+	Interface string
+	// Filter is a BPF filter, in tcpdump syntax
+	Filter   string
+	Duration time.Duration
+}
+
+// Pcap writes the packets captured on the router pod by the daemon of its
+// node to out in pcap format, for the duration of the capture.
+func (p *Plugin) Pcap(ctx context.Context, namespace, name string, options CaptureOptions, out io.Writer) error {
+	virtualRouter, pods, err := p.routerPods(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	pod, err := routerPod(pods, options.Pod)
+	if err != nil {
+		return err
+	}
+	daemon, err := p.daemonPod(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	params := map[string]string{"namespace": virtualRouter.Namespace, "virtualrouter": virtualRouter.Name}
+	if options.Interface != "" {
+		params["interface"] = options.Interface
+	}
+	if options.Filter != "" {
+		params["filter"] = options.Filter
+	}
+	if options.Duration > 0 {
+		params["duration"] = options.Duration.String()
+	}
+	stream, err := p.daemonRequest(daemon, "/debug/pcap", params).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("capturing on %s with daemon %s: %v", pod.Name, daemon.Name, err)
+	}
+	defer stream.Close()
+	_, err = io.Copy(out, stream)
+	return err
+}
+
+// daemonRequest gets the debug endpoint of the daemon pod through the proxy
+// of the API server, with the token of the plugin.
+func (p *Plugin) daemonRequest(daemon *corev1.Pod, path string, params map[string]string) *rest.Request {
+	request := p.KubeClient.CoreV1().RESTClient().Get().Namespace(daemon.Namespace).Resource("pods").SubResource("proxy").
+		Name(utilnet.JoinSchemeNamePort("http", daemon.Name, p.DaemonPort)).Suffix(path).SetHeader(DAEMON_TOKEN_HEADER, p.Token)
+	for name, value := range params {
+		request = request.Param(name, value)
+	}
+	return request
+}
+
+// daemonPod returns the running daemon pod of the node.
+func (p *Plugin) daemonPod(ctx context.Context, nodeName string) (*corev1.Pod, error) {
+	if nodeName == "" {
+		return nil, fmt.Errorf("router pod not scheduled yet")
+	}
+	list, err := p.KubeClient.CoreV1().Pods(p.DaemonNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: p.DaemonSelector,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if list.Items[i].Status.Phase == corev1.PodRunning && list.Items[i].Spec.NodeName == nodeName {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no daemon pod running on node %s", nodeName)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+package vrouterctl
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	networkcontroller "github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/apis/networkcontroller/v1"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/utils/pkg/generated/clientset/versioned/fake"
+	"github.com/tmax-cloud/virtualrouter-controller/internal/virtualroutermanager"
+)
+
+var fakeNow = time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func newRouterPod(name string, nodeName string, ready bool, created time.Time) *corev1.Pod {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "test",
+			Labels:            map[string]string{"app": virtualroutermanager.VIRTUALROUTER_LABEL},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+		},
+	}
+}
+
+func newPlugin(out *bytes.Buffer, pods ...runtime.Object) *Plugin {
+	virtualRouter := &networkcontroller.VirtualRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, Generation: 3},
+		Status: networkcontroller.VirtualRouterStatus{
+			Phase:           networkcontroller.VirtualRouterRunning,
+			AppliedRevision: 2,
+			ExternalIPs:     []string{"192.168.9.10"},
+			Conditions: []metav1.Condition{{
+				Type: networkcontroller.DataPlaneHealthyCondition, Status: metav1.ConditionTrue,
+				Reason: "DataPlaneHealthy", LastTransitionTime: metav1.NewTime(fakeNow),
+			}},
+		},
+	}
+	return &Plugin{
+		KubeClient:          k8sfake.NewSimpleClientset(pods...),
+		VirtualRouterClient: fake.NewSimpleClientset(virtualRouter),
+		Out:                 out,
+	}
+}
+
+func TestStatus(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := newPlugin(out,
+		newRouterPod("router-b", "node-b", true, fakeNow),
+		newRouterPod("router-a", "node-a", true, fakeNow.Add(-time.Hour)))
+	if err := p.Status(context.TODO(), metav1.NamespaceDefault, "test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Phase:         Running",
+		"Revision:      3 (applied 2)",
+		"VIP holder:    router-a on node-a",
+		"router-b  node-b  true   Running",
+		"DataPlaneHealthy  True    DataPlaneHealthy  2021-10-01T00:00:00Z",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := map[string]struct {
+		pods    []runtime.Object
+		force   bool
+		deleted string
+	}{
+		"standby takes over": {
+			pods:    []runtime.Object{newRouterPod("router-a", "node-a", true, fakeNow.Add(-time.Hour)), newRouterPod("router-b", "node-b", true, fakeNow)},
+			deleted: "router-a",
+		},
+		"no ready standby": {
+			pods: []runtime.Object{newRouterPod("router-a", "node-a", true, fakeNow.Add(-time.Hour)), newRouterPod("router-b", "node-b", false, fakeNow)},
+		},
+		"forced": {
+			pods:    []runtime.Object{newRouterPod("router-a", "node-a", true, fakeNow)},
+			force:   true,
+			deleted: "router-a",
+		},
+	}
+	for name, test := range tests {
+		p := newPlugin(&bytes.Buffer{}, test.pods...)
+		err := p.Failover(context.TODO(), metav1.NamespaceDefault, "test", test.force)
+		if (err == nil) != (test.deleted != "") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		var deleted []string
+		for _, action := range p.KubeClient.(*k8sfake.Clientset).Actions() {
+			if action.GetVerb() == "delete" {
+				deleted = append(deleted, action.(interface{ GetName() string }).GetName())
+			}
+		}
+		if test.deleted == "" && len(deleted) != 0 || test.deleted != "" && (len(deleted) != 1 || deleted[0] != test.deleted) {
+			t.Errorf("%s: expected %q deleted, got %v", name, test.deleted, deleted)
+		}
+	}
+}
+
+func TestDaemonRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/virtualrouter/pods/http:daemon-a:8091/proxy/debug/ruleset" {
+			t.Errorf("expected the proxy of the daemon pod, got %s", r.URL.Path)
+		}
+		if query := r.URL.Query(); query.Get("namespace") != "default" || query.Get("virtualrouter") != "test" {
+			t.Errorf("expected the VirtualRouter default/test, got %s", r.URL.RawQuery)
+		}
+		if token := r.Header.Get(DAEMON_TOKEN_HEADER); token != "secret" {
+			t.Errorf("expected the token in %s, got %q", DAEMON_TOKEN_HEADER, token)
+		}
+		w.Write([]byte("ruleset"))
+	}))
+	defer server.Close()
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Plugin{KubeClient: kubeClient, DaemonPort: DEFAULT_DAEMON_PORT, Token: "secret"}
+	daemon := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "daemon-a", Namespace: DEFAULT_DAEMON_NAMESPACE}}
+	output, err := p.daemonRequest(daemon, "/debug/ruleset", map[string]string{"namespace": "default", "virtualrouter": "test"}).DoRaw(context.TODO())
+	if err != nil || string(output) != "ruleset" {
+		t.Errorf("expected the ruleset, got %q: %v", output, err)
+	}
+}